@@ -20,6 +20,7 @@ import (
 	"k8s.io/apimachinery/pkg/util/clock"
 	kubeinformers "k8s.io/client-go/informers"
 	"k8s.io/client-go/kubernetes"
+	corev1client "k8s.io/client-go/kubernetes/typed/core/v1"
 	"k8s.io/client-go/pkg/version"
 	"k8s.io/client-go/rest"
 	"k8s.io/component-base/logs"
@@ -29,22 +30,28 @@ import (
 	configv1alpha1 "go.pinniped.dev/generated/latest/apis/supervisor/config/v1alpha1"
 	pinnipedclientset "go.pinniped.dev/generated/latest/client/supervisor/clientset/versioned"
 	pinnipedinformers "go.pinniped.dev/generated/latest/client/supervisor/informers/externalversions"
+	"go.pinniped.dev/internal/auditlog"
 	"go.pinniped.dev/internal/config/supervisor"
 	"go.pinniped.dev/internal/controller/supervisorconfig"
+	"go.pinniped.dev/internal/controller/supervisorconfig/activedirectoryupstreamwatcher"
 	"go.pinniped.dev/internal/controller/supervisorconfig/generator"
 	"go.pinniped.dev/internal/controller/supervisorconfig/ldapupstreamwatcher"
 	"go.pinniped.dev/internal/controller/supervisorconfig/oidcupstreamwatcher"
 	"go.pinniped.dev/internal/controller/supervisorstorage"
 	"go.pinniped.dev/internal/controllerlib"
+	"go.pinniped.dev/internal/crud"
 	"go.pinniped.dev/internal/deploymentref"
 	"go.pinniped.dev/internal/downward"
 	"go.pinniped.dev/internal/groupsuffix"
 	"go.pinniped.dev/internal/kubeclient"
+	"go.pinniped.dev/internal/metrics"
+	"go.pinniped.dev/internal/oidc/clientregistry"
 	"go.pinniped.dev/internal/oidc/jwks"
 	"go.pinniped.dev/internal/oidc/provider"
 	"go.pinniped.dev/internal/oidc/provider/manager"
 	"go.pinniped.dev/internal/plog"
 	"go.pinniped.dev/internal/secret"
+	"go.pinniped.dev/internal/supervisorsessions"
 )
 
 const (
@@ -73,6 +80,67 @@ func start(ctx context.Context, l net.Listener, handler http.Handler) {
 	}()
 }
 
+// startAuditLog sends the authentication audit events to the configured sink. The returned func closes the sink.
+func startAuditLog(auditLogConfig supervisor.AuditLogSpec) (func(), error) {
+	switch auditLogConfig.Sink {
+	case supervisor.AuditLogSinkStdout:
+		auditlog.SetSink(os.Stdout)
+	case supervisor.AuditLogSinkFile:
+		f, err := os.OpenFile(auditLogConfig.FilePath, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+		if err != nil {
+			return nil, fmt.Errorf("cannot open audit log file: %w", err)
+		}
+		auditlog.SetSink(f)
+		return func() {
+			auditlog.SetSink(nil)
+			_ = f.Close()
+		}, nil
+	case supervisor.AuditLogSinkNone:
+	}
+	return func() {}, nil
+}
+
+// startStorageBackend opens the configured storage backend for the session data. The returned func closes it.
+func startStorageBackend(storageConfig supervisor.StorageSpec, secrets corev1client.SecretInterface) (crud.Backend, func(), error) {
+	switch storageConfig.Backend {
+	case supervisor.StorageBackendBolt:
+		backend, err := crud.NewBoltBackend(storageConfig.FilePath)
+		if err != nil {
+			return nil, nil, fmt.Errorf("cannot open storage backend: %w", err)
+		}
+		return backend, func() { _ = backend.Close() }, nil
+	case supervisor.StorageBackendSecrets:
+	}
+	return crud.NewSecretsBackend(secrets), func() {}, nil
+}
+
+// startSessionsServer serves the sessions of the storage backends which are not mirrored into Sessions on a loopback
+// address, for the "pinniped-supervisor sessions" command. The returned func stops serving them.
+func startSessionsServer(ctx context.Context, storageBackend crud.Backend, namespace string) (func(), error) {
+	gcBackend, ok := storageBackend.(crud.GarbageCollectingBackend)
+	if !ok {
+		return func() {}, nil
+	}
+
+	sessionsListener, err := net.Listen("tcp", supervisorsessions.ListenAddress)
+	if err != nil {
+		return nil, fmt.Errorf("cannot create listener: %w", err)
+	}
+	start(ctx, sessionsListener, supervisorsessions.NewHandler(supervisorstorage.NewBackendSessions(gcBackend), namespace))
+	plog.Debug("serving sessions", "sessionsAddress", sessionsListener.Addr().String())
+	return func() { _ = sessionsListener.Close() }, nil
+}
+
+// runSessionsCommand runs the "pinniped-supervisor sessions" command in a Supervisor pod, which lists and revokes
+// the sessions which are served by startSessionsServer.
+func runSessionsCommand(args []string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Second)
+	defer cancel()
+
+	client := supervisorsessions.NewClient(supervisorsessions.ListenAddress, &http.Client{})
+	return supervisorsessions.RunCommand(ctx, client, args, os.Stdout)
+}
+
 func waitForSignal() os.Signal {
 	signalCh := make(chan os.Signal, 1)
 	signal.Notify(signalCh, os.Interrupt)
@@ -87,7 +155,9 @@ func startControllers(
 	dynamicJWKSProvider jwks.DynamicJWKSProvider,
 	dynamicTLSCertProvider provider.DynamicTLSCertProvider,
 	dynamicUpstreamIDPProvider provider.DynamicUpstreamIDPProvider,
+	dynamicClientManager *clientregistry.DynamicClientManager,
 	secretCache *secret.Cache,
+	storageBackend crud.Backend,
 	supervisorDeployment *appsv1.Deployment,
 	kubeClient kubernetes.Interface,
 	pinnipedClient pinnipedclientset.Interface,
@@ -95,23 +165,57 @@ func startControllers(
 	pinnipedInformers pinnipedinformers.SharedInformerFactory,
 ) {
 	federationDomainInformer := pinnipedInformers.Config().V1alpha1().FederationDomains()
+	oidcClientInformer := pinnipedInformers.Config().V1alpha1().OIDCClients()
 	secretInformer := kubeInformers.Core().V1().Secrets()
 
 	// Create controller manager.
-	controllerManager := controllerlib.
-		NewManager().
-		WithController(
-			supervisorstorage.GarbageCollectorController(
-				clock.RealClock{},
-				kubeClient,
-				secretInformer,
-				controllerlib.WithInformer,
-			),
-			singletonWorker,
-		).
+	controllerManager := controllerlib.NewManager()
+
+	// The session storage Secrets are garbage collected and mirrored into Sessions by controllers which watch them.
+	// The other storage backends garbage collect their data by periodically sweeping it instead, and their sessions
+	// are served by startSessionsServer rather than mirrored into Sessions.
+	if gcBackend, ok := storageBackend.(crud.GarbageCollectingBackend); ok {
+		controllerManager = controllerManager.
+			WithController(
+				supervisorstorage.BackendGarbageCollectorController(
+					clock.RealClock{},
+					gcBackend,
+					controllerlib.WithInitialEvent,
+				),
+				singletonWorker,
+			)
+	} else {
+		controllerManager = controllerManager.
+			WithController(
+				supervisorstorage.GarbageCollectorController(
+					clock.RealClock{},
+					kubeClient,
+					secretInformer,
+					controllerlib.WithInformer,
+				),
+				singletonWorker,
+			).
+			WithController(
+				supervisorstorage.SessionController(
+					storageBackend,
+					pinnipedClient,
+					secretInformer,
+					pinnipedInformers.Config().V1alpha1().Sessions(),
+					controllerlib.WithInformer,
+				),
+				singletonWorker,
+			)
+	}
+
+	controllerManager = controllerManager.
 		WithController(
 			supervisorconfig.NewFederationDomainWatcherController(
 				issuerManager,
+				provider.TokenLifetimes{
+					AccessToken:       cfg.TokenLifetimes.MaxAccessTokenLifetime.Duration,
+					AuthorizationCode: cfg.TokenLifetimes.MaxAuthorizationCodeLifetime.Duration,
+					RefreshToken:      cfg.TokenLifetimes.MaxRefreshTokenLifetime.Duration,
+				},
 				clock.RealClock{},
 				pinnipedClient,
 				federationDomainInformer,
@@ -139,6 +243,28 @@ func startControllers(
 			),
 			singletonWorker,
 		).
+		WithController(
+			supervisorconfig.NewOIDCClientSecretWriterController(
+				cfg.Labels,
+				kubeClient,
+				pinnipedClient,
+				secretInformer,
+				oidcClientInformer,
+				controllerlib.WithInformer,
+			),
+			singletonWorker,
+		).
+		WithController(
+			supervisorconfig.NewOIDCClientWatcherController(
+				dynamicClientManager,
+				clock.RealClock{},
+				pinnipedClient,
+				secretInformer,
+				oidcClientInformer,
+				controllerlib.WithInformer,
+			),
+			singletonWorker,
+		).
 		WithController(
 			supervisorconfig.NewTLSCertObserverController(
 				dynamicTLSCertProvider,
@@ -251,6 +377,15 @@ func startControllers(
 				secretInformer,
 				controllerlib.WithInformer,
 			),
+			singletonWorker).
+		WithController(
+			activedirectoryupstreamwatcher.New(
+				dynamicUpstreamIDPProvider,
+				pinnipedClient,
+				pinnipedInformers.IDP().V1alpha1().ActiveDirectoryIdentityProviders(),
+				secretInformer,
+				controllerlib.WithInformer,
+			),
 			singletonWorker)
 
 	kubeInformers.Start(ctx.Done())
@@ -300,9 +435,31 @@ func run(podInfo *downward.PodInfo, cfg *supervisor.Config) error {
 		_, _ = writer.Write([]byte("ok"))
 	}))
 
+	closeAuditLog, err := startAuditLog(cfg.AuditLog)
+	if err != nil {
+		return err
+	}
+	defer closeAuditLog()
+
+	if err := supervisor.ValidateStorageForDeployment(&cfg.Storage, supervisorDeployment); err != nil {
+		return fmt.Errorf("invalid storage config: %w", err)
+	}
+	storageBackend, closeStorageBackend, err := startStorageBackend(cfg.Storage, client.Kubernetes.CoreV1().Secrets(serverInstallationNamespace))
+	if err != nil {
+		return err
+	}
+	defer closeStorageBackend()
+
+	stopSessionsServer, err := startSessionsServer(ctx, storageBackend, serverInstallationNamespace)
+	if err != nil {
+		return err
+	}
+	defer stopSessionsServer()
+
 	dynamicJWKSProvider := jwks.NewDynamicJWKSProvider()
 	dynamicTLSCertProvider := provider.NewDynamicTLSCertProvider()
 	dynamicUpstreamIDPProvider := provider.NewDynamicUpstreamIDPProvider()
+	dynamicClientManager := clientregistry.NewDynamicClientManager()
 	secretCache := secret.Cache{}
 
 	// OIDC endpoints will be served by the oidProvidersManager, and any non-OIDC paths will fallback to the healthMux.
@@ -310,8 +467,9 @@ func run(podInfo *downward.PodInfo, cfg *supervisor.Config) error {
 		healthMux,
 		dynamicJWKSProvider,
 		dynamicUpstreamIDPProvider,
+		dynamicClientManager,
 		&secretCache,
-		client.Kubernetes.CoreV1().Secrets(serverInstallationNamespace),
+		storageBackend,
 	)
 
 	startControllers(
@@ -321,7 +479,9 @@ func run(podInfo *downward.PodInfo, cfg *supervisor.Config) error {
 		dynamicJWKSProvider,
 		dynamicTLSCertProvider,
 		dynamicUpstreamIDPProvider,
+		dynamicClientManager,
 		&secretCache,
+		storageBackend,
 		supervisorDeployment,
 		client.Kubernetes,
 		client.PinnipedSupervisor,
@@ -360,6 +520,14 @@ func run(podInfo *downward.PodInfo, cfg *supervisor.Config) error {
 	defer func() { _ = httpsListener.Close() }()
 	start(ctx, httpsListener, oidProvidersManager)
 
+	if cfg.Metrics.ListenAddress != "" {
+		metricsListener, err := metrics.Serve(ctx, cfg.Metrics.ListenAddress)
+		if err != nil {
+			return err
+		}
+		plog.Debug("serving metrics", "metricsAddress", metricsListener.Addr().String())
+	}
+
 	plog.Debug("supervisor is ready",
 		"httpAddress", httpListener.Addr().String(),
 		"httpsAddress", httpsListener.Addr().String(),
@@ -372,6 +540,14 @@ func run(podInfo *downward.PodInfo, cfg *supervisor.Config) error {
 }
 
 func main() {
+	if len(os.Args) > 1 && os.Args[1] == "sessions" {
+		if err := runSessionsCommand(os.Args[2:]); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		return
+	}
+
 	logs.InitLogs()
 	defer logs.FlushLogs()
 	plog.RemoveKlogGlobalFlags() // move this whenever the below code gets refactored to use cobra
@@ -68,6 +68,7 @@ type getKubeconfigOIDCParams struct {
 	requestAudience   string
 	upstreamIDPName   string
 	upstreamIDPType   string
+	upstreamIDPFlow   string
 }
 
 type getKubeconfigConciergeParams struct {
@@ -153,7 +154,8 @@ func kubeconfigCommand(deps kubeconfigDeps) *cobra.Command {
 	f.BoolVar(&flags.oidc.debugSessionCache, "oidc-debug-session-cache", false, "Print debug logs related to the OpenID Connect session cache")
 	f.StringVar(&flags.oidc.requestAudience, "oidc-request-audience", "", "Request a token with an alternate audience using RFC8693 token exchange")
 	f.StringVar(&flags.oidc.upstreamIDPName, "upstream-identity-provider-name", "", "The name of the upstream identity provider used during login with a Supervisor")
-	f.StringVar(&flags.oidc.upstreamIDPType, "upstream-identity-provider-type", "", "The type of the upstream identity provider used during login with a Supervisor (e.g. 'oidc', 'ldap')")
+	f.StringVar(&flags.oidc.upstreamIDPType, "upstream-identity-provider-type", "", "The type of the upstream identity provider used during login with a Supervisor (e.g. 'oidc', 'ldap', 'activedirectory')")
+	f.StringVar(&flags.oidc.upstreamIDPFlow, "upstream-identity-provider-flow", "", "The type of client flow to use with the upstream identity provider during login with a Supervisor (e.g. 'cli_password', 'browser_authcode')")
 	f.StringVar(&flags.kubeconfigPath, "kubeconfig", os.Getenv("KUBECONFIG"), "Path to kubeconfig file")
 	f.StringVar(&flags.kubeconfigContextOverride, "kubeconfig-context", "", "Kubeconfig context name (default: current active context)")
 	f.BoolVar(&flags.skipValidate, "skip-validation", false, "Skip final validation of the kubeconfig (default: false)")
@@ -250,6 +252,10 @@ func runGetKubeconfig(ctx context.Context, out io.Writer, deps kubeconfigDeps, f
 		}
 	}
 
+	if err := validateUpstreamIDPFlow(flags); err != nil {
+		return err
+	}
+
 	execConfig, err := newExecConfig(deps, flags)
 	if err != nil {
 		return err
@@ -346,10 +352,37 @@ func newExecConfig(deps kubeconfigDeps, flags getKubeconfigParams) (*clientcmdap
 	if flags.oidc.upstreamIDPType != "" {
 		execConfig.Args = append(execConfig.Args, "--upstream-identity-provider-type="+flags.oidc.upstreamIDPType)
 	}
+	if flags.oidc.upstreamIDPFlow != "" {
+		execConfig.Args = append(execConfig.Args, "--upstream-identity-provider-flow="+flags.oidc.upstreamIDPFlow)
+	}
 
 	return execConfig, nil
 }
 
+// validateUpstreamIDPFlow rejects the --upstream-identity-provider-flow values which "pinniped login oidc" would
+// reject for the type of the upstream, so that an unusable kubeconfig is not generated.
+func validateUpstreamIDPFlow(flags getKubeconfigParams) error {
+	if flags.oidc.upstreamIDPFlow == "" {
+		return nil
+	}
+	switch flags.oidc.upstreamIDPType {
+	case "", "oidc":
+		// The login command uses the oidc type when no type is given.
+		if flags.oidc.upstreamIDPFlow != "browser_authcode" {
+			return fmt.Errorf(
+				"--upstream-identity-provider-flow value not recognized for identity provider type oidc: %s (supported values: browser_authcode)",
+				flags.oidc.upstreamIDPFlow)
+		}
+	case "ldap", "activedirectory":
+		if flags.oidc.upstreamIDPFlow != "cli_password" && flags.oidc.upstreamIDPFlow != "browser_authcode" {
+			return fmt.Errorf(
+				"--upstream-identity-provider-flow value not recognized for identity provider type %s: %s (supported values: cli_password, browser_authcode)",
+				flags.oidc.upstreamIDPType, flags.oidc.upstreamIDPFlow)
+		}
+	}
+	return nil
+}
+
 type kubeconfigNames struct{ ContextName, UserName, ClusterName string }
 
 func getCurrentContext(currentKubeConfig clientcmdapi.Config, flags getKubeconfigParams) (*kubeconfigNames, error) {
@@ -60,6 +60,7 @@ type oidcLoginFlags struct {
 	scopes                       []string
 	skipBrowser                  bool
 	skipListen                   bool
+	deviceFlow                   bool
 	sessionCachePath             string
 	caBundlePaths                []string
 	caBundleData                 []string
@@ -74,6 +75,7 @@ type oidcLoginFlags struct {
 	credentialCachePath          string
 	upstreamIdentityProviderName string
 	upstreamIdentityProviderType string
+	upstreamIdentityProviderFlow string
 }
 
 func oidcLoginCommand(deps oidcLoginCommandDeps) *cobra.Command {
@@ -93,6 +95,7 @@ func oidcLoginCommand(deps oidcLoginCommandDeps) *cobra.Command {
 	cmd.Flags().StringSliceVar(&flags.scopes, "scopes", []string{oidc.ScopeOfflineAccess, oidc.ScopeOpenID, "pinniped:request-audience"}, "OIDC scopes to request during login")
 	cmd.Flags().BoolVar(&flags.skipBrowser, "skip-browser", false, "Skip opening the browser (just print the URL)")
 	cmd.Flags().BoolVar(&flags.skipListen, "skip-listen", false, "Skip starting a localhost callback listener (manual copy/paste flow only)")
+	cmd.Flags().BoolVar(&flags.deviceFlow, "device-flow", false, "Log in from a web browser on another device using the OAuth 2.0 device authorization grant (Supervisor OIDC upstreams only)")
 	cmd.Flags().StringVar(&flags.sessionCachePath, "session-cache", filepath.Join(mustGetConfigDir(), "sessions.yaml"), "Path to session cache file")
 	cmd.Flags().StringSliceVar(&flags.caBundlePaths, "ca-bundle", nil, "Path to TLS certificate authority bundle (PEM format, optional, can be repeated)")
 	cmd.Flags().StringSliceVar(&flags.caBundleData, "ca-bundle-data", nil, "Base64 encoded TLS certificate authority bundle (base64 encoded PEM format, optional, can be repeated)")
@@ -107,7 +110,8 @@ func oidcLoginCommand(deps oidcLoginCommandDeps) *cobra.Command {
 	cmd.Flags().StringVar(&flags.conciergeAPIGroupSuffix, "concierge-api-group-suffix", groupsuffix.PinnipedDefaultSuffix, "Concierge API group suffix")
 	cmd.Flags().StringVar(&flags.credentialCachePath, "credential-cache", filepath.Join(mustGetConfigDir(), "credentials.yaml"), "Path to cluster-specific credentials cache (\"\" disables the cache)")
 	cmd.Flags().StringVar(&flags.upstreamIdentityProviderName, "upstream-identity-provider-name", "", "The name of the upstream identity provider used during login with a Supervisor")
-	cmd.Flags().StringVar(&flags.upstreamIdentityProviderType, "upstream-identity-provider-type", "oidc", "The type of the upstream identity provider used during login with a Supervisor (e.g. 'oidc', 'ldap')")
+	cmd.Flags().StringVar(&flags.upstreamIdentityProviderType, "upstream-identity-provider-type", "oidc", "The type of the upstream identity provider used during login with a Supervisor (e.g. 'oidc', 'ldap', 'activedirectory')")
+	cmd.Flags().StringVar(&flags.upstreamIdentityProviderFlow, "upstream-identity-provider-flow", "", "The type of client flow to use with the upstream identity provider during login with a Supervisor (e.g. 'cli_password', 'browser_authcode')")
 
 	// --skip-listen is mainly needed for testing. We'll leave it hidden until we have a non-testing use case.
 	mustMarkHidden(cmd, "skip-listen")
@@ -162,13 +166,31 @@ func runOIDCLogin(cmd *cobra.Command, deps oidcLoginCommandDeps, flags oidcLogin
 
 	switch flags.upstreamIdentityProviderType {
 	case "oidc":
-		// this is the default, so don't need to do anything
-	case "ldap":
-		opts = append(opts, oidcclient.WithCLISendingCredentials())
+		// this is the default, so don't need to do anything except check that the flow is the browser-based flow
+		if flags.upstreamIdentityProviderFlow != "" && flags.upstreamIdentityProviderFlow != "browser_authcode" {
+			return fmt.Errorf(
+				"--upstream-identity-provider-flow value not recognized for identity provider type %s: %s (supported values: browser_authcode)",
+				flags.upstreamIdentityProviderType, flags.upstreamIdentityProviderFlow)
+		}
+	case "ldap", "activedirectory":
+		if flags.deviceFlow {
+			return fmt.Errorf("--device-flow is not supported when --upstream-identity-provider-type is %s", flags.upstreamIdentityProviderType)
+		}
+		switch flags.upstreamIdentityProviderFlow {
+		case "", "cli_password":
+			// The CLI prompts for the username and password by default.
+			opts = append(opts, oidcclient.WithCLISendingCredentials())
+		case "browser_authcode":
+			// The end user logs in on the Supervisor's login page using the default browser-based flow.
+		default:
+			return fmt.Errorf(
+				"--upstream-identity-provider-flow value not recognized for identity provider type %s: %s (supported values: cli_password, browser_authcode)",
+				flags.upstreamIdentityProviderType, flags.upstreamIdentityProviderFlow)
+		}
 	default:
 		// Surprisingly cobra does not support this kind of flag validation. See https://github.com/spf13/pflag/issues/236
 		return fmt.Errorf(
-			"--upstream-identity-provider-type value not recognized: %s (supported values: oidc, ldap)",
+			"--upstream-identity-provider-type value not recognized: %s (supported values: oidc, ldap, activedirectory)",
 			flags.upstreamIdentityProviderType)
 	}
 
@@ -196,6 +218,11 @@ func runOIDCLogin(cmd *cobra.Command, deps oidcLoginCommandDeps, flags oidcLogin
 		opts = append(opts, oidcclient.WithSkipListen())
 	}
 
+	// --device-flow uses the device authorization grant instead of the localhost callback listener.
+	if flags.deviceFlow {
+		opts = append(opts, oidcclient.WithDeviceFlow())
+	}
+
 	if len(flags.caBundlePaths) > 0 || len(flags.caBundleData) > 0 {
 		client, err := makeClient(flags.caBundlePaths, flags.caBundleData)
 		if err != nil {
@@ -246,7 +273,7 @@ func runOIDCLogin(cmd *cobra.Command, deps oidcLoginCommandDeps, flags oidcLogin
 	// If there was a credential cache, save the resulting credential for future use.
 	if credCache != nil {
 		pLogger.Debug("caching cluster credential for future use.")
-		credCache.Put(cacheKey, cred)
+		credCache.PutForIssuer(cacheKey, flags.issuer, cred)
 	}
 	return json.NewEncoder(cmd.OutOrStdout()).Encode(cred)
 }
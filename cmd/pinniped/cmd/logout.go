@@ -0,0 +1,114 @@
+// Copyright 2021 the Pinniped contributors. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"path/filepath"
+	"sort"
+	"time"
+
+	"github.com/spf13/cobra"
+	utilerrors "k8s.io/apimachinery/pkg/util/errors"
+
+	"go.pinniped.dev/internal/execcredcache"
+	"go.pinniped.dev/pkg/oidcclient"
+	"go.pinniped.dev/pkg/oidcclient/filesession"
+)
+
+//nolint: gochecknoinits
+func init() {
+	rootCmd.AddCommand(logoutCommand(logoutCommandRealDeps()))
+}
+
+type logoutCommandDeps struct {
+	revokeRefreshToken func(issuer string, clientID string, refreshToken string, opts ...oidcclient.Option) error
+}
+
+func logoutCommandRealDeps() logoutCommandDeps {
+	return logoutCommandDeps{
+		revokeRefreshToken: oidcclient.RevokeRefreshToken,
+	}
+}
+
+type logoutFlags struct {
+	issuer              string
+	sessionCachePath    string
+	credentialCachePath string
+	caBundlePaths       []string
+	caBundleData        []string
+}
+
+func logoutCommand(deps logoutCommandDeps) *cobra.Command {
+	var (
+		cmd = &cobra.Command{
+			Args:         cobra.NoArgs,
+			Use:          "logout --issuer ISSUER",
+			Short:        "Log out of an OpenID Connect issuer, such as a Pinniped Supervisor",
+			Long:         "Log out of an OpenID Connect issuer, such as a Pinniped Supervisor, by revoking the refresh tokens of the cached sessions of the issuer, and by removing those sessions and the cluster credentials which were obtained using them from the local caches.",
+			SilenceUsage: true,
+		}
+		flags logoutFlags
+	)
+	cmd.Flags().StringVar(&flags.issuer, "issuer", "", "OpenID Connect issuer URL")
+	cmd.Flags().StringVar(&flags.sessionCachePath, "session-cache", filepath.Join(mustGetConfigDir(), "sessions.yaml"), "Path to session cache file")
+	cmd.Flags().StringVar(&flags.credentialCachePath, "credential-cache", filepath.Join(mustGetConfigDir(), "credentials.yaml"), "Path to cluster-specific credentials cache (\"\" disables the cache)")
+	cmd.Flags().StringSliceVar(&flags.caBundlePaths, "ca-bundle", nil, "Path to TLS certificate authority bundle (PEM format, optional, can be repeated)")
+	cmd.Flags().StringSliceVar(&flags.caBundleData, "ca-bundle-data", nil, "Base64 encoded TLS certificate authority bundle (base64 encoded PEM format, optional, can be repeated)")
+	mustMarkRequired(cmd, "issuer")
+	cmd.RunE = func(cmd *cobra.Command, args []string) error { return runLogout(cmd, deps, flags) }
+	return cmd
+}
+
+func runLogout(cmd *cobra.Command, deps logoutCommandDeps, flags logoutFlags) error {
+	ctx, cancel := context.WithTimeout(cmd.Context(), 2*time.Minute)
+	defer cancel()
+
+	opts := []oidcclient.Option{oidcclient.WithContext(ctx)}
+	if len(flags.caBundlePaths) > 0 || len(flags.caBundleData) > 0 {
+		client, err := makeClient(flags.caBundlePaths, flags.caBundleData)
+		if err != nil {
+			return err
+		}
+		opts = append(opts, oidcclient.WithClient(client))
+	}
+
+	// Remove the cluster credentials first, since they were obtained using the sessions and would otherwise
+	// continue to work until they expire.
+	deletedCredentials := 0
+	if flags.credentialCachePath != "" {
+		deletedCredentials = execcredcache.New(flags.credentialCachePath).DeleteForIssuer(flags.issuer)
+	}
+
+	sessionsByClientID := filesession.New(flags.sessionCachePath).DeleteTokens(flags.issuer)
+	clientIDs := make([]string, 0, len(sessionsByClientID))
+	for clientID := range sessionsByClientID {
+		clientIDs = append(clientIDs, clientID)
+	}
+	sort.Strings(clientIDs)
+
+	deletedSessions, revokedTokens := 0, 0
+	var errs []error
+	for _, clientID := range clientIDs {
+		for _, token := range sessionsByClientID[clientID] {
+			deletedSessions++
+			if token.RefreshToken == nil {
+				continue
+			}
+			if err := deps.revokeRefreshToken(flags.issuer, clientID, token.RefreshToken.Token, opts...); err != nil {
+				errs = append(errs, err)
+				continue
+			}
+			revokedTokens++
+		}
+	}
+
+	fmt.Fprintf(cmd.OutOrStdout(), "Removed %d cached session(s) and %d cached cluster credential(s) of %s, and revoked %d refresh token(s).\n",
+		deletedSessions, deletedCredentials, flags.issuer, revokedTokens)
+	if len(errs) > 0 {
+		return fmt.Errorf("could not revoke all refresh tokens: %w", utilerrors.NewAggregate(errs))
+	}
+	return nil
+}
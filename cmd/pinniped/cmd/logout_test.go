@@ -0,0 +1,178 @@
+// Copyright 2021 the Pinniped contributors. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package cmd
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	clientauthv1beta1 "k8s.io/client-go/pkg/apis/clientauthentication/v1beta1"
+
+	"go.pinniped.dev/internal/certauthority"
+	"go.pinniped.dev/internal/execcredcache"
+	"go.pinniped.dev/internal/here"
+	"go.pinniped.dev/internal/testutil"
+	"go.pinniped.dev/pkg/oidcclient"
+	"go.pinniped.dev/pkg/oidcclient/filesession"
+	"go.pinniped.dev/pkg/oidcclient/oidctypes"
+)
+
+func TestLogoutCommand(t *testing.T) {
+	cfgDir := mustGetConfigDir()
+
+	testCA, err := certauthority.New("Test CA", 1*time.Hour)
+	require.NoError(t, err)
+	tmpdir := testutil.TempDir(t)
+	testCABundlePath := filepath.Join(tmpdir, "testca.pem")
+	require.NoError(t, ioutil.WriteFile(testCABundlePath, testCA.Bundle(), 0600))
+
+	tests := []struct {
+		name             string
+		args             []string
+		revokeErr        error
+		wantError        bool
+		wantStdout       string
+		wantStderr       string
+		wantRevoked      []string
+		wantOptionsCount int
+		wantCached       bool
+	}{
+		{
+			name: "help flag passed",
+			args: []string{"--help"},
+			wantStdout: here.Doc(`
+				Log out of an OpenID Connect issuer, such as a Pinniped Supervisor, by revoking the refresh tokens of the cached sessions of the issuer, and by removing those sessions and the cluster credentials which were obtained using them from the local caches.
+
+				Usage:
+				  logout --issuer ISSUER [flags]
+
+				Flags:
+				      --ca-bundle strings         Path to TLS certificate authority bundle (PEM format, optional, can be repeated)
+				      --ca-bundle-data strings    Base64 encoded TLS certificate authority bundle (base64 encoded PEM format, optional, can be repeated)
+				      --credential-cache string   Path to cluster-specific credentials cache ("" disables the cache) (default "` + cfgDir + `/credentials.yaml")
+				  -h, --help                      help for logout
+				      --issuer string             OpenID Connect issuer URL
+				      --session-cache string      Path to session cache file (default "` + cfgDir + `/sessions.yaml")
+			`),
+			wantCached: true,
+		},
+		{
+			name:      "missing required flags",
+			args:      []string{},
+			wantError: true,
+			wantStderr: here.Doc(`
+				Error: required flag(s) "issuer" not set
+			`),
+			wantCached: true,
+		},
+		{
+			name:      "invalid CA bundle path",
+			args:      []string{"--issuer", "test-issuer", "--ca-bundle", "./does/not/exist"},
+			wantError: true,
+			wantStderr: here.Doc(`
+				Error: could not read --ca-bundle: open ./does/not/exist: no such file or directory
+			`),
+			wantCached: true,
+		},
+		{
+			name:             "success",
+			args:             []string{"--issuer", "test-issuer"},
+			wantRevoked:      []string{"other-client-id/refresh-token-3", "pinniped-cli/refresh-token-1"},
+			wantOptionsCount: 1,
+			wantStdout:       "Removed 3 cached session(s) and 1 cached cluster credential(s) of test-issuer, and revoked 2 refresh token(s).\n",
+		},
+		{
+			name:             "success with CA bundle",
+			args:             []string{"--issuer", "test-issuer", "--ca-bundle", testCABundlePath},
+			wantRevoked:      []string{"other-client-id/refresh-token-3", "pinniped-cli/refresh-token-1"},
+			wantOptionsCount: 2,
+			wantStdout:       "Removed 3 cached session(s) and 1 cached cluster credential(s) of test-issuer, and revoked 2 refresh token(s).\n",
+		},
+		{
+			name:             "issuer without cached sessions",
+			args:             []string{"--issuer", "other-issuer"},
+			wantOptionsCount: 1,
+			wantStdout:       "Removed 0 cached session(s) and 0 cached cluster credential(s) of other-issuer, and revoked 0 refresh token(s).\n",
+			wantCached:       true,
+		},
+		{
+			name:             "revocation fails",
+			args:             []string{"--issuer", "test-issuer"},
+			revokeErr:        fmt.Errorf("some revocation error"),
+			wantOptionsCount: 1,
+			wantError:        true,
+			wantStdout:       "Removed 3 cached session(s) and 1 cached cluster credential(s) of test-issuer, and revoked 0 refresh token(s).\n",
+			wantStderr:       "Error: could not revoke all refresh tokens: some revocation error\n",
+		},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			cacheDir := testutil.TempDir(t)
+			sessionCachePath := filepath.Join(cacheDir, "sessions.yaml")
+			credentialCachePath := filepath.Join(cacheDir, "credentials.yaml")
+
+			expiry := metav1.NewTime(time.Now().Add(time.Hour))
+			sessionCache := filesession.New(sessionCachePath)
+			for _, s := range []struct{ clientID, refreshToken, redirectURI string }{
+				{clientID: "pinniped-cli", refreshToken: "refresh-token-1", redirectURI: "http://localhost:0/callback"},
+				{clientID: "pinniped-cli", redirectURI: "http://localhost:1/callback"},
+				{clientID: "other-client-id", refreshToken: "refresh-token-3", redirectURI: "http://localhost:0/callback"},
+			} {
+				token := &oidctypes.Token{IDToken: &oidctypes.IDToken{Token: "some-id-token", Expiry: expiry}}
+				if s.refreshToken != "" {
+					token.RefreshToken = &oidctypes.RefreshToken{Token: s.refreshToken}
+				}
+				sessionCache.PutToken(oidcclient.SessionCacheKey{Issuer: "test-issuer", ClientID: s.clientID, RedirectURI: s.redirectURI}, token)
+			}
+			cred := &clientauthv1beta1.ExecCredential{Status: &clientauthv1beta1.ExecCredentialStatus{Token: "some-token", ExpirationTimestamp: &expiry}}
+			credCache := execcredcache.New(credentialCachePath)
+			credCache.PutForIssuer("some-key", "test-issuer", cred)
+
+			var revoked []string
+			cmd := logoutCommand(logoutCommandDeps{
+				revokeRefreshToken: func(issuer string, clientID string, refreshToken string, opts ...oidcclient.Option) error {
+					require.Equal(t, "test-issuer", issuer)
+					require.Len(t, opts, tt.wantOptionsCount)
+					if tt.revokeErr != nil {
+						return tt.revokeErr
+					}
+					revoked = append(revoked, clientID+"/"+refreshToken)
+					return nil
+				},
+			})
+			require.NotNil(t, cmd)
+
+			var stdout, stderr bytes.Buffer
+			cmd.SetOut(&stdout)
+			cmd.SetErr(&stderr)
+			cmd.SetArgs(append(tt.args, "--session-cache", sessionCachePath, "--credential-cache", credentialCachePath))
+			err := cmd.Execute()
+			if tt.wantError {
+				require.Error(t, err)
+			} else {
+				require.NoError(t, err)
+			}
+			require.Equal(t, tt.wantStdout, stdout.String(), "unexpected stdout")
+			require.Equal(t, tt.wantStderr, stderr.String(), "unexpected stderr")
+			require.Equal(t, tt.wantRevoked, revoked)
+
+			cachedToken := sessionCache.GetToken(oidcclient.SessionCacheKey{Issuer: "test-issuer", ClientID: "pinniped-cli", RedirectURI: "http://localhost:0/callback"})
+			cachedCred := credCache.Get("some-key")
+			if tt.wantCached {
+				require.NotNil(t, cachedToken)
+				require.NotNil(t, cachedCred)
+			} else {
+				require.Nil(t, cachedToken)
+				require.Nil(t, cachedCred)
+			}
+		})
+	}
+}
@@ -0,0 +1,196 @@
+// Copyright 2021 the Pinniped contributors. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package cmd
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"text/tabwriter"
+	"time"
+
+	"github.com/spf13/cobra"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+
+	configv1alpha1 "go.pinniped.dev/generated/latest/apis/supervisor/config/v1alpha1"
+	supervisorclientset "go.pinniped.dev/generated/latest/client/supervisor/clientset/versioned"
+	"go.pinniped.dev/internal/groupsuffix"
+)
+
+//nolint: gochecknoinits
+func init() {
+	supervisorCmd.AddCommand(newSupervisorSessionsCommand(getRealSupervisorClientset))
+}
+
+// supervisorSessionsBackendNote explains how to manage the sessions which are not mirrored into Sessions.
+const supervisorSessionsBackendNote = "The Supervisor only creates Sessions when it stores its session data in Secrets. " +
+	"When it uses another storage backend, run \"pinniped-supervisor sessions\" in a Supervisor pod instead, " +
+	"e.g. \"kubectl exec -n pinniped-supervisor deploy/pinniped-supervisor -- pinniped-supervisor sessions list\"."
+
+type supervisorSessionsFlags struct {
+	username string
+	subject  string
+
+	namespace string
+
+	kubeconfigPath            string
+	kubeconfigContextOverride string
+
+	apiGroupSuffix string
+}
+
+func newSupervisorSessionsCommand(getClientset getSupervisorClientsetFunc) *cobra.Command {
+	cmd := &cobra.Command{
+		Args:         cobra.NoArgs, // do not accept positional arguments for this command
+		Use:          "sessions",
+		Short:        "Manage the sessions of the users who logged in using a Supervisor",
+		SilenceUsage: true,
+	}
+	cmd.AddCommand(newSupervisorSessionsListCommand(getClientset))
+	cmd.AddCommand(newSupervisorSessionsRevokeCommand(getClientset))
+	return cmd
+}
+
+func newSupervisorSessionsListCommand(getClientset getSupervisorClientsetFunc) *cobra.Command {
+	cmd := &cobra.Command{
+		Args:         cobra.NoArgs, // do not accept positional arguments for this command
+		Use:          "list",
+		Short:        "List the active sessions, optionally only those of a user",
+		Long:         "List the active sessions, optionally only those of a user, from the Sessions of the Supervisor. " + supervisorSessionsBackendNote,
+		SilenceUsage: true,
+	}
+	flags := &supervisorSessionsFlags{}
+	addSupervisorSessionsFlags(cmd, flags)
+
+	cmd.RunE = func(cmd *cobra.Command, _ []string) error {
+		return runSupervisorSessionsList(cmd.OutOrStdout(), getClientset, flags)
+	}
+
+	return cmd
+}
+
+func newSupervisorSessionsRevokeCommand(getClientset getSupervisorClientsetFunc) *cobra.Command {
+	cmd := &cobra.Command{
+		Use:          "revoke [session-name]...",
+		Short:        "Revoke sessions by name, or all sessions of a user",
+		Long:         "Revoke sessions by name, or all sessions of a user, by marking the Sessions of the Supervisor as revoked. " + supervisorSessionsBackendNote,
+		SilenceUsage: true,
+	}
+	flags := &supervisorSessionsFlags{}
+	addSupervisorSessionsFlags(cmd, flags)
+
+	cmd.RunE = func(cmd *cobra.Command, args []string) error {
+		return runSupervisorSessionsRevoke(cmd.OutOrStdout(), getClientset, flags, args)
+	}
+
+	return cmd
+}
+
+func addSupervisorSessionsFlags(cmd *cobra.Command, flags *supervisorSessionsFlags) {
+	f := cmd.Flags()
+	f.StringVar(&flags.username, "username", "", "Only include the sessions of the user with this downstream username")
+	f.StringVar(&flags.subject, "subject", "", "Only include the sessions of the user with this downstream subject")
+	f.StringVarP(&flags.namespace, "namespace", "n", "pinniped-supervisor", "Namespace in which the Supervisor is installed")
+	f.StringVar(&flags.kubeconfigPath, "kubeconfig", os.Getenv("KUBECONFIG"), "Path to kubeconfig file")
+	f.StringVar(&flags.kubeconfigContextOverride, "kubeconfig-context", "", "Kubeconfig context name (default: current active context)")
+	f.StringVar(&flags.apiGroupSuffix, "api-group-suffix", groupsuffix.PinnipedDefaultSuffix, "Supervisor API group suffix")
+}
+
+// listSupervisorSessions lists the Sessions of the Supervisor, keeping only those which match the username and
+// subject flags, if they were specified.
+func listSupervisorSessions(ctx context.Context, clientset supervisorclientset.Interface, flags *supervisorSessionsFlags) ([]configv1alpha1.Session, error) {
+	sessionList, err := clientset.ConfigV1alpha1().Sessions(flags.namespace).List(ctx, metav1.ListOptions{})
+	if err != nil {
+		return nil, fmt.Errorf("could not list Sessions: %w", err)
+	}
+
+	sessions := make([]configv1alpha1.Session, 0, len(sessionList.Items))
+	for _, session := range sessionList.Items {
+		if flags.username != "" && session.Status.Username != flags.username {
+			continue
+		}
+		if flags.subject != "" && session.Status.Subject != flags.subject {
+			continue
+		}
+		sessions = append(sessions, session)
+	}
+	sort.Slice(sessions, func(i, j int) bool { return sessions[i].Name < sessions[j].Name })
+	return sessions, nil
+}
+
+func runSupervisorSessionsList(output io.Writer, getClientset getSupervisorClientsetFunc, flags *supervisorSessionsFlags) error {
+	clientset, err := getClientset(newClientConfig(flags.kubeconfigPath, flags.kubeconfigContextOverride), flags.apiGroupSuffix)
+	if err != nil {
+		return fmt.Errorf("could not configure Kubernetes client: %w", err)
+	}
+
+	ctx, cancelFunc := context.WithTimeout(context.Background(), time.Second*20)
+	defer cancelFunc()
+
+	sessions, err := listSupervisorSessions(ctx, clientset, flags)
+	if err != nil {
+		return err
+	}
+
+	w := tabwriter.NewWriter(output, 0, 8, 3, ' ', 0)
+	fmt.Fprintln(w, "NAME\tUSERNAME\tSUBJECT\tCLIENT\tIDENTITY PROVIDER\tEXPIRES")
+	for _, session := range sessions {
+		expires := ""
+		if session.Status.ExpiresAt != nil {
+			expires = session.Status.ExpiresAt.UTC().Format(time.RFC3339)
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%s\n",
+			session.Name,
+			session.Status.Username,
+			session.Status.Subject,
+			session.Status.ClientID,
+			session.Status.UpstreamIdentityProviderName,
+			expires,
+		)
+	}
+	return w.Flush()
+}
+
+func runSupervisorSessionsRevoke(output io.Writer, getClientset getSupervisorClientsetFunc, flags *supervisorSessionsFlags, names []string) error {
+	if len(names) == 0 && flags.username == "" && flags.subject == "" {
+		return errors.New("at least one session name, or --username or --subject must be specified")
+	}
+
+	clientset, err := getClientset(newClientConfig(flags.kubeconfigPath, flags.kubeconfigContextOverride), flags.apiGroupSuffix)
+	if err != nil {
+		return fmt.Errorf("could not configure Kubernetes client: %w", err)
+	}
+
+	ctx, cancelFunc := context.WithTimeout(context.Background(), time.Second*20)
+	defer cancelFunc()
+
+	if len(names) == 0 {
+		sessions, err := listSupervisorSessions(ctx, clientset, flags)
+		if err != nil {
+			return err
+		}
+		if len(sessions) == 0 {
+			fmt.Fprintln(output, "no matching sessions found")
+			return nil
+		}
+		for _, session := range sessions {
+			names = append(names, session.Name)
+		}
+	}
+
+	// The Supervisor deletes the stored data of a session and then its Session once it is marked as revoked.
+	patch := []byte(`{"spec":{"revoked":true}}`)
+	for _, name := range names {
+		_, err := clientset.ConfigV1alpha1().Sessions(flags.namespace).Patch(ctx, name, types.MergePatchType, patch, metav1.PatchOptions{})
+		if err != nil {
+			return fmt.Errorf("could not revoke Session %s: %w", name, err)
+		}
+		fmt.Fprintf(output, "session %s revoked\n", name)
+	}
+	return nil
+}
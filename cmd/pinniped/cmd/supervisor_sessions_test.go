@@ -0,0 +1,217 @@
+// Copyright 2021 the Pinniped contributors. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package cmd
+
+import (
+	"bytes"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	kubetesting "k8s.io/client-go/testing"
+	"k8s.io/client-go/tools/clientcmd"
+
+	configv1alpha1 "go.pinniped.dev/generated/latest/apis/supervisor/config/v1alpha1"
+	supervisorclientset "go.pinniped.dev/generated/latest/client/supervisor/clientset/versioned"
+	fakesupervisorclientset "go.pinniped.dev/generated/latest/client/supervisor/clientset/versioned/fake"
+	"go.pinniped.dev/internal/constable"
+	"go.pinniped.dev/internal/here"
+)
+
+func TestSupervisorSessions(t *testing.T) {
+	expiresAt := metav1.NewTime(time.Date(2021, 1, 2, 3, 4, 5, 0, time.UTC))
+	newSession := func(namespace, name, username string) *configv1alpha1.Session {
+		return &configv1alpha1.Session{
+			ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: namespace},
+			Status: configv1alpha1.SessionStatus{
+				Username:                     username,
+				Subject:                      "https://upstream.example.com?sub=" + username,
+				ClientID:                     "pinniped-cli",
+				UpstreamIdentityProviderName: "some-upstream",
+				ExpiresAt:                    &expiresAt,
+			},
+		}
+	}
+
+	tests := []struct {
+		name                string
+		args                []string
+		gettingClientsetErr error
+		callingAPIErr       error
+		wantError           bool
+		wantRevoked         []string
+		wantStdout          string
+		wantStderr          string
+	}{
+		{
+			name: "list help flag",
+			args: []string{"list", "--help"},
+			wantStdout: here.Doc(`
+				List the active sessions, optionally only those of a user, from the Sessions of the Supervisor. The Supervisor only creates Sessions when it stores its session data in Secrets. When it uses another storage backend, run "pinniped-supervisor sessions" in a Supervisor pod instead, e.g. "kubectl exec -n pinniped-supervisor deploy/pinniped-supervisor -- pinniped-supervisor sessions list".
+
+				Usage:
+				  sessions list [flags]
+
+				Flags:
+				      --api-group-suffix string     Supervisor API group suffix (default "pinniped.dev")
+				  -h, --help                        help for list
+				      --kubeconfig string           Path to kubeconfig file
+				      --kubeconfig-context string   Kubeconfig context name (default: current active context)
+				  -n, --namespace string            Namespace in which the Supervisor is installed (default "pinniped-supervisor")
+				      --subject string              Only include the sessions of the user with this downstream subject
+				      --username string             Only include the sessions of the user with this downstream username
+			`),
+		},
+		{
+			name: "list all sessions",
+			args: []string{"list"},
+			wantStdout: here.Doc(`
+				NAME        USERNAME   SUBJECT                                   CLIENT         IDENTITY PROVIDER   EXPIRES
+				session-1   user-1     https://upstream.example.com?sub=user-1   pinniped-cli   some-upstream       2021-01-02T03:04:05Z
+				session-2   user-2     https://upstream.example.com?sub=user-2   pinniped-cli   some-upstream       2021-01-02T03:04:05Z
+				session-3   user-1     https://upstream.example.com?sub=user-1   pinniped-cli   some-upstream       2021-01-02T03:04:05Z
+			`),
+		},
+		{
+			name: "list sessions by username",
+			args: []string{"list", "--username", "user-1"},
+			wantStdout: here.Doc(`
+				NAME        USERNAME   SUBJECT                                   CLIENT         IDENTITY PROVIDER   EXPIRES
+				session-1   user-1     https://upstream.example.com?sub=user-1   pinniped-cli   some-upstream       2021-01-02T03:04:05Z
+				session-3   user-1     https://upstream.example.com?sub=user-1   pinniped-cli   some-upstream       2021-01-02T03:04:05Z
+			`),
+		},
+		{
+			name: "list sessions by subject in another namespace",
+			args: []string{"list", "--subject", "https://upstream.example.com?sub=user-1", "-n", "other-namespace"},
+			wantStdout: here.Doc(`
+				NAME        USERNAME   SUBJECT                                   CLIENT         IDENTITY PROVIDER   EXPIRES
+				session-4   user-1     https://upstream.example.com?sub=user-1   pinniped-cli   some-upstream       2021-01-02T03:04:05Z
+			`),
+		},
+		{
+			name:       "list extra args",
+			args:       []string{"list", "extra-arg"},
+			wantError:  true,
+			wantStderr: "Error: unknown command \"extra-arg\" for \"sessions list\"\n",
+		},
+		{
+			name:                "list getting clientset fails",
+			args:                []string{"list"},
+			gettingClientsetErr: constable.Error("some get clientset error"),
+			wantError:           true,
+			wantStderr:          "Error: could not configure Kubernetes client: some get clientset error\n",
+		},
+		{
+			name:          "list calling API fails",
+			args:          []string{"list"},
+			callingAPIErr: constable.Error("some API error"),
+			wantError:     true,
+			wantStderr:    "Error: could not list Sessions: some API error\n",
+		},
+		{
+			name:        "revoke sessions by name",
+			args:        []string{"revoke", "session-1", "session-2"},
+			wantRevoked: []string{"session-1", "session-2"},
+			wantStdout: here.Doc(`
+				session session-1 revoked
+				session session-2 revoked
+			`),
+		},
+		{
+			name:        "revoke sessions by username",
+			args:        []string{"revoke", "--username", "user-1"},
+			wantRevoked: []string{"session-1", "session-3"},
+			wantStdout: here.Doc(`
+				session session-1 revoked
+				session session-3 revoked
+			`),
+		},
+		{
+			name:       "revoke sessions by username without any sessions",
+			args:       []string{"revoke", "--username", "user-5"},
+			wantStdout: "no matching sessions found\n",
+		},
+		{
+			name:       "revoke session which does not exist",
+			args:       []string{"revoke", "session-5"},
+			wantError:  true,
+			wantStderr: "Error: could not revoke Session session-5: sessions.config.supervisor.pinniped.dev \"session-5\" not found\n",
+		},
+		{
+			name:       "revoke without sessions",
+			args:       []string{"revoke"},
+			wantError:  true,
+			wantStderr: "Error: at least one session name, or --username or --subject must be specified\n",
+		},
+		{
+			name:                "revoke getting clientset fails",
+			args:                []string{"revoke", "session-1"},
+			gettingClientsetErr: constable.Error("some get clientset error"),
+			wantError:           true,
+			wantStderr:          "Error: could not configure Kubernetes client: some get clientset error\n",
+		},
+		{
+			name:          "revoke calling API fails",
+			args:          []string{"revoke", "--username", "user-1"},
+			callingAPIErr: constable.Error("some API error"),
+			wantError:     true,
+			wantStderr:    "Error: could not list Sessions: some API error\n",
+		},
+	}
+	for _, test := range tests {
+		test := test
+		t.Run(test.name, func(t *testing.T) {
+			clientset := fakesupervisorclientset.NewSimpleClientset(
+				newSession("pinniped-supervisor", "session-3", "user-1"),
+				newSession("pinniped-supervisor", "session-1", "user-1"),
+				newSession("pinniped-supervisor", "session-2", "user-2"),
+				newSession("other-namespace", "session-4", "user-1"),
+			)
+			clientset.PrependReactor("list", "sessions", func(_ kubetesting.Action) (bool, runtime.Object, error) {
+				if test.callingAPIErr != nil {
+					return true, nil, test.callingAPIErr
+				}
+				return false, nil, nil
+			})
+			getClientset := func(clientConfig clientcmd.ClientConfig, apiGroupSuffix string) (supervisorclientset.Interface, error) {
+				if test.gettingClientsetErr != nil {
+					return nil, test.gettingClientsetErr
+				}
+				return clientset, nil
+			}
+			cmd := newSupervisorSessionsCommand(getClientset)
+
+			stdout, stderr := bytes.NewBuffer([]byte{}), bytes.NewBuffer([]byte{})
+			cmd.SetOut(stdout)
+			cmd.SetErr(stderr)
+			cmd.SetArgs(test.args)
+
+			err := cmd.Execute()
+			if test.wantError {
+				require.Error(t, err)
+			} else {
+				require.NoError(t, err)
+			}
+			require.Equal(t, test.wantStdout, stdout.String())
+			require.Equal(t, test.wantStderr, stderr.String())
+
+			sessions, err := clientset.Tracker().List(
+				configv1alpha1.SchemeGroupVersion.WithResource("sessions"),
+				configv1alpha1.SchemeGroupVersion.WithKind("Session"),
+				"pinniped-supervisor",
+			)
+			require.NoError(t, err)
+			var revoked []string
+			for _, session := range sessions.(*configv1alpha1.SessionList).Items {
+				if session.Spec.Revoked {
+					revoked = append(revoked, session.Name)
+				}
+			}
+			require.Equal(t, test.wantRevoked, revoked)
+		})
+	}
+}
@@ -20,6 +20,32 @@ const (
 	PhaseError OIDCIdentityProviderPhase = "Error"
 )
 
+type OIDCUserInfoMode string
+
+const (
+	// OIDCUserInfoModeIfAvailable merges the userinfo claims when the provider advertises a userinfo endpoint.
+	OIDCUserInfoModeIfAvailable OIDCUserInfoMode = "IfAvailable"
+
+	// OIDCUserInfoModeRequired merges the userinfo claims and requires the provider to advertise a userinfo endpoint.
+	OIDCUserInfoModeRequired OIDCUserInfoMode = "Required"
+
+	// OIDCUserInfoModeDisabled never calls the userinfo endpoint.
+	OIDCUserInfoModeDisabled OIDCUserInfoMode = "Disabled"
+)
+
+type OIDCClientAuthMethod string
+
+const (
+	// OIDCClientAuthMethodClientSecret authenticates the client using its client secret.
+	OIDCClientAuthMethodClientSecret OIDCClientAuthMethod = "ClientSecret"
+
+	// OIDCClientAuthMethodPrivateKeyJWT authenticates the client using a JWT which is signed by its private key.
+	OIDCClientAuthMethodPrivateKeyJWT OIDCClientAuthMethod = "PrivateKeyJWT"
+
+	// OIDCClientAuthMethodTLSClientAuth authenticates the client using mutual TLS with its client certificate.
+	OIDCClientAuthMethodTLSClientAuth OIDCClientAuthMethod = "TLSClientAuth"
+)
+
 // Status of an OIDC identity provider.
 type OIDCIdentityProviderStatus struct {
 	// Phase summarizes the overall status of the OIDCIdentityProvider.
@@ -39,18 +65,78 @@ type OIDCIdentityProviderStatus struct {
 // request parameters.
 type OIDCAuthorizationConfig struct {
 	// AdditionalScopes are the scopes in addition to "openid" that will be requested as part of the authorization
-	// request flow with an OIDC identity provider. By default only the "openid" scope will be requested.
+	// request flow with an OIDC identity provider. By default only the "openid" scope will be requested. The Supervisor
+	// needs a refresh token from the provider to refresh downstream sessions, and most providers only return one when
+	// the "offline_access" scope is requested, so it should usually be included. Otherwise each downstream refresh fails
+	// and the user must log in again. The OfflineAccessRequested condition is Unknown when it is not included.
 	// +optional
 	AdditionalScopes []string `json:"additionalScopes,omitempty"`
+
+	// AdditionalAuthorizeParameters are extra query parameters that should be included in the authorization
+	// request to the OIDC identity provider, e.g. {"name": "hd", "value": "example.com"} for Google, {"name":
+	// "domain_hint", "value": "example.com"} for Azure AD, or {"name": "prompt", "value": "select_account"}. By
+	// default, no extra parameters are sent. The parameters which are always set by the Supervisor, i.e.
+	// "response_type", "scope", "client_id", "state", "nonce", "code_challenge", "code_challenge_method", and
+	// "redirect_uri", are not allowed, and the OIDCIdentityProvider will have a failing
+	// AdditionalAuthorizeParametersValid condition when any of them are specified. The "access_type" parameter,
+	// which the Supervisor sends as "offline" by default, may be overridden. When the client which is logging in
+	// sends its own "prompt" parameter, it is used instead of any "prompt" parameter which is specified here.
+	// +optional
+	AdditionalAuthorizeParameters []Parameter `json:"additionalAuthorizeParameters,omitempty"`
+
+	// EndUpstreamSessionOnLogout, when true, causes the end_session_endpoint of the Supervisor to redirect the end
+	// user's browser to the end_session_endpoint of the OIDC identity provider after ending the Supervisor session of an
+	// end user who logged in using this OIDC identity provider, so the end user is also logged out of the OIDC identity
+	// provider. The Supervisor sends its client ID and the ID token which the OIDC identity provider issued for the
+	// session, and asks to be redirected back to the issuer of the FederationDomain appended with "/logout/callback", so
+	// that redirect URI must also be registered with the OIDC identity provider. The Supervisor then redirects to the
+	// post_logout_redirect_uri of the client which is logging out. It has no effect when the discovery document of the
+	// OIDC identity provider does not advertise an end_session_endpoint. By default, only the Supervisor session is
+	// ended.
+	// +optional
+	EndUpstreamSessionOnLogout bool `json:"endUpstreamSessionOnLogout,omitempty"`
+}
+
+// Parameter is a key/value pair which represents a parameter in an HTTP request.
+type Parameter struct {
+	// The name of the parameter. Required.
+	// +kubebuilder:validation:MinLength=1
+	Name string `json:"name"`
+
+	// The value of the parameter.
+	// +optional
+	Value string `json:"value,omitempty"`
 }
 
 // OIDCClaims provides a mapping from upstream claims into identities.
 type OIDCClaims struct {
+	// AdditionalClaimMappings allows the values of upstream ID token claims to be mapped into the
+	// "additionalClaims" claim of the ID tokens which are issued by the Supervisor. It is a map of the new claim
+	// names, as the keys, to the names of the upstream claims, as the values, e.g. {"email": "email"}. The new
+	// claims are nested under the top-level "additionalClaims" claim of the ID tokens which are issued when this
+	// provider was used to log in. Upstream claims which are not present in the upstream ID token are skipped, and
+	// the "additionalClaims" claim is omitted when it would be empty.
+	// +optional
+	AdditionalClaimMappings map[string]string `json:"additionalClaimMappings,omitempty"`
+
 	// Groups provides the name of the token claim that will be used to ascertain the groups to which
 	// an identity belongs.
 	// +optional
 	Groups string `json:"groups"`
 
+	// UserInfo determines whether the claims which are returned by the userinfo endpoint of this OIDC identity
+	// provider are merged into the claims of the upstream ID token before the username, groups, and additional
+	// claims are read from them. The userinfo endpoint is called with the upstream access token during login, and
+	// its claims are only used when its "sub" claim matches the "sub" claim of the ID token. "IfAvailable", the
+	// default, calls the userinfo endpoint when the discovery document of the provider advertises one. "Required"
+	// also calls the userinfo endpoint, but the provider is considered misconfigured when its discovery document
+	// does not advertise one, which is useful for providers which only return some claims, e.g. large lists of
+	// groups, from their userinfo endpoint. "Disabled" never calls the userinfo endpoint, so only the claims of
+	// the ID token are used.
+	// +kubebuilder:validation:Enum=IfAvailable;Required;Disabled
+	// +optional
+	UserInfo OIDCUserInfoMode `json:"userInfo,omitempty"`
+
 	// Username provides the name of the token claim that will be used to ascertain an identity's
 	// username.
 	// +optional
@@ -65,6 +151,19 @@ type OIDCClient struct {
 	// struct, then it is expected that the Secret is of type "secrets.pinniped.dev/oidc-client" with keys
 	// "clientID" and "clientSecret".
 	SecretName string `json:"secretName"`
+
+	// AuthMethod determines how the Supervisor authenticates itself as this client at the token endpoint of the
+	// OIDC identity provider. "ClientSecret", the default, uses the client secret from the "clientSecret" key of
+	// the Secret. "PrivateKeyJWT" sends a JWT which is signed by the PEM-encoded RSA or EC private key from the
+	// "privateKey" key of the Secret, as described in RFC 7523, using the optional "privateKeyID" key of the
+	// Secret as the key ID of the JWT. "TLSClientAuth" uses the PEM-encoded client certificate and private key
+	// from the "tls.crt" and "tls.key" keys of the Secret for mutual TLS client authentication, as described in
+	// RFC 8705. The "clientID" key of the Secret is always required. When using "PrivateKeyJWT" or
+	// "TLSClientAuth", the discovery document of the provider must list "private_key_jwt" or "tls_client_auth",
+	// respectively, in its token_endpoint_auth_methods_supported.
+	// +kubebuilder:validation:Enum=ClientSecret;PrivateKeyJWT;TLSClientAuth
+	// +optional
+	AuthMethod OIDCClientAuthMethod `json:"authMethod,omitempty"`
 }
 
 // Spec for configuring an OIDC identity provider.
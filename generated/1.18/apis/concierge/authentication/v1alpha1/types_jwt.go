@@ -49,6 +49,14 @@ type JWTTokenClaims struct {
 	// username from the JWT token. When not specified, it will default to "username".
 	// +optional
 	Username string `json:"username"`
+
+	// Extra is the name of a claim whose value is an object of additional claims which should be
+	// mapped into the extra information of the user, e.g. "additionalClaims" for the ID tokens
+	// which are issued by the Pinniped Supervisor. Each additional claim becomes an extra key,
+	// which is the lower-case claim name prefixed by "claims.concierge.pinniped.dev/". When not
+	// specified, no extra information will be mapped from the JWT token.
+	// +optional
+	Extra string `json:"extra,omitempty"`
 }
 
 // JWTAuthenticator describes the configuration of a JWT authenticator.
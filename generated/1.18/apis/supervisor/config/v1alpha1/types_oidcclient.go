@@ -0,0 +1,107 @@
+// Copyright 2021 the Pinniped contributors. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package v1alpha1
+
+import (
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// +kubebuilder:validation:Enum=Success;Invalid
+type OIDCClientStatusCondition string
+
+const (
+	SuccessOIDCClientStatusCondition = OIDCClientStatusCondition("Success")
+	InvalidOIDCClientStatusCondition = OIDCClientStatusCondition("Invalid")
+)
+
+// RedirectURI is a URI to which the Supervisor may redirect the browser at the end of an authorization request.
+// It must be an https URI, or an http URI using a loopback interface IP address.
+// +kubebuilder:validation:Pattern=`^https://.+|^http://(127\.0\.0\.1|\[::1\])(:\d+)?/`
+type RedirectURI string
+
+// GrantType is an OAuth 2.0 grant type which may be used by a client.
+// +kubebuilder:validation:Enum="authorization_code";"refresh_token";"urn:ietf:params:oauth:grant-type:token-exchange";"password"
+type GrantType string
+
+// Scope is an OAuth 2.0 scope which may be requested by a client.
+// +kubebuilder:validation:Enum="openid";"offline_access";"profile";"email";"pinniped:request-audience"
+type Scope string
+
+// OIDCClientSpec is a struct that describes an OIDC client which may log in using the FederationDomains.
+type OIDCClientSpec struct {
+	// AllowedRedirectURIs is a list of the redirect URIs which the client may use in its authorization requests.
+	// +kubebuilder:validation:MinItems=1
+	AllowedRedirectURIs []RedirectURI `json:"allowedRedirectURIs"`
+
+	// AllowedPostLogoutRedirectURIs is a list of the redirect URIs which the client may use as the
+	// post_logout_redirect_uri of its logout requests at the end_session_endpoint. When it is empty, the client may
+	// still log out, but the end user's browser is not redirected back to the client afterwards.
+	// +optional
+	AllowedPostLogoutRedirectURIs []RedirectURI `json:"allowedPostLogoutRedirectURIs,omitempty"`
+
+	// AllowedGrantTypes is a list of the OAuth 2.0 grant types which the client may use. It must include
+	// "authorization_code". To use "refresh_token", the "offline_access" scope must also be allowed. To use
+	// "urn:ietf:params:oauth:grant-type:token-exchange", the "pinniped:request-audience" scope must also be allowed.
+	// The "password" grant type allows the client to log in at the token endpoint using the username and password
+	// of a user of an LDAPIdentityProvider or ActiveDirectoryIdentityProvider.
+	// +kubebuilder:validation:MinItems=1
+	AllowedGrantTypes []GrantType `json:"allowedGrantTypes"`
+
+	// AllowedScopes is a list of the scopes which the client may request. It must include "openid".
+	// +kubebuilder:validation:MinItems=1
+	AllowedScopes []Scope `json:"allowedScopes"`
+}
+
+// OIDCClientStatus is a struct that describes the actual state of an OIDC client.
+type OIDCClientStatus struct {
+	// Status holds an enum that describes the state of this OIDC client. Note that this Status can
+	// represent success or failure.
+	// +optional
+	Status OIDCClientStatusCondition `json:"status,omitempty"`
+
+	// Message provides human-readable details about the Status.
+	// +optional
+	Message string `json:"message,omitempty"`
+
+	// LastUpdateTime holds the time at which the Status was last updated. It is a pointer to get
+	// around some undesirable behavior with respect to the empty metav1.Time value (see
+	// https://github.com/kubernetes/kubernetes/issues/86811).
+	// +optional
+	LastUpdateTime *metav1.Time `json:"lastUpdateTime,omitempty"`
+
+	// ClientSecret holds the name of the corev1.Secret in which this OIDC client's secret is stored.
+	// To set or rotate the client secret, create or update the Secret named "<OIDCClient name>-client-secret"
+	// with the plaintext client secret in its "clientSecret" key. The Supervisor then replaces the plaintext
+	// client secret with its bcrypt hash, so the Secret never keeps the credential.
+	// +optional
+	ClientSecret corev1.LocalObjectReference `json:"clientSecret,omitempty"`
+}
+
+// OIDCClient describes the configuration of an OIDC client which may use the confidential client
+// authorization code flow to log in using the FederationDomains. The name of the OIDCClient is used as
+// its client ID.
+// +genclient
+// +k8s:deepcopy-gen:interfaces=k8s.io/apimachinery/pkg/runtime.Object
+// +kubebuilder:resource:categories=pinniped
+// +kubebuilder:subresource:status
+type OIDCClient struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	// Spec of the OIDC client.
+	Spec OIDCClientSpec `json:"spec"`
+
+	// Status of the OIDC client.
+	Status OIDCClientStatus `json:"status,omitempty"`
+}
+
+// List of OIDCClient objects.
+// +k8s:deepcopy-gen:interfaces=k8s.io/apimachinery/pkg/runtime.Object
+type OIDCClientList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata,omitempty"`
+
+	Items []OIDCClient `json:"items"`
+}
@@ -19,6 +19,14 @@ func (c *FakeConfigV1alpha1) FederationDomains(namespace string) v1alpha1.Federa
 	return &FakeFederationDomains{c, namespace}
 }
 
+func (c *FakeConfigV1alpha1) OIDCClients(namespace string) v1alpha1.OIDCClientInterface {
+	return &FakeOIDCClients{c, namespace}
+}
+
+func (c *FakeConfigV1alpha1) Sessions(namespace string) v1alpha1.SessionInterface {
+	return &FakeSessions{c, namespace}
+}
+
 // RESTClient returns a RESTClient that is used to communicate
 // with API server by this client implementation.
 func (c *FakeConfigV1alpha1) RESTClient() rest.Interface {
@@ -41,6 +41,189 @@ type FederationDomainTLSSpec struct {
 	SecretName string `json:"secretName,omitempty"`
 }
 
+// +kubebuilder:validation:Enum=UsernamePrefix;GroupsPrefix;UsernameRegexReplace;GroupsRegexReplace;GroupsAllow;GroupsDeny;RequireGroup
+type FederationDomainTransformType string
+
+const (
+	UsernamePrefixFederationDomainTransformType       = FederationDomainTransformType("UsernamePrefix")
+	GroupsPrefixFederationDomainTransformType         = FederationDomainTransformType("GroupsPrefix")
+	UsernameRegexReplaceFederationDomainTransformType = FederationDomainTransformType("UsernameRegexReplace")
+	GroupsRegexReplaceFederationDomainTransformType   = FederationDomainTransformType("GroupsRegexReplace")
+	GroupsAllowFederationDomainTransformType          = FederationDomainTransformType("GroupsAllow")
+	GroupsDenyFederationDomainTransformType           = FederationDomainTransformType("GroupsDeny")
+	RequireGroupFederationDomainTransformType         = FederationDomainTransformType("RequireGroup")
+)
+
+// FederationDomainTransform is one step of the pipeline which transforms the username and groups of an end user
+// who logs in using an identity provider, before the downstream session of the end user is created.
+type FederationDomainTransform struct {
+	// Type is the kind of transformation. UsernamePrefix and GroupsPrefix prepend Prefix to the username or to each
+	// group name. UsernameRegexReplace and GroupsRegexReplace replace the matches of Regex in the username or in each
+	// group name with Replacement. GroupsAllow removes all groups which are not listed in Groups, while GroupsDeny
+	// removes all groups which are listed in Groups. RequireGroup rejects the login of any end user who is not a
+	// member of at least one of the groups listed in Groups.
+	Type FederationDomainTransformType `json:"type"`
+
+	// Prefix is used by the UsernamePrefix and GroupsPrefix transformations.
+	// +optional
+	Prefix string `json:"prefix,omitempty"`
+
+	// Regex is a regular expression in RE2 syntax, used by the UsernameRegexReplace and GroupsRegexReplace
+	// transformations.
+	// +optional
+	Regex string `json:"regex,omitempty"`
+
+	// Replacement is used by the UsernameRegexReplace and GroupsRegexReplace transformations. It may refer to
+	// submatches of Regex, e.g. "$1". When Replacement is empty, the matches of Regex are removed. Groups which
+	// become empty are removed.
+	// +optional
+	Replacement string `json:"replacement,omitempty"`
+
+	// Groups is a list of group names used by the GroupsAllow, GroupsDeny and RequireGroup transformations. The
+	// group names are compared to the group names as transformed by the previous steps of the pipeline.
+	// +optional
+	Groups []string `json:"groups,omitempty"`
+}
+
+// FederationDomainIdentityProvider describes an identity provider resource which may be used to log in to a
+// FederationDomain.
+type FederationDomainIdentityProvider struct {
+	// Kind is the kind of the identity provider resource.
+	// +kubebuilder:validation:Enum=OIDCIdentityProvider;LDAPIdentityProvider;ActiveDirectoryIdentityProvider
+	Kind string `json:"kind"`
+
+	// Name is the name of the identity provider resource, which must be in the same namespace as the
+	// FederationDomain.
+	// +kubebuilder:validation:MinLength=1
+	Name string `json:"name"`
+
+	// Transforms is an optional ordered list of transformations of the username and groups of the end users who
+	// log in to this FederationDomain using this identity provider. The transformations are applied in order,
+	// each to the result of the previous one, before the downstream session of the end user is created. They
+	// are also applied during refreshes of the downstream session.
+	// +optional
+	Transforms []FederationDomainTransform `json:"transforms,omitempty"`
+}
+
+// FederationDomainClaimMatch matches one claim of the end user. For OIDC identity providers, the claims are the
+// claims of the upstream ID token. For LDAP and Active Directory identity providers, the claims are the attributes
+// which were read from the end user's entry, each as a list of strings, and "dn", the DN of the entry.
+type FederationDomainClaimMatch struct {
+	// Name is the name of the claim. A claim which the end user does not have never matches.
+	// +kubebuilder:validation:MinLength=1
+	Name string `json:"name"`
+
+	// Values matches a string claim which is equal to one of these values, a list claim which contains a string
+	// which is equal to one of these values, or a boolean claim whose value, either "true" or "false", is one of
+	// these values. Claims of other types, e.g. numbers, cannot be matched, and reject the login. Exactly one of
+	// Values and Regex must be specified.
+	// +optional
+	Values []string `json:"values,omitempty"`
+
+	// Regex is a regular expression in RE2 syntax which matches a string claim, or a list claim which contains a
+	// string which it matches. Claims of other types cannot be matched, and reject the login. Exactly one of Values
+	// and Regex must be specified.
+	// +optional
+	Regex string `json:"regex,omitempty"`
+}
+
+// FederationDomainPolicyMatch selects the end users to whom a rule of a FederationDomainPolicy applies. It has
+// conditions, and it selects the end users who meet all of the conditions which are specified.
+type FederationDomainPolicyMatch struct {
+	// UsernameRegex is a regular expression in RE2 syntax which must match the username of the end user, e.g.
+	// "@contractor\\.com$".
+	// +optional
+	UsernameRegex string `json:"usernameRegex,omitempty"`
+
+	// InAnyGroup is a list of group names. The end user must be a member of at least one of them.
+	// +optional
+	InAnyGroup []string `json:"inAnyGroup,omitempty"`
+
+	// NotInAnyGroup is a list of group names. The end user must not be a member of any of them.
+	// +optional
+	NotInAnyGroup []string `json:"notInAnyGroup,omitempty"`
+
+	// Claims must all match the claims of the end user.
+	// +optional
+	Claims []FederationDomainClaimMatch `json:"claims,omitempty"`
+}
+
+// FederationDomainGroupRule adds groups to some of the end users who log in to a FederationDomain.
+type FederationDomainGroupRule struct {
+	// Match selects the end users to whom the groups are added. When it is not specified, the groups are added to
+	// every end user.
+	// +optional
+	Match *FederationDomainPolicyMatch `json:"match,omitempty"`
+
+	// Groups are added to the groups of the end user. At least one of Groups and FromClaim must be specified.
+	// +optional
+	Groups []string `json:"groups,omitempty"`
+
+	// FromClaim is the name of a claim of the end user whose value, a string or a list of strings, is added to the
+	// groups of the end user, each prepended with Prefix. Nothing is added when the end user does not have the
+	// claim, and a claim of another type rejects the login.
+	// +optional
+	FromClaim string `json:"fromClaim,omitempty"`
+
+	// Prefix is prepended to each of the groups which are added from FromClaim, e.g. "dept:".
+	// +optional
+	Prefix string `json:"prefix,omitempty"`
+}
+
+// FederationDomainDenyRule rejects the logins of some end users to a FederationDomain.
+type FederationDomainDenyRule struct {
+	// Match selects the end users whose logins are rejected. At least one of its conditions must be specified.
+	Match FederationDomainPolicyMatch `json:"match"`
+
+	// Message is shown to the end user when the login is rejected by this rule, as the description of an OAuth
+	// access_denied error. When it is empty, a generic message is shown.
+	// +optional
+	Message string `json:"message,omitempty"`
+}
+
+// FederationDomainPolicy is a login policy made of rules, which are applied after the username and groups of the
+// end user have been transformed by the transforms of the identity provider. For example, this rule rejects the
+// end users whose usernames end with "@contractor.com", unless they are members of the "approved-contractors"
+// group:
+//
+//	denyRules:
+//	- match:
+//	    usernameRegex: "@contractor\\.com$"
+//	    notInAnyGroup: [approved-contractors]
+//	  message: contractors must be approved
+type FederationDomainPolicy struct {
+	// GroupRules are applied in order, before the DenyRules. The groups added by each rule are visible to the
+	// later rules.
+	// +optional
+	GroupRules []FederationDomainGroupRule `json:"groupRules,omitempty"`
+
+	// DenyRules are applied in order. The login is rejected by the first rule which matches the end user.
+	// +optional
+	DenyRules []FederationDomainDenyRule `json:"denyRules,omitempty"`
+}
+
+// FederationDomainTokensSpec configures the lifetimes of the tokens issued by a FederationDomain.
+type FederationDomainTokensSpec struct {
+	// AccessTokenLifetime is the lifetime of the access tokens and ID tokens issued by this FederationDomain, e.g.
+	// "5m". When not specified, it defaults to 2 minutes. It may not exceed the maximum access token lifetime of
+	// the Supervisor, which is 1 hour by default.
+	// +optional
+	AccessTokenLifetime *metav1.Duration `json:"accessTokenLifetime,omitempty"`
+
+	// AuthorizationCodeLifetime is how long an authorization code issued by this FederationDomain may be redeemed
+	// at its token endpoint, e.g. "5m". When not specified, it defaults to 10 minutes. It may not exceed the
+	// maximum authorization code lifetime of the Supervisor, which is 1 hour by default.
+	// +optional
+	AuthorizationCodeLifetime *metav1.Duration `json:"authorizationCodeLifetime,omitempty"`
+
+	// RefreshTokenLifetime is the lifetime of the refresh tokens issued by this FederationDomain, e.g. "24h". This
+	// is the length of the sessions of the end users, who must log in again once their refresh token has expired.
+	// When not specified, it defaults to 9 hours. It may not exceed the maximum refresh token lifetime of the
+	// Supervisor, which is 24 hours by default.
+	// +optional
+	RefreshTokenLifetime *metav1.Duration `json:"refreshTokenLifetime,omitempty"`
+}
+
 // FederationDomainSpec is a struct that describes an OIDC Provider.
 type FederationDomainSpec struct {
 	// Issuer is the OIDC Provider's issuer, per the OIDC Discovery Metadata document, as well as the
@@ -57,6 +240,23 @@ type FederationDomainSpec struct {
 	// TLS configures how this FederationDomain is served over Transport Layer Security (TLS).
 	// +optional
 	TLS *FederationDomainTLSSpec `json:"tls,omitempty"`
+
+	// IdentityProviders is an optional list of the identity providers which may be used to log in to this
+	// FederationDomain. When this list is empty, then every identity provider may be used to log in. When this
+	// list is not empty, then only the listed identity providers may be used to log in, and only the listed
+	// identity providers will be advertised by this FederationDomain's identity provider discovery endpoint.
+	// +optional
+	IdentityProviders []FederationDomainIdentityProvider `json:"identityProviders,omitempty"`
+
+	// Policy is an optional login policy for the end users who log in to this FederationDomain using any identity
+	// provider. It is also applied during refreshes of the downstream session.
+	// +optional
+	Policy *FederationDomainPolicy `json:"policy,omitempty"`
+
+	// Tokens configures the lifetimes of the tokens issued by this FederationDomain. When not specified, the
+	// default lifetimes are used.
+	// +optional
+	Tokens *FederationDomainTokensSpec `json:"tokens,omitempty"`
 }
 
 // FederationDomainSecrets holds information about this OIDC Provider's secrets.
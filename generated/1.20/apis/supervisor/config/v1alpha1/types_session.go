@@ -0,0 +1,87 @@
+// Copyright 2021 the Pinniped contributors. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package v1alpha1
+
+import (
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// SessionSpec is a struct that describes the desired state of a Supervisor session.
+type SessionSpec struct {
+	// Revoked may be set to true to revoke the session. The Supervisor will then delete all of the stored
+	// authorization codes, access tokens, refresh tokens, PKCE and OIDC data of the session, so the session can no
+	// longer be used or refreshed, and then it will delete this Session.
+	// +optional
+	Revoked bool `json:"revoked,omitempty"`
+}
+
+// SessionStatus is a struct that describes the actual state of a Supervisor session.
+type SessionStatus struct {
+	// Username is the downstream username of the user who logged in.
+	// +optional
+	Username string `json:"username,omitempty"`
+
+	// Subject is the downstream subject of the user who logged in.
+	// +optional
+	Subject string `json:"subject,omitempty"`
+
+	// Groups are the downstream group memberships of the user, as of the most recent login or refresh.
+	// +optional
+	Groups []string `json:"groups,omitempty"`
+
+	// ClientID is the ID of the downstream OIDC client to which the tokens of the session were issued.
+	// +optional
+	ClientID string `json:"clientID,omitempty"`
+
+	// UpstreamIdentityProviderName is the name of the upstream identity provider which authenticated the user.
+	// +optional
+	UpstreamIdentityProviderName string `json:"upstreamIdentityProviderName,omitempty"`
+
+	// UpstreamIdentityProviderType is the type of the upstream identity provider which authenticated the user,
+	// e.g. "oidc", "ldap", or "activedirectory".
+	// +optional
+	UpstreamIdentityProviderType string `json:"upstreamIdentityProviderType,omitempty"`
+
+	// AuthenticatedAt is the time at which the user logged in to start the session.
+	// +optional
+	AuthenticatedAt *metav1.Time `json:"authenticatedAt,omitempty"`
+
+	// ExpiresAt is the time after which the stored tokens of the session will be garbage collected,
+	// unless the session is refreshed before then.
+	// +optional
+	ExpiresAt *metav1.Time `json:"expiresAt,omitempty"`
+}
+
+// Session describes an active downstream session of a user who logged in using one of the FederationDomains.
+// Sessions are created, updated and deleted by the Supervisor to mirror its session storage, and the name of a
+// Session is the ID of the session. Set spec.revoked to true to revoke a session. Deleting a Session does not revoke
+// the session, and the Supervisor will create the Session again. Sessions are only created when the Supervisor
+// stores its session data in Secrets. With the other storage backends, run "pinniped-supervisor sessions" in a
+// Supervisor pod to list and revoke the sessions instead.
+// +genclient
+// +k8s:deepcopy-gen:interfaces=k8s.io/apimachinery/pkg/runtime.Object
+// +kubebuilder:resource:categories=pinniped
+// +kubebuilder:printcolumn:name="Username",type=string,JSONPath=`.status.username`
+// +kubebuilder:printcolumn:name="Client",type=string,JSONPath=`.status.clientID`
+// +kubebuilder:printcolumn:name="Expires",type=date,JSONPath=`.status.expiresAt`
+// +kubebuilder:printcolumn:name="Age",type=date,JSONPath=`.metadata.creationTimestamp`
+type Session struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	// Spec of the session.
+	Spec SessionSpec `json:"spec"`
+
+	// Status of the session.
+	Status SessionStatus `json:"status,omitempty"`
+}
+
+// List of Session objects.
+// +k8s:deepcopy-gen:interfaces=k8s.io/apimachinery/pkg/runtime.Object
+type SessionList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata,omitempty"`
+
+	Items []Session `json:"items"`
+}
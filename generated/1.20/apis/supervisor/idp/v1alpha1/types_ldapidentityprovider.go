@@ -20,6 +20,20 @@ const (
 	LDAPPhaseError LDAPIdentityProviderPhase = "Error"
 )
 
+type LDAPIdentityProviderGroupSearchMode string
+
+const (
+	// LDAPGroupSearchModeSearch searches for the groups which have the user as a member.
+	LDAPGroupSearchModeSearch LDAPIdentityProviderGroupSearchMode = "Search"
+
+	// LDAPGroupSearchModeUserAttribute reads the groups of the user from an attribute of the user's entry.
+	LDAPGroupSearchModeUserAttribute LDAPIdentityProviderGroupSearchMode = "UserAttribute"
+
+	// LDAPGroupSearchModeInChainSearch searches for the groups which have the user as a direct or indirect member
+	// using the LDAP_MATCHING_RULE_IN_CHAIN matching rule.
+	LDAPGroupSearchModeInChainSearch LDAPIdentityProviderGroupSearchMode = "InChainSearch"
+)
+
 // Status of an LDAP identity provider.
 type LDAPIdentityProviderStatus struct {
 	// Phase summarizes the overall status of the LDAPIdentityProvider.
@@ -40,7 +54,10 @@ type LDAPIdentityProviderBind struct {
 	// password for an LDAP bind user. This account will be used to perform LDAP searches. The Secret should be
 	// of type "kubernetes.io/basic-auth" which includes "username" and "password" keys. The username value
 	// should be the full dn (distinguished name) of your bind account, e.g. "cn=bind-account,ou=users,dc=example,dc=com".
-	// The password must be non-empty.
+	// The password must be non-empty. Alternatively, the Secret may be of type "kubernetes.io/tls" which includes
+	// "tls.crt" and "tls.key" keys. Then this client certificate is presented to the LDAP server during the TLS
+	// handshake, and a SASL EXTERNAL bind is performed, so that the LDAP server determines the bind account from
+	// the certificate.
 	// +kubebuilder:validation:MinLength=1
 	SecretName string `json:"secretName"`
 }
@@ -62,6 +79,15 @@ type LDAPIdentityProviderUserSearchAttributes struct {
 	// server in the user's entry. Distinguished names can be used by specifying lower-case "dn".
 	// +kubebuilder:validation:MinLength=1
 	UID string `json:"uid,omitempty"`
+
+	// AdditionalClaimMappings allows the values of other attributes of the user's LDAP entry to be mapped into the
+	// "additionalClaims" claim of the ID tokens which are issued by the Supervisor. It is a map of the new claim
+	// names, as the keys, to the names of the attributes, as the values, e.g. {"email": "mail", "department":
+	// "departmentNumber"}. An attribute with one value becomes a string claim, and an attribute with several values
+	// becomes a list of strings. Attributes which are not present in the user's entry are skipped, and the
+	// "additionalClaims" claim is omitted when it would be empty.
+	// +optional
+	AdditionalClaimMappings map[string]string `json:"additionalClaimMappings,omitempty"`
 }
 
 type LDAPIdentityProviderGroupSearchAttributes struct {
@@ -98,10 +124,19 @@ type LDAPIdentityProviderUserSearch struct {
 }
 
 type LDAPIdentityProviderGroupSearch struct {
+	// Mode determines how the groups of a user are found. "Search", the default, searches under the Base for the
+	// groups which match the Filter. "UserAttribute" reads the dn (distinguished name) of each group of the user
+	// from the UserAttributeForGroups attribute of the user's entry, e.g. "memberOf", so the Base and Filter are not
+	// used. "InChainSearch" is like "Search", but the default Filter uses the LDAP_MATCHING_RULE_IN_CHAIN matching
+	// rule, so that LDAP servers which support it also find the groups of which the user is an indirect member.
+	// +kubebuilder:validation:Enum=Search;UserAttribute;InChainSearch
+	// +optional
+	Mode LDAPIdentityProviderGroupSearchMode `json:"mode,omitempty"`
+
 	// Base is the dn (distinguished name) that should be used as the search base when searching for groups. E.g.
 	// "ou=groups,dc=example,dc=com". When not specified, no group search will be performed and
 	// authenticated users will not belong to any groups from the LDAP provider. Also, when not specified,
-	// the values of Filter and Attributes are ignored.
+	// the values of Filter and Attributes are ignored. The Base is not used when the Mode is "UserAttribute".
 	// +optional
 	Base string `json:"base,omitempty"`
 
@@ -111,14 +146,54 @@ type LDAPIdentityProviderGroupSearch struct {
 	// "&(objectClass=groupOfNames)(member={})". For more information about LDAP filters, see
 	// https://ldap.com/ldap-filters.
 	// Note that the dn (distinguished name) is not an attribute of an entry, so "dn={}" cannot be used.
-	// Optional. When not specified, the default will act as if the Filter were specified as "member={}".
+	// Optional. When not specified, the default will act as if the Filter were specified as "member={}", or as
+	// "member:1.2.840.113556.1.4.1941:={}" when the Mode is "InChainSearch".
 	// +optional
 	Filter string `json:"filter,omitempty"`
 
+	// UserAttributeForGroups is the attribute of the user's entry which holds the dn (distinguished name) of each
+	// group of which the user is a member, when the Mode is "UserAttribute". When MaxNestingDepth is also specified,
+	// the same attribute of each group's entry is read to find the groups which contain that group.
+	// Optional. When not specified, the default will act as if the UserAttributeForGroups were specified as "memberOf".
+	// +optional
+	UserAttributeForGroups string `json:"userAttributeForGroups,omitempty"`
+
+	// MaxNestingDepth enables nested groups when the Mode is "Search" or "UserAttribute". The groups which contain
+	// the groups of the user are also found, and so on, until this many levels of groups above the user's direct
+	// groups have been found. Groups which were already found are skipped, so cycles of groups are harmless.
+	// When the Mode is "InChainSearch", the LDAP server resolves nested groups instead, and this is ignored.
+	// Optional. When not specified, only the groups of which the user is a direct member are found.
+	// +kubebuilder:validation:Minimum=0
+	// +kubebuilder:validation:Maximum=10
+	// +optional
+	MaxNestingDepth int32 `json:"maxNestingDepth,omitempty"`
+
 	// Attributes specifies how the group's information should be read from each LDAP entry which was found as
 	// the result of the group search.
 	// +optional
 	Attributes LDAPIdentityProviderGroupSearchAttributes `json:"attributes,omitempty"`
+
+	// Cache configures an in-memory cache of the groups which were found for each user, to reduce the load on the
+	// LDAP server when the same users log in or refresh their sessions often. Users must still bind with their
+	// password at every login. The cache is emptied whenever this LDAPIdentityProvider is changed.
+	// +optional
+	Cache LDAPIdentityProviderGroupSearchCache `json:"cache,omitempty"`
+}
+
+// LDAPIdentityProviderGroupSearchCache configures the caching of the results of group searches.
+type LDAPIdentityProviderGroupSearchCache struct {
+	// TTLSeconds is how long the groups which were found for a user are reused before they are searched for again.
+	// Changes to a user's group memberships in the LDAP server may take this long to be noticed.
+	// Optional. When not specified, or zero, the groups of users are not cached.
+	// +kubebuilder:validation:Minimum=0
+	// +optional
+	TTLSeconds int32 `json:"ttlSeconds,omitempty"`
+
+	// MaxEntries is the maximum number of users whose groups are cached. When the cache is full, the entries which
+	// would expire soonest are removed first. Optional. When not specified, or zero, the default is 1000.
+	// +kubebuilder:validation:Minimum=0
+	// +optional
+	MaxEntries int32 `json:"maxEntries,omitempty"`
 }
 
 // Spec for configuring an LDAP identity provider.
@@ -127,6 +202,19 @@ type LDAPIdentityProviderSpec struct {
 	// +kubebuilder:validation:MinLength=1
 	Host string `json:"host"`
 
+	// FailoverHosts is an ordered list of the hostnames of other servers of this LDAP identity provider, which are tried
+	// in order when the Host cannot be reached. For example: ldap2.example.com:636. These servers must serve the same directory
+	// and accept the same bind account as the Host.
+	// +optional
+	FailoverHosts []string `json:"failoverHosts,omitempty"`
+
+	// SRVDomain is a DNS domain whose "_ldap._tcp" SRV records list more LDAP servers of this LDAP identity provider,
+	// which are tried in the order of their priority and weight when neither the Host nor the FailoverHosts can be
+	// reached. When connecting with TLS, port 636 of each server is used instead of the port from its SRV record.
+	// For example: example.com.
+	// +optional
+	SRVDomain string `json:"srvDomain,omitempty"`
+
 	// TLS contains the connection settings for how to establish the connection to the Host.
 	TLS *TLSSpec `json:"tls,omitempty"`
 
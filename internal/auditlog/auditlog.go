@@ -0,0 +1,156 @@
+// Copyright 2021 the Pinniped contributors. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+// Package auditlog writes a stream of authentication audit events for the Supervisor.
+//
+// Each event is written as a single line of JSON. The fields of Event are a stable schema: fields may be added in
+// later versions, but existing fields will not be renamed or change their meaning without changing Version.
+package auditlog
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+
+	"go.pinniped.dev/internal/plog"
+)
+
+// Version is the version of the schema of Event.
+const Version = "1"
+
+// EventType is the kind of authentication step which an Event describes.
+type EventType string
+
+const (
+	// EventTypeAuthorize is a request to the authorization endpoint. For LDAP upstreams, this is where the end user
+	// logs in, so its result is the result of the login. For OIDC upstreams, it only redirects to the upstream.
+	EventTypeAuthorize = EventType("authorize")
+
+	// EventTypeCallback is the return of the end user from an OIDC upstream to the callback endpoint.
+	EventTypeCallback = EventType("callback")
+
+	// EventTypeLogin is the submission of a username and password on the login page, which is where end users who
+	// use a web browser log in using an LDAP or AD upstream.
+	EventTypeLogin = EventType("login")
+
+	// EventTypeTokenExchange is an RFC8693 token exchange at the token endpoint, which is how clients get a
+	// cluster-scoped ID token.
+	EventTypeTokenExchange = EventType("token_exchange")
+
+	// EventTypeRefresh is the use of a refresh token at the token endpoint.
+	EventTypeRefresh = EventType("refresh")
+
+	// EventTypePasswordGrant is a login using the resource owner password credentials grant at the token endpoint,
+	// where a client sends the username and password of an end user who logs in using an LDAP or AD upstream.
+	EventTypePasswordGrant = EventType("password_grant")
+
+	// EventTypeFailedCredentials is a login attempt which was rejected because of a wrong or missing username
+	// or password. Many of these events for the same username or source IP may be a brute force attack.
+	EventTypeFailedCredentials = EventType("failed_credentials")
+
+	// EventTypeLogout is a request to the end session endpoint, where a client logs the end user out of their session.
+	EventTypeLogout = EventType("logout")
+
+	// EventTypeDeviceAuthorization is a request to the device authorization endpoint, where a device starts a login
+	// using the device authorization grant.
+	EventTypeDeviceAuthorization = EventType("device_authorization")
+
+	// EventTypeDeviceVerification is the submission of a user code on the device verification page. Many failures
+	// for the same source IP may be an attempt to guess the user codes of other devices.
+	EventTypeDeviceVerification = EventType("device_verification")
+)
+
+const (
+	ResultSuccess = "success"
+	ResultFailure = "failure"
+	ResultError   = "error"
+)
+
+// Event is a single audit event. Fields which are not known at the time of the event are left empty.
+type Event struct {
+	Version   string    `json:"version"`
+	Timestamp time.Time `json:"timestamp"`
+	Type      EventType `json:"type"`
+	Result    string    `json:"result"`
+
+	// Reason explains why the event was not successful.
+	Reason string `json:"reason,omitempty"`
+
+	// Issuer is the issuer of the FederationDomain which handled the request.
+	Issuer string `json:"issuer"`
+
+	// SourceIP is the IP address from which the request was received.
+	SourceIP string `json:"sourceIP"`
+
+	ClientID string `json:"clientID,omitempty"`
+
+	UpstreamIDPName string `json:"upstreamIDPName,omitempty"`
+	UpstreamIDPType string `json:"upstreamIDPType,omitempty"`
+
+	// Subject, Username and Groups describe the downstream identity of the end user. For failed credentials,
+	// Username is the username which was used in the attempt.
+	Subject  string   `json:"subject,omitempty"`
+	Username string   `json:"username,omitempty"`
+	Groups   []string `json:"groups,omitempty"`
+}
+
+//nolint: gochecknoglobals
+var (
+	sinkMu sync.Mutex
+	sink   io.Writer
+	now    = time.Now
+)
+
+// SetSink sets the destination of all audit events. When w is nil, audit events are discarded.
+func SetSink(w io.Writer) {
+	sinkMu.Lock()
+	defer sinkMu.Unlock()
+	sink = w
+}
+
+type issuerKey struct{}
+
+// WithIssuer returns a handler which remembers the issuer of the FederationDomain which serves the request,
+// so that the events recorded by the handler include it.
+func WithIssuer(issuer string, handler http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		handler.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), issuerKey{}, issuer)))
+	})
+}
+
+// Record writes the event, filling in its version, timestamp, issuer and source IP from the request.
+func Record(r *http.Request, event Event) {
+	sinkMu.Lock()
+	defer sinkMu.Unlock()
+
+	if sink == nil {
+		return
+	}
+
+	event.Version = Version
+	event.Timestamp = now().UTC()
+	event.Issuer, _ = r.Context().Value(issuerKey{}).(string)
+	event.SourceIP = SourceIP(r)
+
+	data, err := json.Marshal(event)
+	if err != nil {
+		plog.Error("could not encode audit event", err)
+		return
+	}
+	if _, err := sink.Write(append(data, '\n')); err != nil {
+		plog.Error("could not write audit event", err)
+	}
+}
+
+// SourceIP returns the IP address from which the request was received.
+func SourceIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
@@ -0,0 +1,97 @@
+// Copyright 2021 the Pinniped contributors. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package auditlog
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestRecord(t *testing.T) {
+	var buf bytes.Buffer
+	SetSink(&buf)
+	defer SetSink(nil)
+
+	fakeNow := time.Date(2030, time.January, 1, 0, 0, 0, 0, time.FixedZone("some-zone", 3600))
+	now = func() time.Time { return fakeNow }
+	defer func() { now = time.Now }()
+
+	handler := WithIssuer("https://some-issuer.com/some-path", http.HandlerFunc(func(_ http.ResponseWriter, r *http.Request) {
+		Record(r, Event{
+			Type:            EventTypeAuthorize,
+			Result:          ResultSuccess,
+			ClientID:        "pinniped-cli",
+			UpstreamIDPName: "some-ldap-idp",
+			UpstreamIDPType: "ldap",
+			Subject:         "ldaps://some-ldap-host?sub=some-uid",
+			Username:        "some-user",
+			Groups:          []string{"group1", "group2"},
+		})
+		Record(r, Event{
+			Type:            EventTypeFailedCredentials,
+			Result:          ResultFailure,
+			Reason:          "username/password not accepted by upstream",
+			UpstreamIDPName: "some-ldap-idp",
+			UpstreamIDPType: "ldap",
+			Username:        "some-other-user",
+		})
+	}))
+
+	req := httptest.NewRequest(http.MethodGet, "/some-path/oauth2/authorize", nil)
+	req.RemoteAddr = "10.1.2.3:54321"
+	handler.ServeHTTP(httptest.NewRecorder(), req)
+
+	lines := bytes.Split(bytes.TrimSuffix(buf.Bytes(), []byte("\n")), []byte("\n"))
+	require.Len(t, lines, 2)
+	require.JSONEq(t, `{
+		"version": "1",
+		"timestamp": "2029-12-31T23:00:00Z",
+		"type": "authorize",
+		"result": "success",
+		"issuer": "https://some-issuer.com/some-path",
+		"sourceIP": "10.1.2.3",
+		"clientID": "pinniped-cli",
+		"upstreamIDPName": "some-ldap-idp",
+		"upstreamIDPType": "ldap",
+		"subject": "ldaps://some-ldap-host?sub=some-uid",
+		"username": "some-user",
+		"groups": ["group1", "group2"]
+	}`, string(lines[0]))
+	require.JSONEq(t, `{
+		"version": "1",
+		"timestamp": "2029-12-31T23:00:00Z",
+		"type": "failed_credentials",
+		"result": "failure",
+		"reason": "username/password not accepted by upstream",
+		"issuer": "https://some-issuer.com/some-path",
+		"sourceIP": "10.1.2.3",
+		"upstreamIDPName": "some-ldap-idp",
+		"upstreamIDPType": "ldap",
+		"username": "some-other-user"
+	}`, string(lines[1]))
+}
+
+func TestRecordWithoutIssuerOrPort(t *testing.T) {
+	var buf bytes.Buffer
+	SetSink(&buf)
+	defer SetSink(nil)
+
+	req := httptest.NewRequest(http.MethodPost, "/token", nil)
+	req.RemoteAddr = "some-unix-socket"
+	Record(req, Event{Type: EventTypeRefresh, Result: ResultError})
+
+	require.Contains(t, buf.String(), `"issuer":"","sourceIP":"some-unix-socket"`)
+}
+
+func TestRecordWithoutSink(t *testing.T) {
+	SetSink(nil)
+
+	// Nothing to assert other than that this does not panic.
+	Record(httptest.NewRequest(http.MethodGet, "/", nil), Event{Type: EventTypeCallback})
+}
@@ -7,7 +7,7 @@ package authenticators
 import (
 	"context"
 
-	"k8s.io/apiserver/pkg/authentication/authenticator"
+	"k8s.io/apiserver/pkg/authentication/user"
 )
 
 // This interface is similar to the k8s token authenticator, but works with username/passwords instead
@@ -16,6 +16,7 @@ import (
 // The return values should be as follows.
 // 1. For a successful authentication:
 //    - A response which includes the username, uid, and groups in the userInfo. The username and uid must not be blank.
+//      The response also includes the DN of the user's entry, which is needed to look up the user again later.
 //    - true
 //    - nil error
 // 2. For an unsuccessful authentication, e.g. bad username or password:
@@ -31,5 +32,23 @@ import (
 // See k8s.io/apiserver/pkg/authentication/authenticator/interfaces.go for the token authenticator
 // interface, as well as the Response type.
 type UserAuthenticator interface {
-	AuthenticateUser(ctx context.Context, username, password string) (*authenticator.Response, bool, error)
+	AuthenticateUser(ctx context.Context, username, password string) (*Response, bool, error)
+}
+
+// Response is the result of a successful user authentication. It is similar to the k8s authenticator.Response,
+// but also holds any upstream state which is needed to look up the same user again when the session is refreshed.
+type Response struct {
+	// User is the mapped identity of the authenticated user.
+	User user.Info
+
+	// DN is the distinguished name of the user's entry in the upstream LDAP or Active Directory provider.
+	DN string
+
+	// Attributes holds the values of the attributes which were read from the user's entry, and the DN of the
+	// entry as "dn". They are made available to the login policy of the FederationDomain.
+	Attributes map[string][]string
+
+	// AdditionalClaims holds the values of the attributes which were mapped to additional claims of the
+	// downstream ID tokens, keyed by claim name.
+	AdditionalClaims map[string]interface{}
 }
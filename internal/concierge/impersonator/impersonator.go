@@ -50,6 +50,7 @@ import (
 	"go.pinniped.dev/internal/dynamiccert"
 	"go.pinniped.dev/internal/httputil/securityheader"
 	"go.pinniped.dev/internal/kubeclient"
+	"go.pinniped.dev/internal/metrics"
 	"go.pinniped.dev/internal/plog"
 	"go.pinniped.dev/internal/valuelesscontext"
 )
@@ -178,6 +179,7 @@ func newInternal( //nolint:funlen // yeah, it's kind of long.
 			impersonationProxy := impersonationProxyFunc(c)
 			handler := http.Handler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 				defer impersonationProxyCompleted.ServeHTTP(w, r)
+				defer metrics.ObserveImpersonationProxyRequest(requestVerb(r), time.Now())
 				impersonationProxy.ServeHTTP(w, r)
 			}))
 			handler = filterlatency.TrackStarted(handler, "impersonationproxy")
@@ -752,6 +754,15 @@ func newStatusErrResponse(w http.ResponseWriter, r *http.Request, s runtime.Nego
 	responsewriters.ErrorNegotiated(err, s, gv, w, r)
 }
 
+// requestVerb returns the Kubernetes API verb of the request, e.g. "list", for use as a metrics label.
+func requestVerb(r *http.Request) string {
+	requestInfo, ok := genericapirequest.RequestInfoFrom(r.Context())
+	if !ok {
+		return "unknown"
+	}
+	return requestInfo.Verb
+}
+
 func getTransportForProtocol(restConfig *rest.Config, protocol string) (http.RoundTripper, error) {
 	transportConfig, err := restConfig.TransportConfig()
 	if err != nil {
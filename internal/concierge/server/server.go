@@ -27,6 +27,7 @@ import (
 	"go.pinniped.dev/internal/dynamiccert"
 	"go.pinniped.dev/internal/here"
 	"go.pinniped.dev/internal/issuer"
+	"go.pinniped.dev/internal/metrics"
 	"go.pinniped.dev/internal/plog"
 	"go.pinniped.dev/internal/registry/credentialrequest"
 )
@@ -176,6 +177,15 @@ func (a *App) runServer(ctx context.Context) error {
 		return fmt.Errorf("could not create aggregated API server: %w", err)
 	}
 
+	// Serve the metrics on their own plain HTTP listener, if configured.
+	if cfg.Metrics.ListenAddress != "" {
+		metricsListener, err := metrics.Serve(ctx, cfg.Metrics.ListenAddress)
+		if err != nil {
+			return err
+		}
+		plog.Debug("serving metrics", "metricsAddress", metricsListener.Addr().String())
+	}
+
 	// Run the server. Its post-start hook will start the controllers.
 	return server.GenericAPIServer.PrepareRun().Run(ctx.Done())
 }
@@ -8,6 +8,7 @@ package concierge
 import (
 	"fmt"
 	"io/ioutil"
+	"net"
 	"strings"
 
 	"k8s.io/utils/pointer"
@@ -61,6 +62,10 @@ func FromPath(path string) (*Config, error) {
 		return nil, fmt.Errorf("validate log level: %w", err)
 	}
 
+	if err := validateMetrics(&config.Metrics); err != nil {
+		return nil, fmt.Errorf("validate metrics: %w", err)
+	}
+
 	if config.Labels == nil {
 		config.Labels = make(map[string]string)
 	}
@@ -147,3 +152,13 @@ func validateAPI(apiConfig *APIConfigSpec) error {
 func validateAPIGroupSuffix(apiGroupSuffix string) error {
 	return groupsuffix.Validate(apiGroupSuffix)
 }
+
+func validateMetrics(metrics *MetricsSpec) error {
+	if metrics.ListenAddress == "" {
+		return nil
+	}
+	if _, _, err := net.SplitHostPort(metrics.ListenAddress); err != nil {
+		return fmt.Errorf("invalid listenAddress %q: %w", metrics.ListenAddress, err)
+	}
+	return nil
+}
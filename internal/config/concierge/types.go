@@ -14,6 +14,7 @@ type Config struct {
 	KubeCertAgentConfig KubeCertAgentSpec `json:"kubeCertAgent"`
 	Labels              map[string]string `json:"labels"`
 	LogLevel            plog.LogLevel     `json:"logLevel"`
+	Metrics             MetricsSpec       `json:"metrics"`
 }
 
 // DiscoveryInfoSpec contains configuration knobs specific to
@@ -77,3 +78,10 @@ type KubeCertAgentSpec struct {
 	// ImagePullSecrets on the kube-cert-agent pods.
 	ImagePullSecrets []string
 }
+
+// MetricsSpec configures the Prometheus metrics endpoint of the Concierge.
+type MetricsSpec struct {
+	// ListenAddress is the host:port on which /metrics is served over plain HTTP, e.g. ":9090".
+	// When empty, metrics are not served.
+	ListenAddress string `json:"listenAddress"`
+}
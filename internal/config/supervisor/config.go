@@ -8,8 +8,12 @@ package supervisor
 import (
 	"fmt"
 	"io/ioutil"
+	"net"
 	"strings"
+	"time"
 
+	appsv1 "k8s.io/api/apps/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/utils/pointer"
 	"sigs.k8s.io/yaml"
 
@@ -37,6 +41,8 @@ func FromPath(path string) (*Config, error) {
 	}
 
 	maybeSetAPIGroupSuffixDefault(&config.APIGroupSuffix)
+	maybeSetTokenLifetimesDefaults(&config.TokenLifetimes)
+	maybeSetStorageDefaults(&config.Storage)
 
 	if err := validateAPIGroupSuffix(*config.APIGroupSuffix); err != nil {
 		return nil, fmt.Errorf("validate apiGroupSuffix: %w", err)
@@ -50,6 +56,22 @@ func FromPath(path string) (*Config, error) {
 		return nil, fmt.Errorf("validate log level: %w", err)
 	}
 
+	if err := validateMetrics(&config.Metrics); err != nil {
+		return nil, fmt.Errorf("validate metrics: %w", err)
+	}
+
+	if err := validateAuditLog(&config.AuditLog); err != nil {
+		return nil, fmt.Errorf("validate auditLog: %w", err)
+	}
+
+	if err := validateTokenLifetimes(&config.TokenLifetimes); err != nil {
+		return nil, fmt.Errorf("validate tokenLifetimes: %w", err)
+	}
+
+	if err := validateStorage(&config.Storage); err != nil {
+		return nil, fmt.Errorf("validate storage: %w", err)
+	}
+
 	return &config, nil
 }
 
@@ -59,6 +81,24 @@ func maybeSetAPIGroupSuffixDefault(apiGroupSuffix **string) {
 	}
 }
 
+func maybeSetTokenLifetimesDefaults(tokenLifetimes *TokenLifetimesSpec) {
+	maybeSetDurationDefault(&tokenLifetimes.MaxAccessTokenLifetime, time.Hour)
+	maybeSetDurationDefault(&tokenLifetimes.MaxAuthorizationCodeLifetime, time.Hour)
+	maybeSetDurationDefault(&tokenLifetimes.MaxRefreshTokenLifetime, 24*time.Hour)
+}
+
+func maybeSetStorageDefaults(storage *StorageSpec) {
+	if storage.Backend == "" {
+		storage.Backend = StorageBackendSecrets
+	}
+}
+
+func maybeSetDurationDefault(duration *metav1.Duration, defaultDuration time.Duration) {
+	if duration.Duration == 0 {
+		duration.Duration = defaultDuration
+	}
+}
+
 func validateAPIGroupSuffix(apiGroupSuffix string) error {
 	return groupsuffix.Validate(apiGroupSuffix)
 }
@@ -73,3 +113,79 @@ func validateNames(names *NamesConfigSpec) error {
 	}
 	return nil
 }
+
+func validateMetrics(metrics *MetricsSpec) error {
+	if metrics.ListenAddress == "" {
+		return nil
+	}
+	if _, _, err := net.SplitHostPort(metrics.ListenAddress); err != nil {
+		return fmt.Errorf("invalid listenAddress %q: %w", metrics.ListenAddress, err)
+	}
+	return nil
+}
+
+func validateAuditLog(auditLog *AuditLogSpec) error {
+	switch auditLog.Sink {
+	case AuditLogSinkNone, AuditLogSinkStdout:
+		if auditLog.FilePath != "" {
+			return constable.Error("filePath can only be used when sink is file")
+		}
+	case AuditLogSinkFile:
+		if auditLog.FilePath == "" {
+			return constable.Error("filePath is required when sink is file")
+		}
+	default:
+		return fmt.Errorf("unknown sink %q, must be stdout or file", auditLog.Sink)
+	}
+	return nil
+}
+
+func validateStorage(storage *StorageSpec) error {
+	switch storage.Backend {
+	case StorageBackendSecrets:
+		if storage.FilePath != "" {
+			return constable.Error("filePath can only be used when backend is bolt")
+		}
+	case StorageBackendBolt:
+		if storage.FilePath == "" {
+			return constable.Error("filePath is required when backend is bolt")
+		}
+	default:
+		return fmt.Errorf("unknown backend %q, must be secrets or bolt", storage.Backend)
+	}
+	return nil
+}
+
+// ValidateStorageForDeployment validates that the storage backend can be used by the Deployment of the Supervisor,
+// which is only known once the Supervisor is running. Only one process can open the database file of the bolt
+// backend at a time, so the Deployment must have a single replica, and it must stop its old pod before starting a
+// new one.
+func ValidateStorageForDeployment(storage *StorageSpec, deployment *appsv1.Deployment) error {
+	if storage.Backend != StorageBackendBolt {
+		return nil
+	}
+	if replicas := deployment.Spec.Replicas; replicas != nil && *replicas != 1 {
+		return fmt.Errorf("backend bolt requires Deployment %s to have 1 replica, but it has %d", deployment.Name, *replicas)
+	}
+	if strategy := deployment.Spec.Strategy.Type; strategy != appsv1.RecreateDeploymentStrategyType {
+		return fmt.Errorf("backend bolt requires Deployment %s to use the %s strategy, but it uses %q",
+			deployment.Name, appsv1.RecreateDeploymentStrategyType, strategy)
+	}
+	return nil
+}
+
+func validateTokenLifetimes(tokenLifetimes *TokenLifetimesSpec) error {
+	for _, lifetime := range []struct {
+		name     string
+		duration metav1.Duration
+	}{
+		{"maxAccessTokenLifetime", tokenLifetimes.MaxAccessTokenLifetime},
+		{"maxAuthorizationCodeLifetime", tokenLifetimes.MaxAuthorizationCodeLifetime},
+		{"maxRefreshTokenLifetime", tokenLifetimes.MaxRefreshTokenLifetime},
+	} {
+		if lifetime.duration.Duration < 0 {
+			return fmt.Errorf("%s must not be negative, but was %s", lifetime.name, lifetime.duration.Duration)
+		}
+	}
+	return nil
+}
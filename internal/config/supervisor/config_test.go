@@ -7,7 +7,10 @@ import (
 	"io/ioutil"
 	"os"
 	"testing"
+	"time"
 
+	appsv1 "k8s.io/api/apps/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/utils/pointer"
 
 	"github.com/stretchr/testify/require"
@@ -32,6 +35,18 @@ func TestFromPath(t *testing.T) {
 				  myLabelKey2: myLabelValue2
 				names:
 				  defaultTLSCertificateSecret: my-secret-name
+				metrics:
+				  listenAddress: 127.0.0.1:9090
+				auditLog:
+				  sink: file
+				  filePath: /var/log/pinniped/audit.log
+				tokenLifetimes:
+				  maxAccessTokenLifetime: 30m
+				  maxAuthorizationCodeLifetime: 15m
+				  maxRefreshTokenLifetime: 168h
+				storage:
+				  backend: bolt
+				  filePath: /var/lib/pinniped-supervisor/sessions.db
 			`),
 			wantConfig: &Config{
 				APIGroupSuffix: pointer.StringPtr("some.suffix.com"),
@@ -42,6 +57,22 @@ func TestFromPath(t *testing.T) {
 				NamesConfig: NamesConfigSpec{
 					DefaultTLSCertificateSecret: "my-secret-name",
 				},
+				Metrics: MetricsSpec{
+					ListenAddress: "127.0.0.1:9090",
+				},
+				AuditLog: AuditLogSpec{
+					Sink:     AuditLogSinkFile,
+					FilePath: "/var/log/pinniped/audit.log",
+				},
+				TokenLifetimes: TokenLifetimesSpec{
+					MaxAccessTokenLifetime:       metav1.Duration{Duration: 30 * time.Minute},
+					MaxAuthorizationCodeLifetime: metav1.Duration{Duration: 15 * time.Minute},
+					MaxRefreshTokenLifetime:      metav1.Duration{Duration: 168 * time.Hour},
+				},
+				Storage: StorageSpec{
+					Backend:  StorageBackendBolt,
+					FilePath: "/var/lib/pinniped-supervisor/sessions.db",
+				},
 			},
 		},
 		{
@@ -57,6 +88,14 @@ func TestFromPath(t *testing.T) {
 				NamesConfig: NamesConfigSpec{
 					DefaultTLSCertificateSecret: "my-secret-name",
 				},
+				TokenLifetimes: TokenLifetimesSpec{
+					MaxAccessTokenLifetime:       metav1.Duration{Duration: time.Hour},
+					MaxAuthorizationCodeLifetime: metav1.Duration{Duration: time.Hour},
+					MaxRefreshTokenLifetime:      metav1.Duration{Duration: 24 * time.Hour},
+				},
+				Storage: StorageSpec{
+					Backend: StorageBackendSecrets,
+				},
 			},
 		},
 		{
@@ -76,6 +115,106 @@ func TestFromPath(t *testing.T) {
 			`),
 			wantError: "validate apiGroupSuffix: a lowercase RFC 1123 subdomain must consist of lower case alphanumeric characters, '-' or '.', and must start and end with an alphanumeric character (e.g. 'example.com', regex used for validation is '[a-z0-9]([-a-z0-9]*[a-z0-9])?(\\.[a-z0-9]([-a-z0-9]*[a-z0-9])?)*')",
 		},
+		{
+			name: "invalid metrics listenAddress",
+			yaml: here.Doc(`
+				---
+				names:
+				  defaultTLSCertificateSecret: my-secret-name
+				metrics:
+				  listenAddress: not-a-host-port
+			`),
+			wantError: `validate metrics: invalid listenAddress "not-a-host-port": address not-a-host-port: missing port in address`,
+		},
+		{
+			name: "unknown auditLog sink",
+			yaml: here.Doc(`
+				---
+				names:
+				  defaultTLSCertificateSecret: my-secret-name
+				auditLog:
+				  sink: syslog
+			`),
+			wantError: `validate auditLog: unknown sink "syslog", must be stdout or file`,
+		},
+		{
+			name: "auditLog file sink without filePath",
+			yaml: here.Doc(`
+				---
+				names:
+				  defaultTLSCertificateSecret: my-secret-name
+				auditLog:
+				  sink: file
+			`),
+			wantError: "validate auditLog: filePath is required when sink is file",
+		},
+		{
+			name: "auditLog stdout sink with filePath",
+			yaml: here.Doc(`
+				---
+				names:
+				  defaultTLSCertificateSecret: my-secret-name
+				auditLog:
+				  sink: stdout
+				  filePath: /some/path
+			`),
+			wantError: "validate auditLog: filePath can only be used when sink is file",
+		},
+		{
+			name: "negative tokenLifetimes",
+			yaml: here.Doc(`
+				---
+				names:
+				  defaultTLSCertificateSecret: my-secret-name
+				tokenLifetimes:
+				  maxRefreshTokenLifetime: -1h
+			`),
+			wantError: "validate tokenLifetimes: maxRefreshTokenLifetime must not be negative, but was -1h0m0s",
+		},
+		{
+			name: "invalid tokenLifetimes",
+			yaml: here.Doc(`
+				---
+				names:
+				  defaultTLSCertificateSecret: my-secret-name
+				tokenLifetimes:
+				  maxAccessTokenLifetime: one hour
+			`),
+			wantError: `decode yaml: error unmarshaling JSON: while decoding JSON: time: invalid duration "one hour"`,
+		},
+		{
+			name: "unknown storage backend",
+			yaml: here.Doc(`
+				---
+				names:
+				  defaultTLSCertificateSecret: my-secret-name
+				storage:
+				  backend: etcd
+			`),
+			wantError: `validate storage: unknown backend "etcd", must be secrets or bolt`,
+		},
+		{
+			name: "bolt storage backend without filePath",
+			yaml: here.Doc(`
+				---
+				names:
+				  defaultTLSCertificateSecret: my-secret-name
+				storage:
+				  backend: bolt
+			`),
+			wantError: "validate storage: filePath is required when backend is bolt",
+		},
+		{
+			name: "secrets storage backend with filePath",
+			yaml: here.Doc(`
+				---
+				names:
+				  defaultTLSCertificateSecret: my-secret-name
+				storage:
+				  filePath: /var/lib/pinniped-supervisor/sessions.db
+			`),
+			wantError: "validate storage: filePath can only be used when backend is bolt",
+		},
 	}
 	for _, test := range tests {
 		test := test
@@ -104,3 +243,61 @@ func TestFromPath(t *testing.T) {
 		})
 	}
 }
+
+func TestValidateStorageForDeployment(t *testing.T) {
+	deployment := func(replicas *int32, strategy appsv1.DeploymentStrategyType) *appsv1.Deployment {
+		return &appsv1.Deployment{
+			ObjectMeta: metav1.ObjectMeta{Name: "pinniped-supervisor"},
+			Spec: appsv1.DeploymentSpec{
+				Replicas: replicas,
+				Strategy: appsv1.DeploymentStrategy{Type: strategy},
+			},
+		}
+	}
+
+	tests := []struct {
+		name       string
+		storage    StorageSpec
+		deployment *appsv1.Deployment
+		wantError  string
+	}{
+		{
+			name:       "secrets backend with many replicas",
+			storage:    StorageSpec{Backend: StorageBackendSecrets},
+			deployment: deployment(pointer.Int32Ptr(2), appsv1.RollingUpdateDeploymentStrategyType),
+		},
+		{
+			name:       "bolt backend with one replica",
+			storage:    StorageSpec{Backend: StorageBackendBolt, FilePath: "/var/lib/pinniped-supervisor/sessions.db"},
+			deployment: deployment(pointer.Int32Ptr(1), appsv1.RecreateDeploymentStrategyType),
+		},
+		{
+			name:       "bolt backend with the default number of replicas",
+			storage:    StorageSpec{Backend: StorageBackendBolt, FilePath: "/var/lib/pinniped-supervisor/sessions.db"},
+			deployment: deployment(nil, appsv1.RecreateDeploymentStrategyType),
+		},
+		{
+			name:       "bolt backend with many replicas",
+			storage:    StorageSpec{Backend: StorageBackendBolt, FilePath: "/var/lib/pinniped-supervisor/sessions.db"},
+			deployment: deployment(pointer.Int32Ptr(2), appsv1.RecreateDeploymentStrategyType),
+			wantError:  "backend bolt requires Deployment pinniped-supervisor to have 1 replica, but it has 2",
+		},
+		{
+			name:       "bolt backend with rolling updates",
+			storage:    StorageSpec{Backend: StorageBackendBolt, FilePath: "/var/lib/pinniped-supervisor/sessions.db"},
+			deployment: deployment(pointer.Int32Ptr(1), appsv1.RollingUpdateDeploymentStrategyType),
+			wantError:  `backend bolt requires Deployment pinniped-supervisor to use the Recreate strategy, but it uses "RollingUpdate"`,
+		},
+	}
+	for _, test := range tests {
+		test := test
+		t.Run(test.name, func(t *testing.T) {
+			err := ValidateStorageForDeployment(&test.storage, test.deployment)
+			if test.wantError != "" {
+				require.EqualError(t, err, test.wantError)
+			} else {
+				require.NoError(t, err)
+			}
+		})
+	}
+}
@@ -3,17 +3,89 @@
 
 package supervisor
 
-import "go.pinniped.dev/internal/plog"
+import (
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	"go.pinniped.dev/internal/plog"
+)
 
 // Config contains knobs to setup an instance of the Pinniped Supervisor.
 type Config struct {
-	APIGroupSuffix *string           `json:"apiGroupSuffix,omitempty"`
-	Labels         map[string]string `json:"labels"`
-	NamesConfig    NamesConfigSpec   `json:"names"`
-	LogLevel       plog.LogLevel     `json:"logLevel"`
+	APIGroupSuffix *string            `json:"apiGroupSuffix,omitempty"`
+	Labels         map[string]string  `json:"labels"`
+	NamesConfig    NamesConfigSpec    `json:"names"`
+	LogLevel       plog.LogLevel      `json:"logLevel"`
+	Metrics        MetricsSpec        `json:"metrics"`
+	AuditLog       AuditLogSpec       `json:"auditLog"`
+	TokenLifetimes TokenLifetimesSpec `json:"tokenLifetimes"`
+	Storage        StorageSpec        `json:"storage"`
 }
 
 // NamesConfigSpec configures the names of some Kubernetes resources for the Supervisor.
 type NamesConfigSpec struct {
 	DefaultTLSCertificateSecret string `json:"defaultTLSCertificateSecret"`
 }
+
+// MetricsSpec configures the Prometheus metrics endpoint of the Supervisor.
+type MetricsSpec struct {
+	// ListenAddress is the host:port on which /metrics is served over plain HTTP, e.g. ":9090".
+	// When empty, metrics are not served.
+	ListenAddress string `json:"listenAddress"`
+}
+
+// AuditLogSink is where the Supervisor writes its authentication audit events.
+type AuditLogSink string
+
+const (
+	// AuditLogSinkNone disables the audit log. This is the default.
+	AuditLogSinkNone = AuditLogSink("")
+
+	// AuditLogSinkStdout writes audit events to the standard output of the Supervisor, separately from its logs,
+	// which are written to standard error.
+	AuditLogSinkStdout = AuditLogSink("stdout")
+
+	// AuditLogSinkFile appends audit events to the file at AuditLogSpec.FilePath.
+	AuditLogSinkFile = AuditLogSink("file")
+)
+
+// AuditLogSpec configures the authentication audit log of the Supervisor.
+type AuditLogSpec struct {
+	Sink     AuditLogSink `json:"sink"`
+	FilePath string       `json:"filePath"`
+}
+
+// TokenLifetimesSpec configures the maximum lifetimes of the tokens issued by the FederationDomains of the Supervisor.
+// A FederationDomain which configures a longer lifetime in its spec is invalid. A FederationDomain which does not
+// configure a lifetime uses the default lifetime, or the maximum lifetime when it is shorter than the default.
+type TokenLifetimesSpec struct {
+	// MaxAccessTokenLifetime bounds the lifetime of access tokens and ID tokens, e.g. "1h". Defaults to 1 hour.
+	MaxAccessTokenLifetime metav1.Duration `json:"maxAccessTokenLifetime"`
+
+	// MaxAuthorizationCodeLifetime bounds the lifetime of authorization codes, e.g. "1h". Defaults to 1 hour.
+	MaxAuthorizationCodeLifetime metav1.Duration `json:"maxAuthorizationCodeLifetime"`
+
+	// MaxRefreshTokenLifetime bounds the lifetime of refresh tokens, and therefore the length of the sessions of the
+	// end users, e.g. "24h". Defaults to 24 hours.
+	MaxRefreshTokenLifetime metav1.Duration `json:"maxRefreshTokenLifetime"`
+}
+
+// StorageBackend is where the Supervisor stores the session data of the end users, e.g. their authorization codes
+// and refresh tokens.
+type StorageBackend string
+
+const (
+	// StorageBackendSecrets stores the session data in Secrets in the namespace of the Supervisor. This is the default.
+	StorageBackendSecrets = StorageBackend("secrets")
+
+	// StorageBackendBolt stores the session data in the bbolt database file at StorageSpec.FilePath. Only one process
+	// can open the file at a time, so the Supervisor must be deployed with a single replica and the Recreate strategy,
+	// which the Supervisor checks when it starts, and the file should be on a persistent volume so that the sessions
+	// survive restarts of the pod.
+	StorageBackendBolt = StorageBackend("bolt")
+)
+
+// StorageSpec configures the storage of the session data of the Supervisor.
+type StorageSpec struct {
+	Backend  StorageBackend `json:"backend"`
+	FilePath string         `json:"filePath"`
+}
@@ -15,6 +15,7 @@ import (
 
 	loginapi "go.pinniped.dev/generated/latest/apis/concierge/login"
 	"go.pinniped.dev/internal/constable"
+	"go.pinniped.dev/internal/metrics"
 	"go.pinniped.dev/internal/plog"
 	"go.pinniped.dev/internal/valuelesscontext"
 )
@@ -97,6 +98,8 @@ func (c *Cache) AuthenticateTokenCredentialRequest(ctx context.Context, req *log
 			"kind", key.Kind,
 			"apiGroup", key.APIGroup,
 		)
+		// Do not use the requested kind and name as labels, since they could be anything that a client sends.
+		metrics.RecordTokenCredentialRequest("", "", metrics.ResultFailure)
 		return nil, ErrNoSuchAuthenticator
 	}
 
@@ -107,11 +110,14 @@ func (c *Cache) AuthenticateTokenCredentialRequest(ctx context.Context, req *log
 	// Call the selected authenticator.
 	resp, authenticated, err := val.AuthenticateToken(ctx, req.Spec.Token)
 	if err != nil {
+		metrics.RecordTokenCredentialRequest(key.Kind, key.Name, metrics.ResultError)
 		return nil, err
 	}
 	if !authenticated {
+		metrics.RecordTokenCredentialRequest(key.Kind, key.Name, metrics.ResultFailure)
 		return nil, nil
 	}
+	metrics.RecordTokenCredentialRequest(key.Kind, key.Name, metrics.ResultSuccess)
 
 	// Return the user.Info from the response (if it is non-nil).
 	var respUser user.Info
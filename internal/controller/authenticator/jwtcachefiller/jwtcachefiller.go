@@ -6,15 +6,20 @@
 package jwtcachefiller
 
 import (
+	"context"
+	"encoding/json"
 	"fmt"
 	"io/ioutil"
 	"os"
 	"reflect"
+	"strings"
 
 	"github.com/go-logr/logr"
 	"gopkg.in/square/go-jose.v2"
+	"gopkg.in/square/go-jose.v2/jwt"
 	"k8s.io/apimachinery/pkg/api/errors"
 	"k8s.io/apiserver/pkg/authentication/authenticator"
+	"k8s.io/apiserver/pkg/authentication/user"
 	"k8s.io/apiserver/plugin/pkg/authenticator/token/oidc"
 	"k8s.io/klog/v2"
 
@@ -31,6 +36,10 @@ import (
 const (
 	defaultUsernameClaim = "username"
 	defaultGroupsClaim   = "groups"
+
+	// ExtraKeyPrefix is the prefix of the keys of the user's extra information which are mapped from the additional
+	// claims of a JWT, when the JWTAuthenticator is configured with an extra claim.
+	ExtraKeyPrefix = "claims.concierge.pinniped.dev/"
 )
 
 // defaultSupportedSigningAlgos returns the default signing algos that this JWTAuthenticator
@@ -194,3 +203,76 @@ func newJWTAuthenticator(spec *auth1alpha1.JWTAuthenticatorSpec) (*jwtAuthentica
 		spec:                     spec,
 	}, nil
 }
+
+// AuthenticateToken authenticates the JWT, and then adds its additional claims to the extra information of the user
+// when the JWTAuthenticator is configured with an extra claim.
+func (a *jwtAuthenticator) AuthenticateToken(ctx context.Context, token string) (*authenticator.Response, bool, error) {
+	response, authenticated, err := a.tokenAuthenticatorCloser.AuthenticateToken(ctx, token)
+	if err != nil || !authenticated || a.spec.Claims.Extra == "" {
+		return response, authenticated, err
+	}
+
+	extra, err := extraFromClaim(token, a.spec.Claims.Extra)
+	if err != nil {
+		return nil, false, err
+	}
+	if len(extra) == 0 {
+		return response, authenticated, nil
+	}
+	for key, values := range response.User.GetExtra() {
+		extra[key] = values
+	}
+	response.User = &user.DefaultInfo{
+		Name:   response.User.GetName(),
+		UID:    response.User.GetUID(),
+		Groups: response.User.GetGroups(),
+		Extra:  extra,
+	}
+	return response, authenticated, nil
+}
+
+// extraFromClaim returns the extra information of the user for each of the additional claims in the object which is
+// the value of the named claim of the JWT. The JWT must have already been verified.
+func extraFromClaim(token string, claimName string) (map[string][]string, error) {
+	parsed, err := jwt.ParseSigned(token)
+	if err != nil {
+		return nil, fmt.Errorf("could not parse JWT: %w", err)
+	}
+	var claims map[string]interface{}
+	if err := parsed.UnsafeClaimsWithoutVerification(&claims); err != nil {
+		return nil, fmt.Errorf("could not parse JWT claims: %w", err)
+	}
+
+	value, ok := claims[claimName]
+	if !ok {
+		return nil, nil // the issuer may have omitted the claim if there are no additional claims
+	}
+	additionalClaims, ok := value.(map[string]interface{})
+	if !ok {
+		return nil, fmt.Errorf("extra claim %q is not an object", claimName)
+	}
+
+	extra := make(map[string][]string, len(additionalClaims))
+	for name, value := range additionalClaims {
+		extra[ExtraKeyPrefix+strings.ToLower(name)] = extraValues(value)
+	}
+	return extra, nil
+}
+
+// extraValues returns the value of an additional claim as a list of strings. Each string, or string in a list, is
+// used as is, and any other value is encoded as JSON.
+func extraValues(value interface{}) []string {
+	switch v := value.(type) {
+	case string:
+		return []string{v}
+	case []interface{}:
+		values := make([]string, 0, len(v))
+		for _, element := range v {
+			values = append(values, extraValues(element)...)
+		}
+		return values
+	default:
+		encoded, _ := json.Marshal(v)
+		return []string{string(encoded)}
+	}
+}
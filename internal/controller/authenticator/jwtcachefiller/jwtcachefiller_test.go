@@ -446,6 +446,129 @@ func TestController(t *testing.T) {
 	}
 }
 
+func TestJWTAuthenticatorExtra(t *testing.T) {
+	t.Parallel()
+
+	signingKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+
+	innerResponse := func() *authenticator.Response {
+		return &authenticator.Response{
+			User: &user.DefaultInfo{
+				Name:   "some-username",
+				UID:    "some-uid",
+				Groups: []string{"some-group"},
+				Extra:  map[string][]string{"some-key": {"some-value"}},
+			},
+		}
+	}
+
+	tests := []struct {
+		name              string
+		extraClaim        string
+		claims            map[string]interface{}
+		innerAuthErr      error
+		innerAuthFailed   bool
+		wantResponse      *authenticator.Response
+		wantAuthenticated bool
+		wantErr           string
+	}{
+		{
+			name:              "extra claim is not configured",
+			claims:            map[string]interface{}{"additionalClaims": map[string]interface{}{"email": "joe@example.com"}},
+			wantResponse:      innerResponse(),
+			wantAuthenticated: true,
+		},
+		{
+			name:       "extra claim is configured and present",
+			extraClaim: "additionalClaims",
+			claims: map[string]interface{}{"additionalClaims": map[string]interface{}{
+				"Email":       "joe@example.com",
+				"departments": []string{"sales", "marketing"},
+				"level":       7,
+			}},
+			wantResponse: &authenticator.Response{
+				User: &user.DefaultInfo{
+					Name:   "some-username",
+					UID:    "some-uid",
+					Groups: []string{"some-group"},
+					Extra: map[string][]string{
+						"some-key":                                  {"some-value"},
+						"claims.concierge.pinniped.dev/email":       {"joe@example.com"},
+						"claims.concierge.pinniped.dev/departments": {"sales", "marketing"},
+						"claims.concierge.pinniped.dev/level":       {"7"},
+					},
+				},
+			},
+			wantAuthenticated: true,
+		},
+		{
+			name:              "extra claim is configured but not present",
+			extraClaim:        "additionalClaims",
+			claims:            map[string]interface{}{"other": "value"},
+			wantResponse:      innerResponse(),
+			wantAuthenticated: true,
+		},
+		{
+			name:       "extra claim is configured but is not an object",
+			extraClaim: "additionalClaims",
+			claims:     map[string]interface{}{"additionalClaims": "not-an-object"},
+			wantErr:    `extra claim "additionalClaims" is not an object`,
+		},
+		{
+			name:            "token is not authenticated by the inner authenticator",
+			extraClaim:      "additionalClaims",
+			claims:          map[string]interface{}{"additionalClaims": map[string]interface{}{"email": "joe@example.com"}},
+			innerAuthFailed: true,
+		},
+		{
+			name:         "inner authenticator returns an error",
+			extraClaim:   "additionalClaims",
+			claims:       map[string]interface{}{"additionalClaims": map[string]interface{}{"email": "joe@example.com"}},
+			innerAuthErr: fmt.Errorf("some error"),
+			wantErr:      "some error",
+		},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			sig, err := jose.NewSigner(jose.SigningKey{Algorithm: jose.ES256, Key: signingKey}, (&jose.SignerOptions{}).WithType("JWT"))
+			require.NoError(t, err)
+			token, err := jwt.Signed(sig).Claims(tt.claims).CompactSerialize()
+			require.NoError(t, err)
+
+			ctrl := gomock.NewController(t)
+			t.Cleanup(ctrl.Finish)
+			tokenAuthenticatorCloser := mocktokenauthenticatorcloser.NewMockTokenAuthenticatorCloser(ctrl)
+			switch {
+			case tt.innerAuthErr != nil:
+				tokenAuthenticatorCloser.EXPECT().AuthenticateToken(gomock.Any(), token).Return(nil, false, tt.innerAuthErr)
+			case tt.innerAuthFailed:
+				tokenAuthenticatorCloser.EXPECT().AuthenticateToken(gomock.Any(), token).Return(nil, false, nil)
+			default:
+				tokenAuthenticatorCloser.EXPECT().AuthenticateToken(gomock.Any(), token).Return(innerResponse(), true, nil)
+			}
+
+			a := &jwtAuthenticator{
+				tokenAuthenticatorCloser: tokenAuthenticatorCloser,
+				spec:                     &auth1alpha1.JWTAuthenticatorSpec{Claims: auth1alpha1.JWTTokenClaims{Extra: tt.extraClaim}},
+			}
+			rsp, authenticated, err := a.AuthenticateToken(context.Background(), token)
+			if tt.wantErr != "" {
+				require.EqualError(t, err, tt.wantErr)
+				require.Nil(t, rsp)
+				require.False(t, authenticated)
+				return
+			}
+			require.NoError(t, err)
+			require.Equal(t, tt.wantResponse, rsp)
+			require.Equal(t, tt.wantAuthenticated, authenticated)
+		})
+	}
+}
+
 // isNotInitialized checks if the error is the internally-defined "oidc: authenticator not initialized" error from
 // the underlying OIDC authenticator, which is initialized asynchronously.
 func isNotInitialized(err error) bool {
@@ -0,0 +1,244 @@
+// Copyright 2021 the Pinniped contributors. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+// Package activedirectoryupstreamwatcher implements a controller which watches ActiveDirectoryIdentityProviders.
+package activedirectoryupstreamwatcher
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/go-ldap/ldap/v3"
+	"k8s.io/apimachinery/pkg/api/equality"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	corev1informers "k8s.io/client-go/informers/core/v1"
+	"k8s.io/klog/v2/klogr"
+
+	"go.pinniped.dev/generated/latest/apis/supervisor/idp/v1alpha1"
+	pinnipedclientset "go.pinniped.dev/generated/latest/client/supervisor/clientset/versioned"
+	idpinformers "go.pinniped.dev/generated/latest/client/supervisor/informers/externalversions/idp/v1alpha1"
+	pinnipedcontroller "go.pinniped.dev/internal/controller"
+	"go.pinniped.dev/internal/controller/conditionsutil"
+	"go.pinniped.dev/internal/controller/supervisorconfig/upstreamwatchers"
+	"go.pinniped.dev/internal/controllerlib"
+	"go.pinniped.dev/internal/oidc/provider"
+	"go.pinniped.dev/internal/upstreamad"
+	"go.pinniped.dev/internal/upstreamldap"
+)
+
+const (
+	activeDirectoryControllerName = "active-directory-upstream-observer"
+)
+
+// UpstreamActiveDirectoryIdentityProviderICache is a thread safe cache that holds a list of validated upstream Active
+// Directory IDP configurations.
+type UpstreamActiveDirectoryIdentityProviderICache interface {
+	SetActiveDirectoryIdentityProviders([]provider.UpstreamLDAPIdentityProviderI)
+}
+
+type activeDirectoryWatcherController struct {
+	cache                                   UpstreamActiveDirectoryIdentityProviderICache
+	validatedSecretVersionsCache            *upstreamwatchers.SecretVersionCache
+	ldapDialer                              upstreamldap.LDAPDialer
+	client                                  pinnipedclientset.Interface
+	activeDirectoryIdentityProviderInformer idpinformers.ActiveDirectoryIdentityProviderInformer
+	secretInformer                          corev1informers.SecretInformer
+}
+
+// activeDirectoryUpstreamGenericLDAPImpl adapts an ActiveDirectoryIdentityProvider for the validations which are
+// shared with other LDAP-like upstream IDPs.
+type activeDirectoryUpstreamGenericLDAPImpl struct {
+	activeDirectoryIdentityProvider *v1alpha1.ActiveDirectoryIdentityProvider
+}
+
+var _ upstreamwatchers.UpstreamGenericLDAPIDP = &activeDirectoryUpstreamGenericLDAPImpl{}
+
+func (g *activeDirectoryUpstreamGenericLDAPImpl) Name() string {
+	return g.activeDirectoryIdentityProvider.Name
+}
+
+func (g *activeDirectoryUpstreamGenericLDAPImpl) Generation() int64 {
+	return g.activeDirectoryIdentityProvider.Generation
+}
+
+func (g *activeDirectoryUpstreamGenericLDAPImpl) Namespace() string {
+	return g.activeDirectoryIdentityProvider.Namespace
+}
+
+func (g *activeDirectoryUpstreamGenericLDAPImpl) TLSSpec() *v1alpha1.TLSSpec {
+	return g.activeDirectoryIdentityProvider.Spec.TLS
+}
+
+func (g *activeDirectoryUpstreamGenericLDAPImpl) BindSecretName() string {
+	return g.activeDirectoryIdentityProvider.Spec.Bind.SecretName
+}
+
+func (g *activeDirectoryUpstreamGenericLDAPImpl) AllowsClientCertificateBind() bool {
+	return false
+}
+
+func (g *activeDirectoryUpstreamGenericLDAPImpl) Conditions() []v1alpha1.Condition {
+	return g.activeDirectoryIdentityProvider.Status.Conditions
+}
+
+// New instantiates a new controllerlib.Controller which will populate the provided UpstreamActiveDirectoryIdentityProviderICache.
+func New(
+	idpCache UpstreamActiveDirectoryIdentityProviderICache,
+	client pinnipedclientset.Interface,
+	activeDirectoryIdentityProviderInformer idpinformers.ActiveDirectoryIdentityProviderInformer,
+	secretInformer corev1informers.SecretInformer,
+	withInformer pinnipedcontroller.WithInformerOptionFunc,
+) controllerlib.Controller {
+	return newInternal(
+		idpCache,
+		// start with an empty secretVersionCache
+		upstreamwatchers.NewSecretVersionCache(),
+		// nil means to use a real production dialer when creating objects to add to the cache
+		nil,
+		client,
+		activeDirectoryIdentityProviderInformer,
+		secretInformer,
+		withInformer,
+	)
+}
+
+// For test dependency injection purposes.
+func newInternal(
+	idpCache UpstreamActiveDirectoryIdentityProviderICache,
+	validatedSecretVersionsCache *upstreamwatchers.SecretVersionCache,
+	ldapDialer upstreamldap.LDAPDialer,
+	client pinnipedclientset.Interface,
+	activeDirectoryIdentityProviderInformer idpinformers.ActiveDirectoryIdentityProviderInformer,
+	secretInformer corev1informers.SecretInformer,
+	withInformer pinnipedcontroller.WithInformerOptionFunc,
+) controllerlib.Controller {
+	c := activeDirectoryWatcherController{
+		cache:                                   idpCache,
+		validatedSecretVersionsCache:            validatedSecretVersionsCache,
+		ldapDialer:                              ldapDialer,
+		client:                                  client,
+		activeDirectoryIdentityProviderInformer: activeDirectoryIdentityProviderInformer,
+		secretInformer:                          secretInformer,
+	}
+	return controllerlib.New(
+		controllerlib.Config{Name: activeDirectoryControllerName, Syncer: &c},
+		withInformer(
+			activeDirectoryIdentityProviderInformer,
+			pinnipedcontroller.MatchAnythingFilter(pinnipedcontroller.SingletonQueue()),
+			controllerlib.InformerOption{},
+		),
+		withInformer(
+			secretInformer,
+			pinnipedcontroller.MatchAnySecretOfTypeFilter(upstreamwatchers.LDAPBindAccountSecretType, pinnipedcontroller.SingletonQueue()),
+			controllerlib.InformerOption{},
+		),
+	)
+}
+
+// Sync implements controllerlib.Syncer.
+func (c *activeDirectoryWatcherController) Sync(ctx controllerlib.Context) error {
+	actualUpstreams, err := c.activeDirectoryIdentityProviderInformer.Lister().List(labels.Everything())
+	if err != nil {
+		return fmt.Errorf("failed to list ActiveDirectoryIdentityProviders: %w", err)
+	}
+
+	requeue := false
+	validatedUpstreams := make([]provider.UpstreamLDAPIdentityProviderI, 0, len(actualUpstreams))
+	for _, upstream := range actualUpstreams {
+		valid, requestedRequeue := c.validateUpstream(ctx.Context, upstream)
+		if valid != nil {
+			validatedUpstreams = append(validatedUpstreams, valid)
+		}
+		if requestedRequeue {
+			requeue = true
+		}
+	}
+
+	c.cache.SetActiveDirectoryIdentityProviders(validatedUpstreams)
+
+	if requeue {
+		return controllerlib.ErrSyntheticRequeue
+	}
+	return nil
+}
+
+func (c *activeDirectoryWatcherController) validateUpstream(ctx context.Context, upstream *v1alpha1.ActiveDirectoryIdentityProvider) (p provider.UpstreamLDAPIdentityProviderI, requeue bool) {
+	spec := upstream.Spec
+
+	config := &upstreamldap.ProviderConfig{
+		Name:               upstream.Name,
+		Host:               spec.Host,
+		FailoverHosts:      spec.FailoverHosts,
+		SRVDomain:          spec.SRVDomain,
+		ConnectionPoolSize: upstreamldap.DefaultConnectionPoolSize,
+		UserSearch: upstreamldap.UserSearchConfig{
+			Base:                    spec.UserSearch.Base,
+			Filter:                  defaultIfEmpty(spec.UserSearch.Filter, upstreamad.DefaultUserSearchFilter),
+			UsernameAttribute:       defaultIfEmpty(spec.UserSearch.Attributes.Username, upstreamad.DefaultUsernameAttributeName),
+			UIDAttribute:            defaultIfEmpty(spec.UserSearch.Attributes.UID, upstreamad.DefaultUIDAttributeName),
+			AdditionalClaimMappings: spec.UserSearch.Attributes.AdditionalClaimMappings,
+		},
+		GroupSearch: upstreamldap.GroupSearchConfig{
+			Base:               spec.GroupSearch.Base,
+			Filter:             defaultIfEmpty(spec.GroupSearch.Filter, upstreamad.DefaultGroupSearchFilter),
+			GroupNameAttribute: defaultIfEmpty(spec.GroupSearch.Attributes.GroupName, upstreamad.DefaultGroupNameAttributeName),
+		},
+		Dialer: c.ldapDialer,
+		UIDAttributeParsingOverrides: map[string]func(*ldap.Entry) (string, error){
+			upstreamad.ObjectGUIDAttributeName: upstreamad.ObjectGUIDAsString,
+		},
+		GroupAttributeParsingOverrides: map[string]func(*ldap.Entry) (string, error){
+			upstreamad.SAMAccountNameAttributeName: upstreamad.GroupSAMAccountNameWithDomainSuffix,
+		},
+		UserAttributeChecks: map[string]func(*ldap.Entry) error{
+			upstreamad.UserAccountControlAttributeName: upstreamad.ValidUserAccountControl,
+		},
+	}
+
+	conditions, loadable, requeue := upstreamwatchers.ValidateGenericLDAP(
+		ctx,
+		&activeDirectoryUpstreamGenericLDAPImpl{activeDirectoryIdentityProvider: upstream},
+		c.secretInformer,
+		c.validatedSecretVersionsCache,
+		config,
+	)
+
+	c.updateStatus(ctx, upstream, conditions)
+
+	if !loadable {
+		return nil, requeue
+	}
+	return upstreamldap.New(*config), requeue
+}
+
+func (c *activeDirectoryWatcherController) updateStatus(ctx context.Context, upstream *v1alpha1.ActiveDirectoryIdentityProvider, conditions []*v1alpha1.Condition) {
+	log := klogr.New().WithValues("namespace", upstream.Namespace, "name", upstream.Name)
+	updated := upstream.DeepCopy()
+
+	hadErrorCondition := conditionsutil.Merge(conditions, upstream.Generation, &updated.Status.Conditions, log)
+
+	updated.Status.Phase = v1alpha1.ActiveDirectoryPhaseReady
+	if hadErrorCondition {
+		updated.Status.Phase = v1alpha1.ActiveDirectoryPhaseError
+	}
+
+	if equality.Semantic.DeepEqual(upstream, updated) {
+		return // nothing to update
+	}
+
+	_, err := c.client.
+		IDPV1alpha1().
+		ActiveDirectoryIdentityProviders(upstream.Namespace).
+		UpdateStatus(ctx, updated, metav1.UpdateOptions{})
+	if err != nil {
+		log.Error(err, "failed to update status")
+	}
+}
+
+func defaultIfEmpty(value, defaultValue string) string {
+	if len(value) == 0 {
+		return defaultValue
+	}
+	return value
+}
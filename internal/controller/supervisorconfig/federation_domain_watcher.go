@@ -8,6 +8,7 @@ import (
 	"fmt"
 	"net/url"
 	"strings"
+	"time"
 
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/labels"
@@ -21,6 +22,8 @@ import (
 	configinformers "go.pinniped.dev/generated/latest/client/supervisor/informers/externalversions/config/v1alpha1"
 	pinnipedcontroller "go.pinniped.dev/internal/controller"
 	"go.pinniped.dev/internal/controllerlib"
+	"go.pinniped.dev/internal/idtransform"
+	"go.pinniped.dev/internal/oidc"
 	"go.pinniped.dev/internal/oidc/provider"
 	"go.pinniped.dev/internal/plog"
 )
@@ -34,6 +37,7 @@ type ProvidersSetter interface {
 
 type federationDomainWatcherController struct {
 	providerSetter           ProvidersSetter
+	maxTokenLifetimes        provider.TokenLifetimes
 	clock                    clock.Clock
 	client                   pinnipedclientset.Interface
 	federationDomainInformer configinformers.FederationDomainInformer
@@ -41,8 +45,11 @@ type federationDomainWatcherController struct {
 
 // NewFederationDomainWatcherController creates a controllerlib.Controller that watches
 // FederationDomain objects and notifies a callback object of the collection of provider configs.
+// FederationDomains which configure token lifetimes longer than the maxTokenLifetimes are invalid.
+// A zero maximum lifetime means that there is no maximum.
 func NewFederationDomainWatcherController(
 	providerSetter ProvidersSetter,
+	maxTokenLifetimes provider.TokenLifetimes,
 	clock clock.Clock,
 	client pinnipedclientset.Interface,
 	federationDomainInformer configinformers.FederationDomainInformer,
@@ -53,6 +60,7 @@ func NewFederationDomainWatcherController(
 			Name: "FederationDomainWatcherController",
 			Syncer: &federationDomainWatcherController{
 				providerSetter:           providerSetter,
+				maxTokenLifetimes:        maxTokenLifetimes,
 				clock:                    clock,
 				client:                   client,
 				federationDomainInformer: federationDomainInformer,
@@ -111,6 +119,8 @@ func (c *federationDomainWatcherController) Sync(ctx controllerlib.Context) erro
 
 	federationDomainIssuers := make([]*provider.FederationDomainIssuer, 0)
 	for _, federationDomain := range federationDomains {
+		var federationDomainIssuer *provider.FederationDomainIssuer
+
 		issuerURL, urlParseErr := url.Parse(federationDomain.Spec.Issuer)
 
 		// Skip url parse errors because they will be validated below.
@@ -143,7 +153,22 @@ func (c *federationDomainWatcherController) Sync(ctx controllerlib.Context) erro
 			continue
 		}
 
-		federationDomainIssuer, err := provider.NewFederationDomainIssuer(federationDomain.Spec.Issuer) // This validates the Issuer URL.
+		policy, err := idtransform.NewPolicy(federationDomain.Spec.Policy)
+		if err != nil {
+			err = fmt.Errorf("invalid policy: %w", err)
+		}
+		var identityProviders []provider.FederationDomainIdentityProvider
+		if err == nil {
+			identityProviders, err = federationDomainIdentityProviders(federationDomain.Spec.IdentityProviders, policy)
+		}
+		var tokenLifetimes provider.TokenLifetimes
+		if err == nil {
+			tokenLifetimes, err = federationDomainTokenLifetimes(federationDomain.Spec.Tokens, c.maxTokenLifetimes)
+		}
+		if err == nil {
+			// This validates the Issuer URL.
+			federationDomainIssuer, err = provider.NewFederationDomainIssuer(federationDomain.Spec.Issuer, identityProviders, policy, tokenLifetimes)
+		}
 		if err != nil {
 			if err := c.updateStatus(
 				ctx.Context,
@@ -176,6 +201,82 @@ func (c *federationDomainWatcherController) Sync(ctx controllerlib.Context) erro
 	return errors.NewAggregate(errs)
 }
 
+func federationDomainIdentityProviders(
+	specIDPs []configv1alpha1.FederationDomainIdentityProvider,
+	policy *idtransform.Policy,
+) ([]provider.FederationDomainIdentityProvider, error) {
+	var identityProviders []provider.FederationDomainIdentityProvider
+	for _, specIDP := range specIDPs {
+		var idpType string
+		switch specIDP.Kind {
+		case "OIDCIdentityProvider":
+			idpType = oidc.IDPTypeOIDC
+		case "LDAPIdentityProvider":
+			idpType = oidc.IDPTypeLDAP
+		case "ActiveDirectoryIdentityProvider":
+			idpType = oidc.IDPTypeActiveDirectory
+		default:
+			return nil, fmt.Errorf("identity provider %q has unsupported kind %q", specIDP.Name, specIDP.Kind)
+		}
+		transforms, err := idtransform.NewPipeline(specIDP.Transforms, policy)
+		if err != nil {
+			return nil, fmt.Errorf("identity provider %q has invalid transforms: %w", specIDP.Name, err)
+		}
+		identityProviders = append(identityProviders, provider.FederationDomainIdentityProvider{
+			Name:       specIDP.Name,
+			Type:       idpType,
+			Transforms: transforms,
+		})
+	}
+	return identityProviders, nil
+}
+
+// federationDomainTokenLifetimes returns the token lifetimes configured by the spec of a FederationDomain. A lifetime
+// which is not configured is the default lifetime, or the maximum lifetime when it is shorter than the default.
+func federationDomainTokenLifetimes(
+	specTokens *configv1alpha1.FederationDomainTokensSpec,
+	maxTokenLifetimes provider.TokenLifetimes,
+) (provider.TokenLifetimes, error) {
+	if specTokens == nil {
+		specTokens = &configv1alpha1.FederationDomainTokensSpec{}
+	}
+
+	var tokenLifetimes provider.TokenLifetimes
+	var err error
+	if tokenLifetimes.AccessToken, err = tokenLifetime(
+		"accessTokenLifetime", specTokens.AccessTokenLifetime, oidc.DefaultAccessTokenLifespan, maxTokenLifetimes.AccessToken,
+	); err != nil {
+		return provider.TokenLifetimes{}, err
+	}
+	if tokenLifetimes.AuthorizationCode, err = tokenLifetime(
+		"authorizationCodeLifetime", specTokens.AuthorizationCodeLifetime, oidc.DefaultAuthorizeCodeLifespan, maxTokenLifetimes.AuthorizationCode,
+	); err != nil {
+		return provider.TokenLifetimes{}, err
+	}
+	if tokenLifetimes.RefreshToken, err = tokenLifetime(
+		"refreshTokenLifetime", specTokens.RefreshTokenLifetime, oidc.DefaultRefreshTokenLifespan, maxTokenLifetimes.RefreshToken,
+	); err != nil {
+		return provider.TokenLifetimes{}, err
+	}
+	return tokenLifetimes, nil
+}
+
+func tokenLifetime(fieldName string, specLifetime *metav1.Duration, defaultLifetime, maxLifetime time.Duration) (time.Duration, error) {
+	if specLifetime == nil {
+		if maxLifetime > 0 && maxLifetime < defaultLifetime {
+			return maxLifetime, nil
+		}
+		return defaultLifetime, nil
+	}
+	if specLifetime.Duration <= 0 {
+		return 0, fmt.Errorf("tokens.%s must be positive, but was %s", fieldName, specLifetime.Duration)
+	}
+	if maxLifetime > 0 && specLifetime.Duration > maxLifetime {
+		return 0, fmt.Errorf("tokens.%s %s exceeds the maximum of %s configured for the Supervisor", fieldName, specLifetime.Duration, maxLifetime)
+	}
+	return specLifetime.Duration, nil
+}
+
 func (c *federationDomainWatcherController) updateStatus(
 	ctx context.Context,
 	namespace, name string,
@@ -44,6 +44,7 @@ func TestInformerFilters(t *testing.T) {
 			federationDomainInformer := pinnipedinformers.NewSharedInformerFactoryWithOptions(nil, 0).Config().V1alpha1().FederationDomains()
 			_ = NewFederationDomainWatcherController(
 				nil,
+				provider.TokenLifetimes{},
 				nil,
 				nil,
 				federationDomainInformer,
@@ -110,12 +111,24 @@ func TestSync(t *testing.T) {
 		var providersSetter *fakeProvidersSetter
 		var federationDomainGVR schema.GroupVersionResource
 
+		maxTokenLifetimes := provider.TokenLifetimes{
+			AccessToken:       time.Hour,
+			AuthorizationCode: time.Hour,
+			RefreshToken:      24 * time.Hour,
+		}
+		defaultTokenLifetimes := provider.TokenLifetimes{
+			AccessToken:       2 * time.Minute,
+			AuthorizationCode: 10 * time.Minute,
+			RefreshToken:      9 * time.Hour,
+		}
+
 		// Defer starting the informers until the last possible moment so that the
 		// nested Before's can keep adding things to the informer caches.
 		var startInformersAndController = func() {
 			// Set this at the last second to allow for injection of server override.
 			subject = NewFederationDomainWatcherController(
 				providersSetter,
+				maxTokenLifetimes,
 				clock.NewFakeClock(frozenNow),
 				pinnipedAPIClient,
 				federationDomainInformers.Config().V1alpha1().FederationDomains(),
@@ -187,10 +200,10 @@ func TestSync(t *testing.T) {
 				err := controllerlib.TestSync(t, subject, *syncContext)
 				r.NoError(err)
 
-				provider1, err := provider.NewFederationDomainIssuer(federationDomain1.Spec.Issuer)
+				provider1, err := provider.NewFederationDomainIssuer(federationDomain1.Spec.Issuer, nil, nil, defaultTokenLifetimes)
 				r.NoError(err)
 
-				provider2, err := provider.NewFederationDomainIssuer(federationDomain2.Spec.Issuer)
+				provider2, err := provider.NewFederationDomainIssuer(federationDomain2.Spec.Issuer, nil, nil, defaultTokenLifetimes)
 				r.NoError(err)
 
 				r.True(providersSetter.SetProvidersWasCalled)
@@ -288,10 +301,10 @@ func TestSync(t *testing.T) {
 					err := controllerlib.TestSync(t, subject, *syncContext)
 					r.NoError(err)
 
-					provider1, err := provider.NewFederationDomainIssuer(federationDomain1.Spec.Issuer)
+					provider1, err := provider.NewFederationDomainIssuer(federationDomain1.Spec.Issuer, nil, nil, defaultTokenLifetimes)
 					r.NoError(err)
 
-					provider2, err := provider.NewFederationDomainIssuer(federationDomain2.Spec.Issuer)
+					provider2, err := provider.NewFederationDomainIssuer(federationDomain2.Spec.Issuer, nil, nil, defaultTokenLifetimes)
 					r.NoError(err)
 
 					r.True(providersSetter.SetProvidersWasCalled)
@@ -326,10 +339,10 @@ func TestSync(t *testing.T) {
 					err := controllerlib.TestSync(t, subject, *syncContext)
 					r.EqualError(err, "could not update status: some update error")
 
-					provider1, err := provider.NewFederationDomainIssuer(federationDomain1.Spec.Issuer)
+					provider1, err := provider.NewFederationDomainIssuer(federationDomain1.Spec.Issuer, nil, nil, defaultTokenLifetimes)
 					r.NoError(err)
 
-					provider2, err := provider.NewFederationDomainIssuer(federationDomain2.Spec.Issuer)
+					provider2, err := provider.NewFederationDomainIssuer(federationDomain2.Spec.Issuer, nil, nil, defaultTokenLifetimes)
 					r.NoError(err)
 
 					r.True(providersSetter.SetProvidersWasCalled)
@@ -545,7 +558,7 @@ func TestSync(t *testing.T) {
 				err := controllerlib.TestSync(t, subject, *syncContext)
 				r.NoError(err)
 
-				validProvider, err := provider.NewFederationDomainIssuer(validFederationDomain.Spec.Issuer)
+				validProvider, err := provider.NewFederationDomainIssuer(validFederationDomain.Spec.Issuer, nil, nil, defaultTokenLifetimes)
 				r.NoError(err)
 
 				r.True(providersSetter.SetProvidersWasCalled)
@@ -619,7 +632,7 @@ func TestSync(t *testing.T) {
 					err := controllerlib.TestSync(t, subject, *syncContext)
 					r.EqualError(err, "could not update status: some update error")
 
-					validProvider, err := provider.NewFederationDomainIssuer(validFederationDomain.Spec.Issuer)
+					validProvider, err := provider.NewFederationDomainIssuer(validFederationDomain.Spec.Issuer, nil, nil, defaultTokenLifetimes)
 					r.NoError(err)
 
 					r.True(providersSetter.SetProvidersWasCalled)
@@ -673,6 +686,412 @@ func TestSync(t *testing.T) {
 			})
 		})
 
+		when("there are FederationDomains which choose their identity providers in the informer", func() {
+			var (
+				validFederationDomain   *v1alpha1.FederationDomain
+				invalidFederationDomain *v1alpha1.FederationDomain
+			)
+
+			it.Before(func() {
+				validFederationDomain = &v1alpha1.FederationDomain{
+					ObjectMeta: metav1.ObjectMeta{Name: "valid-config", Namespace: namespace},
+					Spec: v1alpha1.FederationDomainSpec{
+						Issuer: "https://valid-issuer.com",
+						IdentityProviders: []v1alpha1.FederationDomainIdentityProvider{
+							{Kind: "OIDCIdentityProvider", Name: "some-oidc-idp"},
+							{Kind: "LDAPIdentityProvider", Name: "some-ldap-idp"},
+							{Kind: "ActiveDirectoryIdentityProvider", Name: "some-ad-idp"},
+						},
+					},
+				}
+				r.NoError(pinnipedAPIClient.Tracker().Add(validFederationDomain))
+				r.NoError(federationDomainInformerClient.Tracker().Add(validFederationDomain))
+
+				invalidFederationDomain = &v1alpha1.FederationDomain{
+					ObjectMeta: metav1.ObjectMeta{Name: "invalid-config", Namespace: namespace},
+					Spec: v1alpha1.FederationDomainSpec{
+						Issuer: "https://invalid-issuer.com",
+						IdentityProviders: []v1alpha1.FederationDomainIdentityProvider{
+							{Kind: "SomeOtherKind", Name: "some-other-idp"},
+						},
+					},
+				}
+				r.NoError(pinnipedAPIClient.Tracker().Add(invalidFederationDomain))
+				r.NoError(federationDomainInformerClient.Tracker().Add(invalidFederationDomain))
+			})
+
+			it("calls the ProvidersSetter with the valid provider and its identity providers", func() {
+				startInformersAndController()
+				err := controllerlib.TestSync(t, subject, *syncContext)
+				r.NoError(err)
+
+				validProvider, err := provider.NewFederationDomainIssuer(
+					validFederationDomain.Spec.Issuer,
+					[]provider.FederationDomainIdentityProvider{
+						{Type: "oidc", Name: "some-oidc-idp"},
+						{Type: "ldap", Name: "some-ldap-idp"},
+						{Type: "activedirectory", Name: "some-ad-idp"},
+					},
+					nil,
+					defaultTokenLifetimes,
+				)
+				r.NoError(err)
+
+				r.True(providersSetter.SetProvidersWasCalled)
+				r.Equal(
+					[]*provider.FederationDomainIssuer{
+						validProvider,
+					},
+					providersSetter.FederationDomainsReceived,
+				)
+			})
+
+			it("updates the status to success/invalid in the FederationDomains", func() {
+				startInformersAndController()
+				err := controllerlib.TestSync(t, subject, *syncContext)
+				r.NoError(err)
+
+				validFederationDomain.Status.Status = v1alpha1.SuccessFederationDomainStatusCondition
+				validFederationDomain.Status.Message = "Provider successfully created"
+				validFederationDomain.Status.LastUpdateTime = timePtr(metav1.NewTime(frozenNow))
+
+				invalidFederationDomain.Status.Status = v1alpha1.InvalidFederationDomainStatusCondition
+				invalidFederationDomain.Status.Message = `Invalid: identity provider "some-other-idp" has unsupported kind "SomeOtherKind"`
+				invalidFederationDomain.Status.LastUpdateTime = timePtr(metav1.NewTime(frozenNow))
+
+				expectedActions := []coretesting.Action{
+					coretesting.NewGetAction(
+						federationDomainGVR,
+						invalidFederationDomain.Namespace,
+						invalidFederationDomain.Name,
+					),
+					coretesting.NewUpdateSubresourceAction(
+						federationDomainGVR,
+						"status",
+						invalidFederationDomain.Namespace,
+						invalidFederationDomain,
+					),
+					coretesting.NewGetAction(
+						federationDomainGVR,
+						validFederationDomain.Namespace,
+						validFederationDomain.Name,
+					),
+					coretesting.NewUpdateSubresourceAction(
+						federationDomainGVR,
+						"status",
+						validFederationDomain.Namespace,
+						validFederationDomain,
+					),
+				}
+				r.ElementsMatch(expectedActions, pinnipedAPIClient.Actions())
+			})
+		})
+
+		when("there are FederationDomains which transform the identities of their identity providers in the informer", func() {
+			var (
+				validFederationDomain   *v1alpha1.FederationDomain
+				invalidFederationDomain *v1alpha1.FederationDomain
+			)
+
+			it.Before(func() {
+				validFederationDomain = &v1alpha1.FederationDomain{
+					ObjectMeta: metav1.ObjectMeta{Name: "valid-config", Namespace: namespace},
+					Spec: v1alpha1.FederationDomainSpec{
+						Issuer: "https://valid-issuer.com",
+						IdentityProviders: []v1alpha1.FederationDomainIdentityProvider{
+							{Kind: "OIDCIdentityProvider", Name: "some-oidc-idp", Transforms: []v1alpha1.FederationDomainTransform{
+								{Type: v1alpha1.GroupsPrefixFederationDomainTransformType, Prefix: "okta:"},
+								{Type: v1alpha1.RequireGroupFederationDomainTransformType, Groups: []string{"okta:k8s-users"}},
+							}},
+							{Kind: "LDAPIdentityProvider", Name: "some-ldap-idp"},
+						},
+					},
+				}
+				r.NoError(pinnipedAPIClient.Tracker().Add(validFederationDomain))
+				r.NoError(federationDomainInformerClient.Tracker().Add(validFederationDomain))
+
+				invalidFederationDomain = &v1alpha1.FederationDomain{
+					ObjectMeta: metav1.ObjectMeta{Name: "invalid-config", Namespace: namespace},
+					Spec: v1alpha1.FederationDomainSpec{
+						Issuer: "https://invalid-issuer.com",
+						IdentityProviders: []v1alpha1.FederationDomainIdentityProvider{
+							{Kind: "OIDCIdentityProvider", Name: "some-oidc-idp", Transforms: []v1alpha1.FederationDomainTransform{
+								{Type: v1alpha1.UsernameRegexReplaceFederationDomainTransformType, Regex: "("},
+							}},
+						},
+					},
+				}
+				r.NoError(pinnipedAPIClient.Tracker().Add(invalidFederationDomain))
+				r.NoError(federationDomainInformerClient.Tracker().Add(invalidFederationDomain))
+			})
+
+			it("calls the ProvidersSetter with the valid provider and its identity transforms", func() {
+				startInformersAndController()
+				err := controllerlib.TestSync(t, subject, *syncContext)
+				r.NoError(err)
+
+				r.True(providersSetter.SetProvidersWasCalled)
+				r.Len(providersSetter.FederationDomainsReceived, 1)
+				validProvider := providersSetter.FederationDomainsReceived[0]
+				r.Equal("https://valid-issuer.com", validProvider.Issuer())
+				r.Nil(validProvider.IdentityTransforms("ldap", "some-ldap-idp"))
+
+				transforms := validProvider.IdentityTransforms("oidc", "some-oidc-idp")
+				r.NotNil(transforms)
+				r.Equal([]string{"okta:k8s-users", "okta:other"}, transforms.Evaluate("some-user", []string{"k8s-users", "other"}, nil).Groups)
+				r.True(transforms.Evaluate("some-user", []string{"other"}, nil).Rejected)
+			})
+
+			it("updates the status to success/invalid in the FederationDomains", func() {
+				startInformersAndController()
+				err := controllerlib.TestSync(t, subject, *syncContext)
+				r.NoError(err)
+
+				validFederationDomain.Status.Status = v1alpha1.SuccessFederationDomainStatusCondition
+				validFederationDomain.Status.Message = "Provider successfully created"
+				validFederationDomain.Status.LastUpdateTime = timePtr(metav1.NewTime(frozenNow))
+
+				invalidFederationDomain.Status.Status = v1alpha1.InvalidFederationDomainStatusCondition
+				invalidFederationDomain.Status.Message = "Invalid: identity provider \"some-oidc-idp\" has invalid transforms: " +
+					"transform 0 of type \"UsernameRegexReplace\" is invalid: could not compile regex: error parsing regexp: missing closing ): `(`"
+				invalidFederationDomain.Status.LastUpdateTime = timePtr(metav1.NewTime(frozenNow))
+
+				expectedActions := []coretesting.Action{
+					coretesting.NewGetAction(
+						federationDomainGVR,
+						invalidFederationDomain.Namespace,
+						invalidFederationDomain.Name,
+					),
+					coretesting.NewUpdateSubresourceAction(
+						federationDomainGVR,
+						"status",
+						invalidFederationDomain.Namespace,
+						invalidFederationDomain,
+					),
+					coretesting.NewGetAction(
+						federationDomainGVR,
+						validFederationDomain.Namespace,
+						validFederationDomain.Name,
+					),
+					coretesting.NewUpdateSubresourceAction(
+						federationDomainGVR,
+						"status",
+						validFederationDomain.Namespace,
+						validFederationDomain,
+					),
+				}
+				r.ElementsMatch(expectedActions, pinnipedAPIClient.Actions())
+			})
+		})
+
+		when("there are FederationDomains which have login policies in the informer", func() {
+			var (
+				validFederationDomain   *v1alpha1.FederationDomain
+				invalidFederationDomain *v1alpha1.FederationDomain
+			)
+
+			it.Before(func() {
+				validFederationDomain = &v1alpha1.FederationDomain{
+					ObjectMeta: metav1.ObjectMeta{Name: "valid-config", Namespace: namespace},
+					Spec: v1alpha1.FederationDomainSpec{
+						Issuer: "https://valid-issuer.com",
+						IdentityProviders: []v1alpha1.FederationDomainIdentityProvider{
+							{Kind: "OIDCIdentityProvider", Name: "some-oidc-idp", Transforms: []v1alpha1.FederationDomainTransform{
+								{Type: v1alpha1.UsernamePrefixFederationDomainTransformType, Prefix: "okta:"},
+							}},
+							{Kind: "LDAPIdentityProvider", Name: "some-ldap-idp"},
+						},
+						Policy: &v1alpha1.FederationDomainPolicy{
+							GroupRules: []v1alpha1.FederationDomainGroupRule{
+								{
+									Match:  &v1alpha1.FederationDomainPolicyMatch{UsernameRegex: "^okta:"},
+									Groups: []string{"from-okta"},
+								},
+							},
+							DenyRules: []v1alpha1.FederationDomainDenyRule{
+								{
+									Match:   v1alpha1.FederationDomainPolicyMatch{NotInAnyGroup: []string{"from-okta", "k8s-users"}},
+									Message: "not allowed",
+								},
+							},
+						},
+					},
+				}
+				r.NoError(pinnipedAPIClient.Tracker().Add(validFederationDomain))
+				r.NoError(federationDomainInformerClient.Tracker().Add(validFederationDomain))
+
+				invalidFederationDomain = &v1alpha1.FederationDomain{
+					ObjectMeta: metav1.ObjectMeta{Name: "invalid-config", Namespace: namespace},
+					Spec: v1alpha1.FederationDomainSpec{
+						Issuer: "https://invalid-issuer.com",
+						Policy: &v1alpha1.FederationDomainPolicy{
+							DenyRules: []v1alpha1.FederationDomainDenyRule{
+								{Match: v1alpha1.FederationDomainPolicyMatch{UsernameRegex: "@contractor\\.com$"}},
+								{Match: v1alpha1.FederationDomainPolicyMatch{UsernameRegex: "@contractor.com("}},
+							},
+						},
+					},
+				}
+				r.NoError(pinnipedAPIClient.Tracker().Add(invalidFederationDomain))
+				r.NoError(federationDomainInformerClient.Tracker().Add(invalidFederationDomain))
+			})
+
+			it("calls the ProvidersSetter with the valid provider, whose identity transforms include the policy", func() {
+				startInformersAndController()
+				err := controllerlib.TestSync(t, subject, *syncContext)
+				r.NoError(err)
+
+				r.True(providersSetter.SetProvidersWasCalled)
+				r.Len(providersSetter.FederationDomainsReceived, 1)
+				validProvider := providersSetter.FederationDomainsReceived[0]
+				r.Equal("https://valid-issuer.com", validProvider.Issuer())
+
+				oidcTransforms := validProvider.IdentityTransforms("oidc", "some-oidc-idp")
+				r.Equal([]string{"from-okta"}, oidcTransforms.Evaluate("some-user", nil, nil).Groups)
+
+				ldapTransforms := validProvider.IdentityTransforms("ldap", "some-ldap-idp")
+				r.False(ldapTransforms.Evaluate("some-user", []string{"k8s-users"}, nil).Rejected)
+				result := ldapTransforms.Evaluate("some-user", []string{"other"}, nil)
+				r.True(result.Rejected)
+				r.Equal("not allowed", result.RejectedReason)
+			})
+
+			it("updates the status to success/invalid in the FederationDomains", func() {
+				startInformersAndController()
+				err := controllerlib.TestSync(t, subject, *syncContext)
+				r.NoError(err)
+
+				validFederationDomain.Status.Status = v1alpha1.SuccessFederationDomainStatusCondition
+				validFederationDomain.Status.Message = "Provider successfully created"
+				validFederationDomain.Status.LastUpdateTime = timePtr(metav1.NewTime(frozenNow))
+
+				invalidFederationDomain.Status.Status = v1alpha1.InvalidFederationDomainStatusCondition
+				invalidFederationDomain.Status.Message = "Invalid: invalid policy: deny rule 1 is invalid: could not compile usernameRegex: error parsing regexp: missing closing ): `@contractor.com(`"
+				invalidFederationDomain.Status.LastUpdateTime = timePtr(metav1.NewTime(frozenNow))
+
+				expectedActions := []coretesting.Action{
+					coretesting.NewGetAction(
+						federationDomainGVR,
+						invalidFederationDomain.Namespace,
+						invalidFederationDomain.Name,
+					),
+					coretesting.NewUpdateSubresourceAction(
+						federationDomainGVR,
+						"status",
+						invalidFederationDomain.Namespace,
+						invalidFederationDomain,
+					),
+					coretesting.NewGetAction(
+						federationDomainGVR,
+						validFederationDomain.Namespace,
+						validFederationDomain.Name,
+					),
+					coretesting.NewUpdateSubresourceAction(
+						federationDomainGVR,
+						"status",
+						validFederationDomain.Namespace,
+						validFederationDomain,
+					),
+				}
+				r.ElementsMatch(expectedActions, pinnipedAPIClient.Actions())
+			})
+		})
+
+		when("there are FederationDomains which configure token lifetimes in the informer", func() {
+			var (
+				validFederationDomain   *v1alpha1.FederationDomain
+				invalidFederationDomain *v1alpha1.FederationDomain
+			)
+
+			it.Before(func() {
+				validFederationDomain = &v1alpha1.FederationDomain{
+					ObjectMeta: metav1.ObjectMeta{Name: "valid-config", Namespace: namespace},
+					Spec: v1alpha1.FederationDomainSpec{
+						Issuer: "https://valid-issuer.com",
+						Tokens: &v1alpha1.FederationDomainTokensSpec{
+							AccessTokenLifetime:  &metav1.Duration{Duration: 5 * time.Minute},
+							RefreshTokenLifetime: &metav1.Duration{Duration: 24 * time.Hour},
+						},
+					},
+				}
+				r.NoError(pinnipedAPIClient.Tracker().Add(validFederationDomain))
+				r.NoError(federationDomainInformerClient.Tracker().Add(validFederationDomain))
+
+				invalidFederationDomain = &v1alpha1.FederationDomain{
+					ObjectMeta: metav1.ObjectMeta{Name: "invalid-config", Namespace: namespace},
+					Spec: v1alpha1.FederationDomainSpec{
+						Issuer: "https://invalid-issuer.com",
+						Tokens: &v1alpha1.FederationDomainTokensSpec{
+							RefreshTokenLifetime: &metav1.Duration{Duration: 48 * time.Hour},
+						},
+					},
+				}
+				r.NoError(pinnipedAPIClient.Tracker().Add(invalidFederationDomain))
+				r.NoError(federationDomainInformerClient.Tracker().Add(invalidFederationDomain))
+			})
+
+			it("calls the ProvidersSetter with the valid provider and its token lifetimes", func() {
+				startInformersAndController()
+				err := controllerlib.TestSync(t, subject, *syncContext)
+				r.NoError(err)
+
+				validProvider, err := provider.NewFederationDomainIssuer(validFederationDomain.Spec.Issuer, nil, nil, provider.TokenLifetimes{
+					AccessToken:       5 * time.Minute,
+					AuthorizationCode: 10 * time.Minute,
+					RefreshToken:      24 * time.Hour,
+				})
+				r.NoError(err)
+
+				r.True(providersSetter.SetProvidersWasCalled)
+				r.Equal(
+					[]*provider.FederationDomainIssuer{
+						validProvider,
+					},
+					providersSetter.FederationDomainsReceived,
+				)
+			})
+
+			it("updates the status to success/invalid in the FederationDomains", func() {
+				startInformersAndController()
+				err := controllerlib.TestSync(t, subject, *syncContext)
+				r.NoError(err)
+
+				validFederationDomain.Status.Status = v1alpha1.SuccessFederationDomainStatusCondition
+				validFederationDomain.Status.Message = "Provider successfully created"
+				validFederationDomain.Status.LastUpdateTime = timePtr(metav1.NewTime(frozenNow))
+
+				invalidFederationDomain.Status.Status = v1alpha1.InvalidFederationDomainStatusCondition
+				invalidFederationDomain.Status.Message = "Invalid: tokens.refreshTokenLifetime 48h0m0s exceeds the maximum of 24h0m0s configured for the Supervisor"
+				invalidFederationDomain.Status.LastUpdateTime = timePtr(metav1.NewTime(frozenNow))
+
+				expectedActions := []coretesting.Action{
+					coretesting.NewGetAction(
+						federationDomainGVR,
+						invalidFederationDomain.Namespace,
+						invalidFederationDomain.Name,
+					),
+					coretesting.NewUpdateSubresourceAction(
+						federationDomainGVR,
+						"status",
+						invalidFederationDomain.Namespace,
+						invalidFederationDomain,
+					),
+					coretesting.NewGetAction(
+						federationDomainGVR,
+						validFederationDomain.Namespace,
+						validFederationDomain.Name,
+					),
+					coretesting.NewUpdateSubresourceAction(
+						federationDomainGVR,
+						"status",
+						validFederationDomain.Namespace,
+						validFederationDomain,
+					),
+				}
+				r.ElementsMatch(expectedActions, pinnipedAPIClient.Actions())
+			})
+		})
+
 		when("there are FederationDomains with duplicate issuer names in the informer", func() {
 			var (
 				federationDomainDuplicate1 *v1alpha1.FederationDomain
@@ -709,7 +1128,7 @@ func TestSync(t *testing.T) {
 				err := controllerlib.TestSync(t, subject, *syncContext)
 				r.NoError(err)
 
-				nonDuplicateProvider, err := provider.NewFederationDomainIssuer(federationDomain.Spec.Issuer)
+				nonDuplicateProvider, err := provider.NewFederationDomainIssuer(federationDomain.Spec.Issuer, nil, nil, defaultTokenLifetimes)
 				r.NoError(err)
 
 				r.True(providersSetter.SetProvidersWasCalled)
@@ -882,7 +1301,7 @@ func TestSync(t *testing.T) {
 				err := controllerlib.TestSync(t, subject, *syncContext)
 				r.NoError(err)
 
-				nonDuplicateProvider, err := provider.NewFederationDomainIssuer(federationDomainDifferentIssuerAddress.Spec.Issuer)
+				nonDuplicateProvider, err := provider.NewFederationDomainIssuer(federationDomainDifferentIssuerAddress.Spec.Issuer, nil, nil, defaultTokenLifetimes)
 				r.NoError(err)
 
 				r.True(providersSetter.SetProvidersWasCalled)
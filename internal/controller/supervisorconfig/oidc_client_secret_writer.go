@@ -0,0 +1,396 @@
+// Copyright 2021 the Pinniped contributors. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package supervisorconfig
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"golang.org/x/crypto/bcrypt"
+	corev1 "k8s.io/api/core/v1"
+	k8serrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	corev1informers "k8s.io/client-go/informers/core/v1"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/util/retry"
+	"k8s.io/klog/v2"
+
+	configv1alpha1 "go.pinniped.dev/generated/latest/apis/supervisor/config/v1alpha1"
+	pinnipedclientset "go.pinniped.dev/generated/latest/client/supervisor/clientset/versioned"
+	configinformers "go.pinniped.dev/generated/latest/client/supervisor/informers/externalversions/config/v1alpha1"
+	pinnipedcontroller "go.pinniped.dev/internal/controller"
+	"go.pinniped.dev/internal/controllerlib"
+	"go.pinniped.dev/internal/plog"
+)
+
+// These constants are the keys in an OIDCClient's Secret's Data map.
+const (
+	// oidcClientSecretKey points to the plaintext client secret, which the admin supplies by creating or updating the
+	// OIDCClient's Secret. The controller replaces it with its bcrypt hash, so the plaintext is never kept.
+	//
+	// Note! The value for this key is a credential!
+	oidcClientSecretKey = "clientSecret"
+	// oidcClientSecretHashKey points to the bcrypt hash of the client secret, which is used to authenticate the client.
+	oidcClientSecretHashKey = "clientSecretHash"
+
+	oidcClientSecretTypeValue corev1.SecretType = "secrets.pinniped.dev/oidc-client-secret"
+)
+
+const (
+	oidcClientKind = "OIDCClient"
+
+	// oidcClientSecretNameSuffix is appended to the name of an OIDCClient to get the name of its Secret.
+	oidcClientSecretNameSuffix = "-client-secret"
+
+	// oidcClientPendingSecretNameSuffix is appended to the name of an OIDCClient to get the name of the Secret which
+	// holds the hash of the client secret while the Secret of the OIDCClient is recreated with another type, so that
+	// the client secret is not lost when the recreation fails half way.
+	oidcClientPendingSecretNameSuffix = "-client-secret-pending"
+
+	// oidcClientSecretBcryptCost is the bcrypt cost used to hash client secrets. It is the same as the default
+	// cost used by Fosite.
+	oidcClientSecretBcryptCost = 12
+)
+
+type oidcClientSecretWriterController struct {
+	secretLabels       map[string]string
+	pinnipedClient     pinnipedclientset.Interface
+	kubeClient         kubernetes.Interface
+	oidcClientInformer configinformers.OIDCClientInformer
+	secretInformer     corev1informers.SecretInformer
+}
+
+// NewOIDCClientSecretWriterController returns a controllerlib.Controller that hashes the client secret which the admin
+// supplies in an OIDCClient's Secret. It replaces the plaintext client secret in the Secret with its bcrypt hash, so
+// that reading the Secret does not reveal the credential.
+func NewOIDCClientSecretWriterController(
+	secretLabels map[string]string,
+	kubeClient kubernetes.Interface,
+	pinnipedClient pinnipedclientset.Interface,
+	secretInformer corev1informers.SecretInformer,
+	oidcClientInformer configinformers.OIDCClientInformer,
+	withInformer pinnipedcontroller.WithInformerOptionFunc,
+) controllerlib.Controller {
+	return controllerlib.New(
+		controllerlib.Config{
+			Name: "oidc-client-secret-writer-controller",
+			Syncer: &oidcClientSecretWriterController{
+				secretLabels:       secretLabels,
+				kubeClient:         kubeClient,
+				pinnipedClient:     pinnipedClient,
+				secretInformer:     secretInformer,
+				oidcClientInformer: oidcClientInformer,
+			},
+		},
+		// We want to be notified when an OIDCClient's secret gets supplied, updated or deleted. When this happens, we
+		// should get notified via the corresponding OIDCClient key.
+		withInformer(
+			secretInformer,
+			pinnipedcontroller.SimpleFilter(
+				func(obj metav1.Object) bool { return isOIDCClientSecret(obj) || isSuppliedOIDCClientSecret(obj) },
+				oidcClientSecretParentFunc,
+			),
+			controllerlib.InformerOption{},
+		),
+		// We want to be notified when anything happens to an OIDCClient.
+		withInformer(
+			oidcClientInformer,
+			pinnipedcontroller.MatchAnythingFilter(nil), // nil parent func is fine because each event is distinct
+			controllerlib.InformerOption{},
+		),
+	)
+}
+
+// Sync implements controllerlib.Syncer.
+func (c *oidcClientSecretWriterController) Sync(ctx controllerlib.Context) error {
+	oidcClient, err := c.oidcClientInformer.Lister().OIDCClients(ctx.Key.Namespace).Get(ctx.Key.Name)
+	notFound := k8serrors.IsNotFound(err)
+	if err != nil && !notFound {
+		return fmt.Errorf(
+			"failed to get %s/%s OIDCClient: %w",
+			ctx.Key.Namespace,
+			ctx.Key.Name,
+			err,
+		)
+	}
+
+	if notFound {
+		// The corresponding secret to this OIDCClient should have been garbage collected since it should have
+		// had this OIDCClient as its owner.
+		plog.Debug(
+			"OIDCClient deleted",
+			"oidcclient",
+			klog.KRef(ctx.Key.Namespace, ctx.Key.Name),
+		)
+		return nil
+	}
+
+	secretName := oidcClientSecretName(oidcClient)
+	secret, err := c.secretInformer.Lister().Secrets(oidcClient.Namespace).Get(secretName)
+	if k8serrors.IsNotFound(err) {
+		restored, err := c.restorePendingSecret(ctx.Context, oidcClient)
+		if err != nil {
+			return fmt.Errorf("cannot restore secret: %w", err)
+		}
+		if restored {
+			// The informer will tell about the restored Secret, which will update the status of the OIDCClient.
+			plog.Debug("restored client secret hash from pending secret", "oidcclient", klog.KObj(oidcClient))
+			return nil
+		}
+		plog.Debug(
+			"waiting for the admin to supply the client secret",
+			"oidcclient",
+			klog.KObj(oidcClient),
+			"secretName",
+			secretName,
+		)
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("cannot get secret: %w", err)
+	}
+
+	if clientSecret := secret.Data[oidcClientSecretKey]; len(clientSecret) > 0 {
+		// The admin supplied a new client secret, so replace it with its hash.
+		hashedSecret, err := c.hashedSecret(oidcClient, clientSecret)
+		if err != nil {
+			return fmt.Errorf("cannot hash client secret: %w", err)
+		}
+		if err := c.replaceSecret(ctx.Context, secret, hashedSecret); err != nil {
+			return fmt.Errorf("cannot replace secret: %w", err)
+		}
+		plog.Debug("replaced client secret with its hash", "secret", klog.KObj(hashedSecret))
+	} else if !isValidOIDCClientSecret(secret) {
+		plog.Debug(
+			"secret contains neither a client secret nor a valid hash of one",
+			"oidcclient",
+			klog.KObj(oidcClient),
+			"secretName",
+			secretName,
+		)
+		return nil
+	}
+
+	// Ensure that the OIDCClient points to the secret.
+	if oidcClient.Status.ClientSecret.Name == secretName {
+		plog.Debug(
+			"secret is up to date",
+			"oidcclient",
+			klog.KRef(ctx.Key.Namespace, ctx.Key.Name),
+		)
+		return nil
+	}
+	if err := c.updateOIDCClientStatus(ctx.Context, oidcClient.Namespace, oidcClient.Name, secretName); err != nil {
+		return fmt.Errorf("cannot update OIDCClient: %w", err)
+	}
+	plog.Debug("updated OIDCClient", "oidcclient", klog.KObj(oidcClient))
+
+	return nil
+}
+
+// hashedSecret returns the Secret which holds only the bcrypt hash of the client secret.
+func (c *oidcClientSecretWriterController) hashedSecret(oidcClient *configv1alpha1.OIDCClient, clientSecret []byte) (*corev1.Secret, error) {
+	hash, err := bcrypt.GenerateFromPassword(clientSecret, oidcClientSecretBcryptCost)
+	if err != nil {
+		return nil, err
+	}
+
+	s := corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      oidcClientSecretName(oidcClient),
+			Namespace: oidcClient.Namespace,
+			Labels:    c.secretLabels,
+			OwnerReferences: []metav1.OwnerReference{
+				*metav1.NewControllerRef(oidcClient, schema.GroupVersionKind{
+					Group:   configv1alpha1.SchemeGroupVersion.Group,
+					Version: configv1alpha1.SchemeGroupVersion.Version,
+					Kind:    oidcClientKind,
+				}),
+			},
+		},
+		Data: map[string][]byte{
+			oidcClientSecretHashKey: hash,
+		},
+		Type: oidcClientSecretTypeValue,
+	}
+
+	return &s, nil
+}
+
+// replaceSecret replaces the Secret which holds the plaintext client secret with the one which holds its hash. The
+// preconditions make sure that a client secret which the admin supplied in the meantime is not lost.
+func (c *oidcClientSecretWriterController) replaceSecret(
+	ctx context.Context,
+	oldSecret *corev1.Secret,
+	newSecret *corev1.Secret,
+) error {
+	secretClient := c.kubeClient.CoreV1().Secrets(newSecret.Namespace)
+
+	if oldSecret.Type == newSecret.Type {
+		newSecret.ResourceVersion = oldSecret.ResourceVersion
+		if _, err := secretClient.Update(ctx, newSecret, metav1.UpdateOptions{}); err != nil {
+			return fmt.Errorf("cannot update secret: %w", err)
+		}
+		return nil
+	}
+
+	// The type of a Secret cannot be changed, so a Secret which the admin created with another type is recreated.
+	// The hash is written to the pending Secret first, so that restorePendingSecret can finish the job when the
+	// Secret was deleted but could not be created again.
+	pendingSecret := newSecret.DeepCopy()
+	pendingSecret.Name = strings.TrimSuffix(newSecret.Name, oidcClientSecretNameSuffix) + oidcClientPendingSecretNameSuffix
+	if err := c.writeSecret(ctx, pendingSecret); err != nil {
+		return fmt.Errorf("cannot write pending secret: %w", err)
+	}
+	if err := secretClient.Delete(ctx, oldSecret.Name, metav1.DeleteOptions{
+		Preconditions: &metav1.Preconditions{UID: &oldSecret.UID, ResourceVersion: &oldSecret.ResourceVersion},
+	}); err != nil {
+		return fmt.Errorf("cannot delete secret: %w", err)
+	}
+	if _, err := secretClient.Create(ctx, newSecret, metav1.CreateOptions{}); err != nil {
+		return fmt.Errorf("cannot create secret: %w", err)
+	}
+	if err := secretClient.Delete(ctx, pendingSecret.Name, metav1.DeleteOptions{}); err != nil && !k8serrors.IsNotFound(err) {
+		return fmt.Errorf("cannot delete pending secret: %w", err)
+	}
+	return nil
+}
+
+// restorePendingSecret creates the Secret of the OIDCClient from its pending Secret, when replaceSecret deleted the
+// Secret but could not create it again. It returns whether there was a pending Secret to restore.
+func (c *oidcClientSecretWriterController) restorePendingSecret(ctx context.Context, oidcClient *configv1alpha1.OIDCClient) (bool, error) {
+	pendingSecret, err := c.secretInformer.Lister().Secrets(oidcClient.Namespace).Get(oidcClient.Name + oidcClientPendingSecretNameSuffix)
+	if k8serrors.IsNotFound(err) {
+		return false, nil
+	}
+	if err != nil {
+		return false, fmt.Errorf("cannot get pending secret: %w", err)
+	}
+	if !isOIDCClientSecret(pendingSecret) || !isValidOIDCClientSecret(pendingSecret) {
+		return false, nil
+	}
+
+	secret := &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:            oidcClientSecretName(oidcClient),
+			Namespace:       pendingSecret.Namespace,
+			Labels:          pendingSecret.Labels,
+			OwnerReferences: pendingSecret.OwnerReferences,
+		},
+		Data: pendingSecret.Data,
+		Type: pendingSecret.Type,
+	}
+	secretClient := c.kubeClient.CoreV1().Secrets(secret.Namespace)
+	if _, err := secretClient.Create(ctx, secret, metav1.CreateOptions{}); err != nil {
+		return false, fmt.Errorf("cannot create secret: %w", err)
+	}
+	if err := secretClient.Delete(ctx, pendingSecret.Name, metav1.DeleteOptions{}); err != nil && !k8serrors.IsNotFound(err) {
+		return false, fmt.Errorf("cannot delete pending secret: %w", err)
+	}
+	return true, nil
+}
+
+// writeSecret creates the Secret, or updates it when it already exists.
+func (c *oidcClientSecretWriterController) writeSecret(ctx context.Context, secret *corev1.Secret) error {
+	secretClient := c.kubeClient.CoreV1().Secrets(secret.Namespace)
+	_, err := secretClient.Create(ctx, secret, metav1.CreateOptions{})
+	if !k8serrors.IsAlreadyExists(err) {
+		return err
+	}
+	return retry.RetryOnConflict(retry.DefaultRetry, func() error {
+		existing, err := secretClient.Get(ctx, secret.Name, metav1.GetOptions{})
+		if err != nil {
+			return err
+		}
+		updated := secret.DeepCopy()
+		updated.ResourceVersion = existing.ResourceVersion
+		_, err = secretClient.Update(ctx, updated, metav1.UpdateOptions{})
+		return err
+	})
+}
+
+func (c *oidcClientSecretWriterController) updateOIDCClientStatus(
+	ctx context.Context,
+	namespace, name string,
+	secretName string,
+) error {
+	oidcClientClient := c.pinnipedClient.ConfigV1alpha1().OIDCClients(namespace)
+	return retry.RetryOnConflict(retry.DefaultRetry, func() error {
+		oldOIDCClient, err := oidcClientClient.Get(ctx, name, metav1.GetOptions{})
+		if err != nil {
+			return fmt.Errorf("cannot get OIDCClient: %w", err)
+		}
+
+		if oldOIDCClient.Status.ClientSecret.Name == secretName {
+			// If the existing OIDCClient is up to date, we don't need to update it.
+			return nil
+		}
+
+		oldOIDCClient.Status.ClientSecret.Name = secretName
+		_, err = oidcClientClient.UpdateStatus(ctx, oldOIDCClient, metav1.UpdateOptions{})
+		return err
+	})
+}
+
+// oidcClientSecretName returns the name of the Secret which holds the client secret for the OIDCClient.
+func oidcClientSecretName(oidcClient *configv1alpha1.OIDCClient) string {
+	return oidcClient.Name + oidcClientSecretNameSuffix
+}
+
+// oidcClientSecretParentFunc returns the key of the OIDCClient of a Secret which matches isOIDCClientSecret or
+// isSuppliedOIDCClientSecret.
+func oidcClientSecretParentFunc(obj metav1.Object) controllerlib.Key {
+	if controller := metav1.GetControllerOf(obj); controller != nil && isOIDCClientSecret(obj) {
+		return controllerlib.Key{Namespace: obj.GetNamespace(), Name: controller.Name}
+	}
+	return controllerlib.Key{Namespace: obj.GetNamespace(), Name: strings.TrimSuffix(obj.GetName(), oidcClientSecretNameSuffix)}
+}
+
+// isOIDCClientSecret returns whether the provided obj is a client secret Secret controlled by an OIDCClient.
+func isOIDCClientSecret(obj metav1.Object) bool {
+	secret, ok := obj.(*corev1.Secret)
+	if !ok {
+		return false
+	}
+	if secret.Type != oidcClientSecretTypeValue {
+		return false
+	}
+	controller := metav1.GetControllerOf(secret)
+	return controller != nil &&
+		controller.APIVersion == configv1alpha1.SchemeGroupVersion.String() &&
+		controller.Kind == oidcClientKind
+}
+
+// isSuppliedOIDCClientSecret returns whether the provided obj is a Secret in which the admin supplied the client secret
+// of an OIDCClient, which has not been hashed yet.
+func isSuppliedOIDCClientSecret(obj metav1.Object) bool {
+	secret, ok := obj.(*corev1.Secret)
+	if !ok {
+		return false
+	}
+	return strings.HasSuffix(secret.Name, oidcClientSecretNameSuffix) && len(secret.Data[oidcClientSecretKey]) > 0
+}
+
+// isValidOIDCClientSecret returns whether the provided secret contains a valid bcrypt hash of a client secret.
+func isValidOIDCClientSecret(secret *corev1.Secret) bool {
+	if secret.Type != oidcClientSecretTypeValue {
+		plog.Debug("secret does not have the expected type", "expectedType", oidcClientSecretTypeValue, "actualType", secret.Type)
+		return false
+	}
+
+	hash, ok := secret.Data[oidcClientSecretHashKey]
+	if !ok {
+		plog.Debug("secret does not contain client secret hash")
+		return false
+	}
+
+	if _, err := bcrypt.Cost(hash); err != nil {
+		plog.Debug("secret contains invalid client secret hash", "err", err)
+		return false
+	}
+
+	return true
+}
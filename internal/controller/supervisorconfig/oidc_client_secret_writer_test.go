@@ -0,0 +1,591 @@
+// Copyright 2021 the Pinniped contributors. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package supervisorconfig
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"golang.org/x/crypto/bcrypt"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	kubeinformers "k8s.io/client-go/informers"
+	kubernetesfake "k8s.io/client-go/kubernetes/fake"
+	kubetesting "k8s.io/client-go/testing"
+
+	configv1alpha1 "go.pinniped.dev/generated/latest/apis/supervisor/config/v1alpha1"
+	pinnipedfake "go.pinniped.dev/generated/latest/client/supervisor/clientset/versioned/fake"
+	pinnipedinformers "go.pinniped.dev/generated/latest/client/supervisor/informers/externalversions"
+	"go.pinniped.dev/internal/controllerlib"
+	"go.pinniped.dev/internal/testutil"
+)
+
+func TestOIDCClientSecretWriterControllerFilterSecret(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name       string
+		secret     metav1.Object
+		wantAdd    bool
+		wantUpdate bool
+		wantDelete bool
+		wantParent controllerlib.Key
+	}{
+		{
+			name: "no owner reference",
+			secret: &corev1.Secret{
+				Type:       "secrets.pinniped.dev/oidc-client-secret",
+				ObjectMeta: metav1.ObjectMeta{Name: "some-name", Namespace: "some-namespace"},
+			},
+		},
+		{
+			name: "owner reference without correct APIVersion",
+			secret: &corev1.Secret{
+				Type: "secrets.pinniped.dev/oidc-client-secret",
+				ObjectMeta: metav1.ObjectMeta{
+					Name:      "some-name",
+					Namespace: "some-namespace",
+					OwnerReferences: []metav1.OwnerReference{
+						{Kind: "OIDCClient", Name: "some-client", Controller: boolPtr(true)},
+					},
+				},
+			},
+		},
+		{
+			name: "owner reference without controller set to true",
+			secret: &corev1.Secret{
+				Type: "secrets.pinniped.dev/oidc-client-secret",
+				ObjectMeta: metav1.ObjectMeta{
+					Name:      "some-name",
+					Namespace: "some-namespace",
+					OwnerReferences: []metav1.OwnerReference{
+						{APIVersion: configv1alpha1.SchemeGroupVersion.String(), Kind: "OIDCClient", Name: "some-client"},
+					},
+				},
+			},
+		},
+		{
+			name: "wrong type",
+			secret: &corev1.Secret{
+				Type: "some-other-type",
+				ObjectMeta: metav1.ObjectMeta{
+					Name:      "some-name",
+					Namespace: "some-namespace",
+					OwnerReferences: []metav1.OwnerReference{
+						{APIVersion: configv1alpha1.SchemeGroupVersion.String(), Kind: "OIDCClient", Name: "some-client", Controller: boolPtr(true)},
+					},
+				},
+			},
+		},
+		{
+			name: "correct owner reference and type",
+			secret: &corev1.Secret{
+				Type: "secrets.pinniped.dev/oidc-client-secret",
+				ObjectMeta: metav1.ObjectMeta{
+					Name:      "some-name",
+					Namespace: "some-namespace",
+					OwnerReferences: []metav1.OwnerReference{
+						{APIVersion: configv1alpha1.SchemeGroupVersion.String(), Kind: "OIDCClient", Name: "some-client", Controller: boolPtr(true)},
+					},
+				},
+			},
+			wantAdd:    true,
+			wantUpdate: true,
+			wantDelete: true,
+			wantParent: controllerlib.Key{Namespace: "some-namespace", Name: "some-client"},
+		},
+		{
+			name: "client secret supplied by the admin",
+			secret: &corev1.Secret{
+				ObjectMeta: metav1.ObjectMeta{Name: "some-client-client-secret", Namespace: "some-namespace"},
+				Data:       map[string][]byte{"clientSecret": []byte("some-client-secret")},
+			},
+			wantAdd:    true,
+			wantUpdate: true,
+			wantDelete: true,
+			wantParent: controllerlib.Key{Namespace: "some-namespace", Name: "some-client"},
+		},
+		{
+			name: "Secret with the name of a client secret without a client secret",
+			secret: &corev1.Secret{
+				ObjectMeta: metav1.ObjectMeta{Name: "some-client-client-secret", Namespace: "some-namespace"},
+				Data:       map[string][]byte{"someOtherKey": []byte("some-value")},
+			},
+		},
+		{
+			name:   "not a secret",
+			secret: &corev1.ConfigMap{},
+		},
+	}
+	for _, test := range tests {
+		test := test
+		t.Run(test.name, func(t *testing.T) {
+			t.Parallel()
+
+			secretInformer := kubeinformers.NewSharedInformerFactory(
+				kubernetesfake.NewSimpleClientset(),
+				0,
+			).Core().V1().Secrets()
+			oidcClientInformer := pinnipedinformers.NewSharedInformerFactory(
+				pinnipedfake.NewSimpleClientset(),
+				0,
+			).Config().V1alpha1().OIDCClients()
+			withInformer := testutil.NewObservableWithInformerOption()
+			_ = NewOIDCClientSecretWriterController(
+				nil, // labels, not needed
+				nil, // kubeClient, not needed
+				nil, // pinnipedClient, not needed
+				secretInformer,
+				oidcClientInformer,
+				withInformer.WithInformer,
+			)
+
+			unrelated := corev1.Secret{}
+			filter := withInformer.GetFilterForInformer(secretInformer)
+			require.Equal(t, test.wantAdd, filter.Add(test.secret))
+			require.Equal(t, test.wantUpdate, filter.Update(&unrelated, test.secret))
+			require.Equal(t, test.wantUpdate, filter.Update(test.secret, &unrelated))
+			require.Equal(t, test.wantDelete, filter.Delete(test.secret))
+			if test.wantAdd || test.wantUpdate || test.wantDelete {
+				require.Equal(t, test.wantParent, filter.Parent(test.secret))
+			}
+		})
+	}
+}
+
+func TestOIDCClientSecretWriterControllerSync(t *testing.T) {
+	t.Parallel()
+
+	const (
+		namespace        = "tuna-namespace"
+		someClientSecret = "some-client-secret"
+	)
+
+	oidcClientGVR := schema.GroupVersionResource{
+		Group:    configv1alpha1.SchemeGroupVersion.Group,
+		Version:  configv1alpha1.SchemeGroupVersion.Version,
+		Resource: "oidcclients",
+	}
+
+	goodOIDCClient := &configv1alpha1.OIDCClient{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "some-client",
+			Namespace: namespace,
+			UID:       "some-client-uid",
+		},
+		Spec: configv1alpha1.OIDCClientSpec{
+			AllowedRedirectURIs: []configv1alpha1.RedirectURI{"https://example.com/callback"},
+			AllowedGrantTypes:   []configv1alpha1.GrantType{"authorization_code"},
+			AllowedScopes:       []configv1alpha1.Scope{"openid"},
+		},
+	}
+	goodOIDCClientWithStatus := goodOIDCClient.DeepCopy()
+	goodOIDCClientWithStatus.Status.ClientSecret.Name = "some-client-client-secret"
+
+	secretGVR := schema.GroupVersionResource{
+		Group:    corev1.SchemeGroupVersion.Group,
+		Version:  corev1.SchemeGroupVersion.Version,
+		Resource: "secrets",
+	}
+
+	existingHash, err := bcrypt.GenerateFromPassword([]byte("some-existing-client-secret"), bcrypt.MinCost)
+	require.NoError(t, err)
+
+	newSecret := func(data map[string][]byte) *corev1.Secret {
+		return &corev1.Secret{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:            "some-client-client-secret",
+				Namespace:       namespace,
+				UID:             "some-secret-uid",
+				ResourceVersion: "some-resource-version",
+				Labels: map[string]string{
+					"myLabelKey1": "myLabelValue1",
+					"myLabelKey2": "myLabelValue2",
+				},
+				OwnerReferences: []metav1.OwnerReference{
+					{
+						APIVersion:         oidcClientGVR.GroupVersion().String(),
+						Kind:               "OIDCClient",
+						Name:               goodOIDCClient.Name,
+						UID:                goodOIDCClient.UID,
+						BlockOwnerDeletion: boolPtr(true),
+						Controller:         boolPtr(true),
+					},
+				},
+			},
+			Type: "secrets.pinniped.dev/oidc-client-secret",
+			Data: data,
+		}
+	}
+
+	// The hash of a client secret is random, so the test replaces it with this placeholder after checking that it is
+	// the hash of someClientSecret.
+	const hashPlaceholder = "hash-of-some-client-secret"
+	hashedSecret := newSecret(map[string][]byte{
+		"clientSecretHash": []byte(hashPlaceholder),
+	})
+	hashedSecret.UID = ""
+	hashedSecret.ResourceVersion = ""
+	hashedSecretUpdate := hashedSecret.DeepCopy()
+	hashedSecretUpdate.ResourceVersion = "some-resource-version"
+
+	// The Secret which holds the hash while the Secret of the OIDCClient is recreated with another type.
+	pendingSecret := hashedSecret.DeepCopy()
+	pendingSecret.Name = "some-client-client-secret-pending"
+
+	existingSecret := newSecret(map[string][]byte{
+		"clientSecretHash": existingHash,
+	})
+
+	// A pending Secret left behind by a sync which failed to recreate the Secret of the OIDCClient.
+	pendingHash, err := bcrypt.GenerateFromPassword([]byte(someClientSecret), 12)
+	require.NoError(t, err)
+	existingPendingSecret := newSecret(map[string][]byte{
+		"clientSecretHash": pendingHash,
+	})
+	existingPendingSecret.Name = "some-client-client-secret-pending"
+
+	// A Secret created by the admin, e.g. using "kubectl create secret generic".
+	suppliedSecret := &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:            "some-client-client-secret",
+			Namespace:       namespace,
+			UID:             "some-secret-uid",
+			ResourceVersion: "some-resource-version",
+		},
+		Type: corev1.SecretTypeOpaque,
+		Data: map[string][]byte{
+			"clientSecret": []byte(someClientSecret),
+		},
+	}
+
+	// A Secret which still holds the plaintext client secret next to its hash, or whose client secret the admin is
+	// rotating.
+	secretWithClientSecretAndHash := newSecret(map[string][]byte{
+		"clientSecret":     []byte(someClientSecret),
+		"clientSecretHash": existingHash,
+	})
+
+	secretWithWrongType := existingSecret.DeepCopy()
+	secretWithWrongType.Type = "not-the-right-type"
+
+	secretWithInvalidHash := newSecret(map[string][]byte{
+		"clientSecretHash": []byte("not-a-bcrypt-hash"),
+	})
+
+	tests := []struct {
+		name                  string
+		key                   controllerlib.Key
+		secrets               []*corev1.Secret
+		configKubeClient      func(*kubernetesfake.Clientset)
+		configPinnipedClient  func(*pinnipedfake.Clientset)
+		oidcClients           []*configv1alpha1.OIDCClient
+		wantSecretActions     []kubetesting.Action
+		wantOIDCClientActions []kubetesting.Action
+		wantError             string
+	}{
+		{
+			name: "new OIDCClient whose client secret was not supplied yet",
+			key:  controllerlib.Key{Namespace: namespace, Name: goodOIDCClient.Name},
+			oidcClients: []*configv1alpha1.OIDCClient{
+				goodOIDCClient,
+			},
+			wantSecretActions:     []kubetesting.Action{},
+			wantOIDCClientActions: []kubetesting.Action{},
+		},
+		{
+			name: "new OIDCClient whose client secret was supplied in a Secret of another type",
+			key:  controllerlib.Key{Namespace: namespace, Name: goodOIDCClient.Name},
+			oidcClients: []*configv1alpha1.OIDCClient{
+				goodOIDCClient,
+			},
+			secrets: []*corev1.Secret{
+				suppliedSecret,
+			},
+			wantSecretActions: []kubetesting.Action{
+				kubetesting.NewCreateAction(secretGVR, namespace, pendingSecret),
+				kubetesting.NewDeleteAction(secretGVR, namespace, suppliedSecret.Name),
+				kubetesting.NewCreateAction(secretGVR, namespace, hashedSecret),
+				kubetesting.NewDeleteAction(secretGVR, namespace, pendingSecret.Name),
+			},
+			wantOIDCClientActions: []kubetesting.Action{
+				kubetesting.NewGetAction(oidcClientGVR, namespace, goodOIDCClient.Name),
+				kubetesting.NewUpdateSubresourceAction(oidcClientGVR, "status", namespace, goodOIDCClientWithStatus),
+			},
+		},
+		{
+			name: "existing OIDCClient whose Secret still holds the client secret next to a hash",
+			key:  controllerlib.Key{Namespace: namespace, Name: goodOIDCClient.Name},
+			oidcClients: []*configv1alpha1.OIDCClient{
+				goodOIDCClientWithStatus,
+			},
+			secrets: []*corev1.Secret{
+				secretWithClientSecretAndHash,
+			},
+			wantSecretActions: []kubetesting.Action{
+				kubetesting.NewUpdateAction(secretGVR, namespace, hashedSecretUpdate),
+			},
+			wantOIDCClientActions: []kubetesting.Action{},
+		},
+		{
+			name: "OIDCClient without status with existing secret",
+			key:  controllerlib.Key{Namespace: namespace, Name: goodOIDCClient.Name},
+			oidcClients: []*configv1alpha1.OIDCClient{
+				goodOIDCClient,
+			},
+			secrets: []*corev1.Secret{
+				existingSecret,
+			},
+			wantSecretActions: []kubetesting.Action{},
+			wantOIDCClientActions: []kubetesting.Action{
+				kubetesting.NewGetAction(oidcClientGVR, namespace, goodOIDCClient.Name),
+				kubetesting.NewUpdateSubresourceAction(oidcClientGVR, "status", namespace, goodOIDCClientWithStatus),
+			},
+		},
+		{
+			name: "existing OIDCClient with existing secret",
+			key:  controllerlib.Key{Namespace: namespace, Name: goodOIDCClient.Name},
+			oidcClients: []*configv1alpha1.OIDCClient{
+				goodOIDCClientWithStatus,
+			},
+			secrets: []*corev1.Secret{
+				existingSecret,
+			},
+			wantSecretActions:     []kubetesting.Action{},
+			wantOIDCClientActions: []kubetesting.Action{},
+		},
+		{
+			name: "OIDCClient whose Secret was deleted while it was recreated with another type",
+			key:  controllerlib.Key{Namespace: namespace, Name: goodOIDCClient.Name},
+			oidcClients: []*configv1alpha1.OIDCClient{
+				goodOIDCClient,
+			},
+			secrets: []*corev1.Secret{
+				existingPendingSecret,
+			},
+			wantSecretActions: []kubetesting.Action{
+				kubetesting.NewCreateAction(secretGVR, namespace, hashedSecret),
+				kubetesting.NewDeleteAction(secretGVR, namespace, existingPendingSecret.Name),
+			},
+			wantOIDCClientActions: []kubetesting.Action{},
+		},
+		{
+			name: "existing OIDCClient whose secret was deleted",
+			key:  controllerlib.Key{Namespace: namespace, Name: goodOIDCClient.Name},
+			oidcClients: []*configv1alpha1.OIDCClient{
+				goodOIDCClientWithStatus,
+			},
+			wantSecretActions:     []kubetesting.Action{},
+			wantOIDCClientActions: []kubetesting.Action{},
+		},
+		{
+			name: "existing secret with wrong type",
+			key:  controllerlib.Key{Namespace: namespace, Name: goodOIDCClient.Name},
+			oidcClients: []*configv1alpha1.OIDCClient{
+				goodOIDCClient,
+			},
+			secrets: []*corev1.Secret{
+				secretWithWrongType,
+			},
+			wantSecretActions:     []kubetesting.Action{},
+			wantOIDCClientActions: []kubetesting.Action{},
+		},
+		{
+			name: "existing secret with invalid hash",
+			key:  controllerlib.Key{Namespace: namespace, Name: goodOIDCClient.Name},
+			oidcClients: []*configv1alpha1.OIDCClient{
+				goodOIDCClient,
+			},
+			secrets: []*corev1.Secret{
+				secretWithInvalidHash,
+			},
+			wantSecretActions:     []kubetesting.Action{},
+			wantOIDCClientActions: []kubetesting.Action{},
+		},
+		{
+			name: "deleted OIDCClient",
+			key:  controllerlib.Key{Namespace: namespace, Name: goodOIDCClient.Name},
+			// Nothing to do here since Kube will garbage collect our child secret via its OwnerReference.
+			wantSecretActions:     []kubetesting.Action{},
+			wantOIDCClientActions: []kubetesting.Action{},
+		},
+		{
+			name: "delete secret fails",
+			key:  controllerlib.Key{Namespace: namespace, Name: goodOIDCClient.Name},
+			oidcClients: []*configv1alpha1.OIDCClient{
+				goodOIDCClient,
+			},
+			secrets: []*corev1.Secret{
+				suppliedSecret,
+			},
+			configKubeClient: func(client *kubernetesfake.Clientset) {
+				client.PrependReactor("delete", "secrets", func(_ kubetesting.Action) (bool, runtime.Object, error) {
+					return true, nil, errors.New("some delete error")
+				})
+			},
+			wantError: "cannot replace secret: cannot delete secret: some delete error",
+		},
+		{
+			name: "create secret fails",
+			key:  controllerlib.Key{Namespace: namespace, Name: goodOIDCClient.Name},
+			oidcClients: []*configv1alpha1.OIDCClient{
+				goodOIDCClient,
+			},
+			secrets: []*corev1.Secret{
+				suppliedSecret,
+			},
+			configKubeClient: func(client *kubernetesfake.Clientset) {
+				client.PrependReactor("create", "secrets", func(action kubetesting.Action) (bool, runtime.Object, error) {
+					if action.(kubetesting.CreateAction).GetObject().(*corev1.Secret).Name != suppliedSecret.Name {
+						return false, nil, nil
+					}
+					return true, nil, errors.New("some create error")
+				})
+			},
+			// The hash is kept in the pending Secret, from which the next sync restores the Secret.
+			wantSecretActions: []kubetesting.Action{
+				kubetesting.NewCreateAction(secretGVR, namespace, pendingSecret),
+				kubetesting.NewDeleteAction(secretGVR, namespace, suppliedSecret.Name),
+				kubetesting.NewCreateAction(secretGVR, namespace, hashedSecret),
+			},
+			wantOIDCClientActions: []kubetesting.Action{},
+			wantError:             "cannot replace secret: cannot create secret: some create error",
+		},
+		{
+			name: "create pending secret fails",
+			key:  controllerlib.Key{Namespace: namespace, Name: goodOIDCClient.Name},
+			oidcClients: []*configv1alpha1.OIDCClient{
+				goodOIDCClient,
+			},
+			secrets: []*corev1.Secret{
+				suppliedSecret,
+			},
+			configKubeClient: func(client *kubernetesfake.Clientset) {
+				client.PrependReactor("create", "secrets", func(_ kubetesting.Action) (bool, runtime.Object, error) {
+					return true, nil, errors.New("some create error")
+				})
+			},
+			// The Secret which the admin supplied is not deleted.
+			wantSecretActions: []kubetesting.Action{
+				kubetesting.NewCreateAction(secretGVR, namespace, pendingSecret),
+			},
+			wantOIDCClientActions: []kubetesting.Action{},
+			wantError:             "cannot replace secret: cannot write pending secret: some create error",
+		},
+		{
+			name: "update secret fails",
+			key:  controllerlib.Key{Namespace: namespace, Name: goodOIDCClient.Name},
+			oidcClients: []*configv1alpha1.OIDCClient{
+				goodOIDCClientWithStatus,
+			},
+			secrets: []*corev1.Secret{
+				secretWithClientSecretAndHash,
+			},
+			configKubeClient: func(client *kubernetesfake.Clientset) {
+				client.PrependReactor("update", "secrets", func(_ kubetesting.Action) (bool, runtime.Object, error) {
+					return true, nil, errors.New("some update error")
+				})
+			},
+			wantError: "cannot replace secret: cannot update secret: some update error",
+		},
+		{
+			name: "update OIDCClient status fails",
+			key:  controllerlib.Key{Namespace: namespace, Name: goodOIDCClient.Name},
+			oidcClients: []*configv1alpha1.OIDCClient{
+				goodOIDCClient,
+			},
+			secrets: []*corev1.Secret{
+				existingSecret,
+			},
+			configPinnipedClient: func(client *pinnipedfake.Clientset) {
+				client.PrependReactor("update", "oidcclients", func(_ kubetesting.Action) (bool, runtime.Object, error) {
+					return true, nil, errors.New("some update error")
+				})
+			},
+			wantError: "cannot update OIDCClient: some update error",
+		},
+	}
+	for _, test := range tests {
+		test := test
+		t.Run(test.name, func(t *testing.T) {
+			t.Parallel()
+
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+
+			kubeAPIClient := kubernetesfake.NewSimpleClientset()
+			kubeInformerClient := kubernetesfake.NewSimpleClientset()
+			for _, secret := range test.secrets {
+				require.NoError(t, kubeAPIClient.Tracker().Add(secret))
+				require.NoError(t, kubeInformerClient.Tracker().Add(secret))
+			}
+			if test.configKubeClient != nil {
+				test.configKubeClient(kubeAPIClient)
+			}
+
+			pinnipedAPIClient := pinnipedfake.NewSimpleClientset()
+			pinnipedInformerClient := pinnipedfake.NewSimpleClientset()
+			for _, oidcClient := range test.oidcClients {
+				require.NoError(t, pinnipedAPIClient.Tracker().Add(oidcClient))
+				require.NoError(t, pinnipedInformerClient.Tracker().Add(oidcClient))
+			}
+			if test.configPinnipedClient != nil {
+				test.configPinnipedClient(pinnipedAPIClient)
+			}
+
+			kubeInformers := kubeinformers.NewSharedInformerFactory(kubeInformerClient, 0)
+			pinnipedInformers := pinnipedinformers.NewSharedInformerFactory(pinnipedInformerClient, 0)
+
+			c := NewOIDCClientSecretWriterController(
+				map[string]string{
+					"myLabelKey1": "myLabelValue1",
+					"myLabelKey2": "myLabelValue2",
+				},
+				kubeAPIClient,
+				pinnipedAPIClient,
+				kubeInformers.Core().V1().Secrets(),
+				pinnipedInformers.Config().V1alpha1().OIDCClients(),
+				controllerlib.WithInformer,
+			)
+
+			// Must start informers before calling TestRunSynchronously().
+			kubeInformers.Start(ctx.Done())
+			pinnipedInformers.Start(ctx.Done())
+			controllerlib.TestRunSynchronously(t, c)
+
+			err := controllerlib.TestSync(t, c, controllerlib.Context{
+				Context: ctx,
+				Key:     test.key,
+			})
+			if test.wantError != "" {
+				require.EqualError(t, err, test.wantError)
+				if test.wantSecretActions == nil {
+					return
+				}
+			} else {
+				require.NoError(t, err)
+			}
+
+			actualSecretActions := kubeAPIClient.Actions()
+			for _, action := range actualSecretActions {
+				objectAction, ok := action.(kubetesting.CreateAction) // also matches UpdateAction
+				if !ok {
+					continue
+				}
+				secret := objectAction.GetObject().(*corev1.Secret)
+				require.NoError(t, bcrypt.CompareHashAndPassword(secret.Data["clientSecretHash"], []byte(someClientSecret)))
+				cost, err := bcrypt.Cost(secret.Data["clientSecretHash"])
+				require.NoError(t, err)
+				require.Equal(t, 12, cost)
+				secret.Data["clientSecretHash"] = []byte(hashPlaceholder)
+			}
+			require.Equal(t, test.wantSecretActions, actualSecretActions)
+			require.Equal(t, test.wantOIDCClientActions, pinnipedAPIClient.Actions())
+		})
+	}
+}
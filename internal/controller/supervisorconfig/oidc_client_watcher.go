@@ -0,0 +1,283 @@
+// Copyright 2021 the Pinniped contributors. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package supervisorconfig
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"strings"
+
+	coreosoidc "github.com/coreos/go-oidc/v3/oidc"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/util/clock"
+	"k8s.io/apimachinery/pkg/util/errors"
+	corev1informers "k8s.io/client-go/informers/core/v1"
+	"k8s.io/client-go/util/retry"
+	"k8s.io/klog/v2"
+
+	configv1alpha1 "go.pinniped.dev/generated/latest/apis/supervisor/config/v1alpha1"
+	pinnipedclientset "go.pinniped.dev/generated/latest/client/supervisor/clientset/versioned"
+	configinformers "go.pinniped.dev/generated/latest/client/supervisor/informers/externalversions/config/v1alpha1"
+	pinnipedcontroller "go.pinniped.dev/internal/controller"
+	"go.pinniped.dev/internal/controllerlib"
+	"go.pinniped.dev/internal/oidc/clientregistry"
+	"go.pinniped.dev/internal/plog"
+)
+
+const (
+	grantTypeAuthorizationCode = "authorization_code"
+	grantTypeRefreshToken      = "refresh_token"
+	grantTypeTokenExchange     = "urn:ietf:params:oauth:grant-type:token-exchange"
+	grantTypePassword          = "password"
+
+	scopeRequestAudience = "pinniped:request-audience"
+)
+
+// OIDCClientsSetter can be notified of all known valid OIDCClients with its SetClients function.
+// Implementations of this type should be thread-safe to support calls from multiple goroutines.
+type OIDCClientsSetter interface {
+	SetClients(clients []*clientregistry.Client)
+}
+
+type oidcClientWatcherController struct {
+	clientsSetter      OIDCClientsSetter
+	clock              clock.Clock
+	client             pinnipedclientset.Interface
+	oidcClientInformer configinformers.OIDCClientInformer
+	secretInformer     corev1informers.SecretInformer
+}
+
+// NewOIDCClientWatcherController creates a controllerlib.Controller that watches OIDCClient objects and their
+// corresponding client secret Secrets, and notifies a callback object of the collection of valid clients.
+func NewOIDCClientWatcherController(
+	clientsSetter OIDCClientsSetter,
+	clock clock.Clock,
+	client pinnipedclientset.Interface,
+	secretInformer corev1informers.SecretInformer,
+	oidcClientInformer configinformers.OIDCClientInformer,
+	withInformer pinnipedcontroller.WithInformerOptionFunc,
+) controllerlib.Controller {
+	return controllerlib.New(
+		controllerlib.Config{
+			Name: "oidc-client-watcher-controller",
+			Syncer: &oidcClientWatcherController{
+				clientsSetter:      clientsSetter,
+				clock:              clock,
+				client:             client,
+				oidcClientInformer: oidcClientInformer,
+				secretInformer:     secretInformer,
+			},
+		},
+		withInformer(
+			secretInformer,
+			pinnipedcontroller.MatchAnySecretOfTypeFilter(oidcClientSecretTypeValue, pinnipedcontroller.SingletonQueue()),
+			controllerlib.InformerOption{},
+		),
+		withInformer(
+			oidcClientInformer,
+			pinnipedcontroller.MatchAnythingFilter(pinnipedcontroller.SingletonQueue()),
+			controllerlib.InformerOption{},
+		),
+	)
+}
+
+// Sync implements controllerlib.Syncer.
+func (c *oidcClientWatcherController) Sync(ctx controllerlib.Context) error {
+	oidcClients, err := c.oidcClientInformer.Lister().List(labels.Everything())
+	if err != nil {
+		return fmt.Errorf("failed to list OIDCClients: %w", err)
+	}
+
+	// Rebuild the whole list on any change to any Secret or OIDCClient, because either can have changes that
+	// can cause the list to need to be updated.
+	clients := make([]*clientregistry.Client, 0, len(oidcClients))
+	var errs []error
+	for _, oidcClient := range oidcClients {
+		if validationErrs := validateOIDCClient(oidcClient); len(validationErrs) > 0 {
+			if err := c.updateStatus(
+				ctx.Context,
+				oidcClient.Namespace,
+				oidcClient.Name,
+				configv1alpha1.InvalidOIDCClientStatusCondition,
+				strings.Join(validationErrs, "; "),
+			); err != nil {
+				errs = append(errs, fmt.Errorf("could not update status: %w", err))
+			}
+			continue
+		}
+
+		// The client secret is generated by another controller, which will update the OIDCClient's status once it
+		// has done so, which will cause this controller to sync again.
+		secretName := oidcClient.Status.ClientSecret.Name
+		if secretName == "" {
+			plog.Debug("OIDCClient does not have a client secret yet", "oidcclient", klog.KObj(oidcClient))
+			continue
+		}
+		secret, err := c.secretInformer.Lister().Secrets(oidcClient.Namespace).Get(secretName)
+		if err != nil || !isValidOIDCClientSecret(secret) {
+			plog.Debug("OIDCClient does not have a valid client secret yet", "oidcclient", klog.KObj(oidcClient), "secretName", secretName)
+			continue
+		}
+
+		if err := c.updateStatus(
+			ctx.Context,
+			oidcClient.Namespace,
+			oidcClient.Name,
+			configv1alpha1.SuccessOIDCClientStatusCondition,
+			"Client is ready to use",
+		); err != nil {
+			errs = append(errs, fmt.Errorf("could not update status: %w", err))
+		}
+
+		clients = append(clients, clientregistry.ConfidentialClient(
+			oidcClient.Name,
+			secret.Data[oidcClientSecretHashKey],
+			redirectURIsToStrings(oidcClient.Spec.AllowedRedirectURIs),
+			grantTypesToStrings(oidcClient.Spec.AllowedGrantTypes),
+			scopesToStrings(oidcClient.Spec.AllowedScopes),
+		).WithPostLogoutRedirectURIs(redirectURIsToStrings(oidcClient.Spec.AllowedPostLogoutRedirectURIs)))
+	}
+
+	plog.Debug("oidcClientWatcherController Sync updated the OIDC clients", "clientCount", len(clients))
+	c.clientsSetter.SetClients(clients)
+
+	return errors.NewAggregate(errs)
+}
+
+// validateOIDCClient returns a description of each problem found in the OIDCClient's name and spec.
+func validateOIDCClient(oidcClient *configv1alpha1.OIDCClient) []string {
+	var problems []string
+
+	if _, err := (clientregistry.StaticClientManager{}).GetClient(context.Background(), oidcClient.Name); err == nil {
+		problems = append(problems, fmt.Sprintf("the client ID %q is reserved for a built-in client", oidcClient.Name))
+	}
+
+	for _, redirectURI := range oidcClient.Spec.AllowedRedirectURIs {
+		if problem := validateRedirectURI(string(redirectURI)); problem != "" {
+			problems = append(problems, problem)
+		}
+	}
+	for _, redirectURI := range oidcClient.Spec.AllowedPostLogoutRedirectURIs {
+		if problem := validateRedirectURI(string(redirectURI)); problem != "" {
+			problems = append(problems, "post-logout "+problem)
+		}
+	}
+
+	grantTypes := grantTypesToStrings(oidcClient.Spec.AllowedGrantTypes)
+	scopes := scopesToStrings(oidcClient.Spec.AllowedScopes)
+	for _, grantType := range grantTypes {
+		switch grantType {
+		case grantTypeAuthorizationCode, grantTypeRefreshToken, grantTypeTokenExchange, grantTypePassword:
+		default:
+			problems = append(problems, fmt.Sprintf("allowedGrantTypes includes unsupported grant type %q", grantType))
+		}
+	}
+	if !contains(grantTypes, grantTypeAuthorizationCode) {
+		problems = append(problems, fmt.Sprintf("allowedGrantTypes must include %q", grantTypeAuthorizationCode))
+	}
+	if !contains(scopes, coreosoidc.ScopeOpenID) {
+		problems = append(problems, fmt.Sprintf("allowedScopes must include %q", coreosoidc.ScopeOpenID))
+	}
+	problems = append(problems, validateGrantTypeRequiresScope(grantTypes, scopes, grantTypeRefreshToken, coreosoidc.ScopeOfflineAccess)...)
+	problems = append(problems, validateGrantTypeRequiresScope(grantTypes, scopes, grantTypeTokenExchange, scopeRequestAudience)...)
+
+	return problems
+}
+
+func validateRedirectURI(redirectURI string) string {
+	u, err := url.Parse(redirectURI)
+	if err != nil {
+		return fmt.Sprintf("redirect URI %q is not a valid URI: %s", redirectURI, err.Error())
+	}
+	switch {
+	case u.Scheme == "https" && u.Host != "":
+		return ""
+	case u.Scheme == "http" && (u.Hostname() == "127.0.0.1" || u.Hostname() == "::1"):
+		return ""
+	default:
+		return fmt.Sprintf("redirect URI %q must be an https URI, or an http URI using a loopback interface IP address", redirectURI)
+	}
+}
+
+// validateGrantTypeRequiresScope checks that the grant type and the scope are either both allowed or both disallowed,
+// since Fosite will only issue the tokens for those grant types when the corresponding scope is granted.
+func validateGrantTypeRequiresScope(grantTypes, scopes []string, grantType, scope string) []string {
+	hasGrantType, hasScope := contains(grantTypes, grantType), contains(scopes, scope)
+	switch {
+	case hasGrantType && !hasScope:
+		return []string{fmt.Sprintf("allowedScopes must include %q when allowedGrantTypes includes %q", scope, grantType)}
+	case hasScope && !hasGrantType:
+		return []string{fmt.Sprintf("allowedGrantTypes must include %q when allowedScopes includes %q", grantType, scope)}
+	default:
+		return nil
+	}
+}
+
+func (c *oidcClientWatcherController) updateStatus(
+	ctx context.Context,
+	namespace, name string,
+	status configv1alpha1.OIDCClientStatusCondition,
+	message string,
+) error {
+	return retry.RetryOnConflict(retry.DefaultRetry, func() error {
+		oidcClient, err := c.client.ConfigV1alpha1().OIDCClients(namespace).Get(ctx, name, metav1.GetOptions{})
+		if err != nil {
+			return fmt.Errorf("get failed: %w", err)
+		}
+
+		if oidcClient.Status.Status == status && oidcClient.Status.Message == message {
+			return nil
+		}
+
+		plog.Debug(
+			"attempting status update",
+			"oidcclient",
+			klog.KRef(namespace, name),
+			"status",
+			status,
+			"message",
+			message,
+		)
+		oidcClient.Status.Status = status
+		oidcClient.Status.Message = message
+		oidcClient.Status.LastUpdateTime = timePtr(metav1.NewTime(c.clock.Now()))
+		_, err = c.client.ConfigV1alpha1().OIDCClients(namespace).UpdateStatus(ctx, oidcClient, metav1.UpdateOptions{})
+		return err
+	})
+}
+
+func redirectURIsToStrings(redirectURIs []configv1alpha1.RedirectURI) []string {
+	result := make([]string, 0, len(redirectURIs))
+	for _, redirectURI := range redirectURIs {
+		result = append(result, string(redirectURI))
+	}
+	return result
+}
+
+func grantTypesToStrings(grantTypes []configv1alpha1.GrantType) []string {
+	result := make([]string, 0, len(grantTypes))
+	for _, grantType := range grantTypes {
+		result = append(result, string(grantType))
+	}
+	return result
+}
+
+func scopesToStrings(scopes []configv1alpha1.Scope) []string {
+	result := make([]string, 0, len(scopes))
+	for _, scope := range scopes {
+		result = append(result, string(scope))
+	}
+	return result
+}
+
+func contains(haystack []string, needle string) bool {
+	for _, s := range haystack {
+		if s == needle {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,250 @@
+// Copyright 2021 the Pinniped contributors. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package supervisorconfig
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"golang.org/x/crypto/bcrypt"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/util/clock"
+	kubeinformers "k8s.io/client-go/informers"
+	kubernetesfake "k8s.io/client-go/kubernetes/fake"
+	kubetesting "k8s.io/client-go/testing"
+
+	configv1alpha1 "go.pinniped.dev/generated/latest/apis/supervisor/config/v1alpha1"
+	pinnipedfake "go.pinniped.dev/generated/latest/client/supervisor/clientset/versioned/fake"
+	pinnipedinformers "go.pinniped.dev/generated/latest/client/supervisor/informers/externalversions"
+	"go.pinniped.dev/internal/controllerlib"
+	"go.pinniped.dev/internal/oidc/clientregistry"
+)
+
+type fakeOIDCClientsSetter struct {
+	setClientsWasCalled bool
+	clientsReceived     []*clientregistry.Client
+}
+
+func (f *fakeOIDCClientsSetter) SetClients(clients []*clientregistry.Client) {
+	f.setClientsWasCalled = true
+	f.clientsReceived = clients
+}
+
+func TestOIDCClientWatcherControllerSync(t *testing.T) {
+	t.Parallel()
+
+	const namespace = "some-namespace"
+
+	now := time.Date(2021, 1, 2, 3, 4, 5, 0, time.UTC)
+	frozenMetav1Now := metav1.NewTime(now)
+
+	oidcClientGVR := schema.GroupVersionResource{
+		Group:    configv1alpha1.SchemeGroupVersion.Group,
+		Version:  configv1alpha1.SchemeGroupVersion.Version,
+		Resource: "oidcclients",
+	}
+
+	hash, err := bcrypt.GenerateFromPassword([]byte("some-client-secret"), bcrypt.MinCost)
+	require.NoError(t, err)
+
+	newOIDCClient := func(name string, spec configv1alpha1.OIDCClientSpec) *configv1alpha1.OIDCClient {
+		return &configv1alpha1.OIDCClient{
+			ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: namespace},
+			Spec:       spec,
+			Status: configv1alpha1.OIDCClientStatus{
+				ClientSecret: corev1.LocalObjectReference{Name: name + "-client-secret"},
+			},
+		}
+	}
+
+	newSecret := func(name string) *corev1.Secret {
+		return &corev1.Secret{
+			ObjectMeta: metav1.ObjectMeta{Name: name + "-client-secret", Namespace: namespace},
+			Type:       "secrets.pinniped.dev/oidc-client-secret",
+			Data:       map[string][]byte{"clientSecretHash": hash},
+		}
+	}
+
+	withStatus := func(oidcClient *configv1alpha1.OIDCClient, status configv1alpha1.OIDCClientStatusCondition, message string) *configv1alpha1.OIDCClient {
+		c := oidcClient.DeepCopy()
+		c.Status.Status = status
+		c.Status.Message = message
+		c.Status.LastUpdateTime = &frozenMetav1Now
+		return c
+	}
+
+	goodSpec := configv1alpha1.OIDCClientSpec{
+		AllowedRedirectURIs:           []configv1alpha1.RedirectURI{"https://example.com/callback", "http://127.0.0.1:1234/callback"},
+		AllowedPostLogoutRedirectURIs: []configv1alpha1.RedirectURI{"https://example.com/logged-out"},
+		AllowedGrantTypes:             []configv1alpha1.GrantType{"authorization_code", "refresh_token"},
+		AllowedScopes:                 []configv1alpha1.Scope{"openid", "offline_access"},
+	}
+	goodClient := newOIDCClient("some-client", goodSpec)
+
+	badClient := newOIDCClient("some-bad-client", configv1alpha1.OIDCClientSpec{
+		AllowedRedirectURIs:           []configv1alpha1.RedirectURI{"http://example.com/callback"},
+		AllowedPostLogoutRedirectURIs: []configv1alpha1.RedirectURI{"http://example.com/logged-out"},
+		AllowedGrantTypes:             []configv1alpha1.GrantType{"refresh_token", "implicit"},
+		AllowedScopes:                 []configv1alpha1.Scope{"pinniped:request-audience"},
+	})
+
+	passwordGrantSpec := goodSpec.DeepCopy()
+	passwordGrantSpec.AllowedGrantTypes = append(passwordGrantSpec.AllowedGrantTypes, "password")
+	passwordGrantClient := newOIDCClient("some-password-grant-client", *passwordGrantSpec)
+
+	reservedClient := newOIDCClient("pinniped-cli", goodSpec)
+
+	clientWithoutSecret := newOIDCClient("some-client-without-secret", goodSpec)
+	clientWithoutSecret.Status.ClientSecret.Name = ""
+
+	tests := []struct {
+		name                  string
+		oidcClients           []*configv1alpha1.OIDCClient
+		secrets               []*corev1.Secret
+		wantClientIDs         []string
+		wantOIDCClientActions []kubetesting.Action
+	}{
+		{
+			name:                  "no OIDCClients",
+			wantClientIDs:         []string{},
+			wantOIDCClientActions: []kubetesting.Action{},
+		},
+		{
+			name:          "valid OIDCClient with a client secret",
+			oidcClients:   []*configv1alpha1.OIDCClient{goodClient},
+			secrets:       []*corev1.Secret{newSecret(goodClient.Name)},
+			wantClientIDs: []string{"some-client"},
+			wantOIDCClientActions: []kubetesting.Action{
+				kubetesting.NewGetAction(oidcClientGVR, namespace, goodClient.Name),
+				kubetesting.NewUpdateSubresourceAction(oidcClientGVR, "status", namespace,
+					withStatus(goodClient, configv1alpha1.SuccessOIDCClientStatusCondition, "Client is ready to use"),
+				),
+			},
+		},
+		{
+			name:          "valid OIDCClient which may use the password grant",
+			oidcClients:   []*configv1alpha1.OIDCClient{passwordGrantClient},
+			secrets:       []*corev1.Secret{newSecret(passwordGrantClient.Name)},
+			wantClientIDs: []string{"some-password-grant-client"},
+			wantOIDCClientActions: []kubetesting.Action{
+				kubetesting.NewGetAction(oidcClientGVR, namespace, passwordGrantClient.Name),
+				kubetesting.NewUpdateSubresourceAction(oidcClientGVR, "status", namespace,
+					withStatus(passwordGrantClient, configv1alpha1.SuccessOIDCClientStatusCondition, "Client is ready to use"),
+				),
+			},
+		},
+		{
+			name:          "valid OIDCClient whose status is already up to date",
+			oidcClients:   []*configv1alpha1.OIDCClient{withStatus(goodClient, configv1alpha1.SuccessOIDCClientStatusCondition, "Client is ready to use")},
+			secrets:       []*corev1.Secret{newSecret(goodClient.Name)},
+			wantClientIDs: []string{"some-client"},
+			wantOIDCClientActions: []kubetesting.Action{
+				kubetesting.NewGetAction(oidcClientGVR, namespace, goodClient.Name),
+			},
+		},
+		{
+			name:                  "valid OIDCClient which does not have a client secret yet",
+			oidcClients:           []*configv1alpha1.OIDCClient{clientWithoutSecret},
+			wantClientIDs:         []string{},
+			wantOIDCClientActions: []kubetesting.Action{},
+		},
+		{
+			name:                  "valid OIDCClient whose client secret Secret does not exist",
+			oidcClients:           []*configv1alpha1.OIDCClient{goodClient},
+			wantClientIDs:         []string{},
+			wantOIDCClientActions: []kubetesting.Action{},
+		},
+		{
+			name:          "invalid OIDCClient",
+			oidcClients:   []*configv1alpha1.OIDCClient{badClient},
+			secrets:       []*corev1.Secret{newSecret(badClient.Name)},
+			wantClientIDs: []string{},
+			wantOIDCClientActions: []kubetesting.Action{
+				kubetesting.NewGetAction(oidcClientGVR, namespace, badClient.Name),
+				kubetesting.NewUpdateSubresourceAction(oidcClientGVR, "status", namespace,
+					withStatus(badClient, configv1alpha1.InvalidOIDCClientStatusCondition,
+						`redirect URI "http://example.com/callback" must be an https URI, or an http URI using a loopback interface IP address; `+
+							`post-logout redirect URI "http://example.com/logged-out" must be an https URI, or an http URI using a loopback interface IP address; `+
+							`allowedGrantTypes includes unsupported grant type "implicit"; `+
+							`allowedGrantTypes must include "authorization_code"; `+
+							`allowedScopes must include "openid"; `+
+							`allowedScopes must include "offline_access" when allowedGrantTypes includes "refresh_token"; `+
+							`allowedGrantTypes must include "urn:ietf:params:oauth:grant-type:token-exchange" when allowedScopes includes "pinniped:request-audience"`,
+					),
+				),
+			},
+		},
+		{
+			name:          "OIDCClient using the client ID of a built-in client",
+			oidcClients:   []*configv1alpha1.OIDCClient{reservedClient},
+			secrets:       []*corev1.Secret{newSecret(reservedClient.Name)},
+			wantClientIDs: []string{},
+			wantOIDCClientActions: []kubetesting.Action{
+				kubetesting.NewGetAction(oidcClientGVR, namespace, reservedClient.Name),
+				kubetesting.NewUpdateSubresourceAction(oidcClientGVR, "status", namespace,
+					withStatus(reservedClient, configv1alpha1.InvalidOIDCClientStatusCondition,
+						`the client ID "pinniped-cli" is reserved for a built-in client`,
+					),
+				),
+			},
+		},
+	}
+	for _, test := range tests {
+		test := test
+		t.Run(test.name, func(t *testing.T) {
+			t.Parallel()
+
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+
+			kubeInformerClient := kubernetesfake.NewSimpleClientset()
+			for _, secret := range test.secrets {
+				require.NoError(t, kubeInformerClient.Tracker().Add(secret))
+			}
+
+			pinnipedAPIClient := pinnipedfake.NewSimpleClientset()
+			pinnipedInformerClient := pinnipedfake.NewSimpleClientset()
+			for _, oidcClient := range test.oidcClients {
+				require.NoError(t, pinnipedAPIClient.Tracker().Add(oidcClient))
+				require.NoError(t, pinnipedInformerClient.Tracker().Add(oidcClient))
+			}
+
+			kubeInformers := kubeinformers.NewSharedInformerFactory(kubeInformerClient, 0)
+			pinnipedInformers := pinnipedinformers.NewSharedInformerFactory(pinnipedInformerClient, 0)
+
+			setter := &fakeOIDCClientsSetter{}
+			c := NewOIDCClientWatcherController(
+				setter,
+				clock.NewFakeClock(now),
+				pinnipedAPIClient,
+				kubeInformers.Core().V1().Secrets(),
+				pinnipedInformers.Config().V1alpha1().OIDCClients(),
+				controllerlib.WithInformer,
+			)
+
+			// Must start informers before calling TestRunSynchronously().
+			kubeInformers.Start(ctx.Done())
+			pinnipedInformers.Start(ctx.Done())
+			controllerlib.TestRunSynchronously(t, c)
+
+			err := controllerlib.TestSync(t, c, controllerlib.Context{Context: ctx})
+			require.NoError(t, err)
+
+			require.True(t, setter.setClientsWasCalled)
+			clientIDs := []string{}
+			for _, client := range setter.clientsReceived {
+				clientIDs = append(clientIDs, client.GetID())
+				require.Equal(t, hash, client.GetHashedSecret())
+				require.False(t, client.IsPublic())
+				require.Equal(t, []string{"https://example.com/logged-out"}, client.GetPostLogoutRedirectURIs())
+			}
+			require.Equal(t, test.wantClientIDs, clientIDs)
+			require.Equal(t, test.wantOIDCClientActions, pinnipedAPIClient.Actions())
+		})
+	}
+}
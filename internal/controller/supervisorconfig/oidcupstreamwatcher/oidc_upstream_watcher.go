@@ -6,9 +6,13 @@ package oidcupstreamwatcher
 
 import (
 	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rsa"
 	"crypto/tls"
 	"crypto/x509"
 	"encoding/base64"
+	"encoding/pem"
 	"fmt"
 	"net/http"
 	"net/url"
@@ -19,11 +23,13 @@ import (
 	"github.com/coreos/go-oidc/v3/oidc"
 	"github.com/go-logr/logr"
 	"golang.org/x/oauth2"
+	"gopkg.in/square/go-jose.v2"
 	corev1 "k8s.io/api/core/v1"
 	"k8s.io/apimachinery/pkg/api/equality"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/labels"
 	"k8s.io/apimachinery/pkg/util/cache"
+	"k8s.io/apimachinery/pkg/util/sets"
 	corev1informers "k8s.io/client-go/informers/core/v1"
 
 	"go.pinniped.dev/generated/latest/apis/supervisor/idp/v1alpha1"
@@ -47,16 +53,23 @@ const (
 
 	clientIDDataKey     = "clientID"
 	clientSecretDataKey = "clientSecret"
+	privateKeyDataKey   = "privateKey"
+	privateKeyIDDataKey = "privateKeyID"
 
 	// Constants related to the OIDC provider discovery cache. These do not affect the cache of JWKS.
 	oidcValidatorCacheTTL = 15 * time.Minute
 
 	// Constants related to conditions.
-	typeClientCredentialsValid = "ClientCredentialsValid"
-	typeOIDCDiscoverySucceeded = "OIDCDiscoverySucceeded"
+	typeClientCredentialsValid             = "ClientCredentialsValid"
+	typeOIDCDiscoverySucceeded             = "OIDCDiscoverySucceeded"
+	typeAdditionalAuthorizeParametersValid = "AdditionalAuthorizeParametersValid"
+	typeOfflineAccessRequested             = "OfflineAccessRequested"
 
-	reasonUnreachable     = "Unreachable"
-	reasonInvalidResponse = "InvalidResponse"
+	reasonUnreachable              = "Unreachable"
+	reasonInvalidResponse          = "InvalidResponse"
+	reasonDisallowedParameterName  = "DisallowedParameterName"
+	reasonInvalidPrivateKey        = "SecretInvalidPrivateKey"
+	reasonOfflineAccessNotInScopes = "OfflineAccessNotInScopes"
 
 	// Errors that are generated by our reconcile process.
 	errOIDCFailureStatus = constable.Error("OIDCIdentityProvider has a failing condition")
@@ -172,12 +185,17 @@ func (c *oidcWatcherController) validateUpstream(ctx controllerlib.Context, upst
 		Config: &oauth2.Config{
 			Scopes: computeScopes(upstream.Spec.AuthorizationConfig.AdditionalScopes),
 		},
-		UsernameClaim: upstream.Spec.Claims.Username,
-		GroupsClaim:   upstream.Spec.Claims.Groups,
+		UsernameClaim:           upstream.Spec.Claims.Username,
+		GroupsClaim:             upstream.Spec.Claims.Groups,
+		AdditionalClaimMappings: upstream.Spec.Claims.AdditionalClaimMappings,
+		UserInfoMode:            upstreamoidc.UserInfoMode(upstream.Spec.Claims.UserInfo),
 	}
+	secretCondition, clientCert := c.validateSecret(upstream, &result)
 	conditions := []*v1alpha1.Condition{
-		c.validateSecret(upstream, &result),
-		c.validateIssuer(ctx.Context, upstream, &result),
+		c.validateAdditionalAuthorizeParameters(upstream, &result),
+		secretCondition,
+		c.validateIssuer(ctx.Context, upstream, &result, clientCert),
+		c.validateOfflineAccessRequested(&result),
 	}
 	c.updateStatus(ctx.Context, upstream, conditions)
 
@@ -199,8 +217,77 @@ func (c *oidcWatcherController) validateUpstream(ctx controllerlib.Context, upst
 	return nil
 }
 
+// validateAdditionalAuthorizeParameters validates the .spec.authorizationConfig.additionalAuthorizeParameters field
+// and returns the appropriate AdditionalAuthorizeParametersValid condition.
+func (c *oidcWatcherController) validateAdditionalAuthorizeParameters(upstream *v1alpha1.OIDCIdentityProvider, result *upstreamoidc.ProviderConfig) *v1alpha1.Condition {
+	// These parameters are always set by the Supervisor, so they may not be overridden.
+	disallowedNames := sets.NewString(
+		"response_type",
+		"scope",
+		"client_id",
+		"state",
+		"nonce",
+		"code_challenge",
+		"code_challenge_method",
+		"redirect_uri",
+	)
+
+	params := make(map[string]string, len(upstream.Spec.AuthorizationConfig.AdditionalAuthorizeParameters))
+	rejectedNames := sets.NewString()
+	for _, param := range upstream.Spec.AuthorizationConfig.AdditionalAuthorizeParameters {
+		if disallowedNames.Has(param.Name) {
+			rejectedNames.Insert(param.Name)
+			continue
+		}
+		params[param.Name] = param.Value
+	}
+
+	if rejectedNames.Len() > 0 {
+		return &v1alpha1.Condition{
+			Type:   typeAdditionalAuthorizeParametersValid,
+			Status: v1alpha1.ConditionFalse,
+			Reason: reasonDisallowedParameterName,
+			Message: fmt.Sprintf("the following additionalAuthorizeParameters are not allowed: %s",
+				strings.Join(rejectedNames.List(), ",")),
+		}
+	}
+
+	result.AdditionalAuthcodeParams = params
+	return &v1alpha1.Condition{
+		Type:    typeAdditionalAuthorizeParametersValid,
+		Status:  v1alpha1.ConditionTrue,
+		Reason:  upstreamwatchers.ReasonSuccess,
+		Message: "additionalAuthorizeParameters parameter names are allowed",
+	}
+}
+
+// validateOfflineAccessRequested returns the OfflineAccessRequested condition, which tells whether the "offline_access"
+// scope is requested. Most providers only return a refresh token for that scope, and the token endpoint cannot refresh
+// a downstream session without an upstream refresh token. Some providers return a refresh token for other reasons,
+// such as the access_type=offline param which the Supervisor always sends, so a missing scope does not fail the upstream.
+func (c *oidcWatcherController) validateOfflineAccessRequested(result *upstreamoidc.ProviderConfig) *v1alpha1.Condition {
+	for _, scope := range result.Config.Scopes {
+		if scope == oidc.ScopeOfflineAccess {
+			return &v1alpha1.Condition{
+				Type:    typeOfflineAccessRequested,
+				Status:  v1alpha1.ConditionTrue,
+				Reason:  upstreamwatchers.ReasonSuccess,
+				Message: `the "offline_access" scope is requested`,
+			}
+		}
+	}
+	return &v1alpha1.Condition{
+		Type:   typeOfflineAccessRequested,
+		Status: v1alpha1.ConditionUnknown,
+		Reason: reasonOfflineAccessNotInScopes,
+		Message: `additionalScopes does not include "offline_access", so the provider may not return refresh tokens ` +
+			`and the user may have to log in again whenever their downstream session is refreshed`,
+	}
+}
+
 // validateSecret validates the .spec.client.secretName field and returns the appropriate ClientCredentialsValid condition.
-func (c *oidcWatcherController) validateSecret(upstream *v1alpha1.OIDCIdentityProvider, result *upstreamoidc.ProviderConfig) *v1alpha1.Condition {
+// When the .spec.client.authMethod is TLSClientAuth, it also returns the client certificate from the Secret.
+func (c *oidcWatcherController) validateSecret(upstream *v1alpha1.OIDCIdentityProvider, result *upstreamoidc.ProviderConfig) (*v1alpha1.Condition, *tls.Certificate) {
 	secretName := upstream.Spec.Client.SecretName
 
 	// Fetch the Secret from informer cache.
@@ -211,7 +298,7 @@ func (c *oidcWatcherController) validateSecret(upstream *v1alpha1.OIDCIdentityPr
 			Status:  v1alpha1.ConditionFalse,
 			Reason:  upstreamwatchers.ReasonNotFound,
 			Message: err.Error(),
-		}
+		}, nil
 	}
 
 	// Validate the secret .type field.
@@ -221,34 +308,117 @@ func (c *oidcWatcherController) validateSecret(upstream *v1alpha1.OIDCIdentityPr
 			Status:  v1alpha1.ConditionFalse,
 			Reason:  upstreamwatchers.ReasonWrongType,
 			Message: fmt.Sprintf("referenced Secret %q has wrong type %q (should be %q)", secretName, secret.Type, oidcClientSecretType),
+		}, nil
+	}
+
+	// Validate the secret .data field, which needs different keys for each client authentication method.
+	var requiredKeys []string
+	switch upstream.Spec.Client.AuthMethod {
+	case v1alpha1.OIDCClientAuthMethodPrivateKeyJWT:
+		requiredKeys = []string{clientIDDataKey, privateKeyDataKey}
+	case v1alpha1.OIDCClientAuthMethodTLSClientAuth:
+		requiredKeys = []string{clientIDDataKey, corev1.TLSCertKey, corev1.TLSPrivateKeyKey}
+	default:
+		requiredKeys = []string{clientIDDataKey, clientSecretDataKey}
+	}
+	for _, key := range requiredKeys {
+		if len(secret.Data[key]) == 0 {
+			return &v1alpha1.Condition{
+				Type:    typeClientCredentialsValid,
+				Status:  v1alpha1.ConditionFalse,
+				Reason:  upstreamwatchers.ReasonMissingKeys,
+				Message: fmt.Sprintf("referenced Secret %q is missing required keys %q", secretName, requiredKeys),
+			}, nil
 		}
 	}
 
-	// Validate the secret .data field.
-	clientID := secret.Data[clientIDDataKey]
-	clientSecret := secret.Data[clientSecretDataKey]
-	if len(clientID) == 0 || len(clientSecret) == 0 {
-		return &v1alpha1.Condition{
-			Type:    typeClientCredentialsValid,
-			Status:  v1alpha1.ConditionFalse,
-			Reason:  upstreamwatchers.ReasonMissingKeys,
-			Message: fmt.Sprintf("referenced Secret %q is missing required keys %q", secretName, []string{clientIDDataKey, clientSecretDataKey}),
+	var clientCert *tls.Certificate
+	switch upstream.Spec.Client.AuthMethod {
+	case v1alpha1.OIDCClientAuthMethodPrivateKeyJWT:
+		key, err := parseClientAssertionKey(secret.Data[privateKeyDataKey], string(secret.Data[privateKeyIDDataKey]))
+		if err != nil {
+			return &v1alpha1.Condition{
+				Type:    typeClientCredentialsValid,
+				Status:  v1alpha1.ConditionFalse,
+				Reason:  reasonInvalidPrivateKey,
+				Message: fmt.Sprintf("referenced Secret %q has an invalid private key: %s", secretName, err.Error()),
+			}, nil
+		}
+		result.ClientAuthMethod = upstreamoidc.ClientAuthMethodPrivateKeyJWT
+		result.ClientAssertionKey = key
+	case v1alpha1.OIDCClientAuthMethodTLSClientAuth:
+		cert, err := tls.X509KeyPair(secret.Data[corev1.TLSCertKey], secret.Data[corev1.TLSPrivateKeyKey])
+		if err != nil {
+			return &v1alpha1.Condition{
+				Type:    typeClientCredentialsValid,
+				Status:  v1alpha1.ConditionFalse,
+				Reason:  upstreamwatchers.ReasonInvalidClientCertificate,
+				Message: fmt.Sprintf("referenced Secret %q has an invalid client certificate: %s", secretName, err.Error()),
+			}, nil
 		}
+		result.ClientAuthMethod = upstreamoidc.ClientAuthMethodTLSClientAuth
+		clientCert = &cert
+	default:
+		result.ClientAuthMethod = upstreamoidc.ClientAuthMethodClientSecret
+		result.Config.ClientSecret = string(secret.Data[clientSecretDataKey])
 	}
 
 	// If everything is valid, update the result and set the condition to true.
-	result.Config.ClientID = string(clientID)
-	result.Config.ClientSecret = string(clientSecret)
+	result.Config.ClientID = string(secret.Data[clientIDDataKey])
 	return &v1alpha1.Condition{
 		Type:    typeClientCredentialsValid,
 		Status:  v1alpha1.ConditionTrue,
 		Reason:  upstreamwatchers.ReasonSuccess,
 		Message: "loaded client credentials",
+	}, clientCert
+}
+
+// parseClientAssertionKey parses a PEM-encoded RSA or EC private key which is used to sign private_key_jwt client
+// assertions, and chooses the signing algorithm for it.
+func parseClientAssertionKey(keyPEM []byte, keyID string) (*jose.JSONWebKey, error) {
+	block, _ := pem.Decode(keyPEM)
+	if block == nil {
+		return nil, fmt.Errorf("no PEM data found")
+	}
+
+	var key interface{}
+	var err error
+	switch block.Type {
+	case "RSA PRIVATE KEY":
+		key, err = x509.ParsePKCS1PrivateKey(block.Bytes)
+	case "EC PRIVATE KEY":
+		key, err = x509.ParseECPrivateKey(block.Bytes)
+	default:
+		key, err = x509.ParsePKCS8PrivateKey(block.Bytes)
 	}
+	if err != nil {
+		return nil, err
+	}
+
+	var algorithm jose.SignatureAlgorithm
+	switch k := key.(type) {
+	case *rsa.PrivateKey:
+		algorithm = jose.RS256
+	case *ecdsa.PrivateKey:
+		switch k.Curve {
+		case elliptic.P256():
+			algorithm = jose.ES256
+		case elliptic.P384():
+			algorithm = jose.ES384
+		case elliptic.P521():
+			algorithm = jose.ES512
+		default:
+			return nil, fmt.Errorf("unsupported elliptic curve %s", k.Curve.Params().Name)
+		}
+	default:
+		return nil, fmt.Errorf("unsupported private key type %T", key)
+	}
+
+	return &jose.JSONWebKey{Key: key, KeyID: keyID, Algorithm: string(algorithm), Use: "sig"}, nil
 }
 
 // validateIssuer validates the .spec.issuer field, performs OIDC discovery, and returns the appropriate OIDCDiscoverySucceeded condition.
-func (c *oidcWatcherController) validateIssuer(ctx context.Context, upstream *v1alpha1.OIDCIdentityProvider, result *upstreamoidc.ProviderConfig) *v1alpha1.Condition {
+func (c *oidcWatcherController) validateIssuer(ctx context.Context, upstream *v1alpha1.OIDCIdentityProvider, result *upstreamoidc.ProviderConfig, clientCert *tls.Certificate) *v1alpha1.Condition {
 	// Get the provider and HTTP Client from cache if possible.
 	discoveredProvider, httpClient := c.validatorCache.getProvider(&upstream.Spec)
 
@@ -311,10 +481,93 @@ func (c *oidcWatcherController) validateIssuer(ctx context.Context, upstream *v1
 		}
 	}
 
+	// Read the optional parts of the discovery document which are needed by some settings.
+	var discoveryClaims struct {
+		UserInfoURL                       string   `json:"userinfo_endpoint"`
+		EndSessionURL                     string   `json:"end_session_endpoint"`
+		TokenEndpointAuthMethodsSupported []string `json:"token_endpoint_auth_methods_supported"`
+		MTLSEndpointAliases               struct {
+			TokenURL string `json:"token_endpoint"`
+		} `json:"mtls_endpoint_aliases"`
+	}
+	if err := discoveredProvider.Claims(&discoveryClaims); err != nil {
+		return &v1alpha1.Condition{
+			Type:    typeOIDCDiscoverySucceeded,
+			Status:  v1alpha1.ConditionFalse,
+			Reason:  reasonInvalidResponse,
+			Message: fmt.Sprintf("failed to parse discovery document: %v", err),
+		}
+	}
+
+	// When the userinfo endpoint is required, make sure that the provider advertised one.
+	if upstream.Spec.Claims.UserInfo == v1alpha1.OIDCUserInfoModeRequired && discoveryClaims.UserInfoURL == "" {
+		return &v1alpha1.Condition{
+			Type:    typeOIDCDiscoverySucceeded,
+			Status:  v1alpha1.ConditionFalse,
+			Reason:  reasonInvalidResponse,
+			Message: "discovery document does not advertise a userinfo endpoint, which is required by spec.claims.userInfo",
+		}
+	}
+
+	// When using a client authentication method other than the client secret, make sure that the provider supports it.
+	// Providers which do not list their supported methods only support client_secret_basic, according to the spec.
+	var requiredAuthMethod string
+	switch upstream.Spec.Client.AuthMethod {
+	case v1alpha1.OIDCClientAuthMethodPrivateKeyJWT:
+		requiredAuthMethod = "private_key_jwt"
+	case v1alpha1.OIDCClientAuthMethodTLSClientAuth:
+		requiredAuthMethod = "tls_client_auth"
+	}
+	if requiredAuthMethod != "" && !sets.NewString(discoveryClaims.TokenEndpointAuthMethodsSupported...).Has(requiredAuthMethod) {
+		return &v1alpha1.Condition{
+			Type:   typeOIDCDiscoverySucceeded,
+			Status: v1alpha1.ConditionFalse,
+			Reason: reasonInvalidResponse,
+			Message: fmt.Sprintf("discovery document does not list %q in token_endpoint_auth_methods_supported, which is required by spec.client.authMethod",
+				requiredAuthMethod),
+		}
+	}
+
+	// When the upstream session should be ended on logout, make sure that the advertised end session endpoint is
+	// also safe to redirect the end user's browser to. Providers which do not advertise one are allowed.
+	if upstream.Spec.AuthorizationConfig.EndUpstreamSessionOnLogout && discoveryClaims.EndSessionURL != "" {
+		endSessionURL, err := url.Parse(discoveryClaims.EndSessionURL)
+		if err != nil {
+			return &v1alpha1.Condition{
+				Type:    typeOIDCDiscoverySucceeded,
+				Status:  v1alpha1.ConditionFalse,
+				Reason:  reasonInvalidResponse,
+				Message: fmt.Sprintf("failed to parse end session endpoint URL: %v", err),
+			}
+		}
+		if endSessionURL.Scheme != "https" {
+			return &v1alpha1.Condition{
+				Type:    typeOIDCDiscoverySucceeded,
+				Status:  v1alpha1.ConditionFalse,
+				Reason:  reasonInvalidResponse,
+				Message: fmt.Sprintf(`end session endpoint URL scheme must be "https", not %q`, endSessionURL.Scheme),
+			}
+		}
+		result.EndSessionURL = discoveryClaims.EndSessionURL
+	}
+
 	// If everything is valid, update the result and set the condition to true.
 	result.Config.Endpoint = discoveredProvider.Endpoint()
 	result.Provider = discoveredProvider
 	result.Client = httpClient
+	if requiredAuthMethod != "" {
+		// Send the client ID as a parameter, without any client secret in an Authorization header.
+		result.Config.Endpoint.AuthStyle = oauth2.AuthStyleInParams
+	}
+	if clientCert != nil {
+		// Present the client certificate, but do not change the cached client which is shared with the JWKS requests.
+		transport := httpClient.Transport.(*http.Transport).Clone()
+		transport.TLSClientConfig.Certificates = []tls.Certificate{*clientCert}
+		result.Client = &http.Client{Timeout: httpClient.Timeout, Transport: transport}
+		if discoveryClaims.MTLSEndpointAliases.TokenURL != "" {
+			result.Config.Endpoint.TokenURL = discoveryClaims.MTLSEndpointAliases.TokenURL
+		}
+	}
 	return &v1alpha1.Condition{
 		Type:    typeOIDCDiscoverySucceeded,
 		Status:  v1alpha1.ConditionTrue,
@@ -3,7 +3,22 @@
 
 package upstreamwatchers
 
-import "go.pinniped.dev/internal/constable"
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/base64"
+	"fmt"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	corev1informers "k8s.io/client-go/informers/core/v1"
+
+	"go.pinniped.dev/generated/latest/apis/supervisor/idp/v1alpha1"
+	"go.pinniped.dev/internal/constable"
+	"go.pinniped.dev/internal/plog"
+	"go.pinniped.dev/internal/upstreamldap"
+)
 
 const (
 	ReasonNotFound         = "SecretNotFound"
@@ -12,5 +27,325 @@ const (
 	ReasonSuccess          = "Success"
 	ReasonInvalidTLSConfig = "InvalidTLSConfig"
 
+	ReasonInvalidClientCertificate = "SecretInvalidClientCertificate"
+
 	ErrNoCertificates = constable.Error("no certificates found")
+
+	LDAPBindAccountSecretType = corev1.SecretTypeBasicAuth
+	TestLDAPConnectionTimeout = 90 * time.Second
+
+	// LDAPBindClientCertificateSecretType is the type of the bind Secrets which hold a client certificate, for the
+	// upstreams which support SASL EXTERNAL binds.
+	LDAPBindClientCertificateSecretType = corev1.SecretTypeTLS
+
+	// Constants related to conditions.
+	typeBindSecretValid           = "BindSecretValid"
+	typeTLSConfigurationValid     = "TLSConfigurationValid"
+	typeLDAPConnectionValid       = "LDAPConnectionValid"
+	reasonLDAPConnectionError     = "LDAPConnectionError"
+	noTLSConfigurationMessage     = "no TLS configuration provided"
+	loadedTLSConfigurationMessage = "loaded TLS configuration"
 )
+
+// An in-memory cache with an entry for each LDAP-like upstream IDP, to keep track of which ResourceVersion
+// of the bind Secret and which TLS/StartTLS setting was used during the most recent successful validation.
+type SecretVersionCache struct {
+	ValidatedSettingsByName map[string]ValidatedSettings
+}
+
+type ValidatedSettings struct {
+	BindSecretResourceVersion string
+	LDAPConnectionProtocol    upstreamldap.LDAPConnectionProtocol
+}
+
+func NewSecretVersionCache() *SecretVersionCache {
+	return &SecretVersionCache{ValidatedSettingsByName: map[string]ValidatedSettings{}}
+}
+
+// UpstreamGenericLDAPIDP is implemented by adapters of each LDAP-like upstream IDP custom resource type,
+// e.g. LDAPIdentityProvider and ActiveDirectoryIdentityProvider, so they can share validation logic.
+type UpstreamGenericLDAPIDP interface {
+	Name() string
+	Generation() int64
+	Namespace() string
+	TLSSpec() *v1alpha1.TLSSpec
+	BindSecretName() string
+	Conditions() []v1alpha1.Condition
+
+	// AllowsClientCertificateBind returns true when the bind Secret may hold a client certificate instead of a
+	// username and password.
+	AllowsClientCertificateBind() bool
+}
+
+// ValidateGenericLDAP validates the settings which are common to all LDAP-like upstream IDPs. As a side effect,
+// it fills in the bind credentials, CA bundle, and connection protocol of the config. It returns the resulting
+// conditions, whether the upstream is valid enough to be loaded into the cache, and whether the controller should
+// try again later because the upstream is not fully validated.
+func ValidateGenericLDAP(
+	ctx context.Context,
+	upstream UpstreamGenericLDAPIDP,
+	secretInformer corev1informers.SecretInformer,
+	validatedSecretVersionsCache *SecretVersionCache,
+	config *upstreamldap.ProviderConfig,
+) (conditions []*v1alpha1.Condition, loadable bool, requeue bool) {
+	secretValidCondition, currentSecretVersion := validateSecret(secretInformer, upstream, config)
+	tlsValidCondition := validateTLSConfig(upstream.TLSSpec(), config)
+	conditions = append(conditions, secretValidCondition, tlsValidCondition)
+
+	// No point in trying to connect to the server if the config was already determined to be invalid.
+	var finishedConfigCondition *v1alpha1.Condition
+	if secretValidCondition.Status == v1alpha1.ConditionTrue && tlsValidCondition.Status == v1alpha1.ConditionTrue {
+		finishedConfigCondition = validateFinishedConfig(ctx, upstream, config, currentSecretVersion, validatedSecretVersionsCache)
+		if finishedConfigCondition != nil {
+			conditions = append(conditions, finishedConfigCondition)
+		}
+	}
+
+	switch {
+	case secretValidCondition.Status != v1alpha1.ConditionTrue || tlsValidCondition.Status != v1alpha1.ConditionTrue:
+		// Invalid provider, so do not load it into the cache.
+		return conditions, false, true
+	case finishedConfigCondition != nil && finishedConfigCondition.Status != v1alpha1.ConditionTrue:
+		// Error but load it into the cache anyway, treating this condition failure more like a warning.
+		// Try again hoping that the condition will improve.
+		return conditions, true, true
+	default:
+		// Fully validated provider, so load it into the cache.
+		return conditions, true, false
+	}
+}
+
+func validateTLSConfig(tlsSpec *v1alpha1.TLSSpec, config *upstreamldap.ProviderConfig) *v1alpha1.Condition {
+	if tlsSpec == nil {
+		return validTLSCondition(noTLSConfigurationMessage)
+	}
+	if len(tlsSpec.CertificateAuthorityData) == 0 {
+		return validTLSCondition(loadedTLSConfigurationMessage)
+	}
+
+	bundle, err := base64.StdEncoding.DecodeString(tlsSpec.CertificateAuthorityData)
+	if err != nil {
+		return invalidTLSCondition(fmt.Sprintf("certificateAuthorityData is invalid: %s", err.Error()))
+	}
+
+	ca := x509.NewCertPool()
+	ok := ca.AppendCertsFromPEM(bundle)
+	if !ok {
+		return invalidTLSCondition(fmt.Sprintf("certificateAuthorityData is invalid: %s", ErrNoCertificates))
+	}
+
+	config.CABundle = bundle
+	return validTLSCondition(loadedTLSConfigurationMessage)
+}
+
+func validateFinishedConfig(
+	ctx context.Context,
+	upstream UpstreamGenericLDAPIDP,
+	config *upstreamldap.ProviderConfig,
+	currentSecretVersion string,
+	validatedSecretVersionsCache *SecretVersionCache,
+) *v1alpha1.Condition {
+	if hasPreviousSuccessfulConditionForCurrentSpecGenerationAndSecretVersion(upstream, currentSecretVersion, config, validatedSecretVersionsCache) {
+		return nil
+	}
+
+	testConnectionTimeout, cancelFunc := context.WithTimeout(ctx, TestLDAPConnectionTimeout)
+	defer cancelFunc()
+
+	condition := testConnection(testConnectionTimeout, upstream, config, currentSecretVersion)
+
+	if condition.Status == v1alpha1.ConditionTrue {
+		// Remember (in-memory for this pod) that the controller has successfully validated the LDAP provider
+		// using this version of the Secret. This is for performance reasons, to avoid attempting to connect to
+		// the LDAP server more than is needed. If the pod restarts, it will attempt this validation again.
+		validatedSecretVersionsCache.ValidatedSettingsByName[upstream.Name()] = ValidatedSettings{
+			BindSecretResourceVersion: currentSecretVersion,
+			LDAPConnectionProtocol:    config.ConnectionProtocol,
+		}
+	}
+
+	return condition
+}
+
+func testConnection(
+	ctx context.Context,
+	upstream UpstreamGenericLDAPIDP,
+	config *upstreamldap.ProviderConfig,
+	currentSecretVersion string,
+) *v1alpha1.Condition {
+	// First try using TLS.
+	config.ConnectionProtocol = upstreamldap.TLS
+	tlsLDAPProvider := upstreamldap.New(*config)
+	err := tlsLDAPProvider.TestConnection(ctx)
+	if err != nil {
+		plog.InfoErr("testing LDAP connection using TLS failed, so trying again with StartTLS", err, "host", config.Host)
+		// If there was any error, try again with StartTLS instead.
+		config.ConnectionProtocol = upstreamldap.StartTLS
+		startTLSLDAPProvider := upstreamldap.New(*config)
+		startTLSErr := startTLSLDAPProvider.TestConnection(ctx)
+		if startTLSErr == nil {
+			plog.Info("testing LDAP connection using StartTLS succeeded", "host", config.Host)
+			// Successfully able to fall back to using StartTLS, so clear the original
+			// error and consider the connection test to be successful.
+			err = nil
+		} else {
+			plog.InfoErr("testing LDAP connection using StartTLS also failed", err, "host", config.Host)
+			// Falling back to StartTLS also failed, so put TLS back into the config
+			// and consider the connection test to be failed.
+			config.ConnectionProtocol = upstreamldap.TLS
+		}
+	}
+
+	if err != nil {
+		return &v1alpha1.Condition{
+			Type:   typeLDAPConnectionValid,
+			Status: v1alpha1.ConditionFalse,
+			Reason: reasonLDAPConnectionError,
+			Message: fmt.Sprintf(`could not successfully connect to "%s" and bind as user "%s": %s`,
+				config.Host, config.BindUsername, err.Error()),
+		}
+	}
+
+	return &v1alpha1.Condition{
+		Type:   typeLDAPConnectionValid,
+		Status: v1alpha1.ConditionTrue,
+		Reason: ReasonSuccess,
+		Message: fmt.Sprintf(`successfully able to connect to "%s" and bind as user "%s" [validated with Secret "%s" at version "%s"]`,
+			config.Host, config.BindUsername, upstream.BindSecretName(), currentSecretVersion),
+	}
+}
+
+func hasPreviousSuccessfulConditionForCurrentSpecGenerationAndSecretVersion(
+	upstream UpstreamGenericLDAPIDP,
+	currentSecretVersion string,
+	config *upstreamldap.ProviderConfig,
+	validatedSecretVersionsCache *SecretVersionCache,
+) bool {
+	currentGeneration := upstream.Generation()
+	for _, cond := range upstream.Conditions() {
+		if cond.Type == typeLDAPConnectionValid && cond.Status == v1alpha1.ConditionTrue && cond.ObservedGeneration == currentGeneration {
+			// Found a previously successful condition for the current spec generation.
+			// Now figure out which version of the bind Secret was used during that previous validation, if any.
+			validatedSecretVersion := validatedSecretVersionsCache.ValidatedSettingsByName[upstream.Name()]
+			if validatedSecretVersion.BindSecretResourceVersion == currentSecretVersion {
+				// Reload the TLS vs StartTLS setting that was previously validated.
+				config.ConnectionProtocol = validatedSecretVersion.LDAPConnectionProtocol
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func validTLSCondition(message string) *v1alpha1.Condition {
+	return &v1alpha1.Condition{
+		Type:    typeTLSConfigurationValid,
+		Status:  v1alpha1.ConditionTrue,
+		Reason:  ReasonSuccess,
+		Message: message,
+	}
+}
+
+func invalidTLSCondition(message string) *v1alpha1.Condition {
+	return &v1alpha1.Condition{
+		Type:    typeTLSConfigurationValid,
+		Status:  v1alpha1.ConditionFalse,
+		Reason:  ReasonInvalidTLSConfig,
+		Message: message,
+	}
+}
+
+func validateSecret(
+	secretInformer corev1informers.SecretInformer,
+	upstream UpstreamGenericLDAPIDP,
+	config *upstreamldap.ProviderConfig,
+) (*v1alpha1.Condition, string) {
+	secretName := upstream.BindSecretName()
+
+	secret, err := secretInformer.Lister().Secrets(upstream.Namespace()).Get(secretName)
+	if err != nil {
+		return &v1alpha1.Condition{
+			Type:    typeBindSecretValid,
+			Status:  v1alpha1.ConditionFalse,
+			Reason:  ReasonNotFound,
+			Message: err.Error(),
+		}, ""
+	}
+
+	if secret.Type == LDAPBindClientCertificateSecretType && upstream.AllowsClientCertificateBind() {
+		return validateClientCertificateSecret(secret, config), secret.ResourceVersion
+	}
+
+	if secret.Type != corev1.SecretTypeBasicAuth {
+		wantTypes := fmt.Sprintf("%q", corev1.SecretTypeBasicAuth)
+		if upstream.AllowsClientCertificateBind() {
+			wantTypes = fmt.Sprintf("%q or %q", corev1.SecretTypeBasicAuth, LDAPBindClientCertificateSecretType)
+		}
+		return &v1alpha1.Condition{
+			Type:   typeBindSecretValid,
+			Status: v1alpha1.ConditionFalse,
+			Reason: ReasonWrongType,
+			Message: fmt.Sprintf("referenced Secret %q has wrong type %q (should be %s)",
+				secretName, secret.Type, wantTypes),
+		}, secret.ResourceVersion
+	}
+
+	config.BindUsername = string(secret.Data[corev1.BasicAuthUsernameKey])
+	config.BindPassword = string(secret.Data[corev1.BasicAuthPasswordKey])
+	if len(config.BindUsername) == 0 || len(config.BindPassword) == 0 {
+		return &v1alpha1.Condition{
+			Type:   typeBindSecretValid,
+			Status: v1alpha1.ConditionFalse,
+			Reason: ReasonMissingKeys,
+			Message: fmt.Sprintf("referenced Secret %q is missing required keys %q",
+				secretName, []string{corev1.BasicAuthUsernameKey, corev1.BasicAuthPasswordKey}),
+		}, secret.ResourceVersion
+	}
+
+	return &v1alpha1.Condition{
+		Type:    typeBindSecretValid,
+		Status:  v1alpha1.ConditionTrue,
+		Reason:  ReasonSuccess,
+		Message: "loaded bind secret",
+	}, secret.ResourceVersion
+}
+
+// validateClientCertificateSecret loads the client certificate of a bind Secret of type kubernetes.io/tls, for a SASL
+// EXTERNAL bind. The subject of the certificate becomes the BindUsername, which is only used in messages.
+func validateClientCertificateSecret(secret *corev1.Secret, config *upstreamldap.ProviderConfig) *v1alpha1.Condition {
+	certPEM := secret.Data[corev1.TLSCertKey]
+	keyPEM := secret.Data[corev1.TLSPrivateKeyKey]
+	if len(certPEM) == 0 || len(keyPEM) == 0 {
+		return &v1alpha1.Condition{
+			Type:   typeBindSecretValid,
+			Status: v1alpha1.ConditionFalse,
+			Reason: ReasonMissingKeys,
+			Message: fmt.Sprintf("referenced Secret %q is missing required keys %q",
+				secret.Name, []string{corev1.TLSCertKey, corev1.TLSPrivateKeyKey}),
+		}
+	}
+
+	clientCert, err := tls.X509KeyPair(certPEM, keyPEM)
+	if err == nil {
+		clientCert.Leaf, err = x509.ParseCertificate(clientCert.Certificate[0])
+	}
+	if err != nil {
+		return &v1alpha1.Condition{
+			Type:    typeBindSecretValid,
+			Status:  v1alpha1.ConditionFalse,
+			Reason:  ReasonInvalidClientCertificate,
+			Message: fmt.Sprintf("referenced Secret %q has an invalid client certificate: %s", secret.Name, err.Error()),
+		}
+	}
+
+	config.BindUsername = clientCert.Leaf.Subject.String()
+	config.BindPassword = ""
+	config.BindClientCertificateData = certPEM
+	config.BindClientKeyData = keyPEM
+	return &v1alpha1.Condition{
+		Type:    typeBindSecretValid,
+		Status:  v1alpha1.ConditionTrue,
+		Reason:  ReasonSuccess,
+		Message: "loaded bind client certificate",
+	}
+}
@@ -0,0 +1,54 @@
+// Copyright 2021 the Pinniped contributors. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package supervisorstorage
+
+import (
+	"k8s.io/apimachinery/pkg/util/clock"
+
+	pinnipedcontroller "go.pinniped.dev/internal/controller"
+	"go.pinniped.dev/internal/controllerlib"
+	"go.pinniped.dev/internal/crud"
+	"go.pinniped.dev/internal/plog"
+)
+
+type backendGarbageCollectorController struct {
+	backend crud.GarbageCollectingBackend
+	clock   clock.Clock
+}
+
+// BackendGarbageCollectorController garbage collects the session storage of the storage backends which do not use
+// Secrets. There are no informers to trigger it, so it sweeps the backend every minimumRepeatInterval instead, and
+// deletes the same expired data which GarbageCollectorController would delete from the Secrets.
+func BackendGarbageCollectorController(
+	clock clock.Clock,
+	backend crud.GarbageCollectingBackend,
+	withInitialEvent pinnipedcontroller.WithInitialEventOptionFunc,
+) controllerlib.Controller {
+	return controllerlib.New(
+		controllerlib.Config{
+			Name: "backend-garbage-collector-controller",
+			Syncer: &backendGarbageCollectorController{
+				backend: backend,
+				clock:   clock,
+			},
+		},
+		withInitialEvent(controllerlib.Key{}),
+	)
+}
+
+func (c *backendGarbageCollectorController) Sync(ctx controllerlib.Context) error {
+	// Schedule the next sweep first, so that a failed sweep is also retried.
+	ctx.Queue.AddAfter(ctx.Key, minimumRepeatInterval)
+
+	plog.Info("starting storage garbage collection sweep")
+	deleted, err := c.backend.GarbageCollect(ctx.Context, c.clock.Now())
+	if err != nil {
+		plog.WarningErr("failed to garbage collect storage backend", err)
+		return err
+	}
+	if deleted > 0 {
+		plog.Info("storage garbage collector deleted resources", "count", deleted)
+	}
+	return nil
+}
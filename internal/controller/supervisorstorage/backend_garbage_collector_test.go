@@ -0,0 +1,89 @@
+// Copyright 2021 the Pinniped contributors. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package supervisorstorage
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/util/clock"
+
+	"go.pinniped.dev/internal/controllerlib"
+	"go.pinniped.dev/internal/crud"
+)
+
+type failingBackend struct {
+	crud.GarbageCollectingBackend
+}
+
+func (failingBackend) GarbageCollect(_ context.Context, _ time.Time) (int, error) {
+	return 0, errors.New("some garbage collection error")
+}
+
+func TestBackendGarbageCollectorControllerSync(t *testing.T) {
+	type testJSON struct {
+		Data string
+	}
+
+	ctx := context.Background()
+	frozenNow := time.Date(2030, time.January, 1, 0, 0, 0, 0, time.UTC)
+	fakeClock := clock.NewFakeClock(frozenNow)
+
+	backend := crud.NewMemoryBackend()
+	storage := backend.Storage("access-token", fakeClock.Now, time.Minute)
+	_, err := storage.Create(ctx, "expired-signature", &testJSON{Data: "expired"}, nil)
+	require.NoError(t, err)
+	fakeClock.Step(30 * time.Second)
+	_, err = storage.Create(ctx, "unexpired-signature", &testJSON{Data: "unexpired"}, nil)
+	require.NoError(t, err)
+	fakeClock.Step(31 * time.Second)
+
+	tests := []struct {
+		name    string
+		backend crud.GarbageCollectingBackend
+		wantErr string
+	}{
+		{
+			name:    "deletes the expired data and schedules the next sweep",
+			backend: backend,
+		},
+		{
+			name:    "schedules the next sweep even when the sweep fails",
+			backend: failingBackend{},
+			wantErr: "some garbage collection error",
+		},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			subject := BackendGarbageCollectorController(fakeClock, tt.backend, controllerlib.WithInitialEvent)
+			queue := &testQueue{t: t}
+			syncContext := controllerlib.Context{
+				Context: ctx,
+				Name:    subject.Name(),
+				Key:     controllerlib.Key{},
+				Queue:   queue,
+			}
+
+			err := controllerlib.TestSync(t, subject, syncContext)
+			if tt.wantErr != "" {
+				require.EqualError(t, err, tt.wantErr)
+			} else {
+				require.NoError(t, err)
+			}
+			require.True(t, queue.called)
+			require.Equal(t, controllerlib.Key{}, queue.key)
+			require.Equal(t, minimumRepeatInterval, queue.duration)
+		})
+	}
+
+	_, err = storage.Get(ctx, "expired-signature", &testJSON{})
+	require.True(t, apierrors.IsNotFound(err), err)
+	_, err = storage.Get(ctx, "unexpired-signature", &testJSON{})
+	require.NoError(t, err)
+}
@@ -0,0 +1,136 @@
+// Copyright 2021 the Pinniped contributors. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package supervisorstorage
+
+import (
+	"context"
+	"time"
+
+	"github.com/ory/fosite"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/sets"
+
+	configv1alpha1 "go.pinniped.dev/generated/latest/apis/supervisor/config/v1alpha1"
+	"go.pinniped.dev/internal/crud"
+	"go.pinniped.dev/internal/fositestorage"
+	"go.pinniped.dev/internal/fositestorage/accesstoken"
+	"go.pinniped.dev/internal/fositestorage/refreshtoken"
+	"go.pinniped.dev/internal/plog"
+)
+
+// BackendSessions lists and revokes the active downstream sessions by reading them directly from a storage backend
+// which does not use Secrets. The sessions of those backends are not mirrored into Session objects, because that
+// would copy every session into etcd anyway. A session is active while its access tokens or refresh tokens are
+// stored, and it is named after its request ID, just like the Sessions of the Secrets storage backend.
+type BackendSessions struct {
+	backend crud.GarbageCollectingBackend
+	storage *backendSessionStorage
+}
+
+// NewBackendSessions returns the BackendSessions of the backend.
+func NewBackendSessions(backend crud.GarbageCollectingBackend) *BackendSessions {
+	// The lifetimes of the storages only apply to newly created data, and the sessions are only read from them.
+	return &BackendSessions{
+		backend: backend,
+		storage: &backendSessionStorage{
+			accessTokens:  backend.ListingStorage(accesstoken.TypeLabelValue, time.Now, 0),
+			refreshTokens: backend.ListingStorage(refreshtoken.TypeLabelValue, time.Now, 0),
+		},
+	}
+}
+
+// List returns the active sessions as Sessions in the namespace, sorted by their names. The Sessions only have
+// their names, namespaces and statuses, since they are not stored anywhere.
+func (s *BackendSessions) List(ctx context.Context, namespace string) ([]configv1alpha1.Session, error) {
+	requestIDs, err := s.storage.listRequestIDs(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	sessions := []configv1alpha1.Session{}
+	for _, requestID := range requestIDs {
+		stored, err := s.storage.findStoredSession(ctx, requestID)
+		if err != nil {
+			return nil, err
+		}
+		if stored == nil {
+			continue
+		}
+		sessions = append(sessions, configv1alpha1.Session{
+			ObjectMeta: metav1.ObjectMeta{Name: requestID, Namespace: namespace},
+			Status:     stored.status(),
+		})
+	}
+	return sessions, nil
+}
+
+// Revoke deletes all stored data of the session with the request ID from the backend. It returns false when there
+// is no such active session.
+func (s *BackendSessions) Revoke(ctx context.Context, requestID string) (bool, error) {
+	stored, err := s.storage.findStoredSession(ctx, requestID)
+	if err != nil {
+		return false, err
+	}
+	if stored == nil {
+		return false, nil
+	}
+	if err := revokeStoredData(ctx, s.backend, requestID); err != nil {
+		return false, err
+	}
+
+	status := stored.status()
+	plog.Info("revoked session", "session", requestID, "username", status.Username, "subject", status.Subject)
+	return true, nil
+}
+
+// backendSessionStorage finds the sessions in the access token and refresh token storages of a backend which
+// does not use Secrets.
+type backendSessionStorage struct {
+	accessTokens  crud.ListingStorage
+	refreshTokens crud.ListingStorage
+}
+
+// findStoredSession finds the active session with the request ID in the access token and refresh token storages.
+// It returns nil when none of the tokens of the session can be read.
+func (s *backendSessionStorage) findStoredSession(ctx context.Context, requestID string) (*storedSession, error) {
+	var stored *storedSession
+	for _, tokens := range []struct {
+		storage crud.ListingStorage
+		read    func(item *crud.ListedItem) (*fosite.Request, error)
+	}{
+		{storage: s.accessTokens, read: accesstoken.ReadFromListedItem},
+		{storage: s.refreshTokens, read: refreshtoken.ReadFromListedItem},
+	} {
+		items, err := tokens.storage.ListByLabel(ctx, fositestorage.StorageRequestIDLabelName, requestID)
+		if err != nil {
+			return nil, err
+		}
+		for _, item := range items {
+			request, err := tokens.read(item)
+			if err != nil {
+				plog.WarningErr("could not read session storage item", err, "signature", item.Signature)
+				continue
+			}
+
+			if stored == nil {
+				stored = &storedSession{}
+			}
+			stored.add(request, item.CreatedAt, item.Signature, toMetav1Time(item.GarbageCollectAfter))
+		}
+	}
+	return stored, nil
+}
+
+// listRequestIDs lists the sorted request IDs of the sessions which have access tokens or refresh tokens.
+func (s *backendSessionStorage) listRequestIDs(ctx context.Context) ([]string, error) {
+	requestIDs := sets.NewString()
+	for _, storage := range []crud.ListingStorage{s.accessTokens, s.refreshTokens} {
+		values, err := storage.LabelValues(ctx, fositestorage.StorageRequestIDLabelName)
+		if err != nil {
+			return nil, err
+		}
+		requestIDs.Insert(values...)
+	}
+	return requestIDs.List(), nil
+}
@@ -0,0 +1,100 @@
+// Copyright 2021 the Pinniped contributors. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package supervisorstorage
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/ory/fosite"
+	"github.com/ory/fosite/handler/openid"
+	"github.com/ory/fosite/token/jwt"
+	"github.com/stretchr/testify/require"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	configv1alpha1 "go.pinniped.dev/generated/latest/apis/supervisor/config/v1alpha1"
+	"go.pinniped.dev/internal/crud"
+	"go.pinniped.dev/internal/fositestorage/accesstoken"
+	"go.pinniped.dev/internal/fositestorage/authorizationcode"
+	"go.pinniped.dev/internal/fositestorage/refreshtoken"
+	"go.pinniped.dev/internal/oidc/clientregistry"
+	"go.pinniped.dev/internal/psession"
+)
+
+func TestBackendSessions(t *testing.T) {
+	t.Parallel()
+
+	const namespace = "some-namespace"
+
+	now := time.Date(2021, 1, 2, 3, 4, 5, 0, time.UTC)
+	nowFunc := func() time.Time { return now }
+
+	newRequest := func(requestID string) *fosite.Request {
+		return &fosite.Request{
+			ID:     requestID,
+			Client: &clientregistry.Client{DefaultOpenIDConnectClient: fosite.DefaultOpenIDConnectClient{DefaultClient: &fosite.DefaultClient{ID: "pinniped-cli"}}},
+			Session: &psession.PinnipedSession{
+				Fosite: &openid.DefaultSession{
+					Claims: &jwt.IDTokenClaims{
+						Subject: "https://upstream.example.com?sub=" + requestID + "-user",
+						Extra:   map[string]interface{}{"username": requestID + "-user"},
+					},
+				},
+				Custom: &psession.CustomSessionData{ProviderName: "some-upstream", ProviderType: "oidc"},
+			},
+		}
+	}
+
+	newSession := func(requestID string, expiresAt time.Time) configv1alpha1.Session {
+		return configv1alpha1.Session{
+			ObjectMeta: metav1.ObjectMeta{Name: requestID, Namespace: namespace},
+			Status: configv1alpha1.SessionStatus{
+				Username:                     requestID + "-user",
+				Subject:                      "https://upstream.example.com?sub=" + requestID + "-user",
+				ClientID:                     "pinniped-cli",
+				UpstreamIdentityProviderName: "some-upstream",
+				UpstreamIdentityProviderType: "oidc",
+				ExpiresAt:                    &metav1.Time{Time: expiresAt},
+			},
+		}
+	}
+
+	ctx := context.Background()
+	backend := crud.NewMemoryBackend()
+	// session-1 has both kinds of tokens, session-2 only has a refresh token, and session-3 only has an
+	// authorization code, so it is not active.
+	require.NoError(t, accesstoken.New(backend, nowFunc, 2*time.Minute).CreateAccessTokenSession(ctx, "sig-1", newRequest("session-1")))
+	require.NoError(t, refreshtoken.New(backend, nowFunc, 9*time.Hour).CreateRefreshTokenSession(ctx, "sig-1", newRequest("session-1")))
+	require.NoError(t, refreshtoken.New(backend, nowFunc, time.Hour).CreateRefreshTokenSession(ctx, "sig-2", newRequest("session-2")))
+	require.NoError(t, authorizationcode.New(backend, nowFunc, 10*time.Minute).CreateAuthorizeCodeSession(ctx, "sig-3", newRequest("session-3")))
+
+	sessions := NewBackendSessions(backend)
+
+	listed, err := sessions.List(ctx, namespace)
+	require.NoError(t, err)
+	require.Equal(t, []configv1alpha1.Session{
+		newSession("session-1", now.Add(9*time.Hour)),
+		newSession("session-2", now.Add(time.Hour)),
+	}, listed)
+
+	revoked, err := sessions.Revoke(ctx, "session-1")
+	require.NoError(t, err)
+	require.True(t, revoked)
+
+	revoked, err = sessions.Revoke(ctx, "session-3")
+	require.NoError(t, err)
+	require.False(t, revoked)
+
+	listed, err = sessions.List(ctx, namespace)
+	require.NoError(t, err)
+	require.Equal(t, []configv1alpha1.Session{newSession("session-2", now.Add(time.Hour))}, listed)
+
+	// All of the stored data of the revoked session was deleted.
+	_, err = refreshtoken.New(backend, nowFunc, 0).GetRefreshTokenSession(ctx, "sig-1", nil)
+	require.True(t, errors.Is(err, fosite.ErrNotFound))
+	_, err = accesstoken.New(backend, nowFunc, 0).GetAccessTokenSession(ctx, "sig-1", nil)
+	require.True(t, errors.Is(err, fosite.ErrNotFound))
+}
@@ -0,0 +1,281 @@
+// Copyright 2021 the Pinniped contributors. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package supervisorstorage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/ory/fosite"
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/equality"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	corev1informers "k8s.io/client-go/informers/core/v1"
+	"k8s.io/klog/v2"
+
+	configv1alpha1 "go.pinniped.dev/generated/latest/apis/supervisor/config/v1alpha1"
+	pinnipedclientset "go.pinniped.dev/generated/latest/client/supervisor/clientset/versioned"
+	configinformers "go.pinniped.dev/generated/latest/client/supervisor/informers/externalversions/config/v1alpha1"
+	pinnipedcontroller "go.pinniped.dev/internal/controller"
+	"go.pinniped.dev/internal/controllerlib"
+	"go.pinniped.dev/internal/crud"
+	"go.pinniped.dev/internal/fositestorage"
+	"go.pinniped.dev/internal/fositestorage/accesstoken"
+	"go.pinniped.dev/internal/fositestorage/authorizationcode"
+	"go.pinniped.dev/internal/fositestorage/openidconnect"
+	"go.pinniped.dev/internal/fositestorage/pkce"
+	"go.pinniped.dev/internal/fositestorage/refreshtoken"
+	"go.pinniped.dev/internal/oidc/downstreamsession"
+	"go.pinniped.dev/internal/plog"
+	"go.pinniped.dev/internal/psession"
+)
+
+type sessionController struct {
+	backend         crud.Backend
+	pinnipedClient  pinnipedclientset.Interface
+	sessionInformer configinformers.SessionInformer
+
+	// findStoredSession finds the active session with the request ID in the session storage. It returns nil when
+	// none of the tokens of the session can be read.
+	findStoredSession func(ctx context.Context, namespace, requestID string) (*storedSession, error)
+}
+
+// SessionController creates a controllerlib.Controller which mirrors the active downstream sessions in the session
+// storage Secrets into Session objects, so they can be listed by username or subject. A session is active while
+// its access tokens or refresh tokens are stored. When a Session is marked as revoked, the controller deletes all of
+// the stored data of that session from the backend and then deletes the Session. Each session is reconciled on its
+// own, keyed by its request ID, which is also the name of its Session.
+// It is only used with the Secrets storage backend, because it watches the session storage Secrets. The sessions of
+// the other storage backends are not mirrored into Sessions, see BackendSessions instead.
+func SessionController(
+	backend crud.Backend,
+	pinnipedClient pinnipedclientset.Interface,
+	secretInformer corev1informers.SecretInformer,
+	sessionInformer configinformers.SessionInformer,
+	withInformer pinnipedcontroller.WithInformerOptionFunc,
+) controllerlib.Controller {
+	isTokenSecret := func(obj metav1.Object) bool {
+		if obj.GetLabels()[fositestorage.StorageRequestIDLabelName] == "" {
+			return false
+		}
+		switch obj.GetLabels()[crud.SecretLabelKey] {
+		case accesstoken.TypeLabelValue, refreshtoken.TypeLabelValue:
+			return true
+		default:
+			return false
+		}
+	}
+	return controllerlib.New(
+		controllerlib.Config{
+			Name: "session-controller",
+			Syncer: &sessionController{
+				backend:           backend,
+				pinnipedClient:    pinnipedClient,
+				sessionInformer:   sessionInformer,
+				findStoredSession: (&secretsSessionStorage{secretInformer: secretInformer}).findStoredSession,
+			},
+		},
+		withInformer(
+			secretInformer,
+			pinnipedcontroller.SimpleFilter(isTokenSecret, sessionParentFunc),
+			controllerlib.InformerOption{},
+		),
+		withInformer(
+			sessionInformer,
+			pinnipedcontroller.MatchAnythingFilter(nil),
+			controllerlib.InformerOption{},
+		),
+	)
+}
+
+// sessionParentFunc returns the key of the Session of a token Secret, which is named after the request ID of the
+// session.
+func sessionParentFunc(obj metav1.Object) controllerlib.Key {
+	return controllerlib.Key{Namespace: obj.GetNamespace(), Name: obj.GetLabels()[fositestorage.StorageRequestIDLabelName]}
+}
+
+// storedSession is an active session which was found in the session storage.
+type storedSession struct {
+	// newestRequest is the content of the most recently created token of the session, which is identified by
+	// newestCreatedAt and newestName. It holds the most recent identity of the user, since the session is updated
+	// during refreshes.
+	newestRequest   *fosite.Request
+	newestCreatedAt time.Time
+	newestName      string
+
+	// expiresAt is the latest time at which one of the tokens of the session will be garbage collected.
+	expiresAt *metav1.Time
+}
+
+// Sync implements controllerlib.Syncer.
+func (c *sessionController) Sync(ctx controllerlib.Context) error {
+	requestID := ctx.Key.Name
+	stored, err := c.findStoredSession(ctx.Context, ctx.Key.Namespace, requestID)
+	if err != nil {
+		return err
+	}
+
+	session, err := c.sessionInformer.Lister().Sessions(ctx.Key.Namespace).Get(requestID)
+	notFound := apierrors.IsNotFound(err)
+	if err != nil && !notFound {
+		return fmt.Errorf("failed to get Session %s: %w", requestID, err)
+	}
+
+	switch {
+	case notFound && stored == nil:
+		return nil
+	case notFound:
+		session := &configv1alpha1.Session{
+			ObjectMeta: metav1.ObjectMeta{Name: requestID, Namespace: ctx.Key.Namespace},
+			Status:     stored.status(),
+		}
+		if _, err := c.pinnipedClient.ConfigV1alpha1().Sessions(ctx.Key.Namespace).Create(ctx.Context, session, metav1.CreateOptions{}); err != nil {
+			return fmt.Errorf("failed to create Session %s: %w", requestID, err)
+		}
+	case session.Spec.Revoked:
+		return c.revoke(ctx.Context, session)
+	case stored == nil:
+		// The session has expired, or its tokens were revoked by some other means.
+		return c.deleteSession(ctx.Context, session)
+	case !equality.Semantic.DeepEqual(session.Status, stored.status()):
+		updated := session.DeepCopy()
+		updated.Status = stored.status()
+		if _, err := c.pinnipedClient.ConfigV1alpha1().Sessions(session.Namespace).Update(ctx.Context, updated, metav1.UpdateOptions{}); err != nil {
+			return fmt.Errorf("failed to update Session %s: %w", session.Name, err)
+		}
+	}
+	return nil
+}
+
+// secretsSessionStorage finds the sessions in the session storage Secrets.
+type secretsSessionStorage struct {
+	secretInformer corev1informers.SecretInformer
+}
+
+// findStoredSession finds the active session with the request ID in the access token and refresh token Secrets.
+func (s *secretsSessionStorage) findStoredSession(_ context.Context, namespace, requestID string) (*storedSession, error) {
+	selector := labels.SelectorFromSet(labels.Set{fositestorage.StorageRequestIDLabelName: requestID})
+	secrets, err := s.secretInformer.Lister().Secrets(namespace).List(selector)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list Secrets: %w", err)
+	}
+
+	var stored *storedSession
+	for _, secret := range secrets {
+		var request *fosite.Request
+		switch secret.Labels[crud.SecretLabelKey] {
+		case accesstoken.TypeLabelValue:
+			request, err = accesstoken.ReadFromSecret(secret)
+		case refreshtoken.TypeLabelValue:
+			request, err = refreshtoken.ReadFromSecret(secret)
+		default:
+			continue
+		}
+		if err != nil {
+			plog.WarningErr("could not read session storage secret", err, "secretName", secret.Name, "secretNamespace", secret.Namespace)
+			continue
+		}
+
+		if stored == nil {
+			stored = &storedSession{}
+		}
+		stored.add(request, secret.CreationTimestamp.Time, secret.Name, garbageCollectAfter(secret))
+	}
+
+	return stored, nil
+}
+
+// revoke deletes all stored data of the session from the backend, and then deletes the Session.
+func (c *sessionController) revoke(ctx context.Context, session *configv1alpha1.Session) error {
+	if err := revokeStoredData(ctx, c.backend, session.Name); err != nil {
+		return fmt.Errorf("failed to revoke Session %s: %w", session.Name, err)
+	}
+
+	plog.Info("revoked session", "session", klog.KObj(session), "username", session.Status.Username, "subject", session.Status.Subject)
+	return c.deleteSession(ctx, session)
+}
+
+// revokeStoredData deletes all stored data of the session with the request ID from the backend. Some of the data may
+// have been deleted already, e.g. the PKCE data is deleted as soon as the authorization code is redeemed.
+func revokeStoredData(ctx context.Context, backend crud.Backend, requestID string) error {
+	// The lifetimes of the storages only apply to newly created data, so any lifetime will do for deletions.
+	revokers := []func(ctx context.Context, requestID string) error{
+		authorizationcode.New(backend, time.Now, 0).RevokeAuthorizeCodeSession,
+		pkce.New(backend, time.Now, 0).RevokePKCERequestSession,
+		openidconnect.New(backend, time.Now, 0).RevokeOpenIDConnectSession,
+		accesstoken.New(backend, time.Now, 0).RevokeAccessToken,
+		refreshtoken.New(backend, time.Now, 0).RevokeRefreshToken,
+	}
+	for _, revoke := range revokers {
+		if err := revoke(ctx, requestID); err != nil && !errors.Is(err, crud.ErrNoSecretsFound) {
+			return err
+		}
+	}
+	return nil
+}
+
+func (c *sessionController) deleteSession(ctx context.Context, session *configv1alpha1.Session) error {
+	err := c.pinnipedClient.ConfigV1alpha1().Sessions(session.Namespace).Delete(ctx, session.Name, metav1.DeleteOptions{})
+	if err != nil && !apierrors.IsNotFound(err) {
+		return fmt.Errorf("failed to delete Session %s: %w", session.Name, err)
+	}
+	return nil
+}
+
+// add adds one of the tokens of the session to the stored session. The name of the token, i.e. the name of its
+// Secret or its signature, orders the tokens which were created at the same time.
+func (s *storedSession) add(request *fosite.Request, createdAt time.Time, name string, expiresAt *metav1.Time) {
+	if s.newestRequest == nil || createdAt.After(s.newestCreatedAt) || (createdAt.Equal(s.newestCreatedAt) && name > s.newestName) {
+		s.newestRequest, s.newestCreatedAt, s.newestName = request, createdAt, name
+	}
+	if expiresAt != nil && (s.expiresAt == nil || s.expiresAt.Before(expiresAt)) {
+		s.expiresAt = expiresAt
+	}
+}
+
+// status returns the desired status of the Session of the stored session.
+func (s *storedSession) status() configv1alpha1.SessionStatus {
+	request := s.newestRequest
+	status := configv1alpha1.SessionStatus{ExpiresAt: s.expiresAt}
+	if request.Client != nil {
+		status.ClientID = request.Client.GetID()
+	}
+
+	session, ok := request.Session.(*psession.PinnipedSession)
+	if !ok || session.Fosite == nil {
+		return status
+	}
+	if session.Fosite.Claims != nil {
+		status.Subject, status.Username, status.Groups = downstreamsession.GetIdentityFromDownstreamSession(session)
+		if !session.Fosite.Claims.AuthTime.IsZero() {
+			status.AuthenticatedAt = toMetav1Time(session.Fosite.Claims.AuthTime)
+		}
+	}
+	if len(status.Groups) == 0 {
+		status.Groups = nil
+	}
+	if session.Custom != nil {
+		status.UpstreamIdentityProviderName = session.Custom.ProviderName
+		status.UpstreamIdentityProviderType = session.Custom.ProviderType
+	}
+	return status
+}
+
+func garbageCollectAfter(secret *v1.Secret) *metav1.Time {
+	garbageCollectAfterTime, err := time.Parse(crud.SecretLifetimeAnnotationDateFormat, secret.Annotations[crud.SecretLifetimeAnnotationKey])
+	if err != nil {
+		return nil
+	}
+	return toMetav1Time(garbageCollectAfterTime)
+}
+
+// toMetav1Time returns the time with the precision which survives the serialization of a metav1.Time, so that
+// the times of the Sessions can be compared to the times which are found in the session storage.
+func toMetav1Time(t time.Time) *metav1.Time {
+	return &metav1.Time{Time: t.UTC().Truncate(time.Second)}
+}
@@ -0,0 +1,325 @@
+// Copyright 2021 the Pinniped contributors. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package supervisorstorage
+
+import (
+	"context"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/ory/fosite"
+	"github.com/ory/fosite/handler/openid"
+	"github.com/ory/fosite/token/jwt"
+	"github.com/stretchr/testify/require"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	kubeinformers "k8s.io/client-go/informers"
+	kubernetesfake "k8s.io/client-go/kubernetes/fake"
+	kubetesting "k8s.io/client-go/testing"
+
+	configv1alpha1 "go.pinniped.dev/generated/latest/apis/supervisor/config/v1alpha1"
+	pinnipedfake "go.pinniped.dev/generated/latest/client/supervisor/clientset/versioned/fake"
+	pinnipedinformers "go.pinniped.dev/generated/latest/client/supervisor/informers/externalversions"
+	"go.pinniped.dev/internal/controllerlib"
+	"go.pinniped.dev/internal/crud"
+	"go.pinniped.dev/internal/fositestorage/accesstoken"
+	"go.pinniped.dev/internal/fositestorage/authorizationcode"
+	"go.pinniped.dev/internal/fositestorage/openidconnect"
+	"go.pinniped.dev/internal/fositestorage/pkce"
+	"go.pinniped.dev/internal/fositestorage/refreshtoken"
+	"go.pinniped.dev/internal/oidc/clientregistry"
+	"go.pinniped.dev/internal/psession"
+	"go.pinniped.dev/internal/testutil"
+)
+
+func TestSessionControllerInformerFilters(t *testing.T) {
+	t.Parallel()
+
+	observableWithInformerOption := testutil.NewObservableWithInformerOption()
+	secretInformer := kubeinformers.NewSharedInformerFactory(nil, 0).Core().V1().Secrets()
+	sessionInformer := pinnipedinformers.NewSharedInformerFactory(nil, 0).Config().V1alpha1().Sessions()
+	_ = SessionController(nil, nil, secretInformer, sessionInformer, observableWithInformerOption.WithInformer)
+
+	tokenSecret := func(storageType string) *corev1.Secret {
+		return &corev1.Secret{ObjectMeta: metav1.ObjectMeta{
+			Name:      "some-secret",
+			Namespace: "some-namespace",
+			Labels: map[string]string{
+				"storage.pinniped.dev/type":       storageType,
+				"storage.pinniped.dev/request-id": "some-request-id",
+			},
+		}}
+	}
+
+	secretFilter := observableWithInformerOption.GetFilterForInformer(secretInformer)
+	for _, secret := range []*corev1.Secret{tokenSecret("access-token"), tokenSecret("refresh-token")} {
+		require.True(t, secretFilter.Add(secret))
+		require.True(t, secretFilter.Update(secret, secret))
+		require.True(t, secretFilter.Delete(secret))
+		// Token Secrets are queued under the key of the Session of their session.
+		require.Equal(t, controllerlib.Key{Namespace: "some-namespace", Name: "some-request-id"}, secretFilter.Parent(secret))
+	}
+	otherSecret := tokenSecret("authcode")
+	require.False(t, secretFilter.Add(otherSecret))
+	require.False(t, secretFilter.Update(otherSecret, otherSecret))
+	require.False(t, secretFilter.Delete(otherSecret))
+
+	sessionFilter := observableWithInformerOption.GetFilterForInformer(sessionInformer)
+	session := &configv1alpha1.Session{ObjectMeta: metav1.ObjectMeta{Name: "some-request-id", Namespace: "some-namespace"}}
+	require.True(t, sessionFilter.Add(session))
+	require.Equal(t, controllerlib.Key{Namespace: "some-namespace", Name: "some-request-id"}, sessionFilter.Parent(session))
+}
+
+func TestSessionControllerSync(t *testing.T) {
+	t.Parallel()
+
+	const namespace = "some-namespace"
+
+	now := time.Date(2021, 1, 2, 3, 4, 5, 0, time.UTC)
+	nowFunc := func() time.Time { return now }
+	authTime := now.Add(-time.Hour)
+
+	sessionGVR := schema.GroupVersionResource{
+		Group:    configv1alpha1.SchemeGroupVersion.Group,
+		Version:  configv1alpha1.SchemeGroupVersion.Version,
+		Resource: "sessions",
+	}
+
+	newRequest := func(requestID string, groups []string) *fosite.Request {
+		return &fosite.Request{
+			ID:     requestID,
+			Client: &clientregistry.Client{DefaultOpenIDConnectClient: fosite.DefaultOpenIDConnectClient{DefaultClient: &fosite.DefaultClient{ID: "pinniped-cli"}}},
+			Session: &psession.PinnipedSession{
+				Fosite: &openid.DefaultSession{
+					Claims: &jwt.IDTokenClaims{
+						Subject:  "https://upstream.example.com?sub=" + requestID + "-user",
+						AuthTime: authTime,
+						Extra:    map[string]interface{}{"username": requestID + "-user", "groups": groups},
+					},
+				},
+				Custom: &psession.CustomSessionData{ProviderName: "some-upstream", ProviderType: "oidc"},
+			},
+		}
+	}
+
+	// storeSession stores the same data which the Supervisor stores for a session after its authorization code
+	// was redeemed, plus the authorization code data which is normally deleted during redemption.
+	storeSession := func(t *testing.T, backend crud.Backend, request *fosite.Request) {
+		ctx := context.Background()
+		signature := request.ID + "-signature"
+		require.NoError(t, authorizationcode.New(backend, nowFunc, 10*time.Minute).CreateAuthorizeCodeSession(ctx, signature, request))
+		require.NoError(t, pkce.New(backend, nowFunc, 10*time.Minute).CreatePKCERequestSession(ctx, signature, request))
+		require.NoError(t, openidconnect.New(backend, nowFunc, 10*time.Minute).CreateOpenIDConnectSession(ctx, "code."+signature, request))
+		require.NoError(t, accesstoken.New(backend, nowFunc, 2*time.Minute).CreateAccessTokenSession(ctx, signature, request))
+		require.NoError(t, refreshtoken.New(backend, nowFunc, 9*time.Hour).CreateRefreshTokenSession(ctx, signature, request))
+	}
+
+	// storedData returns the type and the request ID of each of the stored items, e.g. "pkce/session-1".
+	storedData := func(t *testing.T, kubeClient *kubernetesfake.Clientset) []string {
+		stored := []string{}
+		secrets, err := kubeClient.CoreV1().Secrets(namespace).List(context.Background(), metav1.ListOptions{})
+		require.NoError(t, err)
+		for _, secret := range secrets.Items {
+			stored = append(stored, secret.Labels["storage.pinniped.dev/type"]+"/"+secret.Labels["storage.pinniped.dev/request-id"])
+		}
+		sort.Strings(stored)
+		return stored
+	}
+
+	newSession := func(requestID string, groups []string) *configv1alpha1.Session {
+		return &configv1alpha1.Session{
+			ObjectMeta: metav1.ObjectMeta{Name: requestID, Namespace: namespace},
+			Status: configv1alpha1.SessionStatus{
+				Username:                     requestID + "-user",
+				Subject:                      "https://upstream.example.com?sub=" + requestID + "-user",
+				Groups:                       groups,
+				ClientID:                     "pinniped-cli",
+				UpstreamIdentityProviderName: "some-upstream",
+				UpstreamIdentityProviderType: "oidc",
+				AuthenticatedAt:              &metav1.Time{Time: authTime},
+				ExpiresAt:                    &metav1.Time{Time: now.Add(9 * time.Hour)},
+			},
+		}
+	}
+
+	revoked := func(session *configv1alpha1.Session) *configv1alpha1.Session {
+		s := session.DeepCopy()
+		s.Spec.Revoked = true
+		return s
+	}
+
+	tests := []struct {
+		name               string
+		storedRequests     []*fosite.Request
+		redeemedAuthcodes  bool
+		otherSecrets       []*corev1.Secret
+		sessions           []*configv1alpha1.Session
+		syncRequestID      string
+		wantSessionActions []kubetesting.Action
+		wantStoredData     []string
+	}{
+		{
+			name:               "no sessions",
+			syncRequestID:      "session-1",
+			wantSessionActions: []kubetesting.Action{},
+		},
+		{
+			name:           "stored session without a Session",
+			storedRequests: []*fosite.Request{newRequest("session-1", []string{"group-1", "group-2"})},
+			syncRequestID:  "session-1",
+			wantSessionActions: []kubetesting.Action{
+				kubetesting.NewCreateAction(sessionGVR, namespace, newSession("session-1", []string{"group-1", "group-2"})),
+			},
+		},
+		{
+			name:               "stored session whose Session is up to date",
+			storedRequests:     []*fosite.Request{newRequest("session-1", []string{"group-1"})},
+			sessions:           []*configv1alpha1.Session{newSession("session-1", []string{"group-1"})},
+			syncRequestID:      "session-1",
+			wantSessionActions: []kubetesting.Action{},
+		},
+		{
+			name:           "stored session whose Session is out of date",
+			storedRequests: []*fosite.Request{newRequest("session-1", nil)},
+			sessions:       []*configv1alpha1.Session{newSession("session-1", []string{"old-group"})},
+			syncRequestID:  "session-1",
+			wantSessionActions: []kubetesting.Action{
+				kubetesting.NewUpdateAction(sessionGVR, namespace, newSession("session-1", nil)),
+			},
+		},
+		{
+			name:          "Session whose session is no longer stored",
+			sessions:      []*configv1alpha1.Session{newSession("session-1", nil)},
+			syncRequestID: "session-1",
+			wantSessionActions: []kubetesting.Action{
+				kubetesting.NewDeleteAction(sessionGVR, namespace, "session-1"),
+			},
+		},
+		{
+			name: "session storage Secret which cannot be read",
+			otherSecrets: []*corev1.Secret{{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:      "pinniped-storage-refresh-token-bad",
+					Namespace: namespace,
+					Labels: map[string]string{
+						"storage.pinniped.dev/type":       "refresh-token",
+						"storage.pinniped.dev/request-id": "session-1",
+					},
+				},
+				Type: "storage.pinniped.dev/refresh-token",
+				Data: map[string][]byte{"pinniped-storage-data": []byte("{}"), "pinniped-storage-version": []byte("1")},
+			}},
+			syncRequestID:      "session-1",
+			wantSessionActions: []kubetesting.Action{},
+			wantStoredData:     []string{"refresh-token/session-1"},
+		},
+		{
+			name:           "revoked Session",
+			storedRequests: []*fosite.Request{newRequest("session-1", nil), newRequest("session-2", nil)},
+			sessions:       []*configv1alpha1.Session{revoked(newSession("session-1", nil)), newSession("session-2", nil)},
+			syncRequestID:  "session-1",
+			wantSessionActions: []kubetesting.Action{
+				kubetesting.NewDeleteAction(sessionGVR, namespace, "session-1"),
+			},
+			// Only the stored data of session-2 remains.
+			wantStoredData: []string{
+				"access-token/session-2",
+				"authcode/session-2",
+				"oidc/session-2",
+				"pkce/session-2",
+				"refresh-token/session-2",
+			},
+		},
+		{
+			name:              "revoked Session whose session was partially deleted already",
+			storedRequests:    []*fosite.Request{newRequest("session-1", nil)},
+			redeemedAuthcodes: true,
+			sessions:          []*configv1alpha1.Session{revoked(newSession("session-1", nil))},
+			syncRequestID:     "session-1",
+			wantSessionActions: []kubetesting.Action{
+				kubetesting.NewDeleteAction(sessionGVR, namespace, "session-1"),
+			},
+		},
+		{
+			name:          "revoked Session whose session is no longer stored",
+			sessions:      []*configv1alpha1.Session{revoked(newSession("session-1", nil))},
+			syncRequestID: "session-1",
+			wantSessionActions: []kubetesting.Action{
+				kubetesting.NewDeleteAction(sessionGVR, namespace, "session-1"),
+			},
+		},
+		{
+			name:           "only the session of the key is reconciled",
+			storedRequests: []*fosite.Request{newRequest("session-1", nil), newRequest("session-2", nil)},
+			sessions: []*configv1alpha1.Session{
+				revoked(newSession("session-1", nil)),
+				newSession("session-3", nil),
+			},
+			syncRequestID: "session-2",
+			wantSessionActions: []kubetesting.Action{
+				kubetesting.NewCreateAction(sessionGVR, namespace, newSession("session-2", nil)),
+			},
+		},
+	}
+	for _, test := range tests {
+		test := test
+		t.Run(test.name, func(t *testing.T) {
+			t.Parallel()
+
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+
+			kubeClient := kubernetesfake.NewSimpleClientset()
+			backend := crud.NewSecretsBackend(kubeClient.CoreV1().Secrets(namespace))
+			for _, request := range test.storedRequests {
+				storeSession(t, backend, request)
+				if test.redeemedAuthcodes {
+					// The PKCE and OIDC data are deleted when the authorization code is redeemed.
+					require.NoError(t, pkce.New(backend, nowFunc, 0).DeletePKCERequestSession(ctx, request.ID+"-signature"))
+					require.NoError(t, openidconnect.New(backend, nowFunc, 0).DeleteOpenIDConnectSession(ctx, "code."+request.ID+"-signature"))
+				}
+			}
+			for _, secret := range test.otherSecrets {
+				require.NoError(t, kubeClient.Tracker().Add(secret))
+			}
+
+			pinnipedAPIClient := pinnipedfake.NewSimpleClientset()
+			pinnipedInformerClient := pinnipedfake.NewSimpleClientset()
+			for _, session := range test.sessions {
+				require.NoError(t, pinnipedAPIClient.Tracker().Add(session))
+				require.NoError(t, pinnipedInformerClient.Tracker().Add(session))
+			}
+
+			kubeInformers := kubeinformers.NewSharedInformerFactory(kubeClient, 0)
+			pinnipedInformers := pinnipedinformers.NewSharedInformerFactory(pinnipedInformerClient, 0)
+
+			c := SessionController(
+				backend,
+				pinnipedAPIClient,
+				kubeInformers.Core().V1().Secrets(),
+				pinnipedInformers.Config().V1alpha1().Sessions(),
+				controllerlib.WithInformer,
+			)
+
+			// Must start informers before calling TestRunSynchronously().
+			kubeInformers.Start(ctx.Done())
+			pinnipedInformers.Start(ctx.Done())
+			controllerlib.TestRunSynchronously(t, c)
+
+			err := controllerlib.TestSync(t, c, controllerlib.Context{
+				Context: ctx,
+				Key:     controllerlib.Key{Namespace: namespace, Name: test.syncRequestID},
+			})
+			require.NoError(t, err)
+
+			require.Equal(t, test.wantSessionActions, pinnipedAPIClient.Actions())
+
+			if test.wantStoredData != nil {
+				require.Equal(t, test.wantStoredData, storedData(t, kubeClient))
+			}
+		})
+	}
+}
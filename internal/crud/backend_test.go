@@ -0,0 +1,202 @@
+// Copyright 2021 the Pinniped contributors. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package crud
+
+import (
+	"context"
+	"errors"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	bolt "go.etcd.io/bbolt"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/util/clock"
+
+	"go.pinniped.dev/internal/testutil"
+)
+
+// TestGarbageCollectingBackends runs the same scenario against each of the backends which do not use Secrets,
+// to check that they behave like the Secrets backend.
+func TestGarbageCollectingBackends(t *testing.T) {
+	type testJSON struct {
+		Data string
+	}
+
+	tests := []struct {
+		name       string
+		newBackend func(t *testing.T) GarbageCollectingBackend
+	}{
+		{
+			name: "memory",
+			newBackend: func(t *testing.T) GarbageCollectingBackend {
+				return NewMemoryBackend()
+			},
+		},
+		{
+			name: "bolt",
+			newBackend: func(t *testing.T) GarbageCollectingBackend {
+				backend, err := NewBoltBackend(filepath.Join(testutil.TempDir(t), "sessions.db"))
+				require.NoError(t, err)
+				t.Cleanup(func() { require.NoError(t, backend.Close()) })
+				return backend
+			},
+		},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.Background()
+			fakeClock := clock.NewFakeClock(time.Date(2030, time.January, 1, 0, 0, 0, 0, time.UTC))
+			backend := tt.newBackend(t)
+			seals := backend.ListingStorage("seals", fakeClock.Now, 10*time.Minute)
+			walruses := backend.Storage("walruses", fakeClock.Now, time.Hour)
+
+			// Getting, updating and deleting items which do not exist fails with NotFound errors.
+			_, err := seals.Get(ctx, "sig-1", &testJSON{})
+			require.True(t, apierrors.IsNotFound(err), err)
+			require.EqualError(t, err, `failed to get seals for signature sig-1: seals "sig-1" not found`)
+			_, err = seals.Update(ctx, "sig-1", "", &testJSON{}, nil)
+			require.True(t, apierrors.IsNotFound(err), err)
+			require.True(t, apierrors.IsNotFound(seals.Delete(ctx, "sig-1")))
+
+			// Items can be created and read.
+			rv1, err := seals.Create(ctx, "sig-1", &testJSON{Data: "happy-seal"}, map[string]string{"request-id": "abc"})
+			require.NoError(t, err)
+			require.NotEmpty(t, rv1)
+			_, err = seals.Create(ctx, "sig-1", &testJSON{Data: "other-seal"}, nil)
+			require.True(t, apierrors.IsAlreadyExists(err), err)
+			_, err = seals.Create(ctx, "sig-2", &testJSON{Data: "sad-seal"}, map[string]string{"request-id": "abc"})
+			require.NoError(t, err)
+			_, err = seals.Create(ctx, "sig-3", &testJSON{Data: "other-seal"}, map[string]string{"request-id": "def"})
+			require.NoError(t, err)
+			_, err = walruses.Create(ctx, "sig-1", &testJSON{Data: "happy-walrus"}, map[string]string{"request-id": "abc"})
+			require.NoError(t, err)
+
+			out := &testJSON{}
+			rv, err := seals.Get(ctx, "sig-1", out)
+			require.NoError(t, err)
+			require.Equal(t, rv1, rv)
+			require.Equal(t, "happy-seal", out.Data)
+
+			// Updates must use the current resource version.
+			rv2, err := seals.Update(ctx, "sig-1", rv1, &testJSON{Data: "happier-seal"}, map[string]string{"request-id": "abc"})
+			require.NoError(t, err)
+			require.NotEqual(t, rv1, rv2)
+			_, err = seals.Update(ctx, "sig-1", rv1, &testJSON{Data: "stale-seal"}, nil)
+			require.True(t, apierrors.IsConflict(err), err)
+			out = &testJSON{}
+			_, err = seals.Get(ctx, "sig-1", out)
+			require.NoError(t, err)
+			require.Equal(t, "happier-seal", out.Data)
+
+			// Items can be found by their labels.
+			out = &testJSON{}
+			_, err = seals.GetByLabel(ctx, "request-id", "def", out)
+			require.NoError(t, err)
+			require.Equal(t, "other-seal", out.Data)
+			_, err = seals.GetByLabel(ctx, "request-id", "xyz", out)
+			require.True(t, errors.Is(err, ErrNoSecretsFound), err)
+
+			// Items can be listed by their labels, which follow the updates of the items.
+			listed, err := seals.ListByLabel(ctx, "request-id", "abc")
+			require.NoError(t, err)
+			require.Len(t, listed, 2)
+			require.Equal(t, "sig-1", listed[0].Signature)
+			require.JSONEq(t, `{"Data":"happier-seal"}`, string(listed[0].Data))
+			require.Equal(t, fakeClock.Now(), listed[0].CreatedAt)
+			require.Equal(t, fakeClock.Now().Add(10*time.Minute), listed[0].GarbageCollectAfter)
+			require.Equal(t, "sig-2", listed[1].Signature)
+			values, err := seals.LabelValues(ctx, "request-id")
+			require.NoError(t, err)
+			require.Equal(t, []string{"abc", "def"}, values)
+			_, err = seals.Update(ctx, "sig-3", "", &testJSON{Data: "other-seal"}, map[string]string{"request-id": "ghi"})
+			require.NoError(t, err)
+			listed, err = seals.ListByLabel(ctx, "request-id", "def")
+			require.NoError(t, err)
+			require.Empty(t, listed)
+			_, err = seals.GetByLabel(ctx, "request-id", "def", out)
+			require.True(t, errors.Is(err, ErrNoSecretsFound), err)
+			values, err = seals.LabelValues(ctx, "request-id")
+			require.NoError(t, err)
+			require.Equal(t, []string{"abc", "ghi"}, values)
+
+			// Deleting by label only deletes the items of the same resource.
+			require.NoError(t, seals.DeleteByLabel(ctx, "request-id", "abc"))
+			require.True(t, errors.Is(seals.DeleteByLabel(ctx, "request-id", "abc"), ErrNoSecretsFound))
+			_, err = seals.Get(ctx, "sig-2", &testJSON{})
+			require.True(t, apierrors.IsNotFound(err), err)
+			_, err = seals.Get(ctx, "sig-3", &testJSON{})
+			require.NoError(t, err)
+			_, err = walruses.Get(ctx, "sig-1", &testJSON{})
+			require.NoError(t, err)
+
+			require.NoError(t, seals.Delete(ctx, "sig-3"))
+			_, err = seals.Get(ctx, "sig-3", &testJSON{})
+			require.True(t, apierrors.IsNotFound(err), err)
+			values, err = seals.LabelValues(ctx, "request-id")
+			require.NoError(t, err)
+			require.Empty(t, values)
+
+			// Items are garbage collected once the lifetime of their resource has passed since they were last written.
+			_, err = seals.Create(ctx, "sig-4", &testJSON{Data: "new-seal"}, nil)
+			require.NoError(t, err)
+			fakeClock.Step(5 * time.Minute)
+			_, err = seals.Create(ctx, "sig-5", &testJSON{Data: "newer-seal"}, nil)
+			require.NoError(t, err)
+
+			deleted, err := backend.GarbageCollect(ctx, fakeClock.Now().Add(5*time.Minute))
+			require.NoError(t, err)
+			require.Zero(t, deleted)
+
+			deleted, err = backend.GarbageCollect(ctx, fakeClock.Now().Add(5*time.Minute+time.Second))
+			require.NoError(t, err)
+			require.Equal(t, 1, deleted)
+			_, err = seals.Get(ctx, "sig-4", &testJSON{})
+			require.True(t, apierrors.IsNotFound(err), err)
+			_, err = seals.Get(ctx, "sig-5", &testJSON{})
+			require.NoError(t, err)
+
+			deleted, err = backend.GarbageCollect(ctx, fakeClock.Now().Add(2*time.Hour))
+			require.NoError(t, err)
+			require.Equal(t, 2, deleted)
+			_, err = walruses.Get(ctx, "sig-1", &testJSON{})
+			require.True(t, apierrors.IsNotFound(err), err)
+		})
+	}
+}
+
+func TestBoltBackendGarbageCollectsUndecodableItems(t *testing.T) {
+	ctx := context.Background()
+	backend, err := NewBoltBackend(filepath.Join(testutil.TempDir(t), "sessions.db"))
+	require.NoError(t, err)
+	t.Cleanup(func() { require.NoError(t, backend.Close()) })
+
+	seals := backend.ListingStorage("seals", time.Now, time.Hour)
+	_, err = seals.Create(ctx, "sig-1", "happy-seal", map[string]string{"request-id": "abc"})
+	require.NoError(t, err)
+	_, err = seals.Create(ctx, "sig-2", "sad-seal", map[string]string{"request-id": "abc"})
+	require.NoError(t, err)
+	require.NoError(t, backend.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket([]byte(itemsBucketName)).Bucket([]byte("seals")).Put([]byte("sig-1"), []byte("not json"))
+	}))
+
+	_, err = seals.ListByLabel(ctx, "request-id", "abc")
+	require.EqualError(t, err, `failed to list items for resource "seals" matching label "request-id=abc": `+
+		`failed to decode seals for signature sig-1: invalid character 'o' in literal null (expecting 'u')`)
+
+	// The undecodable item does not stop the garbage collection, and it is deleted along with its labels.
+	deleted, err := backend.GarbageCollect(ctx, time.Now())
+	require.NoError(t, err)
+	require.Equal(t, 1, deleted)
+	listed, err := seals.ListByLabel(ctx, "request-id", "abc")
+	require.NoError(t, err)
+	require.Len(t, listed, 1)
+	require.Equal(t, "sig-2", listed[0].Signature)
+	require.NoError(t, backend.db.View(func(tx *bolt.Tx) error {
+		require.Equal(t, 1, tx.Bucket([]byte(labelIndexBucketName)).Bucket([]byte("seals")).Stats().KeyN)
+		return nil
+	}))
+}
@@ -0,0 +1,450 @@
+// Copyright 2021 the Pinniped contributors. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package crud
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+	"k8s.io/apimachinery/pkg/api/errors"
+
+	"go.pinniped.dev/internal/plog"
+)
+
+const (
+	// itemsBucketName is the top level bucket which holds one bucket per resource, which maps the signatures of the
+	// items of the resource to their storedItems.
+	itemsBucketName = "items"
+
+	// labelIndexBucketName is the top level bucket which holds one bucket per resource, whose keys are made of the
+	// labels of the items of the resource followed by their signatures (see labelIndexKey), so that the items can
+	// be found by their labels without reading all of them. The values are empty.
+	labelIndexBucketName = "label-index"
+)
+
+// BoltBackend is a Backend which stores the items in an embedded bbolt database file, with one bucket per resource.
+// Only one process at a time may open the file, so all of the requests of the Supervisor must be served by the
+// same pod when this backend is used.
+type BoltBackend struct {
+	db *bolt.DB
+}
+
+var _ GarbageCollectingBackend = &BoltBackend{}
+
+// NewBoltBackend opens or creates the bbolt database file at the path. Close the returned BoltBackend when it is
+// no longer used.
+func NewBoltBackend(path string) (*BoltBackend, error) {
+	db, err := bolt.Open(path, 0600, &bolt.Options{Timeout: 10 * time.Second})
+	if err != nil {
+		return nil, fmt.Errorf("failed to open bolt database %s: %w", path, err)
+	}
+	return &BoltBackend{db: db}, nil
+}
+
+// Close closes the database file.
+func (b *BoltBackend) Close() error {
+	return b.db.Close()
+}
+
+func (b *BoltBackend) Storage(resource string, clock func() time.Time, lifetime time.Duration) Storage {
+	return b.ListingStorage(resource, clock, lifetime)
+}
+
+// ListingStorage implements GarbageCollectingBackend.
+func (b *BoltBackend) ListingStorage(resource string, clock func() time.Time, lifetime time.Duration) ListingStorage {
+	return &boltStorage{db: b.db, resource: resource, clock: clock, lifetime: lifetime}
+}
+
+// GarbageCollect implements GarbageCollectingBackend. Items which cannot be decoded are deleted too, since they
+// could never be read nor garbage collected otherwise.
+func (b *BoltBackend) GarbageCollect(_ context.Context, now time.Time) (int, error) {
+	deleted := 0
+	err := b.db.Update(func(tx *bolt.Tx) error {
+		// Buckets must not be changed while iterating over their parent bucket, so first find all of the resources.
+		var resources []string
+		if items := tx.Bucket([]byte(itemsBucketName)); items != nil {
+			if err := items.ForEach(func(resource, _ []byte) error {
+				resources = append(resources, string(resource))
+				return nil
+			}); err != nil {
+				return err
+			}
+		}
+		for _, resource := range resources {
+			n, err := (&boltStorage{resource: resource}).garbageCollect(tx, now)
+			deleted += n
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+	return deleted, nil
+}
+
+type boltStorage struct {
+	db       *bolt.DB
+	resource string
+	clock    func() time.Time
+	lifetime time.Duration
+}
+
+// boltBuckets are the buckets of the items of one resource and of their label index.
+type boltBuckets struct {
+	items      *bolt.Bucket
+	labelIndex *bolt.Bucket
+}
+
+func (s *boltStorage) Create(_ context.Context, signature string, data JSON, additionalLabels map[string]string) (string, error) {
+	var resourceVersion string
+	err := s.db.Update(func(tx *bolt.Tx) error {
+		buckets, err := s.createBuckets(tx)
+		if err != nil {
+			return err
+		}
+		if buckets.items.Get([]byte(signature)) != nil {
+			return errors.NewAlreadyExists(groupResource(s.resource), signature)
+		}
+		resourceVersion, err = s.put(buckets, signature, data, additionalLabels, s.clock(), nil)
+		return err
+	})
+	if err != nil {
+		return "", fmt.Errorf("failed to create %s for signature %s: %w", s.resource, signature, err)
+	}
+	return resourceVersion, nil
+}
+
+func (s *boltStorage) Get(_ context.Context, signature string, data JSON) (string, error) {
+	var item *storedItem
+	err := s.db.View(func(tx *bolt.Tx) error {
+		var err error
+		item, err = s.get(s.buckets(tx), signature)
+		return err
+	})
+	if err != nil {
+		return "", fmt.Errorf("failed to get %s for signature %s: %w", s.resource, signature, err)
+	}
+	if err := json.Unmarshal(item.Data, data); err != nil {
+		return "", fmt.Errorf("failed to decode %s for signature %s: %w", s.resource, signature, err)
+	}
+	return item.ResourceVersion, nil
+}
+
+func (s *boltStorage) Update(_ context.Context, signature, resourceVersion string, data JSON, additionalLabels map[string]string) (string, error) {
+	var newResourceVersion string
+	err := s.db.Update(func(tx *bolt.Tx) error {
+		buckets := s.buckets(tx)
+		existing, err := s.get(buckets, signature)
+		if err != nil {
+			return err
+		}
+		if resourceVersion != "" && resourceVersion != existing.ResourceVersion {
+			return errors.NewConflict(groupResource(s.resource), signature, fmt.Errorf("the object has been modified"))
+		}
+		newResourceVersion, err = s.put(buckets, signature, data, additionalLabels, existing.CreatedAt, existing)
+		return err
+	})
+	if err != nil {
+		return "", fmt.Errorf("failed to update %s for signature %s at resource version %s: %w", s.resource, signature, resourceVersion, err)
+	}
+	return newResourceVersion, nil
+}
+
+func (s *boltStorage) Delete(_ context.Context, signature string) error {
+	err := s.db.Update(func(tx *bolt.Tx) error {
+		buckets := s.buckets(tx)
+		item, err := s.get(buckets, signature)
+		if err != nil {
+			return err
+		}
+		return s.delete(buckets, signature, item)
+	})
+	if err != nil {
+		return fmt.Errorf("failed to delete %s for signature %s: %w", s.resource, signature, err)
+	}
+	return nil
+}
+
+func (s *boltStorage) DeleteByLabel(_ context.Context, labelName string, labelValue string) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		buckets := s.buckets(tx)
+		signatures, items, err := s.findByLabel(buckets, labelName, labelValue)
+		if err != nil {
+			return fmt.Errorf(`failed to list items for resource "%s" matching label "%s=%s": %w`, s.resource, labelName, labelValue, err)
+		}
+		if len(signatures) == 0 {
+			return fmt.Errorf(`failed to delete items for resource "%s" matching label "%s=%s": %w`, s.resource, labelName, labelValue, ErrNoSecretsFound)
+		}
+		for i, signature := range signatures {
+			if err := s.delete(buckets, signature, items[i]); err != nil {
+				return fmt.Errorf(`failed to delete items for resource "%s" matching label "%s=%s" with signature %s: %w`, s.resource, labelName, labelValue, signature, err)
+			}
+		}
+		return nil
+	})
+}
+
+func (s *boltStorage) GetByLabel(_ context.Context, labelName string, labelValue string, data JSON) (string, error) {
+	var items []*storedItem
+	err := s.db.View(func(tx *bolt.Tx) error {
+		var err error
+		_, items, err = s.findByLabel(s.buckets(tx), labelName, labelValue)
+		return err
+	})
+	if err != nil {
+		return "", fmt.Errorf(`failed to list items for resource "%s" matching label "%s=%s": %w`, s.resource, labelName, labelValue, err)
+	}
+	if len(items) == 0 {
+		return "", fmt.Errorf(`failed to get items for resource "%s" matching label "%s=%s": %w`, s.resource, labelName, labelValue, ErrNoSecretsFound)
+	}
+	if err := json.Unmarshal(items[0].Data, data); err != nil {
+		return "", fmt.Errorf(`failed to decode %s matching label "%s=%s": %w`, s.resource, labelName, labelValue, err)
+	}
+	return items[0].ResourceVersion, nil
+}
+
+// ListByLabel implements ListingStorage.
+func (s *boltStorage) ListByLabel(_ context.Context, labelName string, labelValue string) ([]*ListedItem, error) {
+	var listed []*ListedItem
+	err := s.db.View(func(tx *bolt.Tx) error {
+		signatures, items, err := s.findByLabel(s.buckets(tx), labelName, labelValue)
+		for i, signature := range signatures {
+			listed = append(listed, items[i].listed(signature))
+		}
+		return err
+	})
+	if err != nil {
+		return nil, fmt.Errorf(`failed to list items for resource "%s" matching label "%s=%s": %w`, s.resource, labelName, labelValue, err)
+	}
+	return listed, nil
+}
+
+// LabelValues implements ListingStorage.
+func (s *boltStorage) LabelValues(_ context.Context, labelName string) ([]string, error) {
+	var values []string
+	err := s.db.View(func(tx *bolt.Tx) error {
+		buckets := s.buckets(tx)
+		if buckets.labelIndex == nil {
+			return nil
+		}
+		// The keys are sorted, so the keys with the same label value are next to each other.
+		prefix := []byte(labelName + "=")
+		cursor := buckets.labelIndex.Cursor()
+		for key, _ := cursor.Seek(prefix); key != nil && bytes.HasPrefix(key, prefix); key, _ = cursor.Next() {
+			value := string(key[len(prefix):bytes.IndexByte(key, 0)])
+			if len(values) == 0 || values[len(values)-1] != value {
+				values = append(values, value)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf(`failed to list values of label "%s" for resource "%s": %w`, labelName, s.resource, err)
+	}
+	return values, nil
+}
+
+// buckets returns the buckets of the resource, which are nil when nothing was stored for the resource yet.
+func (s *boltStorage) buckets(tx *bolt.Tx) *boltBuckets {
+	buckets := &boltBuckets{}
+	if items := tx.Bucket([]byte(itemsBucketName)); items != nil {
+		buckets.items = items.Bucket([]byte(s.resource))
+	}
+	if labelIndex := tx.Bucket([]byte(labelIndexBucketName)); labelIndex != nil {
+		buckets.labelIndex = labelIndex.Bucket([]byte(s.resource))
+	}
+	return buckets
+}
+
+func (s *boltStorage) createBuckets(tx *bolt.Tx) (*boltBuckets, error) {
+	buckets := &boltBuckets{}
+	for name, bucket := range map[string]**bolt.Bucket{itemsBucketName: &buckets.items, labelIndexBucketName: &buckets.labelIndex} {
+		parent, err := tx.CreateBucketIfNotExists([]byte(name))
+		if err != nil {
+			return nil, err
+		}
+		if *bucket, err = parent.CreateBucketIfNotExists([]byte(s.resource)); err != nil {
+			return nil, err
+		}
+	}
+	return buckets, nil
+}
+
+func (s *boltStorage) get(buckets *boltBuckets, signature string) (*storedItem, error) {
+	var value []byte
+	if buckets.items != nil {
+		value = buckets.items.Get([]byte(signature))
+	}
+	if value == nil {
+		return nil, errors.NewNotFound(groupResource(s.resource), signature)
+	}
+	return decodeStoredItem(value)
+}
+
+// put stores the item and indexes its labels. The existing item is the one which is replaced, if any.
+func (s *boltStorage) put(buckets *boltBuckets, signature string, data JSON, additionalLabels map[string]string, createdAt time.Time, existing *storedItem) (string, error) {
+	sequence, err := buckets.items.NextSequence()
+	if err != nil {
+		return "", err
+	}
+	item, err := newStoredItem(data, additionalLabels, strconv.FormatUint(sequence, 10), createdAt, s.clock().Add(s.lifetime))
+	if err != nil {
+		return "", err
+	}
+	value, err := json.Marshal(item)
+	if err != nil {
+		return "", err
+	}
+	if existing != nil {
+		if err := deleteFromLabelIndex(buckets.labelIndex, signature, existing.Labels); err != nil {
+			return "", err
+		}
+	}
+	if err := buckets.items.Put([]byte(signature), value); err != nil {
+		return "", err
+	}
+	for labelName, labelValue := range additionalLabels {
+		if err := buckets.labelIndex.Put(labelIndexKey(labelName, labelValue, signature), []byte{}); err != nil {
+			return "", err
+		}
+	}
+	return item.ResourceVersion, nil
+}
+
+// delete deletes the item and removes its labels from the index.
+func (s *boltStorage) delete(buckets *boltBuckets, signature string, item *storedItem) error {
+	if err := deleteFromLabelIndex(buckets.labelIndex, signature, item.Labels); err != nil {
+		return err
+	}
+	return buckets.items.Delete([]byte(signature))
+}
+
+// findByLabel returns the signatures and the items which have the label, in the order of their signatures.
+func (s *boltStorage) findByLabel(buckets *boltBuckets, labelName string, labelValue string) ([]string, []*storedItem, error) {
+	if buckets.items == nil || buckets.labelIndex == nil {
+		return nil, nil, nil
+	}
+	var signatures []string
+	var items []*storedItem
+	prefix := labelIndexKey(labelName, labelValue, "")
+	cursor := buckets.labelIndex.Cursor()
+	for key, _ := cursor.Seek(prefix); key != nil && bytes.HasPrefix(key, prefix); key, _ = cursor.Next() {
+		signature := string(key[len(prefix):])
+		item, err := s.get(buckets, signature)
+		if errors.IsNotFound(err) {
+			// The index entry of an undecodable item which was garbage collected.
+			continue
+		}
+		if err != nil {
+			return nil, nil, fmt.Errorf("failed to decode %s for signature %s: %w", s.resource, signature, err)
+		}
+		signatures = append(signatures, signature)
+		items = append(items, item)
+	}
+	return signatures, items, nil
+}
+
+// garbageCollect deletes the items of the resource which should be garbage collected before the given time, and
+// the items which cannot be decoded.
+func (s *boltStorage) garbageCollect(tx *bolt.Tx, now time.Time) (int, error) {
+	buckets := s.buckets(tx)
+	if buckets.items == nil {
+		return 0, nil
+	}
+
+	// Keys must not be deleted while iterating over the bucket, so first find all of the items to delete.
+	expired := map[string]*storedItem{}
+	var undecodable []string
+	if err := buckets.items.ForEach(func(key, value []byte) error {
+		item, err := decodeStoredItem(value)
+		if err != nil {
+			plog.WarningErr("deleting storage item which cannot be decoded", err, "resource", s.resource, "signature", string(key))
+			undecodable = append(undecodable, string(key))
+			return nil
+		}
+		if item.GarbageCollectAfter.Before(now) {
+			expired[string(key)] = item
+		}
+		return nil
+	}); err != nil {
+		return 0, err
+	}
+
+	deleted := 0
+	for signature, item := range expired {
+		if err := s.delete(buckets, signature, item); err != nil {
+			return deleted, fmt.Errorf("failed to delete %s for signature %s: %w", s.resource, signature, err)
+		}
+		deleted++
+	}
+	for _, signature := range undecodable {
+		if err := buckets.items.Delete([]byte(signature)); err != nil {
+			return deleted, fmt.Errorf("failed to delete %s for signature %s: %w", s.resource, signature, err)
+		}
+		deleted++
+	}
+	if len(undecodable) > 0 {
+		// The labels of the undecodable items are unknown, so look for their index entries instead.
+		if err := s.deleteDanglingLabelIndexEntries(buckets); err != nil {
+			return deleted, fmt.Errorf("failed to clean up label index of %s: %w", s.resource, err)
+		}
+	}
+	return deleted, nil
+}
+
+// deleteDanglingLabelIndexEntries deletes the index entries of the items which no longer exist.
+func (s *boltStorage) deleteDanglingLabelIndexEntries(buckets *boltBuckets) error {
+	if buckets.labelIndex == nil {
+		return nil
+	}
+	var dangling [][]byte
+	if err := buckets.labelIndex.ForEach(func(key, _ []byte) error {
+		signature := key[bytes.IndexByte(key, 0)+1:]
+		if buckets.items.Get(signature) == nil {
+			// The key is only valid during the transaction, so keep a copy of it.
+			dangling = append(dangling, append([]byte(nil), key...))
+		}
+		return nil
+	}); err != nil {
+		return err
+	}
+	for _, key := range dangling {
+		if err := buckets.labelIndex.Delete(key); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// labelIndexKey returns the key of the label index entry of the item with the signature, which is
+// "labelName=labelValue\x00signature". Label names and values cannot contain "=" nor NUL characters, so all of the
+// entries of a label value share the prefix which is returned for an empty signature.
+func labelIndexKey(labelName, labelValue, signature string) []byte {
+	return []byte(labelName + "=" + labelValue + "\x00" + signature)
+}
+
+func deleteFromLabelIndex(labelIndex *bolt.Bucket, signature string, labels map[string]string) error {
+	for labelName, labelValue := range labels {
+		if err := labelIndex.Delete(labelIndexKey(labelName, labelValue, signature)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func decodeStoredItem(value []byte) (*storedItem, error) {
+	item := &storedItem{}
+	if err := json.Unmarshal(value, item); err != nil {
+		return nil, err
+	}
+	return item, nil
+}
@@ -37,18 +37,83 @@ const (
 	ErrSecretTypeMismatch    = constable.Error("secret storage data has incorrect type")
 	ErrSecretLabelMismatch   = constable.Error("secret storage data has incorrect label")
 	ErrSecretVersionMismatch = constable.Error("secret storage data has incorrect version")
+	ErrNoSecretsFound        = constable.Error("none found")
 )
 
+// Storage stores the items of one resource, e.g. the authorization codes, each under the signature of the item.
+// The items of a resource may also be found or deleted by the additional labels with which they were stored.
 type Storage interface {
 	Create(ctx context.Context, signature string, data JSON, additionalLabels map[string]string) (resourceVersion string, err error)
 	Get(ctx context.Context, signature string, data JSON) (resourceVersion string, err error)
-	Update(ctx context.Context, signature, resourceVersion string, data JSON) (newResourceVersion string, err error)
+	Update(ctx context.Context, signature, resourceVersion string, data JSON, additionalLabels map[string]string) (newResourceVersion string, err error)
 	Delete(ctx context.Context, signature string) error
 	DeleteByLabel(ctx context.Context, labelName string, labelValue string) error
+	GetByLabel(ctx context.Context, labelName string, labelValue string, data JSON) (resourceVersion string, err error)
 }
 
 type JSON interface{} // document that we need valid JSON types
 
+// ListingStorage is a Storage which can also list its items by their labels.
+type ListingStorage interface {
+	Storage
+
+	// LabelValues returns the sorted distinct values of the label over all of the items.
+	LabelValues(ctx context.Context, labelName string) ([]string, error)
+
+	// ListByLabel returns the items which have the label, sorted by their signatures.
+	ListByLabel(ctx context.Context, labelName string, labelValue string) ([]*ListedItem, error)
+}
+
+// ListedItem is an item which was listed by a ListingStorage.
+type ListedItem struct {
+	Signature string
+
+	// Data is the JSON of the data of the item.
+	Data []byte
+
+	CreatedAt           time.Time
+	GarbageCollectAfter time.Time
+}
+
+// Backend stores the items of all of the resources. Each item is garbage collected once the lifetime of its
+// resource has passed since the item was created or last updated.
+//
+// All backends return the same kinds of Kubernetes API errors as the Secrets backend, e.g. a NotFound error from
+// Get when there is no item with the signature, so that callers may treat all backends alike.
+type Backend interface {
+	// Storage returns the Storage of the items of the resource.
+	Storage(resource string, clock func() time.Time, lifetime time.Duration) Storage
+}
+
+// GarbageCollectingBackend is a Backend which does not garbage collect its items by itself, so it must be asked
+// to delete the items whose lifetime has passed. Its items cannot be watched through informers like the Secrets,
+// so its storages can also list their items instead.
+type GarbageCollectingBackend interface {
+	Backend
+
+	// ListingStorage returns the same Storage as Storage, as a ListingStorage.
+	ListingStorage(resource string, clock func() time.Time, lifetime time.Duration) ListingStorage
+
+	// GarbageCollect deletes all of the items of all of the resources which should be garbage collected
+	// before the given time, and returns how many were deleted.
+	GarbageCollect(ctx context.Context, now time.Time) (int, error)
+}
+
+// NewSecretsBackend returns a Backend which stores each item in its own Secret. The Secrets are garbage
+// collected by the garbage collector controller, which watches all Secrets which have the
+// SecretLifetimeAnnotationKey annotation.
+func NewSecretsBackend(secrets corev1client.SecretInterface) Backend {
+	return &secretsBackend{secrets: secrets}
+}
+
+type secretsBackend struct {
+	secrets corev1client.SecretInterface
+}
+
+func (b *secretsBackend) Storage(resource string, clock func() time.Time, lifetime time.Duration) Storage {
+	return New(resource, b.secrets, clock, lifetime)
+}
+
 func New(resource string, secrets corev1client.SecretInterface, clock func() time.Time, lifetime time.Duration) Storage {
 	return &secretsStorage{
 		resource:      resource,
@@ -96,20 +161,36 @@ func (s *secretsStorage) Get(ctx context.Context, signature string, data JSON) (
 }
 
 func (s *secretsStorage) validateSecret(secret *corev1.Secret) error {
-	if secret.Type != s.secretType {
-		return fmt.Errorf("%w: %s must equal %s", ErrSecretTypeMismatch, secret.Type, s.secretType)
+	return validateSecret(s.resource, s.secretType, s.secretVersion, secret)
+}
+
+func validateSecret(resource string, secretType corev1.SecretType, version []byte, secret *corev1.Secret) error {
+	if secret.Type != secretType {
+		return fmt.Errorf("%w: %s must equal %s", ErrSecretTypeMismatch, secret.Type, secretType)
 	}
-	if labelResource := secret.Labels[SecretLabelKey]; labelResource != s.resource {
-		return fmt.Errorf("%w: %s must equal %s", ErrSecretLabelMismatch, labelResource, s.resource)
+	if labelResource := secret.Labels[SecretLabelKey]; labelResource != resource {
+		return fmt.Errorf("%w: %s must equal %s", ErrSecretLabelMismatch, labelResource, resource)
 	}
-	if !bytes.Equal(secret.Data[secretVersionKey], s.secretVersion) {
+	if !bytes.Equal(secret.Data[secretVersionKey], version) {
 		return ErrSecretVersionMismatch // TODO should this be fatal or not?
 	}
 	return nil
 }
 
-func (s *secretsStorage) Update(ctx context.Context, signature, resourceVersion string, data JSON) (string, error) {
-	secret, err := s.toSecret(signature, resourceVersion, data, nil)
+// FromSecret is like Storage.Get, but decodes a Secret which was already read, e.g. from an informer's cache.
+// It validates that the Secret holds data of the given resource before decoding the data into the data parameter.
+func FromSecret(resource string, secret *corev1.Secret, data JSON) error {
+	if err := validateSecret(resource, corev1.SecretType(fmt.Sprintf(secretTypeFormat, resource)), []byte(secretVersion), secret); err != nil {
+		return err
+	}
+	if err := json.Unmarshal(secret.Data[secretDataKey], data); err != nil {
+		return fmt.Errorf("failed to decode %s from secret %s: %w", resource, secret.Name, err)
+	}
+	return nil
+}
+
+func (s *secretsStorage) Update(ctx context.Context, signature, resourceVersion string, data JSON, additionalLabels map[string]string) (string, error) {
+	secret, err := s.toSecret(signature, resourceVersion, data, additionalLabels)
 	if err != nil {
 		return "", err
 	}
@@ -138,7 +219,7 @@ func (s *secretsStorage) DeleteByLabel(ctx context.Context, labelName string, la
 		return fmt.Errorf(`failed to list secrets for resource "%s" matching label "%s=%s": %w`, s.resource, labelName, labelValue, err)
 	}
 	if len(list.Items) == 0 {
-		return fmt.Errorf(`failed to delete secrets for resource "%s" matching label "%s=%s": none found`, s.resource, labelName, labelValue)
+		return fmt.Errorf(`failed to delete secrets for resource "%s" matching label "%s=%s": %w`, s.resource, labelName, labelValue, ErrNoSecretsFound)
 	}
 	// TODO try to delete all of the items and consolidate all of the errors and return them all
 	for _, secret := range list.Items {
@@ -150,6 +231,31 @@ func (s *secretsStorage) DeleteByLabel(ctx context.Context, labelName string, la
 	return nil
 }
 
+// GetByLabel is like Get, but finds the stored data by one of its additional labels instead of by its signature.
+// When more than one item has the label, the data of any one of them is returned.
+func (s *secretsStorage) GetByLabel(ctx context.Context, labelName string, labelValue string, data JSON) (string, error) {
+	list, err := s.secrets.List(ctx, metav1.ListOptions{
+		LabelSelector: labels.Set{
+			SecretLabelKey: s.resource,
+			labelName:      labelValue,
+		}.String(),
+	})
+	if err != nil {
+		return "", fmt.Errorf(`failed to list secrets for resource "%s" matching label "%s=%s": %w`, s.resource, labelName, labelValue, err)
+	}
+	if len(list.Items) == 0 {
+		return "", fmt.Errorf(`failed to get secrets for resource "%s" matching label "%s=%s": %w`, s.resource, labelName, labelValue, ErrNoSecretsFound)
+	}
+	secret := &list.Items[0]
+	if err := s.validateSecret(secret); err != nil {
+		return "", err
+	}
+	if err := json.Unmarshal(secret.Data[secretDataKey], data); err != nil {
+		return "", fmt.Errorf("failed to decode %s from secret %s: %w", s.resource, secret.Name, err)
+	}
+	return secret.ResourceVersion, nil
+}
+
 //nolint: gochecknoglobals
 var b32 = base32.StdEncoding.WithPadding(base32.NoPadding)
 
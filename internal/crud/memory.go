@@ -0,0 +1,248 @@
+// Copyright 2021 the Pinniped contributors. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package crud
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+)
+
+// storedItem is an item of a resource as it is stored by the backends which do not use Secrets.
+type storedItem struct {
+	Data                json.RawMessage   `json:"data"`
+	Labels              map[string]string `json:"labels,omitempty"`
+	ResourceVersion     string            `json:"resourceVersion"`
+	CreatedAt           time.Time         `json:"createdAt"`
+	GarbageCollectAfter time.Time         `json:"garbageCollectAfter"`
+}
+
+func newStoredItem(data JSON, additionalLabels map[string]string, resourceVersion string, createdAt, garbageCollectAfter time.Time) (*storedItem, error) {
+	buf, err := json.Marshal(data)
+	if err != nil {
+		return nil, err
+	}
+	return &storedItem{
+		Data:                buf,
+		Labels:              additionalLabels,
+		ResourceVersion:     resourceVersion,
+		CreatedAt:           createdAt.UTC(),
+		GarbageCollectAfter: garbageCollectAfter.UTC(),
+	}, nil
+}
+
+func (i *storedItem) hasLabel(labelName string, labelValue string) bool {
+	value, ok := i.Labels[labelName]
+	return ok && value == labelValue
+}
+
+func (i *storedItem) listed(signature string) *ListedItem {
+	return &ListedItem{
+		Signature:           signature,
+		Data:                i.Data,
+		CreatedAt:           i.CreatedAt,
+		GarbageCollectAfter: i.GarbageCollectAfter,
+	}
+}
+
+func groupResource(resource string) schema.GroupResource {
+	return schema.GroupResource{Resource: resource}
+}
+
+// MemoryBackend is a Backend which stores the items in memory, so they are lost when the process exits and they
+// cannot be shared by several processes. It is meant for tests.
+type MemoryBackend struct {
+	mu              sync.Mutex
+	items           map[string]map[string]*storedItem // resource to signature to item
+	resourceVersion uint64
+}
+
+var _ GarbageCollectingBackend = &MemoryBackend{}
+
+// NewMemoryBackend returns an empty MemoryBackend.
+func NewMemoryBackend() *MemoryBackend {
+	return &MemoryBackend{items: map[string]map[string]*storedItem{}}
+}
+
+func (b *MemoryBackend) Storage(resource string, clock func() time.Time, lifetime time.Duration) Storage {
+	return b.ListingStorage(resource, clock, lifetime)
+}
+
+// ListingStorage implements GarbageCollectingBackend.
+func (b *MemoryBackend) ListingStorage(resource string, clock func() time.Time, lifetime time.Duration) ListingStorage {
+	return &memoryStorage{backend: b, resource: resource, clock: clock, lifetime: lifetime}
+}
+
+// GarbageCollect implements GarbageCollectingBackend.
+func (b *MemoryBackend) GarbageCollect(_ context.Context, now time.Time) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	deleted := 0
+	for _, items := range b.items {
+		for signature, item := range items {
+			if item.GarbageCollectAfter.Before(now) {
+				delete(items, signature)
+				deleted++
+			}
+		}
+	}
+	return deleted, nil
+}
+
+func (b *MemoryBackend) nextResourceVersion() string {
+	b.resourceVersion++
+	return strconv.FormatUint(b.resourceVersion, 10)
+}
+
+type memoryStorage struct {
+	backend  *MemoryBackend
+	resource string
+	clock    func() time.Time
+	lifetime time.Duration
+}
+
+func (s *memoryStorage) Create(_ context.Context, signature string, data JSON, additionalLabels map[string]string) (string, error) {
+	s.backend.mu.Lock()
+	defer s.backend.mu.Unlock()
+
+	items := s.backend.items[s.resource]
+	if _, ok := items[signature]; ok {
+		return "", fmt.Errorf("failed to create %s for signature %s: %w", s.resource, signature, errors.NewAlreadyExists(groupResource(s.resource), signature))
+	}
+	now := s.clock()
+	item, err := newStoredItem(data, additionalLabels, s.backend.nextResourceVersion(), now, now.Add(s.lifetime))
+	if err != nil {
+		return "", fmt.Errorf("failed to encode %s for signature %s: %w", s.resource, signature, err)
+	}
+	if items == nil {
+		items = map[string]*storedItem{}
+		s.backend.items[s.resource] = items
+	}
+	items[signature] = item
+	return item.ResourceVersion, nil
+}
+
+func (s *memoryStorage) Get(_ context.Context, signature string, data JSON) (string, error) {
+	s.backend.mu.Lock()
+	defer s.backend.mu.Unlock()
+
+	item, ok := s.backend.items[s.resource][signature]
+	if !ok {
+		return "", fmt.Errorf("failed to get %s for signature %s: %w", s.resource, signature, errors.NewNotFound(groupResource(s.resource), signature))
+	}
+	if err := json.Unmarshal(item.Data, data); err != nil {
+		return "", fmt.Errorf("failed to decode %s for signature %s: %w", s.resource, signature, err)
+	}
+	return item.ResourceVersion, nil
+}
+
+func (s *memoryStorage) Update(_ context.Context, signature, resourceVersion string, data JSON, additionalLabels map[string]string) (string, error) {
+	s.backend.mu.Lock()
+	defer s.backend.mu.Unlock()
+
+	existing, ok := s.backend.items[s.resource][signature]
+	if !ok {
+		return "", fmt.Errorf("failed to update %s for signature %s at resource version %s: %w",
+			s.resource, signature, resourceVersion, errors.NewNotFound(groupResource(s.resource), signature))
+	}
+	if resourceVersion != "" && resourceVersion != existing.ResourceVersion {
+		return "", fmt.Errorf("failed to update %s for signature %s at resource version %s: %w",
+			s.resource, signature, resourceVersion, errors.NewConflict(groupResource(s.resource), signature, fmt.Errorf("the object has been modified")))
+	}
+	item, err := newStoredItem(data, additionalLabels, s.backend.nextResourceVersion(), existing.CreatedAt, s.clock().Add(s.lifetime))
+	if err != nil {
+		return "", fmt.Errorf("failed to encode %s for signature %s: %w", s.resource, signature, err)
+	}
+	s.backend.items[s.resource][signature] = item
+	return item.ResourceVersion, nil
+}
+
+func (s *memoryStorage) Delete(_ context.Context, signature string) error {
+	s.backend.mu.Lock()
+	defer s.backend.mu.Unlock()
+
+	if _, ok := s.backend.items[s.resource][signature]; !ok {
+		return fmt.Errorf("failed to delete %s for signature %s: %w", s.resource, signature, errors.NewNotFound(groupResource(s.resource), signature))
+	}
+	delete(s.backend.items[s.resource], signature)
+	return nil
+}
+
+func (s *memoryStorage) DeleteByLabel(_ context.Context, labelName string, labelValue string) error {
+	s.backend.mu.Lock()
+	defer s.backend.mu.Unlock()
+
+	signatures := s.signaturesWithLabel(labelName, labelValue)
+	if len(signatures) == 0 {
+		return fmt.Errorf(`failed to delete items for resource "%s" matching label "%s=%s": %w`, s.resource, labelName, labelValue, ErrNoSecretsFound)
+	}
+	for _, signature := range signatures {
+		delete(s.backend.items[s.resource], signature)
+	}
+	return nil
+}
+
+func (s *memoryStorage) GetByLabel(_ context.Context, labelName string, labelValue string, data JSON) (string, error) {
+	s.backend.mu.Lock()
+	defer s.backend.mu.Unlock()
+
+	signatures := s.signaturesWithLabel(labelName, labelValue)
+	if len(signatures) == 0 {
+		return "", fmt.Errorf(`failed to get items for resource "%s" matching label "%s=%s": %w`, s.resource, labelName, labelValue, ErrNoSecretsFound)
+	}
+	item := s.backend.items[s.resource][signatures[0]]
+	if err := json.Unmarshal(item.Data, data); err != nil {
+		return "", fmt.Errorf("failed to decode %s for signature %s: %w", s.resource, signatures[0], err)
+	}
+	return item.ResourceVersion, nil
+}
+
+// ListByLabel implements ListingStorage.
+func (s *memoryStorage) ListByLabel(_ context.Context, labelName string, labelValue string) ([]*ListedItem, error) {
+	s.backend.mu.Lock()
+	defer s.backend.mu.Unlock()
+
+	var listed []*ListedItem
+	for _, signature := range s.signaturesWithLabel(labelName, labelValue) {
+		listed = append(listed, s.backend.items[s.resource][signature].listed(signature))
+	}
+	return listed, nil
+}
+
+// LabelValues implements ListingStorage.
+func (s *memoryStorage) LabelValues(_ context.Context, labelName string) ([]string, error) {
+	s.backend.mu.Lock()
+	defer s.backend.mu.Unlock()
+
+	found := map[string]bool{}
+	var values []string
+	for _, item := range s.backend.items[s.resource] {
+		if value, ok := item.Labels[labelName]; ok && !found[value] {
+			found[value] = true
+			values = append(values, value)
+		}
+	}
+	sort.Strings(values)
+	return values, nil
+}
+
+// signaturesWithLabel returns the sorted signatures of the items of the resource which have the label.
+func (s *memoryStorage) signaturesWithLabel(labelName string, labelValue string) []string {
+	var signatures []string
+	for signature, item := range s.backend.items[s.resource] {
+		if item.hasLabel(labelName, labelValue) {
+			signatures = append(signatures, signature)
+		}
+	}
+	sort.Strings(signatures)
+	return signatures
+}
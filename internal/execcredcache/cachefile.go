@@ -43,6 +43,7 @@ type (
 	// entry is a single credential in the cache file.
 	entry struct {
 		Key               string                                            `json:"key"`
+		Issuer            string                                            `json:"issuer,omitempty"`
 		CreationTimestamp metav1.Time                                       `json:"creationTimestamp"`
 		LastUsedTimestamp metav1.Time                                       `json:"lastUsedTimestamp"`
 		Credential        *clientauthenticationv1beta1.ExecCredentialStatus `json:"credential"`
@@ -204,6 +204,7 @@ func TestPutToken(t *testing.T) {
 		name         string
 		makeTestFile func(t *testing.T, tmp string)
 		key          testKey
+		issuer       string
 		cred         *clientauthenticationv1beta1.ExecCredential
 		wantErrors   []string
 		wantTestFile func(t *testing.T, tmp string)
@@ -306,6 +307,28 @@ func TestPutToken(t *testing.T) {
 					ExpirationTimestamp: timePtr(now.Add(1 * time.Hour).Local()),
 					Token:               "token-one",
 				}, cache.Entries[1].Credential)
+				require.Empty(t, cache.Entries[1].Issuer)
+			},
+		},
+		{
+			name:   "new entry for issuer",
+			key:    testKey{K1: "v1", K2: "v2"},
+			issuer: "test-issuer",
+			cred: &clientauthenticationv1beta1.ExecCredential{
+				TypeMeta: metav1.TypeMeta{
+					Kind:       "ExecCredential",
+					APIVersion: "client.authentication.k8s.io/v1beta1",
+				},
+				Status: &clientauthenticationv1beta1.ExecCredentialStatus{
+					ExpirationTimestamp: timePtr(now.Add(1 * time.Hour)),
+					Token:               "token-one",
+				},
+			},
+			wantTestFile: func(t *testing.T, tmp string) {
+				cache, err := readCache(tmp)
+				require.NoError(t, err)
+				require.Len(t, cache.Entries, 1)
+				require.Equal(t, "test-issuer", cache.Entries[0].Issuer)
 			},
 		},
 		{
@@ -342,7 +365,11 @@ func TestPutToken(t *testing.T) {
 			errors := errorCollector{t: t}
 			c := New(tmp)
 			c.errReporter = errors.report
-			c.Put(tt.key, tt.cred)
+			if tt.issuer != "" {
+				c.PutForIssuer(tt.key, tt.issuer, tt.cred)
+			} else {
+				c.Put(tt.key, tt.cred)
+			}
 			errors.require(tt.wantErrors, "TEMPFILE", tmp, "TEMPDIR", filepath.Dir(tmp))
 			if tt.wantTestFile != nil {
 				tt.wantTestFile(t, tmp)
@@ -351,6 +378,100 @@ func TestPutToken(t *testing.T) {
 	}
 }
 
+func TestDeleteForIssuer(t *testing.T) {
+	t.Parallel()
+	now := time.Now().Round(1 * time.Second)
+
+	newEntry := func(key string, issuer string) entry {
+		return entry{
+			Key:               key,
+			Issuer:            issuer,
+			CreationTimestamp: metav1.NewTime(now.Add(-2 * time.Minute)),
+			LastUsedTimestamp: metav1.NewTime(now.Add(-1 * time.Minute)),
+			Credential: &clientauthenticationv1beta1.ExecCredentialStatus{
+				ExpirationTimestamp: timePtr(now.Add(1 * time.Hour)),
+				Token:               "token-" + key,
+			},
+		}
+	}
+
+	tests := []struct {
+		name         string
+		makeTestFile func(t *testing.T, tmp string)
+		issuer       string
+		want         int
+		wantErrors   []string
+		wantKeys     []string
+	}{
+		{
+			name:   "not found",
+			issuer: "test-issuer",
+		},
+		{
+			name: "invalid file",
+			makeTestFile: func(t *testing.T, tmp string) {
+				require.NoError(t, ioutil.WriteFile(tmp, []byte("invalid yaml"), 0600))
+			},
+			issuer: "test-issuer",
+			wantErrors: []string{
+				"failed to read cache, resetting: invalid cache file: error unmarshaling JSON: while decoding JSON: json: cannot unmarshal string into Go value of type execcredcache.credCache",
+			},
+			wantKeys: []string{},
+		},
+		{
+			name: "valid file with credentials of several issuers",
+			makeTestFile: func(t *testing.T, tmp string) {
+				validCache := emptyCache()
+				validCache.Entries = []entry{
+					newEntry("key-1", "test-issuer"),
+					newEntry("key-2", "other-issuer"),
+					newEntry("key-3", ""),
+					newEntry("key-4", "test-issuer"),
+				}
+				require.NoError(t, validCache.writeTo(tmp))
+			},
+			issuer:   "test-issuer",
+			want:     2,
+			wantKeys: []string{"key-2", "key-3"},
+		},
+		{
+			name: "empty issuer",
+			makeTestFile: func(t *testing.T, tmp string) {
+				validCache := emptyCache()
+				validCache.Entries = []entry{newEntry("key-1", "")}
+				require.NoError(t, validCache.writeTo(tmp))
+			},
+			issuer:   "",
+			wantKeys: []string{"key-1"},
+		},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+			tmp := testutil.TempDir(t) + "/credentials.yaml"
+			if tt.makeTestFile != nil {
+				tt.makeTestFile(t, tmp)
+			}
+			// Initialize a cache with a reporter that collects errors
+			errors := errorCollector{t: t}
+			c := New(tmp)
+			c.errReporter = errors.report
+			require.Equal(t, tt.want, c.DeleteForIssuer(tt.issuer))
+			errors.require(tt.wantErrors, "TEMPFILE", tmp)
+			if tt.wantKeys != nil {
+				cache, err := readCache(tmp)
+				require.NoError(t, err)
+				keys := []string{}
+				for _, e := range cache.Entries {
+					keys = append(keys, e.Key)
+				}
+				require.Equal(t, tt.wantKeys, keys)
+			}
+		})
+	}
+}
+
 func TestHashing(t *testing.T) {
 	type testKey struct{ K1, K2 string }
 	require.Equal(t, "38e0b9de817f645c4bec37c0d4a3e58baecccb040f5718dc069a72c7385a0bed", jsonSHA256Hex(nil))
@@ -5,19 +5,21 @@ package accesstoken
 
 import (
 	"context"
+	"encoding/json"
+	stderrors "errors"
 	"fmt"
 	"time"
 
 	"github.com/ory/fosite"
 	"github.com/ory/fosite/handler/oauth2"
-	"github.com/ory/fosite/handler/openid"
+	corev1 "k8s.io/api/core/v1"
 	"k8s.io/apimachinery/pkg/api/errors"
-	corev1client "k8s.io/client-go/kubernetes/typed/core/v1"
 
 	"go.pinniped.dev/internal/constable"
 	"go.pinniped.dev/internal/crud"
 	"go.pinniped.dev/internal/fositestorage"
 	"go.pinniped.dev/internal/oidc/clientregistry"
+	"go.pinniped.dev/internal/psession"
 )
 
 const (
@@ -26,12 +28,13 @@ const (
 	ErrInvalidAccessTokenRequestVersion = constable.Error("access token request data has wrong version")
 	ErrInvalidAccessTokenRequestData    = constable.Error("access token request data must be present")
 
-	accessTokenStorageVersion = "1"
+	accessTokenStorageVersion = "2"
 )
 
 type RevocationStorage interface {
 	oauth2.AccessTokenStorage
 	RevokeAccessToken(ctx context.Context, requestID string) error
+	GetAccessTokenSessionByRequestID(ctx context.Context, requestID string) (fosite.Requester, error)
 }
 
 var _ RevocationStorage = &accessTokenStorage{}
@@ -45,14 +48,40 @@ type session struct {
 	Version string          `json:"version"`
 }
 
-func New(secrets corev1client.SecretInterface, clock func() time.Time, sessionStorageLifetime time.Duration) RevocationStorage {
-	return &accessTokenStorage{storage: crud.New(TypeLabelValue, secrets, clock, sessionStorageLifetime)}
+func New(backend crud.Backend, clock func() time.Time, sessionStorageLifetime time.Duration) RevocationStorage {
+	return &accessTokenStorage{storage: backend.Storage(TypeLabelValue, clock, sessionStorageLifetime)}
 }
 
 func (a *accessTokenStorage) RevokeAccessToken(ctx context.Context, requestID string) error {
 	return a.storage.DeleteByLabel(ctx, fositestorage.StorageRequestIDLabelName, requestID)
 }
 
+// GetAccessTokenSessionByRequestID returns the stored request of a access token of the session with the given request ID,
+// without knowing the access token itself.
+func (a *accessTokenStorage) GetAccessTokenSessionByRequestID(ctx context.Context, requestID string) (fosite.Requester, error) {
+	session := newValidEmptyAccessTokenSession()
+	_, err := a.storage.GetByLabel(ctx, fositestorage.StorageRequestIDLabelName, requestID, session)
+
+	if stderrors.Is(err, crud.ErrNoSecretsFound) {
+		return nil, fosite.ErrNotFound.WithWrap(err).WithDebug(err.Error())
+	}
+
+	if err != nil {
+		return nil, fmt.Errorf("failed to get access token session for request ID %s: %w", requestID, err)
+	}
+
+	if version := session.Version; version != accessTokenStorageVersion {
+		return nil, fmt.Errorf("%w: access token session for request ID %s has version %s instead of %s",
+			ErrInvalidAccessTokenRequestVersion, requestID, version, accessTokenStorageVersion)
+	}
+
+	if session.Request.ID == "" {
+		return nil, fmt.Errorf("malformed access token session for request ID %s: %w", requestID, ErrInvalidAccessTokenRequestData)
+	}
+
+	return session.Request, nil
+}
+
 func (a *accessTokenStorage) CreateAccessTokenSession(ctx context.Context, signature string, requester fosite.Requester) error {
 	request, err := fositestorage.ValidateAndExtractAuthorizeRequest(requester)
 	if err != nil {
@@ -106,11 +135,51 @@ func (a *accessTokenStorage) getSession(ctx context.Context, signature string) (
 	return session, rv, nil
 }
 
+// ReadFromSecret decodes the access token session which is stored in the given Secret, e.g. a Secret which was read
+// from an informer's cache instead of through this storage.
+func ReadFromSecret(secret *corev1.Secret) (*fosite.Request, error) {
+	session := newValidEmptyAccessTokenSession()
+	if err := crud.FromSecret(TypeLabelValue, secret, session); err != nil {
+		return nil, fmt.Errorf("failed to read access token session from secret %s: %w", secret.Name, err)
+	}
+
+	if version := session.Version; version != accessTokenStorageVersion {
+		return nil, fmt.Errorf("%w: access token session in secret %s has version %s instead of %s",
+			ErrInvalidAccessTokenRequestVersion, secret.Name, version, accessTokenStorageVersion)
+	}
+
+	if session.Request.ID == "" {
+		return nil, fmt.Errorf("malformed access token session in secret %s: %w", secret.Name, ErrInvalidAccessTokenRequestData)
+	}
+
+	return session.Request, nil
+}
+
+// ReadFromListedItem decodes the access token session of the given item, which was listed from the crud.ListingStorage
+// of this storage.
+func ReadFromListedItem(item *crud.ListedItem) (*fosite.Request, error) {
+	session := newValidEmptyAccessTokenSession()
+	if err := json.Unmarshal(item.Data, session); err != nil {
+		return nil, fmt.Errorf("failed to decode access token session for %s: %w", item.Signature, err)
+	}
+
+	if version := session.Version; version != accessTokenStorageVersion {
+		return nil, fmt.Errorf("%w: access token session for %s has version %s instead of %s",
+			ErrInvalidAccessTokenRequestVersion, item.Signature, version, accessTokenStorageVersion)
+	}
+
+	if session.Request.ID == "" {
+		return nil, fmt.Errorf("malformed access token session for %s: %w", item.Signature, ErrInvalidAccessTokenRequestData)
+	}
+
+	return session.Request, nil
+}
+
 func newValidEmptyAccessTokenSession() *session {
 	return &session{
 		Request: &fosite.Request{
 			Client:  &clientregistry.Client{},
-			Session: &openid.DefaultSession{},
+			Session: &psession.PinnipedSession{},
 		},
 	}
 }
@@ -0,0 +1,271 @@
+// Copyright 2021 the Pinniped contributors. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+// Package devicecode stores the state of OAuth 2.0 device authorization grants (RFC8628).
+package devicecode
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+
+	"github.com/ory/fosite"
+	"k8s.io/apimachinery/pkg/api/errors"
+
+	"go.pinniped.dev/internal/constable"
+	"go.pinniped.dev/internal/crud"
+	"go.pinniped.dev/internal/fositestorage"
+	"go.pinniped.dev/internal/oidc/clientregistry"
+	"go.pinniped.dev/internal/psession"
+)
+
+const (
+	TypeLabelValue         = "device-code"
+	UserCodeTypeLabelValue = "device-user-code"
+
+	ErrInvalidDeviceCodeRequestVersion = constable.Error("device code request data has wrong version")
+	ErrInvalidDeviceCodeRequestData    = constable.Error("device code request data must be present")
+
+	deviceCodeStorageVersion = "1"
+
+	// userCodeCharset only has consonants which are hard to confuse with each other, so user codes are easy to
+	// read and type, and cannot spell words.
+	userCodeCharset = "BCDFGHJKLMNPQRSTVWXZ"
+	userCodeLength  = 8
+)
+
+// Status describes how far the end user has gotten with approving a device authorization request.
+type Status string
+
+const (
+	// StatusPending means that the end user has not yet finished logging in using the verification page.
+	StatusPending = Status("pending")
+
+	// StatusApproved means that the end user has logged in, so the device code may be redeemed for tokens.
+	StatusApproved = Status("approved")
+)
+
+// DeviceCodeStorage stores device authorization requests, keyed by the signature of their device code.
+// They can also be found using their user code, which is the code that the end user types into the verification page.
+type DeviceCodeStorage interface {
+	CreateDeviceCodeSession(ctx context.Context, signatureOfDeviceCode string, session *Session) error
+	GetDeviceCodeSession(ctx context.Context, signatureOfDeviceCode string) (*Session, error)
+	GetDeviceCodeSessionByUserCode(ctx context.Context, userCode string) (string, *Session, error)
+	UpdateDeviceCodeSession(ctx context.Context, signatureOfDeviceCode string, session *Session) error
+	DeleteDeviceCodeSession(ctx context.Context, signatureOfDeviceCode string) error
+}
+
+var _ DeviceCodeStorage = &deviceCodeStorage{}
+
+type deviceCodeStorage struct {
+	storage         crud.Storage
+	userCodeStorage crud.Storage
+}
+
+// Session is the stored state of a device authorization request.
+type Session struct {
+	// Request holds the client and the requested scopes. Once the request is approved, it also holds the granted
+	// scopes and the downstream session of the end user.
+	Request *fosite.Request `json:"request"`
+
+	// Status is the progress of the end user's approval of this request.
+	Status Status `json:"status"`
+
+	// UserCode is the normalized user code of this request. See NormalizeUserCode.
+	UserCode string `json:"userCode"`
+
+	// ExpiresAt is the time after which the device code and the user code may no longer be used.
+	ExpiresAt time.Time `json:"expiresAt"`
+
+	// UpstreamName and UpstreamType identify the upstream identity provider which the end user will use to log in.
+	UpstreamName string `json:"upstreamName"`
+	UpstreamType string `json:"upstreamType"`
+
+	Version string `json:"version"`
+
+	// resourceVersion is not stored. It is remembered by GetDeviceCodeSession so that UpdateDeviceCodeSession
+	// can detect concurrent updates.
+	resourceVersion string
+}
+
+// userCodeSession points from a user code to the signature of the device code which was issued alongside it.
+type userCodeSession struct {
+	SignatureOfDeviceCode string `json:"signature"`
+	Version               string `json:"version"`
+}
+
+func New(backend crud.Backend, clock func() time.Time, sessionStorageLifetime time.Duration) DeviceCodeStorage {
+	return &deviceCodeStorage{
+		storage:         backend.Storage(TypeLabelValue, clock, sessionStorageLifetime),
+		userCodeStorage: backend.Storage(UserCodeTypeLabelValue, clock, sessionStorageLifetime),
+	}
+}
+
+// Signature returns the value which is used to store and look up the session of a device code. Device codes are
+// long random values, so a plain hash is enough to avoid storing them directly.
+func Signature(deviceCode string) string {
+	hash := sha256.Sum256([]byte(deviceCode))
+	return base64.RawURLEncoding.EncodeToString(hash[:])
+}
+
+// GenerateDeviceCode returns a new random device code.
+func GenerateDeviceCode() (string, error) { return generateDeviceCode(rand.Reader) }
+
+func generateDeviceCode(rand io.Reader) (string, error) {
+	var buf [32]byte
+	if _, err := io.ReadFull(rand, buf[:]); err != nil {
+		return "", fmt.Errorf("could not generate device code: %w", err)
+	}
+	return base64.RawURLEncoding.EncodeToString(buf[:]), nil
+}
+
+// GenerateUserCode returns a new random user code, formatted like "BCDF-GHJK" for readability.
+func GenerateUserCode() (string, error) { return generateUserCode(rand.Reader) }
+
+func generateUserCode(rand io.Reader) (string, error) {
+	// Reject the bytes which would make some characters more likely than others.
+	const maxUnbiasedByte = 256 - (256 % len(userCodeCharset))
+
+	code := make([]byte, 0, userCodeLength)
+	var buf [1]byte
+	for len(code) < userCodeLength {
+		if _, err := io.ReadFull(rand, buf[:]); err != nil {
+			return "", fmt.Errorf("could not generate user code: %w", err)
+		}
+		if int(buf[0]) >= maxUnbiasedByte {
+			continue
+		}
+		code = append(code, userCodeCharset[int(buf[0])%len(userCodeCharset)])
+	}
+	return string(code[:userCodeLength/2]) + "-" + string(code[userCodeLength/2:]), nil
+}
+
+// NormalizeUserCode removes the punctuation and whitespace which may appear in user codes typed by end users,
+// and makes them case-insensitive.
+func NormalizeUserCode(userCode string) string {
+	return strings.Map(func(r rune) rune {
+		switch {
+		case r == '-' || r == ' ' || r == '\t':
+			return -1
+		default:
+			return r
+		}
+	}, strings.ToUpper(userCode))
+}
+
+func (d *deviceCodeStorage) CreateDeviceCodeSession(ctx context.Context, signatureOfDeviceCode string, session *Session) error {
+	if _, err := fositestorage.ValidateAndExtractAuthorizeRequest(session.Request); err != nil {
+		return err
+	}
+
+	// Claim the user code first, so a user code can never point to the wrong device code.
+	// This fails if the user code was already in use, which is very unlikely since they are random.
+	if _, err := d.userCodeStorage.Create(
+		ctx,
+		session.UserCode,
+		&userCodeSession{SignatureOfDeviceCode: signatureOfDeviceCode, Version: deviceCodeStorageVersion},
+		nil,
+	); err != nil {
+		return err
+	}
+
+	session.Version = deviceCodeStorageVersion
+	_, err := d.storage.Create(ctx, signatureOfDeviceCode, session, nil)
+	return err
+}
+
+func (d *deviceCodeStorage) GetDeviceCodeSession(ctx context.Context, signatureOfDeviceCode string) (*Session, error) {
+	session := newValidEmptyDeviceCodeSession()
+	rv, err := d.storage.Get(ctx, signatureOfDeviceCode, session)
+
+	if errors.IsNotFound(err) {
+		return nil, fosite.ErrNotFound.WithWrap(err).WithDebug(err.Error())
+	}
+
+	if err != nil {
+		return nil, fmt.Errorf("failed to get device code session for %s: %w", signatureOfDeviceCode, err)
+	}
+
+	if version := session.Version; version != deviceCodeStorageVersion {
+		return nil, fmt.Errorf("%w: device code session for %s has version %s instead of %s",
+			ErrInvalidDeviceCodeRequestVersion, signatureOfDeviceCode, version, deviceCodeStorageVersion)
+	}
+
+	if session.Request.ID == "" {
+		return nil, fmt.Errorf("malformed device code session for %s: %w", signatureOfDeviceCode, ErrInvalidDeviceCodeRequestData)
+	}
+
+	session.resourceVersion = rv
+	return session, nil
+}
+
+func (d *deviceCodeStorage) GetDeviceCodeSessionByUserCode(ctx context.Context, userCode string) (string, *Session, error) {
+	userCodeSession := &userCodeSession{}
+	_, err := d.userCodeStorage.Get(ctx, userCode, userCodeSession)
+
+	if errors.IsNotFound(err) {
+		return "", nil, fosite.ErrNotFound.WithWrap(err).WithDebug(err.Error())
+	}
+
+	if err != nil {
+		return "", nil, fmt.Errorf("failed to get device user code session for %s: %w", userCode, err)
+	}
+
+	if version := userCodeSession.Version; version != deviceCodeStorageVersion {
+		return "", nil, fmt.Errorf("%w: device user code session for %s has version %s instead of %s",
+			ErrInvalidDeviceCodeRequestVersion, userCode, version, deviceCodeStorageVersion)
+	}
+
+	session, err := d.GetDeviceCodeSession(ctx, userCodeSession.SignatureOfDeviceCode)
+	if err != nil {
+		return "", nil, err
+	}
+
+	return userCodeSession.SignatureOfDeviceCode, session, nil
+}
+
+func (d *deviceCodeStorage) UpdateDeviceCodeSession(ctx context.Context, signatureOfDeviceCode string, session *Session) error {
+	if _, err := fositestorage.ValidateAndExtractAuthorizeRequest(session.Request); err != nil {
+		return err
+	}
+
+	session.Version = deviceCodeStorageVersion
+	rv, err := d.storage.Update(ctx, signatureOfDeviceCode, session.resourceVersion, session, nil)
+	if err != nil {
+		return err
+	}
+
+	session.resourceVersion = rv
+	return nil
+}
+
+func (d *deviceCodeStorage) DeleteDeviceCodeSession(ctx context.Context, signatureOfDeviceCode string) error {
+	session, err := d.GetDeviceCodeSession(ctx, signatureOfDeviceCode)
+	if err != nil {
+		return err
+	}
+
+	if err := d.storage.Delete(ctx, signatureOfDeviceCode); err != nil {
+		return err
+	}
+
+	// The user code is no longer needed after the device code is gone. If this fails, then the user code will be
+	// garbage collected later, and it cannot be used in the meantime since it points to a device code which is gone.
+	_ = d.userCodeStorage.Delete(ctx, session.UserCode)
+
+	return nil
+}
+
+func newValidEmptyDeviceCodeSession() *Session {
+	return &Session{
+		Request: &fosite.Request{
+			Client:  &clientregistry.Client{},
+			Session: &psession.PinnipedSession{},
+		},
+	}
+}
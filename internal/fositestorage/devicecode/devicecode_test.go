@@ -0,0 +1,254 @@
+// Copyright 2021 the Pinniped contributors. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package devicecode
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ory/fosite"
+	"github.com/ory/fosite/handler/openid"
+	"github.com/stretchr/testify/require"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/clock"
+	"k8s.io/client-go/kubernetes/fake"
+	corev1client "k8s.io/client-go/kubernetes/typed/core/v1"
+
+	"go.pinniped.dev/internal/crud"
+	"go.pinniped.dev/internal/oidc/clientregistry"
+	"go.pinniped.dev/internal/psession"
+)
+
+const namespace = "test-ns"
+
+var fakeNow = time.Date(2030, time.January, 1, 0, 0, 0, 0, time.UTC)
+var lifetime = time.Minute * 11
+var fakeNowPlusLifetimeAsString = metav1.Time{Time: fakeNow.Add(lifetime)}.Format(time.RFC3339)
+
+func TestDeviceCodeStorage(t *testing.T) {
+	ctx, _, secrets, storage := makeTestSubject()
+
+	session := &Session{
+		Request:      newTestRequest(),
+		Status:       StatusPending,
+		UserCode:     "BCDFGHJK",
+		ExpiresAt:    fakeNow.Add(10 * time.Minute),
+		UpstreamName: "some-upstream",
+		UpstreamType: "oidc",
+	}
+	err := storage.CreateDeviceCodeSession(ctx, "fancy-signature", session)
+	require.NoError(t, err)
+
+	// Both the device code and the user code are stored, and both are garbage collected after the lifetime.
+	secretList, err := secrets.List(ctx, metav1.ListOptions{})
+	require.NoError(t, err)
+	require.Len(t, secretList.Items, 2)
+	var types []string
+	for _, secret := range secretList.Items {
+		types = append(types, string(secret.Type))
+		require.Equal(t, fakeNowPlusLifetimeAsString, secret.Annotations["storage.pinniped.dev/garbage-collect-after"])
+	}
+	require.ElementsMatch(t, []string{"storage.pinniped.dev/device-code", "storage.pinniped.dev/device-user-code"}, types)
+
+	gotSession, err := storage.GetDeviceCodeSession(ctx, "fancy-signature")
+	require.NoError(t, err)
+	require.Equal(t, session.Request, gotSession.Request)
+	require.Equal(t, StatusPending, gotSession.Status)
+	require.Equal(t, "BCDFGHJK", gotSession.UserCode)
+	require.True(t, session.ExpiresAt.Equal(gotSession.ExpiresAt))
+	require.Equal(t, "some-upstream", gotSession.UpstreamName)
+	require.Equal(t, "oidc", gotSession.UpstreamType)
+	require.Equal(t, "1", gotSession.Version)
+
+	signature, sessionByUserCode, err := storage.GetDeviceCodeSessionByUserCode(ctx, "BCDFGHJK")
+	require.NoError(t, err)
+	require.Equal(t, "fancy-signature", signature)
+	require.Equal(t, gotSession, sessionByUserCode)
+
+	// Approve the request.
+	sessionByUserCode.Status = StatusApproved
+	sessionByUserCode.Request.GrantScope("openid")
+	err = storage.UpdateDeviceCodeSession(ctx, signature, sessionByUserCode)
+	require.NoError(t, err)
+
+	approvedSession, err := storage.GetDeviceCodeSession(ctx, "fancy-signature")
+	require.NoError(t, err)
+	require.Equal(t, StatusApproved, approvedSession.Status)
+	require.Equal(t, fosite.Arguments{"openid"}, approvedSession.Request.GetGrantedScopes())
+
+	// Deleting the device code session also deletes its user code.
+	err = storage.DeleteDeviceCodeSession(ctx, "fancy-signature")
+	require.NoError(t, err)
+	secretList, err = secrets.List(ctx, metav1.ListOptions{})
+	require.NoError(t, err)
+	require.Empty(t, secretList.Items)
+}
+
+func TestCreateWithUserCodeAlreadyInUse(t *testing.T) {
+	ctx, _, _, storage := makeTestSubject()
+
+	err := storage.CreateDeviceCodeSession(ctx, "fancy-signature", &Session{Request: newTestRequest(), UserCode: "BCDFGHJK"})
+	require.NoError(t, err)
+
+	err = storage.CreateDeviceCodeSession(ctx, "other-signature", &Session{Request: newTestRequest(), UserCode: "BCDFGHJK"})
+	require.Error(t, err)
+
+	// The second device code must not have been stored, since its user code points to the first device code.
+	_, err = storage.GetDeviceCodeSession(ctx, "other-signature")
+	require.True(t, errors.Is(err, fosite.ErrNotFound))
+}
+
+func TestGetNotFound(t *testing.T) {
+	ctx, _, _, storage := makeTestSubject()
+
+	_, notFoundErr := storage.GetDeviceCodeSession(ctx, "non-existent-signature")
+	require.EqualError(t, notFoundErr, "not_found")
+	require.True(t, errors.Is(notFoundErr, fosite.ErrNotFound))
+
+	_, _, notFoundErr = storage.GetDeviceCodeSessionByUserCode(ctx, "NONEXIST")
+	require.EqualError(t, notFoundErr, "not_found")
+	require.True(t, errors.Is(notFoundErr, fosite.ErrNotFound))
+
+	notFoundErr = storage.DeleteDeviceCodeSession(ctx, "non-existent-signature")
+	require.True(t, errors.Is(notFoundErr, fosite.ErrNotFound))
+}
+
+func TestWrongVersion(t *testing.T) {
+	ctx, _, secrets, storage := makeTestSubject()
+
+	secret := &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{
+			Name: "pinniped-storage-device-code-pwu5zs7lekbhnln2w4",
+			Labels: map[string]string{
+				"storage.pinniped.dev/type": "device-code",
+			},
+		},
+		Data: map[string][]byte{
+			"pinniped-storage-data":    []byte(`{"request":{"id":"abcd-1"},"version":"not-the-right-version"}`),
+			"pinniped-storage-version": []byte("1"),
+		},
+		Type: "storage.pinniped.dev/device-code",
+	}
+	_, err := secrets.Create(ctx, secret, metav1.CreateOptions{})
+	require.NoError(t, err)
+
+	_, err = storage.GetDeviceCodeSession(ctx, "fancy-signature")
+	require.EqualError(t, err, "device code request data has wrong version: device code session for fancy-signature has version not-the-right-version instead of 1")
+}
+
+func TestNilSessionRequest(t *testing.T) {
+	ctx, _, secrets, storage := makeTestSubject()
+
+	secret := &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{
+			Name: "pinniped-storage-device-code-pwu5zs7lekbhnln2w4",
+			Labels: map[string]string{
+				"storage.pinniped.dev/type": "device-code",
+			},
+		},
+		Data: map[string][]byte{
+			"pinniped-storage-data":    []byte(`{"nonsense-key": "nonsense-value","version":"1"}`),
+			"pinniped-storage-version": []byte("1"),
+		},
+		Type: "storage.pinniped.dev/device-code",
+	}
+	_, err := secrets.Create(ctx, secret, metav1.CreateOptions{})
+	require.NoError(t, err)
+
+	_, err = storage.GetDeviceCodeSession(ctx, "fancy-signature")
+	require.EqualError(t, err, "malformed device code session for fancy-signature: device code request data must be present")
+}
+
+func TestCreateWithWrongRequesterDataTypes(t *testing.T) {
+	ctx, _, _, storage := makeTestSubject()
+
+	err := storage.CreateDeviceCodeSession(ctx, "signature-doesnt-matter", &Session{
+		Request: &fosite.Request{Session: nil, Client: &clientregistry.Client{}},
+	})
+	require.EqualError(t, err, "requester's session must be of type PinnipedSession")
+
+	err = storage.CreateDeviceCodeSession(ctx, "signature-doesnt-matter", &Session{
+		Request: &fosite.Request{Session: &psession.PinnipedSession{}, Client: nil},
+	})
+	require.EqualError(t, err, "requester's client must be of type clientregistry.Client")
+}
+
+func TestSignature(t *testing.T) {
+	require.Equal(t, Signature("some-device-code"), Signature("some-device-code"))
+	require.NotEqual(t, Signature("some-device-code"), Signature("other-device-code"))
+	require.NotContains(t, Signature("some-device-code"), "some-device-code")
+}
+
+func TestNormalizeUserCode(t *testing.T) {
+	require.Equal(t, "BCDFGHJK", NormalizeUserCode("BCDF-GHJK"))
+	require.Equal(t, "BCDFGHJK", NormalizeUserCode(" bcdf ghjk\t"))
+	require.Equal(t, "BCDFGHJK", NormalizeUserCode("bCdF-gHjK"))
+}
+
+func TestGenerateDeviceCode(t *testing.T) {
+	code, err := generateDeviceCode(bytes.NewReader([]byte("0123456789ABCDEFGHIJKLMNOPQRSTUV")))
+	require.NoError(t, err)
+	require.Equal(t, "MDEyMzQ1Njc4OUFCQ0RFRkdISUpLTE1OT1BRUlNUVVY", code)
+
+	code, err = generateDeviceCode(bytes.NewReader([]byte("too short")))
+	require.EqualError(t, err, "could not generate device code: unexpected EOF")
+	require.Empty(t, code)
+
+	code, err = GenerateDeviceCode()
+	require.NoError(t, err)
+	require.Len(t, code, 43)
+}
+
+func TestGenerateUserCode(t *testing.T) {
+	// The bytes 240 to 255 are skipped, so that each character is equally likely.
+	code, err := generateUserCode(bytes.NewReader([]byte{0, 1, 2, 3, 240, 255, 19, 20, 21, 39}))
+	require.NoError(t, err)
+	require.Equal(t, "BCDF-ZBCZ", code)
+
+	code, err = generateUserCode(bytes.NewReader([]byte{0, 1, 2}))
+	require.EqualError(t, err, "could not generate user code: EOF")
+	require.Empty(t, code)
+
+	code, err = GenerateUserCode()
+	require.NoError(t, err)
+	require.Len(t, code, 9)
+	require.Equal(t, "-", code[4:5])
+	for _, r := range NormalizeUserCode(code) {
+		require.True(t, strings.ContainsRune(userCodeCharset, r))
+	}
+}
+
+func newTestRequest() *fosite.Request {
+	return &fosite.Request{
+		ID:          "abcd-1",
+		RequestedAt: time.Time{},
+		Client: &clientregistry.Client{
+			DefaultOpenIDConnectClient: fosite.DefaultOpenIDConnectClient{
+				DefaultClient: &fosite.DefaultClient{
+					ID:     "pinny",
+					Scopes: fosite.Arguments{"openid"},
+					Public: true,
+				},
+			},
+		},
+		RequestedScope: fosite.Arguments{"openid"},
+		GrantedScope:   fosite.Arguments{},
+		Form:           url.Values{"client_id": []string{"pinny"}, "scope": []string{"openid"}},
+		Session: &psession.PinnipedSession{
+			Fosite: &openid.DefaultSession{},
+		},
+	}
+}
+
+func makeTestSubject() (context.Context, *fake.Clientset, corev1client.SecretInterface, DeviceCodeStorage) {
+	client := fake.NewSimpleClientset()
+	secrets := client.CoreV1().Secrets(namespace)
+	return context.Background(), client, secrets, New(crud.NewSecretsBackend(secrets), clock.NewFakeClock(fakeNow).Now, lifetime)
+}
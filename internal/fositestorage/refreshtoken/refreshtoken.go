@@ -5,19 +5,21 @@ package refreshtoken
 
 import (
 	"context"
+	"encoding/json"
+	stderrors "errors"
 	"fmt"
 	"time"
 
 	"github.com/ory/fosite"
 	"github.com/ory/fosite/handler/oauth2"
-	"github.com/ory/fosite/handler/openid"
+	corev1 "k8s.io/api/core/v1"
 	"k8s.io/apimachinery/pkg/api/errors"
-	corev1client "k8s.io/client-go/kubernetes/typed/core/v1"
 
 	"go.pinniped.dev/internal/constable"
 	"go.pinniped.dev/internal/crud"
 	"go.pinniped.dev/internal/fositestorage"
 	"go.pinniped.dev/internal/oidc/clientregistry"
+	"go.pinniped.dev/internal/psession"
 )
 
 const (
@@ -26,12 +28,13 @@ const (
 	ErrInvalidRefreshTokenRequestVersion = constable.Error("refresh token request data has wrong version")
 	ErrInvalidRefreshTokenRequestData    = constable.Error("refresh token request data must be present")
 
-	refreshTokenStorageVersion = "1"
+	refreshTokenStorageVersion = "2"
 )
 
 type RevocationStorage interface {
 	oauth2.RefreshTokenStorage
 	RevokeRefreshToken(ctx context.Context, requestID string) error
+	GetRefreshTokenSessionByRequestID(ctx context.Context, requestID string) (fosite.Requester, error)
 }
 
 var _ RevocationStorage = &refreshTokenStorage{}
@@ -45,14 +48,40 @@ type session struct {
 	Version string          `json:"version"`
 }
 
-func New(secrets corev1client.SecretInterface, clock func() time.Time, sessionStorageLifetime time.Duration) RevocationStorage {
-	return &refreshTokenStorage{storage: crud.New(TypeLabelValue, secrets, clock, sessionStorageLifetime)}
+func New(backend crud.Backend, clock func() time.Time, sessionStorageLifetime time.Duration) RevocationStorage {
+	return &refreshTokenStorage{storage: backend.Storage(TypeLabelValue, clock, sessionStorageLifetime)}
 }
 
 func (a *refreshTokenStorage) RevokeRefreshToken(ctx context.Context, requestID string) error {
 	return a.storage.DeleteByLabel(ctx, fositestorage.StorageRequestIDLabelName, requestID)
 }
 
+// GetRefreshTokenSessionByRequestID returns the stored request of a refresh token of the session with the given request ID,
+// without knowing the refresh token itself.
+func (a *refreshTokenStorage) GetRefreshTokenSessionByRequestID(ctx context.Context, requestID string) (fosite.Requester, error) {
+	session := newValidEmptyRefreshTokenSession()
+	_, err := a.storage.GetByLabel(ctx, fositestorage.StorageRequestIDLabelName, requestID, session)
+
+	if stderrors.Is(err, crud.ErrNoSecretsFound) {
+		return nil, fosite.ErrNotFound.WithWrap(err).WithDebug(err.Error())
+	}
+
+	if err != nil {
+		return nil, fmt.Errorf("failed to get refresh token session for request ID %s: %w", requestID, err)
+	}
+
+	if version := session.Version; version != refreshTokenStorageVersion {
+		return nil, fmt.Errorf("%w: refresh token session for request ID %s has version %s instead of %s",
+			ErrInvalidRefreshTokenRequestVersion, requestID, version, refreshTokenStorageVersion)
+	}
+
+	if session.Request.ID == "" {
+		return nil, fmt.Errorf("malformed refresh token session for request ID %s: %w", requestID, ErrInvalidRefreshTokenRequestData)
+	}
+
+	return session.Request, nil
+}
+
 func (a *refreshTokenStorage) CreateRefreshTokenSession(ctx context.Context, signature string, requester fosite.Requester) error {
 	request, err := fositestorage.ValidateAndExtractAuthorizeRequest(requester)
 	if err != nil {
@@ -106,11 +135,51 @@ func (a *refreshTokenStorage) getSession(ctx context.Context, signature string)
 	return session, rv, nil
 }
 
+// ReadFromSecret decodes the refresh token session which is stored in the given Secret, e.g. a Secret which was read
+// from an informer's cache instead of through this storage.
+func ReadFromSecret(secret *corev1.Secret) (*fosite.Request, error) {
+	session := newValidEmptyRefreshTokenSession()
+	if err := crud.FromSecret(TypeLabelValue, secret, session); err != nil {
+		return nil, fmt.Errorf("failed to read refresh token session from secret %s: %w", secret.Name, err)
+	}
+
+	if version := session.Version; version != refreshTokenStorageVersion {
+		return nil, fmt.Errorf("%w: refresh token session in secret %s has version %s instead of %s",
+			ErrInvalidRefreshTokenRequestVersion, secret.Name, version, refreshTokenStorageVersion)
+	}
+
+	if session.Request.ID == "" {
+		return nil, fmt.Errorf("malformed refresh token session in secret %s: %w", secret.Name, ErrInvalidRefreshTokenRequestData)
+	}
+
+	return session.Request, nil
+}
+
+// ReadFromListedItem decodes the refresh token session of the given item, which was listed from the crud.ListingStorage
+// of this storage.
+func ReadFromListedItem(item *crud.ListedItem) (*fosite.Request, error) {
+	session := newValidEmptyRefreshTokenSession()
+	if err := json.Unmarshal(item.Data, session); err != nil {
+		return nil, fmt.Errorf("failed to decode refresh token session for %s: %w", item.Signature, err)
+	}
+
+	if version := session.Version; version != refreshTokenStorageVersion {
+		return nil, fmt.Errorf("%w: refresh token session for %s has version %s instead of %s",
+			ErrInvalidRefreshTokenRequestVersion, item.Signature, version, refreshTokenStorageVersion)
+	}
+
+	if session.Request.ID == "" {
+		return nil, fmt.Errorf("malformed refresh token session for %s: %w", item.Signature, ErrInvalidRefreshTokenRequestData)
+	}
+
+	return session.Request, nil
+}
+
 func newValidEmptyRefreshTokenSession() *session {
 	return &session{
 		Request: &fosite.Request{
 			Client:  &clientregistry.Client{},
-			Session: &openid.DefaultSession{},
+			Session: &psession.PinnipedSession{},
 		},
 	}
 }
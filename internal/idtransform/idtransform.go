@@ -0,0 +1,476 @@
+// Copyright 2021 the Pinniped contributors. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+// Package idtransform transforms the usernames and groups of end users who log in using an upstream identity
+// provider, and applies the login policy of the FederationDomain, before their downstream sessions are created.
+package idtransform
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+
+	configv1alpha1 "go.pinniped.dev/generated/latest/apis/supervisor/config/v1alpha1"
+	"go.pinniped.dev/internal/plog"
+)
+
+// Pipeline is an ordered list of transformations. Each transformation is applied to the result of the previous one.
+// The Policy, if any, is applied last.
+type Pipeline struct {
+	steps  []step
+	policy *Policy
+}
+
+// Policy is the validated login policy of a FederationDomain.
+type Policy struct {
+	groupRules []groupRule
+	denyRules  []denyRule
+}
+
+type groupRule struct {
+	match     *matcher // nil matches every end user
+	groups    []string
+	fromClaim string
+	prefix    string
+}
+
+type denyRule struct {
+	match   *matcher
+	message string
+}
+
+// matcher is a validated configv1alpha1.FederationDomainPolicyMatch.
+type matcher struct {
+	usernameRegex *regexp.Regexp
+	inAnyGroup    map[string]bool
+	notInAnyGroup map[string]bool
+	claims        []claimMatcher
+}
+
+// claimMatcher is a validated configv1alpha1.FederationDomainClaimMatch. Exactly one of values and regex is set.
+type claimMatcher struct {
+	name   string
+	values map[string]bool
+	regex  *regexp.Regexp
+}
+
+// Result is the identity which results from evaluating a Pipeline.
+type Result struct {
+	Username string
+	Groups   []string
+
+	// Rejected is true when the end user is not allowed to log in. The Username and Groups are not meaningful
+	// for a rejected end user.
+	Rejected bool
+
+	// RejectedReason explains why the end user is not allowed to log in.
+	RejectedReason string
+}
+
+type step func(result *Result)
+
+// NewPipeline returns a validated Pipeline for the given transformations, followed by the given Policy. It returns
+// nil when there are no transformations and no Policy.
+func NewPipeline(transforms []configv1alpha1.FederationDomainTransform, policy *Policy) (*Pipeline, error) {
+	if len(transforms) == 0 {
+		return policy.Pipeline(), nil
+	}
+
+	p := &Pipeline{policy: policy}
+	for i, transform := range transforms {
+		s, err := newStep(transform)
+		if err != nil {
+			return nil, fmt.Errorf("transform %d of type %q is invalid: %w", i, transform.Type, err)
+		}
+		p.steps = append(p.steps, s)
+	}
+	return p, nil
+}
+
+func newStep(transform configv1alpha1.FederationDomainTransform) (step, error) {
+	switch transform.Type {
+	case configv1alpha1.UsernamePrefixFederationDomainTransformType:
+		if transform.Prefix == "" {
+			return nil, fmt.Errorf("prefix is required")
+		}
+		return func(result *Result) {
+			result.Username = transform.Prefix + result.Username
+		}, nil
+
+	case configv1alpha1.GroupsPrefixFederationDomainTransformType:
+		if transform.Prefix == "" {
+			return nil, fmt.Errorf("prefix is required")
+		}
+		return mapGroups(func(group string) string {
+			return transform.Prefix + group
+		}), nil
+
+	case configv1alpha1.UsernameRegexReplaceFederationDomainTransformType:
+		re, err := compileRegex(transform.Regex)
+		if err != nil {
+			return nil, err
+		}
+		return func(result *Result) {
+			result.Username = re.ReplaceAllString(result.Username, transform.Replacement)
+			if result.Username == "" {
+				result.Rejected, result.RejectedReason = true, "username is empty after transformation"
+			}
+		}, nil
+
+	case configv1alpha1.GroupsRegexReplaceFederationDomainTransformType:
+		re, err := compileRegex(transform.Regex)
+		if err != nil {
+			return nil, err
+		}
+		return mapGroups(func(group string) string {
+			return re.ReplaceAllString(group, transform.Replacement)
+		}), nil
+
+	case configv1alpha1.GroupsAllowFederationDomainTransformType:
+		if len(transform.Groups) == 0 {
+			return nil, fmt.Errorf("groups are required")
+		}
+		groups := toSet(transform.Groups)
+		return filterGroups(func(group string) bool {
+			return groups[group]
+		}), nil
+
+	case configv1alpha1.GroupsDenyFederationDomainTransformType:
+		if len(transform.Groups) == 0 {
+			return nil, fmt.Errorf("groups are required")
+		}
+		groups := toSet(transform.Groups)
+		return filterGroups(func(group string) bool {
+			return !groups[group]
+		}), nil
+
+	case configv1alpha1.RequireGroupFederationDomainTransformType:
+		if len(transform.Groups) == 0 {
+			return nil, fmt.Errorf("groups are required")
+		}
+		groups := toSet(transform.Groups)
+		return func(result *Result) {
+			for _, group := range result.Groups {
+				if groups[group] {
+					return
+				}
+			}
+			result.Rejected, result.RejectedReason = true, "user is not a member of any of the required groups"
+		}, nil
+
+	default:
+		return nil, fmt.Errorf("unsupported type")
+	}
+}
+
+func compileRegex(expr string) (*regexp.Regexp, error) {
+	if expr == "" {
+		return nil, fmt.Errorf("regex is required")
+	}
+	re, err := regexp.Compile(expr)
+	if err != nil {
+		return nil, fmt.Errorf("could not compile regex: %w", err)
+	}
+	return re, nil
+}
+
+// mapGroups returns a step which replaces each group. Groups which become empty are removed.
+func mapGroups(f func(group string) string) step {
+	return filterAndMapGroups(func(group string) (string, bool) {
+		group = f(group)
+		return group, group != ""
+	})
+}
+
+func filterGroups(keep func(group string) bool) step {
+	return filterAndMapGroups(func(group string) (string, bool) {
+		return group, keep(group)
+	})
+}
+
+func filterAndMapGroups(f func(group string) (string, bool)) step {
+	return func(result *Result) {
+		groups := make([]string, 0, len(result.Groups))
+		for _, group := range result.Groups {
+			if group, keep := f(group); keep {
+				groups = append(groups, group)
+			}
+		}
+		result.Groups = groups
+	}
+}
+
+func toSet(items []string) map[string]bool {
+	set := make(map[string]bool, len(items))
+	for _, item := range items {
+		set[item] = true
+	}
+	return set
+}
+
+// NewPolicy returns a validated Policy for the given spec. It returns nil when the spec has no rules.
+func NewPolicy(spec *configv1alpha1.FederationDomainPolicy) (*Policy, error) {
+	if spec == nil || (len(spec.GroupRules) == 0 && len(spec.DenyRules) == 0) {
+		return nil, nil
+	}
+
+	p := &Policy{}
+	for i, rule := range spec.GroupRules {
+		r, err := newGroupRule(rule)
+		if err != nil {
+			return nil, fmt.Errorf("group rule %d is invalid: %w", i, err)
+		}
+		p.groupRules = append(p.groupRules, r)
+	}
+	for i, rule := range spec.DenyRules {
+		m, err := newMatcher(rule.Match)
+		if err != nil {
+			return nil, fmt.Errorf("deny rule %d is invalid: %w", i, err)
+		}
+		if m == nil {
+			// Denying every end user is almost certainly a mistake, so it must not happen by leaving out the match.
+			return nil, fmt.Errorf("deny rule %d is invalid: match must have at least one condition", i)
+		}
+		// The message is shown within a sentence, so it should not end the sentence itself.
+		message := strings.TrimSuffix(rule.Message, ".")
+		if message == "" {
+			message = "denied by the login policy"
+		}
+		p.denyRules = append(p.denyRules, denyRule{match: m, message: message})
+	}
+	return p, nil
+}
+
+func newGroupRule(rule configv1alpha1.FederationDomainGroupRule) (groupRule, error) {
+	if len(rule.Groups) == 0 && rule.FromClaim == "" {
+		return groupRule{}, fmt.Errorf("groups or fromClaim is required")
+	}
+	if rule.Prefix != "" && rule.FromClaim == "" {
+		return groupRule{}, fmt.Errorf("prefix can only be used with fromClaim")
+	}
+	r := groupRule{groups: rule.Groups, fromClaim: rule.FromClaim, prefix: rule.Prefix}
+	if rule.Match != nil {
+		m, err := newMatcher(*rule.Match)
+		if err != nil {
+			return groupRule{}, err
+		}
+		r.match = m
+	}
+	return r, nil
+}
+
+// newMatcher returns a validated matcher for the given spec. It returns nil when the spec has no conditions.
+func newMatcher(spec configv1alpha1.FederationDomainPolicyMatch) (*matcher, error) {
+	m := &matcher{}
+	if spec.UsernameRegex != "" {
+		re, err := regexp.Compile(spec.UsernameRegex)
+		if err != nil {
+			return nil, fmt.Errorf("could not compile usernameRegex: %w", err)
+		}
+		m.usernameRegex = re
+	}
+	if len(spec.InAnyGroup) > 0 {
+		m.inAnyGroup = toSet(spec.InAnyGroup)
+	}
+	if len(spec.NotInAnyGroup) > 0 {
+		m.notInAnyGroup = toSet(spec.NotInAnyGroup)
+	}
+	for i, claim := range spec.Claims {
+		c, err := newClaimMatcher(claim)
+		if err != nil {
+			return nil, fmt.Errorf("claim %d is invalid: %w", i, err)
+		}
+		m.claims = append(m.claims, c)
+	}
+	if m.usernameRegex == nil && m.inAnyGroup == nil && m.notInAnyGroup == nil && len(m.claims) == 0 {
+		return nil, nil
+	}
+	return m, nil
+}
+
+func newClaimMatcher(spec configv1alpha1.FederationDomainClaimMatch) (claimMatcher, error) {
+	if spec.Name == "" {
+		return claimMatcher{}, fmt.Errorf("name is required")
+	}
+	switch {
+	case len(spec.Values) > 0 && spec.Regex != "":
+		return claimMatcher{}, fmt.Errorf("only one of values and regex may be specified")
+	case len(spec.Values) > 0:
+		return claimMatcher{name: spec.Name, values: toSet(spec.Values)}, nil
+	case spec.Regex != "":
+		re, err := regexp.Compile(spec.Regex)
+		if err != nil {
+			return claimMatcher{}, fmt.Errorf("could not compile regex: %w", err)
+		}
+		return claimMatcher{name: spec.Name, regex: re}, nil
+	default:
+		return claimMatcher{}, fmt.Errorf("values or regex is required")
+	}
+}
+
+// Pipeline returns a Pipeline which applies only this Policy. It returns nil for a nil Policy.
+func (p *Policy) Pipeline() *Pipeline {
+	if p == nil {
+		return nil
+	}
+	return &Pipeline{policy: p}
+}
+
+// apply first adds the groups of the group rules, and then applies the deny rules using those groups. A claim which
+// cannot be used by a rule rejects the end user, so that a rule which does not work as intended cannot let anyone in.
+func (p *Policy) apply(result *Result, claims map[string]interface{}) {
+	reject := func(rule string, i int, err error) {
+		plog.WarningErr("could not apply login policy rule", err, "rule", fmt.Sprintf("%s rule %d", rule, i))
+		result.Rejected, result.RejectedReason = true, "the login policy could not be applied"
+	}
+
+	for i, rule := range p.groupRules {
+		if rule.match != nil {
+			matches, err := rule.match.matches(result, claims)
+			if err != nil {
+				reject("group", i, err)
+				return
+			}
+			if !matches {
+				continue
+			}
+		}
+		result.Groups = appendNewGroups(result.Groups, rule.groups)
+		if rule.fromClaim != "" {
+			values, err := claimStrings(claims, rule.fromClaim)
+			if err != nil {
+				reject("group", i, err)
+				return
+			}
+			for _, value := range values {
+				result.Groups = appendNewGroups(result.Groups, []string{rule.prefix + value})
+			}
+		}
+	}
+
+	for i, rule := range p.denyRules {
+		matches, err := rule.match.matches(result, claims)
+		if err != nil {
+			reject("deny", i, err)
+			return
+		}
+		if matches {
+			result.Rejected, result.RejectedReason = true, rule.message
+			return
+		}
+	}
+}
+
+// matches returns whether the end user meets all of the conditions of the matcher.
+func (m *matcher) matches(result *Result, claims map[string]interface{}) (bool, error) {
+	if m.usernameRegex != nil && !m.usernameRegex.MatchString(result.Username) {
+		return false, nil
+	}
+	if m.inAnyGroup != nil && !inAnyGroup(result.Groups, m.inAnyGroup) {
+		return false, nil
+	}
+	if m.notInAnyGroup != nil && inAnyGroup(result.Groups, m.notInAnyGroup) {
+		return false, nil
+	}
+	for _, c := range m.claims {
+		values, err := claimStrings(claims, c.name)
+		if err != nil {
+			return false, err
+		}
+		if !c.matchesAny(values) {
+			return false, nil
+		}
+	}
+	return true, nil
+}
+
+func (c *claimMatcher) matchesAny(values []string) bool {
+	for _, value := range values {
+		if (c.values != nil && c.values[value]) || (c.regex != nil && c.regex.MatchString(value)) {
+			return true
+		}
+	}
+	return false
+}
+
+func inAnyGroup(groups []string, set map[string]bool) bool {
+	for _, group := range groups {
+		if set[group] {
+			return true
+		}
+	}
+	return false
+}
+
+// claimStrings returns the value of the claim as a list of strings. A missing claim is an empty list, and a boolean
+// claim is either "true" or "false". Claims of other types, e.g. numbers, are an error, since they have no single
+// obvious string form.
+func claimStrings(claims map[string]interface{}, name string) ([]string, error) {
+	switch value := claims[name].(type) {
+	case nil:
+		return nil, nil
+	case string:
+		return []string{value}, nil
+	case bool:
+		return []string{strconv.FormatBool(value)}, nil
+	case []string:
+		return value, nil
+	case []interface{}:
+		values := make([]string, 0, len(value))
+		for _, item := range value {
+			s, ok := item.(string)
+			if !ok {
+				return nil, fmt.Errorf("claim %q is a list which contains a %T instead of only strings", name, item)
+			}
+			values = append(values, s)
+		}
+		return values, nil
+	default:
+		return nil, fmt.Errorf("claim %q is a %T instead of a string, a bool or a list of strings", name, value)
+	}
+}
+
+// appendNewGroups returns a new slice with the groups which are not already present appended. It does not modify
+// its arguments, which may be shared with the caller of Evaluate.
+func appendNewGroups(groups []string, newGroups []string) []string {
+	result := append(make([]string, 0, len(groups)+len(newGroups)), groups...)
+	existing := toSet(groups)
+	for _, group := range newGroups {
+		if group != "" && !existing[group] {
+			result = append(result, group)
+			existing[group] = true
+		}
+	}
+	return result
+}
+
+// AttributesAsClaims converts the attributes of the user's upstream LDAP entry to the claims which are used by
+// the Policy.
+func AttributesAsClaims(attributes map[string][]string) map[string]interface{} {
+	claims := make(map[string]interface{}, len(attributes))
+	for name, values := range attributes {
+		claims[name] = values
+	}
+	return claims
+}
+
+// Evaluate applies the transformations and then the Policy to the given identity. The claims are the claims from
+// the upstream ID token, or the attributes of the user's upstream LDAP entry, for use by the Policy. Evaluation
+// stops at the first step which rejects the end user. A nil Pipeline returns the identity unchanged.
+func (p *Pipeline) Evaluate(username string, groups []string, claims map[string]interface{}) *Result {
+	result := &Result{Username: username, Groups: groups}
+	if p == nil {
+		return result
+	}
+	for _, s := range p.steps {
+		s(result)
+		if result.Rejected {
+			return result
+		}
+	}
+	if p.policy != nil {
+		p.policy.apply(result, claims)
+	}
+	return result
+}
@@ -0,0 +1,470 @@
+// Copyright 2021 the Pinniped contributors. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package idtransform
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	configv1alpha1 "go.pinniped.dev/generated/latest/apis/supervisor/config/v1alpha1"
+)
+
+func TestPipeline(t *testing.T) {
+	tests := []struct {
+		name       string
+		transforms []configv1alpha1.FederationDomainTransform
+		username   string
+		groups     []string
+		wantResult *Result
+	}{
+		{
+			name:       "no transforms",
+			username:   "some-user",
+			groups:     []string{"group1", "group2"},
+			wantResult: &Result{Username: "some-user", Groups: []string{"group1", "group2"}},
+		},
+		{
+			name: "prefixes",
+			transforms: []configv1alpha1.FederationDomainTransform{
+				{Type: configv1alpha1.UsernamePrefixFederationDomainTransformType, Prefix: "okta:"},
+				{Type: configv1alpha1.GroupsPrefixFederationDomainTransformType, Prefix: "okta:"},
+			},
+			username:   "some-user",
+			groups:     []string{"group1", "group2"},
+			wantResult: &Result{Username: "okta:some-user", Groups: []string{"okta:group1", "okta:group2"}},
+		},
+		{
+			name: "regex replacements",
+			transforms: []configv1alpha1.FederationDomainTransform{
+				{Type: configv1alpha1.UsernameRegexReplaceFederationDomainTransformType, Regex: `^(.*)@example\.com$`, Replacement: "$1"},
+				{Type: configv1alpha1.GroupsRegexReplaceFederationDomainTransformType, Regex: `^cn=([^,]+),.*$`, Replacement: "$1"},
+				{Type: configv1alpha1.GroupsRegexReplaceFederationDomainTransformType, Regex: `^ignored-.*$`},
+			},
+			username:   "some-user@example.com",
+			groups:     []string{"cn=group1,ou=groups,dc=example,dc=com", "group2", "ignored-group"},
+			wantResult: &Result{Username: "some-user", Groups: []string{"group1", "group2"}},
+		},
+		{
+			name: "regex replacement makes the username empty",
+			transforms: []configv1alpha1.FederationDomainTransform{
+				{Type: configv1alpha1.UsernameRegexReplaceFederationDomainTransformType, Regex: `.*`},
+			},
+			username:   "some-user",
+			wantResult: &Result{Rejected: true, RejectedReason: "username is empty after transformation"},
+		},
+		{
+			name: "allow and deny groups",
+			transforms: []configv1alpha1.FederationDomainTransform{
+				{Type: configv1alpha1.GroupsAllowFederationDomainTransformType, Groups: []string{"group1", "group2", "group3"}},
+				{Type: configv1alpha1.GroupsDenyFederationDomainTransformType, Groups: []string{"group2"}},
+			},
+			username:   "some-user",
+			groups:     []string{"group1", "group2", "group3", "group4"},
+			wantResult: &Result{Username: "some-user", Groups: []string{"group1", "group3"}},
+		},
+		{
+			name: "required group after prefixing the groups",
+			transforms: []configv1alpha1.FederationDomainTransform{
+				{Type: configv1alpha1.GroupsPrefixFederationDomainTransformType, Prefix: "okta:"},
+				{Type: configv1alpha1.RequireGroupFederationDomainTransformType, Groups: []string{"okta:k8s-users"}},
+			},
+			username:   "some-user",
+			groups:     []string{"k8s-users", "other"},
+			wantResult: &Result{Username: "some-user", Groups: []string{"okta:k8s-users", "okta:other"}},
+		},
+		{
+			name: "user not in the required group is rejected and later transforms are skipped",
+			transforms: []configv1alpha1.FederationDomainTransform{
+				{Type: configv1alpha1.RequireGroupFederationDomainTransformType, Groups: []string{"k8s-users"}},
+				{Type: configv1alpha1.UsernamePrefixFederationDomainTransformType, Prefix: "okta:"},
+			},
+			username: "some-user",
+			groups:   []string{"other"},
+			wantResult: &Result{
+				Username:       "some-user",
+				Groups:         []string{"other"},
+				Rejected:       true,
+				RejectedReason: "user is not a member of any of the required groups",
+			},
+		},
+		{
+			name: "user without groups is rejected by the required group",
+			transforms: []configv1alpha1.FederationDomainTransform{
+				{Type: configv1alpha1.RequireGroupFederationDomainTransformType, Groups: []string{"k8s-users"}},
+			},
+			username: "some-user",
+			wantResult: &Result{
+				Username:       "some-user",
+				Rejected:       true,
+				RejectedReason: "user is not a member of any of the required groups",
+			},
+		},
+	}
+	for _, tt := range tests {
+		test := tt
+		t.Run(test.name, func(t *testing.T) {
+			p, err := NewPipeline(test.transforms, nil)
+			require.NoError(t, err)
+			result := p.Evaluate(test.username, test.groups, nil)
+			if test.wantResult.Rejected {
+				require.True(t, result.Rejected)
+				require.Equal(t, test.wantResult.RejectedReason, result.RejectedReason)
+				return
+			}
+			require.Equal(t, test.wantResult, result)
+		})
+	}
+}
+
+func TestNewPipelineErrors(t *testing.T) {
+	tests := []struct {
+		name       string
+		transforms []configv1alpha1.FederationDomainTransform
+		wantErr    string
+	}{
+		{
+			name: "missing prefix",
+			transforms: []configv1alpha1.FederationDomainTransform{
+				{Type: configv1alpha1.UsernamePrefixFederationDomainTransformType, Prefix: "a:"},
+				{Type: configv1alpha1.GroupsPrefixFederationDomainTransformType},
+			},
+			wantErr: `transform 1 of type "GroupsPrefix" is invalid: prefix is required`,
+		},
+		{
+			name: "missing regex",
+			transforms: []configv1alpha1.FederationDomainTransform{
+				{Type: configv1alpha1.UsernameRegexReplaceFederationDomainTransformType},
+			},
+			wantErr: `transform 0 of type "UsernameRegexReplace" is invalid: regex is required`,
+		},
+		{
+			name: "bad regex",
+			transforms: []configv1alpha1.FederationDomainTransform{
+				{Type: configv1alpha1.GroupsRegexReplaceFederationDomainTransformType, Regex: "("},
+			},
+			wantErr: "transform 0 of type \"GroupsRegexReplace\" is invalid: could not compile regex: error parsing regexp: missing closing ): `(`",
+		},
+		{
+			name: "missing groups",
+			transforms: []configv1alpha1.FederationDomainTransform{
+				{Type: configv1alpha1.RequireGroupFederationDomainTransformType},
+			},
+			wantErr: `transform 0 of type "RequireGroup" is invalid: groups are required`,
+		},
+		{
+			name: "unsupported type",
+			transforms: []configv1alpha1.FederationDomainTransform{
+				{Type: "Bogus"},
+			},
+			wantErr: `transform 0 of type "Bogus" is invalid: unsupported type`,
+		},
+	}
+	for _, tt := range tests {
+		test := tt
+		t.Run(test.name, func(t *testing.T) {
+			p, err := NewPipeline(test.transforms, nil)
+			require.EqualError(t, err, test.wantErr)
+			require.Nil(t, p)
+		})
+	}
+}
+
+func TestNilPipeline(t *testing.T) {
+	var p *Pipeline
+	require.Equal(t, &Result{Username: "some-user", Groups: []string{"group1"}}, p.Evaluate("some-user", []string{"group1"}, nil))
+}
+
+func TestPolicy(t *testing.T) {
+	contractorPolicy := &configv1alpha1.FederationDomainPolicy{
+		GroupRules: []configv1alpha1.FederationDomainGroupRule{
+			{FromClaim: "department", Prefix: "dept:"},
+			{
+				Match:  &configv1alpha1.FederationDomainPolicyMatch{UsernameRegex: `@contractor\.com$`},
+				Groups: []string{"contractors"},
+			},
+			{Groups: []string{"everyone"}},
+		},
+		DenyRules: []configv1alpha1.FederationDomainDenyRule{
+			{
+				Match: configv1alpha1.FederationDomainPolicyMatch{
+					InAnyGroup:    []string{"contractors"},
+					NotInAnyGroup: []string{"approved-contractors"},
+				},
+				Message: "Contractors must be approved before they can log in.",
+			},
+			{
+				Match: configv1alpha1.FederationDomainPolicyMatch{
+					Claims: []configv1alpha1.FederationDomainClaimMatch{{Name: "blocked", Values: []string{"true"}}},
+				},
+			},
+		},
+	}
+
+	tests := []struct {
+		name       string
+		transforms []configv1alpha1.FederationDomainTransform
+		policy     *configv1alpha1.FederationDomainPolicy
+		username   string
+		groups     []string
+		claims     map[string]interface{}
+		wantResult *Result
+	}{
+		{
+			name:       "nil policy",
+			username:   "some-user",
+			groups:     []string{"group1"},
+			wantResult: &Result{Username: "some-user", Groups: []string{"group1"}},
+		},
+		{
+			name:       "empty policy",
+			policy:     &configv1alpha1.FederationDomainPolicy{},
+			username:   "some-user",
+			groups:     []string{"group1"},
+			wantResult: &Result{Username: "some-user", Groups: []string{"group1"}},
+		},
+		{
+			name:       "group rules add computed groups without duplicates",
+			policy:     contractorPolicy,
+			username:   "pinny@example.com",
+			groups:     []string{"everyone"},
+			claims:     map[string]interface{}{"department": "sales"},
+			wantResult: &Result{Username: "pinny@example.com", Groups: []string{"everyone", "dept:sales"}},
+		},
+		{
+			name:     "contractor who is not approved is denied with the configured message",
+			policy:   contractorPolicy,
+			username: "pinny@contractor.com",
+			groups:   []string{"developers"},
+			wantResult: &Result{
+				Rejected:       true,
+				RejectedReason: "Contractors must be approved before they can log in",
+			},
+		},
+		{
+			name:     "approved contractor is allowed",
+			policy:   contractorPolicy,
+			username: "pinny@contractor.com",
+			groups:   []string{"approved-contractors"},
+			wantResult: &Result{
+				Username: "pinny@contractor.com",
+				Groups:   []string{"approved-contractors", "contractors", "everyone"},
+			},
+		},
+		{
+			name:     "deny rule without a message uses a generic message",
+			policy:   contractorPolicy,
+			username: "pinny@example.com",
+			claims:   map[string]interface{}{"blocked": true},
+			wantResult: &Result{
+				Rejected:       true,
+				RejectedReason: "denied by the login policy",
+			},
+		},
+		{
+			name: "policy sees the transformed identity",
+			transforms: []configv1alpha1.FederationDomainTransform{
+				{Type: configv1alpha1.UsernameRegexReplaceFederationDomainTransformType, Regex: `@contractor\.com$`, Replacement: "@example.com"},
+			},
+			policy:     contractorPolicy,
+			username:   "pinny@contractor.com",
+			wantResult: &Result{Username: "pinny@example.com", Groups: []string{"everyone"}},
+		},
+		{
+			name: "LDAP attributes may be used as claims",
+			policy: &configv1alpha1.FederationDomainPolicy{
+				DenyRules: []configv1alpha1.FederationDomainDenyRule{{
+					Match: configv1alpha1.FederationDomainPolicyMatch{
+						Claims: []configv1alpha1.FederationDomainClaimMatch{{Name: "dn", Regex: `,ou=contractors,dc=example,dc=com$`}},
+					},
+					Message: "employees only",
+				}},
+			},
+			username: "pinny",
+			claims: AttributesAsClaims(map[string][]string{
+				"dn": {"cn=pinny,ou=contractors,dc=example,dc=com"},
+			}),
+			wantResult: &Result{Rejected: true, RejectedReason: "employees only"},
+		},
+		{
+			name: "match only applies when all of its conditions are met",
+			policy: &configv1alpha1.FederationDomainPolicy{
+				DenyRules: []configv1alpha1.FederationDomainDenyRule{{
+					Match: configv1alpha1.FederationDomainPolicyMatch{
+						UsernameRegex: "^pinny@",
+						InAnyGroup:    []string{"admins"},
+						Claims:        []configv1alpha1.FederationDomainClaimMatch{{Name: "email_verified", Values: []string{"false"}}},
+					},
+				}},
+			},
+			username:   "pinny@example.com",
+			groups:     []string{"admins"},
+			claims:     map[string]interface{}{"email_verified": true},
+			wantResult: &Result{Username: "pinny@example.com", Groups: []string{"admins"}},
+		},
+		{
+			name: "claim which the end user does not have does not match",
+			policy: &configv1alpha1.FederationDomainPolicy{
+				GroupRules: []configv1alpha1.FederationDomainGroupRule{{FromClaim: "department"}},
+				DenyRules: []configv1alpha1.FederationDomainDenyRule{{
+					Match: configv1alpha1.FederationDomainPolicyMatch{
+						Claims: []configv1alpha1.FederationDomainClaimMatch{{Name: "email", Regex: `@contractor\.com$`}},
+					},
+				}},
+			},
+			username:   "pinny",
+			claims:     map[string]interface{}{},
+			wantResult: &Result{Username: "pinny", Groups: []string{}},
+		},
+		{
+			name: "list claims match when any of their strings match",
+			policy: &configv1alpha1.FederationDomainPolicy{
+				DenyRules: []configv1alpha1.FederationDomainDenyRule{{
+					Match: configv1alpha1.FederationDomainPolicyMatch{
+						Claims: []configv1alpha1.FederationDomainClaimMatch{{Name: "roles", Values: []string{"suspended"}}},
+					},
+					Message: "the account is suspended",
+				}},
+			},
+			username:   "pinny",
+			claims:     map[string]interface{}{"roles": []interface{}{"developer", "suspended"}},
+			wantResult: &Result{Rejected: true, RejectedReason: "the account is suspended"},
+		},
+		{
+			name: "deny rule using a claim of an unsupported type rejects the login",
+			policy: &configv1alpha1.FederationDomainPolicy{
+				DenyRules: []configv1alpha1.FederationDomainDenyRule{{
+					Match: configv1alpha1.FederationDomainPolicyMatch{
+						Claims: []configv1alpha1.FederationDomainClaimMatch{{Name: "age", Values: []string{"42"}}},
+					},
+				}},
+			},
+			username:   "pinny",
+			claims:     map[string]interface{}{"age": float64(42)},
+			wantResult: &Result{Rejected: true, RejectedReason: "the login policy could not be applied"},
+		},
+		{
+			name: "group rule using a list claim which does not only contain strings rejects the login",
+			policy: &configv1alpha1.FederationDomainPolicy{
+				GroupRules: []configv1alpha1.FederationDomainGroupRule{{FromClaim: "department"}},
+			},
+			username:   "pinny",
+			claims:     map[string]interface{}{"department": []interface{}{"sales", float64(1)}},
+			wantResult: &Result{Rejected: true, RejectedReason: "the login policy could not be applied"},
+		},
+	}
+	for _, tt := range tests {
+		test := tt
+		t.Run(test.name, func(t *testing.T) {
+			policy, err := NewPolicy(test.policy)
+			require.NoError(t, err)
+			p, err := NewPipeline(test.transforms, policy)
+			require.NoError(t, err)
+			result := p.Evaluate(test.username, test.groups, test.claims)
+			if test.wantResult.Rejected {
+				require.True(t, result.Rejected)
+				require.Equal(t, test.wantResult.RejectedReason, result.RejectedReason)
+				return
+			}
+			require.Equal(t, test.wantResult, result)
+		})
+	}
+}
+
+func TestPolicyDoesNotModifyTheGivenGroups(t *testing.T) {
+	policy, err := NewPolicy(&configv1alpha1.FederationDomainPolicy{
+		GroupRules: []configv1alpha1.FederationDomainGroupRule{{Groups: []string{"added"}}},
+	})
+	require.NoError(t, err)
+	groups := make([]string, 1, 10)
+	groups[0] = "original"
+	result := policy.Pipeline().Evaluate("some-user", groups, nil)
+	require.Equal(t, []string{"original", "added"}, result.Groups)
+	// The given groups have spare capacity in their backing array, which must not be written to.
+	require.Equal(t, []string{"original", ""}, groups[:2])
+}
+
+func TestNewPolicyErrors(t *testing.T) {
+	tests := []struct {
+		name    string
+		policy  *configv1alpha1.FederationDomainPolicy
+		wantErr string
+	}{
+		{
+			name: "group rule without groups",
+			policy: &configv1alpha1.FederationDomainPolicy{
+				GroupRules: []configv1alpha1.FederationDomainGroupRule{{Groups: []string{"a"}}, {Prefix: "b"}},
+			},
+			wantErr: "group rule 1 is invalid: groups or fromClaim is required",
+		},
+		{
+			name: "group rule with a prefix but without fromClaim",
+			policy: &configv1alpha1.FederationDomainPolicy{
+				GroupRules: []configv1alpha1.FederationDomainGroupRule{{Groups: []string{"a"}, Prefix: "b"}},
+			},
+			wantErr: "group rule 0 is invalid: prefix can only be used with fromClaim",
+		},
+		{
+			name: "group rule with an invalid usernameRegex",
+			policy: &configv1alpha1.FederationDomainPolicy{
+				GroupRules: []configv1alpha1.FederationDomainGroupRule{{
+					Match:  &configv1alpha1.FederationDomainPolicyMatch{UsernameRegex: "("},
+					Groups: []string{"a"},
+				}},
+			},
+			wantErr: "group rule 0 is invalid: could not compile usernameRegex: error parsing regexp: missing closing ): `(`",
+		},
+		{
+			name: "deny rule without conditions",
+			policy: &configv1alpha1.FederationDomainPolicy{
+				DenyRules: []configv1alpha1.FederationDomainDenyRule{{Message: "nobody may log in"}},
+			},
+			wantErr: "deny rule 0 is invalid: match must have at least one condition",
+		},
+		{
+			name: "claim without a name",
+			policy: &configv1alpha1.FederationDomainPolicy{
+				DenyRules: []configv1alpha1.FederationDomainDenyRule{{Match: configv1alpha1.FederationDomainPolicyMatch{
+					Claims: []configv1alpha1.FederationDomainClaimMatch{{Values: []string{"a"}}},
+				}}},
+			},
+			wantErr: "deny rule 0 is invalid: claim 0 is invalid: name is required",
+		},
+		{
+			name: "claim without values or regex",
+			policy: &configv1alpha1.FederationDomainPolicy{
+				DenyRules: []configv1alpha1.FederationDomainDenyRule{{Match: configv1alpha1.FederationDomainPolicyMatch{
+					Claims: []configv1alpha1.FederationDomainClaimMatch{{Name: "email"}},
+				}}},
+			},
+			wantErr: "deny rule 0 is invalid: claim 0 is invalid: values or regex is required",
+		},
+		{
+			name: "claim with both values and regex",
+			policy: &configv1alpha1.FederationDomainPolicy{
+				DenyRules: []configv1alpha1.FederationDomainDenyRule{{Match: configv1alpha1.FederationDomainPolicyMatch{
+					Claims: []configv1alpha1.FederationDomainClaimMatch{{Name: "email", Values: []string{"a"}, Regex: "a"}},
+				}}},
+			},
+			wantErr: "deny rule 0 is invalid: claim 0 is invalid: only one of values and regex may be specified",
+		},
+		{
+			name: "claim with an invalid regex",
+			policy: &configv1alpha1.FederationDomainPolicy{
+				DenyRules: []configv1alpha1.FederationDomainDenyRule{{Match: configv1alpha1.FederationDomainPolicyMatch{
+					Claims: []configv1alpha1.FederationDomainClaimMatch{{Name: "email", Regex: "["}},
+				}}},
+			},
+			wantErr: "deny rule 0 is invalid: claim 0 is invalid: could not compile regex: error parsing regexp: missing closing ]: `[`",
+		},
+	}
+	for _, tt := range tests {
+		test := tt
+		t.Run(test.name, func(t *testing.T) {
+			p, err := NewPolicy(test.policy)
+			require.EqualError(t, err, test.wantErr)
+			require.Nil(t, p)
+		})
+	}
+}
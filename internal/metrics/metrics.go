@@ -0,0 +1,208 @@
+// Copyright 2021 the Pinniped contributors. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+// Package metrics defines the Prometheus metrics of the Supervisor and the Concierge, and serves them.
+package metrics
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"time"
+
+	"k8s.io/component-base/metrics"
+	"k8s.io/component-base/metrics/legacyregistry"
+	_ "k8s.io/component-base/metrics/prometheus/workqueue" // register the depth, latency, etc. of each controller's queue
+
+	"go.pinniped.dev/internal/plog"
+)
+
+const (
+	namespace = "pinniped"
+
+	// ResultSuccess is the result label of an event which ended the way the caller wanted, e.g. a successful login.
+	ResultSuccess = "success"
+
+	// ResultFailure is the result label of an event which was rejected, e.g. a login with a wrong password.
+	ResultFailure = "failure"
+
+	// ResultError is the result label of an event which could not be completed because something went wrong,
+	// e.g. an upstream identity provider could not be reached.
+	ResultError = "error"
+)
+
+var (
+	upstreamLogins = metrics.NewCounterVec(
+		&metrics.CounterOpts{
+			Namespace:      namespace,
+			Subsystem:      "supervisor",
+			Name:           "upstream_logins_total",
+			Help:           "Number of end user logins using an upstream identity provider, by identity provider and result.",
+			StabilityLevel: metrics.ALPHA,
+		},
+		[]string{"idp_type", "idp_name", "result"},
+	)
+
+	ldapOperationDuration = metrics.NewHistogramVec(
+		&metrics.HistogramOpts{
+			Namespace:      namespace,
+			Subsystem:      "supervisor",
+			Name:           "ldap_operation_duration_seconds",
+			Help:           "Latency of the bind and search operations made against upstream LDAP servers.",
+			Buckets:        []float64{0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10},
+			StabilityLevel: metrics.ALPHA,
+		},
+		[]string{"idp_name", "operation", "result"},
+	)
+
+	ldapGroupSearchCacheLookups = metrics.NewCounterVec(
+		&metrics.CounterOpts{
+			Namespace:      namespace,
+			Subsystem:      "supervisor",
+			Name:           "ldap_group_search_cache_lookups_total",
+			Help:           "Number of lookups in the group search caches of upstream LDAP identity providers, by identity provider and result.",
+			StabilityLevel: metrics.ALPHA,
+		},
+		[]string{"idp_name", "result"},
+	)
+
+	tokenGrants = metrics.NewCounterVec(
+		&metrics.CounterOpts{
+			Namespace:      namespace,
+			Subsystem:      "supervisor",
+			Name:           "token_grants_total",
+			Help:           "Number of requests to the token endpoint, by grant type and result.",
+			StabilityLevel: metrics.ALPHA,
+		},
+		[]string{"grant_type", "result"},
+	)
+
+	tokenCredentialRequests = metrics.NewCounterVec(
+		&metrics.CounterOpts{
+			Namespace:      namespace,
+			Subsystem:      "concierge",
+			Name:           "token_credential_requests_total",
+			Help:           "Number of TokenCredentialRequests, by authenticator and result.",
+			StabilityLevel: metrics.ALPHA,
+		},
+		[]string{"authenticator_type", "authenticator_name", "result"},
+	)
+
+	impersonationProxyRequestDuration = metrics.NewHistogramVec(
+		&metrics.HistogramOpts{
+			Namespace:      namespace,
+			Subsystem:      "concierge",
+			Name:           "impersonation_proxy_request_duration_seconds",
+			Help:           "Latency of the requests served by the impersonation proxy, by Kubernetes API verb.",
+			Buckets:        metrics.DefBuckets,
+			StabilityLevel: metrics.ALPHA,
+		},
+		[]string{"verb"},
+	)
+
+	controllerSyncErrors = metrics.NewCounterVec(
+		&metrics.CounterOpts{
+			Namespace:      namespace,
+			Name:           "controller_sync_errors_total",
+			Help:           "Number of times that a controller's sync function returned an error, by controller.",
+			StabilityLevel: metrics.ALPHA,
+		},
+		[]string{"controller"},
+	)
+)
+
+func init() {
+	legacyregistry.MustRegister(
+		upstreamLogins,
+		ldapOperationDuration,
+		ldapGroupSearchCacheLookups,
+		tokenGrants,
+		tokenCredentialRequests,
+		impersonationProxyRequestDuration,
+		controllerSyncErrors,
+	)
+}
+
+// ResultFor returns ResultError when err is not nil, and otherwise ResultSuccess.
+func ResultFor(err error) string {
+	if err != nil {
+		return ResultError
+	}
+	return ResultSuccess
+}
+
+// RecordUpstreamLogin counts an end user's attempt to log in using an upstream identity provider.
+func RecordUpstreamLogin(idpType, idpName, result string) {
+	upstreamLogins.WithLabelValues(idpType, idpName, result).Inc()
+}
+
+// ObserveLDAPOperation records the latency of a bind or search operation against an upstream LDAP server.
+func ObserveLDAPOperation(idpName, operation, result string, start time.Time) {
+	ldapOperationDuration.WithLabelValues(idpName, operation, result).Observe(time.Since(start).Seconds())
+}
+
+// RecordLDAPGroupSearchCacheLookup counts a lookup of a user's groups in the group search cache of an upstream
+// LDAP identity provider, which either found the groups (a hit) or did not (a miss).
+func RecordLDAPGroupSearchCacheLookup(idpName string, hit bool) {
+	result := "miss"
+	if hit {
+		result = "hit"
+	}
+	ldapGroupSearchCacheLookups.WithLabelValues(idpName, result).Inc()
+}
+
+// RecordTokenGrant counts a request to the token endpoint. Callers should only pass grant types which
+// the token endpoint supports, to keep the number of label values bounded.
+func RecordTokenGrant(grantType, result string) {
+	tokenGrants.WithLabelValues(grantType, result).Inc()
+}
+
+// RecordTokenCredentialRequest counts a TokenCredentialRequest which was handled by the given authenticator.
+func RecordTokenCredentialRequest(authenticatorType, authenticatorName, result string) {
+	tokenCredentialRequests.WithLabelValues(authenticatorType, authenticatorName, result).Inc()
+}
+
+// ObserveImpersonationProxyRequest records the latency of a request served by the impersonation proxy.
+// It is meant to be deferred, e.g. defer metrics.ObserveImpersonationProxyRequest(verb, time.Now()).
+func ObserveImpersonationProxyRequest(verb string, start time.Time) {
+	impersonationProxyRequestDuration.WithLabelValues(verb).Observe(time.Since(start).Seconds())
+}
+
+// RecordControllerSyncError counts a failed sync of the named controller.
+func RecordControllerSyncError(controllerName string) {
+	controllerSyncErrors.WithLabelValues(controllerName).Inc()
+}
+
+// NewHandler returns a handler which serves the metrics at /metrics, and a 404 for all other paths.
+func NewHandler() http.Handler {
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", legacyregistry.Handler())
+	return mux
+}
+
+// Serve serves the metrics over plain HTTP on listenAddress until ctx is cancelled.
+// It returns the listener so callers can find out which address it is listening on.
+func Serve(ctx context.Context, listenAddress string) (net.Listener, error) {
+	l, err := net.Listen("tcp", listenAddress)
+	if err != nil {
+		return nil, fmt.Errorf("cannot create metrics listener: %w", err)
+	}
+
+	server := http.Server{Handler: NewHandler()}
+
+	go func() {
+		if err := server.Serve(l); err != nil && err != http.ErrServerClosed {
+			plog.Error("metrics server exited", err)
+		}
+	}()
+
+	go func() {
+		<-ctx.Done()
+		if err := server.Shutdown(context.Background()); err != nil {
+			plog.Debug("metrics server shutdown failed", "err", err)
+		}
+	}()
+
+	return l, nil
+}
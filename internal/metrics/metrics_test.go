@@ -0,0 +1,92 @@
+// Copyright 2021 the Pinniped contributors. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package metrics
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"k8s.io/component-base/metrics/legacyregistry"
+	"k8s.io/component-base/metrics/testutil"
+)
+
+func TestRecordCounters(t *testing.T) {
+	RecordUpstreamLogin("ldap", "some-ldap-idp", ResultSuccess)
+	RecordUpstreamLogin("ldap", "some-ldap-idp", ResultSuccess)
+	RecordUpstreamLogin("oidc", "some-oidc-idp", ResultFailure)
+	RecordTokenGrant("refresh_token", ResultError)
+	RecordTokenCredentialRequest("WebhookAuthenticator", "some-webhook", ResultSuccess)
+	RecordControllerSyncError("some-controller")
+
+	err := testutil.GatherAndCompare(legacyregistry.DefaultGatherer, strings.NewReader(`
+		# HELP pinniped_supervisor_upstream_logins_total [ALPHA] Number of end user logins using an upstream identity provider, by identity provider and result.
+		# TYPE pinniped_supervisor_upstream_logins_total counter
+		pinniped_supervisor_upstream_logins_total{idp_name="some-ldap-idp",idp_type="ldap",result="success"} 2
+		pinniped_supervisor_upstream_logins_total{idp_name="some-oidc-idp",idp_type="oidc",result="failure"} 1
+		# HELP pinniped_supervisor_token_grants_total [ALPHA] Number of requests to the token endpoint, by grant type and result.
+		# TYPE pinniped_supervisor_token_grants_total counter
+		pinniped_supervisor_token_grants_total{grant_type="refresh_token",result="error"} 1
+		# HELP pinniped_concierge_token_credential_requests_total [ALPHA] Number of TokenCredentialRequests, by authenticator and result.
+		# TYPE pinniped_concierge_token_credential_requests_total counter
+		pinniped_concierge_token_credential_requests_total{authenticator_name="some-webhook",authenticator_type="WebhookAuthenticator",result="success"} 1
+		# HELP pinniped_controller_sync_errors_total [ALPHA] Number of times that a controller's sync function returned an error, by controller.
+		# TYPE pinniped_controller_sync_errors_total counter
+		pinniped_controller_sync_errors_total{controller="some-controller"} 1
+	`),
+		"pinniped_supervisor_upstream_logins_total",
+		"pinniped_supervisor_token_grants_total",
+		"pinniped_concierge_token_credential_requests_total",
+		"pinniped_controller_sync_errors_total",
+	)
+	require.NoError(t, err)
+}
+
+func TestResultFor(t *testing.T) {
+	require.Equal(t, ResultSuccess, ResultFor(nil))
+	require.Equal(t, ResultError, ResultFor(errors.New("some error")))
+}
+
+func TestHandler(t *testing.T) {
+	ObserveLDAPOperation("some-ldap-idp", "bind", ResultSuccess, time.Now())
+	ObserveImpersonationProxyRequest("list", time.Now())
+
+	handler := NewHandler()
+
+	rsp := httptest.NewRecorder()
+	handler.ServeHTTP(rsp, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	require.Equal(t, http.StatusOK, rsp.Code)
+	require.Contains(t, rsp.Body.String(), `pinniped_supervisor_ldap_operation_duration_seconds_count{idp_name="some-ldap-idp",operation="bind",result="success"} 1`)
+	require.Contains(t, rsp.Body.String(), `pinniped_concierge_impersonation_proxy_request_duration_seconds_count{verb="list"} 1`)
+
+	rsp = httptest.NewRecorder()
+	handler.ServeHTTP(rsp, httptest.NewRequest(http.MethodGet, "/some-other-path", nil))
+	require.Equal(t, http.StatusNotFound, rsp.Code)
+}
+
+func TestServe(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	l, err := Serve(ctx, "127.0.0.1:0")
+	require.NoError(t, err)
+
+	rsp, err := http.Get("http://" + l.Addr().String() + "/metrics") //nolint:noctx // this is just a test
+	require.NoError(t, err)
+	defer func() { _ = rsp.Body.Close() }()
+	require.Equal(t, http.StatusOK, rsp.StatusCode)
+	body, err := ioutil.ReadAll(rsp.Body)
+	require.NoError(t, err)
+	require.Contains(t, string(body), "# TYPE")
+
+	_, err = Serve(ctx, l.Addr().String())
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "cannot create metrics listener")
+}
@@ -5,8 +5,8 @@
 package auth
 
 import (
-	"fmt"
 	"net/http"
+	"net/url"
 	"time"
 
 	coreosoidc "github.com/coreos/go-oidc/v3/oidc"
@@ -15,15 +15,18 @@ import (
 	"github.com/ory/fosite/token/jwt"
 	"github.com/pkg/errors"
 	"golang.org/x/oauth2"
-	"k8s.io/apiserver/pkg/authentication/authenticator"
 
+	"go.pinniped.dev/internal/auditlog"
 	"go.pinniped.dev/internal/httputil/httperr"
 	"go.pinniped.dev/internal/httputil/securityheader"
+	"go.pinniped.dev/internal/idtransform"
+	"go.pinniped.dev/internal/metrics"
 	"go.pinniped.dev/internal/oidc"
 	"go.pinniped.dev/internal/oidc/csrftoken"
 	"go.pinniped.dev/internal/oidc/downstreamsession"
 	"go.pinniped.dev/internal/oidc/provider"
 	"go.pinniped.dev/internal/plog"
+	"go.pinniped.dev/internal/psession"
 	"go.pinniped.dev/pkg/oidcclient/nonce"
 	"go.pinniped.dev/pkg/oidcclient/pkce"
 )
@@ -36,6 +39,7 @@ const (
 func NewHandler(
 	downstreamIssuer string,
 	idpLister oidc.UpstreamIdentityProvidersLister,
+	identityTransforms oidc.IdentityTransformsGetter,
 	oauthHelperWithoutStorage fosite.OAuth2Provider,
 	oauthHelperWithStorage fosite.OAuth2Provider,
 	generateCSRF func() (csrftoken.CSRFToken, error),
@@ -52,7 +56,7 @@ func NewHandler(
 			return httperr.Newf(http.StatusMethodNotAllowed, "%s (try GET or POST)", r.Method)
 		}
 
-		oidcUpstream, ldapUpstream, err := chooseUpstreamIDP(idpLister)
+		oidcUpstream, ldapUpstream, idpType, err := ChooseUpstreamIDP(r, idpLister)
 		if err != nil {
 			plog.WarningErr("authorize upstream config", err)
 			return err
@@ -68,9 +72,24 @@ func NewHandler(
 				cookieCodec,
 			)
 		}
+		if !sentCustomCredentialsHeaders(r) {
+			// Only the Pinniped CLI sends the username and password on custom headers. Other clients are
+			// web browsers, so send the end user to the login page instead.
+			return handleAuthRequestForLDAPUpstreamBrowserFlow(r, w,
+				oauthHelperWithoutStorage,
+				generateCSRF,
+				ldapUpstream,
+				idpType,
+				downstreamIssuer,
+				upstreamStateEncoder,
+				cookieCodec,
+			)
+		}
 		return handleAuthRequestForLDAPUpstream(r, w,
 			oauthHelperWithStorage,
 			ldapUpstream,
+			idpType,
+			identityTransforms,
 		)
 	}))
 }
@@ -80,6 +99,8 @@ func handleAuthRequestForLDAPUpstream(
 	w http.ResponseWriter,
 	oauthHelper fosite.OAuth2Provider,
 	ldapUpstream provider.UpstreamLDAPIdentityProviderI,
+	idpType string,
+	identityTransforms oidc.IdentityTransformsGetter,
 ) error {
 	authorizeRequester, created := newAuthorizeRequest(r, w, oauthHelper)
 	if !created {
@@ -92,6 +113,15 @@ func handleAuthRequestForLDAPUpstream(
 		// Return an error according to OIDC spec 3.1.2.6 (second paragraph).
 		err := errors.WithStack(fosite.ErrAccessDenied.WithHintf("Missing or blank username or password."))
 		plog.Info("authorize response error", oidc.FositeErrorForLog(err)...)
+		auditlog.Record(r, auditlog.Event{
+			Type:            auditlog.EventTypeFailedCredentials,
+			Result:          auditlog.ResultFailure,
+			Reason:          "missing or blank username or password",
+			ClientID:        authorizeRequester.GetClient().GetID(),
+			UpstreamIDPName: ldapUpstream.GetName(),
+			UpstreamIDPType: idpType,
+			Username:        username,
+		})
 		oauthHelper.WriteAuthorizeError(w, authorizeRequester, err)
 		return nil
 	}
@@ -99,10 +129,30 @@ func handleAuthRequestForLDAPUpstream(
 	authenticateResponse, authenticated, err := ldapUpstream.AuthenticateUser(r.Context(), username, password)
 	if err != nil {
 		plog.WarningErr("unexpected error during upstream LDAP authentication", err, "upstreamName", ldapUpstream.GetName())
+		metrics.RecordUpstreamLogin(idpType, ldapUpstream.GetName(), metrics.ResultError)
+		auditlog.Record(r, auditlog.Event{
+			Type:            auditlog.EventTypeAuthorize,
+			Result:          auditlog.ResultError,
+			Reason:          "unexpected error during upstream authentication",
+			ClientID:        authorizeRequester.GetClient().GetID(),
+			UpstreamIDPName: ldapUpstream.GetName(),
+			UpstreamIDPType: idpType,
+			Username:        username,
+		})
 		return httperr.New(http.StatusBadGateway, "unexpected error during upstream authentication")
 	}
 	if !authenticated {
 		plog.Debug("failed upstream LDAP authentication", "upstreamName", ldapUpstream.GetName())
+		metrics.RecordUpstreamLogin(idpType, ldapUpstream.GetName(), metrics.ResultFailure)
+		auditlog.Record(r, auditlog.Event{
+			Type:            auditlog.EventTypeFailedCredentials,
+			Result:          auditlog.ResultFailure,
+			Reason:          "username/password not accepted by upstream",
+			ClientID:        authorizeRequester.GetClient().GetID(),
+			UpstreamIDPName: ldapUpstream.GetName(),
+			UpstreamIDPType: idpType,
+			Username:        username,
+		})
 		// Return an error according to OIDC spec 3.1.2.6 (second paragraph).
 		err = errors.WithStack(fosite.ErrAccessDenied.WithHintf("Username/password not accepted by LDAP provider."))
 		plog.Info("authorize response error", oidc.FositeErrorForLog(err)...)
@@ -110,10 +160,47 @@ func handleAuthRequestForLDAPUpstream(
 		return nil
 	}
 
-	openIDSession := downstreamsession.MakeDownstreamSession(
-		downstreamSubjectFromUpstreamLDAP(ldapUpstream, authenticateResponse),
+	identity := identityTransforms.IdentityTransforms(idpType, ldapUpstream.GetName()).Evaluate(
 		authenticateResponse.User.GetName(),
 		authenticateResponse.User.GetGroups(),
+		idtransform.AttributesAsClaims(authenticateResponse.Attributes),
+	)
+	if identity.Rejected {
+		plog.Info("login rejected by identity transforms",
+			"upstreamName", ldapUpstream.GetName(), "reason", identity.RejectedReason)
+		metrics.RecordUpstreamLogin(idpType, ldapUpstream.GetName(), metrics.ResultFailure)
+		auditlog.Record(r, auditlog.Event{
+			Type:            auditlog.EventTypeAuthorize,
+			Result:          auditlog.ResultFailure,
+			Reason:          identity.RejectedReason,
+			ClientID:        authorizeRequester.GetClient().GetID(),
+			UpstreamIDPName: ldapUpstream.GetName(),
+			UpstreamIDPType: idpType,
+			Username:        username,
+		})
+		// Return an error according to OIDC spec 3.1.2.6 (second paragraph).
+		err = errors.WithStack(fosite.ErrAccessDenied.WithHintf("Login was rejected: %s.", identity.RejectedReason))
+		plog.Info("authorize response error", oidc.FositeErrorForLog(err)...)
+		oauthHelper.WriteAuthorizeError(w, authorizeRequester, err)
+		return nil
+	}
+
+	metrics.RecordUpstreamLogin(idpType, ldapUpstream.GetName(), metrics.ResultSuccess)
+
+	customSessionData := &psession.CustomSessionData{
+		ProviderName: ldapUpstream.GetName(),
+		ProviderType: idpType,
+		LDAP: &psession.LDAPSessionData{
+			UserDN: authenticateResponse.DN,
+		},
+	}
+	openIDSession := downstreamsession.MakeDownstreamSession(
+		authorizeRequester.GetID(),
+		downstreamsession.DownstreamSubjectFromUpstreamLDAP(ldapUpstream, authenticateResponse.User.GetUID()),
+		identity.Username,
+		identity.Groups,
+		authenticateResponse.AdditionalClaims,
+		customSessionData,
 	)
 
 	authorizeResponder, err := oauthHelper.NewAuthorizeResponse(r.Context(), authorizeRequester, openIDSession)
@@ -123,11 +210,99 @@ func handleAuthRequestForLDAPUpstream(
 		return nil
 	}
 
+	auditlog.Record(r, auditlog.Event{
+		Type:            auditlog.EventTypeAuthorize,
+		Result:          auditlog.ResultSuccess,
+		ClientID:        authorizeRequester.GetClient().GetID(),
+		UpstreamIDPName: ldapUpstream.GetName(),
+		UpstreamIDPType: idpType,
+		Subject:         openIDSession.IDTokenClaims().Subject,
+		Username:        identity.Username,
+		Groups:          identity.Groups,
+	})
+
 	oauthHelper.WriteAuthorizeResponse(w, authorizeRequester, authorizeResponder)
 
 	return nil
 }
 
+// sentCustomCredentialsHeaders returns true when the request has either of the custom username and password headers,
+// even when their values are blank.
+func sentCustomCredentialsHeaders(r *http.Request) bool {
+	_, sentUsername := r.Header[http.CanonicalHeaderKey(CustomUsernameHeaderName)]
+	_, sentPassword := r.Header[http.CanonicalHeaderKey(CustomPasswordHeaderName)]
+	return sentUsername || sentPassword
+}
+
+// handleAuthRequestForLDAPUpstreamBrowserFlow validates the authorization request and redirects the end user to the
+// login page, where they enter their username and password. The login page finishes the authorization request.
+func handleAuthRequestForLDAPUpstreamBrowserFlow(
+	r *http.Request,
+	w http.ResponseWriter,
+	oauthHelper fosite.OAuth2Provider,
+	generateCSRF func() (csrftoken.CSRFToken, error),
+	ldapUpstream provider.UpstreamLDAPIdentityProviderI,
+	idpType string,
+	downstreamIssuer string,
+	upstreamStateEncoder oidc.Encoder,
+	cookieCodec oidc.Codec,
+) error {
+	authorizeRequester, created := newAuthorizeRequest(r, w, oauthHelper)
+	if !created {
+		return nil
+	}
+
+	if !validateAuthorizeRequest(r, w, oauthHelper, authorizeRequester) {
+		return nil
+	}
+
+	// An invalid CSRF cookie is treated like a missing one, so that a new cookie is written.
+	csrfValue, _ := oidc.ReadCSRFCookie(r, cookieCodec)
+	if csrfValue == "" {
+		var err error
+		csrfValue, err = generateCSRF()
+		if err != nil {
+			plog.Error("authorize generate error", err)
+			return httperr.Wrap(http.StatusInternalServerError, "error generating CSRF token", err)
+		}
+		// We did not receive an incoming CSRF cookie, so write a new one.
+		if err := oidc.AddCSRFSetCookieHeader(w, csrfValue, cookieCodec); err != nil {
+			plog.Error("error setting CSRF cookie", err)
+			return err
+		}
+	}
+
+	// The login page does not redirect to the upstream, so the state does not need a nonce or PKCE code.
+	encodedStateParamValue, err := upstreamStateParam(
+		authorizeRequester,
+		ldapUpstream.GetName(),
+		idpType,
+		"",
+		csrfValue,
+		"",
+		upstreamStateEncoder,
+	)
+	if err != nil {
+		plog.Error("authorize upstream state param error", err)
+		return err
+	}
+
+	auditlog.Record(r, auditlog.Event{
+		Type:            auditlog.EventTypeAuthorize,
+		Result:          auditlog.ResultSuccess,
+		ClientID:        authorizeRequester.GetClient().GetID(),
+		UpstreamIDPName: ldapUpstream.GetName(),
+		UpstreamIDPType: idpType,
+	})
+
+	http.Redirect(w, r,
+		downstreamIssuer+oidc.LoginEndpointPath+"?"+url.Values{"state": []string{encodedStateParamValue}}.Encode(),
+		302,
+	)
+
+	return nil
+}
+
 func handleAuthRequestForOIDCUpstream(
 	r *http.Request,
 	w http.ResponseWriter,
@@ -145,18 +320,7 @@ func handleAuthRequestForOIDCUpstream(
 		return nil
 	}
 
-	now := time.Now()
-	_, err := oauthHelper.NewAuthorizeResponse(r.Context(), authorizeRequester, &openid.DefaultSession{
-		Claims: &jwt.IDTokenClaims{
-			// Temporary claim values to allow `NewAuthorizeResponse` to perform other OIDC validations.
-			Subject:     "none",
-			AuthTime:    now,
-			RequestedAt: now,
-		},
-	})
-	if err != nil {
-		plog.Info("authorize response error", oidc.FositeErrorForLog(err)...)
-		oauthHelper.WriteAuthorizeError(w, authorizeRequester, err)
+	if !validateAuthorizeRequest(r, w, oauthHelper, authorizeRequester) {
 		return nil
 	}
 
@@ -165,23 +329,18 @@ func handleAuthRequestForOIDCUpstream(
 		plog.Error("authorize generate error", err)
 		return err
 	}
-	csrfFromCookie := readCSRFCookie(r, cookieCodec)
+	// We can ignore any errors and just make a new cookie. Hopefully this will
+	// make the user experience better if, for example, the server rotated
+	// cookie signing keys and then a user submitted a very old cookie.
+	csrfFromCookie, _ := oidc.ReadCSRFCookie(r, cookieCodec)
 	if csrfFromCookie != "" {
 		csrfValue = csrfFromCookie
 	}
 
-	upstreamOAuthConfig := oauth2.Config{
-		ClientID: oidcUpstream.GetClientID(),
-		Endpoint: oauth2.Endpoint{
-			AuthURL: oidcUpstream.GetAuthorizationURL().String(),
-		},
-		RedirectURL: fmt.Sprintf("%s/callback", downstreamIssuer),
-		Scopes:      oidcUpstream.GetScopes(),
-	}
-
 	encodedStateParamValue, err := upstreamStateParam(
 		authorizeRequester,
 		oidcUpstream.GetName(),
+		oidc.IDPTypeOIDC,
 		nonceValue,
 		csrfValue,
 		pkceValue,
@@ -194,28 +353,36 @@ func handleAuthRequestForOIDCUpstream(
 
 	if csrfFromCookie == "" {
 		// We did not receive an incoming CSRF cookie, so write a new one.
-		err := addCSRFSetCookieHeader(w, csrfValue, cookieCodec)
+		err := oidc.AddCSRFSetCookieHeader(w, csrfValue, cookieCodec)
 		if err != nil {
 			plog.Error("error setting CSRF cookie", err)
 			return err
 		}
 	}
 
-	authCodeOptions := []oauth2.AuthCodeOption{
-		oauth2.AccessTypeOffline,
-		nonceValue.Param(),
-		pkceValue.Challenge(),
-		pkceValue.Method(),
-	}
+	var authCodeOptions []oauth2.AuthCodeOption
 
+	// A prompt param from the downstream client takes precedence over a configured one, because it is appended last.
 	promptParam := r.Form.Get("prompt")
 	if promptParam != "" && oidc.ScopeWasRequested(authorizeRequester, coreosoidc.ScopeOpenID) {
 		authCodeOptions = append(authCodeOptions, oauth2.SetAuthURLParam("prompt", promptParam))
 	}
 
+	auditlog.Record(r, auditlog.Event{
+		Type:            auditlog.EventTypeAuthorize,
+		Result:          auditlog.ResultSuccess,
+		ClientID:        authorizeRequester.GetClient().GetID(),
+		UpstreamIDPName: oidcUpstream.GetName(),
+		UpstreamIDPType: oidc.IDPTypeOIDC,
+	})
+
 	http.Redirect(w, r,
-		upstreamOAuthConfig.AuthCodeURL(
+		oidc.UpstreamOIDCAuthorizeURL(
+			oidcUpstream,
+			downstreamIssuer,
 			encodedStateParamValue,
+			nonceValue,
+			pkceValue,
 			authCodeOptions...,
 		),
 		302,
@@ -241,55 +408,169 @@ func newAuthorizeRequest(r *http.Request, w http.ResponseWriter, oauthHelper fos
 	return authorizeRequester, true
 }
 
-func readCSRFCookie(r *http.Request, codec oidc.Decoder) csrftoken.CSRFToken {
-	receivedCSRFCookie, err := r.Cookie(oidc.CSRFCookieName)
-	if err != nil {
-		// Error means that the cookie was not found
-		return ""
-	}
-
-	var csrfFromCookie csrftoken.CSRFToken
-	err = codec.Decode(oidc.CSRFCookieEncodingName, receivedCSRFCookie.Value, &csrfFromCookie)
+// validateAuthorizeRequest performs the OIDC validations of `NewAuthorizeResponse` without issuing an authcode,
+// before the end user leaves the authorize endpoint to log in. It writes the error response and returns false
+// when the request is not valid.
+func validateAuthorizeRequest(r *http.Request, w http.ResponseWriter, oauthHelper fosite.OAuth2Provider, authorizeRequester fosite.AuthorizeRequester) bool {
+	now := time.Now()
+	_, err := oauthHelper.NewAuthorizeResponse(r.Context(), authorizeRequester, &psession.PinnipedSession{
+		Fosite: &openid.DefaultSession{
+			Claims: &jwt.IDTokenClaims{
+				// Temporary claim values to allow `NewAuthorizeResponse` to perform other OIDC validations.
+				Subject:     "none",
+				AuthTime:    now,
+				RequestedAt: now,
+			},
+		},
+	})
 	if err != nil {
-		// We can ignore any errors and just make a new cookie. Hopefully this will
-		// make the user experience better if, for example, the server rotated
-		// cookie signing keys and then a user submitted a very old cookie.
-		return ""
+		plog.Info("authorize response error", oidc.FositeErrorForLog(err)...)
+		oauthHelper.WriteAuthorizeError(w, authorizeRequester, err)
+		return false
 	}
-
-	return csrfFromCookie
+	return true
 }
 
-// Select either an OIDC or an LDAP IDP, or return an error.
-func chooseUpstreamIDP(idpLister oidc.UpstreamIdentityProvidersLister) (provider.UpstreamOIDCIdentityProviderI, provider.UpstreamLDAPIdentityProviderI, error) {
+// ChooseUpstreamIDP selects either an OIDC or an LDAP/AD IDP, or returns an error. When the client asked for a specific upstream
+// using the pinniped_idp_name and pinniped_idp_type params, then use that upstream. Otherwise, choose
+// the only configured upstream, which allows clients that do not send those params to keep working.
+// Active Directory upstreams are returned as LDAP upstreams since they are used in exactly the same way, so the
+// type of the chosen upstream is also returned.
+func ChooseUpstreamIDP(r *http.Request, idpLister oidc.UpstreamIdentityProvidersLister) (provider.UpstreamOIDCIdentityProviderI, provider.UpstreamLDAPIdentityProviderI, string, error) {
 	oidcUpstreams := idpLister.GetOIDCIdentityProviders()
 	ldapUpstreams := idpLister.GetLDAPIdentityProviders()
+	adUpstreams := idpLister.GetActiveDirectoryIdentityProviders()
+	requestedName := r.FormValue(oidc.AuthorizeUpstreamIDPNameParamName)
+	requestedType := r.FormValue(oidc.AuthorizeUpstreamIDPTypeParamName)
 	switch {
-	case len(oidcUpstreams)+len(ldapUpstreams) == 0:
-		return nil, nil, httperr.New(
+	case len(oidcUpstreams)+len(ldapUpstreams)+len(adUpstreams) == 0:
+		return nil, nil, "", httperr.New(
 			http.StatusUnprocessableEntity,
 			"No upstream providers are configured",
 		)
-	case len(oidcUpstreams)+len(ldapUpstreams) > 1:
-		var upstreamIDPNames []string
+	case requestedName != "":
+		return findUpstreamIDP(requestedName, requestedType, oidcUpstreams, ldapUpstreams, adUpstreams)
+	case requestedType != "":
+		return nil, nil, "", httperr.Newf(
+			http.StatusUnprocessableEntity,
+			"The %s param must be specified when the %s param is specified",
+			oidc.AuthorizeUpstreamIDPNameParamName, oidc.AuthorizeUpstreamIDPTypeParamName,
+		)
+	case len(oidcUpstreams)+len(ldapUpstreams)+len(adUpstreams) > 1:
+		plog.Warning("Too many upstream providers are configured to choose one without the upstream name param",
+			"upstreamNames", upstreamIDPNames(oidcUpstreams, ldapUpstreams, adUpstreams))
+		return nil, nil, "", httperr.Newf(
+			http.StatusUnprocessableEntity,
+			"Too many upstream providers are configured (the %s and %s params are required to choose one)",
+			oidc.AuthorizeUpstreamIDPNameParamName, oidc.AuthorizeUpstreamIDPTypeParamName,
+		)
+	case len(oidcUpstreams) == 1:
+		return oidcUpstreams[0], nil, oidc.IDPTypeOIDC, nil
+	case len(ldapUpstreams) == 1:
+		return nil, ldapUpstreams[0], oidc.IDPTypeLDAP, nil
+	default:
+		return nil, adUpstreams[0], oidc.IDPTypeActiveDirectory, nil
+	}
+}
+
+// Find the upstream IDP with the requested name and type. The type may be left blank, in which case
+// the name must be unique across all types of upstreams.
+func findUpstreamIDP(
+	name string,
+	idpType string,
+	oidcUpstreams []provider.UpstreamOIDCIdentityProviderI,
+	ldapUpstreams []provider.UpstreamLDAPIdentityProviderI,
+	adUpstreams []provider.UpstreamLDAPIdentityProviderI,
+) (provider.UpstreamOIDCIdentityProviderI, provider.UpstreamLDAPIdentityProviderI, string, error) {
+	if idpType != "" && idpType != oidc.IDPTypeOIDC && idpType != oidc.IDPTypeLDAP && idpType != oidc.IDPTypeActiveDirectory {
+		return nil, nil, "", httperr.Newf(
+			http.StatusUnprocessableEntity,
+			"The %s param is invalid (supported values: %s, %s, %s)",
+			oidc.AuthorizeUpstreamIDPTypeParamName, oidc.IDPTypeOIDC, oidc.IDPTypeLDAP, oidc.IDPTypeActiveDirectory,
+		)
+	}
+
+	var foundOIDC provider.UpstreamOIDCIdentityProviderI
+	if idpType == "" || idpType == oidc.IDPTypeOIDC {
 		for _, idp := range oidcUpstreams {
-			upstreamIDPNames = append(upstreamIDPNames, idp.GetName())
+			if idp.GetName() == name {
+				foundOIDC = idp
+				break
+			}
 		}
-		for _, idp := range ldapUpstreams {
-			upstreamIDPNames = append(upstreamIDPNames, idp.GetName())
+	}
+
+	// LDAP and AD upstreams are both password-based, so they are handled the same way after they are found.
+	foundPasswordBased := []provider.UpstreamLDAPIdentityProviderI{}
+	foundPasswordBasedTypes := []string{}
+	if idpType == "" || idpType == oidc.IDPTypeLDAP {
+		if idp := findLDAPUpstreamIDP(name, ldapUpstreams); idp != nil {
+			foundPasswordBased = append(foundPasswordBased, idp)
+			foundPasswordBasedTypes = append(foundPasswordBasedTypes, oidc.IDPTypeLDAP)
+		}
+	}
+	if idpType == "" || idpType == oidc.IDPTypeActiveDirectory {
+		if idp := findLDAPUpstreamIDP(name, adUpstreams); idp != nil {
+			foundPasswordBased = append(foundPasswordBased, idp)
+			foundPasswordBasedTypes = append(foundPasswordBasedTypes, oidc.IDPTypeActiveDirectory)
 		}
-		plog.Warning("Too many upstream providers are configured (found: %s)", upstreamIDPNames)
-		return nil, nil, httperr.New(
+	}
+
+	foundCount := len(foundPasswordBased)
+	if foundOIDC != nil {
+		foundCount++
+	}
+
+	switch {
+	case foundCount > 1:
+		return nil, nil, "", httperr.Newf(
 			http.StatusUnprocessableEntity,
-			"Too many upstream providers are configured (support for multiple upstreams is not yet implemented)",
+			"Multiple upstream providers have the requested name (the %s param is required to choose one)",
+			oidc.AuthorizeUpstreamIDPTypeParamName,
 		)
-	case len(oidcUpstreams) == 1:
-		return oidcUpstreams[0], nil, nil
+	case foundOIDC != nil:
+		return foundOIDC, nil, oidc.IDPTypeOIDC, nil
+	case len(foundPasswordBased) == 1:
+		return nil, foundPasswordBased[0], foundPasswordBasedTypes[0], nil
 	default:
-		return nil, ldapUpstreams[0], nil
+		plog.Warning("requested upstream provider not found",
+			"requestedUpstreamName", name,
+			"requestedUpstreamType", idpType,
+			"upstreamNames", upstreamIDPNames(oidcUpstreams, ldapUpstreams, adUpstreams))
+		return nil, nil, "", httperr.New(
+			http.StatusUnprocessableEntity,
+			"The requested upstream provider was not found",
+		)
 	}
 }
 
+func findLDAPUpstreamIDP(name string, upstreams []provider.UpstreamLDAPIdentityProviderI) provider.UpstreamLDAPIdentityProviderI {
+	for _, idp := range upstreams {
+		if idp.GetName() == name {
+			return idp
+		}
+	}
+	return nil
+}
+
+func upstreamIDPNames(
+	oidcUpstreams []provider.UpstreamOIDCIdentityProviderI,
+	ldapUpstreams []provider.UpstreamLDAPIdentityProviderI,
+	adUpstreams []provider.UpstreamLDAPIdentityProviderI,
+) []string {
+	names := make([]string, 0, len(oidcUpstreams)+len(ldapUpstreams)+len(adUpstreams))
+	for _, idp := range oidcUpstreams {
+		names = append(names, idp.GetName())
+	}
+	for _, idp := range ldapUpstreams {
+		names = append(names, idp.GetName())
+	}
+	for _, idp := range adUpstreams {
+		names = append(names, idp.GetName())
+	}
+	return names
+}
+
 func generateValues(
 	generateCSRF func() (csrftoken.CSRFToken, error),
 	generateNonce func() (nonce.Nonce, error),
@@ -313,6 +594,7 @@ func generateValues(
 func upstreamStateParam(
 	authorizeRequester fosite.AuthorizeRequester,
 	upstreamName string,
+	upstreamType string,
 	nonceValue nonce.Nonce,
 	csrfValue csrftoken.CSRFToken,
 	pkceValue pkce.Code,
@@ -321,6 +603,7 @@ func upstreamStateParam(
 	stateParamData := oidc.UpstreamStateParamData{
 		AuthParams:    authorizeRequester.GetRequestForm().Encode(),
 		UpstreamName:  upstreamName,
+		UpstreamType:  upstreamType,
 		Nonce:         nonceValue,
 		CSRFToken:     csrfValue,
 		PKCECode:      pkceValue,
@@ -332,29 +615,3 @@ func upstreamStateParam(
 	}
 	return encodedStateParamValue, nil
 }
-
-func addCSRFSetCookieHeader(w http.ResponseWriter, csrfValue csrftoken.CSRFToken, codec oidc.Encoder) error {
-	encodedCSRFValue, err := codec.Encode(oidc.CSRFCookieEncodingName, csrfValue)
-	if err != nil {
-		return httperr.Wrap(http.StatusInternalServerError, "error encoding CSRF cookie", err)
-	}
-
-	http.SetCookie(w, &http.Cookie{
-		Name:     oidc.CSRFCookieName,
-		Value:    encodedCSRFValue,
-		HttpOnly: true,
-		SameSite: http.SameSiteLaxMode,
-		Secure:   true,
-		Path:     "/",
-	})
-
-	return nil
-}
-
-func downstreamSubjectFromUpstreamLDAP(ldapUpstream provider.UpstreamLDAPIdentityProviderI, authenticateResponse *authenticator.Response) string {
-	ldapURL := *ldapUpstream.GetURL()
-	q := ldapURL.Query()
-	q.Set(oidc.IDTokenSubjectClaim, authenticateResponse.User.GetUID())
-	ldapURL.RawQuery = q.Encode()
-	return ldapURL.String()
-}
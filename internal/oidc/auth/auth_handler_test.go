@@ -4,7 +4,9 @@
 package auth
 
 import (
+	"bytes"
 	"context"
+	"encoding/json"
 	"fmt"
 	"html"
 	"net/http"
@@ -13,21 +15,27 @@ import (
 	"regexp"
 	"strings"
 	"testing"
+	"time"
 
 	"github.com/gorilla/securecookie"
 	"github.com/ory/fosite"
 	"github.com/stretchr/testify/require"
-	"k8s.io/apiserver/pkg/authentication/authenticator"
 	"k8s.io/apiserver/pkg/authentication/user"
 	"k8s.io/client-go/kubernetes/fake"
 	v1 "k8s.io/client-go/kubernetes/typed/core/v1"
 	"k8s.io/utils/pointer"
 
+	configv1alpha1 "go.pinniped.dev/generated/latest/apis/supervisor/config/v1alpha1"
+	"go.pinniped.dev/internal/auditlog"
+	"go.pinniped.dev/internal/authenticators"
+	"go.pinniped.dev/internal/crud"
 	"go.pinniped.dev/internal/here"
 	"go.pinniped.dev/internal/oidc"
+	"go.pinniped.dev/internal/oidc/clientregistry"
 	"go.pinniped.dev/internal/oidc/csrftoken"
 	"go.pinniped.dev/internal/oidc/jwks"
 	"go.pinniped.dev/internal/oidc/provider"
+	"go.pinniped.dev/internal/psession"
 	"go.pinniped.dev/internal/testutil"
 	"go.pinniped.dev/internal/testutil/oidctestutil"
 	"go.pinniped.dev/pkg/oidcclient/nonce"
@@ -73,7 +81,7 @@ func TestAuthorizationEndpoint(t *testing.T) {
 
 		fositeMissingCodeChallengeErrorQuery = map[string]string{
 			"error":             "invalid_request",
-			"error_description": "The request is missing a required parameter, includes an invalid parameter value, includes a parameter more than once, or is otherwise malformed. Clients must include a code_challenge when performing the authorize code flow, but it is missing.",
+			"error_description": "The request is missing a required parameter, includes an invalid parameter value, includes a parameter more than once, or is otherwise malformed. This client must include a code_challenge when performing the authorize code flow, but it is missing.",
 			"state":             happyState,
 		}
 
@@ -119,6 +127,18 @@ func TestAuthorizationEndpoint(t *testing.T) {
 			"state":             happyState,
 		}
 
+		fositeAccessDeniedWithRejectedByIdentityTransformsHintErrorQuery = map[string]string{
+			"error":             "access_denied",
+			"error_description": "The resource owner or authorization server denied the request. Login was rejected: user is not a member of any of the required groups.",
+			"state":             happyState,
+		}
+
+		fositeAccessDeniedWithRejectedByPolicyHintErrorQuery = map[string]string{
+			"error":             "access_denied",
+			"error_description": "The resource owner or authorization server denied the request. Login was rejected: the sales department may not log in.",
+			"state":             happyState,
+		}
+
 		fositeAccessDeniedWithMissingUsernamePasswordHintErrorQuery = map[string]string{
 			"error":             "access_denied",
 			"error_description": "The resource owner or authorization server denied the request. Missing or blank username or password.",
@@ -134,12 +154,12 @@ func TestAuthorizationEndpoint(t *testing.T) {
 	createOauthHelperWithRealStorage := func(secretsClient v1.SecretInterface) (fosite.OAuth2Provider, *oidc.KubeStorage) {
 		// Configure fosite the same way that the production code would when using Kube storage.
 		// Inject this into our test subject at the last second so we get a fresh storage for every test.
-		kubeOauthStore := oidc.NewKubeStorage(secretsClient, timeoutsConfiguration)
+		kubeOauthStore := oidc.NewKubeStorage(crud.NewSecretsBackend(secretsClient), &clientregistry.StaticClientManager{}, timeoutsConfiguration)
 		return oidc.FositeOauth2Helper(kubeOauthStore, downstreamIssuer, hmacSecretFunc, jwksProviderIsUnused, timeoutsConfiguration), kubeOauthStore
 	}
 
 	// Configure fosite the same way that the production code would, using NullStorage to turn off storage.
-	nullOauthStore := oidc.NullStorage{}
+	nullOauthStore := oidc.NullStorage{ClientManager: &clientregistry.StaticClientManager{}}
 	oauthHelperWithNullStorage := oidc.FositeOauth2Helper(nullOauthStore, downstreamIssuer, hmacSecretFunc, jwksProviderIsUnused, timeoutsConfiguration)
 
 	upstreamAuthURL, err := url.Parse("https://some-upstream-idp:8443/auth")
@@ -152,11 +172,35 @@ func TestAuthorizationEndpoint(t *testing.T) {
 		Scopes:           []string{"scope1", "scope2"}, // the scopes to request when starting the upstream authorization flow
 	}
 
+	upstreamOIDCIdentityProviderWithAdditionalAuthcodeParams := upstreamOIDCIdentityProvider
+	upstreamOIDCIdentityProviderWithAdditionalAuthcodeParams.AdditionalAuthcodeParams = map[string]string{
+		"hd":          "example.com",
+		"access_type": "online",
+		"prompt":      "select_account",
+	}
+
 	happyLDAPUsername := "some-ldap-user"
 	happyLDAPUsernameFromAuthenticator := "some-mapped-ldap-username"
 	happyLDAPPassword := "some-ldap-password" //nolint:gosec
 	happyLDAPUID := "some-ldap-uid"
 	happyLDAPGroups := []string{"group1", "group2", "group3"}
+	happyLDAPUserDN := "cn=some-ldap-user,ou=users,dc=example,dc=com"
+
+	expectedHappyLDAPUpstreamCustomSession := &psession.CustomSessionData{
+		ProviderName: "some-ldap-idp",
+		ProviderType: "ldap",
+		LDAP: &psession.LDAPSessionData{
+			UserDN: happyLDAPUserDN,
+		},
+	}
+
+	expectedHappyActiveDirectoryUpstreamCustomSession := &psession.CustomSessionData{
+		ProviderName: "some-ldap-idp",
+		ProviderType: "activedirectory",
+		LDAP: &psession.LDAPSessionData{
+			UserDN: happyLDAPUserDN,
+		},
+	}
 
 	parsedUpstreamLDAPURL, err := url.Parse(upstreamLDAPURL)
 	require.NoError(t, err)
@@ -164,30 +208,65 @@ func TestAuthorizationEndpoint(t *testing.T) {
 	upstreamLDAPIdentityProvider := oidctestutil.TestUpstreamLDAPIdentityProvider{
 		Name: "some-ldap-idp",
 		URL:  parsedUpstreamLDAPURL,
-		AuthenticateFunc: func(ctx context.Context, username, password string) (*authenticator.Response, bool, error) {
+		AuthenticateFunc: func(ctx context.Context, username, password string) (*authenticators.Response, bool, error) {
 			if username == "" || password == "" {
 				return nil, false, fmt.Errorf("should not have passed empty username or password to the authenticator")
 			}
 			if username == happyLDAPUsername && password == happyLDAPPassword {
-				return &authenticator.Response{
+				return &authenticators.Response{
 					User: &user.DefaultInfo{
 						Name:   happyLDAPUsernameFromAuthenticator,
 						UID:    happyLDAPUID,
 						Groups: happyLDAPGroups,
 					},
+					DN: happyLDAPUserDN,
+					Attributes: map[string][]string{
+						"dn":         {happyLDAPUserDN},
+						"department": {"sales"},
+					},
 				}, true, nil
 			}
 			return nil, false, nil
 		},
 	}
 
+	upstreamLDAPIdentityProviderWithAdditionalClaims := oidctestutil.TestUpstreamLDAPIdentityProvider{
+		Name: "some-ldap-idp",
+		URL:  parsedUpstreamLDAPURL,
+		AuthenticateFunc: func(ctx context.Context, username, password string) (*authenticators.Response, bool, error) {
+			response, authenticated, err := upstreamLDAPIdentityProvider.AuthenticateFunc(ctx, username, password)
+			if authenticated {
+				response.AdditionalClaims = map[string]interface{}{
+					"email":       "some-user@example.com",
+					"departments": []string{"sales", "marketing"},
+				}
+			}
+			return response, authenticated, err
+		},
+	}
+
 	erroringUpstreamLDAPIdentityProvider := oidctestutil.TestUpstreamLDAPIdentityProvider{
 		Name: "some-ldap-idp",
-		AuthenticateFunc: func(ctx context.Context, username, password string) (*authenticator.Response, bool, error) {
+		AuthenticateFunc: func(ctx context.Context, username, password string) (*authenticators.Response, bool, error) {
 			return nil, false, fmt.Errorf("some ldap upstream auth error")
 		},
 	}
 
+	otherUpstreamOIDCIdentityProvider := oidctestutil.TestUpstreamOIDCIdentityProvider{
+		Name:             "some-other-oidc-idp",
+		ClientID:         "some-other-client-id",
+		AuthorizationURL: *upstreamAuthURL,
+		Scopes:           []string{"other-scope1", "other-scope2"},
+	}
+
+	otherUpstreamLDAPIdentityProvider := oidctestutil.TestUpstreamLDAPIdentityProvider{
+		Name: "some-other-ldap-idp",
+		URL:  parsedUpstreamLDAPURL,
+		AuthenticateFunc: func(ctx context.Context, username, password string) (*authenticators.Response, bool, error) {
+			return nil, false, fmt.Errorf("should not have chosen this upstream LDAP provider")
+		},
+	}
+
 	happyCSRF := "test-csrf"
 	happyPKCE := "test-pkce"
 	happyNonce := "test-nonce"
@@ -284,6 +363,7 @@ func TestAuthorizationEndpoint(t *testing.T) {
 			oidctestutil.ExpectedUpstreamStateParamFormat{
 				P: encodeQuery(modifiedHappyGetRequestQueryMap(queryOverrides)),
 				U: upstreamName,
+				T: "oidc",
 				N: happyNonce,
 				C: csrf,
 				K: happyPKCE,
@@ -294,6 +374,24 @@ func TestAuthorizationEndpoint(t *testing.T) {
 		return encoded
 	}
 
+	expectedRedirectLocationForUpstreamOIDCWithAdditionalParams := func(expectedUpstreamState string, expectedAdditionalParams map[string]string) string {
+		query := map[string]string{
+			"response_type":         "code",
+			"access_type":           "offline",
+			"scope":                 "scope1 scope2",
+			"client_id":             "some-client-id",
+			"state":                 expectedUpstreamState,
+			"nonce":                 happyNonce,
+			"code_challenge":        expectedUpstreamCodeChallenge,
+			"code_challenge_method": downstreamPKCEChallengeMethod,
+			"redirect_uri":          downstreamIssuer + "/callback",
+		}
+		for k, v := range expectedAdditionalParams {
+			query[k] = v
+		}
+		return urlWithQuery(upstreamAuthURL.String(), query)
+	}
+
 	expectedRedirectLocationForUpstreamOIDC := func(expectedUpstreamState string, expectedPrompt string) string {
 		query := map[string]string{
 			"response_type":         "code",
@@ -312,6 +410,24 @@ func TestAuthorizationEndpoint(t *testing.T) {
 		return urlWithQuery(upstreamAuthURL.String(), query)
 	}
 
+	expectedLoginPageUpstreamStateParam := func(queryOverrides map[string]string, csrfValue, upstreamName, upstreamType string) string {
+		encoded, err := happyStateEncoder.Encode("s",
+			oidctestutil.ExpectedUpstreamStateParamFormat{
+				P: encodeQuery(modifiedHappyGetRequestQueryMap(queryOverrides)),
+				U: upstreamName,
+				T: upstreamType,
+				C: csrfValue,
+				V: "1",
+			},
+		)
+		require.NoError(t, err)
+		return encoded
+	}
+
+	expectedRedirectLocationForLoginPage := func(expectedUpstreamState string) string {
+		return urlWithQuery(downstreamIssuer+"/login", map[string]string{"state": expectedUpstreamState})
+	}
+
 	// Note that fosite puts the granted scopes as a param in the redirect URI even though the spec doesn't seem to require it
 	happyAuthcodeDownstreamRedirectLocationRegexp := downstreamRedirectURI + `\?code=([^&]+)&scope=openid&state=` + happyState
 
@@ -323,6 +439,7 @@ func TestAuthorizationEndpoint(t *testing.T) {
 		name string
 
 		idpLister            provider.DynamicUpstreamIDPProvider
+		identityTransforms   oidctestutil.TestIdentityTransforms
 		generateCSRF         func() (csrftoken.CSRFToken, error)
 		generatePKCE         func() (pkce.Code, error)
 		generateNonce        func() (nonce.Nonce, error)
@@ -352,11 +469,16 @@ func TestAuthorizationEndpoint(t *testing.T) {
 		wantDownstreamIDTokenSubject      string
 		wantDownstreamIDTokenUsername     string
 		wantDownstreamIDTokenGroups       []string
+		wantDownstreamAdditionalClaims    map[string]interface{}
 		wantDownstreamRequestedScopes     []string
 		wantDownstreamPKCEChallenge       string
 		wantDownstreamPKCEChallengeMethod string
 		wantDownstreamNonce               string
+		wantDownstreamCustomSessionData   *psession.CustomSessionData
 		wantUnnecessaryStoredRecords      int
+
+		// The last audit event which was written, ignoring the fields which are filled in by the auditlog package.
+		wantAuditEvent *auditlog.Event
 	}
 	tests := []testCase{
 		{
@@ -375,6 +497,13 @@ func TestAuthorizationEndpoint(t *testing.T) {
 			wantLocationHeader:                     expectedRedirectLocationForUpstreamOIDC(expectedUpstreamStateParam(nil, "", ""), ""),
 			wantUpstreamStateParamInLocationHeader: true,
 			wantBodyStringWithLocationInHref:       true,
+			wantAuditEvent: &auditlog.Event{
+				Type:            auditlog.EventTypeAuthorize,
+				Result:          auditlog.ResultSuccess,
+				ClientID:        downstreamClientID,
+				UpstreamIDPName: "some-oidc-idp",
+				UpstreamIDPType: "oidc",
+			},
 		},
 		{
 			name:                              "LDAP upstream happy path using GET",
@@ -396,6 +525,53 @@ func TestAuthorizationEndpoint(t *testing.T) {
 			wantDownstreamNonce:               downstreamNonce,
 			wantDownstreamPKCEChallenge:       downstreamPKCEChallenge,
 			wantDownstreamPKCEChallengeMethod: downstreamPKCEChallengeMethod,
+			wantDownstreamCustomSessionData:   expectedHappyLDAPUpstreamCustomSession,
+			wantAuditEvent: &auditlog.Event{
+				Type:            auditlog.EventTypeAuthorize,
+				Result:          auditlog.ResultSuccess,
+				ClientID:        downstreamClientID,
+				UpstreamIDPName: "some-ldap-idp",
+				UpstreamIDPType: "ldap",
+				Subject:         upstreamLDAPURL + "&sub=" + happyLDAPUID,
+				Username:        happyLDAPUsernameFromAuthenticator,
+				Groups:          happyLDAPGroups,
+			},
+		},
+		{
+			name:                             "LDAP upstream happy path with additional claims",
+			idpLister:                        oidctestutil.NewUpstreamIDPListerBuilder().WithLDAP(&upstreamLDAPIdentityProviderWithAdditionalClaims).Build(),
+			method:                           http.MethodGet,
+			path:                             happyGetRequestPath,
+			customUsernameHeader:             pointer.StringPtr(happyLDAPUsername),
+			customPasswordHeader:             pointer.StringPtr(happyLDAPPassword),
+			wantStatus:                       http.StatusFound,
+			wantContentType:                  htmlContentType,
+			wantRedirectLocationRegexp:       happyAuthcodeDownstreamRedirectLocationRegexp,
+			wantBodyStringWithLocationInHref: false,
+			wantDownstreamIDTokenSubject:     upstreamLDAPURL + "&sub=" + happyLDAPUID,
+			wantDownstreamIDTokenUsername:    happyLDAPUsernameFromAuthenticator,
+			wantDownstreamIDTokenGroups:      happyLDAPGroups,
+			wantDownstreamAdditionalClaims: map[string]interface{}{
+				"email":       "some-user@example.com",
+				"departments": []interface{}{"sales", "marketing"},
+			},
+			wantDownstreamRequestedScopes:     happyDownstreamScopesRequested,
+			wantDownstreamRedirectURI:         downstreamRedirectURI,
+			wantDownstreamGrantedScopes:       happyDownstreamScopesGranted,
+			wantDownstreamNonce:               downstreamNonce,
+			wantDownstreamPKCEChallenge:       downstreamPKCEChallenge,
+			wantDownstreamPKCEChallengeMethod: downstreamPKCEChallengeMethod,
+			wantDownstreamCustomSessionData:   expectedHappyLDAPUpstreamCustomSession,
+			wantAuditEvent: &auditlog.Event{
+				Type:            auditlog.EventTypeAuthorize,
+				Result:          auditlog.ResultSuccess,
+				ClientID:        downstreamClientID,
+				UpstreamIDPName: "some-ldap-idp",
+				UpstreamIDPType: "ldap",
+				Subject:         upstreamLDAPURL + "&sub=" + happyLDAPUID,
+				Username:        happyLDAPUsernameFromAuthenticator,
+				Groups:          happyLDAPGroups,
+			},
 		},
 		{
 			name:                                   "OIDC upstream happy path using GET with a CSRF cookie",
@@ -414,6 +590,68 @@ func TestAuthorizationEndpoint(t *testing.T) {
 			wantUpstreamStateParamInLocationHeader: true,
 			wantBodyStringWithLocationInHref:       true,
 		},
+		{
+			name:                                   "LDAP upstream browser flow happy path using GET without a CSRF cookie",
+			idpLister:                              oidctestutil.NewUpstreamIDPListerBuilder().WithLDAP(&upstreamLDAPIdentityProvider).Build(),
+			generateCSRF:                           happyCSRFGenerator,
+			stateEncoder:                           happyStateEncoder,
+			cookieEncoder:                          happyCookieEncoder,
+			method:                                 http.MethodGet,
+			path:                                   happyGetRequestPath,
+			wantStatus:                             http.StatusFound,
+			wantContentType:                        htmlContentType,
+			wantCSRFValueInCookieHeader:            happyCSRF,
+			wantLocationHeader:                     expectedRedirectLocationForLoginPage(expectedLoginPageUpstreamStateParam(nil, happyCSRF, "some-ldap-idp", "ldap")),
+			wantUpstreamStateParamInLocationHeader: true,
+			wantBodyStringWithLocationInHref:       true,
+			wantAuditEvent: &auditlog.Event{
+				Type:            auditlog.EventTypeAuthorize,
+				Result:          auditlog.ResultSuccess,
+				ClientID:        downstreamClientID,
+				UpstreamIDPName: "some-ldap-idp",
+				UpstreamIDPType: "ldap",
+			},
+		},
+		{
+			name:                                   "Active Directory upstream browser flow happy path using GET with a CSRF cookie",
+			idpLister:                              oidctestutil.NewUpstreamIDPListerBuilder().WithActiveDirectory(&upstreamLDAPIdentityProvider).Build(),
+			generateCSRF:                           happyCSRFGenerator,
+			stateEncoder:                           happyStateEncoder,
+			cookieEncoder:                          happyCookieEncoder,
+			method:                                 http.MethodGet,
+			path:                                   happyGetRequestPath,
+			csrfCookie:                             "__Host-pinniped-csrf=" + encodedIncomingCookieCSRFValue + " ",
+			wantStatus:                             http.StatusFound,
+			wantContentType:                        htmlContentType,
+			wantLocationHeader:                     expectedRedirectLocationForLoginPage(expectedLoginPageUpstreamStateParam(nil, incomingCookieCSRFValue, "some-ldap-idp", "activedirectory")),
+			wantUpstreamStateParamInLocationHeader: true,
+			wantBodyStringWithLocationInHref:       true,
+		},
+		{
+			name:               "LDAP upstream browser flow with an invalid downstream request does not redirect to the login page",
+			idpLister:          oidctestutil.NewUpstreamIDPListerBuilder().WithLDAP(&upstreamLDAPIdentityProvider).Build(),
+			generateCSRF:       happyCSRFGenerator,
+			stateEncoder:       happyStateEncoder,
+			cookieEncoder:      happyCookieEncoder,
+			method:             http.MethodGet,
+			path:               modifiedHappyGetRequestPath(map[string]string{"prompt": "none login"}),
+			wantStatus:         http.StatusFound,
+			wantContentType:    "application/json; charset=utf-8",
+			wantLocationHeader: urlWithQuery(downstreamRedirectURI, fositePromptHasNoneAndOtherValueErrorQuery),
+			wantBodyString:     "",
+		},
+		{
+			name:            "error while generating CSRF token using LDAP upstream browser flow",
+			idpLister:       oidctestutil.NewUpstreamIDPListerBuilder().WithLDAP(&upstreamLDAPIdentityProvider).Build(),
+			generateCSRF:    sadCSRFGenerator,
+			stateEncoder:    happyStateEncoder,
+			cookieEncoder:   happyCookieEncoder,
+			method:          http.MethodGet,
+			path:            happyGetRequestPath,
+			wantStatus:      http.StatusInternalServerError,
+			wantContentType: "text/plain; charset=utf-8",
+			wantBodyString:  "Internal Server Error: error generating CSRF token\n",
+		},
 		{
 			name:                                   "OIDC upstream happy path using POST",
 			idpLister:                              oidctestutil.NewUpstreamIDPListerBuilder().WithOIDC(&upstreamOIDCIdentityProvider).Build(),
@@ -455,6 +693,7 @@ func TestAuthorizationEndpoint(t *testing.T) {
 			wantDownstreamNonce:               downstreamNonce,
 			wantDownstreamPKCEChallenge:       downstreamPKCEChallenge,
 			wantDownstreamPKCEChallengeMethod: downstreamPKCEChallengeMethod,
+			wantDownstreamCustomSessionData:   expectedHappyLDAPUpstreamCustomSession,
 		},
 		{
 			name:                                   "OIDC upstream happy path with prompt param login passed through to redirect uri",
@@ -475,6 +714,42 @@ func TestAuthorizationEndpoint(t *testing.T) {
 			wantLocationHeader:                     expectedRedirectLocationForUpstreamOIDC(expectedUpstreamStateParam(map[string]string{"prompt": "login"}, "", ""), "login"),
 			wantUpstreamStateParamInLocationHeader: true,
 		},
+		{
+			name:                             "OIDC upstream happy path with additional authcode params sent to the upstream",
+			idpLister:                        oidctestutil.NewUpstreamIDPListerBuilder().WithOIDC(&upstreamOIDCIdentityProviderWithAdditionalAuthcodeParams).Build(),
+			generateCSRF:                     happyCSRFGenerator,
+			generatePKCE:                     happyPKCEGenerator,
+			generateNonce:                    happyNonceGenerator,
+			stateEncoder:                     happyStateEncoder,
+			cookieEncoder:                    happyCookieEncoder,
+			method:                           http.MethodGet,
+			path:                             happyGetRequestPath,
+			wantStatus:                       http.StatusFound,
+			wantContentType:                  htmlContentType,
+			wantBodyStringWithLocationInHref: true,
+			wantCSRFValueInCookieHeader:      happyCSRF,
+			wantLocationHeader: expectedRedirectLocationForUpstreamOIDCWithAdditionalParams(expectedUpstreamStateParam(nil, "", ""),
+				map[string]string{"hd": "example.com", "access_type": "online", "prompt": "select_account"}),
+			wantUpstreamStateParamInLocationHeader: true,
+		},
+		{
+			name:                             "OIDC upstream happy path with prompt param from the client taking precedence over the additional authcode params",
+			idpLister:                        oidctestutil.NewUpstreamIDPListerBuilder().WithOIDC(&upstreamOIDCIdentityProviderWithAdditionalAuthcodeParams).Build(),
+			generateCSRF:                     happyCSRFGenerator,
+			generatePKCE:                     happyPKCEGenerator,
+			generateNonce:                    happyNonceGenerator,
+			stateEncoder:                     happyStateEncoder,
+			cookieEncoder:                    happyCookieEncoder,
+			method:                           http.MethodGet,
+			path:                             modifiedHappyGetRequestPath(map[string]string{"prompt": "login"}),
+			wantStatus:                       http.StatusFound,
+			wantContentType:                  htmlContentType,
+			wantBodyStringWithLocationInHref: true,
+			wantCSRFValueInCookieHeader:      happyCSRF,
+			wantLocationHeader: expectedRedirectLocationForUpstreamOIDCWithAdditionalParams(expectedUpstreamStateParam(map[string]string{"prompt": "login"}, "", ""),
+				map[string]string{"hd": "example.com", "access_type": "online", "prompt": "login"}),
+			wantUpstreamStateParamInLocationHeader: true,
+		},
 		{
 			name:            "OIDC upstream with error while decoding CSRF cookie just generates a new cookie and succeeds as usual",
 			idpLister:       oidctestutil.NewUpstreamIDPListerBuilder().WithOIDC(&upstreamOIDCIdentityProvider).Build(),
@@ -537,6 +812,7 @@ func TestAuthorizationEndpoint(t *testing.T) {
 			wantDownstreamNonce:               downstreamNonce,
 			wantDownstreamPKCEChallenge:       downstreamPKCEChallenge,
 			wantDownstreamPKCEChallengeMethod: downstreamPKCEChallengeMethod,
+			wantDownstreamCustomSessionData:   expectedHappyLDAPUpstreamCustomSession,
 		},
 		{
 			name:                        "OIDC upstream happy path when downstream requested scopes include offline_access",
@@ -567,6 +843,15 @@ func TestAuthorizationEndpoint(t *testing.T) {
 			wantStatus:           http.StatusBadGateway,
 			wantContentType:      htmlContentType,
 			wantBodyString:       "Bad Gateway: unexpected error during upstream authentication\n",
+			wantAuditEvent: &auditlog.Event{
+				Type:            auditlog.EventTypeAuthorize,
+				Result:          auditlog.ResultError,
+				Reason:          "unexpected error during upstream authentication",
+				ClientID:        downstreamClientID,
+				UpstreamIDPName: "some-ldap-idp",
+				UpstreamIDPType: "ldap",
+				Username:        happyLDAPUsername,
+			},
 		},
 		{
 			name:                 "wrong upstream password for LDAP authentication",
@@ -579,6 +864,142 @@ func TestAuthorizationEndpoint(t *testing.T) {
 			wantContentType:      "application/json; charset=utf-8",
 			wantLocationHeader:   urlWithQuery(downstreamRedirectURI, fositeAccessDeniedWithBadUsernamePasswordHintErrorQuery),
 			wantBodyString:       "",
+			wantAuditEvent: &auditlog.Event{
+				Type:            auditlog.EventTypeFailedCredentials,
+				Result:          auditlog.ResultFailure,
+				Reason:          "username/password not accepted by upstream",
+				ClientID:        downstreamClientID,
+				UpstreamIDPName: "some-ldap-idp",
+				UpstreamIDPType: "ldap",
+				Username:        happyLDAPUsername,
+			},
+		},
+		{
+			name:      "LDAP upstream happy path with identity transforms",
+			idpLister: oidctestutil.NewUpstreamIDPListerBuilder().WithLDAP(&upstreamLDAPIdentityProvider).Build(),
+			identityTransforms: oidctestutil.NewIdentityTransforms(t, "some-ldap-idp",
+				configv1alpha1.FederationDomainTransform{Type: configv1alpha1.UsernamePrefixFederationDomainTransformType, Prefix: "ldap:"},
+				configv1alpha1.FederationDomainTransform{Type: configv1alpha1.GroupsPrefixFederationDomainTransformType, Prefix: "ldap:"},
+				configv1alpha1.FederationDomainTransform{Type: configv1alpha1.RequireGroupFederationDomainTransformType, Groups: []string{"ldap:group2"}},
+			),
+			method:                            http.MethodGet,
+			path:                              happyGetRequestPath,
+			customUsernameHeader:              pointer.StringPtr(happyLDAPUsername),
+			customPasswordHeader:              pointer.StringPtr(happyLDAPPassword),
+			wantStatus:                        http.StatusFound,
+			wantContentType:                   htmlContentType,
+			wantRedirectLocationRegexp:        happyAuthcodeDownstreamRedirectLocationRegexp,
+			wantDownstreamIDTokenSubject:      upstreamLDAPURL + "&sub=" + happyLDAPUID,
+			wantDownstreamIDTokenUsername:     "ldap:" + happyLDAPUsernameFromAuthenticator,
+			wantDownstreamIDTokenGroups:       []string{"ldap:group1", "ldap:group2", "ldap:group3"},
+			wantDownstreamRequestedScopes:     happyDownstreamScopesRequested,
+			wantDownstreamRedirectURI:         downstreamRedirectURI,
+			wantDownstreamGrantedScopes:       happyDownstreamScopesGranted,
+			wantDownstreamNonce:               downstreamNonce,
+			wantDownstreamPKCEChallenge:       downstreamPKCEChallenge,
+			wantDownstreamPKCEChallengeMethod: downstreamPKCEChallengeMethod,
+			wantDownstreamCustomSessionData:   expectedHappyLDAPUpstreamCustomSession,
+			wantAuditEvent: &auditlog.Event{
+				Type:            auditlog.EventTypeAuthorize,
+				Result:          auditlog.ResultSuccess,
+				ClientID:        downstreamClientID,
+				UpstreamIDPName: "some-ldap-idp",
+				UpstreamIDPType: "ldap",
+				Subject:         upstreamLDAPURL + "&sub=" + happyLDAPUID,
+				Username:        "ldap:" + happyLDAPUsernameFromAuthenticator,
+				Groups:          []string{"ldap:group1", "ldap:group2", "ldap:group3"},
+			},
+		},
+		{
+			name:      "LDAP user rejected by identity transforms",
+			idpLister: oidctestutil.NewUpstreamIDPListerBuilder().WithLDAP(&upstreamLDAPIdentityProvider).Build(),
+			identityTransforms: oidctestutil.NewIdentityTransforms(t, "some-ldap-idp",
+				configv1alpha1.FederationDomainTransform{Type: configv1alpha1.RequireGroupFederationDomainTransformType, Groups: []string{"k8s-users"}},
+			),
+			method:               http.MethodGet,
+			path:                 happyGetRequestPath,
+			customUsernameHeader: pointer.StringPtr(happyLDAPUsername),
+			customPasswordHeader: pointer.StringPtr(happyLDAPPassword),
+			wantStatus:           http.StatusFound,
+			wantContentType:      "application/json; charset=utf-8",
+			wantLocationHeader:   urlWithQuery(downstreamRedirectURI, fositeAccessDeniedWithRejectedByIdentityTransformsHintErrorQuery),
+			wantBodyString:       "",
+			wantAuditEvent: &auditlog.Event{
+				Type:            auditlog.EventTypeAuthorize,
+				Result:          auditlog.ResultFailure,
+				Reason:          "user is not a member of any of the required groups",
+				ClientID:        downstreamClientID,
+				UpstreamIDPName: "some-ldap-idp",
+				UpstreamIDPType: "ldap",
+				Username:        happyLDAPUsername,
+			},
+		},
+		{
+			name:      "LDAP upstream happy path with a policy which adds groups",
+			idpLister: oidctestutil.NewUpstreamIDPListerBuilder().WithLDAP(&upstreamLDAPIdentityProvider).Build(),
+			identityTransforms: oidctestutil.NewIdentityPolicy(t, "some-ldap-idp", configv1alpha1.FederationDomainPolicy{
+				GroupRules: []configv1alpha1.FederationDomainGroupRule{
+					{FromClaim: "department", Prefix: "dept:"},
+				},
+			}),
+			method:                            http.MethodGet,
+			path:                              happyGetRequestPath,
+			customUsernameHeader:              pointer.StringPtr(happyLDAPUsername),
+			customPasswordHeader:              pointer.StringPtr(happyLDAPPassword),
+			wantStatus:                        http.StatusFound,
+			wantContentType:                   htmlContentType,
+			wantRedirectLocationRegexp:        happyAuthcodeDownstreamRedirectLocationRegexp,
+			wantDownstreamIDTokenSubject:      upstreamLDAPURL + "&sub=" + happyLDAPUID,
+			wantDownstreamIDTokenUsername:     happyLDAPUsernameFromAuthenticator,
+			wantDownstreamIDTokenGroups:       []string{"group1", "group2", "group3", "dept:sales"},
+			wantDownstreamRequestedScopes:     happyDownstreamScopesRequested,
+			wantDownstreamRedirectURI:         downstreamRedirectURI,
+			wantDownstreamGrantedScopes:       happyDownstreamScopesGranted,
+			wantDownstreamNonce:               downstreamNonce,
+			wantDownstreamPKCEChallenge:       downstreamPKCEChallenge,
+			wantDownstreamPKCEChallengeMethod: downstreamPKCEChallengeMethod,
+			wantDownstreamCustomSessionData:   expectedHappyLDAPUpstreamCustomSession,
+			wantAuditEvent: &auditlog.Event{
+				Type:            auditlog.EventTypeAuthorize,
+				Result:          auditlog.ResultSuccess,
+				ClientID:        downstreamClientID,
+				UpstreamIDPName: "some-ldap-idp",
+				UpstreamIDPType: "ldap",
+				Subject:         upstreamLDAPURL + "&sub=" + happyLDAPUID,
+				Username:        happyLDAPUsernameFromAuthenticator,
+				Groups:          []string{"group1", "group2", "group3", "dept:sales"},
+			},
+		},
+		{
+			name:      "LDAP user denied by a policy which uses the attributes of their entry",
+			idpLister: oidctestutil.NewUpstreamIDPListerBuilder().WithLDAP(&upstreamLDAPIdentityProvider).Build(),
+			identityTransforms: oidctestutil.NewIdentityPolicy(t, "some-ldap-idp", configv1alpha1.FederationDomainPolicy{
+				DenyRules: []configv1alpha1.FederationDomainDenyRule{
+					{
+						Match: configv1alpha1.FederationDomainPolicyMatch{
+							Claims: []configv1alpha1.FederationDomainClaimMatch{{Name: "department", Values: []string{"sales"}}},
+						},
+						Message: "the sales department may not log in",
+					},
+				},
+			}),
+			method:               http.MethodGet,
+			path:                 happyGetRequestPath,
+			customUsernameHeader: pointer.StringPtr(happyLDAPUsername),
+			customPasswordHeader: pointer.StringPtr(happyLDAPPassword),
+			wantStatus:           http.StatusFound,
+			wantContentType:      "application/json; charset=utf-8",
+			wantLocationHeader:   urlWithQuery(downstreamRedirectURI, fositeAccessDeniedWithRejectedByPolicyHintErrorQuery),
+			wantBodyString:       "",
+			wantAuditEvent: &auditlog.Event{
+				Type:            auditlog.EventTypeAuthorize,
+				Result:          auditlog.ResultFailure,
+				Reason:          "the sales department may not log in",
+				ClientID:        downstreamClientID,
+				UpstreamIDPName: "some-ldap-idp",
+				UpstreamIDPType: "ldap",
+				Username:        happyLDAPUsername,
+			},
 		},
 		{
 			name:                 "wrong upstream username for LDAP authentication",
@@ -953,6 +1374,7 @@ func TestAuthorizationEndpoint(t *testing.T) {
 			wantDownstreamNonce:               downstreamNonce,
 			wantDownstreamPKCEChallenge:       downstreamPKCEChallenge,
 			wantDownstreamPKCEChallengeMethod: downstreamPKCEChallengeMethod,
+			wantDownstreamCustomSessionData:   expectedHappyLDAPUpstreamCustomSession,
 		},
 		{
 			name:               "downstream state does not have enough entropy using OIDC upstream",
@@ -1061,31 +1483,179 @@ func TestAuthorizationEndpoint(t *testing.T) {
 			wantBodyString:  "Unprocessable Entity: No upstream providers are configured\n",
 		},
 		{
-			name:            "too many upstream providers are configured: multiple OIDC",
-			idpLister:       oidctestutil.NewUpstreamIDPListerBuilder().WithOIDC(&upstreamOIDCIdentityProvider, &upstreamOIDCIdentityProvider).Build(), // more than one not allowed
+			name:            "too many upstream providers are configured without choosing one: multiple OIDC",
+			idpLister:       oidctestutil.NewUpstreamIDPListerBuilder().WithOIDC(&upstreamOIDCIdentityProvider, &otherUpstreamOIDCIdentityProvider).Build(),
 			method:          http.MethodGet,
 			path:            happyGetRequestPath,
 			wantStatus:      http.StatusUnprocessableEntity,
 			wantContentType: "text/plain; charset=utf-8",
-			wantBodyString:  "Unprocessable Entity: Too many upstream providers are configured (support for multiple upstreams is not yet implemented)\n",
+			wantBodyString:  "Unprocessable Entity: Too many upstream providers are configured (the pinniped_idp_name and pinniped_idp_type params are required to choose one)\n",
 		},
 		{
-			name:            "too many upstream providers are configured: multiple LDAP",
-			idpLister:       oidctestutil.NewUpstreamIDPListerBuilder().WithLDAP(&upstreamLDAPIdentityProvider, &upstreamLDAPIdentityProvider).Build(), // more than one not allowed
+			name:            "too many upstream providers are configured without choosing one: multiple LDAP",
+			idpLister:       oidctestutil.NewUpstreamIDPListerBuilder().WithLDAP(&upstreamLDAPIdentityProvider, &otherUpstreamLDAPIdentityProvider).Build(),
 			method:          http.MethodGet,
 			path:            happyGetRequestPath,
 			wantStatus:      http.StatusUnprocessableEntity,
 			wantContentType: "text/plain; charset=utf-8",
-			wantBodyString:  "Unprocessable Entity: Too many upstream providers are configured (support for multiple upstreams is not yet implemented)\n",
+			wantBodyString:  "Unprocessable Entity: Too many upstream providers are configured (the pinniped_idp_name and pinniped_idp_type params are required to choose one)\n",
 		},
 		{
-			name:            "too many upstream providers are configured: both OIDC and LDAP",
-			idpLister:       oidctestutil.NewUpstreamIDPListerBuilder().WithOIDC(&upstreamOIDCIdentityProvider).WithLDAP(&upstreamLDAPIdentityProvider).Build(), // more than one not allowed
+			name:            "too many upstream providers are configured without choosing one: both OIDC and LDAP",
+			idpLister:       oidctestutil.NewUpstreamIDPListerBuilder().WithOIDC(&upstreamOIDCIdentityProvider).WithLDAP(&upstreamLDAPIdentityProvider).Build(),
 			method:          http.MethodGet,
 			path:            happyGetRequestPath,
 			wantStatus:      http.StatusUnprocessableEntity,
 			wantContentType: "text/plain; charset=utf-8",
-			wantBodyString:  "Unprocessable Entity: Too many upstream providers are configured (support for multiple upstreams is not yet implemented)\n",
+			wantBodyString:  "Unprocessable Entity: Too many upstream providers are configured (the pinniped_idp_name and pinniped_idp_type params are required to choose one)\n",
+		},
+		{
+			name: "OIDC upstream chosen by name and type when multiple upstreams are configured",
+			idpLister: oidctestutil.NewUpstreamIDPListerBuilder().
+				WithOIDC(&otherUpstreamOIDCIdentityProvider, &upstreamOIDCIdentityProvider).
+				WithLDAP(&otherUpstreamLDAPIdentityProvider, &upstreamLDAPIdentityProvider).
+				Build(),
+			generateCSRF:  happyCSRFGenerator,
+			generatePKCE:  happyPKCEGenerator,
+			generateNonce: happyNonceGenerator,
+			stateEncoder:  happyStateEncoder,
+			cookieEncoder: happyCookieEncoder,
+			method:        http.MethodGet,
+			path: modifiedHappyGetRequestPath(map[string]string{
+				"pinniped_idp_name": "some-oidc-idp",
+				"pinniped_idp_type": "oidc",
+			}),
+			wantStatus:                  http.StatusFound,
+			wantContentType:             htmlContentType,
+			wantCSRFValueInCookieHeader: happyCSRF,
+			wantLocationHeader: expectedRedirectLocationForUpstreamOIDC(expectedUpstreamStateParam(map[string]string{
+				"pinniped_idp_name": "some-oidc-idp",
+				"pinniped_idp_type": "oidc",
+			}, "", ""), ""),
+			wantUpstreamStateParamInLocationHeader: true,
+			wantBodyStringWithLocationInHref:       true,
+		},
+		{
+			name: "OIDC upstream chosen by name only when multiple upstreams are configured",
+			idpLister: oidctestutil.NewUpstreamIDPListerBuilder().
+				WithOIDC(&otherUpstreamOIDCIdentityProvider, &upstreamOIDCIdentityProvider).
+				WithLDAP(&otherUpstreamLDAPIdentityProvider, &upstreamLDAPIdentityProvider).
+				Build(),
+			generateCSRF:                happyCSRFGenerator,
+			generatePKCE:                happyPKCEGenerator,
+			generateNonce:               happyNonceGenerator,
+			stateEncoder:                happyStateEncoder,
+			cookieEncoder:               happyCookieEncoder,
+			method:                      http.MethodGet,
+			path:                        modifiedHappyGetRequestPath(map[string]string{"pinniped_idp_name": "some-oidc-idp"}),
+			wantStatus:                  http.StatusFound,
+			wantContentType:             htmlContentType,
+			wantCSRFValueInCookieHeader: happyCSRF,
+			wantLocationHeader: expectedRedirectLocationForUpstreamOIDC(
+				expectedUpstreamStateParam(map[string]string{"pinniped_idp_name": "some-oidc-idp"}, "", ""), "",
+			),
+			wantUpstreamStateParamInLocationHeader: true,
+			wantBodyStringWithLocationInHref:       true,
+		},
+		{
+			name: "LDAP upstream chosen by name and type when multiple upstreams are configured",
+			idpLister: oidctestutil.NewUpstreamIDPListerBuilder().
+				WithOIDC(&otherUpstreamOIDCIdentityProvider, &upstreamOIDCIdentityProvider).
+				WithLDAP(&otherUpstreamLDAPIdentityProvider, &upstreamLDAPIdentityProvider).
+				Build(),
+			method: http.MethodGet,
+			path: modifiedHappyGetRequestPath(map[string]string{
+				"pinniped_idp_name": "some-ldap-idp",
+				"pinniped_idp_type": "ldap",
+			}),
+			customUsernameHeader:              pointer.StringPtr(happyLDAPUsername),
+			customPasswordHeader:              pointer.StringPtr(happyLDAPPassword),
+			wantStatus:                        http.StatusFound,
+			wantContentType:                   htmlContentType,
+			wantRedirectLocationRegexp:        happyAuthcodeDownstreamRedirectLocationRegexp,
+			wantDownstreamIDTokenSubject:      upstreamLDAPURL + "&sub=" + happyLDAPUID,
+			wantDownstreamIDTokenUsername:     happyLDAPUsernameFromAuthenticator,
+			wantDownstreamIDTokenGroups:       happyLDAPGroups,
+			wantDownstreamRequestedScopes:     happyDownstreamScopesRequested,
+			wantDownstreamRedirectURI:         downstreamRedirectURI,
+			wantDownstreamGrantedScopes:       happyDownstreamScopesGranted,
+			wantDownstreamNonce:               downstreamNonce,
+			wantDownstreamPKCEChallenge:       downstreamPKCEChallenge,
+			wantDownstreamPKCEChallengeMethod: downstreamPKCEChallengeMethod,
+			wantDownstreamCustomSessionData:   expectedHappyLDAPUpstreamCustomSession,
+		},
+		{
+			name: "Active Directory upstream chosen by name and type when multiple upstreams are configured",
+			idpLister: oidctestutil.NewUpstreamIDPListerBuilder().
+				WithOIDC(&otherUpstreamOIDCIdentityProvider, &upstreamOIDCIdentityProvider).
+				WithLDAP(&otherUpstreamLDAPIdentityProvider).
+				WithActiveDirectory(&upstreamLDAPIdentityProvider).
+				Build(),
+			method: http.MethodGet,
+			path: modifiedHappyGetRequestPath(map[string]string{
+				"pinniped_idp_name": "some-ldap-idp",
+				"pinniped_idp_type": "activedirectory",
+			}),
+			customUsernameHeader:              pointer.StringPtr(happyLDAPUsername),
+			customPasswordHeader:              pointer.StringPtr(happyLDAPPassword),
+			wantStatus:                        http.StatusFound,
+			wantContentType:                   htmlContentType,
+			wantRedirectLocationRegexp:        happyAuthcodeDownstreamRedirectLocationRegexp,
+			wantDownstreamIDTokenSubject:      upstreamLDAPURL + "&sub=" + happyLDAPUID,
+			wantDownstreamIDTokenUsername:     happyLDAPUsernameFromAuthenticator,
+			wantDownstreamIDTokenGroups:       happyLDAPGroups,
+			wantDownstreamRequestedScopes:     happyDownstreamScopesRequested,
+			wantDownstreamRedirectURI:         downstreamRedirectURI,
+			wantDownstreamGrantedScopes:       happyDownstreamScopesGranted,
+			wantDownstreamNonce:               downstreamNonce,
+			wantDownstreamPKCEChallenge:       downstreamPKCEChallenge,
+			wantDownstreamPKCEChallengeMethod: downstreamPKCEChallengeMethod,
+			wantDownstreamCustomSessionData:   expectedHappyActiveDirectoryUpstreamCustomSession,
+		},
+		{
+			name: "upstream name is shared by an OIDC and an LDAP upstream and the type was not specified",
+			idpLister: oidctestutil.NewUpstreamIDPListerBuilder().
+				WithOIDC(&upstreamOIDCIdentityProvider).
+				WithLDAP(&oidctestutil.TestUpstreamLDAPIdentityProvider{Name: upstreamOIDCIdentityProvider.Name}).
+				Build(),
+			method:          http.MethodGet,
+			path:            modifiedHappyGetRequestPath(map[string]string{"pinniped_idp_name": "some-oidc-idp"}),
+			wantStatus:      http.StatusUnprocessableEntity,
+			wantContentType: "text/plain; charset=utf-8",
+			wantBodyString:  "Unprocessable Entity: Multiple upstream providers have the requested name (the pinniped_idp_type param is required to choose one)\n",
+		},
+		{
+			name:      "requested upstream provider is not found",
+			idpLister: oidctestutil.NewUpstreamIDPListerBuilder().WithOIDC(&upstreamOIDCIdentityProvider).WithLDAP(&upstreamLDAPIdentityProvider).Build(),
+			method:    http.MethodGet,
+			path: modifiedHappyGetRequestPath(map[string]string{
+				"pinniped_idp_name": "some-oidc-idp",
+				"pinniped_idp_type": "ldap",
+			}),
+			wantStatus:      http.StatusUnprocessableEntity,
+			wantContentType: "text/plain; charset=utf-8",
+			wantBodyString:  "Unprocessable Entity: The requested upstream provider was not found\n",
+		},
+		{
+			name:      "requested upstream provider type is not recognized",
+			idpLister: oidctestutil.NewUpstreamIDPListerBuilder().WithOIDC(&upstreamOIDCIdentityProvider).Build(),
+			method:    http.MethodGet,
+			path: modifiedHappyGetRequestPath(map[string]string{
+				"pinniped_idp_name": "some-oidc-idp",
+				"pinniped_idp_type": "saml",
+			}),
+			wantStatus:      http.StatusUnprocessableEntity,
+			wantContentType: "text/plain; charset=utf-8",
+			wantBodyString:  "Unprocessable Entity: The pinniped_idp_type param is invalid (supported values: oidc, ldap, activedirectory)\n",
+		},
+		{
+			name:            "requested upstream provider type without a name",
+			idpLister:       oidctestutil.NewUpstreamIDPListerBuilder().WithOIDC(&upstreamOIDCIdentityProvider).Build(),
+			method:          http.MethodGet,
+			path:            modifiedHappyGetRequestPath(map[string]string{"pinniped_idp_type": "oidc"}),
+			wantStatus:      http.StatusUnprocessableEntity,
+			wantContentType: "text/plain; charset=utf-8",
+			wantBodyString:  "Unprocessable Entity: The pinniped_idp_name param must be specified when the pinniped_idp_type param is specified\n",
 		},
 		{
 			name:            "PUT is a bad method",
@@ -1128,11 +1698,18 @@ func TestAuthorizationEndpoint(t *testing.T) {
 		if test.customPasswordHeader != nil {
 			req.Header.Set("Pinniped-Password", *test.customPasswordHeader)
 		}
+		var auditEvents bytes.Buffer
+		auditlog.SetSink(&auditEvents)
+		defer auditlog.SetSink(nil)
 		rsp := httptest.NewRecorder()
 		subject.ServeHTTP(rsp, req)
 		t.Logf("response: %#v", rsp)
 		t.Logf("response body: %q", rsp.Body.String())
 
+		if test.wantAuditEvent != nil {
+			requireLastAuditEvent(t, auditEvents.String(), test.wantAuditEvent)
+		}
+
 		require.Equal(t, test.wantStatus, rsp.Code)
 		testutil.RequireEqualContentType(t, rsp.Header().Get("Content-Type"), test.wantContentType)
 		testutil.RequireSecurityHeaders(t, rsp)
@@ -1167,12 +1744,14 @@ func TestAuthorizationEndpoint(t *testing.T) {
 				test.wantDownstreamIDTokenSubject,
 				test.wantDownstreamIDTokenUsername,
 				test.wantDownstreamIDTokenGroups,
+				test.wantDownstreamAdditionalClaims,
 				test.wantDownstreamRequestedScopes,
 				test.wantDownstreamPKCEChallenge,
 				test.wantDownstreamPKCEChallengeMethod,
 				test.wantDownstreamNonce,
 				downstreamClientID,
 				test.wantDownstreamRedirectURI,
+				test.wantDownstreamCustomSessionData,
 			)
 		default:
 			require.Empty(t, rsp.Header().Values("Location"))
@@ -1213,6 +1792,7 @@ func TestAuthorizationEndpoint(t *testing.T) {
 			subject := NewHandler(
 				downstreamIssuer,
 				test.idpLister,
+				test.identityTransforms,
 				oauthHelperWithNullStorage, oauthHelperWithRealStorage,
 				test.generateCSRF, test.generatePKCE, test.generateNonce,
 				test.stateEncoder, test.cookieEncoder,
@@ -1231,6 +1811,7 @@ func TestAuthorizationEndpoint(t *testing.T) {
 		subject := NewHandler(
 			downstreamIssuer,
 			test.idpLister,
+			test.identityTransforms,
 			oauthHelperWithNullStorage, oauthHelperWithRealStorage,
 			test.generateCSRF, test.generatePKCE, test.generateNonce,
 			test.stateEncoder, test.cookieEncoder,
@@ -1265,6 +1846,13 @@ func TestAuthorizationEndpoint(t *testing.T) {
 			html.EscapeString(test.wantLocationHeader),
 			"\n\n",
 		)
+		test.wantAuditEvent = &auditlog.Event{
+			Type:            auditlog.EventTypeAuthorize,
+			Result:          auditlog.ResultSuccess,
+			ClientID:        downstreamClientID,
+			UpstreamIDPName: "some-other-idp",
+			UpstreamIDPType: "oidc",
+		}
 
 		// Run again on the same instance of the subject with the modified upstream IDP settings and the
 		// modified expectations. This should ensure that the implementation is using the in-memory cache
@@ -1335,3 +1923,16 @@ func requireEqualURLs(t *testing.T, actualURL string, expectedURL string, ignore
 	}
 	require.Equal(t, expectedLocationQuery, actualLocationQuery)
 }
+
+func requireLastAuditEvent(t *testing.T, auditEvents string, want *auditlog.Event) {
+	t.Helper()
+
+	lines := strings.Split(strings.TrimSpace(auditEvents), "\n")
+	var got auditlog.Event
+	require.NoError(t, json.Unmarshal([]byte(lines[len(lines)-1]), &got))
+	require.Equal(t, auditlog.Version, got.Version)
+	require.False(t, got.Timestamp.IsZero())
+	require.Equal(t, "192.0.2.1", got.SourceIP) // the default of httptest.NewRequest
+	got.Version, got.Timestamp, got.SourceIP = "", time.Time{}, ""
+	require.Equal(t, want, &got)
+}
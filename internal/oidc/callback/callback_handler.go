@@ -5,34 +5,32 @@
 package callback
 
 import (
-	"crypto/subtle"
-	"fmt"
+	"errors"
 	"net/http"
 	"net/url"
+	"time"
 
 	"github.com/ory/fosite"
 
+	"go.pinniped.dev/internal/auditlog"
+	"go.pinniped.dev/internal/fositestorage/devicecode"
 	"go.pinniped.dev/internal/httputil/httperr"
 	"go.pinniped.dev/internal/httputil/securityheader"
+	"go.pinniped.dev/internal/metrics"
 	"go.pinniped.dev/internal/oidc"
-	"go.pinniped.dev/internal/oidc/csrftoken"
 	"go.pinniped.dev/internal/oidc/downstreamsession"
 	"go.pinniped.dev/internal/oidc/provider"
+	"go.pinniped.dev/internal/oidc/provider/devicehtml"
 	"go.pinniped.dev/internal/oidc/provider/formposthtml"
 	"go.pinniped.dev/internal/plog"
-)
-
-const (
-	// The name of the email claim from https://openid.net/specs/openid-connect-core-1_0.html#StandardClaims
-	emailClaimName = "email"
-
-	// The name of the email_verified claim from https://openid.net/specs/openid-connect-core-1_0.html#StandardClaims
-	emailVerifiedClaimName = "email_verified"
+	"go.pinniped.dev/internal/psession"
 )
 
 func NewHandler(
 	upstreamIDPs oidc.UpstreamOIDCIdentityProvidersLister,
+	identityTransforms oidc.IdentityTransformsGetter,
 	oauthHelper fosite.OAuth2Provider,
+	deviceCodeStorage devicecode.DeviceCodeStorage,
 	stateDecoder, cookieDecoder oidc.Decoder,
 	redirectURI string,
 ) http.Handler {
@@ -42,12 +40,16 @@ func NewHandler(
 			return err
 		}
 
-		upstreamIDPConfig := findUpstreamIDPConfig(state.UpstreamName, upstreamIDPs)
+		upstreamIDPConfig := oidc.FindUpstreamOIDCIdentityProviderByNameAndType(state.UpstreamName, state.UpstreamType, upstreamIDPs)
 		if upstreamIDPConfig == nil {
 			plog.Warning("upstream provider not found")
 			return httperr.New(http.StatusUnprocessableEntity, "upstream provider not found")
 		}
 
+		if state.DeviceUserCode != "" {
+			return handleDeviceCallback(w, r, upstreamIDPConfig, identityTransforms, state, deviceCodeStorage, redirectURI)
+		}
+
 		downstreamAuthParams, err := url.ParseQuery(state.AuthParams)
 		if err != nil {
 			plog.Error("error reading state downstream auth params", err)
@@ -65,30 +67,16 @@ func NewHandler(
 		// Automatically grant the openid, offline_access, and pinniped:request-audience scopes, but only if they were requested.
 		downstreamsession.GrantScopesIfRequested(authorizeRequester)
 
-		token, err := upstreamIDPConfig.ExchangeAuthcodeAndValidateTokens(
-			r.Context(),
-			authcode(r),
-			state.PKCECode,
-			state.Nonce,
-			redirectURI,
-		)
-		if err != nil {
-			plog.WarningErr("error exchanging and validating upstream tokens", err, "upstreamName", upstreamIDPConfig.GetName())
-			return httperr.New(http.StatusBadGateway, "error exchanging and validating upstream tokens")
-		}
-
-		subject, username, err := getSubjectAndUsernameFromUpstreamIDToken(upstreamIDPConfig, token.IDToken.Claims)
-		if err != nil {
-			return err
+		openIDSession, err := makeDownstreamSession(r, upstreamIDPConfig, identityTransforms, state, redirectURI, authorizeRequester)
+		if errors.Is(err, fosite.ErrAccessDenied) {
+			plog.Info("authorize response error", oidc.FositeErrorForLog(err)...)
+			oauthHelper.WriteAuthorizeError(w, authorizeRequester, err)
+			return nil
 		}
-
-		groups, err := getGroupsFromUpstreamIDToken(upstreamIDPConfig, token.IDToken.Claims)
 		if err != nil {
 			return err
 		}
 
-		openIDSession := downstreamsession.MakeDownstreamSession(subject, username, groups)
-
 		authorizeResponder, err := oauthHelper.NewAuthorizeResponse(r.Context(), authorizeRequester, openIDSession)
 		if err != nil {
 			plog.WarningErr("error while generating and saving authcode", err, "upstreamName", upstreamIDPConfig.GetName())
@@ -102,246 +90,153 @@ func NewHandler(
 	return securityheader.WrapWithCustomCSP(handler, formposthtml.ContentSecurityPolicy())
 }
 
-func authcode(r *http.Request) string {
-	return r.FormValue("code")
-}
-
-func validateRequest(r *http.Request, stateDecoder, cookieDecoder oidc.Decoder) (*oidc.UpstreamStateParamData, error) {
-	if r.Method != http.MethodGet {
-		return nil, httperr.Newf(http.StatusMethodNotAllowed, "%s (try GET)", r.Method)
+// makeDownstreamSession exchanges the upstream authcode for tokens and creates the downstream session of the end user.
+// It records the result of the login for the client of the downstream request, whose ID also becomes the ID of the
+// downstream session. When the identity transforms reject the end user, it returns a fosite.ErrAccessDenied.
+func makeDownstreamSession(
+	r *http.Request,
+	upstreamIDPConfig provider.UpstreamOIDCIdentityProviderI,
+	identityTransforms oidc.IdentityTransformsGetter,
+	state *oidc.UpstreamStateParamData,
+	redirectURI string,
+	downstreamRequester fosite.Requester,
+) (*psession.PinnipedSession, error) {
+	event := auditlog.Event{
+		Type:            auditlog.EventTypeCallback,
+		ClientID:        downstreamRequester.GetClient().GetID(),
+		UpstreamIDPName: upstreamIDPConfig.GetName(),
+		UpstreamIDPType: oidc.IDPTypeOIDC,
+	}
+
+	token, err := upstreamIDPConfig.ExchangeAuthcodeAndValidateTokens(
+		r.Context(),
+		authcode(r),
+		state.PKCECode,
+		state.Nonce,
+		redirectURI,
+	)
+	if err != nil {
+		plog.WarningErr("error exchanging and validating upstream tokens", err, "upstreamName", upstreamIDPConfig.GetName())
+		metrics.RecordUpstreamLogin(oidc.IDPTypeOIDC, upstreamIDPConfig.GetName(), metrics.ResultError)
+		event.Result, event.Reason = auditlog.ResultError, "error exchanging and validating upstream tokens"
+		auditlog.Record(r, event)
+		return nil, httperr.New(http.StatusBadGateway, "error exchanging and validating upstream tokens")
 	}
 
-	csrfValue, err := readCSRFCookie(r, cookieDecoder)
+	subject, username, err := downstreamsession.GetSubjectAndUsernameFromUpstreamIDToken(upstreamIDPConfig, token.IDToken.Claims)
 	if err != nil {
-		plog.InfoErr("error reading CSRF cookie", err)
+		metrics.RecordUpstreamLogin(oidc.IDPTypeOIDC, upstreamIDPConfig.GetName(), metrics.ResultFailure)
+		event.Result, event.Reason = auditlog.ResultFailure, "upstream ID token claims not accepted"
+		auditlog.Record(r, event)
 		return nil, err
 	}
 
-	if authcode(r) == "" {
-		plog.Info("code param not found")
-		return nil, httperr.New(http.StatusBadRequest, "code param not found")
-	}
-
-	if r.FormValue("state") == "" {
-		plog.Info("state param not found")
-		return nil, httperr.New(http.StatusBadRequest, "state param not found")
-	}
-
-	state, err := readState(r, stateDecoder)
+	groups, err := downstreamsession.GetGroupsFromUpstreamIDToken(upstreamIDPConfig, token.IDToken.Claims)
 	if err != nil {
-		plog.InfoErr("error reading state", err)
+		metrics.RecordUpstreamLogin(oidc.IDPTypeOIDC, upstreamIDPConfig.GetName(), metrics.ResultFailure)
+		event.Result, event.Reason = auditlog.ResultFailure, "upstream ID token claims not accepted"
+		auditlog.Record(r, event)
 		return nil, err
 	}
 
-	if subtle.ConstantTimeCompare([]byte(state.CSRFToken), []byte(csrfValue)) != 1 {
-		plog.InfoErr("CSRF value does not match", err)
-		return nil, httperr.Wrap(http.StatusForbidden, "CSRF value does not match", err)
+	identity := identityTransforms.IdentityTransforms(oidc.IDPTypeOIDC, upstreamIDPConfig.GetName()).Evaluate(username, groups, token.IDToken.Claims)
+	if identity.Rejected {
+		plog.Info("login rejected by identity transforms",
+			"upstreamName", upstreamIDPConfig.GetName(), "reason", identity.RejectedReason)
+		metrics.RecordUpstreamLogin(oidc.IDPTypeOIDC, upstreamIDPConfig.GetName(), metrics.ResultFailure)
+		event.Result, event.Reason, event.Username = auditlog.ResultFailure, identity.RejectedReason, username
+		auditlog.Record(r, event)
+		return nil, fosite.ErrAccessDenied.WithHintf("Login was rejected: %s.", identity.RejectedReason)
 	}
 
-	return state, nil
-}
+	metrics.RecordUpstreamLogin(oidc.IDPTypeOIDC, upstreamIDPConfig.GetName(), metrics.ResultSuccess)
+	event.Result, event.Subject, event.Username, event.Groups = auditlog.ResultSuccess, subject, identity.Username, identity.Groups
+	auditlog.Record(r, event)
 
-func findUpstreamIDPConfig(upstreamName string, upstreamIDPs oidc.UpstreamOIDCIdentityProvidersLister) provider.UpstreamOIDCIdentityProviderI {
-	for _, p := range upstreamIDPs.GetOIDCIdentityProviders() {
-		if p.GetName() == upstreamName {
-			return p
-		}
+	// Remember the upstream refresh token, if there was one, so the session can be checked against the
+	// upstream again during downstream refreshes.
+	upstreamRefreshToken := ""
+	if token.RefreshToken != nil {
+		upstreamRefreshToken = token.RefreshToken.Token
 	}
-	return nil
-}
-
-func readCSRFCookie(r *http.Request, cookieDecoder oidc.Decoder) (csrftoken.CSRFToken, error) {
-	receivedCSRFCookie, err := r.Cookie(oidc.CSRFCookieName)
-	if err != nil {
-		// Error means that the cookie was not found
-		return "", httperr.Wrap(http.StatusForbidden, "CSRF cookie is missing", err)
+	customSessionData := &psession.CustomSessionData{
+		ProviderName: upstreamIDPConfig.GetName(),
+		ProviderType: oidc.IDPTypeOIDC,
+		OIDC: &psession.OIDCSessionData{
+			UpstreamRefreshToken: upstreamRefreshToken,
+		},
 	}
-
-	var csrfFromCookie csrftoken.CSRFToken
-	err = cookieDecoder.Decode(oidc.CSRFCookieEncodingName, receivedCSRFCookie.Value, &csrfFromCookie)
-	if err != nil {
-		return "", httperr.Wrap(http.StatusForbidden, "error reading CSRF cookie", err)
-	}
-
-	return csrfFromCookie, nil
-}
-
-func readState(r *http.Request, stateDecoder oidc.Decoder) (*oidc.UpstreamStateParamData, error) {
-	var state oidc.UpstreamStateParamData
-	if err := stateDecoder.Decode(
-		oidc.UpstreamStateParamEncodingName,
-		r.FormValue("state"),
-		&state,
-	); err != nil {
-		return nil, httperr.New(http.StatusBadRequest, "error reading state")
+	// Remember the upstream ID token when the end_session_endpoint will need it as the id_token_hint for the upstream.
+	if upstreamIDPConfig.GetEndSessionURL() != nil {
+		customSessionData.OIDC.UpstreamIDToken = token.IDToken.Token
 	}
 
-	if state.FormatVersion != oidc.UpstreamStateParamFormatVersion {
-		return nil, httperr.New(http.StatusUnprocessableEntity, "state format version is invalid")
-	}
+	additionalClaims := downstreamsession.GetAdditionalClaimsFromUpstreamIDToken(upstreamIDPConfig, token.IDToken.Claims)
 
-	return &state, nil
+	return downstreamsession.MakeDownstreamSession(downstreamRequester.GetID(), subject, identity.Username, identity.Groups, additionalClaims, customSessionData), nil
 }
 
-func getSubjectAndUsernameFromUpstreamIDToken(
+// handleDeviceCallback finishes a login which was started on the device verification page. Instead of issuing an
+// authcode, it approves the pending device authorization request, so the device can redeem its device code.
+func handleDeviceCallback(
+	w http.ResponseWriter,
+	r *http.Request,
 	upstreamIDPConfig provider.UpstreamOIDCIdentityProviderI,
-	idTokenClaims map[string]interface{},
-) (string, string, error) {
-	// The spec says the "sub" claim is only unique per issuer,
-	// so we will prepend the issuer string to make it globally unique.
-	upstreamIssuer := idTokenClaims[oidc.IDTokenIssuerClaim]
-	if upstreamIssuer == "" {
-		plog.Warning(
-			"issuer claim in upstream ID token missing",
-			"upstreamName", upstreamIDPConfig.GetName(),
-			"issClaim", upstreamIssuer,
-		)
-		return "", "", httperr.New(http.StatusUnprocessableEntity, "issuer claim in upstream ID token missing")
-	}
-	upstreamIssuerAsString, ok := upstreamIssuer.(string)
-	if !ok {
-		plog.Warning(
-			"issuer claim in upstream ID token has invalid format",
-			"upstreamName", upstreamIDPConfig.GetName(),
-			"issClaim", upstreamIssuer,
-		)
-		return "", "", httperr.New(http.StatusUnprocessableEntity, "issuer claim in upstream ID token has invalid format")
-	}
-
-	subjectAsInterface, ok := idTokenClaims[oidc.IDTokenSubjectClaim]
-	if !ok {
-		plog.Warning(
-			"no subject claim in upstream ID token",
-			"upstreamName", upstreamIDPConfig.GetName(),
-		)
-		return "", "", httperr.New(http.StatusUnprocessableEntity, "no subject claim in upstream ID token")
-	}
-
-	upstreamSubject, ok := subjectAsInterface.(string)
-	if !ok {
-		plog.Warning(
-			"subject claim in upstream ID token has invalid format",
-			"upstreamName", upstreamIDPConfig.GetName(),
-		)
-		return "", "", httperr.New(http.StatusUnprocessableEntity, "subject claim in upstream ID token has invalid format")
+	identityTransforms oidc.IdentityTransformsGetter,
+	state *oidc.UpstreamStateParamData,
+	deviceCodeStorage devicecode.DeviceCodeStorage,
+	redirectURI string,
+) error {
+	signature, deviceSession, err := deviceCodeStorage.GetDeviceCodeSessionByUserCode(r.Context(), state.DeviceUserCode)
+	if err != nil && !errors.Is(err, fosite.ErrNotFound) {
+		plog.Error("error reading device code session", err)
+		return httperr.Wrap(http.StatusInternalServerError, "error reading device code session", err)
 	}
-
-	subject := downstreamSubjectFromUpstreamOIDC(upstreamIssuerAsString, upstreamSubject)
-
-	usernameClaimName := upstreamIDPConfig.GetUsernameClaim()
-	if usernameClaimName == "" {
-		return subject, subject, nil
+	if err != nil || deviceSession.Status != devicecode.StatusPending || time.Now().After(deviceSession.ExpiresAt) {
+		plog.Info("device code session not found, already used, or expired")
+		return httperr.New(http.StatusUnprocessableEntity, "device authorization request not found, already used, or expired")
 	}
 
-	// If the upstream username claim is configured to be the special "email" claim and the upstream "email_verified"
-	// claim is present, then validate that the "email_verified" claim is true.
-	emailVerifiedAsInterface, ok := idTokenClaims[emailVerifiedClaimName]
-	if usernameClaimName == emailClaimName && ok {
-		emailVerified, ok := emailVerifiedAsInterface.(bool)
-		if !ok {
-			plog.Warning(
-				"username claim configured as \"email\" and upstream email_verified claim is not a boolean",
-				"upstreamName", upstreamIDPConfig.GetName(),
-				"configuredUsernameClaim", usernameClaimName,
-				"emailVerifiedClaim", emailVerifiedAsInterface,
-			)
-			return "", "", httperr.New(http.StatusUnprocessableEntity, "email_verified claim in upstream ID token has invalid format")
-		}
-		if !emailVerified {
-			plog.Warning(
-				"username claim configured as \"email\" and upstream email_verified claim has false value",
-				"upstreamName", upstreamIDPConfig.GetName(),
-				"configuredUsernameClaim", usernameClaimName,
-			)
-			return "", "", httperr.New(http.StatusUnprocessableEntity, "email_verified claim in upstream ID token has false value")
-		}
+	openIDSession, err := makeDownstreamSession(r, upstreamIDPConfig, identityTransforms, state, redirectURI, deviceSession.Request)
+	if errors.Is(err, fosite.ErrAccessDenied) {
+		return httperr.Wrap(http.StatusForbidden, "login was rejected", err)
 	}
-
-	usernameAsInterface, ok := idTokenClaims[usernameClaimName]
-	if !ok {
-		plog.Warning(
-			"no username claim in upstream ID token",
-			"upstreamName", upstreamIDPConfig.GetName(),
-			"configuredUsernameClaim", usernameClaimName,
-		)
-		return "", "", httperr.New(http.StatusUnprocessableEntity, "no username claim in upstream ID token")
+	if err != nil {
+		return err
 	}
 
-	username, ok := usernameAsInterface.(string)
-	if !ok {
-		plog.Warning(
-			"username claim in upstream ID token has invalid format",
-			"upstreamName", upstreamIDPConfig.GetName(),
-			"configuredUsernameClaim", usernameClaimName,
-		)
-		return "", "", httperr.New(http.StatusUnprocessableEntity, "username claim in upstream ID token has invalid format")
+	// Automatically grant the openid, offline_access, and pinniped:request-audience scopes, but only if they were requested.
+	downstreamsession.GrantScopesIfRequested(deviceSession.Request)
+	deviceSession.Request.SetSession(openIDSession)
+	deviceSession.Status = devicecode.StatusApproved
+	if err := deviceCodeStorage.UpdateDeviceCodeSession(r.Context(), signature, deviceSession); err != nil {
+		plog.WarningErr("error while approving device code session", err, "upstreamName", upstreamIDPConfig.GetName())
+		return httperr.Wrap(http.StatusInternalServerError, "error while approving device code session", err)
 	}
 
-	return subject, username, nil
+	w.Header().Set("Content-Security-Policy", devicehtml.ContentSecurityPolicy())
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	return devicehtml.Template().Execute(w, &devicehtml.PageData{Success: true})
 }
 
-func downstreamSubjectFromUpstreamOIDC(upstreamIssuerAsString string, upstreamSubject string) string {
-	return fmt.Sprintf("%s?%s=%s", upstreamIssuerAsString, oidc.IDTokenSubjectClaim, url.QueryEscape(upstreamSubject))
-}
-
-func getGroupsFromUpstreamIDToken(
-	upstreamIDPConfig provider.UpstreamOIDCIdentityProviderI,
-	idTokenClaims map[string]interface{},
-) ([]string, error) {
-	groupsClaimName := upstreamIDPConfig.GetGroupsClaim()
-	if groupsClaimName == "" {
-		return nil, nil
-	}
-
-	groupsAsInterface, ok := idTokenClaims[groupsClaimName]
-	if !ok {
-		plog.Warning(
-			"no groups claim in upstream ID token",
-			"upstreamName", upstreamIDPConfig.GetName(),
-			"configuredGroupsClaim", groupsClaimName,
-		)
-		return nil, nil // the upstream IDP may have omitted the claim if the user has no groups
-	}
-
-	groupsAsArray, okAsArray := extractGroups(groupsAsInterface)
-	if !okAsArray {
-		plog.Warning(
-			"groups claim in upstream ID token has invalid format",
-			"upstreamName", upstreamIDPConfig.GetName(),
-			"configuredGroupsClaim", groupsClaimName,
-		)
-		return nil, httperr.New(http.StatusUnprocessableEntity, "groups claim in upstream ID token has invalid format")
-	}
-
-	return groupsAsArray, nil
+func authcode(r *http.Request) string {
+	return r.FormValue("code")
 }
 
-func extractGroups(groupsAsInterface interface{}) ([]string, bool) {
-	groupsAsString, okAsString := groupsAsInterface.(string)
-	if okAsString {
-		return []string{groupsAsString}, true
-	}
-
-	groupsAsStringArray, okAsStringArray := groupsAsInterface.([]string)
-	if okAsStringArray {
-		return groupsAsStringArray, true
+func validateRequest(r *http.Request, stateDecoder, cookieDecoder oidc.Decoder) (*oidc.UpstreamStateParamData, error) {
+	if r.Method != http.MethodGet {
+		return nil, httperr.Newf(http.StatusMethodNotAllowed, "%s (try GET)", r.Method)
 	}
 
-	groupsAsInterfaceArray, okAsArray := groupsAsInterface.([]interface{})
-	if !okAsArray {
-		return nil, false
+	state, err := oidc.ReadUpstreamStateParamAndValidateCSRFCookie(r, stateDecoder, cookieDecoder)
+	if err != nil {
+		return nil, err
 	}
 
-	var groupsAsStrings []string
-	for _, groupAsInterface := range groupsAsInterfaceArray {
-		groupAsString, okAsString := groupAsInterface.(string)
-		if !okAsString {
-			return nil, false
-		}
-		if groupAsString != "" {
-			groupsAsStrings = append(groupsAsStrings, groupAsString)
-		}
+	if authcode(r) == "" {
+		plog.Info("code param not found")
+		return nil, httperr.New(http.StatusBadRequest, "code param not found")
 	}
 
-	return groupsAsStrings, true
+	return state, nil
 }
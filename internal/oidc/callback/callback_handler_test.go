@@ -4,20 +4,31 @@
 package callback
 
 import (
+	"bytes"
 	"context"
+	"encoding/json"
 	"errors"
 	"net/http"
 	"net/http/httptest"
 	"net/url"
 	"strings"
 	"testing"
+	"time"
 
 	"github.com/gorilla/securecookie"
+	"github.com/ory/fosite"
 	"github.com/stretchr/testify/require"
 	"k8s.io/client-go/kubernetes/fake"
 
+	configv1alpha1 "go.pinniped.dev/generated/latest/apis/supervisor/config/v1alpha1"
+	"go.pinniped.dev/internal/auditlog"
+	"go.pinniped.dev/internal/crud"
+	"go.pinniped.dev/internal/fositestorage/devicecode"
 	"go.pinniped.dev/internal/oidc"
+	"go.pinniped.dev/internal/oidc/clientregistry"
 	"go.pinniped.dev/internal/oidc/jwks"
+	"go.pinniped.dev/internal/oidc/provider/devicehtml"
+	"go.pinniped.dev/internal/psession"
 	"go.pinniped.dev/internal/testutil"
 	"go.pinniped.dev/internal/testutil/oidctestutil"
 	"go.pinniped.dev/pkg/oidcclient/nonce"
@@ -32,6 +43,8 @@ const (
 	upstreamSubject             = "abc123-some guid" // has a space character which should get escaped in URL
 	queryEscapedUpstreamSubject = "abc123-some+guid"
 	upstreamUsername            = "test-pinniped-username"
+	upstreamRefreshToken        = "test-upstream-refresh-token"
+	upstreamIDToken             = "test-upstream-id-token"
 
 	upstreamUsernameClaim = "the-user-claim"
 	upstreamGroupsClaim   = "the-groups-claim"
@@ -71,6 +84,14 @@ var (
 		"redirect_uri":          []string{downstreamRedirectURI},
 	}
 	happyDownstreamRequestParams = happyDownstreamRequestParamsQuery.Encode()
+
+	happyDownstreamCustomSessionData = &psession.CustomSessionData{
+		ProviderName: happyUpstreamIDPName,
+		ProviderType: "oidc",
+		OIDC: &psession.OIDCSessionData{
+			UpstreamRefreshToken: upstreamRefreshToken,
+		},
+	}
 )
 
 func TestCallbackEndpoint(t *testing.T) {
@@ -113,26 +134,33 @@ func TestCallbackEndpoint(t *testing.T) {
 	tests := []struct {
 		name string
 
-		idp        oidctestutil.TestUpstreamOIDCIdentityProvider
-		method     string
-		path       string
-		csrfCookie string
+		idp                oidctestutil.TestUpstreamOIDCIdentityProvider
+		identityTransforms oidctestutil.TestIdentityTransforms
+		method             string
+		path               string
+		csrfCookie         string
 
 		wantStatus                        int
 		wantContentType                   string
 		wantBody                          string
+		wantLocationHeader                string
 		wantRedirectLocationRegexp        string
 		wantBodyFormResponseRegexp        string
 		wantDownstreamGrantedScopes       []string
 		wantDownstreamIDTokenSubject      string
 		wantDownstreamIDTokenUsername     string
 		wantDownstreamIDTokenGroups       []string
+		wantDownstreamAdditionalClaims    map[string]interface{}
 		wantDownstreamRequestedScopes     []string
 		wantDownstreamNonce               string
 		wantDownstreamPKCEChallenge       string
 		wantDownstreamPKCEChallengeMethod string
+		wantDownstreamCustomSessionData   *psession.CustomSessionData
 
 		wantExchangeAndValidateTokensCall *oidctestutil.ExchangeAuthcodeAndValidateTokenArgs
+
+		// The audit event which was written, ignoring the fields which are filled in by the auditlog package.
+		wantAuditEvent *auditlog.Event
 	}{
 		{
 			name:   "GET with good state and cookie and successful upstream token exchange with response_mode=form_post returns 200 with HTML+JS form",
@@ -158,6 +186,7 @@ func TestCallbackEndpoint(t *testing.T) {
 			wantDownstreamNonce:               downstreamNonce,
 			wantDownstreamPKCEChallenge:       downstreamPKCEChallenge,
 			wantDownstreamPKCEChallengeMethod: downstreamPKCEChallengeMethod,
+			wantDownstreamCustomSessionData:   happyDownstreamCustomSessionData,
 			wantExchangeAndValidateTokensCall: happyExchangeAndValidateTokensArgs,
 		},
 		{
@@ -177,6 +206,192 @@ func TestCallbackEndpoint(t *testing.T) {
 			wantDownstreamNonce:               downstreamNonce,
 			wantDownstreamPKCEChallenge:       downstreamPKCEChallenge,
 			wantDownstreamPKCEChallengeMethod: downstreamPKCEChallengeMethod,
+			wantDownstreamCustomSessionData:   happyDownstreamCustomSessionData,
+			wantExchangeAndValidateTokensCall: happyExchangeAndValidateTokensArgs,
+			wantAuditEvent: &auditlog.Event{
+				Type:            auditlog.EventTypeCallback,
+				Result:          auditlog.ResultSuccess,
+				ClientID:        downstreamClientID,
+				UpstreamIDPName: happyUpstreamIDPName,
+				UpstreamIDPType: "oidc",
+				Subject:         upstreamIssuer + "?sub=" + queryEscapedUpstreamSubject,
+				Username:        upstreamUsername,
+				Groups:          upstreamGroupMembership,
+			},
+		},
+		{
+			name: "GET with good state and cookie and successful upstream token exchange maps the configured additional claims",
+			idp: happyUpstream().
+				WithAdditionalClaimMappings(map[string]string{"email": "email", "department": "missing-claim"}).
+				WithIDTokenClaim("email", "joe@whitehouse.gov").Build(),
+			method:                            http.MethodGet,
+			path:                              newRequestPath().WithState(happyState).String(),
+			csrfCookie:                        happyCSRFCookie,
+			wantStatus:                        http.StatusFound,
+			wantRedirectLocationRegexp:        happyDownstreamRedirectLocationRegexp,
+			wantBody:                          "",
+			wantDownstreamIDTokenSubject:      upstreamIssuer + "?sub=" + queryEscapedUpstreamSubject,
+			wantDownstreamIDTokenUsername:     upstreamUsername,
+			wantDownstreamIDTokenGroups:       upstreamGroupMembership,
+			wantDownstreamAdditionalClaims:    map[string]interface{}{"email": "joe@whitehouse.gov"},
+			wantDownstreamRequestedScopes:     happyDownstreamScopesRequested,
+			wantDownstreamGrantedScopes:       happyDownstreamScopesGranted,
+			wantDownstreamNonce:               downstreamNonce,
+			wantDownstreamPKCEChallenge:       downstreamPKCEChallenge,
+			wantDownstreamPKCEChallengeMethod: downstreamPKCEChallengeMethod,
+			wantDownstreamCustomSessionData:   happyDownstreamCustomSessionData,
+			wantExchangeAndValidateTokensCall: happyExchangeAndValidateTokensArgs,
+			wantAuditEvent: &auditlog.Event{
+				Type:            auditlog.EventTypeCallback,
+				Result:          auditlog.ResultSuccess,
+				ClientID:        downstreamClientID,
+				UpstreamIDPName: happyUpstreamIDPName,
+				UpstreamIDPType: "oidc",
+				Subject:         upstreamIssuer + "?sub=" + queryEscapedUpstreamSubject,
+				Username:        upstreamUsername,
+				Groups:          upstreamGroupMembership,
+			},
+		},
+		{
+			name: "GET with good state and cookie and successful upstream token exchange applies the identity transforms",
+			idp:  happyUpstream().Build(),
+			identityTransforms: oidctestutil.NewIdentityTransforms(t, happyUpstreamIDPName,
+				configv1alpha1.FederationDomainTransform{Type: configv1alpha1.GroupsPrefixFederationDomainTransformType, Prefix: "okta:"},
+				configv1alpha1.FederationDomainTransform{Type: configv1alpha1.RequireGroupFederationDomainTransformType, Groups: []string{"okta:test-pinniped-group-1"}},
+			),
+			method:                            http.MethodGet,
+			path:                              newRequestPath().WithState(happyState).String(),
+			csrfCookie:                        happyCSRFCookie,
+			wantStatus:                        http.StatusFound,
+			wantRedirectLocationRegexp:        happyDownstreamRedirectLocationRegexp,
+			wantBody:                          "",
+			wantDownstreamIDTokenSubject:      upstreamIssuer + "?sub=" + queryEscapedUpstreamSubject,
+			wantDownstreamIDTokenUsername:     upstreamUsername,
+			wantDownstreamIDTokenGroups:       []string{"okta:test-pinniped-group-0", "okta:test-pinniped-group-1"},
+			wantDownstreamRequestedScopes:     happyDownstreamScopesRequested,
+			wantDownstreamGrantedScopes:       happyDownstreamScopesGranted,
+			wantDownstreamNonce:               downstreamNonce,
+			wantDownstreamPKCEChallenge:       downstreamPKCEChallenge,
+			wantDownstreamPKCEChallengeMethod: downstreamPKCEChallengeMethod,
+			wantDownstreamCustomSessionData:   happyDownstreamCustomSessionData,
+			wantExchangeAndValidateTokensCall: happyExchangeAndValidateTokensArgs,
+			wantAuditEvent: &auditlog.Event{
+				Type:            auditlog.EventTypeCallback,
+				Result:          auditlog.ResultSuccess,
+				ClientID:        downstreamClientID,
+				UpstreamIDPName: happyUpstreamIDPName,
+				UpstreamIDPType: "oidc",
+				Subject:         upstreamIssuer + "?sub=" + queryEscapedUpstreamSubject,
+				Username:        upstreamUsername,
+				Groups:          []string{"okta:test-pinniped-group-0", "okta:test-pinniped-group-1"},
+			},
+		},
+		{
+			name: "user rejected by the identity transforms is redirected to the downstream client callback with an access_denied error",
+			idp:  happyUpstream().Build(),
+			identityTransforms: oidctestutil.NewIdentityTransforms(t, happyUpstreamIDPName,
+				configv1alpha1.FederationDomainTransform{Type: configv1alpha1.RequireGroupFederationDomainTransformType, Groups: []string{"k8s-users"}},
+			),
+			method:          http.MethodGet,
+			path:            newRequestPath().WithState(happyState).String(),
+			csrfCookie:      happyCSRFCookie,
+			wantStatus:      http.StatusFound,
+			wantContentType: "application/json; charset=utf-8",
+			wantLocationHeader: downstreamRedirectURI + "?" + url.Values{
+				"error":             []string{"access_denied"},
+				"error_description": []string{"The resource owner or authorization server denied the request. Login was rejected: user is not a member of any of the required groups."},
+				"state":             []string{happyDownstreamState},
+			}.Encode(),
+			wantExchangeAndValidateTokensCall: happyExchangeAndValidateTokensArgs,
+			wantAuditEvent: &auditlog.Event{
+				Type:            auditlog.EventTypeCallback,
+				Result:          auditlog.ResultFailure,
+				Reason:          "user is not a member of any of the required groups",
+				ClientID:        downstreamClientID,
+				UpstreamIDPName: happyUpstreamIDPName,
+				UpstreamIDPType: "oidc",
+				Username:        upstreamUsername,
+			},
+		},
+		{
+			name: "user denied by the login policy is redirected to the downstream client callback with the configured message",
+			idp:  happyUpstream().WithIDTokenClaim("email", "joe@contractor.com").Build(),
+			identityTransforms: oidctestutil.NewIdentityPolicy(t, happyUpstreamIDPName, configv1alpha1.FederationDomainPolicy{
+				DenyRules: []configv1alpha1.FederationDomainDenyRule{{
+					Match: configv1alpha1.FederationDomainPolicyMatch{
+						NotInAnyGroup: []string{"approved-contractors"},
+						Claims:        []configv1alpha1.FederationDomainClaimMatch{{Name: "email", Regex: `@contractor\.com$`}},
+					},
+					Message: "Contractors must be approved before they can log in.",
+				}},
+			}),
+			method:          http.MethodGet,
+			path:            newRequestPath().WithState(happyState).String(),
+			csrfCookie:      happyCSRFCookie,
+			wantStatus:      http.StatusFound,
+			wantContentType: "application/json; charset=utf-8",
+			wantLocationHeader: downstreamRedirectURI + "?" + url.Values{
+				"error":             []string{"access_denied"},
+				"error_description": []string{"The resource owner or authorization server denied the request. Login was rejected: Contractors must be approved before they can log in."},
+				"state":             []string{happyDownstreamState},
+			}.Encode(),
+			wantExchangeAndValidateTokensCall: happyExchangeAndValidateTokensArgs,
+			wantAuditEvent: &auditlog.Event{
+				Type:            auditlog.EventTypeCallback,
+				Result:          auditlog.ResultFailure,
+				Reason:          "Contractors must be approved before they can log in",
+				ClientID:        downstreamClientID,
+				UpstreamIDPName: happyUpstreamIDPName,
+				UpstreamIDPType: "oidc",
+				Username:        upstreamUsername,
+			},
+		},
+		{
+			name:                              "upstream IDP does not return a refresh token, so none is remembered in the downstream session",
+			idp:                               happyUpstream().WithoutRefreshToken().Build(),
+			method:                            http.MethodGet,
+			path:                              newRequestPath().WithState(happyState).String(),
+			csrfCookie:                        happyCSRFCookie,
+			wantStatus:                        http.StatusFound,
+			wantRedirectLocationRegexp:        happyDownstreamRedirectLocationRegexp,
+			wantBody:                          "",
+			wantDownstreamIDTokenSubject:      upstreamIssuer + "?sub=" + queryEscapedUpstreamSubject,
+			wantDownstreamIDTokenUsername:     upstreamUsername,
+			wantDownstreamIDTokenGroups:       upstreamGroupMembership,
+			wantDownstreamRequestedScopes:     happyDownstreamScopesRequested,
+			wantDownstreamGrantedScopes:       happyDownstreamScopesGranted,
+			wantDownstreamNonce:               downstreamNonce,
+			wantDownstreamPKCEChallenge:       downstreamPKCEChallenge,
+			wantDownstreamPKCEChallengeMethod: downstreamPKCEChallengeMethod,
+			wantDownstreamCustomSessionData: &psession.CustomSessionData{
+				ProviderName: happyUpstreamIDPName,
+				ProviderType: "oidc",
+				OIDC:         &psession.OIDCSessionData{UpstreamRefreshToken: ""},
+			},
+			wantExchangeAndValidateTokensCall: happyExchangeAndValidateTokensArgs,
+		},
+		{
+			name:                              "upstream session is ended on logout, so the upstream ID token is remembered in the downstream session",
+			idp:                               happyUpstream().WithEndSessionURL("https://some-upstream.com/logout").Build(),
+			method:                            http.MethodGet,
+			path:                              newRequestPath().WithState(happyState).String(),
+			csrfCookie:                        happyCSRFCookie,
+			wantStatus:                        http.StatusFound,
+			wantRedirectLocationRegexp:        happyDownstreamRedirectLocationRegexp,
+			wantBody:                          "",
+			wantDownstreamIDTokenSubject:      upstreamIssuer + "?sub=" + queryEscapedUpstreamSubject,
+			wantDownstreamIDTokenUsername:     upstreamUsername,
+			wantDownstreamIDTokenGroups:       upstreamGroupMembership,
+			wantDownstreamRequestedScopes:     happyDownstreamScopesRequested,
+			wantDownstreamGrantedScopes:       happyDownstreamScopesGranted,
+			wantDownstreamNonce:               downstreamNonce,
+			wantDownstreamPKCEChallenge:       downstreamPKCEChallenge,
+			wantDownstreamPKCEChallengeMethod: downstreamPKCEChallengeMethod,
+			wantDownstreamCustomSessionData: &psession.CustomSessionData{
+				ProviderName: happyUpstreamIDPName,
+				ProviderType: "oidc",
+				OIDC:         &psession.OIDCSessionData{UpstreamRefreshToken: upstreamRefreshToken, UpstreamIDToken: upstreamIDToken},
+			},
 			wantExchangeAndValidateTokensCall: happyExchangeAndValidateTokensArgs,
 		},
 		{
@@ -196,6 +411,7 @@ func TestCallbackEndpoint(t *testing.T) {
 			wantDownstreamNonce:               downstreamNonce,
 			wantDownstreamPKCEChallenge:       downstreamPKCEChallenge,
 			wantDownstreamPKCEChallengeMethod: downstreamPKCEChallengeMethod,
+			wantDownstreamCustomSessionData:   happyDownstreamCustomSessionData,
 			wantExchangeAndValidateTokensCall: happyExchangeAndValidateTokensArgs,
 		},
 		{
@@ -216,6 +432,7 @@ func TestCallbackEndpoint(t *testing.T) {
 			wantDownstreamNonce:               downstreamNonce,
 			wantDownstreamPKCEChallenge:       downstreamPKCEChallenge,
 			wantDownstreamPKCEChallengeMethod: downstreamPKCEChallengeMethod,
+			wantDownstreamCustomSessionData:   happyDownstreamCustomSessionData,
 			wantExchangeAndValidateTokensCall: happyExchangeAndValidateTokensArgs,
 		},
 		{
@@ -237,6 +454,7 @@ func TestCallbackEndpoint(t *testing.T) {
 			wantDownstreamNonce:               downstreamNonce,
 			wantDownstreamPKCEChallenge:       downstreamPKCEChallenge,
 			wantDownstreamPKCEChallengeMethod: downstreamPKCEChallengeMethod,
+			wantDownstreamCustomSessionData:   happyDownstreamCustomSessionData,
 			wantExchangeAndValidateTokensCall: happyExchangeAndValidateTokensArgs,
 		},
 		{
@@ -259,6 +477,7 @@ func TestCallbackEndpoint(t *testing.T) {
 			wantDownstreamNonce:               downstreamNonce,
 			wantDownstreamPKCEChallenge:       downstreamPKCEChallenge,
 			wantDownstreamPKCEChallengeMethod: downstreamPKCEChallengeMethod,
+			wantDownstreamCustomSessionData:   happyDownstreamCustomSessionData,
 			wantExchangeAndValidateTokensCall: happyExchangeAndValidateTokensArgs,
 		},
 		{
@@ -304,6 +523,7 @@ func TestCallbackEndpoint(t *testing.T) {
 			wantDownstreamNonce:               downstreamNonce,
 			wantDownstreamPKCEChallenge:       downstreamPKCEChallenge,
 			wantDownstreamPKCEChallengeMethod: downstreamPKCEChallengeMethod,
+			wantDownstreamCustomSessionData:   happyDownstreamCustomSessionData,
 			wantExchangeAndValidateTokensCall: happyExchangeAndValidateTokensArgs,
 		},
 		{
@@ -323,6 +543,7 @@ func TestCallbackEndpoint(t *testing.T) {
 			wantDownstreamNonce:               downstreamNonce,
 			wantDownstreamPKCEChallenge:       downstreamPKCEChallenge,
 			wantDownstreamPKCEChallengeMethod: downstreamPKCEChallengeMethod,
+			wantDownstreamCustomSessionData:   happyDownstreamCustomSessionData,
 			wantExchangeAndValidateTokensCall: happyExchangeAndValidateTokensArgs,
 		},
 		{
@@ -342,6 +563,7 @@ func TestCallbackEndpoint(t *testing.T) {
 			wantDownstreamNonce:               downstreamNonce,
 			wantDownstreamPKCEChallenge:       downstreamPKCEChallenge,
 			wantDownstreamPKCEChallengeMethod: downstreamPKCEChallengeMethod,
+			wantDownstreamCustomSessionData:   happyDownstreamCustomSessionData,
 			wantExchangeAndValidateTokensCall: happyExchangeAndValidateTokensArgs,
 		},
 
@@ -479,6 +701,7 @@ func TestCallbackEndpoint(t *testing.T) {
 			wantDownstreamNonce:               downstreamNonce,
 			wantDownstreamPKCEChallenge:       downstreamPKCEChallenge,
 			wantDownstreamPKCEChallengeMethod: downstreamPKCEChallengeMethod,
+			wantDownstreamCustomSessionData:   happyDownstreamCustomSessionData,
 			wantExchangeAndValidateTokensCall: happyExchangeAndValidateTokensArgs,
 		},
 		{
@@ -502,6 +725,7 @@ func TestCallbackEndpoint(t *testing.T) {
 			wantDownstreamNonce:               downstreamNonce,
 			wantDownstreamPKCEChallenge:       downstreamPKCEChallenge,
 			wantDownstreamPKCEChallengeMethod: downstreamPKCEChallengeMethod,
+			wantDownstreamCustomSessionData:   happyDownstreamCustomSessionData,
 			wantExchangeAndValidateTokensCall: happyExchangeAndValidateTokensArgs,
 		},
 		{
@@ -514,6 +738,36 @@ func TestCallbackEndpoint(t *testing.T) {
 			wantContentType: htmlContentType,
 			wantBody:        "Unprocessable Entity: upstream provider not found\n",
 		},
+		{
+			name:                              "GET with good state which has no upstream type, because it was issued by an older version, uses the OIDC upstream",
+			idp:                               happyUpstream().Build(),
+			method:                            http.MethodGet,
+			path:                              newRequestPath().WithState(happyUpstreamStateParam().WithUpstreamType("").Build(t, happyStateCodec)).String(),
+			csrfCookie:                        happyCSRFCookie,
+			wantStatus:                        http.StatusFound,
+			wantRedirectLocationRegexp:        happyDownstreamRedirectLocationRegexp,
+			wantBody:                          "",
+			wantDownstreamIDTokenSubject:      upstreamIssuer + "?sub=" + queryEscapedUpstreamSubject,
+			wantDownstreamIDTokenUsername:     upstreamUsername,
+			wantDownstreamIDTokenGroups:       upstreamGroupMembership,
+			wantDownstreamRequestedScopes:     happyDownstreamScopesRequested,
+			wantDownstreamGrantedScopes:       happyDownstreamScopesGranted,
+			wantDownstreamNonce:               downstreamNonce,
+			wantDownstreamPKCEChallenge:       downstreamPKCEChallenge,
+			wantDownstreamPKCEChallengeMethod: downstreamPKCEChallengeMethod,
+			wantDownstreamCustomSessionData:   happyDownstreamCustomSessionData,
+			wantExchangeAndValidateTokensCall: happyExchangeAndValidateTokensArgs,
+		},
+		{
+			name:            "the state param refers to an upstream of a type which does not use the callback endpoint",
+			idp:             happyUpstream().Build(),
+			method:          http.MethodGet,
+			path:            newRequestPath().WithState(happyUpstreamStateParam().WithUpstreamType("ldap").Build(t, happyStateCodec)).String(),
+			csrfCookie:      happyCSRFCookie,
+			wantStatus:      http.StatusUnprocessableEntity,
+			wantContentType: htmlContentType,
+			wantBody:        "Unprocessable Entity: upstream provider not found\n",
+		},
 		{
 			name:            "the CSRF cookie does not exist on request",
 			idp:             happyUpstream().Build(),
@@ -555,6 +809,14 @@ func TestCallbackEndpoint(t *testing.T) {
 			wantBody:                          "Bad Gateway: error exchanging and validating upstream tokens\n",
 			wantContentType:                   htmlContentType,
 			wantExchangeAndValidateTokensCall: happyExchangeAndValidateTokensArgs,
+			wantAuditEvent: &auditlog.Event{
+				Type:            auditlog.EventTypeCallback,
+				Result:          auditlog.ResultError,
+				Reason:          "error exchanging and validating upstream tokens",
+				ClientID:        downstreamClientID,
+				UpstreamIDPName: happyUpstreamIDPName,
+				UpstreamIDPType: "oidc",
+			},
 		},
 		{
 			name:                              "upstream ID token does not contain requested username claim",
@@ -584,6 +846,7 @@ func TestCallbackEndpoint(t *testing.T) {
 			wantDownstreamNonce:               downstreamNonce,
 			wantDownstreamPKCEChallenge:       downstreamPKCEChallenge,
 			wantDownstreamPKCEChallengeMethod: downstreamPKCEChallengeMethod,
+			wantDownstreamCustomSessionData:   happyDownstreamCustomSessionData,
 			wantExchangeAndValidateTokensCall: happyExchangeAndValidateTokensArgs,
 		},
 		{
@@ -663,18 +926,21 @@ func TestCallbackEndpoint(t *testing.T) {
 			// Configure fosite the same way that the production code would.
 			// Inject this into our test subject at the last second so we get a fresh storage for every test.
 			timeoutsConfiguration := oidc.DefaultOIDCTimeoutsConfiguration()
-			oauthStore := oidc.NewKubeStorage(secrets, timeoutsConfiguration)
+			oauthStore := oidc.NewKubeStorage(crud.NewSecretsBackend(secrets), &clientregistry.StaticClientManager{}, timeoutsConfiguration)
 			hmacSecretFunc := func() []byte { return []byte("some secret - must have at least 32 bytes") }
 			require.GreaterOrEqual(t, len(hmacSecretFunc()), 32, "fosite requires that hmac secrets have at least 32 bytes")
 			jwksProviderIsUnused := jwks.NewDynamicJWKSProvider()
 			oauthHelper := oidc.FositeOauth2Helper(oauthStore, downstreamIssuer, hmacSecretFunc, jwksProviderIsUnused, timeoutsConfiguration)
 
 			idpLister := oidctestutil.NewUpstreamIDPListerBuilder().WithOIDC(&test.idp).Build()
-			subject := NewHandler(idpLister, oauthHelper, happyStateCodec, happyCookieCodec, happyUpstreamRedirectURI)
+			subject := NewHandler(idpLister, test.identityTransforms, oauthHelper, oauthStore, happyStateCodec, happyCookieCodec, happyUpstreamRedirectURI)
 			req := httptest.NewRequest(test.method, test.path, nil)
 			if test.csrfCookie != "" {
 				req.Header.Set("Cookie", test.csrfCookie)
 			}
+			var auditEvents bytes.Buffer
+			auditlog.SetSink(&auditEvents)
+			defer auditlog.SetSink(nil)
 			rsp := httptest.NewRecorder()
 			subject.ServeHTTP(rsp, req)
 			t.Logf("response: %#v", rsp)
@@ -682,6 +948,13 @@ func TestCallbackEndpoint(t *testing.T) {
 
 			testutil.RequireSecurityHeaders(t, rsp)
 
+			if test.wantAuditEvent != nil {
+				var got auditlog.Event
+				require.NoError(t, json.Unmarshal(auditEvents.Bytes(), &got))
+				got.Version, got.Timestamp, got.SourceIP = "", time.Time{}, ""
+				require.Equal(t, test.wantAuditEvent, &got)
+			}
+
 			if test.wantExchangeAndValidateTokensCall != nil {
 				require.Equal(t, 1, test.idp.ExchangeAuthcodeAndValidateTokensCallCount())
 				test.wantExchangeAndValidateTokensCall.Ctx = req.Context()
@@ -711,12 +984,14 @@ func TestCallbackEndpoint(t *testing.T) {
 					test.wantDownstreamIDTokenSubject,
 					test.wantDownstreamIDTokenUsername,
 					test.wantDownstreamIDTokenGroups,
+					test.wantDownstreamAdditionalClaims,
 					test.wantDownstreamRequestedScopes,
 					test.wantDownstreamPKCEChallenge,
 					test.wantDownstreamPKCEChallengeMethod,
 					test.wantDownstreamNonce,
 					downstreamClientID,
 					downstreamRedirectURI,
+					test.wantDownstreamCustomSessionData,
 				)
 
 			// Otherwise, expect an empty response body.
@@ -724,6 +999,10 @@ func TestCallbackEndpoint(t *testing.T) {
 				require.Empty(t, rsp.Body.String())
 			}
 
+			if test.wantLocationHeader != "" {
+				require.Equal(t, test.wantLocationHeader, rsp.Header().Get("Location"))
+			}
+
 			if test.wantRedirectLocationRegexp != "" { //nolint:nestif // don't mind have several sequential if statements in this test
 				require.Len(t, rsp.Header().Values("Location"), 1)
 				oidctestutil.RequireAuthCodeRegexpMatch(
@@ -737,18 +1016,179 @@ func TestCallbackEndpoint(t *testing.T) {
 					test.wantDownstreamIDTokenSubject,
 					test.wantDownstreamIDTokenUsername,
 					test.wantDownstreamIDTokenGroups,
+					test.wantDownstreamAdditionalClaims,
 					test.wantDownstreamRequestedScopes,
 					test.wantDownstreamPKCEChallenge,
 					test.wantDownstreamPKCEChallengeMethod,
 					test.wantDownstreamNonce,
 					downstreamClientID,
 					downstreamRedirectURI,
+					test.wantDownstreamCustomSessionData,
 				)
 			}
 		})
 	}
 }
 
+func TestCallbackEndpointForDeviceFlow(t *testing.T) {
+	const happyUserCode = "BCDFGHJK"
+
+	var happyStateCodec = securecookie.New([]byte("fake-hash-secret"), []byte("0123456789ABCDEF"))
+	happyStateCodec.SetSerializer(securecookie.JSONEncoder{})
+	var happyCookieCodec = securecookie.New([]byte("fake-hash-secret2"), []byte("0123456789ABCDE2"))
+	happyCookieCodec.SetSerializer(securecookie.JSONEncoder{})
+
+	encodedIncomingCookieCSRFValue, err := happyCookieCodec.Encode("csrf", happyDownstreamCSRF)
+	require.NoError(t, err)
+	happyCSRFCookie := "__Host-pinniped-csrf=" + encodedIncomingCookieCSRFValue
+
+	happyDeviceState := happyUpstreamStateParam().WithAuthorizeRequestParams("").WithDeviceUserCode(happyUserCode).Build(t, happyStateCodec)
+
+	newDeviceSession := func(modify func(*devicecode.Session)) *devicecode.Session {
+		session := &devicecode.Session{
+			Request: &fosite.Request{
+				ID: "some-request-id",
+				Client: &clientregistry.Client{DefaultOpenIDConnectClient: fosite.DefaultOpenIDConnectClient{
+					DefaultClient: &fosite.DefaultClient{ID: downstreamClientID, Public: true},
+				}},
+				RequestedScope: fosite.Arguments{"openid", "offline_access", "some-other-scope"},
+				Session:        psession.NewPinnipedSession(),
+			},
+			Status:       devicecode.StatusPending,
+			UserCode:     happyUserCode,
+			ExpiresAt:    time.Now().Add(time.Minute),
+			UpstreamName: happyUpstreamIDPName,
+			UpstreamType: "oidc",
+		}
+		if modify != nil {
+			modify(session)
+		}
+		return session
+	}
+
+	successPage := func(t *testing.T) string {
+		var buf strings.Builder
+		require.NoError(t, devicehtml.Template().Execute(&buf, &devicehtml.PageData{Success: true}))
+		return buf.String()
+	}
+
+	tests := []struct {
+		name string
+
+		idp                oidctestutil.TestUpstreamOIDCIdentityProvider
+		identityTransforms oidctestutil.TestIdentityTransforms
+		session            *devicecode.Session
+
+		wantStatus      int
+		wantContentType string
+		wantBody        string
+		wantApproved    bool
+	}{
+		{
+			name:            "successful upstream login approves the device authorization request",
+			idp:             happyUpstream().Build(),
+			session:         newDeviceSession(nil),
+			wantStatus:      http.StatusOK,
+			wantContentType: htmlContentType,
+			wantBody:        successPage(t),
+			wantApproved:    true,
+		},
+		{
+			name:            "device authorization request was not found",
+			idp:             happyUpstream().Build(),
+			wantStatus:      http.StatusUnprocessableEntity,
+			wantContentType: htmlContentType,
+			wantBody:        "Unprocessable Entity: device authorization request not found, already used, or expired\n",
+		},
+		{
+			name:            "device authorization request was already approved",
+			idp:             happyUpstream().Build(),
+			session:         newDeviceSession(func(s *devicecode.Session) { s.Status = devicecode.StatusApproved }),
+			wantStatus:      http.StatusUnprocessableEntity,
+			wantContentType: htmlContentType,
+			wantBody:        "Unprocessable Entity: device authorization request not found, already used, or expired\n",
+		},
+		{
+			name:            "device authorization request has expired",
+			idp:             happyUpstream().Build(),
+			session:         newDeviceSession(func(s *devicecode.Session) { s.ExpiresAt = time.Now().Add(-time.Second) }),
+			wantStatus:      http.StatusUnprocessableEntity,
+			wantContentType: htmlContentType,
+			wantBody:        "Unprocessable Entity: device authorization request not found, already used, or expired\n",
+		},
+		{
+			name:            "upstream token exchange fails",
+			idp:             happyUpstream().WithoutUpstreamAuthcodeExchangeError(errors.New("some error")).Build(),
+			session:         newDeviceSession(nil),
+			wantStatus:      http.StatusBadGateway,
+			wantContentType: htmlContentType,
+			wantBody:        "Bad Gateway: error exchanging and validating upstream tokens\n",
+		},
+		{
+			name: "user rejected by the identity transforms does not approve the device authorization request",
+			idp:  happyUpstream().Build(),
+			identityTransforms: oidctestutil.NewIdentityTransforms(t, happyUpstreamIDPName,
+				configv1alpha1.FederationDomainTransform{Type: configv1alpha1.RequireGroupFederationDomainTransformType, Groups: []string{"k8s-users"}},
+			),
+			session:         newDeviceSession(nil),
+			wantStatus:      http.StatusForbidden,
+			wantContentType: htmlContentType,
+			wantBody:        "Forbidden: login was rejected\n",
+		},
+	}
+	for _, test := range tests {
+		test := test
+
+		t.Run(test.name, func(t *testing.T) {
+			secrets := fake.NewSimpleClientset().CoreV1().Secrets("some-namespace")
+			timeoutsConfiguration := oidc.DefaultOIDCTimeoutsConfiguration()
+			oauthStore := oidc.NewKubeStorage(crud.NewSecretsBackend(secrets), &clientregistry.StaticClientManager{}, timeoutsConfiguration)
+			hmacSecretFunc := func() []byte { return []byte("some secret - must have at least 32 bytes") }
+			oauthHelper := oidc.FositeOauth2Helper(oauthStore, downstreamIssuer, hmacSecretFunc, jwks.NewDynamicJWKSProvider(), timeoutsConfiguration)
+
+			if test.session != nil {
+				require.NoError(t, oauthStore.CreateDeviceCodeSession(context.Background(), "some-signature", test.session))
+			}
+
+			idpLister := oidctestutil.NewUpstreamIDPListerBuilder().WithOIDC(&test.idp).Build()
+			subject := NewHandler(idpLister, test.identityTransforms, oauthHelper, oauthStore, happyStateCodec, happyCookieCodec, happyUpstreamRedirectURI)
+			req := httptest.NewRequest(http.MethodGet, newRequestPath().WithState(happyDeviceState).String(), nil)
+			req.Header.Set("Cookie", happyCSRFCookie)
+			rsp := httptest.NewRecorder()
+			subject.ServeHTTP(rsp, req)
+			t.Logf("response: %#v", rsp)
+			t.Logf("response body: %q", rsp.Body.String())
+
+			testutil.RequireSecurityHeaders(t, rsp)
+			require.Equal(t, test.wantStatus, rsp.Code)
+			testutil.RequireEqualContentType(t, rsp.Header().Get("Content-Type"), test.wantContentType)
+			require.Equal(t, test.wantBody, rsp.Body.String())
+			require.Empty(t, rsp.Header().Values("Location"))
+
+			if test.session == nil {
+				return
+			}
+			session, err := oauthStore.GetDeviceCodeSession(context.Background(), "some-signature")
+			require.NoError(t, err)
+			if !test.wantApproved {
+				require.Equal(t, test.session.Status, session.Status)
+				require.Empty(t, session.Request.GetGrantedScopes())
+				return
+			}
+
+			require.Equal(t, devicehtml.ContentSecurityPolicy(), rsp.Header().Get("Content-Security-Policy"))
+			require.Equal(t, devicecode.StatusApproved, session.Status)
+			require.Equal(t, fosite.Arguments{"openid", "offline_access"}, session.Request.GetGrantedScopes())
+			pinnipedSession, ok := session.Request.GetSession().(*psession.PinnipedSession)
+			require.True(t, ok)
+			require.Equal(t, upstreamIssuer+"?sub="+queryEscapedUpstreamSubject, pinnipedSession.Fosite.Claims.Subject)
+			require.Equal(t, upstreamUsername, pinnipedSession.Fosite.Claims.Extra["username"])
+			require.ElementsMatch(t, upstreamGroupMembership, pinnipedSession.Fosite.Claims.Extra["groups"])
+			require.Equal(t, happyDownstreamCustomSessionData, pinnipedSession.Custom)
+		})
+	}
+}
+
 type requestPath struct {
 	code, state *string
 }
@@ -799,6 +1239,7 @@ type upstreamStateParamBuilder oidctestutil.ExpectedUpstreamStateParamFormat
 func happyUpstreamStateParam() *upstreamStateParamBuilder {
 	return &upstreamStateParamBuilder{
 		U: happyUpstreamIDPName,
+		T: "oidc",
 		P: happyDownstreamRequestParams,
 		N: happyDownstreamNonce,
 		C: happyDownstreamCSRF,
@@ -818,6 +1259,11 @@ func (b *upstreamStateParamBuilder) WithAuthorizeRequestParams(params string) *u
 	return b
 }
 
+func (b *upstreamStateParamBuilder) WithUpstreamType(upstreamType string) *upstreamStateParamBuilder {
+	b.T = upstreamType
+	return b
+}
+
 func (b *upstreamStateParamBuilder) WithNonce(nonce string) *upstreamStateParamBuilder {
 	b.N = nonce
 	return b
@@ -833,6 +1279,11 @@ func (b *upstreamStateParamBuilder) WithPKCVE(pkce string) *upstreamStateParamBu
 	return b
 }
 
+func (b *upstreamStateParamBuilder) WithDeviceUserCode(userCode string) *upstreamStateParamBuilder {
+	b.D = userCode
+	return b
+}
+
 func (b *upstreamStateParamBuilder) WithStateVersion(version string) *upstreamStateParamBuilder {
 	b.V = version
 	return b
@@ -841,6 +1292,9 @@ func (b *upstreamStateParamBuilder) WithStateVersion(version string) *upstreamSt
 type upstreamOIDCIdentityProviderBuilder struct {
 	idToken                    map[string]interface{}
 	usernameClaim, groupsClaim string
+	additionalClaimMappings    map[string]string
+	refreshToken               *oidctypes.RefreshToken
+	endSessionURL              *url.URL
 	authcodeExchangeErr        error
 }
 
@@ -848,6 +1302,7 @@ func happyUpstream() *upstreamOIDCIdentityProviderBuilder {
 	return &upstreamOIDCIdentityProviderBuilder{
 		usernameClaim: upstreamUsernameClaim,
 		groupsClaim:   upstreamGroupsClaim,
+		refreshToken:  &oidctypes.RefreshToken{Token: upstreamRefreshToken},
 		idToken: map[string]interface{}{
 			"iss":                 upstreamIssuer,
 			"sub":                 upstreamSubject,
@@ -873,6 +1328,11 @@ func (u *upstreamOIDCIdentityProviderBuilder) WithoutGroupsClaim() *upstreamOIDC
 	return u
 }
 
+func (u *upstreamOIDCIdentityProviderBuilder) WithAdditionalClaimMappings(m map[string]string) *upstreamOIDCIdentityProviderBuilder {
+	u.additionalClaimMappings = m
+	return u
+}
+
 func (u *upstreamOIDCIdentityProviderBuilder) WithIDTokenClaim(name string, value interface{}) *upstreamOIDCIdentityProviderBuilder {
 	u.idToken[name] = value
 	return u
@@ -883,6 +1343,20 @@ func (u *upstreamOIDCIdentityProviderBuilder) WithoutIDTokenClaim(claim string)
 	return u
 }
 
+func (u *upstreamOIDCIdentityProviderBuilder) WithoutRefreshToken() *upstreamOIDCIdentityProviderBuilder {
+	u.refreshToken = nil
+	return u
+}
+
+func (u *upstreamOIDCIdentityProviderBuilder) WithEndSessionURL(endSessionURL string) *upstreamOIDCIdentityProviderBuilder {
+	parsed, err := url.Parse(endSessionURL)
+	if err != nil {
+		panic(err)
+	}
+	u.endSessionURL = parsed
+	return u
+}
+
 func (u *upstreamOIDCIdentityProviderBuilder) WithoutUpstreamAuthcodeExchangeError(err error) *upstreamOIDCIdentityProviderBuilder {
 	u.authcodeExchangeErr = err
 	return u
@@ -890,16 +1364,18 @@ func (u *upstreamOIDCIdentityProviderBuilder) WithoutUpstreamAuthcodeExchangeErr
 
 func (u *upstreamOIDCIdentityProviderBuilder) Build() oidctestutil.TestUpstreamOIDCIdentityProvider {
 	return oidctestutil.TestUpstreamOIDCIdentityProvider{
-		Name:          happyUpstreamIDPName,
-		ClientID:      "some-client-id",
-		UsernameClaim: u.usernameClaim,
-		GroupsClaim:   u.groupsClaim,
-		Scopes:        []string{"scope1", "scope2"},
+		Name:                    happyUpstreamIDPName,
+		ClientID:                "some-client-id",
+		UsernameClaim:           u.usernameClaim,
+		GroupsClaim:             u.groupsClaim,
+		AdditionalClaimMappings: u.additionalClaimMappings,
+		Scopes:                  []string{"scope1", "scope2"},
+		EndSessionURL:           u.endSessionURL,
 		ExchangeAuthcodeAndValidateTokensFunc: func(ctx context.Context, authcode string, pkceCodeVerifier oidcpkce.Code, expectedIDTokenNonce nonce.Nonce) (*oidctypes.Token, error) {
 			if u.authcodeExchangeErr != nil {
 				return nil, u.authcodeExchangeErr
 			}
-			return &oidctypes.Token{IDToken: &oidctypes.IDToken{Claims: u.idToken}}, nil
+			return &oidctypes.Token{IDToken: &oidctypes.IDToken{Token: upstreamIDToken, Claims: u.idToken}, RefreshToken: u.refreshToken}, nil
 		},
 	}
 }
@@ -7,6 +7,7 @@ package clientregistry
 import (
 	"context"
 	"fmt"
+	"sync"
 	"time"
 
 	"github.com/coreos/go-oidc/v3/oidc"
@@ -16,6 +17,11 @@ import (
 // Client represents a Pinniped OAuth/OIDC client.
 type Client struct {
 	fosite.DefaultOpenIDConnectClient
+
+	// postLogoutRedirectURIs are the redirect URIs which the client may use at the end_session_endpoint. They are
+	// unexported so they are not stored as part of the sessions, since the end_session_endpoint always looks up the
+	// current client.
+	postLogoutRedirectURIs []string
 }
 
 func (c Client) GetResponseModes() []fosite.ResponseModeType {
@@ -23,6 +29,19 @@ func (c Client) GetResponseModes() []fosite.ResponseModeType {
 	return []fosite.ResponseModeType{fosite.ResponseModeDefault, fosite.ResponseModeQuery, fosite.ResponseModeFormPost}
 }
 
+// GetPostLogoutRedirectURIs returns the redirect URIs which the client may use as the post_logout_redirect_uri of its
+// logout requests, as described in https://openid.net/specs/openid-connect-rpinitiated-1_0.html.
+func (c Client) GetPostLogoutRedirectURIs() []string {
+	return c.postLogoutRedirectURIs
+}
+
+// WithPostLogoutRedirectURIs sets the redirect URIs which the client may use as the post_logout_redirect_uri of its
+// logout requests, and returns the client.
+func (c *Client) WithPostLogoutRedirectURIs(postLogoutRedirectURIs []string) *Client {
+	c.postLogoutRedirectURIs = postLogoutRedirectURIs
+	return c
+}
+
 // It implements both the base, OIDC, and response_mode client interfaces of Fosite.
 var (
 	_ fosite.Client              = (*Client)(nil)
@@ -65,6 +84,78 @@ func (StaticClientManager) SetClientAssertionJWT(ctx context.Context, jti string
 	return fmt.Errorf("not implemented")
 }
 
+// DynamicClientManager is a fosite.ClientManager which includes the same statically-defined clients as the
+// StaticClientManager, plus any number of clients which can be set at runtime, e.g. by a controller watching
+// OIDCClient resources. It is safe for concurrent use.
+type DynamicClientManager struct {
+	StaticClientManager
+
+	clients map[string]*Client
+	mutex   sync.RWMutex
+}
+
+var _ fosite.ClientManager = (*DynamicClientManager)(nil)
+
+// NewDynamicClientManager returns an empty DynamicClientManager, which only knows about the static clients.
+func NewDynamicClientManager() *DynamicClientManager {
+	return &DynamicClientManager{
+		clients: map[string]*Client{},
+	}
+}
+
+// SetClients replaces all of the dynamic clients. The static clients cannot be replaced, so any dynamic client
+// which has the same ID as a static client will be ignored.
+func (m *DynamicClientManager) SetClients(clients []*Client) {
+	clientsByID := make(map[string]*Client, len(clients))
+	for _, c := range clients {
+		clientsByID[c.GetID()] = c
+	}
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	m.clients = clientsByID
+}
+
+// GetClient returns the static or dynamic client specified by the given ID.
+//
+// It returns a fosite.ErrNotFound if an unknown client is specified.
+func (m *DynamicClientManager) GetClient(ctx context.Context, id string) (fosite.Client, error) {
+	staticClient, err := m.StaticClientManager.GetClient(ctx, id)
+	if err == nil {
+		return staticClient, nil
+	}
+	m.mutex.RLock()
+	defer m.mutex.RUnlock()
+	if c, ok := m.clients[id]; ok {
+		return c, nil
+	}
+	return nil, err
+}
+
+// ConfidentialClient returns a Client which must authenticate at the token endpoint using HTTP basic auth
+// with a client secret. The hashedSecret is the bcrypt hash of the client secret.
+func ConfidentialClient(id string, hashedSecret []byte, redirectURIs []string, grantTypes []string, scopes []string) *Client {
+	return &Client{
+		DefaultOpenIDConnectClient: fosite.DefaultOpenIDConnectClient{
+			DefaultClient: &fosite.DefaultClient{
+				ID:            id,
+				Secret:        hashedSecret,
+				RedirectURIs:  redirectURIs,
+				GrantTypes:    grantTypes,
+				ResponseTypes: []string{"code"},
+				Scopes:        scopes,
+				Audience:      nil,
+				Public:        false,
+			},
+			RequestURIs:                       nil,
+			JSONWebKeys:                       nil,
+			JSONWebKeysURI:                    "",
+			RequestObjectSigningAlgorithm:     "",
+			TokenEndpointAuthSigningAlgorithm: oidc.RS256,
+			TokenEndpointAuthMethod:           "client_secret_basic",
+		},
+	}
+}
+
 // PinnipedCLI returns the static Client corresponding to the Pinniped CLI.
 func PinnipedCLI() *Client {
 	return &Client{
@@ -77,6 +168,7 @@ func PinnipedCLI() *Client {
 					"authorization_code",
 					"refresh_token",
 					"urn:ietf:params:oauth:grant-type:token-exchange",
+					"urn:ietf:params:oauth:grant-type:device_code",
 				},
 				ResponseTypes: []string{"code"},
 				Scopes: fosite.Arguments{
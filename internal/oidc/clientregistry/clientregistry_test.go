@@ -43,12 +43,72 @@ func TestStaticRegistry(t *testing.T) {
 	})
 }
 
+func TestDynamicRegistry(t *testing.T) {
+	ctx := context.Background()
+
+	t.Run("unimplemented methods", func(t *testing.T) {
+		registry := NewDynamicClientManager()
+		require.EqualError(t, registry.ClientAssertionJWTValid(ctx, "some-token-id"), "not implemented")
+		require.EqualError(t, registry.SetClientAssertionJWT(ctx, "some-token-id", time.Now()), "not implemented")
+	})
+
+	t.Run("not found", func(t *testing.T) {
+		registry := NewDynamicClientManager()
+		registry.SetClients([]*Client{ConfidentialClient("some-client", []byte("some-hash"), nil, nil, nil)})
+		got, err := registry.GetClient(ctx, "does-not-exist")
+		require.Error(t, err)
+		require.Nil(t, got)
+		rfcErr := fosite.ErrorToRFC6749Error(err)
+		require.NotNil(t, rfcErr)
+		require.Equal(t, rfcErr.CodeField, 404)
+		require.Equal(t, rfcErr.GetDescription(), "no such client")
+	})
+
+	t.Run("pinniped CLI", func(t *testing.T) {
+		registry := NewDynamicClientManager()
+		got, err := registry.GetClient(ctx, "pinniped-cli")
+		require.NoError(t, err)
+		require.Equal(t, PinnipedCLI(), got)
+	})
+
+	t.Run("pinniped CLI cannot be replaced by a dynamic client", func(t *testing.T) {
+		registry := NewDynamicClientManager()
+		registry.SetClients([]*Client{ConfidentialClient("pinniped-cli", []byte("some-hash"), nil, nil, nil)})
+		got, err := registry.GetClient(ctx, "pinniped-cli")
+		require.NoError(t, err)
+		require.Equal(t, PinnipedCLI(), got)
+	})
+
+	t.Run("dynamic clients", func(t *testing.T) {
+		registry := NewDynamicClientManager()
+		client1 := ConfidentialClient("client-1", []byte("some-hash-1"), nil, nil, nil)
+		client2 := ConfidentialClient("client-2", []byte("some-hash-2"), nil, nil, nil)
+		registry.SetClients([]*Client{client1, client2})
+
+		got, err := registry.GetClient(ctx, "client-1")
+		require.NoError(t, err)
+		require.Same(t, client1, got)
+		got, err = registry.GetClient(ctx, "client-2")
+		require.NoError(t, err)
+		require.Same(t, client2, got)
+
+		// Setting the clients again replaces all of the old dynamic clients.
+		registry.SetClients([]*Client{client2})
+		got, err = registry.GetClient(ctx, "client-1")
+		require.Error(t, err)
+		require.Nil(t, got)
+		got, err = registry.GetClient(ctx, "client-2")
+		require.NoError(t, err)
+		require.Same(t, client2, got)
+	})
+}
+
 func TestPinnipedCLI(t *testing.T) {
 	c := PinnipedCLI()
 	require.Equal(t, "pinniped-cli", c.GetID())
 	require.Nil(t, c.GetHashedSecret())
 	require.Equal(t, []string{"http://127.0.0.1/callback"}, c.GetRedirectURIs())
-	require.Equal(t, fosite.Arguments{"authorization_code", "refresh_token", "urn:ietf:params:oauth:grant-type:token-exchange"}, c.GetGrantTypes())
+	require.Equal(t, fosite.Arguments{"authorization_code", "refresh_token", "urn:ietf:params:oauth:grant-type:token-exchange", "urn:ietf:params:oauth:grant-type:device_code"}, c.GetGrantTypes())
 	require.Equal(t, fosite.Arguments{"code"}, c.GetResponseTypes())
 	require.Equal(t, fosite.Arguments{oidc.ScopeOpenID, oidc.ScopeOfflineAccess, "profile", "email", "pinniped:request-audience"}, c.GetScopes())
 	require.True(t, c.IsPublic())
@@ -60,6 +120,7 @@ func TestPinnipedCLI(t *testing.T) {
 	require.Equal(t, "none", c.GetTokenEndpointAuthMethod())
 	require.Equal(t, "RS256", c.GetTokenEndpointAuthSigningAlgorithm())
 	require.Equal(t, []fosite.ResponseModeType{"", "query", "form_post"}, c.GetResponseModes())
+	require.Nil(t, c.GetPostLogoutRedirectURIs())
 
 	marshaled, err := json.Marshal(c)
 	require.NoError(t, err)
@@ -72,7 +133,8 @@ func TestPinnipedCLI(t *testing.T) {
 		  "grant_types": [
 			"authorization_code",
 			"refresh_token",
-			"urn:ietf:params:oauth:grant-type:token-exchange"
+			"urn:ietf:params:oauth:grant-type:token-exchange",
+			"urn:ietf:params:oauth:grant-type:device_code"
 		  ],
 		  "response_types": [
 			"code"
@@ -94,3 +156,33 @@ func TestPinnipedCLI(t *testing.T) {
 		  "token_endpoint_auth_signing_alg": "RS256"
 		}`, string(marshaled))
 }
+
+func TestConfidentialClient(t *testing.T) {
+	c := ConfidentialClient(
+		"some-client",
+		[]byte("some-hash"),
+		[]string{"https://example.com/callback"},
+		[]string{"authorization_code", "refresh_token"},
+		[]string{oidc.ScopeOpenID, oidc.ScopeOfflineAccess},
+	)
+	require.Equal(t, "some-client", c.GetID())
+	require.Equal(t, []byte("some-hash"), c.GetHashedSecret())
+	require.Equal(t, []string{"https://example.com/callback"}, c.GetRedirectURIs())
+	require.Equal(t, fosite.Arguments{"authorization_code", "refresh_token"}, c.GetGrantTypes())
+	require.Equal(t, fosite.Arguments{"code"}, c.GetResponseTypes())
+	require.Equal(t, fosite.Arguments{oidc.ScopeOpenID, oidc.ScopeOfflineAccess}, c.GetScopes())
+	require.False(t, c.IsPublic())
+	require.Nil(t, c.GetAudience())
+	require.Equal(t, "client_secret_basic", c.GetTokenEndpointAuthMethod())
+	require.Equal(t, "RS256", c.GetTokenEndpointAuthSigningAlgorithm())
+	require.Equal(t, []fosite.ResponseModeType{"", "query", "form_post"}, c.GetResponseModes())
+	require.Nil(t, c.GetPostLogoutRedirectURIs())
+
+	require.Same(t, c, c.WithPostLogoutRedirectURIs([]string{"https://example.com/logged-out"}))
+	require.Equal(t, []string{"https://example.com/logged-out"}, c.GetPostLogoutRedirectURIs())
+
+	// The post-logout redirect URIs are not part of the stored sessions.
+	marshaled, err := json.Marshal(c)
+	require.NoError(t, err)
+	require.NotContains(t, string(marshaled), "logged-out")
+}
@@ -0,0 +1,166 @@
+// Copyright 2021 the Pinniped contributors. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+// Package device provides the handlers for the OAuth 2.0 Device Authorization Grant (RFC8628).
+package device
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/ory/fosite"
+
+	"go.pinniped.dev/internal/auditlog"
+	"go.pinniped.dev/internal/fositestorage/devicecode"
+	"go.pinniped.dev/internal/httputil/httperr"
+	"go.pinniped.dev/internal/httputil/securityheader"
+	"go.pinniped.dev/internal/oidc"
+	"go.pinniped.dev/internal/oidc/auth"
+	"go.pinniped.dev/internal/oidc/clientregistry"
+	"go.pinniped.dev/internal/plog"
+	"go.pinniped.dev/internal/psession"
+)
+
+// authorizationResponse is the successful response of the device authorization endpoint.
+// See https://datatracker.ietf.org/doc/html/rfc8628#section-3.2.
+type authorizationResponse struct {
+	DeviceCode              string `json:"device_code"`
+	UserCode                string `json:"user_code"`
+	VerificationURI         string `json:"verification_uri"`
+	VerificationURIComplete string `json:"verification_uri_complete"`
+	ExpiresIn               int64  `json:"expires_in"`
+	Interval                int64  `json:"interval"`
+}
+
+// NewAuthorizationHandler returns the handler of the device authorization endpoint. Devices call this endpoint to
+// start a login, and then show the returned user code and verification URI to the end user.
+func NewAuthorizationHandler(
+	downstreamIssuer string,
+	idpLister oidc.UpstreamIdentityProvidersLister,
+	clientManager fosite.ClientManager,
+	deviceCodeStorage devicecode.DeviceCodeStorage,
+	oauthHelper fosite.OAuth2Provider,
+	deviceCodeLifespan time.Duration,
+	generateDeviceCode func() (string, error),
+	generateUserCode func() (string, error),
+) http.Handler {
+	return securityheader.Wrap(httperr.HandlerFunc(func(w http.ResponseWriter, r *http.Request) error {
+		if r.Method != http.MethodPost {
+			// https://datatracker.ietf.org/doc/html/rfc8628#section-3.1
+			return httperr.Newf(http.StatusMethodNotAllowed, "%s (try POST)", r.Method)
+		}
+
+		if err := r.ParseForm(); err != nil {
+			err = fosite.ErrInvalidRequest.WithWrap(err).WithHint("Unable to parse HTTP body, make sure to send a properly formatted form request body.")
+			plog.Info("device authorization request error", oidc.FositeErrorForLog(err)...)
+			oauthHelper.WriteAccessError(w, nil, err)
+			return nil
+		}
+
+		client, scopes, err := validateClientAndScopes(r, clientManager)
+		if err != nil {
+			plog.Info("device authorization request error", oidc.FositeErrorForLog(err)...)
+			oauthHelper.WriteAccessError(w, nil, err)
+			return nil
+		}
+
+		oidcUpstream, _, idpType, err := auth.ChooseUpstreamIDP(r, idpLister)
+		if err != nil {
+			plog.WarningErr("device authorization upstream config", err)
+			return err
+		}
+		if oidcUpstream == nil {
+			err := fosite.ErrInvalidRequest.WithHintf("The device authorization grant is not supported for %s upstream providers.", idpType)
+			plog.Info("device authorization request error", oidc.FositeErrorForLog(err)...)
+			oauthHelper.WriteAccessError(w, nil, err)
+			return nil
+		}
+
+		deviceCode, err := generateDeviceCode()
+		if err != nil {
+			plog.Error("device authorization generate error", err)
+			return httperr.Wrap(http.StatusInternalServerError, "error generating device code", err)
+		}
+		userCode, err := generateUserCode()
+		if err != nil {
+			plog.Error("device authorization generate error", err)
+			return httperr.Wrap(http.StatusInternalServerError, "error generating user code", err)
+		}
+
+		signature := devicecode.Signature(deviceCode)
+		now := time.Now()
+		request := fosite.NewRequest()
+		// The signature is unique to this request, and the device code session is deleted when it is redeemed.
+		request.ID = signature
+		request.RequestedAt = now.UTC()
+		request.Client = client
+		request.RequestedScope = scopes
+		request.Form = r.PostForm
+		request.Session = psession.NewPinnipedSession()
+
+		if err := deviceCodeStorage.CreateDeviceCodeSession(r.Context(), signature, &devicecode.Session{
+			Request:      request,
+			Status:       devicecode.StatusPending,
+			UserCode:     devicecode.NormalizeUserCode(userCode),
+			ExpiresAt:    now.Add(deviceCodeLifespan),
+			UpstreamName: oidcUpstream.GetName(),
+			UpstreamType: idpType,
+		}); err != nil {
+			plog.Error("device authorization storage error", err)
+			return httperr.Wrap(http.StatusInternalServerError, "error saving device code session", err)
+		}
+		auditlog.Record(r, auditlog.Event{
+			Type:            auditlog.EventTypeDeviceAuthorization,
+			Result:          auditlog.ResultSuccess,
+			ClientID:        client.GetID(),
+			UpstreamIDPName: oidcUpstream.GetName(),
+			UpstreamIDPType: idpType,
+		})
+
+		verificationURI := downstreamIssuer + oidc.DeviceVerificationEndpointPath
+		w.Header().Set("Content-Type", "application/json;charset=UTF-8")
+		w.Header().Set("Cache-Control", "no-store")
+		w.Header().Set("Pragma", "no-cache")
+		return json.NewEncoder(w).Encode(&authorizationResponse{
+			DeviceCode:              deviceCode,
+			UserCode:                userCode,
+			VerificationURI:         verificationURI,
+			VerificationURIComplete: verificationURI + "?" + url.Values{"user_code": []string{userCode}}.Encode(),
+			ExpiresIn:               int64(deviceCodeLifespan.Seconds()),
+			Interval:                int64(oidc.DeviceCodePollingInterval.Seconds()),
+		})
+	}))
+}
+
+// validateClientAndScopes checks that the client may use the device authorization grant, and that it may request
+// all of the requested scopes. Only public clients are supported, since they do not need to authenticate.
+func validateClientAndScopes(r *http.Request, clientManager fosite.ClientManager) (*clientregistry.Client, fosite.Arguments, error) {
+	clientID := r.PostForm.Get("client_id")
+	if clientID == "" {
+		return nil, nil, fosite.ErrInvalidRequest.WithHint("The client_id parameter is required.")
+	}
+
+	fositeClient, err := clientManager.GetClient(r.Context(), clientID)
+	if err != nil {
+		return nil, nil, fosite.ErrInvalidClient.WithWrap(err).WithHint("The requested OAuth 2.0 Client does not exist.")
+	}
+	client, ok := fositeClient.(*clientregistry.Client)
+	if !ok || !client.IsPublic() {
+		return nil, nil, fosite.ErrInvalidClient.WithHint("Only public clients may use the device authorization grant.")
+	}
+	if !client.GetGrantTypes().Has(oidc.GrantTypeDeviceCode) {
+		return nil, nil, fosite.ErrUnauthorizedClient.WithHintf("The OAuth 2.0 Client is not allowed to use authorization grant %q.", oidc.GrantTypeDeviceCode)
+	}
+
+	scopes := fosite.RemoveEmpty(strings.Split(r.PostForm.Get("scope"), " "))
+	for _, scope := range scopes {
+		if !fosite.ExactScopeStrategy(client.GetScopes(), scope) {
+			return nil, nil, fosite.ErrInvalidScope.WithHintf("The OAuth 2.0 Client is not allowed to request scope '%s'.", scope)
+		}
+	}
+
+	return client, scopes, nil
+}
@@ -0,0 +1,276 @@
+// Copyright 2021 the Pinniped contributors. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package device
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"k8s.io/client-go/kubernetes/fake"
+
+	"go.pinniped.dev/internal/auditlog"
+	"go.pinniped.dev/internal/crud"
+	"go.pinniped.dev/internal/fositestorage/devicecode"
+	"go.pinniped.dev/internal/oidc"
+	"go.pinniped.dev/internal/oidc/clientregistry"
+	"go.pinniped.dev/internal/oidc/jwks"
+	"go.pinniped.dev/internal/testutil"
+	"go.pinniped.dev/internal/testutil/oidctestutil"
+)
+
+const (
+	downstreamIssuer = "https://my-downstream-issuer.com/some-path"
+	happyDeviceCode  = "some-device-code"
+	happyUserCode    = "BCDF-GHJK"
+)
+
+func TestAuthorizationEndpoint(t *testing.T) {
+	upstreamAuthURL, err := url.Parse("https://some-upstream-idp:8443/auth")
+	require.NoError(t, err)
+
+	upstreamOIDCIdentityProvider := &oidctestutil.TestUpstreamOIDCIdentityProvider{
+		Name:             "some-oidc-idp",
+		ClientID:         "some-client-id",
+		AuthorizationURL: *upstreamAuthURL,
+		Scopes:           []string{"scope1", "scope2"},
+	}
+	upstreamLDAPIdentityProvider := &oidctestutil.TestUpstreamLDAPIdentityProvider{Name: "some-ldap-idp"}
+
+	happyBody := url.Values{
+		"client_id": []string{"pinniped-cli"},
+		"scope":     []string{"openid offline_access pinniped:request-audience"},
+	}
+
+	withBody := func(changes map[string]string) url.Values {
+		body := url.Values{}
+		for k, v := range happyBody {
+			body[k] = v
+		}
+		for k, v := range changes {
+			if v == "" {
+				body.Del(k)
+			} else {
+				body.Set(k, v)
+			}
+		}
+		return body
+	}
+
+	happyDeviceCodeGenerator := func() (string, error) { return happyDeviceCode, nil }
+	happyUserCodeGenerator := func() (string, error) { return happyUserCode, nil }
+	sadGenerator := func() (string, error) { return "", fmt.Errorf("some generator error") }
+
+	tests := []struct {
+		name string
+
+		method             string
+		body               url.Values
+		idps               *oidctestutil.UpstreamIDPListerBuilder
+		generateDeviceCode func() (string, error)
+		generateUserCode   func() (string, error)
+
+		wantStatus             int
+		wantContentType        string
+		wantBodyJSON           string
+		wantBodyString         string
+		wantOAuthError         string
+		wantOAuthErrorContains string
+		wantStoredSession      bool
+	}{
+		{
+			name:            "happy path",
+			method:          http.MethodPost,
+			body:            happyBody,
+			idps:            oidctestutil.NewUpstreamIDPListerBuilder().WithOIDC(upstreamOIDCIdentityProvider),
+			wantStatus:      http.StatusOK,
+			wantContentType: "application/json;charset=UTF-8",
+			wantBodyJSON: `{
+				"device_code": "some-device-code",
+				"user_code": "BCDF-GHJK",
+				"verification_uri": "https://my-downstream-issuer.com/some-path/oauth2/device",
+				"verification_uri_complete": "https://my-downstream-issuer.com/some-path/oauth2/device?user_code=BCDF-GHJK",
+				"expires_in": 600,
+				"interval": 5
+			}`,
+			wantStoredSession: true,
+		},
+		{
+			name:            "wrong method",
+			method:          http.MethodGet,
+			idps:            oidctestutil.NewUpstreamIDPListerBuilder().WithOIDC(upstreamOIDCIdentityProvider),
+			wantStatus:      http.StatusMethodNotAllowed,
+			wantContentType: "text/plain; charset=utf-8",
+			wantBodyString:  "Method Not Allowed: GET (try POST)\n",
+		},
+		{
+			name:                   "missing client_id",
+			method:                 http.MethodPost,
+			body:                   withBody(map[string]string{"client_id": ""}),
+			idps:                   oidctestutil.NewUpstreamIDPListerBuilder().WithOIDC(upstreamOIDCIdentityProvider),
+			wantStatus:             http.StatusBadRequest,
+			wantContentType:        "application/json;charset=UTF-8",
+			wantOAuthError:         "invalid_request",
+			wantOAuthErrorContains: "The client_id parameter is required.",
+		},
+		{
+			name:                   "unknown client",
+			method:                 http.MethodPost,
+			body:                   withBody(map[string]string{"client_id": "some-other-client"}),
+			idps:                   oidctestutil.NewUpstreamIDPListerBuilder().WithOIDC(upstreamOIDCIdentityProvider),
+			wantStatus:             http.StatusUnauthorized,
+			wantContentType:        "application/json;charset=UTF-8",
+			wantOAuthError:         "invalid_client",
+			wantOAuthErrorContains: "The requested OAuth 2.0 Client does not exist.",
+		},
+		{
+			name:                   "scope not allowed",
+			method:                 http.MethodPost,
+			body:                   withBody(map[string]string{"scope": "openid tuna"}),
+			idps:                   oidctestutil.NewUpstreamIDPListerBuilder().WithOIDC(upstreamOIDCIdentityProvider),
+			wantStatus:             http.StatusBadRequest,
+			wantContentType:        "application/json;charset=UTF-8",
+			wantOAuthError:         "invalid_scope",
+			wantOAuthErrorContains: "The OAuth 2.0 Client is not allowed to request scope 'tuna'.",
+		},
+		{
+			name:            "no upstreams",
+			method:          http.MethodPost,
+			body:            happyBody,
+			idps:            oidctestutil.NewUpstreamIDPListerBuilder(),
+			wantStatus:      http.StatusUnprocessableEntity,
+			wantContentType: "text/plain; charset=utf-8",
+			wantBodyString:  "Unprocessable Entity: No upstream providers are configured\n",
+		},
+		{
+			name:                   "LDAP upstream",
+			method:                 http.MethodPost,
+			body:                   happyBody,
+			idps:                   oidctestutil.NewUpstreamIDPListerBuilder().WithLDAP(upstreamLDAPIdentityProvider),
+			wantStatus:             http.StatusBadRequest,
+			wantContentType:        "application/json;charset=UTF-8",
+			wantOAuthError:         "invalid_request",
+			wantOAuthErrorContains: "The device authorization grant is not supported for ldap upstream providers.",
+		},
+		{
+			name:               "error generating device code",
+			method:             http.MethodPost,
+			body:               happyBody,
+			idps:               oidctestutil.NewUpstreamIDPListerBuilder().WithOIDC(upstreamOIDCIdentityProvider),
+			generateDeviceCode: sadGenerator,
+			wantStatus:         http.StatusInternalServerError,
+			wantContentType:    "text/plain; charset=utf-8",
+			wantBodyString:     "Internal Server Error: error generating device code\n",
+		},
+		{
+			name:             "error generating user code",
+			method:           http.MethodPost,
+			body:             happyBody,
+			idps:             oidctestutil.NewUpstreamIDPListerBuilder().WithOIDC(upstreamOIDCIdentityProvider),
+			generateUserCode: sadGenerator,
+			wantStatus:       http.StatusInternalServerError,
+			wantContentType:  "text/plain; charset=utf-8",
+			wantBodyString:   "Internal Server Error: error generating user code\n",
+		},
+	}
+	for _, test := range tests {
+		test := test
+		t.Run(test.name, func(t *testing.T) {
+			kubeClient := fake.NewSimpleClientset()
+			secretsClient := kubeClient.CoreV1().Secrets("some-namespace")
+			timeoutsConfiguration := oidc.DefaultOIDCTimeoutsConfiguration()
+			kubeOauthStore := oidc.NewKubeStorage(crud.NewSecretsBackend(secretsClient), &clientregistry.StaticClientManager{}, timeoutsConfiguration)
+			hmacSecretFunc := func() []byte { return []byte("some secret - must have at least 32 bytes") }
+			oauthHelper := oidc.FositeOauth2Helper(kubeOauthStore, downstreamIssuer, hmacSecretFunc, jwks.NewDynamicJWKSProvider(), timeoutsConfiguration)
+
+			generateDeviceCode := happyDeviceCodeGenerator
+			if test.generateDeviceCode != nil {
+				generateDeviceCode = test.generateDeviceCode
+			}
+			generateUserCode := happyUserCodeGenerator
+			if test.generateUserCode != nil {
+				generateUserCode = test.generateUserCode
+			}
+
+			subject := NewAuthorizationHandler(
+				downstreamIssuer,
+				test.idps.Build(),
+				&clientregistry.StaticClientManager{},
+				kubeOauthStore,
+				oauthHelper,
+				timeoutsConfiguration.DeviceCodeLifespan,
+				generateDeviceCode,
+				generateUserCode,
+			)
+
+			req := httptest.NewRequest(test.method, "/oauth2/device_authorization", strings.NewReader(test.body.Encode()))
+			req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+			var auditEvents bytes.Buffer
+			auditlog.SetSink(&auditEvents)
+			defer auditlog.SetSink(nil)
+			rsp := httptest.NewRecorder()
+			subject.ServeHTTP(rsp, req)
+			t.Logf("response: %#v", rsp)
+			t.Logf("response body: %q", rsp.Body.String())
+
+			testutil.RequireSecurityHeaders(t, rsp)
+			require.Equal(t, test.wantStatus, rsp.Code)
+			require.Equal(t, test.wantContentType, rsp.Header().Get("Content-Type"))
+
+			switch {
+			case test.wantBodyJSON != "":
+				require.JSONEq(t, test.wantBodyJSON, rsp.Body.String())
+				require.Equal(t, "no-store", rsp.Header().Get("Cache-Control"))
+			case test.wantOAuthError != "":
+				var oauthErr map[string]string
+				require.NoError(t, json.Unmarshal(rsp.Body.Bytes(), &oauthErr))
+				require.Equal(t, test.wantOAuthError, oauthErr["error"])
+				require.Contains(t, oauthErr["error_description"], test.wantOAuthErrorContains)
+			default:
+				require.Equal(t, test.wantBodyString, rsp.Body.String())
+			}
+
+			session, err := kubeOauthStore.GetDeviceCodeSession(context.Background(), devicecode.Signature(happyDeviceCode))
+			if !test.wantStoredSession {
+				require.Error(t, err)
+				require.Empty(t, auditEvents.String())
+				return
+			}
+			require.NoError(t, err)
+
+			var auditEvent auditlog.Event
+			require.NoError(t, json.Unmarshal(auditEvents.Bytes(), &auditEvent))
+			auditEvent.Version, auditEvent.Timestamp, auditEvent.SourceIP = "", time.Time{}, ""
+			require.Equal(t, auditlog.Event{
+				Type:            auditlog.EventTypeDeviceAuthorization,
+				Result:          auditlog.ResultSuccess,
+				ClientID:        "pinniped-cli",
+				UpstreamIDPName: "some-oidc-idp",
+				UpstreamIDPType: "oidc",
+			}, auditEvent)
+			require.Equal(t, devicecode.StatusPending, session.Status)
+			require.Equal(t, "BCDFGHJK", session.UserCode)
+			require.Equal(t, "some-oidc-idp", session.UpstreamName)
+			require.Equal(t, "oidc", session.UpstreamType)
+			require.WithinDuration(t, time.Now().Add(timeoutsConfiguration.DeviceCodeLifespan), session.ExpiresAt, time.Minute)
+			require.Equal(t, "pinniped-cli", session.Request.GetClient().GetID())
+			require.ElementsMatch(t, []string{"openid", "offline_access", "pinniped:request-audience"}, session.Request.GetRequestedScopes())
+			require.Empty(t, session.Request.GetGrantedScopes())
+
+			// The user code can be used to find the same session.
+			signature, sessionByUserCode, err := kubeOauthStore.GetDeviceCodeSessionByUserCode(context.Background(), "BCDFGHJK")
+			require.NoError(t, err)
+			require.Equal(t, devicecode.Signature(happyDeviceCode), signature)
+			require.Equal(t, session.Request.GetID(), sessionByUserCode.Request.GetID())
+		})
+	}
+}
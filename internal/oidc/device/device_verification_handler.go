@@ -0,0 +1,206 @@
+// Copyright 2021 the Pinniped contributors. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package device
+
+import (
+	"crypto/subtle"
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/ory/fosite"
+
+	"go.pinniped.dev/internal/auditlog"
+	"go.pinniped.dev/internal/fositestorage/devicecode"
+	"go.pinniped.dev/internal/httputil/httperr"
+	"go.pinniped.dev/internal/httputil/securityheader"
+	"go.pinniped.dev/internal/oidc"
+	"go.pinniped.dev/internal/oidc/csrftoken"
+	"go.pinniped.dev/internal/oidc/provider/devicehtml"
+	"go.pinniped.dev/internal/plog"
+	"go.pinniped.dev/pkg/oidcclient/nonce"
+	"go.pinniped.dev/pkg/oidcclient/pkce"
+)
+
+const (
+	invalidUserCodeMessage   = "The code is invalid or has expired. Please check the code on your device and try again."
+	tooManyAttemptsMessage   = "Too many invalid codes were entered. Please wait a few minutes and try again."
+	tooManyAttemptsLogReason = "too many invalid user codes were submitted"
+)
+
+// NewVerificationHandler returns the handler of the device verification page. The end user enters the user code
+// from their device on this page, and is then sent to the upstream OIDC provider to log in. The callback endpoint
+// approves the device authorization request once the end user has logged in. Each source IP may only submit a
+// limited number of invalid user codes, so that the user codes of other devices cannot be guessed.
+func NewVerificationHandler(
+	downstreamIssuer string,
+	idpLister oidc.UpstreamOIDCIdentityProvidersLister,
+	deviceCodeStorage devicecode.DeviceCodeStorage,
+	generateCSRF func() (csrftoken.CSRFToken, error),
+	generatePKCE func() (pkce.Code, error),
+	generateNonce func() (nonce.Nonce, error),
+	upstreamStateEncoder oidc.Encoder,
+	cookieCodec oidc.Codec,
+) http.Handler {
+	limiter := newFailedAttemptLimiter(time.Now)
+	handler := httperr.HandlerFunc(func(w http.ResponseWriter, r *http.Request) error {
+		switch r.Method {
+		case http.MethodGet:
+			return showVerificationPage(w, r, generateCSRF, cookieCodec)
+		case http.MethodPost:
+			return handleVerificationPageSubmit(w, r,
+				downstreamIssuer,
+				idpLister,
+				deviceCodeStorage,
+				limiter,
+				generatePKCE, generateNonce,
+				upstreamStateEncoder,
+				cookieCodec,
+			)
+		default:
+			return httperr.Newf(http.StatusMethodNotAllowed, "%s (try GET or POST)", r.Method)
+		}
+	})
+	return securityheader.WrapWithCustomCSP(handler, devicehtml.ContentSecurityPolicy())
+}
+
+func showVerificationPage(
+	w http.ResponseWriter,
+	r *http.Request,
+	generateCSRF func() (csrftoken.CSRFToken, error),
+	cookieCodec oidc.Codec,
+) error {
+	// An invalid CSRF cookie is treated like a missing one, so that a new cookie is written.
+	csrfValue, _ := oidc.ReadCSRFCookie(r, cookieCodec)
+	if csrfValue == "" {
+		// We did not receive an incoming CSRF cookie, so write a new one.
+		var err error
+		csrfValue, err = generateCSRF()
+		if err != nil {
+			plog.Error("device verification generate error", err)
+			return httperr.Wrap(http.StatusInternalServerError, "error generating CSRF token", err)
+		}
+		if err := oidc.AddCSRFSetCookieHeader(w, csrfValue, cookieCodec); err != nil {
+			plog.Error("error setting CSRF cookie", err)
+			return err
+		}
+	}
+
+	return renderPage(w, http.StatusOK, &devicehtml.PageData{
+		UserCode:  r.URL.Query().Get("user_code"),
+		CSRFToken: string(csrfValue),
+	})
+}
+
+func handleVerificationPageSubmit(
+	w http.ResponseWriter,
+	r *http.Request,
+	downstreamIssuer string,
+	idpLister oidc.UpstreamOIDCIdentityProvidersLister,
+	deviceCodeStorage devicecode.DeviceCodeStorage,
+	limiter *failedAttemptLimiter,
+	generatePKCE func() (pkce.Code, error),
+	generateNonce func() (nonce.Nonce, error),
+	upstreamStateEncoder oidc.Encoder,
+	cookieCodec oidc.Codec,
+) error {
+	csrfValue, err := oidc.ReadCSRFCookie(r, cookieCodec)
+	if err != nil {
+		plog.Info("CSRF cookie is missing or invalid")
+		return httperr.New(http.StatusForbidden, "CSRF cookie is missing or invalid")
+	}
+	if subtle.ConstantTimeCompare([]byte(r.PostFormValue("csrf_token")), []byte(csrfValue)) != 1 {
+		plog.Info("CSRF value does not match")
+		return httperr.New(http.StatusForbidden, "CSRF value does not match")
+	}
+
+	submittedUserCode := r.PostFormValue("user_code")
+	source := auditlog.SourceIP(r)
+	if !limiter.allowed(source) {
+		// Even a valid user code is rejected, so that guessing cannot continue once the limit was reached.
+		plog.Info("device verification rejected because " + tooManyAttemptsLogReason)
+		auditlog.Record(r, auditlog.Event{
+			Type:   auditlog.EventTypeDeviceVerification,
+			Result: auditlog.ResultFailure,
+			Reason: tooManyAttemptsLogReason,
+		})
+		return renderPage(w, http.StatusTooManyRequests, &devicehtml.PageData{
+			UserCode:     submittedUserCode,
+			CSRFToken:    string(csrfValue),
+			ErrorMessage: tooManyAttemptsMessage,
+		})
+	}
+
+	userCode := devicecode.NormalizeUserCode(submittedUserCode)
+	_, session, err := deviceCodeStorage.GetDeviceCodeSessionByUserCode(r.Context(), userCode)
+	if err != nil && !errors.Is(err, fosite.ErrNotFound) {
+		plog.Error("error reading device code session", err)
+		return httperr.Wrap(http.StatusInternalServerError, "error reading device code session", err)
+	}
+	if err != nil || session.Status != devicecode.StatusPending || time.Now().After(session.ExpiresAt) {
+		plog.Info("device code session not found, already used, or expired")
+		limiter.recordFailure(source)
+		auditlog.Record(r, auditlog.Event{
+			Type:   auditlog.EventTypeDeviceVerification,
+			Result: auditlog.ResultFailure,
+			Reason: "user code not found, already used, or expired",
+		})
+		return renderPage(w, http.StatusOK, &devicehtml.PageData{
+			UserCode:     submittedUserCode,
+			CSRFToken:    string(csrfValue),
+			ErrorMessage: invalidUserCodeMessage,
+		})
+	}
+
+	oidcUpstream := oidc.FindUpstreamOIDCIdentityProviderByNameAndType(session.UpstreamName, session.UpstreamType, idpLister)
+	if oidcUpstream == nil {
+		plog.Warning("upstream provider not found", "upstreamName", session.UpstreamName, "upstreamType", session.UpstreamType)
+		return httperr.New(http.StatusUnprocessableEntity, "upstream provider not found")
+	}
+
+	nonceValue, err := generateNonce()
+	if err != nil {
+		return httperr.Wrap(http.StatusInternalServerError, "error generating nonce param", err)
+	}
+	pkceValue, err := generatePKCE()
+	if err != nil {
+		return httperr.Wrap(http.StatusInternalServerError, "error generating PKCE param", err)
+	}
+
+	encodedStateParamValue, err := upstreamStateEncoder.Encode(oidc.UpstreamStateParamEncodingName, oidc.UpstreamStateParamData{
+		UpstreamName:   oidcUpstream.GetName(),
+		UpstreamType:   oidc.IDPTypeOIDC,
+		Nonce:          nonceValue,
+		CSRFToken:      csrfValue,
+		PKCECode:       pkceValue,
+		FormatVersion:  oidc.UpstreamStateParamFormatVersion,
+		DeviceUserCode: userCode,
+	})
+	if err != nil {
+		plog.Error("device verification upstream state param error", err)
+		return httperr.Wrap(http.StatusInternalServerError, "error encoding upstream state param", err)
+	}
+
+	auditlog.Record(r, auditlog.Event{
+		Type:            auditlog.EventTypeDeviceVerification,
+		Result:          auditlog.ResultSuccess,
+		ClientID:        session.Request.GetClient().GetID(),
+		UpstreamIDPName: oidcUpstream.GetName(),
+		UpstreamIDPType: oidc.IDPTypeOIDC,
+	})
+
+	// Use 303 See Other so the browser follows the redirect using GET instead of re-submitting the form.
+	http.Redirect(w, r,
+		oidc.UpstreamOIDCAuthorizeURL(oidcUpstream, downstreamIssuer, encodedStateParamValue, nonceValue, pkceValue),
+		http.StatusSeeOther,
+	)
+
+	return nil
+}
+
+func renderPage(w http.ResponseWriter, status int, data *devicehtml.PageData) error {
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.WriteHeader(status)
+	return devicehtml.Template().Execute(w, data)
+}
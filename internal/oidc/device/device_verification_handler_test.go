@@ -0,0 +1,452 @@
+// Copyright 2021 the Pinniped contributors. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package device
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/securecookie"
+	"github.com/ory/fosite"
+	"github.com/stretchr/testify/require"
+	"k8s.io/client-go/kubernetes/fake"
+
+	"go.pinniped.dev/internal/auditlog"
+	"go.pinniped.dev/internal/crud"
+	"go.pinniped.dev/internal/fositestorage/devicecode"
+	"go.pinniped.dev/internal/oidc"
+	"go.pinniped.dev/internal/oidc/clientregistry"
+	"go.pinniped.dev/internal/oidc/csrftoken"
+	"go.pinniped.dev/internal/oidc/provider/devicehtml"
+	"go.pinniped.dev/internal/psession"
+	"go.pinniped.dev/internal/testutil"
+	"go.pinniped.dev/internal/testutil/oidctestutil"
+	"go.pinniped.dev/pkg/oidcclient/nonce"
+	"go.pinniped.dev/pkg/oidcclient/pkce"
+)
+
+func TestVerificationEndpoint(t *testing.T) {
+	const (
+		happyCSRF         = "test-csrf"
+		happyPKCE         = "test-pkce"
+		happyNonce        = "test-nonce"
+		htmlContentType   = "text/html; charset=utf-8"
+		normalizedCode    = "BCDFGHJK"
+		upstreamIDPName   = "some-oidc-idp"
+		otherUpstreamName = "some-other-oidc-idp"
+	)
+
+	upstreamAuthURL, err := url.Parse("https://some-upstream-idp:8443/auth")
+	require.NoError(t, err)
+
+	upstreamOIDCIdentityProvider := &oidctestutil.TestUpstreamOIDCIdentityProvider{
+		Name:             upstreamIDPName,
+		ClientID:         "some-client-id",
+		AuthorizationURL: *upstreamAuthURL,
+		Scopes:           []string{"scope1", "scope2"},
+	}
+
+	happyCSRFGenerator := func() (csrftoken.CSRFToken, error) { return happyCSRF, nil }
+	sadCSRFGenerator := func() (csrftoken.CSRFToken, error) { return "", fmt.Errorf("some csrf generator error") }
+	happyPKCEGenerator := func() (pkce.Code, error) { return happyPKCE, nil }
+	happyNonceGenerator := func() (nonce.Nonce, error) { return happyNonce, nil }
+
+	// This is the PKCE challenge which is calculated as base64(sha256("test-pkce")).
+	expectedUpstreamCodeChallenge := "VVaezYqum7reIhoavCHD1n2d-piN3r_mywoYj7fCR7g"
+
+	stateEncoder := securecookie.New([]byte("fake-hash-secret"), []byte("0123456789ABCDEF"))
+	stateEncoder.SetSerializer(securecookie.JSONEncoder{})
+	cookieEncoder := securecookie.New([]byte("fake-hash-secret2"), []byte("0123456789ABCDE2"))
+	cookieEncoder.SetSerializer(securecookie.JSONEncoder{})
+
+	encodedCSRFCookie, err := cookieEncoder.Encode(oidc.CSRFCookieEncodingName, csrftoken.CSRFToken(happyCSRF))
+	require.NoError(t, err)
+	happyCSRFCookie := "__Host-pinniped-csrf=" + encodedCSRFCookie
+
+	expectedUpstreamState := oidc.UpstreamStateParamData{
+		UpstreamName:   upstreamIDPName,
+		UpstreamType:   oidc.IDPTypeOIDC,
+		Nonce:          happyNonce,
+		CSRFToken:      happyCSRF,
+		PKCECode:       happyPKCE,
+		FormatVersion:  oidc.UpstreamStateParamFormatVersion,
+		DeviceUserCode: normalizedCode,
+	}
+
+	expectedRedirectLocation := "https://some-upstream-idp:8443/auth?" + url.Values{
+		"access_type":           []string{"offline"},
+		"client_id":             []string{"some-client-id"},
+		"code_challenge":        []string{expectedUpstreamCodeChallenge},
+		"code_challenge_method": []string{"S256"},
+		"nonce":                 []string{happyNonce},
+		"redirect_uri":          []string{downstreamIssuer + "/callback"},
+		"response_type":         []string{"code"},
+		"scope":                 []string{"scope1 scope2"},
+	}.Encode()
+
+	upstreamOIDCIdentityProviderWithAdditionalParams := *upstreamOIDCIdentityProvider
+	upstreamOIDCIdentityProviderWithAdditionalParams.AdditionalAuthcodeParams = map[string]string{
+		"prompt": "consent",
+		"hd":     "example.com",
+	}
+
+	expectedRedirectLocationWithAdditionalParams := "https://some-upstream-idp:8443/auth?" + url.Values{
+		"access_type":           []string{"offline"},
+		"client_id":             []string{"some-client-id"},
+		"code_challenge":        []string{expectedUpstreamCodeChallenge},
+		"code_challenge_method": []string{"S256"},
+		"hd":                    []string{"example.com"},
+		"nonce":                 []string{happyNonce},
+		"prompt":                []string{"consent"},
+		"redirect_uri":          []string{downstreamIssuer + "/callback"},
+		"response_type":         []string{"code"},
+		"scope":                 []string{"scope1 scope2"},
+	}.Encode()
+
+	renderedPage := func(t *testing.T, data *devicehtml.PageData) string {
+		var buf strings.Builder
+		require.NoError(t, devicehtml.Template().Execute(&buf, data))
+		return buf.String()
+	}
+
+	newSession := func(modify func(*devicecode.Session)) *devicecode.Session {
+		session := &devicecode.Session{
+			Request: &fosite.Request{
+				ID:             "some-request-id",
+				Client:         &clientregistry.Client{DefaultOpenIDConnectClient: fosite.DefaultOpenIDConnectClient{DefaultClient: &fosite.DefaultClient{ID: "pinniped-cli", Public: true}}},
+				RequestedScope: fosite.Arguments{"openid"},
+				Session:        psession.NewPinnipedSession(),
+			},
+			Status:       devicecode.StatusPending,
+			UserCode:     normalizedCode,
+			ExpiresAt:    time.Now().Add(time.Minute),
+			UpstreamName: upstreamIDPName,
+			UpstreamType: oidc.IDPTypeOIDC,
+		}
+		if modify != nil {
+			modify(session)
+		}
+		return session
+	}
+
+	tests := []struct {
+		name string
+
+		method       string
+		path         string
+		body         url.Values
+		cookie       string
+		session      *devicecode.Session
+		generateCSRF func() (csrftoken.CSRFToken, error)
+		upstream     *oidctestutil.TestUpstreamOIDCIdentityProvider
+
+		// The number of unknown user codes which are submitted from the same source IP before the test request.
+		previousFailedAttempts int
+
+		wantStatus          int
+		wantContentType     string
+		wantBody            string
+		wantLocation        string
+		wantCSRFValueCookie bool
+
+		// The audit event which was written, ignoring the fields which are filled in by the auditlog package.
+		wantAuditEvent *auditlog.Event
+	}{
+		{
+			name:                "GET without a CSRF cookie renders the form and sets a new cookie",
+			method:              http.MethodGet,
+			path:                "/oauth2/device?user_code=BCDF-GHJK",
+			wantStatus:          http.StatusOK,
+			wantContentType:     htmlContentType,
+			wantBody:            renderedPage(t, &devicehtml.PageData{UserCode: "BCDF-GHJK", CSRFToken: happyCSRF}),
+			wantCSRFValueCookie: true,
+		},
+		{
+			name:            "GET with a CSRF cookie renders the form using the existing cookie",
+			method:          http.MethodGet,
+			path:            "/oauth2/device",
+			cookie:          happyCSRFCookie,
+			generateCSRF:    sadCSRFGenerator,
+			wantStatus:      http.StatusOK,
+			wantContentType: htmlContentType,
+			wantBody:        renderedPage(t, &devicehtml.PageData{CSRFToken: happyCSRF}),
+		},
+		{
+			name:            "GET when generating a CSRF token fails",
+			method:          http.MethodGet,
+			path:            "/oauth2/device",
+			generateCSRF:    sadCSRFGenerator,
+			wantStatus:      http.StatusInternalServerError,
+			wantContentType: "text/plain; charset=utf-8",
+			wantBody:        "Internal Server Error: error generating CSRF token\n",
+		},
+		{
+			name:            "POST with a valid user code redirects to the upstream",
+			method:          http.MethodPost,
+			path:            "/oauth2/device",
+			body:            url.Values{"user_code": []string{"bcdf-ghjk"}, "csrf_token": []string{happyCSRF}},
+			cookie:          happyCSRFCookie,
+			session:         newSession(nil),
+			wantStatus:      http.StatusSeeOther,
+			wantContentType: "",
+			wantLocation:    expectedRedirectLocation,
+			wantAuditEvent: &auditlog.Event{
+				Type:            auditlog.EventTypeDeviceVerification,
+				Result:          auditlog.ResultSuccess,
+				ClientID:        "pinniped-cli",
+				UpstreamIDPName: upstreamIDPName,
+				UpstreamIDPType: oidc.IDPTypeOIDC,
+			},
+		},
+		{
+			name:            "POST with a valid user code redirects to the upstream with its additional authorize params",
+			method:          http.MethodPost,
+			path:            "/oauth2/device",
+			body:            url.Values{"user_code": []string{"BCDF-GHJK"}, "csrf_token": []string{happyCSRF}},
+			cookie:          happyCSRFCookie,
+			session:         newSession(nil),
+			upstream:        &upstreamOIDCIdentityProviderWithAdditionalParams,
+			wantStatus:      http.StatusSeeOther,
+			wantContentType: "",
+			wantLocation:    expectedRedirectLocationWithAdditionalParams,
+			wantAuditEvent: &auditlog.Event{
+				Type:            auditlog.EventTypeDeviceVerification,
+				Result:          auditlog.ResultSuccess,
+				ClientID:        "pinniped-cli",
+				UpstreamIDPName: upstreamIDPName,
+				UpstreamIDPType: oidc.IDPTypeOIDC,
+			},
+		},
+		{
+			name:                   "POST with a valid user code after fewer invalid user codes than the limit redirects to the upstream",
+			method:                 http.MethodPost,
+			path:                   "/oauth2/device",
+			body:                   url.Values{"user_code": []string{"BCDF-GHJK"}, "csrf_token": []string{happyCSRF}},
+			cookie:                 happyCSRFCookie,
+			session:                newSession(nil),
+			previousFailedAttempts: maxFailedUserCodeAttempts - 1,
+			wantStatus:             http.StatusSeeOther,
+			wantContentType:        "",
+			wantLocation:           expectedRedirectLocation,
+			wantAuditEvent: &auditlog.Event{
+				Type:            auditlog.EventTypeDeviceVerification,
+				Result:          auditlog.ResultSuccess,
+				ClientID:        "pinniped-cli",
+				UpstreamIDPName: upstreamIDPName,
+				UpstreamIDPType: oidc.IDPTypeOIDC,
+			},
+		},
+		{
+			name:                   "POST with a valid user code after too many invalid user codes",
+			method:                 http.MethodPost,
+			path:                   "/oauth2/device",
+			body:                   url.Values{"user_code": []string{"BCDF-GHJK"}, "csrf_token": []string{happyCSRF}},
+			cookie:                 happyCSRFCookie,
+			session:                newSession(nil),
+			previousFailedAttempts: maxFailedUserCodeAttempts,
+			wantStatus:             http.StatusTooManyRequests,
+			wantContentType:        htmlContentType,
+			wantBody:               renderedPage(t, &devicehtml.PageData{UserCode: "BCDF-GHJK", CSRFToken: happyCSRF, ErrorMessage: tooManyAttemptsMessage}),
+			wantAuditEvent: &auditlog.Event{
+				Type:   auditlog.EventTypeDeviceVerification,
+				Result: auditlog.ResultFailure,
+				Reason: "too many invalid user codes were submitted",
+			},
+		},
+		{
+			name:            "POST without a CSRF cookie",
+			method:          http.MethodPost,
+			path:            "/oauth2/device",
+			body:            url.Values{"user_code": []string{"BCDF-GHJK"}, "csrf_token": []string{happyCSRF}},
+			session:         newSession(nil),
+			wantStatus:      http.StatusForbidden,
+			wantContentType: "text/plain; charset=utf-8",
+			wantBody:        "Forbidden: CSRF cookie is missing or invalid\n",
+		},
+		{
+			name:            "POST with an undecodable CSRF cookie",
+			method:          http.MethodPost,
+			path:            "/oauth2/device",
+			body:            url.Values{"user_code": []string{"BCDF-GHJK"}, "csrf_token": []string{happyCSRF}},
+			cookie:          "__Host-pinniped-csrf=this-value-was-not-encoded-by-the-supervisor",
+			session:         newSession(nil),
+			wantStatus:      http.StatusForbidden,
+			wantContentType: "text/plain; charset=utf-8",
+			wantBody:        "Forbidden: CSRF cookie is missing or invalid\n",
+		},
+		{
+			name:            "POST with the wrong CSRF value",
+			method:          http.MethodPost,
+			path:            "/oauth2/device",
+			body:            url.Values{"user_code": []string{"BCDF-GHJK"}, "csrf_token": []string{"wrong"}},
+			cookie:          happyCSRFCookie,
+			session:         newSession(nil),
+			wantStatus:      http.StatusForbidden,
+			wantContentType: "text/plain; charset=utf-8",
+			wantBody:        "Forbidden: CSRF value does not match\n",
+		},
+		{
+			name:            "POST with an unknown user code",
+			method:          http.MethodPost,
+			path:            "/oauth2/device",
+			body:            url.Values{"user_code": []string{"ZZZZ-ZZZZ"}, "csrf_token": []string{happyCSRF}},
+			cookie:          happyCSRFCookie,
+			session:         newSession(nil),
+			wantStatus:      http.StatusOK,
+			wantContentType: htmlContentType,
+			wantBody:        renderedPage(t, &devicehtml.PageData{UserCode: "ZZZZ-ZZZZ", CSRFToken: happyCSRF, ErrorMessage: invalidUserCodeMessage}),
+			wantAuditEvent: &auditlog.Event{
+				Type:   auditlog.EventTypeDeviceVerification,
+				Result: auditlog.ResultFailure,
+				Reason: "user code not found, already used, or expired",
+			},
+		},
+		{
+			name:            "POST with an already approved user code",
+			method:          http.MethodPost,
+			path:            "/oauth2/device",
+			body:            url.Values{"user_code": []string{"BCDF-GHJK"}, "csrf_token": []string{happyCSRF}},
+			cookie:          happyCSRFCookie,
+			session:         newSession(func(s *devicecode.Session) { s.Status = devicecode.StatusApproved }),
+			wantStatus:      http.StatusOK,
+			wantContentType: htmlContentType,
+			wantBody:        renderedPage(t, &devicehtml.PageData{UserCode: "BCDF-GHJK", CSRFToken: happyCSRF, ErrorMessage: invalidUserCodeMessage}),
+		},
+		{
+			name:            "POST with an expired user code",
+			method:          http.MethodPost,
+			path:            "/oauth2/device",
+			body:            url.Values{"user_code": []string{"BCDF-GHJK"}, "csrf_token": []string{happyCSRF}},
+			cookie:          happyCSRFCookie,
+			session:         newSession(func(s *devicecode.Session) { s.ExpiresAt = time.Now().Add(-time.Second) }),
+			wantStatus:      http.StatusOK,
+			wantContentType: htmlContentType,
+			wantBody:        renderedPage(t, &devicehtml.PageData{UserCode: "BCDF-GHJK", CSRFToken: happyCSRF, ErrorMessage: invalidUserCodeMessage}),
+		},
+		{
+			name:            "POST when the upstream is no longer configured",
+			method:          http.MethodPost,
+			path:            "/oauth2/device",
+			body:            url.Values{"user_code": []string{"BCDF-GHJK"}, "csrf_token": []string{happyCSRF}},
+			cookie:          happyCSRFCookie,
+			session:         newSession(func(s *devicecode.Session) { s.UpstreamName = otherUpstreamName }),
+			wantStatus:      http.StatusUnprocessableEntity,
+			wantContentType: "text/plain; charset=utf-8",
+			wantBody:        "Unprocessable Entity: upstream provider not found\n",
+		},
+		{
+			name:            "wrong method",
+			method:          http.MethodPut,
+			path:            "/oauth2/device",
+			wantStatus:      http.StatusMethodNotAllowed,
+			wantContentType: "text/plain; charset=utf-8",
+			wantBody:        "Method Not Allowed: PUT (try GET or POST)\n",
+		},
+	}
+	for _, test := range tests {
+		test := test
+		t.Run(test.name, func(t *testing.T) {
+			secretsClient := fake.NewSimpleClientset().CoreV1().Secrets("some-namespace")
+			kubeOauthStore := oidc.NewKubeStorage(crud.NewSecretsBackend(secretsClient), &clientregistry.StaticClientManager{}, oidc.DefaultOIDCTimeoutsConfiguration())
+			if test.session != nil {
+				require.NoError(t, kubeOauthStore.CreateDeviceCodeSession(context.Background(), "some-signature", test.session))
+			}
+
+			generateCSRF := happyCSRFGenerator
+			if test.generateCSRF != nil {
+				generateCSRF = test.generateCSRF
+			}
+
+			upstream := upstreamOIDCIdentityProvider
+			if test.upstream != nil {
+				upstream = test.upstream
+			}
+
+			subject := NewVerificationHandler(
+				downstreamIssuer,
+				oidctestutil.NewUpstreamIDPListerBuilder().WithOIDC(upstream).Build(),
+				kubeOauthStore,
+				generateCSRF, happyPKCEGenerator, happyNonceGenerator,
+				stateEncoder,
+				cookieEncoder,
+			)
+
+			for i := 0; i < test.previousFailedAttempts; i++ {
+				body := url.Values{"user_code": []string{"ZZZZ-ZZZZ"}, "csrf_token": []string{happyCSRF}}
+				req := httptest.NewRequest(http.MethodPost, "/oauth2/device", strings.NewReader(body.Encode()))
+				req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+				req.Header.Set("Cookie", happyCSRFCookie)
+				rsp := httptest.NewRecorder()
+				subject.ServeHTTP(rsp, req)
+				require.Equal(t, http.StatusOK, rsp.Code)
+			}
+
+			req := httptest.NewRequest(test.method, test.path, strings.NewReader(test.body.Encode()))
+			req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+			if test.cookie != "" {
+				req.Header.Set("Cookie", test.cookie)
+			}
+			var auditEvents bytes.Buffer
+			auditlog.SetSink(&auditEvents)
+			defer auditlog.SetSink(nil)
+			rsp := httptest.NewRecorder()
+			subject.ServeHTTP(rsp, req)
+			t.Logf("response: %#v", rsp)
+			t.Logf("response body: %q", rsp.Body.String())
+
+			testutil.RequireSecurityHeaders(t, rsp)
+			require.Equal(t, devicehtml.ContentSecurityPolicy(), rsp.Header().Get("Content-Security-Policy"))
+			require.Equal(t, test.wantStatus, rsp.Code)
+			require.Equal(t, test.wantContentType, rsp.Header().Get("Content-Type"))
+
+			if test.wantAuditEvent != nil {
+				var got auditlog.Event
+				require.NoError(t, json.Unmarshal(auditEvents.Bytes(), &got))
+				got.Version, got.Timestamp, got.SourceIP = "", time.Time{}, ""
+				require.Equal(t, test.wantAuditEvent, &got)
+			}
+
+			if test.wantLocation != "" {
+				actualLocation, err := url.Parse(rsp.Header().Get("Location"))
+				require.NoError(t, err)
+				expectedLocation, err := url.Parse(test.wantLocation)
+				require.NoError(t, err)
+				// The state param is encrypted using a random IV, so decode it before comparing.
+				actualQuery := actualLocation.Query()
+				var actualUpstreamState oidc.UpstreamStateParamData
+				require.NoError(t, stateEncoder.Decode(oidc.UpstreamStateParamEncodingName, actualQuery.Get("state"), &actualUpstreamState))
+				require.Equal(t, expectedUpstreamState, actualUpstreamState)
+				actualQuery.Del("state")
+				require.Equal(t, expectedLocation.Query(), actualQuery)
+				actualLocation.RawQuery, expectedLocation.RawQuery = "", ""
+				require.Equal(t, expectedLocation.String(), actualLocation.String())
+			} else {
+				require.Empty(t, rsp.Header().Get("Location"))
+				require.Equal(t, test.wantBody, rsp.Body.String())
+			}
+
+			if test.wantCSRFValueCookie {
+				require.Len(t, rsp.Result().Cookies(), 1)
+				cookie := rsp.Result().Cookies()[0]
+				require.Equal(t, "__Host-pinniped-csrf", cookie.Name)
+				var csrfFromCookie csrftoken.CSRFToken
+				require.NoError(t, cookieEncoder.Decode(oidc.CSRFCookieEncodingName, cookie.Value, &csrfFromCookie))
+				require.Equal(t, csrftoken.CSRFToken(happyCSRF), csrfFromCookie)
+				require.True(t, cookie.HttpOnly)
+				require.True(t, cookie.Secure)
+				require.Equal(t, http.SameSiteLaxMode, cookie.SameSite)
+			} else {
+				require.Empty(t, rsp.Header().Values("Set-Cookie"))
+			}
+		})
+	}
+}
@@ -0,0 +1,73 @@
+// Copyright 2021 the Pinniped contributors. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package device
+
+import (
+	"sync"
+	"time"
+)
+
+const (
+	// User codes are short enough for an end user to type, so they could be guessed by submitting many of them.
+	// Each source may only submit this many invalid user codes per failedUserCodeAttemptsWindow.
+	maxFailedUserCodeAttempts    = 10
+	failedUserCodeAttemptsWindow = 10 * time.Minute
+)
+
+// failedAttemptLimiter counts the invalid user codes which were submitted by each source in the current window.
+// The counts are only kept in memory, so each Supervisor pod limits the attempts which it receives.
+type failedAttemptLimiter struct {
+	mu       sync.Mutex
+	now      func() time.Time
+	attempts map[string]*failedAttempts
+}
+
+type failedAttempts struct {
+	count       int
+	windowStart time.Time
+}
+
+func newFailedAttemptLimiter(now func() time.Time) *failedAttemptLimiter {
+	return &failedAttemptLimiter{
+		now:      now,
+		attempts: map[string]*failedAttempts{},
+	}
+}
+
+// allowed returns false when the source has already submitted the maximum number of invalid user codes in its
+// current window.
+func (l *failedAttemptLimiter) allowed(source string) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	a, ok := l.attempts[source]
+	if !ok || l.expired(a) {
+		return true
+	}
+	return a.count < maxFailedUserCodeAttempts
+}
+
+// recordFailure counts an invalid user code which was submitted by the source.
+func (l *failedAttemptLimiter) recordFailure(source string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	// Forget the sources whose windows have ended, so that the map does not keep growing.
+	for s, a := range l.attempts {
+		if l.expired(a) {
+			delete(l.attempts, s)
+		}
+	}
+
+	a, ok := l.attempts[source]
+	if !ok {
+		a = &failedAttempts{windowStart: l.now()}
+		l.attempts[source] = a
+	}
+	a.count++
+}
+
+func (l *failedAttemptLimiter) expired(a *failedAttempts) bool {
+	return !l.now().Before(a.windowStart.Add(failedUserCodeAttemptsWindow))
+}
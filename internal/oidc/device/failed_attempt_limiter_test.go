@@ -0,0 +1,38 @@
+// Copyright 2021 the Pinniped contributors. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package device
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestFailedAttemptLimiter(t *testing.T) {
+	now := time.Date(2030, time.January, 1, 0, 0, 0, 0, time.UTC)
+	subject := newFailedAttemptLimiter(func() time.Time { return now })
+
+	for i := 0; i < maxFailedUserCodeAttempts; i++ {
+		require.True(t, subject.allowed("10.1.2.3"), "attempt %d", i)
+		subject.recordFailure("10.1.2.3")
+	}
+	require.False(t, subject.allowed("10.1.2.3"))
+
+	// Other sources have their own counts.
+	require.True(t, subject.allowed("10.4.5.6"))
+	subject.recordFailure("10.4.5.6")
+
+	// The limit still applies until the end of the window, which started with the first failure.
+	now = now.Add(failedUserCodeAttemptsWindow - time.Second)
+	require.False(t, subject.allowed("10.1.2.3"))
+
+	now = now.Add(time.Second)
+	require.True(t, subject.allowed("10.1.2.3"))
+	subject.recordFailure("10.1.2.3")
+	require.Equal(t, 1, subject.attempts["10.1.2.3"].count)
+
+	// The ended windows of the other sources were forgotten.
+	require.Len(t, subject.attempts, 1)
+}
@@ -0,0 +1,122 @@
+// Copyright 2021 the Pinniped contributors. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package oidc
+
+import (
+	"context"
+	"net/http"
+	"time"
+
+	"github.com/ory/fosite"
+	"github.com/ory/fosite/compose"
+	"github.com/pkg/errors"
+
+	"go.pinniped.dev/internal/fositestorage/devicecode"
+)
+
+var (
+	// errAuthorizationPending is returned while the end user has not yet approved the device authorization request.
+	// See https://datatracker.ietf.org/doc/html/rfc8628#section-3.5.
+	errAuthorizationPending = &fosite.RFC6749Error{
+		ErrorField:       "authorization_pending",
+		DescriptionField: "The authorization request is still pending as the end user hasn't yet completed the user-interaction steps.",
+		CodeField:        http.StatusBadRequest,
+	}
+
+	// errExpiredToken is returned when the device code has expired before the end user approved the request.
+	// See https://datatracker.ietf.org/doc/html/rfc8628#section-3.5.
+	errExpiredToken = &fosite.RFC6749Error{
+		ErrorField:       "expired_token",
+		DescriptionField: "The device code has expired, and the device authorization session has concluded.",
+		CodeField:        http.StatusBadRequest,
+	}
+)
+
+func DeviceCodeFactory(config *compose.Config, storage interface{}, strategy interface{}) interface{} {
+	return &DeviceCodeHandler{
+		tokenIssuer:       newTokenIssuer(config, storage, strategy),
+		deviceCodeStorage: storage.(devicecode.DeviceCodeStorage),
+	}
+}
+
+// DeviceCodeHandler implements the token endpoint part of the OAuth 2.0 Device Authorization Grant (RFC8628).
+// The device authorization endpoint and the verification page are implemented outside of Fosite.
+type DeviceCodeHandler struct {
+	tokenIssuer
+	deviceCodeStorage devicecode.DeviceCodeStorage
+}
+
+var _ fosite.TokenEndpointHandler = (*DeviceCodeHandler)(nil)
+
+func (d *DeviceCodeHandler) HandleTokenEndpointRequest(ctx context.Context, requester fosite.AccessRequester) error {
+	if !d.CanHandleTokenEndpointRequest(requester) {
+		return errors.WithStack(fosite.ErrUnknownRequest)
+	}
+
+	if !requester.GetClient().GetGrantTypes().Has(GrantTypeDeviceCode) {
+		return errors.WithStack(fosite.ErrUnauthorizedClient.WithHintf("The OAuth 2.0 Client is not allowed to use authorization grant %q.", GrantTypeDeviceCode))
+	}
+
+	deviceCode := requester.GetRequestForm().Get("device_code")
+	if deviceCode == "" {
+		return errors.WithStack(fosite.ErrInvalidRequest.WithHint("missing device_code parameter"))
+	}
+
+	session, err := d.deviceCodeStorage.GetDeviceCodeSession(ctx, devicecode.Signature(deviceCode))
+	if errors.Is(err, fosite.ErrNotFound) {
+		return errors.WithStack(fosite.ErrInvalidGrant.WithWrap(err).WithHint("invalid device_code"))
+	}
+	if err != nil {
+		return errors.WithStack(fosite.ErrServerError.WithWrap(err).WithDebug(err.Error()))
+	}
+
+	if session.Request.GetClient().GetID() != requester.GetClient().GetID() {
+		return errors.WithStack(fosite.ErrInvalidGrant.WithHint("The OAuth 2.0 Client ID from this request does not match the one from the device authorization request."))
+	}
+
+	if time.Now().After(session.ExpiresAt) {
+		return errors.WithStack(errExpiredToken)
+	}
+
+	if session.Status != devicecode.StatusApproved {
+		return errors.WithStack(errAuthorizationPending)
+	}
+
+	// Continue the downstream session which was started by the callback endpoint when the end user approved the request.
+	requester.SetID(session.Request.GetID())
+	requester.SetRequestedScopes(session.Request.GetRequestedScopes())
+	requester.SetSession(session.Request.GetSession())
+	for _, scope := range session.Request.GetGrantedScopes() {
+		requester.GrantScope(scope)
+	}
+
+	d.setExpiresAt(requester)
+
+	return nil
+}
+
+func (d *DeviceCodeHandler) PopulateTokenEndpointResponse(ctx context.Context, requester fosite.AccessRequester, responder fosite.AccessResponder) error {
+	if !d.CanHandleTokenEndpointRequest(requester) {
+		return errors.WithStack(fosite.ErrUnknownRequest)
+	}
+
+	// Device codes may only be redeemed once.
+	signature := devicecode.Signature(requester.GetRequestForm().Get("device_code"))
+	if err := d.deviceCodeStorage.DeleteDeviceCodeSession(ctx, signature); err != nil {
+		if errors.Is(err, fosite.ErrNotFound) {
+			return errors.WithStack(fosite.ErrInvalidGrant.WithWrap(err).WithHint("invalid device_code"))
+		}
+		return errors.WithStack(fosite.ErrServerError.WithWrap(err).WithDebug(err.Error()))
+	}
+
+	return d.issueTokens(ctx, requester, responder)
+}
+
+func (d *DeviceCodeHandler) CanSkipClientAuth(_ fosite.AccessRequester) bool {
+	return false
+}
+
+func (d *DeviceCodeHandler) CanHandleTokenEndpointRequest(requester fosite.AccessRequester) bool {
+	return requester.GetGrantTypes().ExactOne(GrantTypeDeviceCode)
+}
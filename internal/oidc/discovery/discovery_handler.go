@@ -33,10 +33,29 @@ type Metadata struct {
 
 	// vvv Optional vvv
 
+	GrantTypesSupported               []string `json:"grant_types_supported"`
 	TokenEndpointAuthMethodsSupported []string `json:"token_endpoint_auth_methods_supported"`
 	ScopesSupported                   []string `json:"scopes_supported"`
 	ClaimsSupported                   []string `json:"claims_supported"`
 
+	// DeviceAuthorizationEndpoint is defined by the OAuth 2.0 Device Authorization Grant (RFC8628), see
+	// https://datatracker.ietf.org/doc/html/rfc8628#section-4.
+	DeviceAuthorizationEndpoint string `json:"device_authorization_endpoint,omitempty"`
+
+	// RevocationEndpoint is defined by OAuth 2.0 Token Revocation (RFC7009), and advertised as described in
+	// https://datatracker.ietf.org/doc/html/rfc8414#section-2.
+	RevocationEndpoint                     string   `json:"revocation_endpoint,omitempty"`
+	RevocationEndpointAuthMethodsSupported []string `json:"revocation_endpoint_auth_methods_supported,omitempty"`
+
+	// IntrospectionEndpoint is defined by OAuth 2.0 Token Introspection (RFC7662), and advertised as described in
+	// https://datatracker.ietf.org/doc/html/rfc8414#section-2.
+	IntrospectionEndpoint                     string   `json:"introspection_endpoint,omitempty"`
+	IntrospectionEndpointAuthMethodsSupported []string `json:"introspection_endpoint_auth_methods_supported,omitempty"`
+
+	// EndSessionEndpoint is defined by OpenID Connect RP-Initiated Logout, see
+	// https://openid.net/specs/openid-connect-rpinitiated-1_0.html#OPMetadata.
+	EndSessionEndpoint string `json:"end_session_endpoint,omitempty"`
+
 	// ^^^ Optional ^^^
 
 	// vvv Custom vvv
@@ -57,19 +76,35 @@ type IdentityProviderMetadata struct {
 
 // NewHandler returns an http.Handler that serves an OIDC discovery endpoint.
 func NewHandler(issuerURL string) http.Handler {
+	// The Pinniped CLI is a public client which does not authenticate ("none"), and the OIDCClients authenticate
+	// with their client secrets using HTTP basic auth ("client_secret_basic"). Introspection is not allowed for
+	// public clients.
 	oidcConfig := Metadata{
-		Issuer:                            issuerURL,
-		AuthorizationEndpoint:             issuerURL + oidc.AuthorizationEndpointPath,
-		TokenEndpoint:                     issuerURL + oidc.TokenEndpointPath,
-		JWKSURI:                           issuerURL + oidc.JWKSEndpointPath,
-		SupervisorDiscovery:               SupervisorDiscoveryMetadataV1Alpha1{PinnipedIDPsEndpoint: issuerURL + oidc.PinnipedIDPsPathV1Alpha1},
-		ResponseTypesSupported:            []string{"code"},
-		ResponseModesSupported:            []string{"query", "form_post"},
-		SubjectTypesSupported:             []string{"public"},
-		IDTokenSigningAlgValuesSupported:  []string{"ES256"},
-		TokenEndpointAuthMethodsSupported: []string{"client_secret_basic"},
-		ScopesSupported:                   []string{"openid", "offline"},
-		ClaimsSupported:                   []string{"groups"},
+		Issuer:                           issuerURL,
+		AuthorizationEndpoint:            issuerURL + oidc.AuthorizationEndpointPath,
+		TokenEndpoint:                    issuerURL + oidc.TokenEndpointPath,
+		JWKSURI:                          issuerURL + oidc.JWKSEndpointPath,
+		SupervisorDiscovery:              SupervisorDiscoveryMetadataV1Alpha1{PinnipedIDPsEndpoint: issuerURL + oidc.PinnipedIDPsPathV1Alpha1},
+		ResponseTypesSupported:           []string{"code"},
+		ResponseModesSupported:           []string{"query", "form_post"},
+		SubjectTypesSupported:            []string{"public"},
+		IDTokenSigningAlgValuesSupported: []string{"ES256"},
+		GrantTypesSupported: []string{
+			"authorization_code",
+			"refresh_token",
+			"urn:ietf:params:oauth:grant-type:token-exchange",
+			oidc.GrantTypeDeviceCode,
+			oidc.GrantTypePassword,
+		},
+		TokenEndpointAuthMethodsSupported:         []string{"client_secret_basic", "none"},
+		ScopesSupported:                           []string{"openid", "offline"},
+		ClaimsSupported:                           []string{"groups"},
+		DeviceAuthorizationEndpoint:               issuerURL + oidc.DeviceAuthorizationEndpointPath,
+		RevocationEndpoint:                        issuerURL + oidc.RevocationEndpointPath,
+		RevocationEndpointAuthMethodsSupported:    []string{"client_secret_basic", "none"},
+		IntrospectionEndpoint:                     issuerURL + oidc.IntrospectionEndpointPath,
+		IntrospectionEndpointAuthMethodsSupported: []string{"client_secret_basic"},
+		EndSessionEndpoint:                        issuerURL + oidc.EndSessionEndpointPath,
 	}
 
 	var b bytes.Buffer
@@ -42,13 +42,26 @@ func TestDiscovery(t *testing.T) {
 				SupervisorDiscovery: SupervisorDiscoveryMetadataV1Alpha1{
 					PinnipedIDPsEndpoint: "https://some-issuer.com/some/path/v1alpha1/pinniped_identity_providers",
 				},
-				ResponseTypesSupported:            []string{"code"},
-				ResponseModesSupported:            []string{"query", "form_post"},
-				SubjectTypesSupported:             []string{"public"},
-				IDTokenSigningAlgValuesSupported:  []string{"ES256"},
-				TokenEndpointAuthMethodsSupported: []string{"client_secret_basic"},
-				ScopesSupported:                   []string{"openid", "offline"},
-				ClaimsSupported:                   []string{"groups"},
+				ResponseTypesSupported:           []string{"code"},
+				ResponseModesSupported:           []string{"query", "form_post"},
+				SubjectTypesSupported:            []string{"public"},
+				IDTokenSigningAlgValuesSupported: []string{"ES256"},
+				GrantTypesSupported: []string{
+					"authorization_code",
+					"refresh_token",
+					"urn:ietf:params:oauth:grant-type:token-exchange",
+					"urn:ietf:params:oauth:grant-type:device_code",
+					"password",
+				},
+				TokenEndpointAuthMethodsSupported:         []string{"client_secret_basic", "none"},
+				ScopesSupported:                           []string{"openid", "offline"},
+				ClaimsSupported:                           []string{"groups"},
+				DeviceAuthorizationEndpoint:               "https://some-issuer.com/some/path/oauth2/device_authorization",
+				RevocationEndpoint:                        "https://some-issuer.com/some/path/oauth2/revoke",
+				RevocationEndpointAuthMethodsSupported:    []string{"client_secret_basic", "none"},
+				IntrospectionEndpoint:                     "https://some-issuer.com/some/path/oauth2/introspect",
+				IntrospectionEndpointAuthMethodsSupported: []string{"client_secret_basic"},
+				EndSessionEndpoint:                        "https://some-issuer.com/some/path/oauth2/logout",
 			},
 		},
 		{
@@ -5,6 +5,9 @@
 package downstreamsession
 
 import (
+	"fmt"
+	"net/http"
+	"net/url"
 	"time"
 
 	oidc2 "github.com/coreos/go-oidc/v3/oidc"
@@ -12,32 +15,300 @@ import (
 	"github.com/ory/fosite/handler/openid"
 	"github.com/ory/fosite/token/jwt"
 
+	"go.pinniped.dev/internal/httputil/httperr"
 	"go.pinniped.dev/internal/oidc"
+	"go.pinniped.dev/internal/oidc/provider"
+	"go.pinniped.dev/internal/plog"
+	"go.pinniped.dev/internal/psession"
 )
 
-// MakeDownstreamSession creates a downstream OIDC session.
-func MakeDownstreamSession(subject string, username string, groups []string) *openid.DefaultSession {
+const (
+	// The name of the email claim from https://openid.net/specs/openid-connect-core-1_0.html#StandardClaims
+	emailClaimName = "email"
+
+	// The name of the email_verified claim from https://openid.net/specs/openid-connect-core-1_0.html#StandardClaims
+	emailVerifiedClaimName = "email_verified"
+)
+
+// MakeDownstreamSession creates a downstream OIDC session. The custom session data remembers which upstream
+// was used, so the user can be checked against that upstream again when the session is refreshed. The requestID is
+// the ID of the request under which the tokens of the session will be stored, which becomes the session ID claim of
+// its ID tokens, so the end_session_endpoint can find the session again.
+func MakeDownstreamSession(
+	requestID string,
+	subject string,
+	username string,
+	groups []string,
+	additionalClaims map[string]interface{},
+	custom *psession.CustomSessionData,
+) *psession.PinnipedSession {
 	now := time.Now().UTC()
-	openIDSession := &openid.DefaultSession{
-		Claims: &jwt.IDTokenClaims{
-			Subject:     subject,
-			RequestedAt: now,
-			AuthTime:    now,
+	openIDSession := &psession.PinnipedSession{
+		Fosite: &openid.DefaultSession{
+			Claims: &jwt.IDTokenClaims{
+				Subject:     subject,
+				RequestedAt: now,
+				AuthTime:    now,
+			},
 		},
+		Custom: custom,
 	}
 	if groups == nil {
 		groups = []string{}
 	}
-	openIDSession.Claims.Extra = map[string]interface{}{
-		oidc.DownstreamUsernameClaim: username,
-		oidc.DownstreamGroupsClaim:   groups,
+	openIDSession.IDTokenClaims().Extra = map[string]interface{}{
+		oidc.DownstreamUsernameClaim:  username,
+		oidc.DownstreamGroupsClaim:    groups,
+		oidc.DownstreamSessionIDClaim: requestID,
 	}
+	SetAdditionalClaims(openIDSession, additionalClaims)
 	return openIDSession
 }
 
+// SetAdditionalClaims replaces the additional claims of the downstream session. The additional claims are nested
+// under a single claim of the ID token, which is omitted when there are no additional claims.
+func SetAdditionalClaims(session *psession.PinnipedSession, additionalClaims map[string]interface{}) {
+	claims := session.IDTokenClaims()
+	if claims.Extra == nil {
+		claims.Extra = map[string]interface{}{}
+	}
+	if len(additionalClaims) == 0 {
+		delete(claims.Extra, oidc.DownstreamAdditionalClaimsClaim)
+		return
+	}
+	claims.Extra[oidc.DownstreamAdditionalClaimsClaim] = additionalClaims
+}
+
+// GetIdentityFromDownstreamSession returns the downstream subject, username and groups which were stored in the
+// session by MakeDownstreamSession. The groups may have been decoded from storage as a []interface{}.
+func GetIdentityFromDownstreamSession(session *psession.PinnipedSession) (string, string, []string) {
+	if session.Fosite == nil || session.Fosite.Claims == nil {
+		return "", "", nil
+	}
+	claims := session.Fosite.Claims
+	username, _ := claims.Extra[oidc.DownstreamUsernameClaim].(string)
+	var groups []string
+	switch g := claims.Extra[oidc.DownstreamGroupsClaim].(type) {
+	case []string:
+		groups = g
+	case []interface{}:
+		for _, group := range g {
+			if groupAsString, ok := group.(string); ok {
+				groups = append(groups, groupAsString)
+			}
+		}
+	}
+	return claims.Subject, username, groups
+}
+
 // GrantScopesIfRequested auto-grants the scopes for which we do not require end-user approval, if they were requested.
-func GrantScopesIfRequested(authorizeRequester fosite.AuthorizeRequester) {
-	oidc.GrantScopeIfRequested(authorizeRequester, oidc2.ScopeOpenID)
-	oidc.GrantScopeIfRequested(authorizeRequester, oidc2.ScopeOfflineAccess)
-	oidc.GrantScopeIfRequested(authorizeRequester, "pinniped:request-audience")
+func GrantScopesIfRequested(requester fosite.Requester) {
+	oidc.GrantScopeIfRequested(requester, oidc2.ScopeOpenID)
+	oidc.GrantScopeIfRequested(requester, oidc2.ScopeOfflineAccess)
+	oidc.GrantScopeIfRequested(requester, "pinniped:request-audience")
+}
+
+// DownstreamSubjectFromUpstreamLDAP returns a globally unique downstream subject for an upstream LDAP or Active
+// Directory user, by combining the upstream's URL with the user's UID.
+func DownstreamSubjectFromUpstreamLDAP(ldapUpstream provider.UpstreamLDAPIdentityProviderI, uid string) string {
+	ldapURL := *ldapUpstream.GetURL()
+	q := ldapURL.Query()
+	q.Set(oidc.IDTokenSubjectClaim, uid)
+	ldapURL.RawQuery = q.Encode()
+	return ldapURL.String()
+}
+
+// GetSubjectAndUsernameFromUpstreamIDToken returns the downstream subject and username for the claims of an
+// upstream ID token, using the username claim which is configured on the upstream.
+func GetSubjectAndUsernameFromUpstreamIDToken(
+	upstreamIDPConfig provider.UpstreamOIDCIdentityProviderI,
+	idTokenClaims map[string]interface{},
+) (string, string, error) {
+	// The spec says the "sub" claim is only unique per issuer,
+	// so we will prepend the issuer string to make it globally unique.
+	upstreamIssuer := idTokenClaims[oidc.IDTokenIssuerClaim]
+	if upstreamIssuer == "" {
+		plog.Warning(
+			"issuer claim in upstream ID token missing",
+			"upstreamName", upstreamIDPConfig.GetName(),
+			"issClaim", upstreamIssuer,
+		)
+		return "", "", httperr.New(http.StatusUnprocessableEntity, "issuer claim in upstream ID token missing")
+	}
+	upstreamIssuerAsString, ok := upstreamIssuer.(string)
+	if !ok {
+		plog.Warning(
+			"issuer claim in upstream ID token has invalid format",
+			"upstreamName", upstreamIDPConfig.GetName(),
+			"issClaim", upstreamIssuer,
+		)
+		return "", "", httperr.New(http.StatusUnprocessableEntity, "issuer claim in upstream ID token has invalid format")
+	}
+
+	subjectAsInterface, ok := idTokenClaims[oidc.IDTokenSubjectClaim]
+	if !ok {
+		plog.Warning(
+			"no subject claim in upstream ID token",
+			"upstreamName", upstreamIDPConfig.GetName(),
+		)
+		return "", "", httperr.New(http.StatusUnprocessableEntity, "no subject claim in upstream ID token")
+	}
+
+	upstreamSubject, ok := subjectAsInterface.(string)
+	if !ok {
+		plog.Warning(
+			"subject claim in upstream ID token has invalid format",
+			"upstreamName", upstreamIDPConfig.GetName(),
+		)
+		return "", "", httperr.New(http.StatusUnprocessableEntity, "subject claim in upstream ID token has invalid format")
+	}
+
+	subject := DownstreamSubjectFromUpstreamOIDC(upstreamIssuerAsString, upstreamSubject)
+
+	usernameClaimName := upstreamIDPConfig.GetUsernameClaim()
+	if usernameClaimName == "" {
+		return subject, subject, nil
+	}
+
+	// If the upstream username claim is configured to be the special "email" claim and the upstream "email_verified"
+	// claim is present, then validate that the "email_verified" claim is true.
+	emailVerifiedAsInterface, ok := idTokenClaims[emailVerifiedClaimName]
+	if usernameClaimName == emailClaimName && ok {
+		emailVerified, ok := emailVerifiedAsInterface.(bool)
+		if !ok {
+			plog.Warning(
+				"username claim configured as \"email\" and upstream email_verified claim is not a boolean",
+				"upstreamName", upstreamIDPConfig.GetName(),
+				"configuredUsernameClaim", usernameClaimName,
+				"emailVerifiedClaim", emailVerifiedAsInterface,
+			)
+			return "", "", httperr.New(http.StatusUnprocessableEntity, "email_verified claim in upstream ID token has invalid format")
+		}
+		if !emailVerified {
+			plog.Warning(
+				"username claim configured as \"email\" and upstream email_verified claim has false value",
+				"upstreamName", upstreamIDPConfig.GetName(),
+				"configuredUsernameClaim", usernameClaimName,
+			)
+			return "", "", httperr.New(http.StatusUnprocessableEntity, "email_verified claim in upstream ID token has false value")
+		}
+	}
+
+	usernameAsInterface, ok := idTokenClaims[usernameClaimName]
+	if !ok {
+		plog.Warning(
+			"no username claim in upstream ID token",
+			"upstreamName", upstreamIDPConfig.GetName(),
+			"configuredUsernameClaim", usernameClaimName,
+		)
+		return "", "", httperr.New(http.StatusUnprocessableEntity, "no username claim in upstream ID token")
+	}
+
+	username, ok := usernameAsInterface.(string)
+	if !ok {
+		plog.Warning(
+			"username claim in upstream ID token has invalid format",
+			"upstreamName", upstreamIDPConfig.GetName(),
+			"configuredUsernameClaim", usernameClaimName,
+		)
+		return "", "", httperr.New(http.StatusUnprocessableEntity, "username claim in upstream ID token has invalid format")
+	}
+
+	return subject, username, nil
+}
+
+// DownstreamSubjectFromUpstreamOIDC returns a globally unique downstream subject for an upstream OIDC user.
+func DownstreamSubjectFromUpstreamOIDC(upstreamIssuerAsString string, upstreamSubject string) string {
+	return fmt.Sprintf("%s?%s=%s", upstreamIssuerAsString, oidc.IDTokenSubjectClaim, url.QueryEscape(upstreamSubject))
+}
+
+// GetGroupsFromUpstreamIDToken returns the downstream groups for the claims of an upstream ID token, using the
+// groups claim which is configured on the upstream.
+func GetGroupsFromUpstreamIDToken(
+	upstreamIDPConfig provider.UpstreamOIDCIdentityProviderI,
+	idTokenClaims map[string]interface{},
+) ([]string, error) {
+	groupsClaimName := upstreamIDPConfig.GetGroupsClaim()
+	if groupsClaimName == "" {
+		return nil, nil
+	}
+
+	groupsAsInterface, ok := idTokenClaims[groupsClaimName]
+	if !ok {
+		plog.Warning(
+			"no groups claim in upstream ID token",
+			"upstreamName", upstreamIDPConfig.GetName(),
+			"configuredGroupsClaim", groupsClaimName,
+		)
+		return nil, nil // the upstream IDP may have omitted the claim if the user has no groups
+	}
+
+	groupsAsArray, okAsArray := extractGroups(groupsAsInterface)
+	if !okAsArray {
+		plog.Warning(
+			"groups claim in upstream ID token has invalid format",
+			"upstreamName", upstreamIDPConfig.GetName(),
+			"configuredGroupsClaim", groupsClaimName,
+		)
+		return nil, httperr.New(http.StatusUnprocessableEntity, "groups claim in upstream ID token has invalid format")
+	}
+
+	return groupsAsArray, nil
+}
+
+// GetAdditionalClaimsFromUpstreamIDToken returns the additional downstream claims for the claims of an upstream ID
+// token, using the additional claim mappings which are configured on the upstream. Upstream claims which are missing
+// are skipped. Returns nil when there are no additional claims.
+func GetAdditionalClaimsFromUpstreamIDToken(
+	upstreamIDPConfig provider.UpstreamOIDCIdentityProviderI,
+	idTokenClaims map[string]interface{},
+) map[string]interface{} {
+	var additionalClaims map[string]interface{}
+	for downstreamClaimName, upstreamClaimName := range upstreamIDPConfig.GetAdditionalClaimMappings() {
+		value, ok := idTokenClaims[upstreamClaimName]
+		if !ok {
+			plog.Debug(
+				"additional claim mapping skipped because the claim is not present in the upstream ID token",
+				"upstreamName", upstreamIDPConfig.GetName(),
+				"upstreamClaimName", upstreamClaimName,
+				"downstreamClaimName", downstreamClaimName,
+			)
+			continue
+		}
+		if additionalClaims == nil {
+			additionalClaims = map[string]interface{}{}
+		}
+		additionalClaims[downstreamClaimName] = value
+	}
+	return additionalClaims
+}
+
+func extractGroups(groupsAsInterface interface{}) ([]string, bool) {
+	groupsAsString, okAsString := groupsAsInterface.(string)
+	if okAsString {
+		return []string{groupsAsString}, true
+	}
+
+	groupsAsStringArray, okAsStringArray := groupsAsInterface.([]string)
+	if okAsStringArray {
+		return groupsAsStringArray, true
+	}
+
+	groupsAsInterfaceArray, okAsArray := groupsAsInterface.([]interface{})
+	if !okAsArray {
+		return nil, false
+	}
+
+	var groupsAsStrings []string
+	for _, groupAsInterface := range groupsAsInterfaceArray {
+		groupAsString, okAsString := groupAsInterface.(string)
+		if !okAsString {
+			return nil, false
+		}
+		if groupAsString != "" {
+			groupsAsStrings = append(groupsAsStrings, groupAsString)
+		}
+	}
+
+	return groupsAsStrings, true
 }
@@ -13,11 +13,6 @@ import (
 	"go.pinniped.dev/internal/oidc"
 )
 
-const (
-	idpDiscoveryTypeLDAP = "ldap"
-	idpDiscoveryTypeOIDC = "oidc"
-)
-
 type response struct {
 	IDPs []identityProviderResponse `json:"pinniped_identity_providers"`
 }
@@ -56,10 +51,13 @@ func responseAsJSON(upstreamIDPs oidc.UpstreamIdentityProvidersLister) ([]byte,
 
 	// The cache of IDPs could change at any time, so always recalculate the list.
 	for _, provider := range upstreamIDPs.GetLDAPIdentityProviders() {
-		r.IDPs = append(r.IDPs, identityProviderResponse{Name: provider.GetName(), Type: idpDiscoveryTypeLDAP})
+		r.IDPs = append(r.IDPs, identityProviderResponse{Name: provider.GetName(), Type: oidc.IDPTypeLDAP})
 	}
 	for _, provider := range upstreamIDPs.GetOIDCIdentityProviders() {
-		r.IDPs = append(r.IDPs, identityProviderResponse{Name: provider.GetName(), Type: idpDiscoveryTypeOIDC})
+		r.IDPs = append(r.IDPs, identityProviderResponse{Name: provider.GetName(), Type: oidc.IDPTypeOIDC})
+	}
+	for _, provider := range upstreamIDPs.GetActiveDirectoryIdentityProviders() {
+		r.IDPs = append(r.IDPs, identityProviderResponse{Name: provider.GetName(), Type: oidc.IDPTypeActiveDirectory})
 	}
 
 	// Nobody like an API that changes the results unnecessarily. :)
@@ -37,6 +37,7 @@ func TestIDPDiscovery(t *testing.T) {
 			wantContentType: "application/json",
 			wantFirstResponseBodyJSON: &response{
 				IDPs: []identityProviderResponse{
+					{Name: "a-some-ad-idp", Type: "activedirectory"},
 					{Name: "a-some-ldap-idp", Type: "ldap"},
 					{Name: "a-some-oidc-idp", Type: "oidc"},
 					{Name: "x-some-idp", Type: "ldap"},
@@ -47,6 +48,7 @@ func TestIDPDiscovery(t *testing.T) {
 			},
 			wantSecondResponseBodyJSON: &response{
 				IDPs: []identityProviderResponse{
+					{Name: "some-other-ad-idp-1", Type: "activedirectory"},
 					{Name: "some-other-ldap-idp-1", Type: "ldap"},
 					{Name: "some-other-ldap-idp-2", Type: "ldap"},
 					{Name: "some-other-oidc-idp-1", Type: "oidc"},
@@ -73,6 +75,7 @@ func TestIDPDiscovery(t *testing.T) {
 				WithOIDC(&oidctestutil.TestUpstreamOIDCIdentityProvider{Name: "a-some-oidc-idp"}).
 				WithLDAP(&oidctestutil.TestUpstreamLDAPIdentityProvider{Name: "z-some-ldap-idp"}).
 				WithLDAP(&oidctestutil.TestUpstreamLDAPIdentityProvider{Name: "x-some-idp"}).
+				WithActiveDirectory(&oidctestutil.TestUpstreamLDAPIdentityProvider{Name: "a-some-ad-idp"}).
 				Build()
 
 			handler := NewHandler(idpLister)
@@ -103,6 +106,9 @@ func TestIDPDiscovery(t *testing.T) {
 				&oidctestutil.TestUpstreamOIDCIdentityProvider{Name: "some-other-oidc-idp-1"},
 				&oidctestutil.TestUpstreamOIDCIdentityProvider{Name: "some-other-oidc-idp-2"},
 			})
+			idpLister.SetActiveDirectoryIdentityProviders([]provider.UpstreamLDAPIdentityProviderI{
+				&oidctestutil.TestUpstreamLDAPIdentityProvider{Name: "some-other-ad-idp-1"},
+			})
 
 			// Make the same request to the same handler instance again, and expect different results.
 			rsp = httptest.NewRecorder()
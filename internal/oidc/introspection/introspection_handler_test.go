@@ -0,0 +1,181 @@
+// Copyright 2021 the Pinniped contributors. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package introspection
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ory/fosite"
+	"github.com/ory/fosite/compose"
+	"github.com/ory/fosite/handler/openid"
+	"github.com/ory/fosite/token/jwt"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/crypto/bcrypt"
+	"k8s.io/client-go/kubernetes/fake"
+
+	"go.pinniped.dev/internal/crud"
+	"go.pinniped.dev/internal/oidc"
+	"go.pinniped.dev/internal/oidc/clientregistry"
+	"go.pinniped.dev/internal/oidc/jwks"
+	"go.pinniped.dev/internal/psession"
+)
+
+const (
+	hmacSecret = "this needs to be at least 32 characters to meet entropy requirements"
+
+	confidentialClientID     = "some-confidential-client"
+	confidentialClientSecret = "some-client-secret"
+)
+
+func TestIntrospectionHandler(t *testing.T) {
+	hashedClientSecret, err := bcrypt.GenerateFromPassword([]byte(confidentialClientSecret), bcrypt.MinCost)
+	require.NoError(t, err)
+
+	tests := []struct {
+		name              string
+		tokenClientID     string
+		introspectRefresh bool
+		token             string
+		bearerAuth        bool
+		basicAuthSecret   string
+		wantStatus        int
+		wantActive        bool
+		wantError         string
+	}{
+		{
+			name:              "introspect refresh token using access token as bearer token",
+			tokenClientID:     "pinniped-cli",
+			introspectRefresh: true,
+			bearerAuth:        true,
+			wantStatus:        http.StatusOK,
+			wantActive:        true,
+		},
+		{
+			name:            "introspect access token as confidential client",
+			tokenClientID:   confidentialClientID,
+			basicAuthSecret: confidentialClientSecret,
+			wantStatus:      http.StatusOK,
+			wantActive:      true,
+		},
+		{
+			name:            "introspect unknown token as confidential client",
+			tokenClientID:   confidentialClientID,
+			token:           "some-unknown-token",
+			basicAuthSecret: confidentialClientSecret,
+			wantStatus:      http.StatusOK,
+			wantActive:      false,
+		},
+		{
+			name:            "confidential client with wrong client secret",
+			tokenClientID:   confidentialClientID,
+			basicAuthSecret: "wrong-client-secret",
+			wantStatus:      http.StatusUnauthorized,
+			wantError:       "request_unauthorized",
+		},
+		{
+			name:          "unauthenticated request",
+			tokenClientID: "pinniped-cli",
+			wantStatus:    http.StatusUnauthorized,
+			wantError:     "request_unauthorized",
+		},
+	}
+	for _, test := range tests {
+		test := test
+		t.Run(test.name, func(t *testing.T) {
+			ctx := context.Background()
+			secrets := fake.NewSimpleClientset().CoreV1().Secrets("some-namespace")
+
+			clients := clientregistry.NewDynamicClientManager()
+			clients.SetClients([]*clientregistry.Client{clientregistry.ConfidentialClient(
+				confidentialClientID,
+				hashedClientSecret,
+				[]string{"https://example.com/callback"},
+				[]string{"authorization_code", "refresh_token"},
+				[]string{"openid", "offline_access"},
+			)})
+			timeouts := oidc.DefaultOIDCTimeoutsConfiguration()
+			storage := oidc.NewKubeStorage(crud.NewSecretsBackend(secrets), clients, timeouts)
+			oauthHelper := oidc.FositeOauth2Helper(storage, "https://some-issuer.com", func() []byte { return []byte(hmacSecret) }, jwks.NewDynamicJWKSProvider(), timeouts)
+
+			client, err := clients.GetClient(ctx, test.tokenClientID)
+			require.NoError(t, err)
+			accessToken, refreshToken := storeTokens(t, storage, client, timeouts)
+
+			token := test.token
+			if token == "" {
+				token = accessToken
+				if test.introspectRefresh {
+					token = refreshToken
+				}
+			}
+			req := httptest.NewRequest(http.MethodPost, "/path/shouldn't/matter", strings.NewReader(url.Values{"token": {token}}.Encode()))
+			req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+			if test.bearerAuth {
+				req.Header.Set("Authorization", "Bearer "+accessToken)
+			}
+			if test.basicAuthSecret != "" {
+				req.SetBasicAuth(confidentialClientID, test.basicAuthSecret)
+			}
+			rsp := httptest.NewRecorder()
+			NewHandler(oauthHelper).ServeHTTP(rsp, req)
+
+			require.Equal(t, test.wantStatus, rsp.Code, rsp.Body.String())
+			var body map[string]interface{}
+			require.NoError(t, json.Unmarshal(rsp.Body.Bytes(), &body))
+			if test.wantError != "" {
+				require.Equal(t, test.wantError, body["error"])
+				return
+			}
+			require.Equal(t, test.wantActive, body["active"])
+			if test.wantActive {
+				require.Equal(t, test.tokenClientID, body["client_id"])
+				require.Equal(t, "some-subject", body["sub"])
+				require.Equal(t, "openid offline_access", body["scope"])
+				require.Contains(t, body, "exp")
+			}
+		})
+	}
+}
+
+// storeTokens stores an access token and a refresh token of the same session, in the same way as the token endpoint.
+func storeTokens(t *testing.T, storage *oidc.KubeStorage, client fosite.Client, timeouts oidc.TimeoutsConfiguration) (string, string) {
+	t.Helper()
+	ctx := context.Background()
+	now := time.Now()
+
+	strategy := compose.NewOAuth2HMACStrategy(&compose.Config{}, []byte(hmacSecret), nil)
+	request := &fosite.Request{
+		ID:          "some-request-id",
+		RequestedAt: now,
+		Client:      client,
+		Session: &psession.PinnipedSession{
+			Fosite: &openid.DefaultSession{
+				Claims:  &jwt.IDTokenClaims{Subject: "some-subject"},
+				Subject: "some-subject",
+				ExpiresAt: map[fosite.TokenType]time.Time{
+					fosite.AccessToken:  now.Add(timeouts.AccessTokenLifespan),
+					fosite.RefreshToken: now.Add(timeouts.RefreshTokenLifespan),
+				},
+			},
+			Custom: &psession.CustomSessionData{ProviderName: "some-upstream", ProviderType: "oidc"},
+		},
+		RequestedScope: fosite.Arguments{"openid", "offline_access"},
+		GrantedScope:   fosite.Arguments{"openid", "offline_access"},
+	}
+
+	accessToken, accessSignature, err := strategy.GenerateAccessToken(ctx, request)
+	require.NoError(t, err)
+	require.NoError(t, storage.CreateAccessTokenSession(ctx, accessSignature, request))
+	refreshToken, refreshSignature, err := strategy.GenerateRefreshToken(ctx, request)
+	require.NoError(t, err)
+	require.NoError(t, storage.CreateRefreshTokenSession(ctx, refreshSignature, request))
+	return accessToken, refreshToken
+}
@@ -11,15 +11,15 @@ import (
 	"github.com/ory/fosite/handler/oauth2"
 	"github.com/ory/fosite/handler/openid"
 	fositepkce "github.com/ory/fosite/handler/pkce"
-	corev1client "k8s.io/client-go/kubernetes/typed/core/v1"
 
+	"go.pinniped.dev/internal/crud"
 	"go.pinniped.dev/internal/fositestorage/accesstoken"
 	"go.pinniped.dev/internal/fositestorage/authorizationcode"
+	"go.pinniped.dev/internal/fositestorage/devicecode"
 	"go.pinniped.dev/internal/fositestorage/openidconnect"
 	"go.pinniped.dev/internal/fositestorage/pkce"
 	"go.pinniped.dev/internal/fositestorage/refreshtoken"
 	"go.pinniped.dev/internal/fositestoragei"
-	"go.pinniped.dev/internal/oidc/clientregistry"
 )
 
 type KubeStorage struct {
@@ -29,19 +29,23 @@ type KubeStorage struct {
 	oidcStorage              openid.OpenIDConnectRequestStorage
 	accessTokenStorage       accesstoken.RevocationStorage
 	refreshTokenStorage      refreshtoken.RevocationStorage
+	deviceCodeStorage        devicecode.DeviceCodeStorage
 }
 
 var _ fositestoragei.AllFositeStorage = &KubeStorage{}
 
-func NewKubeStorage(secrets corev1client.SecretInterface, timeoutsConfiguration TimeoutsConfiguration) *KubeStorage {
+// NewKubeStorage returns the storage of all of the fosite sessions of a FederationDomain. Despite its name, the
+// sessions may be stored by any crud.Backend, not only in Kubernetes Secrets.
+func NewKubeStorage(backend crud.Backend, clientManager fosite.ClientManager, timeoutsConfiguration TimeoutsConfiguration) *KubeStorage {
 	nowFunc := time.Now
 	return &KubeStorage{
-		clientManager:            &clientregistry.StaticClientManager{},
-		authorizationCodeStorage: authorizationcode.New(secrets, nowFunc, timeoutsConfiguration.AuthorizationCodeSessionStorageLifetime),
-		pkceStorage:              pkce.New(secrets, nowFunc, timeoutsConfiguration.PKCESessionStorageLifetime),
-		oidcStorage:              openidconnect.New(secrets, nowFunc, timeoutsConfiguration.OIDCSessionStorageLifetime),
-		accessTokenStorage:       accesstoken.New(secrets, nowFunc, timeoutsConfiguration.AccessTokenSessionStorageLifetime),
-		refreshTokenStorage:      refreshtoken.New(secrets, nowFunc, timeoutsConfiguration.RefreshTokenSessionStorageLifetime),
+		clientManager:            clientManager,
+		authorizationCodeStorage: authorizationcode.New(backend, nowFunc, timeoutsConfiguration.AuthorizationCodeSessionStorageLifetime),
+		pkceStorage:              pkce.New(backend, nowFunc, timeoutsConfiguration.PKCESessionStorageLifetime),
+		oidcStorage:              openidconnect.New(backend, nowFunc, timeoutsConfiguration.OIDCSessionStorageLifetime),
+		accessTokenStorage:       accesstoken.New(backend, nowFunc, timeoutsConfiguration.AccessTokenSessionStorageLifetime),
+		refreshTokenStorage:      refreshtoken.New(backend, nowFunc, timeoutsConfiguration.RefreshTokenSessionStorageLifetime),
+		deviceCodeStorage:        devicecode.New(backend, nowFunc, timeoutsConfiguration.DeviceCodeSessionStorageLifetime),
 	}
 }
 
@@ -148,6 +152,10 @@ func (k KubeStorage) RevokeAccessToken(ctx context.Context, requestID string) er
 	return k.accessTokenStorage.RevokeAccessToken(ctx, requestID)
 }
 
+func (k KubeStorage) GetAccessTokenSessionByRequestID(ctx context.Context, requestID string) (fosite.Requester, error) {
+	return k.accessTokenStorage.GetAccessTokenSessionByRequestID(ctx, requestID)
+}
+
 //
 // Refresh token sessions:
 //
@@ -180,6 +188,41 @@ func (k KubeStorage) RevokeRefreshToken(ctx context.Context, requestID string) e
 	return k.refreshTokenStorage.RevokeRefreshToken(ctx, requestID)
 }
 
+func (k KubeStorage) GetRefreshTokenSessionByRequestID(ctx context.Context, requestID string) (fosite.Requester, error) {
+	return k.refreshTokenStorage.GetRefreshTokenSessionByRequestID(ctx, requestID)
+}
+
+//
+// Device code sessions:
+//
+// These are keyed by the signature of the device code, and can also be found by their user code.
+//
+// Pinniped's device authorization endpoint will create these, and the callback endpoint will update them when the
+// end user finishes logging in using the device verification page. Pinniped's device code grant handler in the token
+// endpoint will delete them when the device code is redeemed. If the client never redeems the device code, then
+// they will be garbage collected.
+//
+
+func (k KubeStorage) CreateDeviceCodeSession(ctx context.Context, signatureOfDeviceCode string, session *devicecode.Session) error {
+	return k.deviceCodeStorage.CreateDeviceCodeSession(ctx, signatureOfDeviceCode, session)
+}
+
+func (k KubeStorage) GetDeviceCodeSession(ctx context.Context, signatureOfDeviceCode string) (*devicecode.Session, error) {
+	return k.deviceCodeStorage.GetDeviceCodeSession(ctx, signatureOfDeviceCode)
+}
+
+func (k KubeStorage) GetDeviceCodeSessionByUserCode(ctx context.Context, userCode string) (string, *devicecode.Session, error) {
+	return k.deviceCodeStorage.GetDeviceCodeSessionByUserCode(ctx, userCode)
+}
+
+func (k KubeStorage) UpdateDeviceCodeSession(ctx context.Context, signatureOfDeviceCode string, session *devicecode.Session) error {
+	return k.deviceCodeStorage.UpdateDeviceCodeSession(ctx, signatureOfDeviceCode, session)
+}
+
+func (k KubeStorage) DeleteDeviceCodeSession(ctx context.Context, signatureOfDeviceCode string) error {
+	return k.deviceCodeStorage.DeleteDeviceCodeSession(ctx, signatureOfDeviceCode)
+}
+
 //
 // OAuth client definitions:
 //
@@ -0,0 +1,188 @@
+// Copyright 2021 the Pinniped contributors. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+// Package login provides a handler for the login page of LDAP and Active Directory upstreams.
+package login
+
+import (
+	"net/http"
+	"net/url"
+
+	"github.com/ory/fosite"
+	"github.com/pkg/errors"
+
+	"go.pinniped.dev/internal/auditlog"
+	"go.pinniped.dev/internal/httputil/httperr"
+	"go.pinniped.dev/internal/httputil/securityheader"
+	"go.pinniped.dev/internal/idtransform"
+	"go.pinniped.dev/internal/metrics"
+	"go.pinniped.dev/internal/oidc"
+	"go.pinniped.dev/internal/oidc/downstreamsession"
+	"go.pinniped.dev/internal/oidc/provider"
+	"go.pinniped.dev/internal/oidc/provider/formposthtml"
+	"go.pinniped.dev/internal/oidc/provider/loginhtml"
+	"go.pinniped.dev/internal/plog"
+	"go.pinniped.dev/internal/psession"
+)
+
+const (
+	missingCredentialsMessage = "Please enter your username and password."
+	badCredentialsMessage     = "Incorrect username or password."
+)
+
+// NewHandler returns the handler of the login page. The authorize endpoint sends end users who log in with a web
+// browser using an LDAP or Active Directory upstream to this page, where they enter their username and password.
+// Once the upstream has accepted the username and password, the original authorization request is finished by
+// issuing an authcode to the downstream client, just like the callback endpoint does for OIDC upstreams.
+func NewHandler(
+	idpLister oidc.UpstreamIdentityProvidersLister,
+	identityTransforms oidc.IdentityTransformsGetter,
+	oauthHelper fosite.OAuth2Provider,
+	stateDecoder, cookieDecoder oidc.Decoder,
+) http.Handler {
+	handler := httperr.HandlerFunc(func(w http.ResponseWriter, r *http.Request) error {
+		if r.Method != http.MethodGet && r.Method != http.MethodPost {
+			return httperr.Newf(http.StatusMethodNotAllowed, "%s (try GET or POST)", r.Method)
+		}
+
+		state, err := oidc.ReadUpstreamStateParamAndValidateCSRFCookie(r, stateDecoder, cookieDecoder)
+		if err != nil {
+			return err
+		}
+
+		ldapUpstream := oidc.FindUpstreamLDAPIdentityProviderByNameAndType(state.UpstreamName, state.UpstreamType, idpLister)
+		if ldapUpstream == nil {
+			plog.Warning("upstream provider not found", "upstreamName", state.UpstreamName, "upstreamType", state.UpstreamType)
+			return httperr.New(http.StatusUnprocessableEntity, "upstream provider not found")
+		}
+
+		if r.Method == http.MethodGet {
+			return renderPage(w, &loginhtml.PageData{
+				State:        r.FormValue("state"),
+				UpstreamName: ldapUpstream.GetName(),
+			})
+		}
+		return handleLoginPageSubmit(w, r, ldapUpstream, state, identityTransforms, oauthHelper)
+	})
+	return securityheader.WrapWithCustomCSP(handler, loginhtml.ContentSecurityPolicy())
+}
+
+func handleLoginPageSubmit(
+	w http.ResponseWriter,
+	r *http.Request,
+	ldapUpstream provider.UpstreamLDAPIdentityProviderI,
+	state *oidc.UpstreamStateParamData,
+	identityTransforms oidc.IdentityTransformsGetter,
+	oauthHelper fosite.OAuth2Provider,
+) error {
+	downstreamAuthParams, err := url.ParseQuery(state.AuthParams)
+	if err != nil {
+		plog.Error("error reading state downstream auth params", err)
+		return httperr.New(http.StatusBadRequest, "error reading state downstream auth params")
+	}
+
+	// Recreate enough of the original authorize request so we can pass it to NewAuthorizeRequest().
+	reconstitutedAuthRequest := &http.Request{Form: downstreamAuthParams}
+	authorizeRequester, err := oauthHelper.NewAuthorizeRequest(r.Context(), reconstitutedAuthRequest)
+	if err != nil {
+		plog.Error("error using state downstream auth params", err)
+		return httperr.New(http.StatusBadRequest, "error using state downstream auth params")
+	}
+
+	// Automatically grant the openid, offline_access, and pinniped:request-audience scopes, but only if they were requested.
+	downstreamsession.GrantScopesIfRequested(authorizeRequester)
+
+	event := auditlog.Event{
+		Type:            auditlog.EventTypeLogin,
+		ClientID:        authorizeRequester.GetClient().GetID(),
+		UpstreamIDPName: ldapUpstream.GetName(),
+		UpstreamIDPType: state.UpstreamType,
+	}
+
+	username := r.PostFormValue("username")
+	password := r.PostFormValue("password")
+	pageData := &loginhtml.PageData{
+		State:        r.PostFormValue("state"),
+		UpstreamName: ldapUpstream.GetName(),
+		Username:     username,
+	}
+	if username == "" || password == "" {
+		plog.Info("missing or blank username or password on login page", "upstreamName", ldapUpstream.GetName())
+		pageData.ErrorMessage = missingCredentialsMessage
+		return renderPage(w, pageData)
+	}
+	event.Username = username
+
+	authenticateResponse, authenticated, err := ldapUpstream.AuthenticateUser(r.Context(), username, password)
+	if err != nil {
+		plog.WarningErr("unexpected error during upstream LDAP authentication", err, "upstreamName", ldapUpstream.GetName())
+		metrics.RecordUpstreamLogin(state.UpstreamType, ldapUpstream.GetName(), metrics.ResultError)
+		event.Result, event.Reason = auditlog.ResultError, "unexpected error during upstream authentication"
+		auditlog.Record(r, event)
+		return httperr.New(http.StatusBadGateway, "unexpected error during upstream authentication")
+	}
+	if !authenticated {
+		plog.Debug("failed upstream LDAP authentication", "upstreamName", ldapUpstream.GetName())
+		metrics.RecordUpstreamLogin(state.UpstreamType, ldapUpstream.GetName(), metrics.ResultFailure)
+		event.Type, event.Result, event.Reason = auditlog.EventTypeFailedCredentials, auditlog.ResultFailure, "username/password not accepted by upstream"
+		auditlog.Record(r, event)
+		pageData.ErrorMessage = badCredentialsMessage
+		return renderPage(w, pageData)
+	}
+
+	identity := identityTransforms.IdentityTransforms(state.UpstreamType, ldapUpstream.GetName()).Evaluate(
+		authenticateResponse.User.GetName(),
+		authenticateResponse.User.GetGroups(),
+		idtransform.AttributesAsClaims(authenticateResponse.Attributes),
+	)
+	if identity.Rejected {
+		plog.Info("login rejected by identity transforms",
+			"upstreamName", ldapUpstream.GetName(), "reason", identity.RejectedReason)
+		metrics.RecordUpstreamLogin(state.UpstreamType, ldapUpstream.GetName(), metrics.ResultFailure)
+		event.Result, event.Reason = auditlog.ResultFailure, identity.RejectedReason
+		auditlog.Record(r, event)
+		// Return an error according to OIDC spec 3.1.2.6 (second paragraph).
+		err = errors.WithStack(fosite.ErrAccessDenied.WithHintf("Login was rejected: %s.", identity.RejectedReason))
+		plog.Info("authorize response error", oidc.FositeErrorForLog(err)...)
+		oauthHelper.WriteAuthorizeError(w, authorizeRequester, err)
+		return nil
+	}
+
+	metrics.RecordUpstreamLogin(state.UpstreamType, ldapUpstream.GetName(), metrics.ResultSuccess)
+
+	customSessionData := &psession.CustomSessionData{
+		ProviderName: ldapUpstream.GetName(),
+		ProviderType: state.UpstreamType,
+		LDAP: &psession.LDAPSessionData{
+			UserDN: authenticateResponse.DN,
+		},
+	}
+	openIDSession := downstreamsession.MakeDownstreamSession(
+		authorizeRequester.GetID(),
+		downstreamsession.DownstreamSubjectFromUpstreamLDAP(ldapUpstream, authenticateResponse.User.GetUID()),
+		identity.Username,
+		identity.Groups,
+		authenticateResponse.AdditionalClaims,
+		customSessionData,
+	)
+
+	authorizeResponder, err := oauthHelper.NewAuthorizeResponse(r.Context(), authorizeRequester, openIDSession)
+	if err != nil {
+		plog.WarningErr("error while generating and saving authcode", err, "upstreamName", ldapUpstream.GetName())
+		return httperr.Wrap(http.StatusInternalServerError, "error while generating and saving authcode", err)
+	}
+
+	event.Result, event.Subject, event.Username, event.Groups = auditlog.ResultSuccess, openIDSession.IDTokenClaims().Subject, identity.Username, identity.Groups
+	auditlog.Record(r, event)
+
+	// The authorize response may be rendered as the form_post page, which needs its own inline script and styles.
+	w.Header().Set("Content-Security-Policy", formposthtml.ContentSecurityPolicy())
+	oauthHelper.WriteAuthorizeResponse(w, authorizeRequester, authorizeResponder)
+
+	return nil
+}
+
+func renderPage(w http.ResponseWriter, data *loginhtml.PageData) error {
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	return loginhtml.Template().Execute(w, data)
+}
@@ -0,0 +1,523 @@
+// Copyright 2021 the Pinniped contributors. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package login
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/securecookie"
+	"github.com/stretchr/testify/require"
+	"k8s.io/apiserver/pkg/authentication/user"
+	"k8s.io/client-go/kubernetes/fake"
+
+	configv1alpha1 "go.pinniped.dev/generated/latest/apis/supervisor/config/v1alpha1"
+	"go.pinniped.dev/internal/auditlog"
+	"go.pinniped.dev/internal/authenticators"
+	"go.pinniped.dev/internal/crud"
+	"go.pinniped.dev/internal/oidc"
+	"go.pinniped.dev/internal/oidc/clientregistry"
+	"go.pinniped.dev/internal/oidc/jwks"
+	"go.pinniped.dev/internal/oidc/provider/formposthtml"
+	"go.pinniped.dev/internal/oidc/provider/loginhtml"
+	"go.pinniped.dev/internal/psession"
+	"go.pinniped.dev/internal/testutil"
+	"go.pinniped.dev/internal/testutil/oidctestutil"
+)
+
+const (
+	happyUpstreamIDPName = "some-ldap-idp"
+	upstreamLDAPURL      = "ldaps://some-ldap-host:123?base=ou%3Dusers%2Cdc%3Dpinniped%2Cdc%3Ddev"
+
+	happyLDAPUsername                  = "some-ldap-user"
+	happyLDAPUsernameFromAuthenticator = "some-mapped-ldap-username"
+	happyLDAPPassword                  = "some-ldap-password" //nolint:gosec
+	happyLDAPUID                       = "some-ldap-uid"
+	happyLDAPUserDN                    = "cn=some-ldap-user,ou=users,dc=example,dc=com"
+
+	happyDownstreamState        = "8b-state"
+	happyDownstreamCSRF         = "test-csrf"
+	happyDownstreamStateVersion = "1"
+
+	downstreamIssuer              = "https://my-downstream-issuer.com/path"
+	downstreamRedirectURI         = "http://127.0.0.1/callback"
+	downstreamClientID            = "pinniped-cli"
+	downstreamNonce               = "some-nonce-value"
+	downstreamPKCEChallenge       = "some-challenge"
+	downstreamPKCEChallengeMethod = "S256"
+
+	htmlContentType = "text/html; charset=utf-8"
+)
+
+var (
+	happyLDAPGroups                = []string{"group1", "group2", "group3"}
+	happyDownstreamScopesRequested = []string{"openid"}
+	happyDownstreamScopesGranted   = []string{"openid"}
+
+	happyDownstreamRequestParams = url.Values{
+		"response_type":         []string{"code"},
+		"scope":                 []string{strings.Join(happyDownstreamScopesRequested, " ")},
+		"client_id":             []string{downstreamClientID},
+		"state":                 []string{happyDownstreamState},
+		"nonce":                 []string{downstreamNonce},
+		"code_challenge":        []string{downstreamPKCEChallenge},
+		"code_challenge_method": []string{downstreamPKCEChallengeMethod},
+		"redirect_uri":          []string{downstreamRedirectURI},
+	}.Encode()
+)
+
+func TestLoginEndpoint(t *testing.T) {
+	var stateEncoderHashKey = []byte("fake-hash-secret")
+	var stateEncoderBlockKey = []byte("0123456789ABCDEF") // block encryption requires 16/24/32 bytes for AES
+	var cookieEncoderHashKey = []byte("fake-hash-secret2")
+	var cookieEncoderBlockKey = []byte("0123456789ABCDE2") // block encryption requires 16/24/32 bytes for AES
+
+	var happyStateCodec = securecookie.New(stateEncoderHashKey, stateEncoderBlockKey)
+	happyStateCodec.SetSerializer(securecookie.JSONEncoder{})
+	var happyCookieCodec = securecookie.New(cookieEncoderHashKey, cookieEncoderBlockKey)
+	happyCookieCodec.SetSerializer(securecookie.JSONEncoder{})
+
+	encodeState := func(state oidctestutil.ExpectedUpstreamStateParamFormat) string {
+		encoded, err := happyStateCodec.Encode("s", state)
+		require.NoError(t, err)
+		return encoded
+	}
+	happyStateParam := oidctestutil.ExpectedUpstreamStateParamFormat{
+		P: happyDownstreamRequestParams,
+		U: happyUpstreamIDPName,
+		T: "ldap",
+		C: happyDownstreamCSRF,
+		V: happyDownstreamStateVersion,
+	}
+	happyState := encodeState(happyStateParam)
+
+	activeDirectoryStateParam := happyStateParam
+	activeDirectoryStateParam.T = "activedirectory"
+	activeDirectoryState := encodeState(activeDirectoryStateParam)
+
+	oidcStateParam := happyStateParam
+	oidcStateParam.T = "oidc"
+	oidcState := encodeState(oidcStateParam)
+
+	wrongCSRFStateParam := happyStateParam
+	wrongCSRFStateParam.C = "wrong-csrf"
+	wrongCSRFState := encodeState(wrongCSRFStateParam)
+
+	wrongVersionStateParam := happyStateParam
+	wrongVersionStateParam.V = "wrong-version"
+	wrongVersionState := encodeState(wrongVersionStateParam)
+
+	encodedIncomingCookieCSRFValue, err := happyCookieCodec.Encode("csrf", happyDownstreamCSRF)
+	require.NoError(t, err)
+	happyCSRFCookie := "__Host-pinniped-csrf=" + encodedIncomingCookieCSRFValue
+
+	parsedUpstreamLDAPURL, err := url.Parse(upstreamLDAPURL)
+	require.NoError(t, err)
+
+	happyUpstream := func() *oidctestutil.TestUpstreamLDAPIdentityProvider {
+		return &oidctestutil.TestUpstreamLDAPIdentityProvider{
+			Name: happyUpstreamIDPName,
+			URL:  parsedUpstreamLDAPURL,
+			AuthenticateFunc: func(ctx context.Context, username, password string) (*authenticators.Response, bool, error) {
+				if username == "" || password == "" {
+					return nil, false, errors.New("should not have passed empty username or password to the authenticator")
+				}
+				if username == happyLDAPUsername && password == happyLDAPPassword {
+					return &authenticators.Response{
+						User: &user.DefaultInfo{
+							Name:   happyLDAPUsernameFromAuthenticator,
+							UID:    happyLDAPUID,
+							Groups: happyLDAPGroups,
+						},
+						DN: happyLDAPUserDN,
+					}, true, nil
+				}
+				return nil, false, nil
+			},
+		}
+	}
+
+	erroringUpstream := &oidctestutil.TestUpstreamLDAPIdentityProvider{
+		Name: happyUpstreamIDPName,
+		AuthenticateFunc: func(ctx context.Context, username, password string) (*authenticators.Response, bool, error) {
+			return nil, false, errors.New("some ldap upstream auth error")
+		},
+	}
+
+	loginPage := func(state string, username string, errorMessage string) string {
+		var buf bytes.Buffer
+		require.NoError(t, loginhtml.Template().Execute(&buf, &loginhtml.PageData{
+			State:        state,
+			UpstreamName: happyUpstreamIDPName,
+			Username:     username,
+			ErrorMessage: errorMessage,
+		}))
+		return buf.String()
+	}
+
+	loginForm := func(state, username, password string) string {
+		return url.Values{"state": []string{state}, "username": []string{username}, "password": []string{password}}.Encode()
+	}
+
+	// Note that fosite puts the granted scopes as a param in the redirect URI even though the spec doesn't seem to require it
+	happyDownstreamRedirectLocationRegexp := downstreamRedirectURI + `\?code=([^&]+)&scope=openid&state=` + happyDownstreamState
+
+	tests := []struct {
+		name string
+
+		ldap               *oidctestutil.TestUpstreamLDAPIdentityProvider
+		activeDirectory    *oidctestutil.TestUpstreamLDAPIdentityProvider
+		identityTransforms oidctestutil.TestIdentityTransforms
+		method             string
+		path               string
+		body               string
+		csrfCookie         string
+
+		wantStatus         int
+		wantContentType    string
+		wantBody           string
+		wantCSP            string
+		wantLocationHeader string
+
+		// For when the login succeeded and an authcode is being returned.
+		wantRedirectLocationRegexp      string
+		wantDownstreamIDTokenSubject    string
+		wantDownstreamIDTokenUsername   string
+		wantDownstreamIDTokenGroups     []string
+		wantDownstreamCustomSessionData *psession.CustomSessionData
+
+		// The audit event which was written, ignoring the fields which are filled in by the auditlog package.
+		wantAuditEvent *auditlog.Event
+	}{
+		{
+			name:            "GET shows the login page",
+			ldap:            happyUpstream(),
+			method:          http.MethodGet,
+			path:            "/login?state=" + url.QueryEscape(happyState),
+			csrfCookie:      happyCSRFCookie,
+			wantStatus:      http.StatusOK,
+			wantContentType: htmlContentType,
+			wantBody:        loginPage(happyState, "", ""),
+			wantCSP:         loginhtml.ContentSecurityPolicy(),
+		},
+		{
+			name:            "GET shows the login page for an Active Directory upstream",
+			activeDirectory: happyUpstream(),
+			method:          http.MethodGet,
+			path:            "/login?state=" + url.QueryEscape(activeDirectoryState),
+			csrfCookie:      happyCSRFCookie,
+			wantStatus:      http.StatusOK,
+			wantContentType: htmlContentType,
+			wantBody:        loginPage(activeDirectoryState, "", ""),
+			wantCSP:         loginhtml.ContentSecurityPolicy(),
+		},
+		{
+			name:                            "POST with the right username and password redirects to the downstream client with an authcode",
+			ldap:                            happyUpstream(),
+			method:                          http.MethodPost,
+			path:                            "/login",
+			body:                            loginForm(happyState, happyLDAPUsername, happyLDAPPassword),
+			csrfCookie:                      happyCSRFCookie,
+			wantStatus:                      http.StatusFound,
+			wantContentType:                 htmlContentType,
+			wantCSP:                         formposthtml.ContentSecurityPolicy(),
+			wantRedirectLocationRegexp:      happyDownstreamRedirectLocationRegexp,
+			wantDownstreamIDTokenSubject:    upstreamLDAPURL + "&sub=" + happyLDAPUID,
+			wantDownstreamIDTokenUsername:   happyLDAPUsernameFromAuthenticator,
+			wantDownstreamIDTokenGroups:     happyLDAPGroups,
+			wantDownstreamCustomSessionData: &psession.CustomSessionData{ProviderName: happyUpstreamIDPName, ProviderType: "ldap", LDAP: &psession.LDAPSessionData{UserDN: happyLDAPUserDN}},
+			wantAuditEvent: &auditlog.Event{
+				Type:            auditlog.EventTypeLogin,
+				Result:          auditlog.ResultSuccess,
+				ClientID:        downstreamClientID,
+				UpstreamIDPName: happyUpstreamIDPName,
+				UpstreamIDPType: "ldap",
+				Subject:         upstreamLDAPURL + "&sub=" + happyLDAPUID,
+				Username:        happyLDAPUsernameFromAuthenticator,
+				Groups:          happyLDAPGroups,
+			},
+		},
+		{
+			name:                            "POST with the right username and password for an Active Directory upstream",
+			activeDirectory:                 happyUpstream(),
+			method:                          http.MethodPost,
+			path:                            "/login",
+			body:                            loginForm(activeDirectoryState, happyLDAPUsername, happyLDAPPassword),
+			csrfCookie:                      happyCSRFCookie,
+			wantStatus:                      http.StatusFound,
+			wantContentType:                 htmlContentType,
+			wantCSP:                         formposthtml.ContentSecurityPolicy(),
+			wantRedirectLocationRegexp:      happyDownstreamRedirectLocationRegexp,
+			wantDownstreamIDTokenSubject:    upstreamLDAPURL + "&sub=" + happyLDAPUID,
+			wantDownstreamIDTokenUsername:   happyLDAPUsernameFromAuthenticator,
+			wantDownstreamIDTokenGroups:     happyLDAPGroups,
+			wantDownstreamCustomSessionData: &psession.CustomSessionData{ProviderName: happyUpstreamIDPName, ProviderType: "activedirectory", LDAP: &psession.LDAPSessionData{UserDN: happyLDAPUserDN}},
+		},
+		{
+			name:            "POST with the wrong password shows the login page again with an error",
+			ldap:            happyUpstream(),
+			method:          http.MethodPost,
+			path:            "/login",
+			body:            loginForm(happyState, happyLDAPUsername, "wrong-password"),
+			csrfCookie:      happyCSRFCookie,
+			wantStatus:      http.StatusOK,
+			wantContentType: htmlContentType,
+			wantBody:        loginPage(happyState, happyLDAPUsername, "Incorrect username or password."),
+			wantCSP:         loginhtml.ContentSecurityPolicy(),
+			wantAuditEvent: &auditlog.Event{
+				Type:            auditlog.EventTypeFailedCredentials,
+				Result:          auditlog.ResultFailure,
+				Reason:          "username/password not accepted by upstream",
+				ClientID:        downstreamClientID,
+				UpstreamIDPName: happyUpstreamIDPName,
+				UpstreamIDPType: "ldap",
+				Username:        happyLDAPUsername,
+			},
+		},
+		{
+			name:            "POST with a blank password shows the login page again with an error",
+			ldap:            happyUpstream(),
+			method:          http.MethodPost,
+			path:            "/login",
+			body:            loginForm(happyState, happyLDAPUsername, ""),
+			csrfCookie:      happyCSRFCookie,
+			wantStatus:      http.StatusOK,
+			wantContentType: htmlContentType,
+			wantBody:        loginPage(happyState, happyLDAPUsername, "Please enter your username and password."),
+			wantCSP:         loginhtml.ContentSecurityPolicy(),
+		},
+		{
+			name:            "POST when the upstream returns an error",
+			ldap:            erroringUpstream,
+			method:          http.MethodPost,
+			path:            "/login",
+			body:            loginForm(happyState, happyLDAPUsername, happyLDAPPassword),
+			csrfCookie:      happyCSRFCookie,
+			wantStatus:      http.StatusBadGateway,
+			wantContentType: "text/plain; charset=utf-8",
+			wantBody:        "Bad Gateway: unexpected error during upstream authentication\n",
+			wantAuditEvent: &auditlog.Event{
+				Type:            auditlog.EventTypeLogin,
+				Result:          auditlog.ResultError,
+				Reason:          "unexpected error during upstream authentication",
+				ClientID:        downstreamClientID,
+				UpstreamIDPName: happyUpstreamIDPName,
+				UpstreamIDPType: "ldap",
+				Username:        happyLDAPUsername,
+			},
+		},
+		{
+			name: "POST when the user is rejected by the identity transforms redirects to the downstream client with an access_denied error",
+			ldap: happyUpstream(),
+			identityTransforms: oidctestutil.NewIdentityTransforms(t, happyUpstreamIDPName,
+				configv1alpha1.FederationDomainTransform{Type: configv1alpha1.RequireGroupFederationDomainTransformType, Groups: []string{"k8s-users"}},
+			),
+			method:          http.MethodPost,
+			path:            "/login",
+			body:            loginForm(happyState, happyLDAPUsername, happyLDAPPassword),
+			csrfCookie:      happyCSRFCookie,
+			wantStatus:      http.StatusFound,
+			wantContentType: "application/json; charset=utf-8",
+			wantLocationHeader: downstreamRedirectURI + "?" + url.Values{
+				"error":             []string{"access_denied"},
+				"error_description": []string{"The resource owner or authorization server denied the request. Login was rejected: user is not a member of any of the required groups."},
+				"state":             []string{happyDownstreamState},
+			}.Encode(),
+			wantAuditEvent: &auditlog.Event{
+				Type:            auditlog.EventTypeLogin,
+				Result:          auditlog.ResultFailure,
+				Reason:          "user is not a member of any of the required groups",
+				ClientID:        downstreamClientID,
+				UpstreamIDPName: happyUpstreamIDPName,
+				UpstreamIDPType: "ldap",
+				Username:        happyLDAPUsername,
+			},
+		},
+		{
+			name:            "PUT is a bad method",
+			ldap:            happyUpstream(),
+			method:          http.MethodPut,
+			path:            "/login?state=" + url.QueryEscape(happyState),
+			csrfCookie:      happyCSRFCookie,
+			wantStatus:      http.StatusMethodNotAllowed,
+			wantContentType: "text/plain; charset=utf-8",
+			wantBody:        "Method Not Allowed: PUT (try GET or POST)\n",
+		},
+		{
+			name:            "missing CSRF cookie",
+			ldap:            happyUpstream(),
+			method:          http.MethodGet,
+			path:            "/login?state=" + url.QueryEscape(happyState),
+			wantStatus:      http.StatusForbidden,
+			wantContentType: "text/plain; charset=utf-8",
+			wantBody:        "Forbidden: CSRF cookie is missing\n",
+		},
+		{
+			name:            "CSRF cookie which cannot be decoded",
+			ldap:            happyUpstream(),
+			method:          http.MethodGet,
+			path:            "/login?state=" + url.QueryEscape(happyState),
+			csrfCookie:      "__Host-pinniped-csrf=this-value-was-not-signed-by-pinniped",
+			wantStatus:      http.StatusForbidden,
+			wantContentType: "text/plain; charset=utf-8",
+			wantBody:        "Forbidden: error reading CSRF cookie\n",
+		},
+		{
+			name:            "missing state param",
+			ldap:            happyUpstream(),
+			method:          http.MethodGet,
+			path:            "/login",
+			csrfCookie:      happyCSRFCookie,
+			wantStatus:      http.StatusBadRequest,
+			wantContentType: "text/plain; charset=utf-8",
+			wantBody:        "Bad Request: state param not found\n",
+		},
+		{
+			name:            "state param which cannot be decoded",
+			ldap:            happyUpstream(),
+			method:          http.MethodGet,
+			path:            "/login?state=this-value-was-not-signed-by-pinniped",
+			csrfCookie:      happyCSRFCookie,
+			wantStatus:      http.StatusBadRequest,
+			wantContentType: "text/plain; charset=utf-8",
+			wantBody:        "Bad Request: error reading state\n",
+		},
+		{
+			name:            "state param with the wrong format version",
+			ldap:            happyUpstream(),
+			method:          http.MethodGet,
+			path:            "/login?state=" + url.QueryEscape(wrongVersionState),
+			csrfCookie:      happyCSRFCookie,
+			wantStatus:      http.StatusUnprocessableEntity,
+			wantContentType: "text/plain; charset=utf-8",
+			wantBody:        "Unprocessable Entity: state format version is invalid\n",
+		},
+		{
+			name:            "state param with a CSRF value which does not match the cookie",
+			ldap:            happyUpstream(),
+			method:          http.MethodPost,
+			path:            "/login",
+			body:            loginForm(wrongCSRFState, happyLDAPUsername, happyLDAPPassword),
+			csrfCookie:      happyCSRFCookie,
+			wantStatus:      http.StatusForbidden,
+			wantContentType: "text/plain; charset=utf-8",
+			wantBody:        "Forbidden: CSRF value does not match\n",
+		},
+		{
+			name:            "upstream with the name from the state param is not an LDAP upstream",
+			ldap:            happyUpstream(),
+			method:          http.MethodGet,
+			path:            "/login?state=" + url.QueryEscape(oidcState),
+			csrfCookie:      happyCSRFCookie,
+			wantStatus:      http.StatusUnprocessableEntity,
+			wantContentType: "text/plain; charset=utf-8",
+			wantBody:        "Unprocessable Entity: upstream provider not found\n",
+		},
+		{
+			name:            "upstream with the name from the state param no longer exists",
+			activeDirectory: happyUpstream(),
+			method:          http.MethodPost,
+			path:            "/login",
+			body:            loginForm(happyState, happyLDAPUsername, happyLDAPPassword),
+			csrfCookie:      happyCSRFCookie,
+			wantStatus:      http.StatusUnprocessableEntity,
+			wantContentType: "text/plain; charset=utf-8",
+			wantBody:        "Unprocessable Entity: upstream provider not found\n",
+		},
+	}
+	for _, test := range tests {
+		test := test
+
+		t.Run(test.name, func(t *testing.T) {
+			client := fake.NewSimpleClientset()
+			secrets := client.CoreV1().Secrets("some-namespace")
+
+			// Configure fosite the same way that the production code would.
+			timeoutsConfiguration := oidc.DefaultOIDCTimeoutsConfiguration()
+			oauthStore := oidc.NewKubeStorage(crud.NewSecretsBackend(secrets), &clientregistry.StaticClientManager{}, timeoutsConfiguration)
+			hmacSecretFunc := func() []byte { return []byte("some secret - must have at least 32 bytes") }
+			oauthHelper := oidc.FositeOauth2Helper(oauthStore, downstreamIssuer, hmacSecretFunc, jwks.NewDynamicJWKSProvider(), timeoutsConfiguration)
+
+			idpListerBuilder := oidctestutil.NewUpstreamIDPListerBuilder()
+			if test.ldap != nil {
+				idpListerBuilder = idpListerBuilder.WithLDAP(test.ldap)
+			}
+			if test.activeDirectory != nil {
+				idpListerBuilder = idpListerBuilder.WithActiveDirectory(test.activeDirectory)
+			}
+
+			subject := NewHandler(idpListerBuilder.Build(), test.identityTransforms, oauthHelper, happyStateCodec, happyCookieCodec)
+			req := httptest.NewRequest(test.method, test.path, strings.NewReader(test.body))
+			if test.body != "" {
+				req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+			}
+			if test.csrfCookie != "" {
+				req.Header.Set("Cookie", test.csrfCookie)
+			}
+			var auditEvents bytes.Buffer
+			auditlog.SetSink(&auditEvents)
+			defer auditlog.SetSink(nil)
+			rsp := httptest.NewRecorder()
+			subject.ServeHTTP(rsp, req)
+			t.Logf("response: %#v", rsp)
+			t.Logf("response body: %q", rsp.Body.String())
+
+			testutil.RequireSecurityHeaders(t, rsp)
+			if test.wantCSP != "" {
+				require.Equal(t, test.wantCSP, rsp.Header().Get("Content-Security-Policy"))
+			}
+
+			if test.wantAuditEvent != nil {
+				var got auditlog.Event
+				require.NoError(t, json.Unmarshal(auditEvents.Bytes(), &got))
+				got.Version, got.Timestamp, got.SourceIP = "", time.Time{}, ""
+				require.Equal(t, test.wantAuditEvent, &got)
+			}
+
+			require.Equal(t, test.wantStatus, rsp.Code)
+			testutil.RequireEqualContentType(t, rsp.Header().Get("Content-Type"), test.wantContentType)
+
+			switch {
+			case test.wantRedirectLocationRegexp != "":
+				require.Len(t, rsp.Header().Values("Location"), 1)
+				oidctestutil.RequireAuthCodeRegexpMatch(
+					t,
+					rsp.Header().Get("Location"),
+					test.wantRedirectLocationRegexp,
+					client,
+					secrets,
+					oauthStore,
+					happyDownstreamScopesGranted,
+					test.wantDownstreamIDTokenSubject,
+					test.wantDownstreamIDTokenUsername,
+					test.wantDownstreamIDTokenGroups,
+					nil,
+					happyDownstreamScopesRequested,
+					downstreamPKCEChallenge,
+					downstreamPKCEChallengeMethod,
+					downstreamNonce,
+					downstreamClientID,
+					downstreamRedirectURI,
+					test.wantDownstreamCustomSessionData,
+				)
+			case test.wantLocationHeader != "":
+				require.Equal(t, test.wantLocationHeader, rsp.Header().Get("Location"))
+				require.Empty(t, rsp.Body.String())
+			default:
+				require.Empty(t, rsp.Header().Values("Location"))
+				require.Equal(t, test.wantBody, rsp.Body.String())
+				// Nothing should be stored unless an authcode was issued.
+				require.Empty(t, client.Actions())
+			}
+		})
+	}
+}
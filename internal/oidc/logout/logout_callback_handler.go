@@ -0,0 +1,41 @@
+// Copyright 2021 the Pinniped contributors. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package logout
+
+import (
+	"net/http"
+
+	"go.pinniped.dev/internal/httputil/httperr"
+	"go.pinniped.dev/internal/oidc"
+	"go.pinniped.dev/internal/plog"
+)
+
+// NewCallbackHandler returns an http.Handler that serves the logout callback endpoint, to which the end_session_endpoint
+// of an upstream OIDC provider sends the end user's browser back after they logged out of the upstream. The state param
+// was encoded by the handler returned by NewHandler, so it can be trusted to hold a post_logout_redirect_uri which was
+// already validated for the client that is logging out. The browser is sent on to that redirect URI.
+func NewCallbackHandler(upstreamStateDecoder oidc.Decoder) http.Handler {
+	return httperr.HandlerFunc(func(w http.ResponseWriter, r *http.Request) error {
+		if r.Method != http.MethodGet {
+			return httperr.Newf(http.StatusMethodNotAllowed, "%s (try GET)", r.Method)
+		}
+
+		encodedState := r.URL.Query().Get("state")
+		if encodedState == "" {
+			plog.Info("state param not found")
+			return httperr.New(http.StatusBadRequest, "state param not found")
+		}
+
+		var state oidc.UpstreamLogoutStateParamData
+		if err := upstreamStateDecoder.Decode(oidc.UpstreamLogoutStateParamEncodingName, encodedState, &state); err != nil {
+			plog.InfoErr("error reading upstream logout state", err)
+			return httperr.New(http.StatusBadRequest, "error reading state")
+		}
+		if state.FormatVersion != oidc.UpstreamStateParamFormatVersion {
+			return httperr.New(http.StatusUnprocessableEntity, "state format version is invalid")
+		}
+
+		return redirectToClient(w, r, state.PostLogoutRedirectURI, state.State)
+	})
+}
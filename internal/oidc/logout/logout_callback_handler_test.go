@@ -0,0 +1,113 @@
+// Copyright 2021 the Pinniped contributors. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package logout
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"go.pinniped.dev/internal/oidc"
+)
+
+func TestLogoutCallbackHandler(t *testing.T) {
+	encode := func(name string, state oidc.UpstreamLogoutStateParamData) string {
+		encoded, err := testCodec{}.Encode(name, state)
+		require.NoError(t, err)
+		return encoded
+	}
+
+	tests := []struct {
+		name         string
+		method       string
+		params       url.Values
+		wantStatus   int
+		wantBody     string
+		wantLocation string
+	}{
+		{
+			name: "redirects to the post_logout_redirect_uri of the client with its state",
+			params: url.Values{"state": {encode(oidc.UpstreamLogoutStateParamEncodingName, oidc.UpstreamLogoutStateParamData{
+				PostLogoutRedirectURI: postLogoutRedirectURI,
+				State:                 "some-state",
+				FormatVersion:         "1",
+			})}},
+			wantStatus:   http.StatusSeeOther,
+			wantLocation: postLogoutRedirectURI + "?state=some-state",
+		},
+		{
+			name: "redirects to the post_logout_redirect_uri of the client without state",
+			params: url.Values{"state": {encode(oidc.UpstreamLogoutStateParamEncodingName, oidc.UpstreamLogoutStateParamData{
+				PostLogoutRedirectURI: postLogoutRedirectURI,
+				FormatVersion:         "1",
+			})}},
+			wantStatus:   http.StatusSeeOther,
+			wantLocation: postLogoutRedirectURI,
+		},
+		{
+			name: "client did not request a redirect",
+			params: url.Values{"state": {encode(oidc.UpstreamLogoutStateParamEncodingName, oidc.UpstreamLogoutStateParamData{
+				FormatVersion: "1",
+			})}},
+			wantStatus: http.StatusOK,
+			wantBody:   "You have been logged out.\n",
+		},
+		{
+			name:       "missing state",
+			wantStatus: http.StatusBadRequest,
+			wantBody:   "Bad Request: state param not found\n",
+		},
+		{
+			name:       "undecodable state",
+			params:     url.Values{"state": {"not-encoded"}},
+			wantStatus: http.StatusBadRequest,
+			wantBody:   "Bad Request: error reading state\n",
+		},
+		{
+			name: "state of a login instead of a logout",
+			params: url.Values{"state": {encode(oidc.UpstreamStateParamEncodingName, oidc.UpstreamLogoutStateParamData{
+				PostLogoutRedirectURI: "https://example.com/evil",
+				FormatVersion:         "1",
+			})}},
+			wantStatus: http.StatusBadRequest,
+			wantBody:   "Bad Request: error reading state\n",
+		},
+		{
+			name: "wrong state format version",
+			params: url.Values{"state": {encode(oidc.UpstreamLogoutStateParamEncodingName, oidc.UpstreamLogoutStateParamData{
+				PostLogoutRedirectURI: postLogoutRedirectURI,
+				FormatVersion:         "2",
+			})}},
+			wantStatus: http.StatusUnprocessableEntity,
+			wantBody:   "Unprocessable Entity: state format version is invalid\n",
+		},
+		{
+			name:       "wrong method",
+			method:     http.MethodPost,
+			wantStatus: http.StatusMethodNotAllowed,
+			wantBody:   "Method Not Allowed: POST (try GET)\n",
+		},
+	}
+	for _, test := range tests {
+		test := test
+		t.Run(test.name, func(t *testing.T) {
+			method := test.method
+			if method == "" {
+				method = http.MethodGet
+			}
+			req := httptest.NewRequest(method, "/logout/callback?"+test.params.Encode(), nil)
+			rsp := httptest.NewRecorder()
+			NewCallbackHandler(testCodec{}).ServeHTTP(rsp, req)
+
+			require.Equal(t, test.wantStatus, rsp.Code, rsp.Body.String())
+			if test.wantBody != "" {
+				require.Equal(t, test.wantBody, rsp.Body.String())
+			}
+			require.Equal(t, test.wantLocation, rsp.Header().Get("Location"))
+		})
+	}
+}
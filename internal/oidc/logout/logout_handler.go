@@ -0,0 +1,299 @@
+// Copyright 2021 the Pinniped contributors. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+// Package logout provides a handler for the OIDC end session endpoint.
+package logout
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+
+	"github.com/ory/fosite"
+	"gopkg.in/square/go-jose.v2"
+	"gopkg.in/square/go-jose.v2/jwt"
+
+	"go.pinniped.dev/internal/auditlog"
+	"go.pinniped.dev/internal/crud"
+	"go.pinniped.dev/internal/httputil/httperr"
+	"go.pinniped.dev/internal/oidc"
+	"go.pinniped.dev/internal/oidc/clientregistry"
+	"go.pinniped.dev/internal/oidc/jwks"
+	"go.pinniped.dev/internal/oidc/provider"
+	"go.pinniped.dev/internal/plog"
+	"go.pinniped.dev/internal/psession"
+)
+
+// SessionStorage is the subset of the Supervisor's session storage which is used to end a session.
+type SessionStorage interface {
+	GetRefreshTokenSessionByRequestID(ctx context.Context, requestID string) (fosite.Requester, error)
+	GetAccessTokenSessionByRequestID(ctx context.Context, requestID string) (fosite.Requester, error)
+	RevokeRefreshToken(ctx context.Context, requestID string) error
+	RevokeAccessToken(ctx context.Context, requestID string) error
+}
+
+// idTokenClaims are the claims of the id_token_hint which are used to find the session to end.
+type idTokenClaims struct {
+	jwt.Claims
+	SessionID string `json:"sid"`
+}
+
+// NewHandler returns an http.Handler that serves an end session endpoint, as described in
+// https://openid.net/specs/openid-connect-rpinitiated-1_0.html.
+//
+// The session to end is identified by the sid claim of the id_token_hint, which must be an ID token that was issued
+// by this FederationDomain. The ID token may have expired. All of the stored access tokens and refresh tokens of
+// the session are revoked. When the session was started using an upstream OIDC provider whose end session endpoint
+// is known, the end user's browser is then redirected to it to also end the upstream session. The upstream sends the
+// browser back to the logout callback endpoint, which is served by NewCallbackHandler. Otherwise, the browser is
+// redirected to the post_logout_redirect_uri, when the client requested one.
+func NewHandler(
+	issuer string,
+	idpLister oidc.UpstreamIdentityProvidersLister,
+	jwksProvider jwks.DynamicJWKSProvider,
+	clientManager fosite.ClientManager,
+	sessionStorage SessionStorage,
+	upstreamStateEncoder oidc.Encoder,
+) http.Handler {
+	return httperr.HandlerFunc(func(w http.ResponseWriter, r *http.Request) error {
+		if r.Method != http.MethodGet && r.Method != http.MethodPost {
+			return httperr.Newf(http.StatusMethodNotAllowed, "%s (try GET or POST)", r.Method)
+		}
+		if err := r.ParseForm(); err != nil {
+			return httperr.Wrap(http.StatusBadRequest, "error parsing request params", err)
+		}
+
+		idTokenHint := r.Form.Get("id_token_hint")
+		if idTokenHint == "" {
+			return httperr.New(http.StatusBadRequest, "id_token_hint param not found")
+		}
+		claims, err := verifyIDTokenHint(issuer, jwksProvider, idTokenHint)
+		if err != nil {
+			plog.Info("logout request has invalid id_token_hint", "err", err.Error())
+			return httperr.New(http.StatusBadRequest, "invalid id_token_hint")
+		}
+
+		clientID, err := clientIDOfLogoutRequest(r.Form.Get("client_id"), claims.Audience)
+		if err != nil {
+			return err
+		}
+
+		postLogoutRedirectURI := r.Form.Get("post_logout_redirect_uri")
+		if postLogoutRedirectURI != "" {
+			if err := validatePostLogoutRedirectURI(r.Context(), clientManager, clientID, postLogoutRedirectURI); err != nil {
+				return err
+			}
+		}
+		state := r.Form.Get("state")
+
+		// Find the upstream of the session before its tokens are revoked, because they are the only place where it is stored.
+		upstreamSession := findUpstreamSessionData(r.Context(), sessionStorage, claims.SessionID)
+		var upstreamName, upstreamType string
+		if upstreamSession != nil {
+			upstreamName, upstreamType = upstreamSession.ProviderName, upstreamSession.ProviderType
+		}
+
+		if err := revokeSession(r.Context(), sessionStorage, claims.SessionID); err != nil {
+			plog.WarningErr("error revoking session during logout", err, "sessionID", claims.SessionID)
+			return httperr.New(http.StatusServiceUnavailable, "error revoking session")
+		}
+
+		auditlog.Record(r, auditlog.Event{
+			Type:            auditlog.EventTypeLogout,
+			Result:          auditlog.ResultSuccess,
+			ClientID:        clientID,
+			UpstreamIDPName: upstreamName,
+			UpstreamIDPType: upstreamType,
+			Subject:         claims.Subject,
+		})
+
+		if upstreamType == oidc.IDPTypeOIDC {
+			if upstream := oidc.FindUpstreamOIDCIdentityProvider(upstreamName, idpLister); upstream != nil && upstream.GetEndSessionURL() != nil {
+				upstreamEndSessionURL, err := upstreamEndSessionRedirectURL(issuer, upstream, upstreamSession, upstreamStateEncoder, postLogoutRedirectURI, state)
+				if err != nil {
+					plog.Error("error encoding upstream logout state param", err)
+					return httperr.Wrap(http.StatusInternalServerError, "error encoding upstream state param", err)
+				}
+				http.Redirect(w, r, upstreamEndSessionURL, http.StatusSeeOther)
+				return nil
+			}
+		}
+
+		return redirectToClient(w, r, postLogoutRedirectURI, state)
+	})
+}
+
+// upstreamEndSessionRedirectURL returns the URL of the end_session_endpoint of the upstream OIDC provider. The
+// upstream is asked to send the browser back to the logout callback endpoint of the FederationDomain, because only
+// the Supervisor's own redirect URI can be registered with the upstream. The already validated
+// post_logout_redirect_uri and state of the client are carried in the encoded state param, so the logout callback
+// endpoint can send the browser on to the client.
+func upstreamEndSessionRedirectURL(
+	issuer string,
+	upstream provider.UpstreamOIDCIdentityProviderI,
+	upstreamSession *psession.CustomSessionData,
+	upstreamStateEncoder oidc.Encoder,
+	postLogoutRedirectURI string,
+	state string,
+) (string, error) {
+	encodedState, err := upstreamStateEncoder.Encode(oidc.UpstreamLogoutStateParamEncodingName, oidc.UpstreamLogoutStateParamData{
+		PostLogoutRedirectURI: postLogoutRedirectURI,
+		State:                 state,
+		FormatVersion:         oidc.UpstreamStateParamFormatVersion,
+	})
+	if err != nil {
+		return "", err
+	}
+
+	upstreamEndSessionURL := *upstream.GetEndSessionURL()
+	params := upstreamEndSessionURL.Query()
+	params.Set("client_id", upstream.GetClientID())
+	if upstreamSession.OIDC != nil && upstreamSession.OIDC.UpstreamIDToken != "" {
+		params.Set("id_token_hint", upstreamSession.OIDC.UpstreamIDToken)
+	}
+	params.Set("post_logout_redirect_uri", issuer+oidc.LogoutCallbackEndpointPath)
+	params.Set("state", encodedState)
+	upstreamEndSessionURL.RawQuery = params.Encode()
+	return upstreamEndSessionURL.String(), nil
+}
+
+// redirectToClient sends the browser to the post_logout_redirect_uri of the client, or tells the end user that they
+// have been logged out when the client did not request a redirect.
+func redirectToClient(w http.ResponseWriter, r *http.Request, postLogoutRedirectURI string, state string) error {
+	if postLogoutRedirectURI != "" {
+		redirectURL, err := url.Parse(postLogoutRedirectURI)
+		if err != nil {
+			return httperr.Wrap(http.StatusBadRequest, "invalid post_logout_redirect_uri", err)
+		}
+		if state != "" {
+			params := redirectURL.Query()
+			params.Set("state", state)
+			redirectURL.RawQuery = params.Encode()
+		}
+		http.Redirect(w, r, redirectURL.String(), http.StatusSeeOther)
+		return nil
+	}
+
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	_, _ = fmt.Fprintln(w, "You have been logged out.")
+	return nil
+}
+
+// verifyIDTokenHint checks that the ID token was signed by one of the keys of the FederationDomain and was issued by
+// it. The expiration of the ID token is deliberately not checked, because end users often log out after the ID
+// tokens of their clients have expired.
+func verifyIDTokenHint(issuer string, jwksProvider jwks.DynamicJWKSProvider, idTokenHint string) (*idTokenClaims, error) {
+	token, err := jwt.ParseSigned(idTokenHint)
+	if err != nil {
+		return nil, fmt.Errorf("could not parse ID token: %w", err)
+	}
+	if len(token.Headers) != 1 {
+		return nil, fmt.Errorf("ID token must have exactly one signature, not %d", len(token.Headers))
+	}
+
+	keySet, _ := jwksProvider.GetJWKS(issuer)
+	if keySet == nil {
+		return nil, fmt.Errorf("no signing keys found for issuer %s", issuer)
+	}
+	var keys []jose.JSONWebKey
+	if keyID := token.Headers[0].KeyID; keyID != "" {
+		keys = keySet.Key(keyID)
+	} else {
+		keys = keySet.Keys
+	}
+
+	claims := &idTokenClaims{}
+	verified := false
+	for _, key := range keys {
+		if err := token.Claims(key.Public().Key, claims); err == nil {
+			verified = true
+			break
+		}
+	}
+	if !verified {
+		return nil, fmt.Errorf("ID token was not signed by any of the signing keys of issuer %s", issuer)
+	}
+
+	if claims.Issuer != issuer {
+		return nil, fmt.Errorf("ID token has issuer %q instead of %q", claims.Issuer, issuer)
+	}
+	if claims.SessionID == "" {
+		return nil, fmt.Errorf("ID token has no %s claim", oidc.DownstreamSessionIDClaim)
+	}
+	return claims, nil
+}
+
+// clientIDOfLogoutRequest determines the client which is logging out from its client_id param, or else from the
+// audience of its ID token. It returns an empty string when the client cannot be determined.
+func clientIDOfLogoutRequest(clientIDParam string, audience jwt.Audience) (string, error) {
+	if clientIDParam != "" {
+		if !audience.Contains(clientIDParam) {
+			return "", httperr.New(http.StatusBadRequest, "client_id param does not match the audience of the id_token_hint")
+		}
+		return clientIDParam, nil
+	}
+	if len(audience) == 1 {
+		return audience[0], nil
+	}
+	return "", nil
+}
+
+func validatePostLogoutRedirectURI(ctx context.Context, clientManager fosite.ClientManager, clientID string, postLogoutRedirectURI string) error {
+	if clientID == "" {
+		return httperr.New(http.StatusBadRequest, "client_id param is required when the id_token_hint has more than one audience")
+	}
+	client, err := clientManager.GetClient(ctx, clientID)
+	if err != nil {
+		plog.Info("logout request for unknown client", "clientID", clientID)
+		return httperr.New(http.StatusBadRequest, "post_logout_redirect_uri is not registered for the client")
+	}
+	pinnipedClient, ok := client.(*clientregistry.Client)
+	if !ok {
+		return httperr.New(http.StatusBadRequest, "post_logout_redirect_uri is not registered for the client")
+	}
+	for _, allowedURI := range pinnipedClient.GetPostLogoutRedirectURIs() {
+		if allowedURI == postLogoutRedirectURI {
+			return nil
+		}
+	}
+	return httperr.New(http.StatusBadRequest, "post_logout_redirect_uri is not registered for the client")
+}
+
+// findUpstreamSessionData returns the data of the session about its upstream identity provider, or nil when none of
+// the tokens of the session are stored anymore.
+func findUpstreamSessionData(ctx context.Context, sessionStorage SessionStorage, sessionID string) *psession.CustomSessionData {
+	for _, getSession := range []func(ctx context.Context, requestID string) (fosite.Requester, error){
+		sessionStorage.GetRefreshTokenSessionByRequestID,
+		sessionStorage.GetAccessTokenSessionByRequestID,
+	} {
+		requester, err := getSession(ctx, sessionID)
+		if err != nil {
+			if !errors.Is(err, fosite.ErrNotFound) {
+				plog.WarningErr("error reading session during logout", err, "sessionID", sessionID)
+			}
+			continue
+		}
+		session, ok := requester.GetSession().(*psession.PinnipedSession)
+		if !ok || session.Custom == nil {
+			continue
+		}
+		return session.Custom
+	}
+	return nil
+}
+
+// revokeSession deletes all of the stored refresh tokens and access tokens of the session. It is not an error
+// when the session has no stored tokens, e.g. because it was already logged out or had expired.
+func revokeSession(ctx context.Context, sessionStorage SessionStorage, sessionID string) error {
+	for _, revoke := range []func(ctx context.Context, requestID string) error{
+		sessionStorage.RevokeRefreshToken,
+		sessionStorage.RevokeAccessToken,
+	} {
+		if err := revoke(ctx, sessionID); err != nil && !errors.Is(err, crud.ErrNoSecretsFound) {
+			return err
+		}
+	}
+	return nil
+}
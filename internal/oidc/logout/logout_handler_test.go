@@ -0,0 +1,400 @@
+// Copyright 2021 the Pinniped contributors. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package logout
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ory/fosite"
+	"github.com/ory/fosite/compose"
+	"github.com/ory/fosite/handler/openid"
+	fositejwt "github.com/ory/fosite/token/jwt"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/crypto/bcrypt"
+	"gopkg.in/square/go-jose.v2"
+	"gopkg.in/square/go-jose.v2/jwt"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+
+	"go.pinniped.dev/internal/crud"
+	"go.pinniped.dev/internal/oidc"
+	"go.pinniped.dev/internal/oidc/clientregistry"
+	"go.pinniped.dev/internal/oidc/jwks"
+	"go.pinniped.dev/internal/psession"
+	"go.pinniped.dev/internal/testutil/oidctestutil"
+)
+
+const (
+	issuer     = "https://some-issuer.com"
+	hmacSecret = "this needs to be at least 32 characters to meet entropy requirements"
+
+	confidentialClientID  = "some-confidential-client"
+	sessionID             = "some-request-id"
+	upstreamName          = "some-upstream"
+	postLogoutRedirectURI = "https://example.com/logged-out"
+	upstreamIDToken       = "some-upstream-id-token"
+)
+
+func TestLogoutHandler(t *testing.T) {
+	hashedClientSecret, err := bcrypt.GenerateFromPassword([]byte("some-client-secret"), bcrypt.MinCost)
+	require.NoError(t, err)
+
+	signingKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	otherKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+
+	upstreamEndSessionURL, err := url.Parse("https://some-upstream.com/logout?foo=bar")
+	require.NoError(t, err)
+
+	encodedLogoutState := func(postLogoutRedirectURI, state string) string {
+		encoded, err := testCodec{}.Encode(oidc.UpstreamLogoutStateParamEncodingName, oidc.UpstreamLogoutStateParamData{
+			PostLogoutRedirectURI: postLogoutRedirectURI,
+			State:                 state,
+			FormatVersion:         "1",
+		})
+		require.NoError(t, err)
+		return encoded
+	}
+
+	tests := []struct {
+		name                string
+		method              string
+		params              url.Values
+		idTokenKey          *ecdsa.PrivateKey
+		idTokenClaims       func(claims *jwt.Claims)
+		withoutSessionID    bool
+		withoutStoredTokens bool
+		upstreamEndSession  bool
+		// The session was stored before the upstream ID token was stored for ending the upstream session.
+		withoutUpstreamIDToken bool
+		encoderErr             error
+		wantStatus             int
+		wantBody               string
+		wantLocation           string
+		wantRevoked            bool
+	}{
+		{
+			name:        "logout without post_logout_redirect_uri",
+			wantStatus:  http.StatusOK,
+			wantBody:    "You have been logged out.\n",
+			wantRevoked: true,
+		},
+		{
+			name:        "logout using POST",
+			method:      http.MethodPost,
+			wantStatus:  http.StatusOK,
+			wantBody:    "You have been logged out.\n",
+			wantRevoked: true,
+		},
+		{
+			name: "logout with expired ID token",
+			idTokenClaims: func(claims *jwt.Claims) {
+				claims.Expiry = jwt.NewNumericDate(time.Now().Add(-time.Hour))
+			},
+			wantStatus:  http.StatusOK,
+			wantBody:    "You have been logged out.\n",
+			wantRevoked: true,
+		},
+		{
+			name:                "logout of a session which has no stored tokens anymore",
+			withoutStoredTokens: true,
+			wantStatus:          http.StatusOK,
+			wantBody:            "You have been logged out.\n",
+		},
+		{
+			name:         "logout with registered post_logout_redirect_uri and state",
+			params:       url.Values{"post_logout_redirect_uri": {postLogoutRedirectURI}, "state": {"some-state"}},
+			wantStatus:   http.StatusSeeOther,
+			wantLocation: postLogoutRedirectURI + "?state=some-state",
+			wantRevoked:  true,
+		},
+		{
+			name:         "logout with client_id and registered post_logout_redirect_uri",
+			params:       url.Values{"client_id": {confidentialClientID}, "post_logout_redirect_uri": {postLogoutRedirectURI}},
+			wantStatus:   http.StatusSeeOther,
+			wantLocation: postLogoutRedirectURI,
+			wantRevoked:  true,
+		},
+		{
+			name:               "logout which also ends the upstream session",
+			params:             url.Values{"post_logout_redirect_uri": {postLogoutRedirectURI}, "state": {"some-state"}},
+			upstreamEndSession: true,
+			wantStatus:         http.StatusSeeOther,
+			wantLocation: "https://some-upstream.com/logout?" + url.Values{
+				"foo":                      {"bar"},
+				"client_id":                {"some-upstream-client-id"},
+				"id_token_hint":            {upstreamIDToken},
+				"post_logout_redirect_uri": {issuer + "/logout/callback"},
+				"state":                    {encodedLogoutState(postLogoutRedirectURI, "some-state")},
+			}.Encode(),
+			wantRevoked: true,
+		},
+		{
+			name:               "logout which also ends the upstream session without post_logout_redirect_uri",
+			upstreamEndSession: true,
+			wantStatus:         http.StatusSeeOther,
+			wantLocation: "https://some-upstream.com/logout?" + url.Values{
+				"foo":                      {"bar"},
+				"client_id":                {"some-upstream-client-id"},
+				"id_token_hint":            {upstreamIDToken},
+				"post_logout_redirect_uri": {issuer + "/logout/callback"},
+				"state":                    {encodedLogoutState("", "")},
+			}.Encode(),
+			wantRevoked: true,
+		},
+		{
+			name:                   "logout which also ends the upstream session of a session without upstream ID token",
+			params:                 url.Values{"post_logout_redirect_uri": {postLogoutRedirectURI}},
+			upstreamEndSession:     true,
+			withoutUpstreamIDToken: true,
+			wantStatus:             http.StatusSeeOther,
+			wantLocation: "https://some-upstream.com/logout?" + url.Values{
+				"foo":                      {"bar"},
+				"client_id":                {"some-upstream-client-id"},
+				"post_logout_redirect_uri": {issuer + "/logout/callback"},
+				"state":                    {encodedLogoutState(postLogoutRedirectURI, "")},
+			}.Encode(),
+			wantRevoked: true,
+		},
+		{
+			name:               "logout which also ends the upstream session when encoding the state fails",
+			upstreamEndSession: true,
+			encoderErr:         errors.New("some encoding error"),
+			wantStatus:         http.StatusInternalServerError,
+			wantBody:           "Internal Server Error: error encoding upstream state param\n",
+			wantRevoked:        true,
+		},
+		{
+			name:       "unregistered post_logout_redirect_uri",
+			params:     url.Values{"post_logout_redirect_uri": {"https://example.com/evil"}},
+			wantStatus: http.StatusBadRequest,
+			wantBody:   "Bad Request: post_logout_redirect_uri is not registered for the client\n",
+		},
+		{
+			name:       "client_id does not match the audience of the ID token",
+			params:     url.Values{"client_id": {"some-other-client"}},
+			wantStatus: http.StatusBadRequest,
+			wantBody:   "Bad Request: client_id param does not match the audience of the id_token_hint\n",
+		},
+		{
+			name:       "missing id_token_hint",
+			params:     url.Values{"id_token_hint": {""}},
+			wantStatus: http.StatusBadRequest,
+			wantBody:   "Bad Request: id_token_hint param not found\n",
+		},
+		{
+			name:       "id_token_hint which is not a JWT",
+			params:     url.Values{"id_token_hint": {"not-a-jwt"}},
+			wantStatus: http.StatusBadRequest,
+			wantBody:   "Bad Request: invalid id_token_hint\n",
+		},
+		{
+			name:       "id_token_hint signed by another key",
+			idTokenKey: otherKey,
+			wantStatus: http.StatusBadRequest,
+			wantBody:   "Bad Request: invalid id_token_hint\n",
+		},
+		{
+			name: "id_token_hint issued by another issuer",
+			idTokenClaims: func(claims *jwt.Claims) {
+				claims.Issuer = "https://some-other-issuer.com"
+			},
+			wantStatus: http.StatusBadRequest,
+			wantBody:   "Bad Request: invalid id_token_hint\n",
+		},
+		{
+			name:             "id_token_hint without sid claim",
+			withoutSessionID: true,
+			wantStatus:       http.StatusBadRequest,
+			wantBody:         "Bad Request: invalid id_token_hint\n",
+		},
+		{
+			name:       "wrong method",
+			method:     http.MethodPut,
+			wantStatus: http.StatusMethodNotAllowed,
+			wantBody:   "Method Not Allowed: PUT (try GET or POST)\n",
+		},
+	}
+	for _, test := range tests {
+		test := test
+		t.Run(test.name, func(t *testing.T) {
+			ctx := context.Background()
+			secrets := fake.NewSimpleClientset().CoreV1().Secrets("some-namespace")
+
+			clients := clientregistry.NewDynamicClientManager()
+			clients.SetClients([]*clientregistry.Client{clientregistry.ConfidentialClient(
+				confidentialClientID,
+				hashedClientSecret,
+				[]string{"https://example.com/callback"},
+				[]string{"authorization_code", "refresh_token"},
+				[]string{"openid", "offline_access"},
+			).WithPostLogoutRedirectURIs([]string{postLogoutRedirectURI})})
+			timeouts := oidc.DefaultOIDCTimeoutsConfiguration()
+			storage := oidc.NewKubeStorage(crud.NewSecretsBackend(secrets), clients, timeouts)
+
+			jwksProvider := jwks.NewDynamicJWKSProvider()
+			jwk := jose.JSONWebKey{Key: signingKey, KeyID: "some-key-id", Algorithm: "ES256", Use: "sig"}
+			publicJWK := jwk.Public()
+			jwksProvider.SetIssuerToJWKSMap(
+				map[string]*jose.JSONWebKeySet{issuer: {Keys: []jose.JSONWebKey{publicJWK}}},
+				map[string]*jose.JSONWebKey{issuer: &jwk},
+			)
+
+			upstream := &oidctestutil.TestUpstreamOIDCIdentityProvider{Name: upstreamName, ClientID: "some-upstream-client-id"}
+			if test.upstreamEndSession {
+				upstream.EndSessionURL = upstreamEndSessionURL
+			}
+			idpLister := oidctestutil.NewUpstreamIDPListerBuilder().WithOIDC(upstream).Build()
+
+			if !test.withoutStoredTokens {
+				client, err := clients.GetClient(ctx, confidentialClientID)
+				require.NoError(t, err)
+				oidcSessionData := &psession.OIDCSessionData{UpstreamIDToken: upstreamIDToken}
+				if test.withoutUpstreamIDToken {
+					oidcSessionData.UpstreamIDToken = ""
+				}
+				storeTokens(t, storage, client, timeouts, oidcSessionData)
+			}
+
+			idTokenKey := test.idTokenKey
+			if idTokenKey == nil {
+				idTokenKey = signingKey
+			}
+			claims := jwt.Claims{
+				Issuer:   issuer,
+				Subject:  "some-subject",
+				Audience: jwt.Audience{confidentialClientID},
+				Expiry:   jwt.NewNumericDate(time.Now().Add(time.Hour)),
+				IssuedAt: jwt.NewNumericDate(time.Now()),
+			}
+			if test.idTokenClaims != nil {
+				test.idTokenClaims(&claims)
+			}
+			idTokenSessionID := sessionID
+			if test.withoutSessionID {
+				idTokenSessionID = ""
+			}
+			idToken := signIDToken(t, idTokenKey, claims, idTokenSessionID)
+
+			params := url.Values{"id_token_hint": {idToken}}
+			for k, v := range test.params {
+				params[k] = v
+			}
+			method := test.method
+			if method == "" {
+				method = http.MethodGet
+			}
+			var req *http.Request
+			if method == http.MethodPost {
+				req = httptest.NewRequest(method, "/path/shouldn't/matter", strings.NewReader(params.Encode()))
+				req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+			} else {
+				req = httptest.NewRequest(method, "/path/shouldn't/matter?"+params.Encode(), nil)
+			}
+			rsp := httptest.NewRecorder()
+			NewHandler(issuer, idpLister, jwksProvider, clients, storage, testCodec{err: test.encoderErr}).ServeHTTP(rsp, req)
+
+			require.Equal(t, test.wantStatus, rsp.Code, rsp.Body.String())
+			if test.wantBody != "" {
+				require.Equal(t, test.wantBody, rsp.Body.String())
+			}
+			require.Equal(t, test.wantLocation, rsp.Header().Get("Location"))
+
+			storedSecrets, err := secrets.List(ctx, metav1.ListOptions{})
+			require.NoError(t, err)
+			if test.wantRevoked || test.withoutStoredTokens {
+				require.Empty(t, storedSecrets.Items)
+			} else {
+				require.Len(t, storedSecrets.Items, 2)
+			}
+		})
+	}
+}
+
+func signIDToken(t *testing.T, key *ecdsa.PrivateKey, claims jwt.Claims, sid string) string {
+	t.Helper()
+
+	signer, err := jose.NewSigner(
+		jose.SigningKey{Algorithm: jose.ES256, Key: key},
+		(&jose.SignerOptions{}).WithType("JWT").WithHeader("kid", "some-key-id"),
+	)
+	require.NoError(t, err)
+	builder := jwt.Signed(signer).Claims(claims)
+	if sid != "" {
+		builder = builder.Claims(map[string]interface{}{oidc.DownstreamSessionIDClaim: sid})
+	}
+	idToken, err := builder.CompactSerialize()
+	require.NoError(t, err)
+	return idToken
+}
+
+// storeTokens stores an access token and a refresh token of the same session, in the same way as the token endpoint.
+func storeTokens(t *testing.T, storage *oidc.KubeStorage, client fosite.Client, timeouts oidc.TimeoutsConfiguration, oidcSessionData *psession.OIDCSessionData) {
+	t.Helper()
+	ctx := context.Background()
+	now := time.Now()
+
+	strategy := compose.NewOAuth2HMACStrategy(&compose.Config{}, []byte(hmacSecret), nil)
+	request := &fosite.Request{
+		ID:          sessionID,
+		RequestedAt: now,
+		Client:      client,
+		Session: &psession.PinnipedSession{
+			Fosite: &openid.DefaultSession{
+				Claims:  &fositejwt.IDTokenClaims{Subject: "some-subject"},
+				Subject: "some-subject",
+				ExpiresAt: map[fosite.TokenType]time.Time{
+					fosite.AccessToken:  now.Add(timeouts.AccessTokenLifespan),
+					fosite.RefreshToken: now.Add(timeouts.RefreshTokenLifespan),
+				},
+			},
+			Custom: &psession.CustomSessionData{ProviderName: upstreamName, ProviderType: oidc.IDPTypeOIDC, OIDC: oidcSessionData},
+		},
+		RequestedScope: fosite.Arguments{"openid", "offline_access"},
+		GrantedScope:   fosite.Arguments{"openid", "offline_access"},
+	}
+
+	_, accessSignature, err := strategy.GenerateAccessToken(ctx, request)
+	require.NoError(t, err)
+	require.NoError(t, storage.CreateAccessTokenSession(ctx, accessSignature, request))
+	_, refreshSignature, err := strategy.GenerateRefreshToken(ctx, request)
+	require.NoError(t, err)
+	require.NoError(t, storage.CreateRefreshTokenSession(ctx, refreshSignature, request))
+}
+
+// testCodec encodes values as their name and their JSON, so that tests can expect the exact encoded state params.
+type testCodec struct {
+	err error
+}
+
+func (c testCodec) Encode(name string, value interface{}) (string, error) {
+	if c.err != nil {
+		return "", c.err
+	}
+	data, err := json.Marshal(value)
+	if err != nil {
+		return "", err
+	}
+	return name + ":" + string(data), nil
+}
+
+func (c testCodec) Decode(name, value string, into interface{}) error {
+	if !strings.HasPrefix(value, name+":") {
+		return fmt.Errorf("value was not encoded with name %q", name)
+	}
+	return json.Unmarshal([]byte(strings.TrimPrefix(value, name+":")), into)
+}
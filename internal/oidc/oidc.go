@@ -11,6 +11,7 @@ import (
 	"github.com/ory/fosite"
 	"github.com/ory/fosite/compose"
 
+	"go.pinniped.dev/internal/idtransform"
 	"go.pinniped.dev/internal/oidc/csrftoken"
 	"go.pinniped.dev/internal/oidc/jwks"
 	"go.pinniped.dev/internal/oidc/provider"
@@ -24,8 +25,55 @@ const (
 	AuthorizationEndpointPath = "/oauth2/authorize"
 	TokenEndpointPath         = "/oauth2/token" //nolint:gosec // ignore lint warning that this is a credential
 	CallbackEndpointPath      = "/callback"
+	LoginEndpointPath         = "/login"
 	JWKSEndpointPath          = "/jwks.json"
 	PinnipedIDPsPathV1Alpha1  = "/v1alpha1/pinniped_identity_providers"
+
+	DeviceAuthorizationEndpointPath = "/oauth2/device_authorization"
+	DeviceVerificationEndpointPath  = "/oauth2/device"
+
+	RevocationEndpointPath    = "/oauth2/revoke"
+	IntrospectionEndpointPath = "/oauth2/introspect"
+	EndSessionEndpointPath    = "/oauth2/logout"
+
+	// LogoutCallbackEndpointPath is where the end_session_endpoint of an upstream OIDC provider sends the browser back
+	// to after the end user logged out of it.
+	LogoutCallbackEndpointPath = "/logout/callback"
+)
+
+const (
+	// AuthorizeUpstreamIDPNameParamName is the name of the custom param which a client may send to the authorize
+	// endpoint to choose which upstream identity provider should be used to authenticate the user.
+	AuthorizeUpstreamIDPNameParamName = "pinniped_idp_name"
+
+	// AuthorizeUpstreamIDPTypeParamName is the name of the custom param which a client may send to the authorize
+	// endpoint, along with AuthorizeUpstreamIDPNameParamName, to choose the type of the upstream identity provider.
+	AuthorizeUpstreamIDPTypeParamName = "pinniped_idp_type"
+
+	// IDPTypeOIDC is the type name of OIDCIdentityProvider upstreams, as used by the authorize endpoint and by the
+	// IDP discovery endpoint.
+	IDPTypeOIDC = "oidc"
+
+	// IDPTypeLDAP is the type name of LDAPIdentityProvider upstreams, as used by the authorize endpoint and by the
+	// IDP discovery endpoint.
+	IDPTypeLDAP = "ldap"
+
+	// IDPTypeActiveDirectory is the type name of ActiveDirectoryIdentityProvider upstreams, as used by the authorize
+	// endpoint and by the IDP discovery endpoint.
+	IDPTypeActiveDirectory = "activedirectory"
+
+	// GrantTypeDeviceCode is the grant type which clients use at the token endpoint to redeem a device code,
+	// as described in https://datatracker.ietf.org/doc/html/rfc8628#section-3.4.
+	GrantTypeDeviceCode = "urn:ietf:params:oauth:grant-type:device_code"
+
+	// GrantTypePassword is the grant type which clients use at the token endpoint to log in with the username and
+	// password of an end user, as described in https://datatracker.ietf.org/doc/html/rfc6749#section-4.3. It may
+	// only be used by clients which are allowed to use it, and only with LDAP and Active Directory upstreams.
+	GrantTypePassword = "password"
+
+	// DeviceCodePollingInterval is the minimum amount of time that clients should wait between polling requests to
+	// the token endpoint while the end user is approving a device authorization request.
+	DeviceCodePollingInterval = 5 * time.Second
 )
 
 const (
@@ -38,6 +86,10 @@ const (
 	// because it will be encoded into the upstream state param value and we're trying to keep that small.
 	UpstreamStateParamEncodingName = "s"
 
+	// The `name` passed to the encoder for encoding the state param which is sent to the end_session_endpoint of an
+	// upstream OIDC provider. It differs from UpstreamStateParamEncodingName, so neither can be used as the other.
+	UpstreamLogoutStateParamEncodingName = "l"
+
 	// CSRFCookieName is the name of the browser cookie which shall hold our CSRF value.
 	// The `__Host` prefix has a special meaning. See:
 	// https://developer.mozilla.org/en-US/docs/Web/HTTP/Cookies#Cookie_prefixes.
@@ -62,6 +114,15 @@ const (
 	// information.
 	DownstreamGroupsClaim = "groups"
 
+	// DownstreamAdditionalClaimsClaim is a custom claim in the downstream ID token whose value is an object of the
+	// additional claims which were mapped from the upstream identity by the upstream's additionalClaimMappings.
+	DownstreamAdditionalClaimsClaim = "additionalClaims"
+
+	// DownstreamSessionIDClaim is the claim of the downstream ID token which identifies the downstream session, as
+	// described in https://openid.net/specs/openid-connect-frontchannel-1_0.html#ClaimsContents. Its value is the ID
+	// of the request under which the tokens of the session are stored, so the end_session_endpoint can find them.
+	DownstreamSessionIDClaim = "sid"
+
 	// CSRFCookieLifespan is the length of time that the CSRF cookie is valid. After this time, the
 	// Supervisor's authorization endpoint should give the browser a new CSRF cookie. We set it to
 	// a week so that it is unlikely to expire during a login.
@@ -93,10 +154,24 @@ type Codec interface {
 type UpstreamStateParamData struct {
 	AuthParams    string              `json:"p"`
 	UpstreamName  string              `json:"u"`
+	UpstreamType  string              `json:"t"` // empty in state params issued before it was added, which were for OIDC upstreams
 	Nonce         nonce.Nonce         `json:"n"`
 	CSRFToken     csrftoken.CSRFToken `json:"c"`
 	PKCECode      pkce.Code           `json:"k"`
 	FormatVersion string              `json:"v"`
+
+	// DeviceUserCode is only set when the login was started by the device verification page, in which case it holds
+	// the user code of the device authorization request which should be approved by the login.
+	DeviceUserCode string `json:"d,omitempty"`
+}
+
+// UpstreamLogoutStateParamData is the format of the state param which is sent to the end_session_endpoint of an
+// upstream OIDC provider. It holds the post_logout_redirect_uri and state params of the downstream client, which
+// the logout callback endpoint uses to send the browser back to the client.
+type UpstreamLogoutStateParamData struct {
+	PostLogoutRedirectURI string `json:"p,omitempty"`
+	State                 string `json:"s,omitempty"`
+	FormatVersion         string `json:"v"`
 }
 
 type TimeoutsConfiguration struct {
@@ -156,6 +231,16 @@ type TimeoutsConfiguration struct {
 	// or else the refresh flow will not work properly. So this must be longer than RefreshTokenLifespan.
 	AccessTokenSessionStorageLifetime time.Duration
 
+	// DeviceCodeLifespan is how long a device code and its user code, issued by the device authorization endpoint,
+	// are valid. This determines how much time the end user has to visit the verification page and log in with the
+	// upstream IDP.
+	DeviceCodeLifespan time.Duration
+
+	// DeviceCodeSessionStorageLifetime is the length of time after which a device code's session data is allowed to be
+	// garbage collected from storage. Device code sessions are explicitly deleted when they are redeemed, so this can
+	// be just slightly longer than the DeviceCodeLifespan.
+	DeviceCodeSessionStorageLifetime time.Duration
+
 	// RefreshTokenSessionStorageLifetime is the length of time after which a refresh token's session data is allowed
 	// to be garbage collected from storage. These must exist in storage for as long as the refresh token is valid.
 	// Therefore, this can be just slightly longer than the RefreshTokenLifespan. We'll avoid making it exactly the same
@@ -167,11 +252,38 @@ type TimeoutsConfiguration struct {
 	RefreshTokenSessionStorageLifetime time.Duration
 }
 
+const (
+	// DefaultAccessTokenLifespan is the lifetime of the access tokens and ID tokens issued by a FederationDomain
+	// which does not configure it.
+	DefaultAccessTokenLifespan = 2 * time.Minute
+
+	// DefaultAuthorizeCodeLifespan is the lifetime of the authcodes issued by a FederationDomain which does not
+	// configure it.
+	DefaultAuthorizeCodeLifespan = 10 * time.Minute
+
+	// DefaultRefreshTokenLifespan is the lifetime of the refresh tokens issued by a FederationDomain which does not
+	// configure it.
+	DefaultRefreshTokenLifespan = 9 * time.Hour
+)
+
 // Get the defaults for the Supervisor server.
 func DefaultOIDCTimeoutsConfiguration() TimeoutsConfiguration {
-	accessTokenLifespan := 2 * time.Minute
-	authorizationCodeLifespan := 10 * time.Minute
-	refreshTokenLifespan := 9 * time.Hour
+	return OIDCTimeoutsConfiguration(DefaultAccessTokenLifespan, DefaultAuthorizeCodeLifespan, DefaultRefreshTokenLifespan)
+}
+
+// OIDCTimeoutsConfiguration returns the configuration for a FederationDomain which issues tokens with the given
+// lifespans. The storage lifetimes are derived from the lifespans. A zero lifespan means to use the default.
+func OIDCTimeoutsConfiguration(accessTokenLifespan, authorizationCodeLifespan, refreshTokenLifespan time.Duration) TimeoutsConfiguration {
+	if accessTokenLifespan == 0 {
+		accessTokenLifespan = DefaultAccessTokenLifespan
+	}
+	if authorizationCodeLifespan == 0 {
+		authorizationCodeLifespan = DefaultAuthorizeCodeLifespan
+	}
+	if refreshTokenLifespan == 0 {
+		refreshTokenLifespan = DefaultRefreshTokenLifespan
+	}
+	deviceCodeLifespan := 10 * time.Minute
 
 	return TimeoutsConfiguration{
 		UpstreamStateParamLifespan:              90 * time.Minute,
@@ -184,6 +296,8 @@ func DefaultOIDCTimeoutsConfiguration() TimeoutsConfiguration {
 		OIDCSessionStorageLifetime:              authorizationCodeLifespan + (1 * time.Minute),
 		AccessTokenSessionStorageLifetime:       refreshTokenLifespan + accessTokenLifespan,
 		RefreshTokenSessionStorageLifetime:      refreshTokenLifespan + accessTokenLifespan,
+		DeviceCodeLifespan:                      deviceCodeLifespan,
+		DeviceCodeSessionStorageLifetime:        deviceCodeLifespan + (1 * time.Minute),
 	}
 }
 
@@ -203,7 +317,10 @@ func FositeOauth2Helper(
 		RefreshTokenLifespan:  timeoutsConfiguration.RefreshTokenLifespan,
 
 		ScopeStrategy: fosite.ExactScopeStrategy,
-		EnforcePKCE:   true,
+
+		// Public clients like the Pinniped CLI must use PKCE. Confidential clients authenticate at the token endpoint
+		// using their client secret, so PKCE is optional for them, which makes it easier to use off the shelf web apps.
+		EnforcePKCEForPublicClients: true,
 
 		// "offline_access" as per https://openid.net/specs/openid-connect-core-1_0.html#OfflineAccess
 		RefreshTokenScopes: []string{coreosoidc.ScopeOfflineAccess},
@@ -232,7 +349,11 @@ func FositeOauth2Helper(
 		compose.OpenIDConnectExplicitFactory,
 		compose.OpenIDConnectRefreshFactory,
 		compose.OAuth2PKCEFactory,
+		compose.OAuth2TokenRevocationFactory,
+		compose.OAuth2TokenIntrospectionFactory,
 		TokenExchangeFactory,
+		DeviceCodeFactory,
+		PasswordGrantFactory,
 	)
 	provider.(*fosite.Fosite).FormPostHTMLTemplate = formposthtml.Template()
 	return provider
@@ -242,11 +363,12 @@ func FositeOauth2Helper(
 // passed to a plog function (e.g., plog.Info()).
 //
 // Sample usage:
-//   err := someFositeLibraryFunction()
-//   if err != nil {
-//     	plog.Info("some error", FositeErrorForLog(err)...)
-//      ...
-//    }
+//
+//	err := someFositeLibraryFunction()
+//	if err != nil {
+//	  	plog.Info("some error", FositeErrorForLog(err)...)
+//	   ...
+//	 }
 func FositeErrorForLog(err error) []interface{} {
 	rfc6749Error := fosite.ErrorToRFC6749Error(err)
 	keysAndValues := make([]interface{}, 0)
@@ -267,19 +389,69 @@ type UpstreamLDAPIdentityProvidersLister interface {
 	GetLDAPIdentityProviders() []provider.UpstreamLDAPIdentityProviderI
 }
 
+type UpstreamActiveDirectoryIdentityProviderLister interface {
+	GetActiveDirectoryIdentityProviders() []provider.UpstreamLDAPIdentityProviderI
+}
+
 type UpstreamIdentityProvidersLister interface {
 	UpstreamOIDCIdentityProvidersLister
 	UpstreamLDAPIdentityProvidersLister
+	UpstreamActiveDirectoryIdentityProviderLister
+}
+
+// FindUpstreamLDAPIdentityProviderByNameAndType returns the LDAP or Active Directory upstream with the given name
+// and type, or nil when there is none. Names are only unique within one type of upstream.
+func FindUpstreamLDAPIdentityProviderByNameAndType(upstreamName string, upstreamType string, lister UpstreamIdentityProvidersLister) provider.UpstreamLDAPIdentityProviderI {
+	var upstreams []provider.UpstreamLDAPIdentityProviderI
+	switch upstreamType {
+	case IDPTypeLDAP:
+		upstreams = lister.GetLDAPIdentityProviders()
+	case IDPTypeActiveDirectory:
+		upstreams = lister.GetActiveDirectoryIdentityProviders()
+	}
+	for _, p := range upstreams {
+		if p.GetName() == upstreamName {
+			return p
+		}
+	}
+	return nil
+}
+
+// FindUpstreamOIDCIdentityProviderByNameAndType returns the OIDC upstream with the given name, or nil when there is
+// none. Names are only unique within one type of upstream, so nil is also returned when the type is not OIDC. An
+// empty type is treated as OIDC, because state params which were issued before the type was added to them were
+// always for OIDC upstreams.
+func FindUpstreamOIDCIdentityProviderByNameAndType(upstreamName string, upstreamType string, lister UpstreamOIDCIdentityProvidersLister) provider.UpstreamOIDCIdentityProviderI {
+	if upstreamType != "" && upstreamType != IDPTypeOIDC {
+		return nil
+	}
+	return FindUpstreamOIDCIdentityProvider(upstreamName, lister)
+}
+
+// FindUpstreamOIDCIdentityProvider returns the OIDC upstream with the given name, or nil when there is none.
+func FindUpstreamOIDCIdentityProvider(upstreamName string, lister UpstreamOIDCIdentityProvidersLister) provider.UpstreamOIDCIdentityProviderI {
+	for _, p := range lister.GetOIDCIdentityProviders() {
+		if p.GetName() == upstreamName {
+			return p
+		}
+	}
+	return nil
+}
+
+// IdentityTransformsGetter returns the transformations of the identities of the end users who log in using an
+// upstream identity provider, or nil when their identities are used unchanged.
+type IdentityTransformsGetter interface {
+	IdentityTransforms(idpType string, idpName string) *idtransform.Pipeline
 }
 
-func GrantScopeIfRequested(authorizeRequester fosite.AuthorizeRequester, scopeName string) {
-	if ScopeWasRequested(authorizeRequester, scopeName) {
-		authorizeRequester.GrantScope(scopeName)
+func GrantScopeIfRequested(requester fosite.Requester, scopeName string) {
+	if ScopeWasRequested(requester, scopeName) {
+		requester.GrantScope(scopeName)
 	}
 }
 
-func ScopeWasRequested(authorizeRequester fosite.AuthorizeRequester, scopeName string) bool {
-	for _, scope := range authorizeRequester.GetRequestedScopes() {
+func ScopeWasRequested(requester fosite.Requester, scopeName string) bool {
+	for _, scope := range requester.GetRequestedScopes() {
 		if scope == scopeName {
 			return true
 		}
@@ -0,0 +1,74 @@
+// Copyright 2021 the Pinniped contributors. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package oidc
+
+import (
+	"context"
+
+	"github.com/ory/fosite"
+	"github.com/ory/fosite/compose"
+	"github.com/pkg/errors"
+)
+
+func PasswordGrantFactory(config *compose.Config, storage interface{}, strategy interface{}) interface{} {
+	return &PasswordGrantHandler{
+		tokenIssuer:   newTokenIssuer(config, storage, strategy),
+		scopeStrategy: config.GetScopeStrategy(),
+	}
+}
+
+// PasswordGrantHandler implements the token endpoint part of the OAuth 2.0 Resource Owner Password Credentials Grant
+// (RFC6749 section 4.3) for the clients which are allowed to use it. It only validates the request and issues the
+// tokens. In between those two steps, the token endpoint checks the username and password using the upstream LDAP or
+// Active Directory identity provider and replaces the session of the request with the user's downstream session.
+type PasswordGrantHandler struct {
+	tokenIssuer
+	scopeStrategy fosite.ScopeStrategy
+}
+
+var _ fosite.TokenEndpointHandler = (*PasswordGrantHandler)(nil)
+
+func (p *PasswordGrantHandler) HandleTokenEndpointRequest(ctx context.Context, requester fosite.AccessRequester) error {
+	if !p.CanHandleTokenEndpointRequest(requester) {
+		return errors.WithStack(fosite.ErrUnknownRequest)
+	}
+
+	client := requester.GetClient()
+	if !client.GetGrantTypes().Has(GrantTypePassword) {
+		return errors.WithStack(fosite.ErrUnauthorizedClient.WithHintf("The OAuth 2.0 Client is not allowed to use authorization grant %q.", GrantTypePassword))
+	}
+
+	form := requester.GetRequestForm()
+	if form.Get("username") == "" || form.Get("password") == "" {
+		return errors.WithStack(fosite.ErrInvalidRequest.WithHint("Missing or blank username or password."))
+	}
+
+	for _, scope := range requester.GetRequestedScopes() {
+		if !p.scopeStrategy(client.GetScopes(), scope) {
+			return errors.WithStack(fosite.ErrInvalidScope.WithHintf("The OAuth 2.0 Client is not allowed to request scope '%s'.", scope))
+		}
+	}
+
+	return nil
+}
+
+func (p *PasswordGrantHandler) PopulateTokenEndpointResponse(ctx context.Context, requester fosite.AccessRequester, responder fosite.AccessResponder) error {
+	if !p.CanHandleTokenEndpointRequest(requester) {
+		return errors.WithStack(fosite.ErrUnknownRequest)
+	}
+
+	// The expiration times are set here, rather than when handling the request, because the token endpoint has
+	// replaced the session of the request since then.
+	p.setExpiresAt(requester)
+
+	return p.issueTokens(ctx, requester, responder)
+}
+
+func (p *PasswordGrantHandler) CanSkipClientAuth(_ fosite.AccessRequester) bool {
+	return false
+}
+
+func (p *PasswordGrantHandler) CanHandleTokenEndpointRequest(requester fosite.AccessRequester) bool {
+	return requester.GetGrantTypes().ExactOne(GrantTypePassword)
+}
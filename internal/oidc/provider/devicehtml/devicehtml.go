@@ -0,0 +1,71 @@
+// Copyright 2021 the Pinniped contributors. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+// Package devicehtml defines the HTML template of the Supervisor's device verification page.
+//nolint: gochecknoglobals // This package uses globals to ensure that all parsing and minifying happens at init.
+package devicehtml
+
+import (
+	"crypto/sha256"
+	_ "embed" // Needed to trigger //go:embed directives below.
+	"encoding/base64"
+	"html/template"
+	"strings"
+
+	"github.com/tdewolff/minify/v2/minify"
+)
+
+var (
+	//go:embed device.css
+	rawCSS      string
+	minifiedCSS = mustMinify(minify.CSS(rawCSS))
+
+	//go:embed device.gohtml
+	rawHTMLTemplate string
+)
+
+// Parse the Go templated HTML and inject functions providing the minified inline CSS.
+var parsedHTMLTemplate = template.Must(template.New("device.gohtml").Funcs(template.FuncMap{
+	"minifiedCSS": func() template.CSS { return template.CSS(minifiedCSS) },
+}).Parse(rawHTMLTemplate))
+
+// Generate the CSP header value once since it's effectively constant:
+var cspValue = strings.Join([]string{
+	`default-src 'none'`,
+	`style-src '` + cspHash(minifiedCSS) + `'`,
+	`frame-ancestors 'none'`,
+}, "; ")
+
+// PageData is the data used to render the Template().
+type PageData struct {
+	// UserCode prefills the user code input field, e.g. when the end user followed the verification_uri_complete link.
+	UserCode string
+
+	// CSRFToken is submitted along with the user code so the Supervisor can check that the form was submitted by
+	// the same browser to which it was rendered.
+	CSRFToken string
+
+	// ErrorMessage is shown above the form when the previously submitted user code was not accepted.
+	ErrorMessage string
+
+	// Success shows a page which tells the end user that they may return to their device instead of showing the form.
+	Success bool
+}
+
+func mustMinify(s string, err error) string {
+	if err != nil {
+		panic(err)
+	}
+	return s
+}
+
+func cspHash(s string) string {
+	hashBytes := sha256.Sum256([]byte(s))
+	return "sha256-" + base64.StdEncoding.EncodeToString(hashBytes[:])
+}
+
+// ContentSecurityPolicy returns the Content-Security-Policy header value to make the Template() operate correctly.
+func ContentSecurityPolicy() string { return cspValue }
+
+// Template returns the html/template.Template for rendering the device verification page.
+func Template() *template.Template { return parsedHTMLTemplate }
@@ -0,0 +1,96 @@
+// Copyright 2021 the Pinniped contributors. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package devicehtml
+
+import (
+	"bytes"
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"go.pinniped.dev/internal/here"
+)
+
+var (
+	testExpectedFormOutput = here.Doc(`
+        <!DOCTYPE html>
+        <html lang="en">
+        <head>
+            <meta charset="UTF-8">
+            <style>body{font-family:metropolis-light,Helvetica,sans-serif}h1{font-size:20px}.state{position:absolute;top:100px;left:50%;width:400px;margin-left:-200px;font-size:14px;line-height:24px}.error{color:#c92100}input[type=text]{width:200px;padding:6px;font-size:18px;font-family:monospace;letter-spacing:2px;text-transform:uppercase}button{padding:6px 12px;font-size:14px;cursor:pointer}</style>
+            <title>Log in to your device</title>
+        </head>
+        <body>
+        <div class="state">
+            <h1>Log in to your device</h1>
+            <p>Enter the code which is displayed on your device.</p>
+            <p class="error">The code is invalid or has expired.</p>
+            <form method="post">
+                <input type="hidden" name="csrf_token" value="test-csrf-token"/>
+                <input type="text" name="user_code" value="BCDF-GHJK" autocomplete="off" autocapitalize="characters" spellcheck="false" required autofocus/>
+                <button type="submit">Continue</button>
+            </form>
+        </div>
+        </body>
+        </html>
+		`)
+
+	testExpectedSuccessOutput = here.Doc(`
+        <!DOCTYPE html>
+        <html lang="en">
+        <head>
+            <meta charset="UTF-8">
+            <style>body{font-family:metropolis-light,Helvetica,sans-serif}h1{font-size:20px}.state{position:absolute;top:100px;left:50%;width:400px;margin-left:-200px;font-size:14px;line-height:24px}.error{color:#c92100}input[type=text]{width:200px;padding:6px;font-size:18px;font-family:monospace;letter-spacing:2px;text-transform:uppercase}button{padding:6px 12px;font-size:14px;cursor:pointer}</style>
+            <title>Login succeeded</title>
+        </head>
+        <body>
+        <div class="state">
+            <h1>Login succeeded</h1>
+            <p>You have successfully logged in. You may now close this tab and return to your device.</p>
+        </div>
+        </body>
+        </html>
+		`)
+
+	// It's okay if this changes in the future, but this gives us a chance to eyeball the formatting.
+	// Our browser-based integration tests should find any incompatibilities.
+	testExpectedCSP = `default-src 'none'; ` +
+		`style-src 'sha256-wTpJDhJbPEqIhceIvJSBqiuTqczEHQ92DywZign+w+4='; ` +
+		`frame-ancestors 'none'`
+)
+
+func TestTemplate(t *testing.T) {
+	var buf bytes.Buffer
+	require.NoError(t, Template().Execute(&buf, &PageData{
+		UserCode:     "BCDF-GHJK",
+		CSRFToken:    "test-csrf-token",
+		ErrorMessage: "The code is invalid or has expired.",
+	}))
+	require.Equal(t, testExpectedFormOutput, buf.String())
+
+	buf.Reset()
+	require.NoError(t, Template().Execute(&buf, &PageData{Success: true}))
+	require.Equal(t, testExpectedSuccessOutput, buf.String())
+}
+
+func TestTemplateEscapesUserInput(t *testing.T) {
+	var buf bytes.Buffer
+	require.NoError(t, Template().Execute(&buf, &PageData{UserCode: `"><script>alert(1)</script>`}))
+	require.NotContains(t, buf.String(), "<script>")
+	require.Contains(t, buf.String(), `value="&#34;&gt;&lt;script&gt;alert(1)&lt;/script&gt;"`)
+}
+
+func TestContentSecurityPolicyHashes(t *testing.T) {
+	require.Equal(t, testExpectedCSP, ContentSecurityPolicy())
+}
+
+func TestHelpers(t *testing.T) {
+	// These are silly tests but it's easy to we might as well have them.
+	require.Equal(t, "test", mustMinify("test", nil))
+	require.PanicsWithError(t, "some error", func() { mustMinify("", fmt.Errorf("some error")) })
+
+	// Example test vector from https://content-security-policy.com/hash/.
+	require.Equal(t, "sha256-RFWPLDbv2BY+rCkDzsE+0fr8ylGr2R2faWMhq4lfEQc=", cspHash("doSomething();"))
+}
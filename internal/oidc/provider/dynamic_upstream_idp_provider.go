@@ -27,15 +27,28 @@ type UpstreamOIDCIdentityProviderI interface {
 	// The Authorization Endpoint fetched from discovery.
 	GetAuthorizationURL() *url.URL
 
+	// The end session endpoint fetched from discovery, to which the end user's browser is redirected to also end the
+	// end user's session at the upstream provider when they log out of the Supervisor. May return nil, in which case
+	// only the Supervisor session is ended.
+	GetEndSessionURL() *url.URL
+
 	// Scopes to request in authorization flow.
 	GetScopes() []string
 
+	// Additional query parameters to send in the authorization request, beyond the ones which are always sent.
+	// May return an empty map, in which case no additional parameters are sent.
+	GetAdditionalAuthcodeParams() map[string]string
+
 	// ID Token username claim name. May return empty string, in which case we will use some reasonable defaults.
 	GetUsernameClaim() string
 
 	// ID Token groups claim name. May return empty string, in which case we won't try to read groups from the upstream provider.
 	GetGroupsClaim() string
 
+	// Mappings of the names of additional downstream claims to the names of the upstream ID token claims from which
+	// their values are copied. May return an empty map, in which case there are no additional claims.
+	GetAdditionalClaimMappings() map[string]string
+
 	// Performs upstream OIDC authorization code exchange and token validation.
 	// Returns the validated raw tokens as well as the parsed claims of the ID token.
 	ExchangeAuthcodeAndValidateTokens(
@@ -46,6 +59,12 @@ type UpstreamOIDCIdentityProviderI interface {
 		redirectURI string,
 	) (*oidctypes.Token, error)
 
+	// Performs an upstream refresh using the upstream refresh token which was returned during login, to check that
+	// the user's session is still valid at the upstream provider. Returns the raw, unvalidated tokens.
+	PerformRefresh(ctx context.Context, refreshToken string) (*oauth2.Token, error)
+
+	// Validates the tokens, including the ID token, and returns the validated raw tokens as well as the parsed claims
+	// of the ID token. An empty expectedIDTokenNonce skips the nonce validation.
 	ValidateToken(ctx context.Context, tok *oauth2.Token, expectedIDTokenNonce nonce.Nonce) (*oidctypes.Token, error)
 }
 
@@ -60,6 +79,11 @@ type UpstreamLDAPIdentityProviderI interface {
 
 	// A method for performing user authentication against the upstream LDAP provider.
 	authenticators.UserAuthenticator
+
+	// Looks up the user again by the DN which was returned during authentication, to check that the user is
+	// still allowed to log in. Returns the user's current identity, or a nil response and false when the user
+	// is gone or no longer allowed to log in.
+	PerformRefresh(ctx context.Context, userDN string) (*authenticators.Response, bool, error)
 }
 
 type DynamicUpstreamIDPProvider interface {
@@ -67,18 +91,22 @@ type DynamicUpstreamIDPProvider interface {
 	GetOIDCIdentityProviders() []UpstreamOIDCIdentityProviderI
 	SetLDAPIdentityProviders(ldapIDPs []UpstreamLDAPIdentityProviderI)
 	GetLDAPIdentityProviders() []UpstreamLDAPIdentityProviderI
+	SetActiveDirectoryIdentityProviders(adIDPs []UpstreamLDAPIdentityProviderI)
+	GetActiveDirectoryIdentityProviders() []UpstreamLDAPIdentityProviderI
 }
 
 type dynamicUpstreamIDPProvider struct {
-	oidcUpstreams []UpstreamOIDCIdentityProviderI
-	ldapUpstreams []UpstreamLDAPIdentityProviderI
-	mutex         sync.RWMutex
+	oidcUpstreams            []UpstreamOIDCIdentityProviderI
+	ldapUpstreams            []UpstreamLDAPIdentityProviderI
+	activeDirectoryUpstreams []UpstreamLDAPIdentityProviderI
+	mutex                    sync.RWMutex
 }
 
 func NewDynamicUpstreamIDPProvider() DynamicUpstreamIDPProvider {
 	return &dynamicUpstreamIDPProvider{
-		oidcUpstreams: []UpstreamOIDCIdentityProviderI{},
-		ldapUpstreams: []UpstreamLDAPIdentityProviderI{},
+		oidcUpstreams:            []UpstreamOIDCIdentityProviderI{},
+		ldapUpstreams:            []UpstreamLDAPIdentityProviderI{},
+		activeDirectoryUpstreams: []UpstreamLDAPIdentityProviderI{},
 	}
 }
 
@@ -105,3 +133,15 @@ func (p *dynamicUpstreamIDPProvider) GetLDAPIdentityProviders() []UpstreamLDAPId
 	defer p.mutex.RUnlock()
 	return p.ldapUpstreams
 }
+
+func (p *dynamicUpstreamIDPProvider) SetActiveDirectoryIdentityProviders(adIDPs []UpstreamLDAPIdentityProviderI) {
+	p.mutex.Lock() // acquire a write lock
+	defer p.mutex.Unlock()
+	p.activeDirectoryUpstreams = adIDPs
+}
+
+func (p *dynamicUpstreamIDPProvider) GetActiveDirectoryIdentityProviders() []UpstreamLDAPIdentityProviderI {
+	p.mutex.RLock() // acquire a read lock
+	defer p.mutex.RUnlock()
+	return p.activeDirectoryUpstreams
+}
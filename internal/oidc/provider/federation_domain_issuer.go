@@ -1,4 +1,4 @@
-// Copyright 2020 the Pinniped contributors. All Rights Reserved.
+// Copyright 2020-2021 the Pinniped contributors. All Rights Reserved.
 // SPDX-License-Identifier: Apache-2.0
 
 package provider
@@ -7,20 +7,62 @@ import (
 	"fmt"
 	"net/url"
 	"strings"
+	"time"
 
 	"go.pinniped.dev/internal/constable"
+	"go.pinniped.dev/internal/idtransform"
 )
 
+// FederationDomainIdentityProvider identifies an upstream identity provider which may be used to log in to
+// a FederationDomain.
+type FederationDomainIdentityProvider struct {
+	// The name of the upstream identity provider, as returned by its GetName().
+	Name string
+
+	// The type of the upstream identity provider, e.g. "oidc" or "ldap".
+	Type string
+
+	// The transformations of the identities of the end users who log in using this upstream identity provider,
+	// or nil when the identities are used unchanged.
+	Transforms *idtransform.Pipeline
+}
+
+// TokenLifetimes are the lifetimes of the tokens issued by a FederationDomain. A zero lifetime means to use the
+// default lifetime.
+type TokenLifetimes struct {
+	AccessToken       time.Duration
+	AuthorizationCode time.Duration
+	RefreshToken      time.Duration
+}
+
 // FederationDomainIssuer represents all of the settings and state for a downstream OIDC provider
 // as defined by a FederationDomain.
 type FederationDomainIssuer struct {
-	issuer     string
-	issuerHost string
-	issuerPath string
+	issuer            string
+	issuerHost        string
+	issuerPath        string
+	identityProviders []FederationDomainIdentityProvider
+	defaultTransforms *idtransform.Pipeline
+	tokenLifetimes    TokenLifetimes
 }
 
-func NewFederationDomainIssuer(issuer string) (*FederationDomainIssuer, error) {
-	p := FederationDomainIssuer{issuer: issuer}
+// NewFederationDomainIssuer returns a validated FederationDomainIssuer. When identityProviders is empty, then all
+// upstream identity providers may be used to log in to the FederationDomain. Otherwise, only the listed upstream
+// identity providers may be used. The policy, which may be nil, applies to the end users of upstream identity
+// providers which are not listed. The Transforms of the listed identity providers are expected to already
+// include the policy. The tokenLifetimes are used for the tokens issued by the FederationDomain.
+func NewFederationDomainIssuer(
+	issuer string,
+	identityProviders []FederationDomainIdentityProvider,
+	policy *idtransform.Policy,
+	tokenLifetimes TokenLifetimes,
+) (*FederationDomainIssuer, error) {
+	p := FederationDomainIssuer{
+		issuer:            issuer,
+		identityProviders: identityProviders,
+		defaultTransforms: policy.Pipeline(),
+		tokenLifetimes:    tokenLifetimes,
+	}
 	err := p.validate()
 	if err != nil {
 		return nil, err
@@ -75,3 +117,33 @@ func (p *FederationDomainIssuer) IssuerHost() string {
 func (p *FederationDomainIssuer) IssuerPath() string {
 	return p.issuerPath
 }
+
+func (p *FederationDomainIssuer) TokenLifetimes() TokenLifetimes {
+	return p.tokenLifetimes
+}
+
+// AllowsIdentityProvider returns true when the upstream identity provider with the given type and name may be
+// used to log in to this FederationDomain.
+func (p *FederationDomainIssuer) AllowsIdentityProvider(idpType string, idpName string) bool {
+	if len(p.identityProviders) == 0 {
+		return true
+	}
+	for _, idp := range p.identityProviders {
+		if idp.Type == idpType && idp.Name == idpName {
+			return true
+		}
+	}
+	return false
+}
+
+// IdentityTransforms returns the transformations of the identities of the end users who log in to this
+// FederationDomain using the upstream identity provider with the given type and name, including the login policy
+// of the FederationDomain. It returns nil when their identities are used unchanged.
+func (p *FederationDomainIssuer) IdentityTransforms(idpType string, idpName string) *idtransform.Pipeline {
+	for _, idp := range p.identityProviders {
+		if idp.Type == idpType && idp.Name == idpName {
+			return idp.Transforms
+		}
+	}
+	return p.defaultTransforms
+}
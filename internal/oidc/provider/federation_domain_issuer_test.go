@@ -1,4 +1,4 @@
-// Copyright 2020 the Pinniped contributors. All Rights Reserved.
+// Copyright 2020-2021 the Pinniped contributors. All Rights Reserved.
 // SPDX-License-Identifier: Apache-2.0
 
 package provider
@@ -7,6 +7,9 @@ import (
 	"testing"
 
 	"github.com/stretchr/testify/require"
+
+	configv1alpha1 "go.pinniped.dev/generated/latest/apis/supervisor/config/v1alpha1"
+	"go.pinniped.dev/internal/idtransform"
 )
 
 func TestFederationDomainIssuerValidations(t *testing.T) {
@@ -72,7 +75,7 @@ func TestFederationDomainIssuerValidations(t *testing.T) {
 	for _, tt := range tests {
 		tt := tt
 		t.Run(tt.name, func(t *testing.T) {
-			_, err := NewFederationDomainIssuer(tt.issuer)
+			_, err := NewFederationDomainIssuer(tt.issuer, nil, nil, TokenLifetimes{})
 			if tt.wantError != "" {
 				require.EqualError(t, err, tt.wantError)
 			} else {
@@ -81,3 +84,88 @@ func TestFederationDomainIssuerValidations(t *testing.T) {
 		})
 	}
 }
+
+func TestFederationDomainIssuerAllowsIdentityProvider(t *testing.T) {
+	tests := []struct {
+		name              string
+		identityProviders []FederationDomainIdentityProvider
+		idpType           string
+		idpName           string
+		wantAllowed       bool
+	}{
+		{
+			name:        "when no identity providers are listed, then all are allowed",
+			idpType:     "oidc",
+			idpName:     "some-idp",
+			wantAllowed: true,
+		},
+		{
+			name: "when the identity provider is listed, then it is allowed",
+			identityProviders: []FederationDomainIdentityProvider{
+				{Name: "other-idp", Type: "oidc"},
+				{Name: "some-idp", Type: "ldap"},
+			},
+			idpType:     "ldap",
+			idpName:     "some-idp",
+			wantAllowed: true,
+		},
+		{
+			name: "when the identity provider name is not listed, then it is not allowed",
+			identityProviders: []FederationDomainIdentityProvider{
+				{Name: "other-idp", Type: "ldap"},
+			},
+			idpType:     "ldap",
+			idpName:     "some-idp",
+			wantAllowed: false,
+		},
+		{
+			name: "when the identity provider name is listed with a different type, then it is not allowed",
+			identityProviders: []FederationDomainIdentityProvider{
+				{Name: "some-idp", Type: "oidc"},
+			},
+			idpType:     "ldap",
+			idpName:     "some-idp",
+			wantAllowed: false,
+		},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			p, err := NewFederationDomainIssuer("https://tuna.com", tt.identityProviders, nil, TokenLifetimes{})
+			require.NoError(t, err)
+			require.Equal(t, tt.wantAllowed, p.AllowsIdentityProvider(tt.idpType, tt.idpName))
+		})
+	}
+}
+
+func TestFederationDomainIssuerIdentityTransforms(t *testing.T) {
+	transforms, err := idtransform.NewPipeline([]configv1alpha1.FederationDomainTransform{
+		{Type: configv1alpha1.GroupsPrefixFederationDomainTransformType, Prefix: "okta:"},
+	}, nil)
+	require.NoError(t, err)
+
+	p, err := NewFederationDomainIssuer("https://tuna.com", []FederationDomainIdentityProvider{
+		{Name: "some-idp", Type: "oidc", Transforms: transforms},
+		{Name: "some-idp", Type: "ldap"},
+	}, nil, TokenLifetimes{})
+	require.NoError(t, err)
+
+	require.Same(t, transforms, p.IdentityTransforms("oidc", "some-idp"))
+	require.Nil(t, p.IdentityTransforms("ldap", "some-idp"))
+	require.Nil(t, p.IdentityTransforms("oidc", "other-idp"))
+}
+
+func TestFederationDomainIssuerIdentityTransformsWithPolicy(t *testing.T) {
+	policy, err := idtransform.NewPolicy(&configv1alpha1.FederationDomainPolicy{
+		DenyRules: []configv1alpha1.FederationDomainDenyRule{
+			{Match: configv1alpha1.FederationDomainPolicyMatch{UsernameRegex: "^bad-user$"}},
+		},
+	})
+	require.NoError(t, err)
+
+	// When all identity providers are allowed, then the policy applies to all of them.
+	p, err := NewFederationDomainIssuer("https://tuna.com", nil, policy, TokenLifetimes{})
+	require.NoError(t, err)
+	require.True(t, p.IdentityTransforms("oidc", "any-idp").Evaluate("bad-user", nil, nil).Rejected)
+	require.False(t, p.IdentityTransforms("ldap", "any-idp").Evaluate("good-user", nil, nil).Rejected)
+}
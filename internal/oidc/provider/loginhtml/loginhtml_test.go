@@ -0,0 +1,109 @@
+// Copyright 2021 the Pinniped contributors. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package loginhtml
+
+import (
+	"bytes"
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"go.pinniped.dev/internal/here"
+)
+
+var (
+	testExpectedFormOutput = here.Doc(`
+        <!DOCTYPE html>
+        <html lang="en">
+        <head>
+            <meta charset="UTF-8">
+            <style>body{font-family:metropolis-light,Helvetica,sans-serif}h1{font-size:20px}.state{position:absolute;top:100px;left:50%;width:400px;margin-left:-200px;font-size:14px;line-height:24px}.error{color:#c92100}label{display:block;margin-top:12px}input[type=text],input[type=password]{box-sizing:border-box;width:100%;padding:6px;font-size:14px}button{margin-top:18px;padding:6px 12px;font-size:14px;cursor:pointer}</style>
+            <title>Log in</title>
+        </head>
+        <body>
+        <div class="state">
+            <h1>Log in to some-ldap-idp</h1>
+            <form method="post">
+                <input type="hidden" name="state" value="test-state"/>
+                <label for="username">Username</label>
+                <input type="text" id="username" name="username" value="" autocomplete="username" autocapitalize="none" spellcheck="false" required autofocus/>
+                <label for="password">Password</label>
+                <input type="password" id="password" name="password" autocomplete="current-password" required/>
+                <button type="submit">Log in</button>
+            </form>
+        </div>
+        </body>
+        </html>
+		`)
+
+	testExpectedErrorOutput = here.Doc(`
+        <!DOCTYPE html>
+        <html lang="en">
+        <head>
+            <meta charset="UTF-8">
+            <style>body{font-family:metropolis-light,Helvetica,sans-serif}h1{font-size:20px}.state{position:absolute;top:100px;left:50%;width:400px;margin-left:-200px;font-size:14px;line-height:24px}.error{color:#c92100}label{display:block;margin-top:12px}input[type=text],input[type=password]{box-sizing:border-box;width:100%;padding:6px;font-size:14px}button{margin-top:18px;padding:6px 12px;font-size:14px;cursor:pointer}</style>
+            <title>Log in</title>
+        </head>
+        <body>
+        <div class="state">
+            <h1>Log in to some-ldap-idp</h1>
+            <p class="error">Incorrect username or password.</p>
+            <form method="post">
+                <input type="hidden" name="state" value="test-state"/>
+                <label for="username">Username</label>
+                <input type="text" id="username" name="username" value="pinny" autocomplete="username" autocapitalize="none" spellcheck="false" required/>
+                <label for="password">Password</label>
+                <input type="password" id="password" name="password" autocomplete="current-password" required autofocus/>
+                <button type="submit">Log in</button>
+            </form>
+        </div>
+        </body>
+        </html>
+		`)
+
+	// It's okay if this changes in the future, but this gives us a chance to eyeball the formatting.
+	// Our browser-based integration tests should find any incompatibilities.
+	testExpectedCSP = `default-src 'none'; ` +
+		`style-src 'sha256-n7eUsLDCjMqpQwAfA8IbOzVFOdqbZDvCKiWknLPe10A='; ` +
+		`frame-ancestors 'none'`
+)
+
+func TestTemplate(t *testing.T) {
+	var buf bytes.Buffer
+	require.NoError(t, Template().Execute(&buf, &PageData{
+		State:        "test-state",
+		UpstreamName: "some-ldap-idp",
+	}))
+	require.Equal(t, testExpectedFormOutput, buf.String())
+
+	buf.Reset()
+	require.NoError(t, Template().Execute(&buf, &PageData{
+		State:        "test-state",
+		UpstreamName: "some-ldap-idp",
+		Username:     "pinny",
+		ErrorMessage: "Incorrect username or password.",
+	}))
+	require.Equal(t, testExpectedErrorOutput, buf.String())
+}
+
+func TestTemplateEscapesUserInput(t *testing.T) {
+	var buf bytes.Buffer
+	require.NoError(t, Template().Execute(&buf, &PageData{Username: `"><script>alert(1)</script>`}))
+	require.NotContains(t, buf.String(), "<script>")
+	require.Contains(t, buf.String(), `value="&#34;&gt;&lt;script&gt;alert(1)&lt;/script&gt;"`)
+}
+
+func TestContentSecurityPolicyHashes(t *testing.T) {
+	require.Equal(t, testExpectedCSP, ContentSecurityPolicy())
+}
+
+func TestHelpers(t *testing.T) {
+	// These are silly tests but it's easy to we might as well have them.
+	require.Equal(t, "test", mustMinify("test", nil))
+	require.PanicsWithError(t, "some error", func() { mustMinify("", fmt.Errorf("some error")) })
+
+	// Example test vector from https://content-security-policy.com/hash/.
+	require.Equal(t, "sha256-RFWPLDbv2BY+rCkDzsE+0fr8ylGr2R2faWMhq4lfEQc=", cspHash("doSomething();"))
+}
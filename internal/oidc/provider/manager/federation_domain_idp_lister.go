@@ -0,0 +1,61 @@
+// Copyright 2021 the Pinniped contributors. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package manager
+
+import (
+	"go.pinniped.dev/internal/oidc"
+	"go.pinniped.dev/internal/oidc/provider"
+)
+
+// federationDomainIDPLister wraps the in-memory cache of all upstream IDPs to return only those upstream
+// IDPs which may be used to log in to one FederationDomain.
+type federationDomainIDPLister struct {
+	federationDomain *provider.FederationDomainIssuer
+	upstreamIDPs     oidc.UpstreamIdentityProvidersLister
+}
+
+var _ oidc.UpstreamIdentityProvidersLister = (*federationDomainIDPLister)(nil)
+
+func newFederationDomainIDPLister(
+	federationDomain *provider.FederationDomainIssuer,
+	upstreamIDPs oidc.UpstreamIdentityProvidersLister,
+) *federationDomainIDPLister {
+	return &federationDomainIDPLister{
+		federationDomain: federationDomain,
+		upstreamIDPs:     upstreamIDPs,
+	}
+}
+
+func (l *federationDomainIDPLister) GetOIDCIdentityProviders() []provider.UpstreamOIDCIdentityProviderI {
+	// The cache of IDPs could change at any time, so always recalculate the list.
+	allowed := []provider.UpstreamOIDCIdentityProviderI{}
+	for _, idp := range l.upstreamIDPs.GetOIDCIdentityProviders() {
+		if l.federationDomain.AllowsIdentityProvider(oidc.IDPTypeOIDC, idp.GetName()) {
+			allowed = append(allowed, idp)
+		}
+	}
+	return allowed
+}
+
+func (l *federationDomainIDPLister) GetLDAPIdentityProviders() []provider.UpstreamLDAPIdentityProviderI {
+	// The cache of IDPs could change at any time, so always recalculate the list.
+	allowed := []provider.UpstreamLDAPIdentityProviderI{}
+	for _, idp := range l.upstreamIDPs.GetLDAPIdentityProviders() {
+		if l.federationDomain.AllowsIdentityProvider(oidc.IDPTypeLDAP, idp.GetName()) {
+			allowed = append(allowed, idp)
+		}
+	}
+	return allowed
+}
+
+func (l *federationDomainIDPLister) GetActiveDirectoryIdentityProviders() []provider.UpstreamLDAPIdentityProviderI {
+	// The cache of IDPs could change at any time, so always recalculate the list.
+	allowed := []provider.UpstreamLDAPIdentityProviderI{}
+	for _, idp := range l.upstreamIDPs.GetActiveDirectoryIdentityProviders() {
+		if l.federationDomain.AllowsIdentityProvider(oidc.IDPTypeActiveDirectory, idp.GetName()) {
+			allowed = append(allowed, idp)
+		}
+	}
+	return allowed
+}
@@ -8,17 +8,25 @@ import (
 	"strings"
 	"sync"
 
-	corev1client "k8s.io/client-go/kubernetes/typed/core/v1"
+	"github.com/ory/fosite"
 
+	"go.pinniped.dev/internal/auditlog"
+	"go.pinniped.dev/internal/crud"
+	"go.pinniped.dev/internal/fositestorage/devicecode"
 	"go.pinniped.dev/internal/oidc"
 	"go.pinniped.dev/internal/oidc/auth"
 	"go.pinniped.dev/internal/oidc/callback"
 	"go.pinniped.dev/internal/oidc/csrftoken"
+	"go.pinniped.dev/internal/oidc/device"
 	"go.pinniped.dev/internal/oidc/discovery"
 	"go.pinniped.dev/internal/oidc/dynamiccodec"
 	"go.pinniped.dev/internal/oidc/idpdiscovery"
+	"go.pinniped.dev/internal/oidc/introspection"
 	"go.pinniped.dev/internal/oidc/jwks"
+	"go.pinniped.dev/internal/oidc/login"
+	"go.pinniped.dev/internal/oidc/logout"
 	"go.pinniped.dev/internal/oidc/provider"
+	"go.pinniped.dev/internal/oidc/revocation"
 	"go.pinniped.dev/internal/oidc/token"
 	"go.pinniped.dev/internal/plog"
 	"go.pinniped.dev/internal/secret"
@@ -36,28 +44,33 @@ type Manager struct {
 	nextHandler         http.Handler                         // the next handler in a chain, called when this manager didn't know how to handle a request
 	dynamicJWKSProvider jwks.DynamicJWKSProvider             // in-memory cache of per-issuer JWKS data
 	upstreamIDPs        oidc.UpstreamIdentityProvidersLister // in-memory cache of upstream IDPs
+	clientManager       fosite.ClientManager                 // in-memory cache of downstream OIDC clients
 	secretCache         *secret.Cache                        // in-memory cache of cryptographic material
-	secretsClient       corev1client.SecretInterface
+	storageBackend      crud.Backend                         // storage of the session data of the end users
 }
 
 // NewManager returns an empty Manager.
 // nextHandler will be invoked for any requests that could not be handled by this manager's providers.
 // dynamicJWKSProvider will be used as an in-memory cache for per-issuer JWKS data.
 // upstreamIDPs will be used as an in-memory cache of currently configured upstream IDPs.
+// clientManager will be used to look up the currently configured downstream OIDC clients.
+// storageBackend will be used to store the session data of the end users.
 func NewManager(
 	nextHandler http.Handler,
 	dynamicJWKSProvider jwks.DynamicJWKSProvider,
 	upstreamIDPs oidc.UpstreamIdentityProvidersLister,
+	clientManager fosite.ClientManager,
 	secretCache *secret.Cache,
-	secretsClient corev1client.SecretInterface,
+	storageBackend crud.Backend,
 ) *Manager {
 	return &Manager{
 		providerHandlers:    make(map[string]http.Handler),
 		nextHandler:         nextHandler,
 		dynamicJWKSProvider: dynamicJWKSProvider,
 		upstreamIDPs:        upstreamIDPs,
+		clientManager:       clientManager,
 		secretCache:         secretCache,
-		secretsClient:       secretsClient,
+		storageBackend:      storageBackend,
 	}
 }
 
@@ -88,14 +101,20 @@ func (m *Manager) SetProviders(federationDomains ...*provider.FederationDomainIs
 
 		tokenHMACKeyGetter := wrapGetter(incomingProvider.Issuer(), m.secretCache.GetTokenHMACKey)
 
-		timeoutsConfiguration := oidc.DefaultOIDCTimeoutsConfiguration()
+		tokenLifetimes := incomingProvider.TokenLifetimes()
+		timeoutsConfiguration := oidc.OIDCTimeoutsConfiguration(
+			tokenLifetimes.AccessToken,
+			tokenLifetimes.AuthorizationCode,
+			tokenLifetimes.RefreshToken,
+		)
 
 		// Use NullStorage for the authorize endpoint because we do not actually want to store anything until
 		// the upstream callback endpoint is called later.
-		oauthHelperWithNullStorage := oidc.FositeOauth2Helper(oidc.NullStorage{}, issuer, tokenHMACKeyGetter, nil, timeoutsConfiguration)
+		oauthHelperWithNullStorage := oidc.FositeOauth2Helper(oidc.NullStorage{ClientManager: m.clientManager}, issuer, tokenHMACKeyGetter, nil, timeoutsConfiguration)
 
 		// For all the other endpoints, make another oauth helper with exactly the same settings except use real storage.
-		oauthHelperWithKubeStorage := oidc.FositeOauth2Helper(oidc.NewKubeStorage(m.secretsClient, timeoutsConfiguration), issuer, tokenHMACKeyGetter, m.dynamicJWKSProvider, timeoutsConfiguration)
+		kubeStorage := oidc.NewKubeStorage(m.storageBackend, m.clientManager, timeoutsConfiguration)
+		oauthHelperWithKubeStorage := oidc.FositeOauth2Helper(kubeStorage, issuer, tokenHMACKeyGetter, m.dynamicJWKSProvider, timeoutsConfiguration)
 
 		var upstreamStateEncoder = dynamiccodec.New(
 			timeoutsConfiguration.UpstreamStateParamLifespan,
@@ -103,15 +122,19 @@ func (m *Manager) SetProviders(federationDomains ...*provider.FederationDomainIs
 			wrapGetter(incomingProvider.Issuer(), m.secretCache.GetStateEncoderBlockKey),
 		)
 
+		// Each FederationDomain may only use the upstream IDPs which it allows.
+		upstreamIDPs := newFederationDomainIDPLister(incomingProvider, m.upstreamIDPs)
+
 		m.providerHandlers[(issuerHostWithPath + oidc.WellKnownEndpointPath)] = discovery.NewHandler(issuer)
 
 		m.providerHandlers[(issuerHostWithPath + oidc.JWKSEndpointPath)] = jwks.NewHandler(issuer, m.dynamicJWKSProvider)
 
-		m.providerHandlers[(issuerHostWithPath + oidc.PinnipedIDPsPathV1Alpha1)] = idpdiscovery.NewHandler(m.upstreamIDPs)
+		m.providerHandlers[(issuerHostWithPath + oidc.PinnipedIDPsPathV1Alpha1)] = idpdiscovery.NewHandler(upstreamIDPs)
 
 		m.providerHandlers[(issuerHostWithPath + oidc.AuthorizationEndpointPath)] = auth.NewHandler(
 			issuer,
-			m.upstreamIDPs,
+			upstreamIDPs,
+			incomingProvider,
 			oauthHelperWithNullStorage,
 			oauthHelperWithKubeStorage,
 			csrftoken.Generate,
@@ -121,18 +144,81 @@ func (m *Manager) SetProviders(federationDomains ...*provider.FederationDomainIs
 			csrfCookieEncoder,
 		)
 
+		m.providerHandlers[(issuerHostWithPath + oidc.DeviceAuthorizationEndpointPath)] = device.NewAuthorizationHandler(
+			issuer,
+			upstreamIDPs,
+			m.clientManager,
+			kubeStorage,
+			oauthHelperWithKubeStorage,
+			timeoutsConfiguration.DeviceCodeLifespan,
+			devicecode.GenerateDeviceCode,
+			devicecode.GenerateUserCode,
+		)
+
+		m.providerHandlers[(issuerHostWithPath + oidc.DeviceVerificationEndpointPath)] = device.NewVerificationHandler(
+			issuer,
+			upstreamIDPs,
+			kubeStorage,
+			csrftoken.Generate,
+			pkce.Generate,
+			nonce.Generate,
+			upstreamStateEncoder,
+			csrfCookieEncoder,
+		)
+
 		m.providerHandlers[(issuerHostWithPath + oidc.CallbackEndpointPath)] = callback.NewHandler(
-			m.upstreamIDPs,
+			upstreamIDPs,
+			incomingProvider,
 			oauthHelperWithKubeStorage,
+			kubeStorage,
 			upstreamStateEncoder,
 			csrfCookieEncoder,
 			issuer+oidc.CallbackEndpointPath,
 		)
 
+		m.providerHandlers[(issuerHostWithPath + oidc.LoginEndpointPath)] = login.NewHandler(
+			upstreamIDPs,
+			incomingProvider,
+			oauthHelperWithKubeStorage,
+			upstreamStateEncoder,
+			csrfCookieEncoder,
+		)
+
 		m.providerHandlers[(issuerHostWithPath + oidc.TokenEndpointPath)] = token.NewHandler(
+			upstreamIDPs,
+			incomingProvider,
 			oauthHelperWithKubeStorage,
 		)
 
+		m.providerHandlers[(issuerHostWithPath + oidc.RevocationEndpointPath)] = revocation.NewHandler(oauthHelperWithKubeStorage)
+
+		m.providerHandlers[(issuerHostWithPath + oidc.IntrospectionEndpointPath)] = introspection.NewHandler(oauthHelperWithKubeStorage)
+
+		m.providerHandlers[(issuerHostWithPath + oidc.EndSessionEndpointPath)] = logout.NewHandler(
+			issuer,
+			upstreamIDPs,
+			m.dynamicJWKSProvider,
+			m.clientManager,
+			kubeStorage,
+			upstreamStateEncoder,
+		)
+
+		m.providerHandlers[(issuerHostWithPath + oidc.LogoutCallbackEndpointPath)] = logout.NewCallbackHandler(upstreamStateEncoder)
+
+		// Remember the issuer in the requests to the endpoints which write audit events.
+		for _, endpointPath := range []string{
+			oidc.AuthorizationEndpointPath,
+			oidc.DeviceAuthorizationEndpointPath,
+			oidc.DeviceVerificationEndpointPath,
+			oidc.CallbackEndpointPath,
+			oidc.LoginEndpointPath,
+			oidc.TokenEndpointPath,
+			oidc.EndSessionEndpointPath,
+		} {
+			key := issuerHostWithPath + endpointPath
+			m.providerHandlers[key] = auditlog.WithIssuer(issuer, m.providerHandlers[key])
+		}
+
 		plog.Debug("oidc provider manager added or updated issuer", "issuer", issuer)
 	}
 }
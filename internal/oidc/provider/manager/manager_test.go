@@ -14,16 +14,20 @@ import (
 	"net/url"
 	"strings"
 	"testing"
+	"time"
 
 	"go.pinniped.dev/internal/secret"
 
 	"github.com/sclevine/spec"
 	"github.com/stretchr/testify/require"
 	"gopkg.in/square/go-jose.v2"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/client-go/kubernetes/fake"
 
+	"go.pinniped.dev/internal/crud"
 	"go.pinniped.dev/internal/here"
 	"go.pinniped.dev/internal/oidc"
+	"go.pinniped.dev/internal/oidc/clientregistry"
 	"go.pinniped.dev/internal/oidc/discovery"
 	"go.pinniped.dev/internal/oidc/jwks"
 	"go.pinniped.dev/internal/oidc/provider"
@@ -42,6 +46,7 @@ func TestManager(t *testing.T) {
 			nextHandler              http.HandlerFunc
 			fallbackHandlerWasCalled bool
 			dynamicJWKSProvider      jwks.DynamicJWKSProvider
+			idpLister                provider.DynamicUpstreamIDPProvider
 			kubeClient               *fake.Clientset
 		)
 
@@ -175,7 +180,7 @@ func TestManager(t *testing.T) {
 			return actualLocationQueryParams.Get("code")
 		}
 
-		requireTokenRequestToBeHandled := func(requestIssuer, authCode string, jwks *jose.JSONWebKeySet, jwkIssuer string) {
+		requireTokenRequestToBeHandled := func(requestIssuer, authCode string, jwks *jose.JSONWebKeySet, jwkIssuer string) map[string]interface{} {
 			recorder := httptest.NewRecorder()
 
 			numberOfKubeActionsBeforeThisRequest := len(kubeClient.Actions())
@@ -212,6 +217,60 @@ func TestManager(t *testing.T) {
 			// Make sure that we wired up the callback endpoint to use kube storage for fosite sessions.
 			r.Equal(len(kubeClient.Actions()), numberOfKubeActionsBeforeThisRequest+8,
 				"did not perform any kube actions during the callback request, but should have")
+
+			return body
+		}
+
+		requireIntrospectionRequestToBeHandled := func(requestIssuer, bearerAccessToken, accessToken string, wantActive bool) {
+			recorder := httptest.NewRecorder()
+
+			req := newPostRequest(requestIssuer+oidc.IntrospectionEndpointPath, url.Values{"token": []string{accessToken}}.Encode())
+			req.Header.Set("Authorization", "Bearer "+bearerAccessToken)
+			subject.ServeHTTP(recorder, req)
+
+			r.False(fallbackHandlerWasCalled)
+
+			// Minimal check to ensure that the right endpoint was called
+			var body map[string]interface{}
+			r.Equal(http.StatusOK, recorder.Code)
+			r.NoError(json.Unmarshal(recorder.Body.Bytes(), &body))
+			r.Equal(wantActive, body["active"])
+		}
+
+		requireRevocationRequestToBeHandled := func(requestIssuer, accessToken string) {
+			recorder := httptest.NewRecorder()
+
+			numberOfKubeActionsBeforeThisRequest := len(kubeClient.Actions())
+
+			revocationRequestBody := url.Values{
+				"token":           []string{accessToken},
+				"token_type_hint": []string{"access_token"},
+				"client_id":       []string{downstreamClientID},
+			}.Encode()
+			subject.ServeHTTP(recorder, newPostRequest(requestIssuer+oidc.RevocationEndpointPath, revocationRequestBody))
+
+			r.False(fallbackHandlerWasCalled)
+			r.Equal(http.StatusOK, recorder.Code)
+
+			// Make sure that we wired up the revocation endpoint to use kube storage for fosite sessions.
+			r.Greater(len(kubeClient.Actions()), numberOfKubeActionsBeforeThisRequest,
+				"did not perform any kube actions during the revocation request, but should have")
+		}
+
+		requireLogoutRequestToBeHandled := func(requestIssuer, idToken string) {
+			recorder := httptest.NewRecorder()
+
+			numberOfKubeActionsBeforeThisRequest := len(kubeClient.Actions())
+
+			logoutRequestParams := "?" + url.Values{"id_token_hint": []string{idToken}}.Encode()
+			subject.ServeHTTP(recorder, newGetRequest(requestIssuer+oidc.EndSessionEndpointPath+logoutRequestParams))
+
+			r.False(fallbackHandlerWasCalled)
+			r.Equal(http.StatusOK, recorder.Code, recorder.Body.String())
+
+			// Make sure that we wired up the end session endpoint to use kube storage for fosite sessions.
+			r.Greater(len(kubeClient.Actions()), numberOfKubeActionsBeforeThisRequest,
+				"did not perform any kube actions during the logout request, but should have")
 		}
 
 		requireJWKSRequestToBeHandled := func(requestIssuer, requestURLSuffix, expectedJWKKeyID string) *jose.JSONWebKeySet {
@@ -242,7 +301,7 @@ func TestManager(t *testing.T) {
 
 			parsedUpstreamIDPAuthorizationURL, err := url.Parse(upstreamIDPAuthorizationURL)
 			r.NoError(err)
-			idpLister := oidctestutil.NewUpstreamIDPListerBuilder().WithOIDC(&oidctestutil.TestUpstreamOIDCIdentityProvider{
+			idpLister = oidctestutil.NewUpstreamIDPListerBuilder().WithOIDC(&oidctestutil.TestUpstreamOIDCIdentityProvider{
 				Name:             upstreamIDPName,
 				ClientID:         "test-client-id",
 				AuthorizationURL: *parsedUpstreamIDPAuthorizationURL,
@@ -275,7 +334,7 @@ func TestManager(t *testing.T) {
 			cache.SetStateEncoderHashKey(issuer2, []byte("some-state-encoder-hash-key-2"))
 			cache.SetStateEncoderBlockKey(issuer2, []byte("16-bytes-STATE02"))
 
-			subject = NewManager(nextHandler, dynamicJWKSProvider, idpLister, &cache, secretsClient)
+			subject = NewManager(nextHandler, dynamicJWKSProvider, idpLister, clientregistry.NewDynamicClientManager(), &cache, crud.NewSecretsBackend(secretsClient))
 		})
 
 		when("given no providers via SetProviders()", func() {
@@ -368,19 +427,33 @@ func TestManager(t *testing.T) {
 			downstreamAuthCode3 := requireCallbackRequestToBeHandled(issuer1DifferentCaseHostname, callbackRequestParams1, csrfCookieValue1)
 			downstreamAuthCode4 := requireCallbackRequestToBeHandled(issuer2DifferentCaseHostname, callbackRequestParams2, csrfCookieValue2)
 
-			requireTokenRequestToBeHandled(issuer1, downstreamAuthCode1, issuer1JWKS, issuer1)
-			requireTokenRequestToBeHandled(issuer2, downstreamAuthCode2, issuer2JWKS, issuer2)
+			tokens1 := requireTokenRequestToBeHandled(issuer1, downstreamAuthCode1, issuer1JWKS, issuer1)
+			tokens2 := requireTokenRequestToBeHandled(issuer2, downstreamAuthCode2, issuer2JWKS, issuer2)
+
+			// Hostnames are case-insensitive, so test that we can handle that.
+			tokens3 := requireTokenRequestToBeHandled(issuer1DifferentCaseHostname, downstreamAuthCode3, issuer1JWKS, issuer1)
+			tokens4 := requireTokenRequestToBeHandled(issuer2DifferentCaseHostname, downstreamAuthCode4, issuer2JWKS, issuer2)
+
+			requireIntrospectionRequestToBeHandled(issuer1, tokens1["access_token"].(string), tokens3["access_token"].(string), true)
+			requireIntrospectionRequestToBeHandled(issuer2, tokens2["access_token"].(string), tokens4["access_token"].(string), true)
 
+			requireRevocationRequestToBeHandled(issuer1, tokens3["access_token"].(string))
+			requireRevocationRequestToBeHandled(issuer2DifferentCaseHostname, tokens4["access_token"].(string))
+
+			// Hostnames are case-insensitive, so test that we can handle that.
+			requireIntrospectionRequestToBeHandled(issuer1DifferentCaseHostname, tokens1["access_token"].(string), tokens3["access_token"].(string), false)
+			requireIntrospectionRequestToBeHandled(issuer2DifferentCaseHostname, tokens2["access_token"].(string), tokens4["access_token"].(string), false)
+
+			requireLogoutRequestToBeHandled(issuer1, tokens1["id_token"].(string))
 			// Hostnames are case-insensitive, so test that we can handle that.
-			requireTokenRequestToBeHandled(issuer1DifferentCaseHostname, downstreamAuthCode3, issuer1JWKS, issuer1)
-			requireTokenRequestToBeHandled(issuer2DifferentCaseHostname, downstreamAuthCode4, issuer2JWKS, issuer2)
+			requireLogoutRequestToBeHandled(issuer2DifferentCaseHostname, tokens2["id_token"].(string))
 		}
 
 		when("given some valid providers via SetProviders()", func() {
 			it.Before(func() {
-				p1, err := provider.NewFederationDomainIssuer(issuer1)
+				p1, err := provider.NewFederationDomainIssuer(issuer1, nil, nil, provider.TokenLifetimes{})
 				r.NoError(err)
-				p2, err := provider.NewFederationDomainIssuer(issuer2)
+				p2, err := provider.NewFederationDomainIssuer(issuer2, nil, nil, provider.TokenLifetimes{})
 				r.NoError(err)
 				subject.SetProviders(p1, p2)
 
@@ -421,9 +494,9 @@ func TestManager(t *testing.T) {
 
 		when("given the same valid providers as arguments to SetProviders() in reverse order", func() {
 			it.Before(func() {
-				p1, err := provider.NewFederationDomainIssuer(issuer1)
+				p1, err := provider.NewFederationDomainIssuer(issuer1, nil, nil, provider.TokenLifetimes{})
 				r.NoError(err)
-				p2, err := provider.NewFederationDomainIssuer(issuer2)
+				p2, err := provider.NewFederationDomainIssuer(issuer2, nil, nil, provider.TokenLifetimes{})
 				r.NoError(err)
 				subject.SetProviders(p2, p1)
 
@@ -442,5 +515,122 @@ func TestManager(t *testing.T) {
 				requireRoutesMatchingRequestsToAppropriateProvider()
 			})
 		})
+
+		when("given providers which each allow a different subset of the upstream IDPs", func() {
+			it.Before(func() {
+				idpLister.SetOIDCIdentityProviders(append(idpLister.GetOIDCIdentityProviders(),
+					&oidctestutil.TestUpstreamOIDCIdentityProvider{Name: "other-oidc-idp"},
+				))
+				idpLister.SetLDAPIdentityProviders([]provider.UpstreamLDAPIdentityProviderI{
+					&oidctestutil.TestUpstreamLDAPIdentityProvider{Name: "some-ldap-idp"},
+				})
+
+				p1, err := provider.NewFederationDomainIssuer(issuer1, []provider.FederationDomainIdentityProvider{
+					{Name: upstreamIDPName, Type: "oidc"},
+				}, nil, provider.TokenLifetimes{})
+				r.NoError(err)
+				p2, err := provider.NewFederationDomainIssuer(issuer2, []provider.FederationDomainIdentityProvider{
+					{Name: "some-ldap-idp", Type: "ldap"},
+					{Name: upstreamIDPName, Type: "ldap"}, // wrong type, so it does not allow the OIDC upstream of the same name
+				}, nil, provider.TokenLifetimes{})
+				r.NoError(err)
+				subject.SetProviders(p1, p2)
+			})
+
+			it("only advertises the allowed upstream IDPs on each provider's IDP discovery endpoint", func() {
+				requirePinnipedIDPsDiscoveryRequestToBeHandled(issuer1, "", upstreamIDPName, upstreamIDPType)
+				requirePinnipedIDPsDiscoveryRequestToBeHandled(issuer2, "", "some-ldap-idp", "ldap")
+			})
+
+			it("only allows the allowed upstream IDPs to be chosen on each provider's authorization endpoint", func() {
+				authRequestParams := url.Values{
+					"response_type":         []string{"code"},
+					"scope":                 []string{"openid profile email"},
+					"client_id":             []string{downstreamClientID},
+					"state":                 []string{"some-state-value-with-enough-bytes-to-exceed-min-allowed"},
+					"nonce":                 []string{"some-nonce-value-with-enough-bytes-to-exceed-min-allowed"},
+					"code_challenge":        []string{testutil.SHA256(downstreamPKCECodeVerifier)},
+					"code_challenge_method": []string{"S256"},
+					"redirect_uri":          []string{downstreamRedirectURL},
+					"pinniped_idp_name":     []string{upstreamIDPName},
+					"pinniped_idp_type":     []string{upstreamIDPType},
+				}
+
+				requireAuthorizationRequestToBeHandled(issuer1, "?"+authRequestParams.Encode(), upstreamIDPAuthorizationURL)
+
+				recorder := httptest.NewRecorder()
+				subject.ServeHTTP(recorder, newGetRequest(issuer2+oidc.AuthorizationEndpointPath+"?"+authRequestParams.Encode()))
+				r.False(fallbackHandlerWasCalled)
+				r.Equal(http.StatusUnprocessableEntity, recorder.Code)
+				r.Equal("Unprocessable Entity: The requested upstream provider was not found\n", recorder.Body.String())
+			})
+		})
+
+		when("given providers with different token lifetimes via SetProviders()", func() {
+			it.Before(func() {
+				p1, err := provider.NewFederationDomainIssuer(issuer1, nil, nil, provider.TokenLifetimes{
+					AccessToken:       time.Hour,
+					AuthorizationCode: 5 * time.Minute,
+					RefreshToken:      24 * time.Hour,
+				})
+				r.NoError(err)
+				p2, err := provider.NewFederationDomainIssuer(issuer2, nil, nil, provider.TokenLifetimes{})
+				r.NoError(err)
+				subject.SetProviders(p1, p2)
+
+				jwksMap := map[string]*jose.JSONWebKeySet{
+					issuer1: {Keys: []jose.JSONWebKey{*newTestJWK(issuer1KeyID)}},
+					issuer2: {Keys: []jose.JSONWebKey{*newTestJWK(issuer2KeyID)}},
+				}
+				activeJWK := map[string]*jose.JSONWebKey{
+					issuer1: newTestJWK(issuer1KeyID),
+					issuer2: newTestJWK(issuer2KeyID),
+				}
+				dynamicJWKSProvider.SetIssuerToJWKSMap(jwksMap, activeJWK)
+			})
+
+			requireTokenLifetimes := func(requestIssuer string, jwkIssuerKeyID string, wantAccessTokenLifetime, wantAuthcodeStorageLifetime time.Duration) {
+				authRequestParams := "?" + url.Values{
+					"response_type":         []string{"code"},
+					"scope":                 []string{"openid profile email"},
+					"client_id":             []string{downstreamClientID},
+					"state":                 []string{"some-state-value-with-enough-bytes-to-exceed-min-allowed"},
+					"nonce":                 []string{"some-nonce-value-with-enough-bytes-to-exceed-min-allowed"},
+					"code_challenge":        []string{testutil.SHA256(downstreamPKCECodeVerifier)},
+					"code_challenge_method": []string{"S256"},
+					"redirect_uri":          []string{downstreamRedirectURL},
+				}.Encode()
+				csrfCookieValue, upstreamStateParam := requireAuthorizationRequestToBeHandled(requestIssuer, authRequestParams, upstreamIDPAuthorizationURL)
+
+				callbackRequestParams := "?" + url.Values{
+					"code":  []string{"some-fake-code"},
+					"state": []string{upstreamStateParam},
+				}.Encode()
+				startTime := time.Now()
+				authCode := requireCallbackRequestToBeHandled(requestIssuer, callbackRequestParams, csrfCookieValue)
+
+				// The storage lifetime of the authcode session is used by the garbage collector.
+				authcodeSecrets, err := kubeClient.CoreV1().Secrets("some-namespace").List(context.Background(), metav1.ListOptions{
+					LabelSelector: crud.SecretLabelKey + "=authcode",
+				})
+				r.NoError(err)
+				r.Len(authcodeSecrets.Items, 1)
+				garbageCollectAfter, err := time.Parse(crud.SecretLifetimeAnnotationDateFormat, authcodeSecrets.Items[0].Annotations[crud.SecretLifetimeAnnotationKey])
+				r.NoError(err)
+				r.WithinDuration(startTime.Add(wantAuthcodeStorageLifetime), garbageCollectAfter, 5*time.Second)
+
+				jwks := requireJWKSRequestToBeHandled(requestIssuer, "", jwkIssuerKeyID)
+				body := requireTokenRequestToBeHandled(requestIssuer, authCode, jwks, requestIssuer)
+				r.InDelta(wantAccessTokenLifetime.Seconds(), body["expires_in"], 5)
+			}
+
+			it("issues tokens with the lifetimes configured for the provider", func() {
+				requireTokenLifetimes(issuer1, issuer1KeyID, time.Hour, 5*time.Minute+24*time.Hour)
+			})
+
+			it("issues tokens with the default lifetimes when the provider does not configure them", func() {
+				requireTokenLifetimes(issuer2, issuer2KeyID, 2*time.Minute, 10*time.Minute+9*time.Hour)
+			})
+		})
 	})
 }
@@ -0,0 +1,29 @@
+// Copyright 2021 the Pinniped contributors. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+// Package revocation provides a handler for the OAuth 2.0 token revocation endpoint.
+package revocation
+
+import (
+	"net/http"
+
+	"github.com/ory/fosite"
+
+	"go.pinniped.dev/internal/httputil/httperr"
+	"go.pinniped.dev/internal/oidc"
+	"go.pinniped.dev/internal/plog"
+)
+
+// NewHandler returns an http.Handler that serves a token revocation endpoint, as described in
+// https://datatracker.ietf.org/doc/html/rfc7009. Revoking an access token or a refresh token revokes all of the
+// access tokens and refresh tokens of the same session, so the client can no longer refresh the session.
+func NewHandler(oauthHelper fosite.OAuth2Provider) http.Handler {
+	return httperr.HandlerFunc(func(w http.ResponseWriter, r *http.Request) error {
+		err := oauthHelper.NewRevocationRequest(r.Context(), r)
+		if err != nil {
+			plog.Info("revocation request error", oidc.FositeErrorForLog(err)...)
+		}
+		oauthHelper.WriteRevocationResponse(w, err)
+		return nil
+	})
+}
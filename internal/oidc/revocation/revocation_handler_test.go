@@ -0,0 +1,209 @@
+// Copyright 2021 the Pinniped contributors. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package revocation
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ory/fosite"
+	"github.com/ory/fosite/compose"
+	"github.com/ory/fosite/handler/openid"
+	"github.com/ory/fosite/token/jwt"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/crypto/bcrypt"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+
+	"go.pinniped.dev/internal/crud"
+	"go.pinniped.dev/internal/oidc"
+	"go.pinniped.dev/internal/oidc/clientregistry"
+	"go.pinniped.dev/internal/oidc/jwks"
+	"go.pinniped.dev/internal/psession"
+)
+
+const (
+	hmacSecret = "this needs to be at least 32 characters to meet entropy requirements"
+	namespace  = "some-namespace"
+
+	confidentialClientID     = "some-confidential-client"
+	confidentialClientSecret = "some-client-secret"
+)
+
+func TestRevocationHandler(t *testing.T) {
+	hashedClientSecret, err := bcrypt.GenerateFromPassword([]byte(confidentialClientSecret), bcrypt.MinCost)
+	require.NoError(t, err)
+
+	tests := []struct {
+		name            string
+		method          string
+		tokenClientID   string
+		revokeAccess    bool
+		body            url.Values
+		basicAuth       bool
+		wantStatus      int
+		wantBody        string
+		wantTokensExist bool
+	}{
+		{
+			name:          "revoke refresh token of public client",
+			tokenClientID: "pinniped-cli",
+			body:          url.Values{"token_type_hint": {"refresh_token"}, "client_id": {"pinniped-cli"}},
+			wantStatus:    http.StatusOK,
+		},
+		{
+			name:          "revoke access token of public client",
+			tokenClientID: "pinniped-cli",
+			revokeAccess:  true,
+			body:          url.Values{"token_type_hint": {"access_token"}, "client_id": {"pinniped-cli"}},
+			wantStatus:    http.StatusOK,
+		},
+		{
+			name:          "revoke refresh token of confidential client",
+			tokenClientID: confidentialClientID,
+			body:          url.Values{},
+			basicAuth:     true,
+			wantStatus:    http.StatusOK,
+		},
+		{
+			name:          "revoke unknown token",
+			tokenClientID: "pinniped-cli",
+			body:          url.Values{"token": {"some-unknown-token"}, "client_id": {"pinniped-cli"}},
+			wantStatus:    http.StatusOK,
+			// The token of the session was not revoked, because it was not the token in the request.
+			wantTokensExist: true,
+		},
+		{
+			name:            "revoke token of another client",
+			tokenClientID:   confidentialClientID,
+			body:            url.Values{"client_id": {"pinniped-cli"}},
+			wantStatus:      http.StatusOK,
+			wantTokensExist: true,
+		},
+		{
+			name:            "confidential client without client secret",
+			tokenClientID:   confidentialClientID,
+			body:            url.Values{"client_id": {confidentialClientID}},
+			wantStatus:      http.StatusUnauthorized,
+			wantBody:        `"error":"invalid_client"`,
+			wantTokensExist: true,
+		},
+		{
+			name:            "unknown client",
+			tokenClientID:   "pinniped-cli",
+			body:            url.Values{"client_id": {"some-unknown-client"}},
+			wantStatus:      http.StatusUnauthorized,
+			wantBody:        `"error":"invalid_client"`,
+			wantTokensExist: true,
+		},
+		{
+			name:            "GET request",
+			method:          http.MethodGet,
+			tokenClientID:   "pinniped-cli",
+			body:            url.Values{"client_id": {"pinniped-cli"}},
+			wantStatus:      http.StatusBadRequest,
+			wantBody:        `"error":"invalid_request"`,
+			wantTokensExist: true,
+		},
+	}
+	for _, test := range tests {
+		test := test
+		t.Run(test.name, func(t *testing.T) {
+			ctx := context.Background()
+			kubeClient := fake.NewSimpleClientset()
+			secrets := kubeClient.CoreV1().Secrets(namespace)
+
+			clients := clientregistry.NewDynamicClientManager()
+			clients.SetClients([]*clientregistry.Client{clientregistry.ConfidentialClient(
+				confidentialClientID,
+				hashedClientSecret,
+				[]string{"https://example.com/callback"},
+				[]string{"authorization_code", "refresh_token"},
+				[]string{"openid", "offline_access"},
+			)})
+			timeouts := oidc.DefaultOIDCTimeoutsConfiguration()
+			storage := oidc.NewKubeStorage(crud.NewSecretsBackend(secrets), clients, timeouts)
+			oauthHelper := oidc.FositeOauth2Helper(storage, "https://some-issuer.com", func() []byte { return []byte(hmacSecret) }, jwks.NewDynamicJWKSProvider(), timeouts)
+
+			client, err := clients.GetClient(ctx, test.tokenClientID)
+			require.NoError(t, err)
+			accessToken, refreshToken := storeTokens(t, storage, client, timeouts)
+
+			body := test.body
+			if _, ok := body["token"]; !ok {
+				token := refreshToken
+				if test.revokeAccess {
+					token = accessToken
+				}
+				body.Set("token", token)
+			}
+			method := http.MethodPost
+			if test.method != "" {
+				method = test.method
+			}
+			req := httptest.NewRequest(method, "/path/shouldn't/matter", strings.NewReader(body.Encode()))
+			req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+			if test.basicAuth {
+				req.SetBasicAuth(confidentialClientID, confidentialClientSecret)
+			}
+			rsp := httptest.NewRecorder()
+			NewHandler(oauthHelper).ServeHTTP(rsp, req)
+
+			require.Equal(t, test.wantStatus, rsp.Code, rsp.Body.String())
+			require.Equal(t, "no-store", rsp.Header().Get("Cache-Control"))
+			if test.wantBody != "" {
+				require.Contains(t, rsp.Body.String(), test.wantBody)
+			} else {
+				require.Empty(t, rsp.Body.String())
+			}
+
+			storedSecrets, err := secrets.List(ctx, metav1.ListOptions{})
+			require.NoError(t, err)
+			if test.wantTokensExist {
+				require.Len(t, storedSecrets.Items, 2)
+			} else {
+				require.Empty(t, storedSecrets.Items)
+			}
+		})
+	}
+}
+
+// storeTokens stores an access token and a refresh token of the same session, in the same way as the token endpoint.
+func storeTokens(t *testing.T, storage *oidc.KubeStorage, client fosite.Client, timeouts oidc.TimeoutsConfiguration) (string, string) {
+	t.Helper()
+	ctx := context.Background()
+	now := time.Now()
+
+	strategy := compose.NewOAuth2HMACStrategy(&compose.Config{}, []byte(hmacSecret), nil)
+	request := &fosite.Request{
+		ID:          "some-request-id",
+		RequestedAt: now,
+		Client:      client,
+		Session: &psession.PinnipedSession{
+			Fosite: &openid.DefaultSession{
+				Claims: &jwt.IDTokenClaims{Subject: "some-subject"},
+				ExpiresAt: map[fosite.TokenType]time.Time{
+					fosite.AccessToken:  now.Add(timeouts.AccessTokenLifespan),
+					fosite.RefreshToken: now.Add(timeouts.RefreshTokenLifespan),
+				},
+			},
+			Custom: &psession.CustomSessionData{ProviderName: "some-upstream", ProviderType: "oidc"},
+		},
+		RequestedScope: fosite.Arguments{"openid", "offline_access"},
+		GrantedScope:   fosite.Arguments{"openid", "offline_access"},
+	}
+
+	accessToken, accessSignature, err := strategy.GenerateAccessToken(ctx, request)
+	require.NoError(t, err)
+	require.NoError(t, storage.CreateAccessTokenSession(ctx, accessSignature, request))
+	refreshToken, refreshSignature, err := strategy.GenerateRefreshToken(ctx, request)
+	require.NoError(t, err)
+	require.NoError(t, storage.CreateRefreshTokenSession(ctx, refreshSignature, request))
+	return accessToken, refreshToken
+}
@@ -1,41 +1,448 @@
-// Copyright 2020 the Pinniped contributors. All Rights Reserved.
+// Copyright 2020-2021 the Pinniped contributors. All Rights Reserved.
 // SPDX-License-Identifier: Apache-2.0
 
 // Package token provides a handler for the OIDC token endpoint.
 package token
 
 import (
+	"context"
 	"net/http"
 
 	"github.com/ory/fosite"
-	"github.com/ory/fosite/handler/openid"
+	"github.com/pkg/errors"
 
+	"go.pinniped.dev/internal/auditlog"
 	"go.pinniped.dev/internal/httputil/httperr"
+	"go.pinniped.dev/internal/idtransform"
+	"go.pinniped.dev/internal/metrics"
 	"go.pinniped.dev/internal/oidc"
+	"go.pinniped.dev/internal/oidc/auth"
+	"go.pinniped.dev/internal/oidc/downstreamsession"
 	"go.pinniped.dev/internal/plog"
+	"go.pinniped.dev/internal/psession"
+)
+
+var (
+	// errMissingUpstreamSessionInternalError is returned when a downstream session does not remember which upstream
+	// was used to log in, which should not happen for sessions created by this version of the Supervisor.
+	errMissingUpstreamSessionInternalError = fosite.ErrServerError.WithHint("Required upstream data not found in session.")
+
+	// errUpstreamRefreshError is returned when the upstream no longer accepts the user, so the client should
+	// discard its refresh token and ask the user to log in again.
+	errUpstreamRefreshError = fosite.ErrInvalidGrant
 )
 
 func NewHandler(
+	idpLister oidc.UpstreamIdentityProvidersLister,
+	identityTransforms oidc.IdentityTransformsGetter,
 	oauthHelper fosite.OAuth2Provider,
 ) http.Handler {
 	return httperr.HandlerFunc(func(w http.ResponseWriter, r *http.Request) error {
-		var session openid.DefaultSession
-		accessRequest, err := oauthHelper.NewAccessRequest(r.Context(), r, &session)
+		session := psession.NewPinnipedSession()
+		accessRequest, err := oauthHelper.NewAccessRequest(r.Context(), r, session)
 		if err != nil {
 			plog.Info("token request error", oidc.FositeErrorForLog(err)...)
+			recordTokenRequest(r, accessRequest, err)
 			oauthHelper.WriteAccessError(w, accessRequest, err)
 			return nil
 		}
 
+		// When the client is using the password grant, log in the user at the upstream and start their downstream
+		// session before issuing the tokens.
+		if accessRequest.GetGrantTypes().ExactOne(oidc.GrantTypePassword) {
+			err = upstreamPasswordLogin(r, accessRequest, idpLister, identityTransforms)
+			if err != nil {
+				plog.Info("upstream password login error", oidc.FositeErrorForLog(err)...)
+				recordTokenRequest(r, accessRequest, err)
+				oauthHelper.WriteAccessError(w, accessRequest, err)
+				return nil
+			}
+		}
+
+		// When the client is refreshing its tokens, check that the user is still allowed to log in at the upstream,
+		// and update their groups, before issuing new tokens. Otherwise, a user who was disabled or deleted at the
+		// upstream would keep their access until their downstream refresh token expires.
+		if accessRequest.GetGrantTypes().ExactOne("refresh_token") {
+			err = upstreamRefresh(r.Context(), accessRequest, idpLister, identityTransforms)
+			if err != nil {
+				plog.Info("upstream refresh error", oidc.FositeErrorForLog(err)...)
+				recordTokenRequest(r, accessRequest, err)
+				oauthHelper.WriteAccessError(w, accessRequest, err)
+				return nil
+			}
+		}
+
 		accessResponse, err := oauthHelper.NewAccessResponse(r.Context(), accessRequest)
 		if err != nil {
 			plog.Info("token response error", oidc.FositeErrorForLog(err)...)
+			recordTokenRequest(r, accessRequest, err)
 			oauthHelper.WriteAccessError(w, accessRequest, err)
 			return nil
 		}
 
+		recordTokenRequest(r, accessRequest, nil)
 		oauthHelper.WriteAccessResponse(w, accessRequest, accessResponse)
 
 		return nil
 	})
 }
+
+// recordTokenRequest counts a token request by its grant type, and writes an audit event for refreshes and token
+// exchanges. Requests which were rejected because of something the client sent are failures, while requests which
+// could not be completed because of a problem in the Supervisor or at the upstream are errors.
+func recordTokenRequest(r *http.Request, accessRequest fosite.AccessRequester, err error) {
+	result := metrics.ResultSuccess
+	reason := ""
+	if err != nil {
+		rfcErr := fosite.ErrorToRFC6749Error(err)
+		result = metrics.ResultFailure
+		if rfcErr.CodeField >= http.StatusInternalServerError {
+			result = metrics.ResultError
+		}
+		reason = rfcErr.ErrorField
+	}
+
+	grantType := grantTypeLabel(r.PostForm.Get("grant_type"))
+	metrics.RecordTokenGrant(grantType, result)
+
+	event := auditlog.Event{Result: result, Reason: reason}
+	switch grantType {
+	case "refresh_token":
+		event.Type = auditlog.EventTypeRefresh
+	case "token_exchange":
+		event.Type = auditlog.EventTypeTokenExchange
+	case "password":
+		event.Type = auditlog.EventTypePasswordGrant
+	default:
+		return
+	}
+	if client := accessRequest.GetClient(); client != nil {
+		event.ClientID = client.GetID()
+	}
+	if session, ok := accessRequest.GetSession().(*psession.PinnipedSession); ok {
+		event.Subject, event.Username, event.Groups = downstreamsession.GetIdentityFromDownstreamSession(session)
+		if session.Custom != nil {
+			event.UpstreamIDPName = session.Custom.ProviderName
+			event.UpstreamIDPType = session.Custom.ProviderType
+		}
+	}
+	if event.Username == "" && grantType == "password" {
+		event.Username = r.PostForm.Get("username")
+	}
+	auditlog.Record(r, event)
+}
+
+// grantTypeLabel maps the grant_type parameter, which could be anything that a client sends, to one of the grant
+// types which this endpoint supports.
+func grantTypeLabel(grantType string) string {
+	switch grantType {
+	case "authorization_code", "refresh_token":
+		return grantType
+	case "urn:ietf:params:oauth:grant-type:token-exchange":
+		return "token_exchange"
+	case oidc.GrantTypeDeviceCode:
+		return "device_code"
+	case oidc.GrantTypePassword:
+		return "password"
+	default:
+		return "unknown"
+	}
+}
+
+// upstreamPasswordLogin checks the username and password from the request using the chosen LDAP or Active Directory
+// upstream, in the same way as the authorization endpoint does for the custom username and password headers, and
+// replaces the session of the request with the user's new downstream session.
+func upstreamPasswordLogin(
+	r *http.Request,
+	accessRequest fosite.AccessRequester,
+	idpLister oidc.UpstreamIdentityProvidersLister,
+	identityTransforms oidc.IdentityTransformsGetter,
+) error {
+	oidcUpstream, ldapUpstream, idpType, err := auth.ChooseUpstreamIDP(r, idpLister)
+	if err != nil {
+		return errors.WithStack(fosite.ErrInvalidRequest.WithHintf("%s.", err.Error()))
+	}
+	if oidcUpstream != nil {
+		return errors.WithStack(fosite.ErrInvalidRequest.WithHint(
+			"The password grant can only be used with LDAP and Active Directory upstream providers.",
+		))
+	}
+
+	clientID := accessRequest.GetClient().GetID()
+	username := accessRequest.GetRequestForm().Get("username")
+	password := accessRequest.GetRequestForm().Get("password")
+
+	authenticateResponse, authenticated, err := ldapUpstream.AuthenticateUser(r.Context(), username, password)
+	if err != nil {
+		plog.WarningErr("unexpected error during upstream LDAP authentication", err, "upstreamName", ldapUpstream.GetName())
+		metrics.RecordUpstreamLogin(idpType, ldapUpstream.GetName(), metrics.ResultError)
+		return errors.WithStack(fosite.ErrServerError.WithHint("Unexpected error during upstream authentication.").WithWrap(err))
+	}
+	if !authenticated {
+		plog.Debug("failed upstream LDAP authentication", "upstreamName", ldapUpstream.GetName())
+		metrics.RecordUpstreamLogin(idpType, ldapUpstream.GetName(), metrics.ResultFailure)
+		auditlog.Record(r, auditlog.Event{
+			Type:            auditlog.EventTypeFailedCredentials,
+			Result:          auditlog.ResultFailure,
+			Reason:          "username/password not accepted by upstream",
+			ClientID:        clientID,
+			UpstreamIDPName: ldapUpstream.GetName(),
+			UpstreamIDPType: idpType,
+			Username:        username,
+		})
+		return errors.WithStack(fosite.ErrInvalidGrant.WithHint("Username/password not accepted by LDAP provider."))
+	}
+
+	identity := identityTransforms.IdentityTransforms(idpType, ldapUpstream.GetName()).Evaluate(
+		authenticateResponse.User.GetName(),
+		authenticateResponse.User.GetGroups(),
+		idtransform.AttributesAsClaims(authenticateResponse.Attributes),
+	)
+	if identity.Rejected {
+		plog.Info("login rejected by identity transforms",
+			"upstreamName", ldapUpstream.GetName(), "reason", identity.RejectedReason)
+		metrics.RecordUpstreamLogin(idpType, ldapUpstream.GetName(), metrics.ResultFailure)
+		return errors.WithStack(fosite.ErrInvalidGrant.WithHintf("Login was rejected: %s.", identity.RejectedReason))
+	}
+
+	metrics.RecordUpstreamLogin(idpType, ldapUpstream.GetName(), metrics.ResultSuccess)
+
+	customSessionData := &psession.CustomSessionData{
+		ProviderName: ldapUpstream.GetName(),
+		ProviderType: idpType,
+		LDAP: &psession.LDAPSessionData{
+			UserDN: authenticateResponse.DN,
+		},
+	}
+	accessRequest.SetSession(downstreamsession.MakeDownstreamSession(
+		accessRequest.GetID(),
+		downstreamsession.DownstreamSubjectFromUpstreamLDAP(ldapUpstream, authenticateResponse.User.GetUID()),
+		identity.Username,
+		identity.Groups,
+		authenticateResponse.AdditionalClaims,
+		customSessionData,
+	))
+	downstreamsession.GrantScopesIfRequested(accessRequest)
+
+	return nil
+}
+
+// upstreamRefresh checks the user against the upstream which was used to log in, and updates the session which will
+// be stored with the new downstream refresh token. Fosite has already cloned the original session into the request,
+// so changes to the session will be reflected in the new downstream tokens.
+func upstreamRefresh(
+	ctx context.Context,
+	accessRequest fosite.AccessRequester,
+	idpLister oidc.UpstreamIdentityProvidersLister,
+	identityTransforms oidc.IdentityTransformsGetter,
+) error {
+	session, ok := accessRequest.GetSession().(*psession.PinnipedSession)
+	if !ok || session.Fosite == nil || session.IDTokenClaims() == nil || session.Custom == nil {
+		return errors.WithStack(errMissingUpstreamSessionInternalError)
+	}
+	customSessionData := session.Custom
+	if customSessionData.ProviderName == "" || customSessionData.ProviderType == "" {
+		return errors.WithStack(errMissingUpstreamSessionInternalError)
+	}
+
+	switch customSessionData.ProviderType {
+	case oidc.IDPTypeOIDC:
+		return upstreamOIDCRefresh(ctx, session, idpLister, identityTransforms)
+	case oidc.IDPTypeLDAP, oidc.IDPTypeActiveDirectory:
+		return upstreamLDAPRefresh(ctx, session, idpLister, identityTransforms)
+	default:
+		return errors.WithStack(errMissingUpstreamSessionInternalError)
+	}
+}
+
+func upstreamOIDCRefresh(
+	ctx context.Context,
+	session *psession.PinnipedSession,
+	idpLister oidc.UpstreamIdentityProvidersLister,
+	identityTransforms oidc.IdentityTransformsGetter,
+) error {
+	customSessionData := session.Custom
+	if customSessionData.OIDC == nil {
+		return errors.WithStack(errMissingUpstreamSessionInternalError)
+	}
+
+	p := oidc.FindUpstreamOIDCIdentityProvider(customSessionData.ProviderName, idpLister)
+	if p == nil {
+		return errors.WithStack(errUpstreamRefreshError.WithHintf(
+			"Provider %q of type %q from upstream session data was not found.",
+			customSessionData.ProviderName, customSessionData.ProviderType,
+		))
+	}
+
+	if customSessionData.OIDC.UpstreamRefreshToken == "" {
+		// Without an upstream refresh token there is no way to check that the user's session is still valid at the
+		// upstream, so the user must log in again. The upstream usually only returns a refresh token when it is
+		// configured to request the "offline_access" scope using its additionalScopes.
+		plog.Warning("upstream refresh token not found in downstream session, so the user must log in again",
+			"upstreamName", p.GetName())
+		return errors.WithStack(errUpstreamRefreshError.WithHint(
+			"Upstream refresh token not found in session, so the user must log in again.",
+		))
+	}
+
+	refreshedTokens, err := p.PerformRefresh(ctx, customSessionData.OIDC.UpstreamRefreshToken)
+	if err != nil {
+		return errors.WithStack(errUpstreamRefreshError.WithHintf(
+			"Upstream refresh failed using provider %q of type %q.",
+			customSessionData.ProviderName, customSessionData.ProviderType,
+		).WithWrap(err))
+	}
+
+	// The upstream is not required to return a new ID token during a refresh. When it does, check that it still
+	// describes the same user, and use it to update the user's groups and additional claims.
+	if _, hasIDToken := refreshedTokens.Extra("id_token").(string); hasIDToken {
+		validatedTokens, err := p.ValidateToken(ctx, refreshedTokens, "")
+		if err != nil {
+			return errors.WithStack(errUpstreamRefreshError.WithHintf(
+				"Upstream refresh returned an invalid ID token using provider %q of type %q.",
+				customSessionData.ProviderName, customSessionData.ProviderType,
+			).WithWrap(err))
+		}
+
+		subject, username, err := downstreamsession.GetSubjectAndUsernameFromUpstreamIDToken(p, validatedTokens.IDToken.Claims)
+		if err != nil {
+			return errors.WithStack(errUpstreamRefreshError.WithHintf(
+				"Upstream refresh returned an invalid ID token using provider %q of type %q.",
+				customSessionData.ProviderName, customSessionData.ProviderType,
+			).WithWrap(err))
+		}
+
+		groups, err := downstreamsession.GetGroupsFromUpstreamIDToken(p, validatedTokens.IDToken.Claims)
+		if err != nil {
+			return errors.WithStack(errUpstreamRefreshError.WithHintf(
+				"Upstream refresh returned an invalid ID token using provider %q of type %q.",
+				customSessionData.ProviderName, customSessionData.ProviderType,
+			).WithWrap(err))
+		}
+
+		identity, err := transformIdentity(session, identityTransforms, username, groups, validatedTokens.IDToken.Claims)
+		if err != nil {
+			return err
+		}
+		if err := validateIdentityUnchanged(session, subject, identity.Username); err != nil {
+			return err
+		}
+		updateGroups(session, identity.Groups)
+		downstreamsession.SetAdditionalClaims(session,
+			downstreamsession.GetAdditionalClaimsFromUpstreamIDToken(p, validatedTokens.IDToken.Claims))
+		if p.GetEndSessionURL() != nil {
+			customSessionData.OIDC.UpstreamIDToken = validatedTokens.IDToken.Token
+		}
+	}
+
+	// Upstream providers may rotate refresh tokens, in which case the old one may no longer work for the next refresh.
+	if refreshedTokens.RefreshToken != "" {
+		customSessionData.OIDC.UpstreamRefreshToken = refreshedTokens.RefreshToken
+	}
+
+	return nil
+}
+
+func upstreamLDAPRefresh(
+	ctx context.Context,
+	session *psession.PinnipedSession,
+	idpLister oidc.UpstreamIdentityProvidersLister,
+	identityTransforms oidc.IdentityTransformsGetter,
+) error {
+	customSessionData := session.Custom
+	if customSessionData.LDAP == nil || customSessionData.LDAP.UserDN == "" {
+		return errors.WithStack(errMissingUpstreamSessionInternalError)
+	}
+
+	p := oidc.FindUpstreamLDAPIdentityProviderByNameAndType(customSessionData.ProviderName, customSessionData.ProviderType, idpLister)
+	if p == nil {
+		return errors.WithStack(errUpstreamRefreshError.WithHintf(
+			"Provider %q of type %q from upstream session data was not found.",
+			customSessionData.ProviderName, customSessionData.ProviderType,
+		))
+	}
+
+	response, found, err := p.PerformRefresh(ctx, customSessionData.LDAP.UserDN)
+	if err != nil {
+		return errors.WithStack(errUpstreamRefreshError.WithHintf(
+			"Upstream refresh failed using provider %q of type %q.",
+			customSessionData.ProviderName, customSessionData.ProviderType,
+		).WithWrap(err))
+	}
+	if !found {
+		return errors.WithStack(errUpstreamRefreshError.WithHintf(
+			"Upstream refresh failed using provider %q of type %q because the user was not found or is not allowed to log in.",
+			customSessionData.ProviderName, customSessionData.ProviderType,
+		))
+	}
+
+	identity, err := transformIdentity(session, identityTransforms,
+		response.User.GetName(), response.User.GetGroups(), idtransform.AttributesAsClaims(response.Attributes))
+	if err != nil {
+		return err
+	}
+	subject := downstreamsession.DownstreamSubjectFromUpstreamLDAP(p, response.User.GetUID())
+	if err := validateIdentityUnchanged(session, subject, identity.Username); err != nil {
+		return err
+	}
+	updateGroups(session, identity.Groups)
+	downstreamsession.SetAdditionalClaims(session, response.AdditionalClaims)
+
+	return nil
+}
+
+// transformIdentity applies the identity transforms to the refreshed upstream identity, in the same way as during
+// the original login, and returns an error when the transforms no longer allow the user to log in.
+func transformIdentity(
+	session *psession.PinnipedSession,
+	identityTransforms oidc.IdentityTransformsGetter,
+	username string,
+	groups []string,
+	claims map[string]interface{},
+) (*idtransform.Result, error) {
+	identity := identityTransforms.IdentityTransforms(session.Custom.ProviderType, session.Custom.ProviderName).
+		Evaluate(username, groups, claims)
+	if identity.Rejected {
+		plog.Info("upstream refresh rejected by identity transforms",
+			"upstreamName", session.Custom.ProviderName,
+			"upstreamType", session.Custom.ProviderType,
+			"reason", identity.RejectedReason,
+		)
+		return nil, errors.WithStack(errUpstreamRefreshError.WithHintf(
+			"Upstream refresh was rejected using provider %q of type %q: %s.",
+			session.Custom.ProviderName, session.Custom.ProviderType, identity.RejectedReason,
+		))
+	}
+	return identity, nil
+}
+
+// validateIdentityUnchanged returns an error when the upstream now describes a different user than the one who
+// originally logged in, because the downstream subject and username should never change during a session.
+func validateIdentityUnchanged(session *psession.PinnipedSession, subject string, username string) error {
+	claims := session.IDTokenClaims()
+	originalUsername, _ := claims.Extra[oidc.DownstreamUsernameClaim].(string)
+	if subject != claims.Subject || username != originalUsername {
+		plog.Info("upstream refresh found a different identity than the original login",
+			"upstreamName", session.Custom.ProviderName,
+			"upstreamType", session.Custom.ProviderType,
+			"originalSubject", claims.Subject,
+			"refreshedSubject", subject,
+		)
+		return errors.WithStack(errUpstreamRefreshError.WithHintf(
+			"Upstream refresh found a different identity using provider %q of type %q.",
+			session.Custom.ProviderName, session.Custom.ProviderType,
+		))
+	}
+	return nil
+}
+
+func updateGroups(session *psession.PinnipedSession, groups []string) {
+	if groups == nil {
+		groups = []string{}
+	}
+	claims := session.IDTokenClaims()
+	if claims.Extra == nil {
+		claims.Extra = map[string]interface{}{}
+	}
+	claims.Extra[oidc.DownstreamGroupsClaim] = groups
+}
@@ -29,25 +29,38 @@ import (
 	"github.com/ory/fosite/token/jwt"
 	"github.com/pkg/errors"
 	"github.com/stretchr/testify/require"
+	"golang.org/x/crypto/bcrypt"
+	xoauth2 "golang.org/x/oauth2"
 	"gopkg.in/square/go-jose.v2"
 	josejwt "gopkg.in/square/go-jose.v2/jwt"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apiserver/pkg/authentication/user"
 	"k8s.io/client-go/kubernetes/fake"
 	v1 "k8s.io/client-go/kubernetes/typed/core/v1"
 
+	configv1alpha1 "go.pinniped.dev/generated/latest/apis/supervisor/config/v1alpha1"
+	"go.pinniped.dev/internal/authenticators"
 	"go.pinniped.dev/internal/crud"
+	"go.pinniped.dev/internal/fositestorage"
 	"go.pinniped.dev/internal/fositestorage/accesstoken"
 	"go.pinniped.dev/internal/fositestorage/authorizationcode"
+	"go.pinniped.dev/internal/fositestorage/devicecode"
 	"go.pinniped.dev/internal/fositestorage/openidconnect"
 	storagepkce "go.pinniped.dev/internal/fositestorage/pkce"
 	"go.pinniped.dev/internal/fositestorage/refreshtoken"
 	"go.pinniped.dev/internal/fositestoragei"
 	"go.pinniped.dev/internal/here"
 	"go.pinniped.dev/internal/oidc"
+	"go.pinniped.dev/internal/oidc/clientregistry"
+	"go.pinniped.dev/internal/oidc/downstreamsession"
 	"go.pinniped.dev/internal/oidc/jwks"
+	"go.pinniped.dev/internal/oidc/provider"
+	"go.pinniped.dev/internal/psession"
 	"go.pinniped.dev/internal/testutil"
 	"go.pinniped.dev/internal/testutil/oidctestutil"
+	"go.pinniped.dev/pkg/oidcclient/nonce"
+	"go.pinniped.dev/pkg/oidcclient/oidctypes"
 )
 
 const (
@@ -58,7 +71,6 @@ const (
 	goodNonce            = "some-nonce-value-with-enough-bytes-to-exceed-min-allowed"
 	goodSubject          = "https://issuer?sub=some-subject"
 	goodUsername         = "some-username"
-	goodGroups           = "group1,groups2"
 
 	hmacSecret = "this needs to be at least 32 characters to meet entropy requirements"
 
@@ -70,6 +82,7 @@ const (
 )
 
 var (
+	goodGroups          = []string{"group1", "groups2"}
 	goodAuthTime        = time.Date(1, 2, 3, 4, 5, 6, 7, time.UTC)
 	goodRequestedAtTime = time.Date(7, 6, 5, 4, 3, 2, 1, time.UTC)
 
@@ -210,9 +223,17 @@ type tokenEndpointResponseExpectedValues struct {
 	wantErrorResponseBody string
 	wantRequestedScopes   []string
 	wantGrantedScopes     []string
+	// The groups which should be in the ID token and in the stored session. When nil, goodGroups is expected.
+	wantGroups []string
+	// The custom session data which should be stored with the access and refresh tokens. When nil, the custom
+	// session data from the original authorize request is expected.
+	wantCustomSessionDataStored *psession.CustomSessionData
 }
 
 type authcodeExchangeInputs struct {
+	// The custom session data which was stored by the authorize or callback endpoint for the upstream which was
+	// used to log in. When nil, initialUpstreamOIDCCustomSessionData() is used.
+	customSessionData  *psession.CustomSessionData
 	modifyAuthRequest  func(authRequest *http.Request)
 	modifyTokenRequest func(tokenRequest *http.Request, authCode string)
 	modifyStorage      func(
@@ -224,8 +245,12 @@ type authcodeExchangeInputs struct {
 		t *testing.T,
 		authRequest *http.Request,
 		store fositestoragei.AllFositeStorage,
+		initialCustomSessionData *psession.CustomSessionData,
 	) (fosite.OAuth2Provider, string, *ecdsa.PrivateKey)
 
+	// The identity transforms of the FederationDomain, which are also applied during refreshes.
+	identityTransforms oidctestutil.TestIdentityTransforms
+
 	want tokenEndpointResponseExpectedValues
 }
 
@@ -518,7 +543,7 @@ func TestTokenEndpoint(t *testing.T) {
 		t.Run(test.name, func(t *testing.T) {
 			t.Parallel()
 
-			exchangeAuthcodeForTokens(t, test.authcodeExchange)
+			exchangeAuthcodeForTokens(t, test.authcodeExchange, oidctestutil.NewUpstreamIDPListerBuilder().Build())
 		})
 	}
 }
@@ -547,7 +572,7 @@ func TestTokenEndpointWhenAuthcodeIsUsedTwice(t *testing.T) {
 			t.Parallel()
 
 			// First call - should be successful.
-			subject, rsp, authCode, _, secrets, oauthStore := exchangeAuthcodeForTokens(t, test.authcodeExchange)
+			subject, rsp, authCode, _, secrets, oauthStore := exchangeAuthcodeForTokens(t, test.authcodeExchange, oidctestutil.NewUpstreamIDPListerBuilder().Build())
 			var parsedResponseBody map[string]interface{}
 			require.NoError(t, json.Unmarshal(rsp.Body.Bytes(), &parsedResponseBody))
 
@@ -573,7 +598,8 @@ func TestTokenEndpointWhenAuthcodeIsUsedTwice(t *testing.T) {
 			requireInvalidPKCEStorage(t, authCode, oauthStore)
 			// Fosite never cleans up OpenID Connect session storage, so it is still there
 			requireValidOIDCStorage(t, parsedResponseBody, authCode, oauthStore,
-				test.authcodeExchange.want.wantRequestedScopes, test.authcodeExchange.want.wantGrantedScopes)
+				test.authcodeExchange.want.wantRequestedScopes, test.authcodeExchange.want.wantGrantedScopes,
+				initialUpstreamOIDCCustomSessionData())
 
 			// Check that the access token and refresh token storage were both deleted, and the number of other storage objects did not change.
 			testutil.RequireNumberOfSecretsMatchingLabelSelector(t, secrets, labels.Set{crud.SecretLabelKey: authorizationcode.TypeLabelValue}, 1)
@@ -586,6 +612,331 @@ func TestTokenEndpointWhenAuthcodeIsUsedTwice(t *testing.T) {
 	}
 }
 
+func TestDeviceCodeGrant(t *testing.T) {
+	const (
+		deviceCode = "some-device-code"
+		signature  = "some-device-code-signature"
+	)
+
+	approvedSession := func(t *testing.T, modify func(*devicecode.Session)) *devicecode.Session {
+		client, err := (&clientregistry.StaticClientManager{}).GetClient(context.Background(), goodClient)
+		require.NoError(t, err)
+		session := psession.NewPinnipedSession()
+		session.Fosite.Claims.Subject = goodSubject
+		session.Fosite.Claims.RequestedAt = goodRequestedAtTime
+		session.Fosite.Claims.AuthTime = goodAuthTime
+		session.Fosite.Claims.Extra = map[string]interface{}{"username": goodUsername, "groups": goodGroups}
+		session.Custom = initialUpstreamOIDCCustomSessionData()
+		deviceSession := &devicecode.Session{
+			Request: &fosite.Request{
+				ID:             "some-request-id",
+				RequestedAt:    goodRequestedAtTime,
+				Client:         client.(*clientregistry.Client),
+				RequestedScope: fosite.Arguments{"openid", "offline_access", "profile"},
+				GrantedScope:   fosite.Arguments{"openid", "offline_access"},
+				Form:           url.Values{"client_id": []string{goodClient}},
+				Session:        session,
+			},
+			Status:       devicecode.StatusApproved,
+			UserCode:     "BCDFGHJK",
+			ExpiresAt:    time.Now().Add(time.Minute),
+			UpstreamName: oidcUpstreamName,
+			UpstreamType: "oidc",
+		}
+		if modify != nil {
+			modify(deviceSession)
+		}
+		return deviceSession
+	}
+
+	tests := []struct {
+		name    string
+		session func(t *testing.T) *devicecode.Session
+		body    body
+
+		wantStatus            int
+		wantErrorCode         string
+		wantSuccessBodyFields []string
+	}{
+		{
+			name:                  "approved request returns tokens",
+			session:               func(t *testing.T) *devicecode.Session { return approvedSession(t, nil) },
+			body:                  happyDeviceCodeRequestBody(deviceCode),
+			wantStatus:            http.StatusOK,
+			wantSuccessBodyFields: []string{"id_token", "refresh_token", "access_token", "token_type", "expires_in", "scope"},
+		},
+		{
+			name: "pending request",
+			session: func(t *testing.T) *devicecode.Session {
+				return approvedSession(t, func(s *devicecode.Session) { s.Status = devicecode.StatusPending })
+			},
+			body:          happyDeviceCodeRequestBody(deviceCode),
+			wantStatus:    http.StatusBadRequest,
+			wantErrorCode: "authorization_pending",
+		},
+		{
+			name: "expired request",
+			session: func(t *testing.T) *devicecode.Session {
+				return approvedSession(t, func(s *devicecode.Session) { s.ExpiresAt = time.Now().Add(-time.Second) })
+			},
+			body:          happyDeviceCodeRequestBody(deviceCode),
+			wantStatus:    http.StatusBadRequest,
+			wantErrorCode: "expired_token",
+		},
+		{
+			name:          "unknown device code",
+			body:          happyDeviceCodeRequestBody(deviceCode),
+			wantStatus:    http.StatusBadRequest,
+			wantErrorCode: "invalid_grant",
+		},
+		{
+			name:          "missing device code",
+			session:       func(t *testing.T) *devicecode.Session { return approvedSession(t, nil) },
+			body:          happyDeviceCodeRequestBody(deviceCode).with("device_code", ""),
+			wantStatus:    http.StatusBadRequest,
+			wantErrorCode: "invalid_request",
+		},
+	}
+	for _, test := range tests {
+		test := test
+		t.Run(test.name, func(t *testing.T) {
+			t.Parallel()
+
+			secrets := fake.NewSimpleClientset().CoreV1().Secrets("some-namespace")
+			oauthStore := oidc.NewKubeStorage(crud.NewSecretsBackend(secrets), &clientregistry.StaticClientManager{}, oidc.DefaultOIDCTimeoutsConfiguration())
+			_, jwkProvider := generateJWTSigningKeyAndJWKSProvider(t, goodIssuer)
+			oauthHelper := oidc.FositeOauth2Helper(oauthStore, goodIssuer, hmacSecretFunc, jwkProvider, oidc.DefaultOIDCTimeoutsConfiguration())
+			subject := NewHandler(oidctestutil.NewUpstreamIDPListerBuilder().Build(), oidctestutil.TestIdentityTransforms{}, oauthHelper)
+
+			if test.session != nil {
+				require.NoError(t, oauthStore.CreateDeviceCodeSession(context.Background(), devicecode.Signature(deviceCode), test.session(t)))
+			}
+
+			req := httptest.NewRequest("POST", "/path/shouldn't/matter", test.body.ReadCloser())
+			req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+			rsp := httptest.NewRecorder()
+			subject.ServeHTTP(rsp, req)
+			t.Logf("response: %#v", rsp)
+			t.Logf("response body: %q", rsp.Body.String())
+
+			require.Equal(t, test.wantStatus, rsp.Code)
+			testutil.RequireEqualContentType(t, rsp.Header().Get("Content-Type"), "application/json")
+
+			var parsedResponseBody map[string]interface{}
+			require.NoError(t, json.Unmarshal(rsp.Body.Bytes(), &parsedResponseBody))
+			if test.wantErrorCode != "" {
+				require.Equal(t, test.wantErrorCode, parsedResponseBody["error"])
+				return
+			}
+			require.ElementsMatch(t, test.wantSuccessBodyFields, getMapKeys(parsedResponseBody))
+			require.Equal(t, "openid offline_access", parsedResponseBody["scope"])
+
+			// The device code may only be redeemed once.
+			_, err := oauthStore.GetDeviceCodeSession(context.Background(), devicecode.Signature(deviceCode))
+			require.True(t, errors.Is(err, fosite.ErrNotFound))
+			testutil.RequireNumberOfSecretsMatchingLabelSelector(t, secrets, labels.Set{crud.SecretLabelKey: devicecode.TypeLabelValue}, 0)
+			testutil.RequireNumberOfSecretsMatchingLabelSelector(t, secrets, labels.Set{crud.SecretLabelKey: accesstoken.TypeLabelValue}, 1)
+			testutil.RequireNumberOfSecretsMatchingLabelSelector(t, secrets, labels.Set{crud.SecretLabelKey: refreshtoken.TypeLabelValue}, 1)
+		})
+	}
+}
+
+func TestPasswordGrant(t *testing.T) {
+	const (
+		passwordGrantClientID     = "some-password-grant-client"
+		passwordGrantClientSecret = "some-client-secret"
+		ldapUpstreamName          = "some-ldap-idp"
+		ldapUsername              = "some-ldap-username"
+		ldapPassword              = "some-ldap-password"
+		ldapUserUID               = "some-ldap-uid"
+	)
+
+	hashedSecret, err := bcrypt.GenerateFromPassword([]byte(passwordGrantClientSecret), bcrypt.MinCost)
+	require.NoError(t, err)
+
+	passwordGrantClient := func(grantTypes ...string) *clientregistry.Client {
+		return clientregistry.ConfidentialClient(
+			passwordGrantClientID,
+			hashedSecret,
+			[]string{"https://some-client.example.com/callback"},
+			grantTypes,
+			[]string{"openid", "offline_access"},
+		)
+	}
+
+	ldapUpstream := func() *oidctestutil.TestUpstreamLDAPIdentityProvider {
+		return &oidctestutil.TestUpstreamLDAPIdentityProvider{
+			Name: ldapUpstreamName,
+			URL:  &url.URL{Scheme: "ldaps", Host: "ldap.example.com"},
+			AuthenticateFunc: func(ctx context.Context, username, password string) (*authenticators.Response, bool, error) {
+				if username != ldapUsername || password != ldapPassword {
+					return nil, false, nil
+				}
+				return &authenticators.Response{
+					User: &user.DefaultInfo{Name: ldapUsername, UID: ldapUserUID, Groups: goodGroups},
+					DN:   "cn=some-ldap-username,ou=users,dc=example,dc=com",
+				}, true, nil
+			},
+		}
+	}
+
+	happyPasswordGrantRequestBody := func() body {
+		return map[string][]string{
+			"grant_type": {"password"},
+			"username":   {ldapUsername},
+			"password":   {ldapPassword},
+			"scope":      {"openid offline_access"},
+		}
+	}
+
+	tests := []struct {
+		name      string
+		client    *clientregistry.Client
+		upstreams *oidctestutil.UpstreamIDPListerBuilder
+		body      body
+
+		wantStatus            int
+		wantErrorCode         string
+		wantSuccessBodyFields []string
+	}{
+		{
+			name:                  "valid username and password returns tokens",
+			client:                passwordGrantClient("authorization_code", "refresh_token", "password"),
+			upstreams:             oidctestutil.NewUpstreamIDPListerBuilder().WithLDAP(ldapUpstream()),
+			body:                  happyPasswordGrantRequestBody(),
+			wantStatus:            http.StatusOK,
+			wantSuccessBodyFields: []string{"id_token", "refresh_token", "access_token", "token_type", "expires_in", "scope"},
+		},
+		{
+			name:                  "valid username and password returns tokens without a refresh token when the client may not refresh",
+			client:                passwordGrantClient("authorization_code", "password"),
+			upstreams:             oidctestutil.NewUpstreamIDPListerBuilder().WithLDAP(ldapUpstream()),
+			body:                  happyPasswordGrantRequestBody(),
+			wantStatus:            http.StatusOK,
+			wantSuccessBodyFields: []string{"id_token", "access_token", "token_type", "expires_in", "scope"},
+		},
+		{
+			name:          "client is not allowed to use the password grant",
+			client:        passwordGrantClient("authorization_code", "refresh_token"),
+			upstreams:     oidctestutil.NewUpstreamIDPListerBuilder().WithLDAP(ldapUpstream()),
+			body:          happyPasswordGrantRequestBody(),
+			wantStatus:    http.StatusBadRequest,
+			wantErrorCode: "unauthorized_client",
+		},
+		{
+			name:          "wrong password",
+			client:        passwordGrantClient("authorization_code", "refresh_token", "password"),
+			upstreams:     oidctestutil.NewUpstreamIDPListerBuilder().WithLDAP(ldapUpstream()),
+			body:          happyPasswordGrantRequestBody().with("password", "wrong-password"),
+			wantStatus:    http.StatusBadRequest,
+			wantErrorCode: "invalid_grant",
+		},
+		{
+			name:          "missing password",
+			client:        passwordGrantClient("authorization_code", "refresh_token", "password"),
+			upstreams:     oidctestutil.NewUpstreamIDPListerBuilder().WithLDAP(ldapUpstream()),
+			body:          happyPasswordGrantRequestBody().with("password", ""),
+			wantStatus:    http.StatusBadRequest,
+			wantErrorCode: "invalid_request",
+		},
+		{
+			name:          "scope which the client is not allowed to request",
+			client:        passwordGrantClient("authorization_code", "refresh_token", "password"),
+			upstreams:     oidctestutil.NewUpstreamIDPListerBuilder().WithLDAP(ldapUpstream()),
+			body:          happyPasswordGrantRequestBody().with("scope", "openid pinniped:request-audience"),
+			wantStatus:    http.StatusBadRequest,
+			wantErrorCode: "invalid_scope",
+		},
+		{
+			name:   "only an OIDC upstream is configured",
+			client: passwordGrantClient("authorization_code", "refresh_token", "password"),
+			upstreams: oidctestutil.NewUpstreamIDPListerBuilder().WithOIDC(&oidctestutil.TestUpstreamOIDCIdentityProvider{
+				Name: oidcUpstreamName,
+			}),
+			body:          happyPasswordGrantRequestBody(),
+			wantStatus:    http.StatusBadRequest,
+			wantErrorCode: "invalid_request",
+		},
+		{
+			name:   "upstream returns an error",
+			client: passwordGrantClient("authorization_code", "refresh_token", "password"),
+			upstreams: oidctestutil.NewUpstreamIDPListerBuilder().WithLDAP(&oidctestutil.TestUpstreamLDAPIdentityProvider{
+				Name: ldapUpstreamName,
+				AuthenticateFunc: func(ctx context.Context, username, password string) (*authenticators.Response, bool, error) {
+					return nil, false, fmt.Errorf("some ldap error")
+				},
+			}),
+			body:          happyPasswordGrantRequestBody(),
+			wantStatus:    http.StatusInternalServerError,
+			wantErrorCode: "server_error",
+		},
+	}
+	for _, test := range tests {
+		test := test
+		t.Run(test.name, func(t *testing.T) {
+			t.Parallel()
+
+			clientManager := clientregistry.NewDynamicClientManager()
+			clientManager.SetClients([]*clientregistry.Client{test.client})
+			secrets := fake.NewSimpleClientset().CoreV1().Secrets("some-namespace")
+			oauthStore := oidc.NewKubeStorage(crud.NewSecretsBackend(secrets), clientManager, oidc.DefaultOIDCTimeoutsConfiguration())
+			jwtSigningKey, jwkProvider := generateJWTSigningKeyAndJWKSProvider(t, goodIssuer)
+			oauthHelper := oidc.FositeOauth2Helper(oauthStore, goodIssuer, hmacSecretFunc, jwkProvider, oidc.DefaultOIDCTimeoutsConfiguration())
+			idps := test.upstreams.Build()
+			subject := NewHandler(idps, oidctestutil.TestIdentityTransforms{}, oauthHelper)
+
+			req := httptest.NewRequest("POST", "/path/shouldn't/matter", test.body.ReadCloser())
+			req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+			req.SetBasicAuth(passwordGrantClientID, passwordGrantClientSecret)
+			rsp := httptest.NewRecorder()
+			subject.ServeHTTP(rsp, req)
+			t.Logf("response: %#v", rsp)
+			t.Logf("response body: %q", rsp.Body.String())
+
+			require.Equal(t, test.wantStatus, rsp.Code)
+			testutil.RequireEqualContentType(t, rsp.Header().Get("Content-Type"), "application/json")
+
+			var parsedResponseBody map[string]interface{}
+			require.NoError(t, json.Unmarshal(rsp.Body.Bytes(), &parsedResponseBody))
+			if test.wantErrorCode != "" {
+				require.Equal(t, test.wantErrorCode, parsedResponseBody["error"])
+				testutil.RequireNumberOfSecretsMatchingLabelSelector(t, secrets, labels.Set{}, 0)
+				return
+			}
+			require.ElementsMatch(t, test.wantSuccessBodyFields, getMapKeys(parsedResponseBody))
+			require.Equal(t, "openid offline_access", parsedResponseBody["scope"])
+
+			token := oidctestutil.VerifyECDSAIDToken(t, goodIssuer, passwordGrantClientID, jwtSigningKey, parsedResponseBody["id_token"].(string))
+			var claims struct {
+				Subject   string   `json:"sub"`
+				Username  string   `json:"username"`
+				Groups    []string `json:"groups"`
+				SessionID string   `json:"sid"`
+			}
+			require.NoError(t, token.Claims(&claims))
+			require.Equal(t, downstreamsession.DownstreamSubjectFromUpstreamLDAP(idps.GetLDAPIdentityProviders()[0], ldapUserUID), claims.Subject)
+			require.Equal(t, ldapUsername, claims.Username)
+			require.Equal(t, goodGroups, claims.Groups)
+
+			// The session ID claim identifies the stored tokens of the session.
+			accessTokenSecrets, err := secrets.List(context.Background(), metav1.ListOptions{
+				LabelSelector: labels.Set{crud.SecretLabelKey: accesstoken.TypeLabelValue}.String(),
+			})
+			require.NoError(t, err)
+			require.Len(t, accessTokenSecrets.Items, 1)
+			require.NotEmpty(t, claims.SessionID)
+			require.Equal(t, accessTokenSecrets.Items[0].Labels[fositestorage.StorageRequestIDLabelName], claims.SessionID)
+
+			wantRefreshTokens := 0
+			if contains(test.wantSuccessBodyFields, "refresh_token") {
+				wantRefreshTokens = 1
+			}
+			testutil.RequireNumberOfSecretsMatchingLabelSelector(t, secrets, labels.Set{crud.SecretLabelKey: accesstoken.TypeLabelValue}, 1)
+			testutil.RequireNumberOfSecretsMatchingLabelSelector(t, secrets, labels.Set{crud.SecretLabelKey: refreshtoken.TypeLabelValue}, wantRefreshTokens)
+		})
+	}
+}
+
 func TestTokenExchange(t *testing.T) {
 	successfulAuthCodeExchange := tokenEndpointResponseExpectedValues{
 		wantStatus:            http.StatusOK,
@@ -740,7 +1091,7 @@ func TestTokenExchange(t *testing.T) {
 		t.Run(test.name, func(t *testing.T) {
 			t.Parallel()
 
-			subject, rsp, _, _, secrets, storage := exchangeAuthcodeForTokens(t, test.authcodeExchange)
+			subject, rsp, _, _, secrets, storage := exchangeAuthcodeForTokens(t, test.authcodeExchange, oidctestutil.NewUpstreamIDPListerBuilder().Build())
 			var parsedAuthcodeExchangeResponseBody map[string]interface{}
 			require.NoError(t, json.Unmarshal(rsp.Body.Bytes(), &parsedAuthcodeExchangeResponseBody))
 
@@ -813,7 +1164,7 @@ func TestTokenExchange(t *testing.T) {
 			require.Equal(t, goodSubject, tokenClaims["sub"])
 			require.Equal(t, goodIssuer, tokenClaims["iss"])
 			require.Equal(t, goodUsername, tokenClaims["username"])
-			require.Equal(t, goodGroups, tokenClaims["groups"])
+			require.Equal(t, toSliceOfInterface(goodGroups), tokenClaims["groups"])
 
 			// Also assert that some are the same as the original downstream ID token.
 			requireClaimsAreEqual(t, "iss", claimsOfFirstIDToken, tokenClaims)       // issuer
@@ -837,14 +1188,166 @@ func TestTokenExchange(t *testing.T) {
 	}
 }
 
+type expectedUpstreamRefresh struct {
+	performedByUpstreamName string
+	args                    *oidctestutil.PerformRefreshArgs
+}
+
+type expectedUpstreamValidateTokens struct {
+	performedByUpstreamName string
+	args                    *oidctestutil.ValidateTokenArgs
+}
+
 type refreshRequestInputs struct {
 	modifyTokenRequest func(tokenRequest *http.Request, refreshToken string, accessToken string)
 	want               tokenEndpointResponseExpectedValues
+	// When nil, the test expects that no upstream refresh was performed.
+	wantUpstreamRefreshCall *expectedUpstreamRefresh
+	// When nil, the test expects that no upstream ID token was validated.
+	wantUpstreamOIDCValidateTokenCall *expectedUpstreamValidateTokens
 }
 
 func TestRefreshGrant(t *testing.T) {
+	const (
+		oidcUpstreamRotatedRefreshToken = "fake-rotated-upstream-refresh-token"
+		ldapUpstreamName                = "some-ldap-idp"
+		ldapUpstreamType                = "ldap"
+		ldapUpstreamDN                  = "cn=some-ldap-user,ou=users,dc=example,dc=com"
+		activeDirectoryUpstreamName     = "some-ad-idp"
+		activeDirectoryUpstreamType     = "activedirectory"
+	)
+
+	// The downstream subject of an LDAP user is the URL of the upstream with the user's UID appended as a query
+	// param, so choose values which will result in goodSubject.
+	ldapUpstreamURL, err := url.Parse("https://issuer")
+	require.NoError(t, err)
+	ldapUpstreamUID := "some-subject"
+
+	upstreamOIDCIdentityProvider := func() *oidctestutil.TestUpstreamOIDCIdentityProvider {
+		return &oidctestutil.TestUpstreamOIDCIdentityProvider{
+			Name:          oidcUpstreamName,
+			UsernameClaim: "username-claim",
+			GroupsClaim:   "groups-claim",
+			PerformRefreshFunc: func(ctx context.Context, refreshToken string) (*xoauth2.Token, error) {
+				// Many upstreams do not return a new refresh token or a new ID token during a refresh.
+				return &xoauth2.Token{AccessToken: "fake-upstream-access-token"}, nil
+			},
+		}
+	}
+
+	upstreamOIDCTokensWithIDToken := (&xoauth2.Token{
+		AccessToken:  "fake-upstream-access-token",
+		RefreshToken: oidcUpstreamRotatedRefreshToken,
+	}).WithExtra(map[string]interface{}{"id_token": "fake-upstream-id-token"})
+
+	upstreamOIDCIdentityProviderWithIDToken := func(claims map[string]interface{}) *oidctestutil.TestUpstreamOIDCIdentityProvider {
+		p := upstreamOIDCIdentityProvider()
+		p.PerformRefreshFunc = func(ctx context.Context, refreshToken string) (*xoauth2.Token, error) {
+			return upstreamOIDCTokensWithIDToken, nil
+		}
+		p.ValidateTokenFunc = func(ctx context.Context, tok *xoauth2.Token, expectedIDTokenNonce nonce.Nonce) (*oidctypes.Token, error) {
+			return &oidctypes.Token{IDToken: &oidctypes.IDToken{Token: "fake-upstream-id-token", Claims: claims}}, nil
+		}
+		return p
+	}
+
+	happyOIDCUpstreamRefreshCall := &expectedUpstreamRefresh{
+		performedByUpstreamName: oidcUpstreamName,
+		args: &oidctestutil.PerformRefreshArgs{
+			Ctx:          context.Background(),
+			RefreshToken: oidcUpstreamInitialRefreshToken,
+		},
+	}
+
+	happyOIDCUpstreamValidateTokenCall := &expectedUpstreamValidateTokens{
+		performedByUpstreamName: oidcUpstreamName,
+		args: &oidctestutil.ValidateTokenArgs{
+			Ctx:                  context.Background(),
+			Tok:                  upstreamOIDCTokensWithIDToken,
+			ExpectedIDTokenNonce: "", // a refreshed ID token is not expected to have a nonce
+		},
+	}
+
+	upstreamLDAPIdentityProvider := func(name string, performRefreshFunc func(ctx context.Context, userDN string) (*authenticators.Response, bool, error)) *oidctestutil.TestUpstreamLDAPIdentityProvider {
+		return &oidctestutil.TestUpstreamLDAPIdentityProvider{
+			Name:               name,
+			URL:                ldapUpstreamURL,
+			PerformRefreshFunc: performRefreshFunc,
+		}
+	}
+
+	happyLDAPPerformRefreshFunc := func(groups []string) func(ctx context.Context, userDN string) (*authenticators.Response, bool, error) {
+		return func(ctx context.Context, userDN string) (*authenticators.Response, bool, error) {
+			return &authenticators.Response{
+				User: &user.DefaultInfo{Name: goodUsername, UID: ldapUpstreamUID, Groups: groups},
+				DN:   userDN,
+			}, true, nil
+		}
+	}
+
+	ldapCustomSessionData := &psession.CustomSessionData{
+		ProviderName: ldapUpstreamName,
+		ProviderType: ldapUpstreamType,
+		LDAP:         &psession.LDAPSessionData{UserDN: ldapUpstreamDN},
+	}
+
+	activeDirectoryCustomSessionData := &psession.CustomSessionData{
+		ProviderName: activeDirectoryUpstreamName,
+		ProviderType: activeDirectoryUpstreamType,
+		LDAP:         &psession.LDAPSessionData{UserDN: ldapUpstreamDN},
+	}
+
+	happyLDAPUpstreamRefreshCall := func(upstreamName string) *expectedUpstreamRefresh {
+		return &expectedUpstreamRefresh{
+			performedByUpstreamName: upstreamName,
+			args: &oidctestutil.PerformRefreshArgs{
+				Ctx: context.Background(),
+				DN:  ldapUpstreamDN,
+			},
+		}
+	}
+
+	happyAuthcodeExchangeInputs := func(customSessionData *psession.CustomSessionData) authcodeExchangeInputs {
+		return authcodeExchangeInputs{
+			customSessionData: customSessionData,
+			modifyAuthRequest: func(r *http.Request) { r.Form.Set("scope", "openid offline_access") },
+			want: tokenEndpointResponseExpectedValues{
+				wantStatus:            http.StatusOK,
+				wantSuccessBodyFields: []string{"id_token", "refresh_token", "access_token", "token_type", "expires_in", "scope"},
+				wantRequestedScopes:   []string{"openid", "offline_access"},
+				wantGrantedScopes:     []string{"openid", "offline_access"},
+			},
+		}
+	}
+
+	happyRefreshResponse := func(wantGroups []string, wantCustomSessionDataStored *psession.CustomSessionData) tokenEndpointResponseExpectedValues {
+		return tokenEndpointResponseExpectedValues{
+			wantStatus:                  http.StatusOK,
+			wantSuccessBodyFields:       []string{"id_token", "refresh_token", "access_token", "token_type", "expires_in", "scope"},
+			wantRequestedScopes:         []string{"openid", "offline_access"},
+			wantGrantedScopes:           []string{"openid", "offline_access"},
+			wantGroups:                  wantGroups,
+			wantCustomSessionDataStored: wantCustomSessionDataStored,
+		}
+	}
+
+	upstreamRefreshErrorResponse := func(hint string) tokenEndpointResponseExpectedValues {
+		return tokenEndpointResponseExpectedValues{
+			wantStatus: http.StatusBadRequest,
+			wantErrorResponseBody: fmt.Sprintf(here.Doc(`
+				{
+					"error":             "invalid_grant",
+					"error_description": "The provided authorization grant (e.g., authorization code, resource owner credentials) or refresh token is invalid, expired, revoked, does not match the redirection URI used in the authorization request, or was issued to another client. %s"
+				}
+			`), hint),
+		}
+	}
+
 	tests := []struct {
-		name             string
+		name string
+		// When nil, the upstream IDPs will contain only the OIDC upstream which was used to log in, and its refresh
+		// will succeed without returning a new ID token.
+		idps             *oidctestutil.UpstreamIDPListerBuilder
 		authcodeExchange authcodeExchangeInputs
 		refreshRequest   refreshRequestInputs
 	}{
@@ -865,7 +1368,9 @@ func TestRefreshGrant(t *testing.T) {
 					wantSuccessBodyFields: []string{"id_token", "refresh_token", "access_token", "token_type", "expires_in", "scope"},
 					wantRequestedScopes:   []string{"openid", "offline_access"},
 					wantGrantedScopes:     []string{"openid", "offline_access"},
-				}},
+				},
+				wantUpstreamRefreshCall: happyOIDCUpstreamRefreshCall,
+			},
 		},
 		{
 			name: "happy path refresh grant without ID token",
@@ -884,7 +1389,9 @@ func TestRefreshGrant(t *testing.T) {
 					wantSuccessBodyFields: []string{"refresh_token", "access_token", "token_type", "expires_in", "scope"},
 					wantRequestedScopes:   []string{"offline_access"},
 					wantGrantedScopes:     []string{"offline_access"},
-				}},
+				},
+				wantUpstreamRefreshCall: happyOIDCUpstreamRefreshCall,
+			},
 		},
 		{
 			name: "when the refresh request adds a new scope to the list of requested scopes then it is ignored",
@@ -906,7 +1413,9 @@ func TestRefreshGrant(t *testing.T) {
 					wantSuccessBodyFields: []string{"id_token", "refresh_token", "access_token", "token_type", "expires_in", "scope"},
 					wantRequestedScopes:   []string{"openid", "offline_access"},
 					wantGrantedScopes:     []string{"openid", "offline_access"},
-				}},
+				},
+				wantUpstreamRefreshCall: happyOIDCUpstreamRefreshCall,
+			},
 		},
 		{
 			name: "when the refresh request removes a scope which was originally granted from the list of requested scopes then it is granted anyway",
@@ -928,7 +1437,9 @@ func TestRefreshGrant(t *testing.T) {
 					wantSuccessBodyFields: []string{"id_token", "refresh_token", "access_token", "token_type", "expires_in", "scope"},
 					wantRequestedScopes:   []string{"openid", "offline_access", "pinniped:request-audience"},
 					wantGrantedScopes:     []string{"openid", "offline_access", "pinniped:request-audience"},
-				}},
+				},
+				wantUpstreamRefreshCall: happyOIDCUpstreamRefreshCall,
+			},
 		},
 		{
 			name: "when the refresh request does not include a scope param then it gets all the same scopes as the original authorization request",
@@ -950,7 +1461,9 @@ func TestRefreshGrant(t *testing.T) {
 					wantSuccessBodyFields: []string{"id_token", "refresh_token", "access_token", "token_type", "expires_in", "scope"},
 					wantRequestedScopes:   []string{"openid", "offline_access"},
 					wantGrantedScopes:     []string{"openid", "offline_access"},
-				}},
+				},
+				wantUpstreamRefreshCall: happyOIDCUpstreamRefreshCall,
+			},
 		},
 		{
 			name: "when a bad refresh token is sent in the refresh request",
@@ -1012,14 +1525,349 @@ func TestRefreshGrant(t *testing.T) {
 					wantErrorResponseBody: fositeInvalidClientErrorBody,
 				}},
 		},
+		{
+			name: "happy path refresh grant when the upstream OIDC refresh returns a new ID token with new groups",
+			idps: oidctestutil.NewUpstreamIDPListerBuilder().WithOIDC(upstreamOIDCIdentityProviderWithIDToken(map[string]interface{}{
+				"iss":            "https://issuer",
+				"sub":            "some-subject",
+				"username-claim": goodUsername,
+				"groups-claim":   []interface{}{"new-group1", "new-group2"},
+			})),
+			authcodeExchange: happyAuthcodeExchangeInputs(nil),
+			refreshRequest: refreshRequestInputs{
+				want: happyRefreshResponse([]string{"new-group1", "new-group2"}, &psession.CustomSessionData{
+					ProviderName: oidcUpstreamName,
+					ProviderType: oidcUpstreamType,
+					OIDC:         &psession.OIDCSessionData{UpstreamRefreshToken: oidcUpstreamRotatedRefreshToken},
+				}),
+				wantUpstreamRefreshCall:           happyOIDCUpstreamRefreshCall,
+				wantUpstreamOIDCValidateTokenCall: happyOIDCUpstreamValidateTokenCall,
+			},
+		},
+		{
+			name: "happy path refresh grant when the upstream OIDC refresh returns a new ID token for an upstream whose session is ended on logout",
+			idps: oidctestutil.NewUpstreamIDPListerBuilder().WithOIDC(func() *oidctestutil.TestUpstreamOIDCIdentityProvider {
+				p := upstreamOIDCIdentityProviderWithIDToken(map[string]interface{}{
+					"iss":            "https://issuer",
+					"sub":            "some-subject",
+					"username-claim": goodUsername,
+					"groups-claim":   []interface{}{"new-group1", "new-group2"},
+				})
+				p.EndSessionURL = &url.URL{Scheme: "https", Host: "some-upstream.com", Path: "/logout"}
+				return p
+			}()),
+			authcodeExchange: happyAuthcodeExchangeInputs(nil),
+			refreshRequest: refreshRequestInputs{
+				want: happyRefreshResponse([]string{"new-group1", "new-group2"}, &psession.CustomSessionData{
+					ProviderName: oidcUpstreamName,
+					ProviderType: oidcUpstreamType,
+					OIDC: &psession.OIDCSessionData{
+						UpstreamRefreshToken: oidcUpstreamRotatedRefreshToken,
+						UpstreamIDToken:      "fake-upstream-id-token",
+					},
+				}),
+				wantUpstreamRefreshCall:           happyOIDCUpstreamRefreshCall,
+				wantUpstreamOIDCValidateTokenCall: happyOIDCUpstreamValidateTokenCall,
+			},
+		},
+		{
+			name: "happy path refresh grant when the upstream OIDC refresh returns a new ID token without a groups claim",
+			idps: oidctestutil.NewUpstreamIDPListerBuilder().WithOIDC(upstreamOIDCIdentityProviderWithIDToken(map[string]interface{}{
+				"iss":            "https://issuer",
+				"sub":            "some-subject",
+				"username-claim": goodUsername,
+			})),
+			authcodeExchange: happyAuthcodeExchangeInputs(nil),
+			refreshRequest: refreshRequestInputs{
+				want: happyRefreshResponse([]string{}, &psession.CustomSessionData{
+					ProviderName: oidcUpstreamName,
+					ProviderType: oidcUpstreamType,
+					OIDC:         &psession.OIDCSessionData{UpstreamRefreshToken: oidcUpstreamRotatedRefreshToken},
+				}),
+				wantUpstreamRefreshCall:           happyOIDCUpstreamRefreshCall,
+				wantUpstreamOIDCValidateTokenCall: happyOIDCUpstreamValidateTokenCall,
+			},
+		},
+		{
+			name: "when the upstream OIDC refresh returns a new ID token for a different user",
+			idps: oidctestutil.NewUpstreamIDPListerBuilder().WithOIDC(upstreamOIDCIdentityProviderWithIDToken(map[string]interface{}{
+				"iss":            "https://issuer",
+				"sub":            "some-other-subject",
+				"username-claim": goodUsername,
+			})),
+			authcodeExchange: happyAuthcodeExchangeInputs(nil),
+			refreshRequest: refreshRequestInputs{
+				want:                              upstreamRefreshErrorResponse(`Upstream refresh found a different identity using provider 'some-oidc-idp' of type 'oidc'.`),
+				wantUpstreamRefreshCall:           happyOIDCUpstreamRefreshCall,
+				wantUpstreamOIDCValidateTokenCall: happyOIDCUpstreamValidateTokenCall,
+			},
+		},
+		{
+			name: "when the upstream OIDC refresh returns a new ID token with a different username",
+			idps: oidctestutil.NewUpstreamIDPListerBuilder().WithOIDC(upstreamOIDCIdentityProviderWithIDToken(map[string]interface{}{
+				"iss":            "https://issuer",
+				"sub":            "some-subject",
+				"username-claim": "some-other-username",
+			})),
+			authcodeExchange: happyAuthcodeExchangeInputs(nil),
+			refreshRequest: refreshRequestInputs{
+				want:                              upstreamRefreshErrorResponse(`Upstream refresh found a different identity using provider 'some-oidc-idp' of type 'oidc'.`),
+				wantUpstreamRefreshCall:           happyOIDCUpstreamRefreshCall,
+				wantUpstreamOIDCValidateTokenCall: happyOIDCUpstreamValidateTokenCall,
+			},
+		},
+		{
+			name: "when the upstream OIDC refresh returns a new ID token which is missing the username claim",
+			idps: oidctestutil.NewUpstreamIDPListerBuilder().WithOIDC(upstreamOIDCIdentityProviderWithIDToken(map[string]interface{}{
+				"iss": "https://issuer",
+				"sub": "some-subject",
+			})),
+			authcodeExchange: happyAuthcodeExchangeInputs(nil),
+			refreshRequest: refreshRequestInputs{
+				want:                              upstreamRefreshErrorResponse(`Upstream refresh returned an invalid ID token using provider 'some-oidc-idp' of type 'oidc'.`),
+				wantUpstreamRefreshCall:           happyOIDCUpstreamRefreshCall,
+				wantUpstreamOIDCValidateTokenCall: happyOIDCUpstreamValidateTokenCall,
+			},
+		},
+		{
+			name: "when the upstream OIDC refresh returns a new ID token which fails validation",
+			idps: oidctestutil.NewUpstreamIDPListerBuilder().WithOIDC(func() *oidctestutil.TestUpstreamOIDCIdentityProvider {
+				p := upstreamOIDCIdentityProviderWithIDToken(nil)
+				p.ValidateTokenFunc = func(ctx context.Context, tok *xoauth2.Token, expectedIDTokenNonce nonce.Nonce) (*oidctypes.Token, error) {
+					return nil, errors.New("some validation error")
+				}
+				return p
+			}()),
+			authcodeExchange: happyAuthcodeExchangeInputs(nil),
+			refreshRequest: refreshRequestInputs{
+				want:                              upstreamRefreshErrorResponse(`Upstream refresh returned an invalid ID token using provider 'some-oidc-idp' of type 'oidc'.`),
+				wantUpstreamRefreshCall:           happyOIDCUpstreamRefreshCall,
+				wantUpstreamOIDCValidateTokenCall: happyOIDCUpstreamValidateTokenCall,
+			},
+		},
+		{
+			name: "when the upstream OIDC refresh fails",
+			idps: oidctestutil.NewUpstreamIDPListerBuilder().WithOIDC(func() *oidctestutil.TestUpstreamOIDCIdentityProvider {
+				p := upstreamOIDCIdentityProvider()
+				p.PerformRefreshFunc = func(ctx context.Context, refreshToken string) (*xoauth2.Token, error) {
+					return nil, errors.New("some upstream refresh error")
+				}
+				return p
+			}()),
+			authcodeExchange: happyAuthcodeExchangeInputs(nil),
+			refreshRequest: refreshRequestInputs{
+				want:                    upstreamRefreshErrorResponse(`Upstream refresh failed using provider 'some-oidc-idp' of type 'oidc'.`),
+				wantUpstreamRefreshCall: happyOIDCUpstreamRefreshCall,
+			},
+		},
+		{
+			name: "when the downstream session does not contain an upstream OIDC refresh token",
+			authcodeExchange: happyAuthcodeExchangeInputs(&psession.CustomSessionData{
+				ProviderName: oidcUpstreamName,
+				ProviderType: oidcUpstreamType,
+				OIDC:         &psession.OIDCSessionData{UpstreamRefreshToken: ""},
+			}),
+			refreshRequest: refreshRequestInputs{
+				want: upstreamRefreshErrorResponse("Upstream refresh token not found in session, so the user must log in again."),
+			},
+		},
+		{
+			name: "when the upstream OIDC provider which was used to log in no longer exists",
+			idps: oidctestutil.NewUpstreamIDPListerBuilder().WithOIDC(func() *oidctestutil.TestUpstreamOIDCIdentityProvider {
+				p := upstreamOIDCIdentityProvider()
+				p.Name = "some-other-oidc-idp"
+				return p
+			}()),
+			authcodeExchange: happyAuthcodeExchangeInputs(nil),
+			refreshRequest: refreshRequestInputs{
+				want: upstreamRefreshErrorResponse(`Provider 'some-oidc-idp' of type 'oidc' from upstream session data was not found.`),
+			},
+		},
+		{
+			name: "when the downstream session does not say which upstream was used to log in",
+			authcodeExchange: happyAuthcodeExchangeInputs(&psession.CustomSessionData{
+				OIDC: &psession.OIDCSessionData{UpstreamRefreshToken: oidcUpstreamInitialRefreshToken},
+			}),
+			refreshRequest: refreshRequestInputs{
+				want: tokenEndpointResponseExpectedValues{
+					wantStatus: http.StatusInternalServerError,
+					wantErrorResponseBody: here.Doc(`
+						{
+							"error":             "server_error",
+							"error_description": "The authorization server encountered an unexpected condition that prevented it from fulfilling the request. Required upstream data not found in session."
+						}
+					`),
+				},
+			},
+		},
+		{
+			name: "happy path refresh grant when the upstream LDAP user still exists with new groups",
+			idps: oidctestutil.NewUpstreamIDPListerBuilder().WithLDAP(
+				upstreamLDAPIdentityProvider(ldapUpstreamName, happyLDAPPerformRefreshFunc([]string{"new-group1", "new-group2"})),
+			),
+			authcodeExchange: happyAuthcodeExchangeInputs(ldapCustomSessionData),
+			refreshRequest: refreshRequestInputs{
+				want:                    happyRefreshResponse([]string{"new-group1", "new-group2"}, nil),
+				wantUpstreamRefreshCall: happyLDAPUpstreamRefreshCall(ldapUpstreamName),
+			},
+		},
+		{
+			name: "happy path refresh grant when the upstream LDAP user still exists and the identity transforms are applied to the new groups",
+			idps: oidctestutil.NewUpstreamIDPListerBuilder().WithLDAP(
+				upstreamLDAPIdentityProvider(ldapUpstreamName, happyLDAPPerformRefreshFunc([]string{"new-group1", "k8s-users"})),
+			),
+			authcodeExchange: func() authcodeExchangeInputs {
+				inputs := happyAuthcodeExchangeInputs(ldapCustomSessionData)
+				inputs.identityTransforms = oidctestutil.NewIdentityTransforms(t, ldapUpstreamName,
+					configv1alpha1.FederationDomainTransform{Type: configv1alpha1.GroupsPrefixFederationDomainTransformType, Prefix: "ldap:"},
+					configv1alpha1.FederationDomainTransform{Type: configv1alpha1.RequireGroupFederationDomainTransformType, Groups: []string{"ldap:k8s-users"}},
+				)
+				return inputs
+			}(),
+			refreshRequest: refreshRequestInputs{
+				want:                    happyRefreshResponse([]string{"ldap:new-group1", "ldap:k8s-users"}, nil),
+				wantUpstreamRefreshCall: happyLDAPUpstreamRefreshCall(ldapUpstreamName),
+			},
+		},
+		{
+			name: "when the upstream LDAP user is no longer allowed to log in by the identity transforms",
+			idps: oidctestutil.NewUpstreamIDPListerBuilder().WithLDAP(
+				upstreamLDAPIdentityProvider(ldapUpstreamName, happyLDAPPerformRefreshFunc([]string{"new-group1"})),
+			),
+			authcodeExchange: func() authcodeExchangeInputs {
+				inputs := happyAuthcodeExchangeInputs(ldapCustomSessionData)
+				inputs.identityTransforms = oidctestutil.NewIdentityTransforms(t, ldapUpstreamName,
+					configv1alpha1.FederationDomainTransform{Type: configv1alpha1.RequireGroupFederationDomainTransformType, Groups: []string{"k8s-users"}},
+				)
+				return inputs
+			}(),
+			refreshRequest: refreshRequestInputs{
+				want:                    upstreamRefreshErrorResponse(`Upstream refresh was rejected using provider 'some-ldap-idp' of type 'ldap': user is not a member of any of the required groups.`),
+				wantUpstreamRefreshCall: happyLDAPUpstreamRefreshCall(ldapUpstreamName),
+			},
+		},
+		{
+			name: "when the upstream LDAP user is no longer allowed to log in by the login policy, which uses the attributes of their entry",
+			idps: oidctestutil.NewUpstreamIDPListerBuilder().WithLDAP(
+				upstreamLDAPIdentityProvider(ldapUpstreamName, func(ctx context.Context, userDN string) (*authenticators.Response, bool, error) {
+					return &authenticators.Response{
+						User:       &user.DefaultInfo{Name: goodUsername, UID: ldapUpstreamUID},
+						DN:         userDN,
+						Attributes: map[string][]string{"dn": {userDN}, "accountStatus": {"disabled"}},
+					}, true, nil
+				}),
+			),
+			authcodeExchange: func() authcodeExchangeInputs {
+				inputs := happyAuthcodeExchangeInputs(ldapCustomSessionData)
+				inputs.identityTransforms = oidctestutil.NewIdentityPolicy(t, ldapUpstreamName, configv1alpha1.FederationDomainPolicy{
+					DenyRules: []configv1alpha1.FederationDomainDenyRule{
+						{
+							Match: configv1alpha1.FederationDomainPolicyMatch{
+								Claims: []configv1alpha1.FederationDomainClaimMatch{{Name: "accountStatus", Values: []string{"disabled"}}},
+							},
+							Message: "the account is disabled",
+						},
+					},
+				})
+				return inputs
+			}(),
+			refreshRequest: refreshRequestInputs{
+				want:                    upstreamRefreshErrorResponse(`Upstream refresh was rejected using provider 'some-ldap-idp' of type 'ldap': the account is disabled.`),
+				wantUpstreamRefreshCall: happyLDAPUpstreamRefreshCall(ldapUpstreamName),
+			},
+		},
+		{
+			name: "happy path refresh grant when the upstream Active Directory user still exists with no groups",
+			idps: oidctestutil.NewUpstreamIDPListerBuilder().WithActiveDirectory(
+				upstreamLDAPIdentityProvider(activeDirectoryUpstreamName, happyLDAPPerformRefreshFunc(nil)),
+			),
+			authcodeExchange: happyAuthcodeExchangeInputs(activeDirectoryCustomSessionData),
+			refreshRequest: refreshRequestInputs{
+				want:                    happyRefreshResponse([]string{}, nil),
+				wantUpstreamRefreshCall: happyLDAPUpstreamRefreshCall(activeDirectoryUpstreamName),
+			},
+		},
+		{
+			name: "when the upstream LDAP user is no longer found",
+			idps: oidctestutil.NewUpstreamIDPListerBuilder().WithLDAP(
+				upstreamLDAPIdentityProvider(ldapUpstreamName, func(ctx context.Context, userDN string) (*authenticators.Response, bool, error) {
+					return nil, false, nil
+				}),
+			),
+			authcodeExchange: happyAuthcodeExchangeInputs(ldapCustomSessionData),
+			refreshRequest: refreshRequestInputs{
+				want:                    upstreamRefreshErrorResponse(`Upstream refresh failed using provider 'some-ldap-idp' of type 'ldap' because the user was not found or is not allowed to log in.`),
+				wantUpstreamRefreshCall: happyLDAPUpstreamRefreshCall(ldapUpstreamName),
+			},
+		},
+		{
+			name: "when the upstream LDAP refresh fails",
+			idps: oidctestutil.NewUpstreamIDPListerBuilder().WithLDAP(
+				upstreamLDAPIdentityProvider(ldapUpstreamName, func(ctx context.Context, userDN string) (*authenticators.Response, bool, error) {
+					return nil, false, errors.New("some ldap error")
+				}),
+			),
+			authcodeExchange: happyAuthcodeExchangeInputs(ldapCustomSessionData),
+			refreshRequest: refreshRequestInputs{
+				want:                    upstreamRefreshErrorResponse(`Upstream refresh failed using provider 'some-ldap-idp' of type 'ldap'.`),
+				wantUpstreamRefreshCall: happyLDAPUpstreamRefreshCall(ldapUpstreamName),
+			},
+		},
+		{
+			name: "when the upstream LDAP user now has a different username",
+			idps: oidctestutil.NewUpstreamIDPListerBuilder().WithLDAP(
+				upstreamLDAPIdentityProvider(ldapUpstreamName, func(ctx context.Context, userDN string) (*authenticators.Response, bool, error) {
+					return &authenticators.Response{
+						User: &user.DefaultInfo{Name: "some-other-username", UID: ldapUpstreamUID},
+						DN:   userDN,
+					}, true, nil
+				}),
+			),
+			authcodeExchange: happyAuthcodeExchangeInputs(ldapCustomSessionData),
+			refreshRequest: refreshRequestInputs{
+				want:                    upstreamRefreshErrorResponse(`Upstream refresh found a different identity using provider 'some-ldap-idp' of type 'ldap'.`),
+				wantUpstreamRefreshCall: happyLDAPUpstreamRefreshCall(ldapUpstreamName),
+			},
+		},
+		{
+			name: "when the upstream LDAP user now has a different UID",
+			idps: oidctestutil.NewUpstreamIDPListerBuilder().WithLDAP(
+				upstreamLDAPIdentityProvider(ldapUpstreamName, func(ctx context.Context, userDN string) (*authenticators.Response, bool, error) {
+					return &authenticators.Response{
+						User: &user.DefaultInfo{Name: goodUsername, UID: "some-other-uid"},
+						DN:   userDN,
+					}, true, nil
+				}),
+			),
+			authcodeExchange: happyAuthcodeExchangeInputs(ldapCustomSessionData),
+			refreshRequest: refreshRequestInputs{
+				want:                    upstreamRefreshErrorResponse(`Upstream refresh found a different identity using provider 'some-ldap-idp' of type 'ldap'.`),
+				wantUpstreamRefreshCall: happyLDAPUpstreamRefreshCall(ldapUpstreamName),
+			},
+		},
+		{
+			name: "when the upstream LDAP provider which was used to log in is now configured as a different type",
+			idps: oidctestutil.NewUpstreamIDPListerBuilder().WithActiveDirectory(
+				upstreamLDAPIdentityProvider(ldapUpstreamName, happyLDAPPerformRefreshFunc(goodGroups)),
+			),
+			authcodeExchange: happyAuthcodeExchangeInputs(ldapCustomSessionData),
+			refreshRequest: refreshRequestInputs{
+				want: upstreamRefreshErrorResponse(`Provider 'some-ldap-idp' of type 'ldap' from upstream session data was not found.`),
+			},
+		},
 	}
 	for _, test := range tests {
 		test := test
 		t.Run(test.name, func(t *testing.T) {
 			t.Parallel()
 
+			idps := test.idps
+			if idps == nil {
+				idps = oidctestutil.NewUpstreamIDPListerBuilder().WithOIDC(upstreamOIDCIdentityProvider())
+			}
+
 			// First exchange the authcode for tokens, including a refresh token.
-			subject, rsp, authCode, jwtSigningKey, secrets, oauthStore := exchangeAuthcodeForTokens(t, test.authcodeExchange)
+			subject, rsp, authCode, jwtSigningKey, secrets, oauthStore := exchangeAuthcodeForTokens(t, test.authcodeExchange, idps.Build())
 			var parsedAuthcodeExchangeResponseBody map[string]interface{}
 			require.NoError(t, json.Unmarshal(rsp.Body.Bytes(), &parsedAuthcodeExchangeResponseBody))
 
@@ -1047,7 +1895,29 @@ func TestRefreshGrant(t *testing.T) {
 			wantAtHashClaimInIDToken := true
 			// Refreshed ID tokens do not include the nonce from the original auth request
 			wantNonceValueInIDToken := false
-			requireTokenEndpointBehavior(t, test.refreshRequest.want, wantAtHashClaimInIDToken, wantNonceValueInIDToken, refreshResponse, authCode, oauthStore, jwtSigningKey, secrets)
+			initialCustomSessionData := test.authcodeExchange.customSessionData
+			if initialCustomSessionData == nil {
+				initialCustomSessionData = initialUpstreamOIDCCustomSessionData()
+			}
+			requireTokenEndpointBehavior(t, test.refreshRequest.want, initialCustomSessionData, wantAtHashClaimInIDToken, wantNonceValueInIDToken, refreshResponse, authCode, oauthStore, jwtSigningKey, secrets)
+
+			if test.refreshRequest.wantUpstreamRefreshCall != nil {
+				idps.RequireExactlyOneCallToPerformRefresh(t,
+					test.refreshRequest.wantUpstreamRefreshCall.performedByUpstreamName,
+					test.refreshRequest.wantUpstreamRefreshCall.args,
+				)
+			} else {
+				idps.RequireExactlyZeroCallsToPerformRefresh(t)
+			}
+
+			if test.refreshRequest.wantUpstreamOIDCValidateTokenCall != nil {
+				idps.RequireExactlyOneCallToValidateToken(t,
+					test.refreshRequest.wantUpstreamOIDCValidateTokenCall.performedByUpstreamName,
+					test.refreshRequest.wantUpstreamOIDCValidateTokenCall.args,
+				)
+			} else {
+				idps.RequireExactlyZeroCallsToValidateToken(t)
+			}
 
 			if test.refreshRequest.want.wantStatus == http.StatusOK {
 				wantIDToken := contains(test.refreshRequest.want.wantSuccessBodyFields, "id_token")
@@ -1107,7 +1977,21 @@ func requireClaimsAreEqual(t *testing.T, claimName string, claimsOfTokenA map[st
 	require.Equal(t, claimsOfTokenA[claimName], claimsOfTokenB[claimName])
 }
 
-func exchangeAuthcodeForTokens(t *testing.T, test authcodeExchangeInputs) (
+const (
+	oidcUpstreamName                = "some-oidc-idp"
+	oidcUpstreamType                = "oidc"
+	oidcUpstreamInitialRefreshToken = "initial-upstream-refresh-token"
+)
+
+func initialUpstreamOIDCCustomSessionData() *psession.CustomSessionData {
+	return &psession.CustomSessionData{
+		ProviderName: oidcUpstreamName,
+		ProviderType: oidcUpstreamType,
+		OIDC:         &psession.OIDCSessionData{UpstreamRefreshToken: oidcUpstreamInitialRefreshToken},
+	}
+}
+
+func exchangeAuthcodeForTokens(t *testing.T, test authcodeExchangeInputs, idps provider.DynamicUpstreamIDPProvider) (
 	subject http.Handler,
 	rsp *httptest.ResponseRecorder,
 	authCode string,
@@ -1123,19 +2007,24 @@ func exchangeAuthcodeForTokens(t *testing.T, test authcodeExchangeInputs) (
 	client := fake.NewSimpleClientset()
 	secrets = client.CoreV1().Secrets("some-namespace")
 
+	initialCustomSessionData := test.customSessionData
+	if initialCustomSessionData == nil {
+		initialCustomSessionData = initialUpstreamOIDCCustomSessionData()
+	}
+
 	var oauthHelper fosite.OAuth2Provider
 
-	oauthStore = oidc.NewKubeStorage(secrets, oidc.DefaultOIDCTimeoutsConfiguration())
+	oauthStore = oidc.NewKubeStorage(crud.NewSecretsBackend(secrets), &clientregistry.StaticClientManager{}, oidc.DefaultOIDCTimeoutsConfiguration())
 	if test.makeOathHelper != nil {
-		oauthHelper, authCode, jwtSigningKey = test.makeOathHelper(t, authRequest, oauthStore)
+		oauthHelper, authCode, jwtSigningKey = test.makeOathHelper(t, authRequest, oauthStore, initialCustomSessionData)
 	} else {
-		oauthHelper, authCode, jwtSigningKey = makeHappyOauthHelper(t, authRequest, oauthStore)
+		oauthHelper, authCode, jwtSigningKey = makeHappyOauthHelper(t, authRequest, oauthStore, initialCustomSessionData)
 	}
 
 	if test.modifyStorage != nil {
 		test.modifyStorage(t, oauthStore, authCode)
 	}
-	subject = NewHandler(oauthHelper)
+	subject = NewHandler(idps, test.identityTransforms, oauthHelper)
 
 	authorizeEndpointGrantedOpenIDScope := strings.Contains(authRequest.Form.Get("scope"), "openid")
 	expectedNumberOfIDSessionsStored := 0
@@ -1161,7 +2050,7 @@ func exchangeAuthcodeForTokens(t *testing.T, test authcodeExchangeInputs) (
 
 	wantAtHashClaimInIDToken := false // due to a bug in fosite, the at_hash claim is not filled in during authcode exchange
 	wantNonceValueInIDToken := true   // ID tokens returned by the authcode exchange must include the nonce from the auth request (unliked refreshed ID tokens)
-	requireTokenEndpointBehavior(t, test.want, wantAtHashClaimInIDToken, wantNonceValueInIDToken, rsp, authCode, oauthStore, jwtSigningKey, secrets)
+	requireTokenEndpointBehavior(t, test.want, initialCustomSessionData, wantAtHashClaimInIDToken, wantNonceValueInIDToken, rsp, authCode, oauthStore, jwtSigningKey, secrets)
 
 	return subject, rsp, authCode, jwtSigningKey, secrets, oauthStore
 }
@@ -1169,6 +2058,7 @@ func exchangeAuthcodeForTokens(t *testing.T, test authcodeExchangeInputs) (
 func requireTokenEndpointBehavior(
 	t *testing.T,
 	test tokenEndpointResponseExpectedValues,
+	initialCustomSessionData *psession.CustomSessionData,
 	wantAtHashClaimInIDToken bool,
 	wantNonceValueInIDToken bool,
 	tokenEndpointResponse *httptest.ResponseRecorder,
@@ -1190,10 +2080,20 @@ func requireTokenEndpointBehavior(
 		wantIDToken := contains(test.wantSuccessBodyFields, "id_token")
 		wantRefreshToken := contains(test.wantSuccessBodyFields, "refresh_token")
 
+		wantGroups := test.wantGroups
+		if wantGroups == nil {
+			wantGroups = goodGroups
+		}
+		wantCustomSessionData := test.wantCustomSessionDataStored
+		if wantCustomSessionData == nil {
+			wantCustomSessionData = initialCustomSessionData
+		}
+
 		requireInvalidAuthCodeStorage(t, authCode, oauthStore, secrets)
-		requireValidAccessTokenStorage(t, parsedResponseBody, oauthStore, test.wantRequestedScopes, test.wantGrantedScopes, secrets)
+		requireValidAccessTokenStorage(t, parsedResponseBody, oauthStore, test.wantRequestedScopes, test.wantGrantedScopes, wantGroups, wantCustomSessionData, secrets)
 		requireInvalidPKCEStorage(t, authCode, oauthStore)
-		requireValidOIDCStorage(t, parsedResponseBody, authCode, oauthStore, test.wantRequestedScopes, test.wantGrantedScopes)
+		// The OIDC storage was created by the authorize endpoint, so it always holds the original session data.
+		requireValidOIDCStorage(t, parsedResponseBody, authCode, oauthStore, test.wantRequestedScopes, test.wantGrantedScopes, initialCustomSessionData)
 
 		expectedNumberOfRefreshTokenSessionsStored := 0
 		if wantRefreshToken {
@@ -1202,10 +2102,10 @@ func requireTokenEndpointBehavior(
 		expectedNumberOfIDSessionsStored := 0
 		if wantIDToken {
 			expectedNumberOfIDSessionsStored = 1
-			requireValidIDToken(t, parsedResponseBody, jwtSigningKey, wantAtHashClaimInIDToken, wantNonceValueInIDToken, parsedResponseBody["access_token"].(string))
+			requireValidIDToken(t, parsedResponseBody, jwtSigningKey, wantAtHashClaimInIDToken, wantNonceValueInIDToken, wantGroups, parsedResponseBody["access_token"].(string))
 		}
 		if wantRefreshToken {
-			requireValidRefreshTokenStorage(t, parsedResponseBody, oauthStore, test.wantRequestedScopes, test.wantGrantedScopes, secrets)
+			requireValidRefreshTokenStorage(t, parsedResponseBody, oauthStore, test.wantRequestedScopes, test.wantGrantedScopes, wantGroups, wantCustomSessionData, secrets)
 		}
 
 		testutil.RequireNumberOfSecretsMatchingLabelSelector(t, secrets, labels.Set{crud.SecretLabelKey: authorizationcode.TypeLabelValue}, 1)
@@ -1243,6 +2143,14 @@ func happyAuthcodeRequestBody(happyAuthCode string) body {
 	}
 }
 
+func happyDeviceCodeRequestBody(deviceCode string) body {
+	return map[string][]string{
+		"grant_type":  {"urn:ietf:params:oauth:grant-type:device_code"},
+		"device_code": {deviceCode},
+		"client_id":   {goodClient},
+	}
+}
+
 func happyRefreshRequestBody(refreshToken string) body {
 	return map[string][]string{
 		"grant_type":    {"refresh_token"},
@@ -1306,12 +2214,13 @@ func makeHappyOauthHelper(
 	t *testing.T,
 	authRequest *http.Request,
 	store fositestoragei.AllFositeStorage,
+	initialCustomSessionData *psession.CustomSessionData,
 ) (fosite.OAuth2Provider, string, *ecdsa.PrivateKey) {
 	t.Helper()
 
 	jwtSigningKey, jwkProvider := generateJWTSigningKeyAndJWKSProvider(t, goodIssuer)
 	oauthHelper := oidc.FositeOauth2Helper(store, goodIssuer, hmacSecretFunc, jwkProvider, oidc.DefaultOIDCTimeoutsConfiguration())
-	authResponder := simulateAuthEndpointHavingAlreadyRun(t, authRequest, oauthHelper)
+	authResponder := simulateAuthEndpointHavingAlreadyRun(t, authRequest, oauthHelper, initialCustomSessionData)
 	return oauthHelper, authResponder.GetCode(), jwtSigningKey
 }
 
@@ -1332,12 +2241,13 @@ func makeOauthHelperWithJWTKeyThatWorksOnlyOnce(
 	t *testing.T,
 	authRequest *http.Request,
 	store fositestoragei.AllFositeStorage,
+	initialCustomSessionData *psession.CustomSessionData,
 ) (fosite.OAuth2Provider, string, *ecdsa.PrivateKey) {
 	t.Helper()
 
 	jwtSigningKey, jwkProvider := generateJWTSigningKeyAndJWKSProvider(t, goodIssuer)
 	oauthHelper := oidc.FositeOauth2Helper(store, goodIssuer, hmacSecretFunc, &singleUseJWKProvider{DynamicJWKSProvider: jwkProvider}, oidc.DefaultOIDCTimeoutsConfiguration())
-	authResponder := simulateAuthEndpointHavingAlreadyRun(t, authRequest, oauthHelper)
+	authResponder := simulateAuthEndpointHavingAlreadyRun(t, authRequest, oauthHelper, initialCustomSessionData)
 	return oauthHelper, authResponder.GetCode(), jwtSigningKey
 }
 
@@ -1345,31 +2255,40 @@ func makeOauthHelperWithNilPrivateJWTSigningKey(
 	t *testing.T,
 	authRequest *http.Request,
 	store fositestoragei.AllFositeStorage,
+	initialCustomSessionData *psession.CustomSessionData,
 ) (fosite.OAuth2Provider, string, *ecdsa.PrivateKey) {
 	t.Helper()
 
 	jwkProvider := jwks.NewDynamicJWKSProvider() // empty provider which contains no signing key for this issuer
 	oauthHelper := oidc.FositeOauth2Helper(store, goodIssuer, hmacSecretFunc, jwkProvider, oidc.DefaultOIDCTimeoutsConfiguration())
-	authResponder := simulateAuthEndpointHavingAlreadyRun(t, authRequest, oauthHelper)
+	authResponder := simulateAuthEndpointHavingAlreadyRun(t, authRequest, oauthHelper, initialCustomSessionData)
 	return oauthHelper, authResponder.GetCode(), nil
 }
 
 // Simulate the auth endpoint running so Fosite code will fill the store with realistic values.
-func simulateAuthEndpointHavingAlreadyRun(t *testing.T, authRequest *http.Request, oauthHelper fosite.OAuth2Provider) fosite.AuthorizeResponder {
+func simulateAuthEndpointHavingAlreadyRun(
+	t *testing.T,
+	authRequest *http.Request,
+	oauthHelper fosite.OAuth2Provider,
+	initialCustomSessionData *psession.CustomSessionData,
+) fosite.AuthorizeResponder {
 	// We only set the fields in the session that Fosite wants us to set.
 	ctx := context.Background()
-	session := &openid.DefaultSession{
-		Claims: &jwt.IDTokenClaims{
-			Subject:     goodSubject,
-			RequestedAt: goodRequestedAtTime,
-			AuthTime:    goodAuthTime,
-			Extra: map[string]interface{}{
-				oidc.DownstreamUsernameClaim: goodUsername,
-				oidc.DownstreamGroupsClaim:   goodGroups,
-			},
-		},
-		Subject:  "", // not used, note that callback_handler.go does not set this
-		Username: "", // not used, note that callback_handler.go does not set this
+	session := &psession.PinnipedSession{
+		Fosite: &openid.DefaultSession{
+			Claims: &jwt.IDTokenClaims{
+				Subject:     goodSubject,
+				RequestedAt: goodRequestedAtTime,
+				AuthTime:    goodAuthTime,
+				Extra: map[string]interface{}{
+					oidc.DownstreamUsernameClaim: goodUsername,
+					oidc.DownstreamGroupsClaim:   goodGroups,
+				},
+			},
+			Subject:  "", // not used, note that callback_handler.go does not set this
+			Username: "", // not used, note that callback_handler.go does not set this
+		},
+		Custom: initialCustomSessionData,
 	}
 	authRequester, err := oauthHelper.NewAuthorizeRequest(ctx, authRequest)
 	require.NoError(t, err)
@@ -1425,6 +2344,8 @@ func requireValidRefreshTokenStorage(
 	storage oauth2.CoreStorage,
 	wantRequestedScopes []string,
 	wantGrantedScopes []string,
+	wantGroups []string,
+	wantCustomSessionData *psession.CustomSessionData,
 	secrets v1.SecretInterface,
 ) {
 	t.Helper()
@@ -1446,6 +2367,8 @@ func requireValidRefreshTokenStorage(
 		wantRequestedScopes,
 		wantGrantedScopes,
 		true,
+		wantGroups,
+		wantCustomSessionData,
 	)
 
 	requireGarbageCollectTimeInDelta(t, refreshTokenString, "refresh-token", secrets, time.Now().Add(9*time.Hour).Add(2*time.Minute), 1*time.Minute)
@@ -1457,6 +2380,8 @@ func requireValidAccessTokenStorage(
 	storage oauth2.CoreStorage,
 	wantRequestedScopes []string,
 	wantGrantedScopes []string,
+	wantGroups []string,
+	wantCustomSessionData *psession.CustomSessionData,
 	secrets v1.SecretInterface,
 ) {
 	t.Helper()
@@ -1497,6 +2422,8 @@ func requireValidAccessTokenStorage(
 		wantRequestedScopes,
 		wantGrantedScopes,
 		true,
+		wantGroups,
+		wantCustomSessionData,
 	)
 
 	requireGarbageCollectTimeInDelta(t, accessTokenString, "access-token", secrets, time.Now().Add(9*time.Hour).Add(2*time.Minute), 1*time.Minute)
@@ -1538,6 +2465,7 @@ func requireValidOIDCStorage(
 	storage openid.OpenIDConnectRequestStorage,
 	wantRequestedScopes []string,
 	wantGrantedScopes []string,
+	wantCustomSessionData *psession.CustomSessionData,
 ) {
 	t.Helper()
 
@@ -1560,6 +2488,8 @@ func requireValidOIDCStorage(
 			wantRequestedScopes,
 			wantGrantedScopes,
 			false,
+			goodGroups,
+			wantCustomSessionData,
 		)
 	} else {
 		_, err := storage.GetOpenIDConnectSession(context.Background(), code, nil)
@@ -1574,6 +2504,8 @@ func requireValidStoredRequest(
 	wantRequestedScopes []string,
 	wantGrantedScopes []string,
 	wantAccessTokenExpiresAt bool,
+	wantGroups []string,
+	wantCustomSessionData *psession.CustomSessionData,
 ) {
 	t.Helper()
 
@@ -1588,19 +2520,19 @@ func requireValidStoredRequest(
 	require.Equal(t, wantRequestForm, request.GetRequestForm()) // Fosite stores access token request without form
 
 	// Cast session to the type we think it should be.
-	session, ok := request.GetSession().(*openid.DefaultSession)
-	require.Truef(t, ok, "could not cast %T to %T", request.GetSession(), &openid.DefaultSession{})
+	session, ok := request.GetSession().(*psession.PinnipedSession)
+	require.Truef(t, ok, "could not cast %T to %T", request.GetSession(), &psession.PinnipedSession{})
 
 	// Assert that the session claims are what we think they should be, but only if we are doing OIDC.
 	if contains(wantGrantedScopes, "openid") {
-		claims := session.Claims
+		claims := session.Fosite.Claims
 		require.Empty(t, claims.JTI) // When claims.JTI is empty, Fosite will generate a UUID for this field.
 		require.Equal(t, goodSubject, claims.Subject)
 
 		// Our custom claims from the authorize endpoint should still be set.
 		require.Equal(t, map[string]interface{}{
 			"username": goodUsername,
-			"groups":   goodGroups,
+			"groups":   toSliceOfInterface(wantGroups),
 		}, claims.Extra)
 
 		// We are in charge of setting these fields. For the purpose of testing, we ensure that the
@@ -1630,11 +2562,11 @@ func requireValidStoredRequest(
 	}
 
 	// Assert that the session headers are what we think they should be.
-	headers := session.Headers
+	headers := session.Fosite.Headers
 	require.Empty(t, headers)
 
 	// Assert that the token expirations are what we think they should be.
-	authCodeExpiresAt, ok := session.ExpiresAt[fosite.AuthorizeCode]
+	authCodeExpiresAt, ok := session.Fosite.ExpiresAt[fosite.AuthorizeCode]
 	require.True(t, ok, "expected session to hold expiration time for auth code")
 	testutil.RequireTimeInDelta(
 		t,
@@ -1644,7 +2576,7 @@ func requireValidStoredRequest(
 	)
 
 	// OpenID Connect sessions do not store access token expiration information.
-	accessTokenExpiresAt, ok := session.ExpiresAt[fosite.AccessToken]
+	accessTokenExpiresAt, ok := session.Fosite.ExpiresAt[fosite.AccessToken]
 	if wantAccessTokenExpiresAt {
 		require.True(t, ok, "expected session to hold expiration time for access token")
 		testutil.RequireTimeInDelta(
@@ -1658,8 +2590,11 @@ func requireValidStoredRequest(
 	}
 
 	// We don't use these, so they should be empty.
-	require.Empty(t, session.Username)
-	require.Empty(t, session.Subject)
+	require.Empty(t, session.Fosite.Username)
+	require.Empty(t, session.Fosite.Subject)
+
+	// Assert that the custom session data about the upstream is what we think it should be.
+	require.Equal(t, wantCustomSessionData, session.Custom)
 }
 
 func requireGarbageCollectTimeInDelta(t *testing.T, tokenString string, typeLabel string, secrets v1.SecretInterface, wantExpirationTime time.Time, deltaTime time.Duration) {
@@ -1686,6 +2621,7 @@ func requireValidIDToken(
 	jwtSigningKey *ecdsa.PrivateKey,
 	wantAtHashClaimInIDToken bool,
 	wantNonceValueInIDToken bool,
+	wantGroups []string,
 	actualAccessToken string,
 ) {
 	t.Helper()
@@ -1709,7 +2645,7 @@ func requireValidIDToken(
 		IssuedAt        int64    `json:"iat"`
 		RequestedAt     int64    `json:"rat"`
 		AuthTime        int64    `json:"auth_time"`
-		Groups          string   `json:"groups"`
+		Groups          []string `json:"groups"`
 		Username        string   `json:"username"`
 	}
 
@@ -1734,7 +2670,7 @@ func requireValidIDToken(
 	require.NoError(t, err)
 	require.Equal(t, goodSubject, claims.Subject)
 	require.Equal(t, goodUsername, claims.Username)
-	require.Equal(t, goodGroups, claims.Groups)
+	require.Equal(t, wantGroups, claims.Groups)
 	require.Len(t, claims.Audience, 1)
 	require.Equal(t, goodClient, claims.Audience[0])
 	require.Equal(t, goodIssuer, claims.Issuer)
@@ -1787,3 +2723,21 @@ func contains(haystack []string, needle string) bool {
 	}
 	return false
 }
+
+func toSliceOfInterface(s []string) []interface{} {
+	r := make([]interface{}, len(s))
+	for i := range s {
+		r[i] = s[i]
+	}
+	return r
+}
+
+func TestGrantTypeLabel(t *testing.T) {
+	require.Equal(t, "authorization_code", grantTypeLabel("authorization_code"))
+	require.Equal(t, "refresh_token", grantTypeLabel("refresh_token"))
+	require.Equal(t, "token_exchange", grantTypeLabel("urn:ietf:params:oauth:grant-type:token-exchange"))
+	require.Equal(t, "device_code", grantTypeLabel("urn:ietf:params:oauth:grant-type:device_code"))
+	require.Equal(t, "password", grantTypeLabel("password"))
+	require.Equal(t, "unknown", grantTypeLabel("some-made-up-grant-type"))
+	require.Equal(t, "unknown", grantTypeLabel(""))
+}
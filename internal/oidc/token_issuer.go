@@ -0,0 +1,94 @@
+// Copyright 2021 the Pinniped contributors. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package oidc
+
+import (
+	"context"
+	"time"
+
+	coreosoidc "github.com/coreos/go-oidc/v3/oidc"
+	"github.com/ory/fosite"
+	"github.com/ory/fosite/compose"
+	"github.com/ory/fosite/handler/oauth2"
+	"github.com/ory/fosite/handler/openid"
+	"github.com/pkg/errors"
+)
+
+// tokenIssuer issues the downstream tokens for the custom grant types which are implemented outside of Fosite's
+// own handlers, following the same rules as Fosite's authorization code grant handler.
+type tokenIssuer struct {
+	accessTokenStrategy  oauth2.AccessTokenStrategy
+	refreshTokenStrategy oauth2.RefreshTokenStrategy
+	idTokenStrategy      openid.OpenIDConnectTokenStrategy
+	coreStorage          oauth2.CoreStorage
+	accessTokenLifespan  time.Duration
+	refreshTokenLifespan time.Duration
+	refreshTokenScopes   []string
+}
+
+func newTokenIssuer(config *compose.Config, storage interface{}, strategy interface{}) tokenIssuer {
+	return tokenIssuer{
+		accessTokenStrategy:  strategy.(oauth2.AccessTokenStrategy),
+		refreshTokenStrategy: strategy.(oauth2.RefreshTokenStrategy),
+		idTokenStrategy:      strategy.(openid.OpenIDConnectTokenStrategy),
+		coreStorage:          storage.(oauth2.CoreStorage),
+		accessTokenLifespan:  config.GetAccessTokenLifespan(),
+		refreshTokenLifespan: config.GetRefreshTokenLifespan(),
+		refreshTokenScopes:   config.GetRefreshTokenScopes(),
+	}
+}
+
+// setExpiresAt sets the expiration times of the tokens which will be issued for the session of the request.
+func (t *tokenIssuer) setExpiresAt(requester fosite.AccessRequester) {
+	now := time.Now().UTC()
+	requester.GetSession().SetExpiresAt(fosite.AccessToken, now.Add(t.accessTokenLifespan).Round(time.Second))
+	if t.canIssueRefreshToken(requester) {
+		requester.GetSession().SetExpiresAt(fosite.RefreshToken, now.Add(t.refreshTokenLifespan).Round(time.Second))
+	}
+}
+
+// issueTokens creates and stores an access token, a refresh token when the client may have one, and an ID token
+// when the openid scope was granted, and adds them to the response.
+func (t *tokenIssuer) issueTokens(ctx context.Context, requester fosite.AccessRequester, responder fosite.AccessResponder) error {
+	accessToken, accessTokenSignature, err := t.accessTokenStrategy.GenerateAccessToken(ctx, requester)
+	if err != nil {
+		return errors.WithStack(fosite.ErrServerError.WithWrap(err).WithDebug(err.Error()))
+	}
+	if err := t.coreStorage.CreateAccessTokenSession(ctx, accessTokenSignature, requester.Sanitize([]string{})); err != nil {
+		return errors.WithStack(fosite.ErrServerError.WithWrap(err).WithDebug(err.Error()))
+	}
+
+	if t.canIssueRefreshToken(requester) {
+		refreshToken, refreshTokenSignature, err := t.refreshTokenStrategy.GenerateRefreshToken(ctx, requester)
+		if err != nil {
+			return errors.WithStack(fosite.ErrServerError.WithWrap(err).WithDebug(err.Error()))
+		}
+		if err := t.coreStorage.CreateRefreshTokenSession(ctx, refreshTokenSignature, requester.Sanitize([]string{})); err != nil {
+			return errors.WithStack(fosite.ErrServerError.WithWrap(err).WithDebug(err.Error()))
+		}
+		responder.SetExtra("refresh_token", refreshToken)
+	}
+
+	if requester.GetGrantedScopes().Has(coreosoidc.ScopeOpenID) {
+		idToken, err := t.idTokenStrategy.GenerateIDToken(ctx, requester)
+		if err != nil {
+			return errors.WithStack(err)
+		}
+		responder.SetExtra("id_token", idToken)
+	}
+
+	responder.SetAccessToken(accessToken)
+	responder.SetTokenType("bearer")
+	responder.SetExpiresIn(time.Until(requester.GetSession().GetExpiresAt(fosite.AccessToken)).Round(time.Second))
+	responder.SetScopes(requester.GetGrantedScopes())
+	return nil
+}
+
+// canIssueRefreshToken follows the same rules as Fosite's authorization code grant handler.
+func (t *tokenIssuer) canIssueRefreshToken(requester fosite.Requester) bool {
+	if len(t.refreshTokenScopes) > 0 && !requester.GetGrantedScopes().HasOneOf(t.refreshTokenScopes...) {
+		return false
+	}
+	return requester.GetClient().GetGrantTypes().Has("refresh_token")
+}
@@ -0,0 +1,102 @@
+// Copyright 2021 the Pinniped contributors. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package oidc
+
+import (
+	"crypto/subtle"
+	"fmt"
+	"net/http"
+
+	"golang.org/x/oauth2"
+
+	"go.pinniped.dev/internal/httputil/httperr"
+	"go.pinniped.dev/internal/oidc/provider"
+	"go.pinniped.dev/internal/plog"
+	"go.pinniped.dev/pkg/oidcclient/nonce"
+	"go.pinniped.dev/pkg/oidcclient/pkce"
+)
+
+// UpstreamOIDCAuthorizeURL returns the URL of the authorize endpoint of the upstream OIDC provider, to which both the
+// authorize endpoint and the device verification endpoint send the browser to log in. The upstream sends the browser
+// back to the callback endpoint of the downstream issuer with the encoded state param. The options are appended
+// after the additionalAuthorizeParameters of the upstream, so they take precedence over them.
+func UpstreamOIDCAuthorizeURL(
+	upstream provider.UpstreamOIDCIdentityProviderI,
+	downstreamIssuer string,
+	encodedStateParamValue string,
+	nonceValue nonce.Nonce,
+	pkceValue pkce.Code,
+	opts ...oauth2.AuthCodeOption,
+) string {
+	upstreamOAuthConfig := oauth2.Config{
+		ClientID: upstream.GetClientID(),
+		Endpoint: oauth2.Endpoint{
+			AuthURL: upstream.GetAuthorizationURL().String(),
+		},
+		RedirectURL: fmt.Sprintf("%s%s", downstreamIssuer, CallbackEndpointPath),
+		Scopes:      upstream.GetScopes(),
+	}
+
+	authCodeOptions := []oauth2.AuthCodeOption{
+		oauth2.AccessTypeOffline,
+		nonceValue.Param(),
+		pkceValue.Challenge(),
+		pkceValue.Method(),
+	}
+
+	// The parameter names were already validated by the controller, so these cannot override any of the parameters
+	// above except for access_type.
+	for name, value := range upstream.GetAdditionalAuthcodeParams() {
+		authCodeOptions = append(authCodeOptions, oauth2.SetAuthURLParam(name, value))
+	}
+
+	return upstreamOAuthConfig.AuthCodeURL(encodedStateParamValue, append(authCodeOptions, opts...)...)
+}
+
+// ReadUpstreamStateParamAndValidateCSRFCookie decodes the state param of a request which returns to the Supervisor
+// during a login, such as the callback from an upstream OIDC provider or the submission of the login page. The state
+// param must have been issued to the same browser, so its CSRF token must match the CSRF cookie of the request.
+// The returned error is an httperr error.
+func ReadUpstreamStateParamAndValidateCSRFCookie(r *http.Request, stateDecoder, cookieDecoder Decoder) (*UpstreamStateParamData, error) {
+	csrfValue, err := ReadCSRFCookie(r, cookieDecoder)
+	if err != nil {
+		plog.InfoErr("error reading CSRF cookie", err)
+		return nil, err
+	}
+
+	if r.FormValue("state") == "" {
+		plog.Info("state param not found")
+		return nil, httperr.New(http.StatusBadRequest, "state param not found")
+	}
+
+	state, err := readUpstreamStateParam(r, stateDecoder)
+	if err != nil {
+		plog.InfoErr("error reading state", err)
+		return nil, err
+	}
+
+	if subtle.ConstantTimeCompare([]byte(state.CSRFToken), []byte(csrfValue)) != 1 {
+		plog.Info("CSRF value does not match")
+		return nil, httperr.New(http.StatusForbidden, "CSRF value does not match")
+	}
+
+	return state, nil
+}
+
+func readUpstreamStateParam(r *http.Request, stateDecoder Decoder) (*UpstreamStateParamData, error) {
+	var state UpstreamStateParamData
+	if err := stateDecoder.Decode(
+		UpstreamStateParamEncodingName,
+		r.FormValue("state"),
+		&state,
+	); err != nil {
+		return nil, httperr.New(http.StatusBadRequest, "error reading state")
+	}
+
+	if state.FormatVersion != UpstreamStateParamFormatVersion {
+		return nil, httperr.New(http.StatusUnprocessableEntity, "state format version is invalid")
+	}
+
+	return &state, nil
+}
@@ -0,0 +1,122 @@
+// Copyright 2021 the Pinniped contributors. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+// Package psession contains the session type which the Supervisor stores for each downstream OIDC session.
+package psession
+
+import (
+	"time"
+
+	"github.com/ory/fosite"
+	"github.com/ory/fosite/handler/openid"
+	"github.com/ory/fosite/token/jwt"
+)
+
+// PinnipedSession is a session container which includes the fosite standard stuff plus custom Pinniped stuff.
+type PinnipedSession struct {
+	// Delegate most things to the fosite session.
+	Fosite *openid.DefaultSession `json:"fosite,omitempty"`
+
+	// Custom Pinniped extensions to the session data.
+	Custom *CustomSessionData `json:"custom,omitempty"`
+}
+
+var _ openid.Session = &PinnipedSession{}
+
+// CustomSessionData is the custom session data needed by Pinniped. It should be treated as a union type,
+// where the value of ProviderType decides which other fields to use.
+type CustomSessionData struct {
+	// The name of the upstream identity provider which was used to authenticate the user.
+	ProviderName string `json:"providerName"`
+
+	// The type of the upstream identity provider, using the same type names as the authorize endpoint,
+	// e.g. "oidc", "ldap", or "activedirectory".
+	ProviderType string `json:"providerType"`
+
+	// Only used when ProviderType is "oidc".
+	OIDC *OIDCSessionData `json:"oidc,omitempty"`
+
+	// Only used when ProviderType is "ldap" or "activedirectory".
+	LDAP *LDAPSessionData `json:"ldap,omitempty"`
+}
+
+// OIDCSessionData is the additional data needed by Pinniped when the upstream IDP is an OIDC provider.
+type OIDCSessionData struct {
+	// UpstreamRefreshToken is the refresh token which was returned by the upstream provider during login.
+	// It may be empty when the upstream provider did not return a refresh token.
+	UpstreamRefreshToken string `json:"upstreamRefreshToken"`
+
+	// UpstreamIDToken is the latest ID token which was returned by the upstream provider. It is only stored when the
+	// upstream session should be ended on logout, so the end_session_endpoint can send it as the id_token_hint.
+	UpstreamIDToken string `json:"upstreamIDToken,omitempty"`
+}
+
+// LDAPSessionData is the additional data needed by Pinniped when the upstream IDP is an LDAP or Active Directory
+// provider.
+type LDAPSessionData struct {
+	// UserDN is the distinguished name of the user's entry, which was found during login.
+	UserDN string `json:"userDN"`
+}
+
+// NewPinnipedSession returns a new empty session.
+func NewPinnipedSession() *PinnipedSession {
+	return &PinnipedSession{
+		Fosite: &openid.DefaultSession{
+			Claims:  &jwt.IDTokenClaims{},
+			Headers: &jwt.Headers{},
+		},
+		Custom: &CustomSessionData{},
+	}
+}
+
+func (s *PinnipedSession) Clone() fosite.Session {
+	clone := &PinnipedSession{}
+	if s.Fosite != nil {
+		clone.Fosite = s.Fosite.Clone().(*openid.DefaultSession)
+	}
+	if s.Custom != nil {
+		clone.Custom = s.Custom.clone()
+	}
+	return clone
+}
+
+func (s *PinnipedSession) SetExpiresAt(key fosite.TokenType, exp time.Time) {
+	s.Fosite.SetExpiresAt(key, exp)
+}
+
+func (s *PinnipedSession) GetExpiresAt(key fosite.TokenType) time.Time {
+	return s.Fosite.GetExpiresAt(key)
+}
+
+func (s *PinnipedSession) GetUsername() string {
+	return s.Fosite.GetUsername()
+}
+
+func (s *PinnipedSession) SetSubject(subject string) {
+	s.Fosite.SetSubject(subject)
+}
+
+func (s *PinnipedSession) GetSubject() string {
+	return s.Fosite.GetSubject()
+}
+
+func (s *PinnipedSession) IDTokenHeaders() *jwt.Headers {
+	return s.Fosite.IDTokenHeaders()
+}
+
+func (s *PinnipedSession) IDTokenClaims() *jwt.IDTokenClaims {
+	return s.Fosite.IDTokenClaims()
+}
+
+func (c *CustomSessionData) clone() *CustomSessionData {
+	clone := *c
+	if c.OIDC != nil {
+		oidcCopy := *c.OIDC
+		clone.OIDC = &oidcCopy
+	}
+	if c.LDAP != nil {
+		ldapCopy := *c.LDAP
+		clone.LDAP = &ldapCopy
+	}
+	return &clone
+}
@@ -0,0 +1,116 @@
+// Copyright 2021 the Pinniped contributors. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package supervisorsessions
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"text/tabwriter"
+	"time"
+
+	configv1alpha1 "go.pinniped.dev/generated/latest/apis/supervisor/config/v1alpha1"
+)
+
+const commandUsage = `usage: pinniped-supervisor sessions list [--username <username>] [--subject <subject>]
+       pinniped-supervisor sessions revoke [--username <username>] [--subject <subject>] [session-name]...`
+
+// RunCommand runs the "pinniped-supervisor sessions" command with the arguments which follow "sessions", e.g.
+// "list --username some-user" or "revoke some-session-name". It works like "pinniped supervisor sessions", but it
+// reads the sessions from the Supervisor in the same pod through the client, instead of reading Session objects.
+func RunCommand(ctx context.Context, client *Client, args []string, output io.Writer) error {
+	if len(args) == 0 {
+		return errors.New(commandUsage)
+	}
+
+	var username, subject string
+	flags := flag.NewFlagSet("pinniped-supervisor sessions "+args[0], flag.ContinueOnError)
+	flags.SetOutput(io.Discard)
+	flags.StringVar(&username, "username", "", "Only include the sessions of the user with this downstream username")
+	flags.StringVar(&subject, "subject", "", "Only include the sessions of the user with this downstream subject")
+	if err := flags.Parse(args[1:]); err != nil {
+		return fmt.Errorf("%w\n%s", err, commandUsage)
+	}
+
+	switch args[0] {
+	case "list":
+		if flags.NArg() != 0 {
+			return errors.New(commandUsage)
+		}
+		sessions, err := listSessions(ctx, client, username, subject)
+		if err != nil {
+			return err
+		}
+		return printSessions(output, sessions)
+	case "revoke":
+		names := flags.Args()
+		if len(names) == 0 && username == "" && subject == "" {
+			return errors.New("at least one session name, or --username or --subject must be specified")
+		}
+		if len(names) == 0 {
+			sessions, err := listSessions(ctx, client, username, subject)
+			if err != nil {
+				return err
+			}
+			if len(sessions) == 0 {
+				fmt.Fprintln(output, "no matching sessions found")
+				return nil
+			}
+			for _, session := range sessions {
+				names = append(names, session.Name)
+			}
+		}
+		for _, name := range names {
+			if err := client.Revoke(ctx, name); err != nil {
+				return err
+			}
+			fmt.Fprintf(output, "session %s revoked\n", name)
+		}
+		return nil
+	default:
+		return errors.New(commandUsage)
+	}
+}
+
+// listSessions lists the sessions, keeping only those which match the username and subject, if they were specified.
+func listSessions(ctx context.Context, client *Client, username, subject string) ([]configv1alpha1.Session, error) {
+	all, err := client.List(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	sessions := make([]configv1alpha1.Session, 0, len(all))
+	for _, session := range all {
+		if username != "" && session.Status.Username != username {
+			continue
+		}
+		if subject != "" && session.Status.Subject != subject {
+			continue
+		}
+		sessions = append(sessions, session)
+	}
+	return sessions, nil
+}
+
+func printSessions(output io.Writer, sessions []configv1alpha1.Session) error {
+	w := tabwriter.NewWriter(output, 0, 8, 3, ' ', 0)
+	fmt.Fprintln(w, "NAME\tUSERNAME\tSUBJECT\tCLIENT\tIDENTITY PROVIDER\tEXPIRES")
+	for _, session := range sessions {
+		expires := ""
+		if session.Status.ExpiresAt != nil {
+			expires = session.Status.ExpiresAt.UTC().Format(time.RFC3339)
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%s\n",
+			session.Name,
+			session.Status.Username,
+			session.Status.Subject,
+			session.Status.ClientID,
+			session.Status.UpstreamIdentityProviderName,
+			expires,
+		)
+	}
+	return w.Flush()
+}
@@ -0,0 +1,124 @@
+// Copyright 2021 the Pinniped contributors. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+// Package supervisorsessions lists and revokes the active downstream sessions of the storage backends which do not
+// use Secrets. Those sessions are not mirrored into Session objects, so the Supervisor serves them on a loopback
+// address inside of its pod instead, and the "pinniped-supervisor sessions" command which is run in the same pod
+// (e.g. using kubectl exec) reads them from there.
+package supervisorsessions
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+
+	configv1alpha1 "go.pinniped.dev/generated/latest/apis/supervisor/config/v1alpha1"
+	"go.pinniped.dev/internal/httputil/httperr"
+)
+
+// ListenAddress is the loopback address on which the Supervisor serves the sessions. It is only reachable from
+// inside of the Supervisor pod, so running a command in that pod is what grants access to the sessions.
+const ListenAddress = "127.0.0.1:8081"
+
+const sessionsPath = "/sessions"
+
+// Sessions lists and revokes the active sessions of a storage backend.
+type Sessions interface {
+	// List returns the active sessions as Sessions in the namespace, sorted by their names.
+	List(ctx context.Context, namespace string) ([]configv1alpha1.Session, error)
+
+	// Revoke deletes all stored data of the session with the name. It returns false when there is no such session.
+	Revoke(ctx context.Context, name string) (bool, error)
+}
+
+// NewHandler returns an http.Handler which lists the sessions as a SessionList in response to GET /sessions, and
+// which revokes a session in response to DELETE /sessions/<name>.
+func NewHandler(sessions Sessions, namespace string) http.Handler {
+	mux := http.NewServeMux()
+	mux.Handle(sessionsPath, httperr.HandlerFunc(func(w http.ResponseWriter, r *http.Request) error {
+		if r.Method != http.MethodGet {
+			return httperr.Newf(http.StatusMethodNotAllowed, "%s (try GET)", r.Method)
+		}
+
+		list, err := sessions.List(r.Context(), namespace)
+		if err != nil {
+			return httperr.Wrap(http.StatusInternalServerError, "could not list sessions", err)
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		return json.NewEncoder(w).Encode(&configv1alpha1.SessionList{Items: list})
+	}))
+	mux.Handle(sessionsPath+"/", httperr.HandlerFunc(func(w http.ResponseWriter, r *http.Request) error {
+		if r.Method != http.MethodDelete {
+			return httperr.Newf(http.StatusMethodNotAllowed, "%s (try DELETE)", r.Method)
+		}
+
+		name := strings.TrimPrefix(r.URL.Path, sessionsPath+"/")
+		if name == "" || strings.Contains(name, "/") {
+			return httperr.New(http.StatusNotFound, "session not found")
+		}
+
+		revoked, err := sessions.Revoke(r.Context(), name)
+		if err != nil {
+			return httperr.Wrap(http.StatusInternalServerError, "could not revoke session", err)
+		}
+		if !revoked {
+			return httperr.New(http.StatusNotFound, "session not found")
+		}
+
+		w.WriteHeader(http.StatusNoContent)
+		return nil
+	}))
+	return mux
+}
+
+// Client reads the sessions which are served by NewHandler.
+type Client struct {
+	baseURL    string
+	httpClient *http.Client
+}
+
+// NewClient returns a Client of the sessions which are served on the address, e.g. ListenAddress.
+func NewClient(address string, httpClient *http.Client) *Client {
+	return &Client{baseURL: "http://" + address + sessionsPath, httpClient: httpClient}
+}
+
+// List lists the active sessions.
+func (c *Client) List(ctx context.Context) ([]configv1alpha1.Session, error) {
+	var list configv1alpha1.SessionList
+	if err := c.do(ctx, http.MethodGet, c.baseURL, http.StatusOK, &list); err != nil {
+		return nil, fmt.Errorf("could not list sessions: %w", err)
+	}
+	return list.Items, nil
+}
+
+// Revoke revokes the session with the name.
+func (c *Client) Revoke(ctx context.Context, name string) error {
+	if err := c.do(ctx, http.MethodDelete, c.baseURL+"/"+url.PathEscape(name), http.StatusNoContent, nil); err != nil {
+		return fmt.Errorf("could not revoke session %s: %w", name, err)
+	}
+	return nil
+}
+
+func (c *Client) do(ctx context.Context, method, requestURL string, wantStatus int, response interface{}) error {
+	req, err := http.NewRequestWithContext(ctx, method, requestURL, nil)
+	if err != nil {
+		return err
+	}
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = resp.Body.Close() }()
+
+	if resp.StatusCode != wantStatus {
+		return fmt.Errorf("unexpected response status %q", resp.Status)
+	}
+	if response == nil {
+		return nil
+	}
+	return json.NewDecoder(resp.Body).Decode(response)
+}
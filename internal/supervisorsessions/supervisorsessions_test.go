@@ -0,0 +1,250 @@
+// Copyright 2021 the Pinniped contributors. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package supervisorsessions
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	configv1alpha1 "go.pinniped.dev/generated/latest/apis/supervisor/config/v1alpha1"
+	"go.pinniped.dev/internal/here"
+)
+
+type fakeSessions struct {
+	sessions []configv1alpha1.Session
+	listErr  error
+	revoked  []string
+}
+
+func (f *fakeSessions) List(_ context.Context, namespace string) ([]configv1alpha1.Session, error) {
+	if f.listErr != nil {
+		return nil, f.listErr
+	}
+	sessions := make([]configv1alpha1.Session, 0, len(f.sessions))
+	for _, session := range f.sessions {
+		session.Namespace = namespace
+		sessions = append(sessions, session)
+	}
+	return sessions, nil
+}
+
+func (f *fakeSessions) Revoke(_ context.Context, name string) (bool, error) {
+	for i, session := range f.sessions {
+		if session.Name == name {
+			f.sessions = append(f.sessions[:i], f.sessions[i+1:]...)
+			f.revoked = append(f.revoked, name)
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+func TestHandler(t *testing.T) {
+	t.Parallel()
+
+	sessions := &fakeSessions{sessions: []configv1alpha1.Session{{ObjectMeta: metav1.ObjectMeta{Name: "session-1"}}}}
+	handler := NewHandler(sessions, "some-namespace")
+
+	tests := []struct {
+		name       string
+		method     string
+		path       string
+		wantStatus int
+		wantBody   string
+	}{
+		{
+			name:       "list",
+			method:     http.MethodGet,
+			path:       "/sessions",
+			wantStatus: http.StatusOK,
+			wantBody:   `{"metadata":{},"items":[{"metadata":{"name":"session-1","namespace":"some-namespace","creationTimestamp":null},"spec":{},"status":{}}]}` + "\n",
+		},
+		{
+			name:       "list with the wrong method",
+			method:     http.MethodPost,
+			path:       "/sessions",
+			wantStatus: http.StatusMethodNotAllowed,
+			wantBody:   "Method Not Allowed: POST (try GET)\n",
+		},
+		{
+			name:       "revoke with the wrong method",
+			method:     http.MethodGet,
+			path:       "/sessions/session-1",
+			wantStatus: http.StatusMethodNotAllowed,
+			wantBody:   "Method Not Allowed: GET (try DELETE)\n",
+		},
+		{
+			name:       "revoke without a name",
+			method:     http.MethodDelete,
+			path:       "/sessions/",
+			wantStatus: http.StatusNotFound,
+			wantBody:   "Not Found: session not found\n",
+		},
+		{
+			name:       "revoke an unknown session",
+			method:     http.MethodDelete,
+			path:       "/sessions/other-session",
+			wantStatus: http.StatusNotFound,
+			wantBody:   "Not Found: session not found\n",
+		},
+		{
+			name:       "revoke",
+			method:     http.MethodDelete,
+			path:       "/sessions/session-1",
+			wantStatus: http.StatusNoContent,
+		},
+		{
+			name:       "other paths",
+			method:     http.MethodGet,
+			path:       "/healthz",
+			wantStatus: http.StatusNotFound,
+			wantBody:   "404 page not found\n",
+		},
+	}
+	for _, test := range tests {
+		// The cases run in order, because they share the sessions.
+		rsp := httptest.NewRecorder()
+		handler.ServeHTTP(rsp, httptest.NewRequest(test.method, test.path, nil))
+		require.Equal(t, test.wantStatus, rsp.Code, test.name)
+		require.Equal(t, test.wantBody, rsp.Body.String(), test.name)
+	}
+	require.Equal(t, []string{"session-1"}, sessions.revoked)
+}
+
+func TestRunCommand(t *testing.T) {
+	t.Parallel()
+
+	expiresAt := metav1.NewTime(time.Date(2021, 1, 2, 3, 4, 5, 0, time.UTC))
+	newSession := func(name, username string) configv1alpha1.Session {
+		return configv1alpha1.Session{
+			ObjectMeta: metav1.ObjectMeta{Name: name},
+			Status: configv1alpha1.SessionStatus{
+				Username:                     username,
+				Subject:                      "https://upstream.example.com?sub=" + username,
+				ClientID:                     "pinniped-cli",
+				UpstreamIdentityProviderName: "some-upstream",
+				ExpiresAt:                    &expiresAt,
+			},
+		}
+	}
+
+	tests := []struct {
+		name        string
+		args        []string
+		listErr     error
+		wantOutput  string
+		wantErr     string
+		wantRevoked []string
+	}{
+		{
+			name:    "no subcommand",
+			wantErr: commandUsage,
+		},
+		{
+			name:    "unknown subcommand",
+			args:    []string{"delete"},
+			wantErr: commandUsage,
+		},
+		{
+			name:    "unknown flag",
+			args:    []string{"list", "--user", "user-1"},
+			wantErr: "flag provided but not defined: -user\n" + commandUsage,
+		},
+		{
+			name:    "list with arguments",
+			args:    []string{"list", "session-1"},
+			wantErr: commandUsage,
+		},
+		{
+			name: "list",
+			args: []string{"list"},
+			wantOutput: here.Doc(`
+				NAME        USERNAME   SUBJECT                                   CLIENT         IDENTITY PROVIDER   EXPIRES
+				session-1   user-1     https://upstream.example.com?sub=user-1   pinniped-cli   some-upstream       2021-01-02T03:04:05Z
+				session-2   user-2     https://upstream.example.com?sub=user-2   pinniped-cli   some-upstream       2021-01-02T03:04:05Z
+				session-3   user-1     https://upstream.example.com?sub=user-1   pinniped-cli   some-upstream       2021-01-02T03:04:05Z
+			`),
+		},
+		{
+			name: "list by username",
+			args: []string{"list", "--username", "user-2"},
+			wantOutput: here.Doc(`
+				NAME        USERNAME   SUBJECT                                   CLIENT         IDENTITY PROVIDER   EXPIRES
+				session-2   user-2     https://upstream.example.com?sub=user-2   pinniped-cli   some-upstream       2021-01-02T03:04:05Z
+			`),
+		},
+		{
+			name:    "list fails",
+			args:    []string{"list"},
+			listErr: errors.New("some list error"),
+			wantErr: `could not list sessions: unexpected response status "500 Internal Server Error"`,
+		},
+		{
+			name:    "revoke without names or flags",
+			args:    []string{"revoke"},
+			wantErr: "at least one session name, or --username or --subject must be specified",
+		},
+		{
+			name:        "revoke by name",
+			args:        []string{"revoke", "session-2", "session-3"},
+			wantOutput:  "session session-2 revoked\nsession session-3 revoked\n",
+			wantRevoked: []string{"session-2", "session-3"},
+		},
+		{
+			name:        "revoke by subject",
+			args:        []string{"revoke", "--subject", "https://upstream.example.com?sub=user-1"},
+			wantOutput:  "session session-1 revoked\nsession session-3 revoked\n",
+			wantRevoked: []string{"session-1", "session-3"},
+		},
+		{
+			name:       "revoke by username without sessions",
+			args:       []string{"revoke", "--username", "user-3"},
+			wantOutput: "no matching sessions found\n",
+		},
+		{
+			name:        "revoke an unknown session",
+			args:        []string{"revoke", "session-1", "session-4"},
+			wantOutput:  "session session-1 revoked\n",
+			wantErr:     `could not revoke session session-4: unexpected response status "404 Not Found"`,
+			wantRevoked: []string{"session-1"},
+		},
+	}
+	for _, test := range tests {
+		test := test
+		t.Run(test.name, func(t *testing.T) {
+			t.Parallel()
+
+			sessions := &fakeSessions{
+				sessions: []configv1alpha1.Session{
+					newSession("session-1", "user-1"),
+					newSession("session-2", "user-2"),
+					newSession("session-3", "user-1"),
+				},
+				listErr: test.listErr,
+			}
+			server := httptest.NewServer(NewHandler(sessions, "some-namespace"))
+			defer server.Close()
+
+			client := NewClient(strings.TrimPrefix(server.URL, "http://"), server.Client())
+			var output bytes.Buffer
+			err := RunCommand(context.Background(), client, test.args, &output)
+			if test.wantErr != "" {
+				require.EqualError(t, err, test.wantErr)
+			} else {
+				require.NoError(t, err)
+			}
+			require.Equal(t, test.wantOutput, output.String())
+			require.Equal(t, test.wantRevoked, sessions.revoked)
+		})
+	}
+}
@@ -16,21 +16,23 @@ import (
 
 	coreosoidc "github.com/coreos/go-oidc/v3/oidc"
 	"github.com/ory/fosite"
-	"github.com/ory/fosite/handler/openid"
 	"github.com/stretchr/testify/require"
 	"golang.org/x/oauth2"
 	"gopkg.in/square/go-jose.v2"
 	"k8s.io/apimachinery/pkg/labels"
-	"k8s.io/apiserver/pkg/authentication/authenticator"
 	"k8s.io/client-go/kubernetes/fake"
 	v1 "k8s.io/client-go/kubernetes/typed/core/v1"
 
+	configv1alpha1 "go.pinniped.dev/generated/latest/apis/supervisor/config/v1alpha1"
+	"go.pinniped.dev/internal/authenticators"
 	"go.pinniped.dev/internal/crud"
 	"go.pinniped.dev/internal/fositestorage/authorizationcode"
 	"go.pinniped.dev/internal/fositestorage/openidconnect"
 	pkce2 "go.pinniped.dev/internal/fositestorage/pkce"
 	"go.pinniped.dev/internal/fositestoragei"
+	"go.pinniped.dev/internal/idtransform"
 	"go.pinniped.dev/internal/oidc/provider"
+	"go.pinniped.dev/internal/psession"
 	"go.pinniped.dev/internal/testutil"
 	"go.pinniped.dev/pkg/oidcclient/nonce"
 	"go.pinniped.dev/pkg/oidcclient/oidctypes"
@@ -49,10 +51,30 @@ type ExchangeAuthcodeAndValidateTokenArgs struct {
 	RedirectURI          string
 }
 
+// PerformRefreshArgs is used to spy on calls to
+// TestUpstreamOIDCIdentityProvider.PerformRefreshFunc() and TestUpstreamLDAPIdentityProvider.PerformRefreshFunc().
+type PerformRefreshArgs struct {
+	Ctx          context.Context
+	RefreshToken string
+	DN           string
+}
+
+// ValidateTokenArgs is used to spy on calls to
+// TestUpstreamOIDCIdentityProvider.ValidateTokenFunc().
+type ValidateTokenArgs struct {
+	Ctx                  context.Context
+	Tok                  *oauth2.Token
+	ExpectedIDTokenNonce nonce.Nonce
+}
+
 type TestUpstreamLDAPIdentityProvider struct {
-	Name             string
-	URL              *url.URL
-	AuthenticateFunc func(ctx context.Context, username, password string) (*authenticator.Response, bool, error)
+	Name               string
+	URL                *url.URL
+	AuthenticateFunc   func(ctx context.Context, username, password string) (*authenticators.Response, bool, error)
+	PerformRefreshFunc func(ctx context.Context, userDN string) (*authenticators.Response, bool, error)
+
+	performRefreshCallCount int
+	performRefreshArgs      []*PerformRefreshArgs
 }
 
 var _ provider.UpstreamLDAPIdentityProviderI = &TestUpstreamLDAPIdentityProvider{}
@@ -61,7 +83,7 @@ func (u *TestUpstreamLDAPIdentityProvider) GetName() string {
 	return u.Name
 }
 
-func (u *TestUpstreamLDAPIdentityProvider) AuthenticateUser(ctx context.Context, username, password string) (*authenticator.Response, bool, error) {
+func (u *TestUpstreamLDAPIdentityProvider) AuthenticateUser(ctx context.Context, username, password string) (*authenticators.Response, bool, error) {
 	return u.AuthenticateFunc(ctx, username, password)
 }
 
@@ -69,22 +91,54 @@ func (u *TestUpstreamLDAPIdentityProvider) GetURL() *url.URL {
 	return u.URL
 }
 
+func (u *TestUpstreamLDAPIdentityProvider) PerformRefresh(ctx context.Context, userDN string) (*authenticators.Response, bool, error) {
+	if u.performRefreshArgs == nil {
+		u.performRefreshArgs = make([]*PerformRefreshArgs, 0)
+	}
+	u.performRefreshCallCount++
+	u.performRefreshArgs = append(u.performRefreshArgs, &PerformRefreshArgs{
+		Ctx: ctx,
+		DN:  userDN,
+	})
+	return u.PerformRefreshFunc(ctx, userDN)
+}
+
+func (u *TestUpstreamLDAPIdentityProvider) PerformRefreshCallCount() int {
+	return u.performRefreshCallCount
+}
+
+func (u *TestUpstreamLDAPIdentityProvider) PerformRefreshArgs(call int) *PerformRefreshArgs {
+	if u.performRefreshArgs == nil {
+		u.performRefreshArgs = make([]*PerformRefreshArgs, 0)
+	}
+	return u.performRefreshArgs[call]
+}
+
 type TestUpstreamOIDCIdentityProvider struct {
 	Name                                  string
 	ClientID                              string
 	AuthorizationURL                      url.URL
+	EndSessionURL                         *url.URL
 	UsernameClaim                         string
 	GroupsClaim                           string
+	AdditionalClaimMappings               map[string]string
 	Scopes                                []string
+	AdditionalAuthcodeParams              map[string]string
 	ExchangeAuthcodeAndValidateTokensFunc func(
 		ctx context.Context,
 		authcode string,
 		pkceCodeVerifier pkce.Code,
 		expectedIDTokenNonce nonce.Nonce,
 	) (*oidctypes.Token, error)
+	PerformRefreshFunc func(ctx context.Context, refreshToken string) (*oauth2.Token, error)
+	ValidateTokenFunc  func(ctx context.Context, tok *oauth2.Token, expectedIDTokenNonce nonce.Nonce) (*oidctypes.Token, error)
 
 	exchangeAuthcodeAndValidateTokensCallCount int
 	exchangeAuthcodeAndValidateTokensArgs      []*ExchangeAuthcodeAndValidateTokenArgs
+	performRefreshCallCount                    int
+	performRefreshArgs                         []*PerformRefreshArgs
+	validateTokenCallCount                     int
+	validateTokenArgs                          []*ValidateTokenArgs
 }
 
 func (u *TestUpstreamOIDCIdentityProvider) GetName() string {
@@ -99,10 +153,18 @@ func (u *TestUpstreamOIDCIdentityProvider) GetAuthorizationURL() *url.URL {
 	return &u.AuthorizationURL
 }
 
+func (u *TestUpstreamOIDCIdentityProvider) GetEndSessionURL() *url.URL {
+	return u.EndSessionURL
+}
+
 func (u *TestUpstreamOIDCIdentityProvider) GetScopes() []string {
 	return u.Scopes
 }
 
+func (u *TestUpstreamOIDCIdentityProvider) GetAdditionalAuthcodeParams() map[string]string {
+	return u.AdditionalAuthcodeParams
+}
+
 func (u *TestUpstreamOIDCIdentityProvider) GetUsernameClaim() string {
 	return u.UsernameClaim
 }
@@ -111,6 +173,10 @@ func (u *TestUpstreamOIDCIdentityProvider) GetGroupsClaim() string {
 	return u.GroupsClaim
 }
 
+func (u *TestUpstreamOIDCIdentityProvider) GetAdditionalClaimMappings() map[string]string {
+	return u.AdditionalClaimMappings
+}
+
 func (u *TestUpstreamOIDCIdentityProvider) ExchangeAuthcodeAndValidateTokens(
 	ctx context.Context,
 	authcode string,
@@ -143,13 +209,57 @@ func (u *TestUpstreamOIDCIdentityProvider) ExchangeAuthcodeAndValidateTokensArgs
 	return u.exchangeAuthcodeAndValidateTokensArgs[call]
 }
 
-func (u *TestUpstreamOIDCIdentityProvider) ValidateToken(_ context.Context, _ *oauth2.Token, _ nonce.Nonce) (*oidctypes.Token, error) {
-	panic("implement me")
+func (u *TestUpstreamOIDCIdentityProvider) PerformRefresh(ctx context.Context, refreshToken string) (*oauth2.Token, error) {
+	if u.performRefreshArgs == nil {
+		u.performRefreshArgs = make([]*PerformRefreshArgs, 0)
+	}
+	u.performRefreshCallCount++
+	u.performRefreshArgs = append(u.performRefreshArgs, &PerformRefreshArgs{
+		Ctx:          ctx,
+		RefreshToken: refreshToken,
+	})
+	return u.PerformRefreshFunc(ctx, refreshToken)
+}
+
+func (u *TestUpstreamOIDCIdentityProvider) PerformRefreshCallCount() int {
+	return u.performRefreshCallCount
+}
+
+func (u *TestUpstreamOIDCIdentityProvider) PerformRefreshArgs(call int) *PerformRefreshArgs {
+	if u.performRefreshArgs == nil {
+		u.performRefreshArgs = make([]*PerformRefreshArgs, 0)
+	}
+	return u.performRefreshArgs[call]
+}
+
+func (u *TestUpstreamOIDCIdentityProvider) ValidateToken(ctx context.Context, tok *oauth2.Token, expectedIDTokenNonce nonce.Nonce) (*oidctypes.Token, error) {
+	if u.validateTokenArgs == nil {
+		u.validateTokenArgs = make([]*ValidateTokenArgs, 0)
+	}
+	u.validateTokenCallCount++
+	u.validateTokenArgs = append(u.validateTokenArgs, &ValidateTokenArgs{
+		Ctx:                  ctx,
+		Tok:                  tok,
+		ExpectedIDTokenNonce: expectedIDTokenNonce,
+	})
+	return u.ValidateTokenFunc(ctx, tok, expectedIDTokenNonce)
+}
+
+func (u *TestUpstreamOIDCIdentityProvider) ValidateTokenCallCount() int {
+	return u.validateTokenCallCount
+}
+
+func (u *TestUpstreamOIDCIdentityProvider) ValidateTokenArgs(call int) *ValidateTokenArgs {
+	if u.validateTokenArgs == nil {
+		u.validateTokenArgs = make([]*ValidateTokenArgs, 0)
+	}
+	return u.validateTokenArgs[call]
 }
 
 type UpstreamIDPListerBuilder struct {
-	upstreamOIDCIdentityProviders []*TestUpstreamOIDCIdentityProvider
-	upstreamLDAPIdentityProviders []*TestUpstreamLDAPIdentityProvider
+	upstreamOIDCIdentityProviders            []*TestUpstreamOIDCIdentityProvider
+	upstreamLDAPIdentityProviders            []*TestUpstreamLDAPIdentityProvider
+	upstreamActiveDirectoryIdentityProviders []*TestUpstreamLDAPIdentityProvider
 }
 
 func (b *UpstreamIDPListerBuilder) WithOIDC(upstreamOIDCIdentityProviders ...*TestUpstreamOIDCIdentityProvider) *UpstreamIDPListerBuilder {
@@ -162,6 +272,11 @@ func (b *UpstreamIDPListerBuilder) WithLDAP(upstreamLDAPIdentityProviders ...*Te
 	return b
 }
 
+func (b *UpstreamIDPListerBuilder) WithActiveDirectory(upstreamActiveDirectoryIdentityProviders ...*TestUpstreamLDAPIdentityProvider) *UpstreamIDPListerBuilder {
+	b.upstreamActiveDirectoryIdentityProviders = append(b.upstreamActiveDirectoryIdentityProviders, upstreamActiveDirectoryIdentityProviders...)
+	return b
+}
+
 func (b *UpstreamIDPListerBuilder) Build() provider.DynamicUpstreamIDPProvider {
 	idpProvider := provider.NewDynamicUpstreamIDPProvider()
 
@@ -177,13 +292,128 @@ func (b *UpstreamIDPListerBuilder) Build() provider.DynamicUpstreamIDPProvider {
 	}
 	idpProvider.SetLDAPIdentityProviders(ldapUpstreams)
 
+	adUpstreams := make([]provider.UpstreamLDAPIdentityProviderI, len(b.upstreamActiveDirectoryIdentityProviders))
+	for i := range b.upstreamActiveDirectoryIdentityProviders {
+		adUpstreams[i] = provider.UpstreamLDAPIdentityProviderI(b.upstreamActiveDirectoryIdentityProviders[i])
+	}
+	idpProvider.SetActiveDirectoryIdentityProviders(adUpstreams)
+
 	return idpProvider
 }
 
+func (b *UpstreamIDPListerBuilder) RequireExactlyOneCallToPerformRefresh(
+	t *testing.T,
+	expectedPerformedByUpstreamName string,
+	expectedArgs *PerformRefreshArgs,
+) {
+	t.Helper()
+	var actualArgs *PerformRefreshArgs
+	var actualNameOfUpstreamWhichMadeCall string
+	actualCallCountAcrossAllUpstreams := 0
+	for _, upstreamOIDC := range b.upstreamOIDCIdentityProviders {
+		callCountOnThisUpstream := upstreamOIDC.performRefreshCallCount
+		actualCallCountAcrossAllUpstreams += callCountOnThisUpstream
+		if callCountOnThisUpstream == 1 {
+			actualNameOfUpstreamWhichMadeCall = upstreamOIDC.Name
+			actualArgs = upstreamOIDC.performRefreshArgs[0]
+		}
+	}
+	for _, upstreamLDAP := range append(b.upstreamLDAPIdentityProviders, b.upstreamActiveDirectoryIdentityProviders...) {
+		callCountOnThisUpstream := upstreamLDAP.performRefreshCallCount
+		actualCallCountAcrossAllUpstreams += callCountOnThisUpstream
+		if callCountOnThisUpstream == 1 {
+			actualNameOfUpstreamWhichMadeCall = upstreamLDAP.Name
+			actualArgs = upstreamLDAP.performRefreshArgs[0]
+		}
+	}
+	require.Equal(t, 1, actualCallCountAcrossAllUpstreams,
+		"should have been exactly one call to PerformRefresh() by all upstreams",
+	)
+	require.Equal(t, expectedPerformedByUpstreamName, actualNameOfUpstreamWhichMadeCall,
+		"PerformRefresh() was called on the wrong upstream",
+	)
+	require.Equal(t, expectedArgs, actualArgs)
+}
+
+func (b *UpstreamIDPListerBuilder) RequireExactlyZeroCallsToPerformRefresh(t *testing.T) {
+	t.Helper()
+	actualCallCountAcrossAllUpstreams := 0
+	for _, upstreamOIDC := range b.upstreamOIDCIdentityProviders {
+		actualCallCountAcrossAllUpstreams += upstreamOIDC.performRefreshCallCount
+	}
+	for _, upstreamLDAP := range append(b.upstreamLDAPIdentityProviders, b.upstreamActiveDirectoryIdentityProviders...) {
+		actualCallCountAcrossAllUpstreams += upstreamLDAP.performRefreshCallCount
+	}
+	require.Equal(t, 0, actualCallCountAcrossAllUpstreams,
+		"expected exactly zero calls to PerformRefresh()",
+	)
+}
+
+func (b *UpstreamIDPListerBuilder) RequireExactlyOneCallToValidateToken(
+	t *testing.T,
+	expectedPerformedByUpstreamName string,
+	expectedArgs *ValidateTokenArgs,
+) {
+	t.Helper()
+	var actualArgs *ValidateTokenArgs
+	var actualNameOfUpstreamWhichMadeCall string
+	actualCallCountAcrossAllOIDCUpstreams := 0
+	for _, upstreamOIDC := range b.upstreamOIDCIdentityProviders {
+		callCountOnThisUpstream := upstreamOIDC.validateTokenCallCount
+		actualCallCountAcrossAllOIDCUpstreams += callCountOnThisUpstream
+		if callCountOnThisUpstream == 1 {
+			actualNameOfUpstreamWhichMadeCall = upstreamOIDC.Name
+			actualArgs = upstreamOIDC.validateTokenArgs[0]
+		}
+	}
+	require.Equal(t, 1, actualCallCountAcrossAllOIDCUpstreams,
+		"should have been exactly one call to ValidateToken() by all OIDC upstreams",
+	)
+	require.Equal(t, expectedPerformedByUpstreamName, actualNameOfUpstreamWhichMadeCall,
+		"ValidateToken() was called on the wrong OIDC upstream",
+	)
+	require.Equal(t, expectedArgs, actualArgs)
+}
+
+func (b *UpstreamIDPListerBuilder) RequireExactlyZeroCallsToValidateToken(t *testing.T) {
+	t.Helper()
+	actualCallCountAcrossAllOIDCUpstreams := 0
+	for _, upstreamOIDC := range b.upstreamOIDCIdentityProviders {
+		actualCallCountAcrossAllOIDCUpstreams += upstreamOIDC.validateTokenCallCount
+	}
+	require.Equal(t, 0, actualCallCountAcrossAllOIDCUpstreams,
+		"expected exactly zero calls to ValidateToken()",
+	)
+}
+
 func NewUpstreamIDPListerBuilder() *UpstreamIDPListerBuilder {
 	return &UpstreamIDPListerBuilder{}
 }
 
+// TestIdentityTransforms maps the names of upstream IDPs to the transforms of the identities of their users.
+// A nil TestIdentityTransforms does not transform any identities.
+type TestIdentityTransforms map[string]*idtransform.Pipeline
+
+func (t TestIdentityTransforms) IdentityTransforms(_ string, idpName string) *idtransform.Pipeline {
+	return t[idpName]
+}
+
+// NewIdentityTransforms returns a TestIdentityTransforms which applies the given transforms to the identities of the
+// users of the upstream IDP with the given name.
+func NewIdentityTransforms(t *testing.T, idpName string, transforms ...configv1alpha1.FederationDomainTransform) TestIdentityTransforms {
+	pipeline, err := idtransform.NewPipeline(transforms, nil)
+	require.NoError(t, err)
+	return TestIdentityTransforms{idpName: pipeline}
+}
+
+// NewIdentityPolicy returns a TestIdentityTransforms which applies the given login policy to the identities of the
+// users of the upstream IDP with the given name.
+func NewIdentityPolicy(t *testing.T, idpName string, spec configv1alpha1.FederationDomainPolicy) TestIdentityTransforms {
+	policy, err := idtransform.NewPolicy(&spec)
+	require.NoError(t, err)
+	return TestIdentityTransforms{idpName: policy.Pipeline()}
+}
+
 // Declare a separate type from the production code to ensure that the state param's contents was serialized
 // in the format that we expect, with the json keys that we expect, etc. This also ensure that the order of
 // the serialized fields is the same, which doesn't really matter expect that we can make simpler equality
@@ -191,10 +421,12 @@ func NewUpstreamIDPListerBuilder() *UpstreamIDPListerBuilder {
 type ExpectedUpstreamStateParamFormat struct {
 	P string `json:"p"`
 	U string `json:"u"`
+	T string `json:"t"`
 	N string `json:"n"`
 	C string `json:"c"`
 	K string `json:"k"`
 	V string `json:"v"`
+	D string `json:"d,omitempty"`
 }
 
 type staticKeySet struct {
@@ -246,12 +478,14 @@ func RequireAuthCodeRegexpMatch(
 	wantDownstreamIDTokenSubject string,
 	wantDownstreamIDTokenUsername string,
 	wantDownstreamIDTokenGroups []string,
+	wantDownstreamAdditionalClaims map[string]interface{},
 	wantDownstreamRequestedScopes []string,
 	wantDownstreamPKCEChallenge string,
 	wantDownstreamPKCEChallengeMethod string,
 	wantDownstreamNonce string,
 	wantDownstreamClientID string,
 	wantDownstreamRedirectURI string,
+	wantCustomSessionData *psession.CustomSessionData,
 ) {
 	t.Helper()
 
@@ -283,9 +517,11 @@ func RequireAuthCodeRegexpMatch(
 		wantDownstreamIDTokenSubject,
 		wantDownstreamIDTokenUsername,
 		wantDownstreamIDTokenGroups,
+		wantDownstreamAdditionalClaims,
 		wantDownstreamRequestedScopes,
 		wantDownstreamClientID,
 		wantDownstreamRedirectURI,
+		wantCustomSessionData,
 	)
 
 	// One PKCE should have been stored.
@@ -333,10 +569,12 @@ func validateAuthcodeStorage(
 	wantDownstreamIDTokenSubject string,
 	wantDownstreamIDTokenUsername string,
 	wantDownstreamIDTokenGroups []string,
+	wantDownstreamAdditionalClaims map[string]interface{},
 	wantDownstreamRequestedScopes []string,
 	wantDownstreamClientID string,
 	wantDownstreamRedirectURI string,
-) (*fosite.Request, *openid.DefaultSession) {
+	wantCustomSessionData *psession.CustomSessionData,
+) (*fosite.Request, *psession.PinnipedSession) {
 	t.Helper()
 
 	const (
@@ -364,24 +602,36 @@ func validateAuthcodeStorage(
 	testutil.RequireTimeInDelta(t, time.Now(), storedRequestFromAuthcode.RequestedAt, timeComparisonFudgeFactor)
 
 	// We're not using these fields yet, so confirm that we did not set them (for now).
-	require.Empty(t, storedSessionFromAuthcode.Subject)
-	require.Empty(t, storedSessionFromAuthcode.Username)
-	require.Empty(t, storedSessionFromAuthcode.Headers)
+	require.Empty(t, storedSessionFromAuthcode.Fosite.Subject)
+	require.Empty(t, storedSessionFromAuthcode.Fosite.Username)
+	require.Empty(t, storedSessionFromAuthcode.Fosite.Headers)
 
 	// The authcode that we are issuing should be good for the length of time that we declare in the fosite config.
-	testutil.RequireTimeInDelta(t, time.Now().Add(authCodeExpirationSeconds*time.Second), storedSessionFromAuthcode.ExpiresAt[fosite.AuthorizeCode], timeComparisonFudgeFactor)
-	require.Len(t, storedSessionFromAuthcode.ExpiresAt, 1)
+	testutil.RequireTimeInDelta(t, time.Now().Add(authCodeExpirationSeconds*time.Second), storedSessionFromAuthcode.Fosite.ExpiresAt[fosite.AuthorizeCode], timeComparisonFudgeFactor)
+	require.Len(t, storedSessionFromAuthcode.Fosite.ExpiresAt, 1)
+
+	// The stored session should remember which upstream was used, so the user can be checked again during refreshes.
+	require.Equal(t, wantCustomSessionData, storedSessionFromAuthcode.Custom)
 
 	// Now confirm the ID token claims.
-	actualClaims := storedSessionFromAuthcode.Claims
+	actualClaims := storedSessionFromAuthcode.Fosite.Claims
 
-	// Check the user's identity, which are put into the downstream ID token's subject, username and groups claims.
+	// Check the user's identity, which are put into the downstream ID token's subject, username and groups claims,
+	// and the additional claims, if any.
 	require.Equal(t, wantDownstreamIDTokenSubject, actualClaims.Subject)
 	require.Equal(t, wantDownstreamIDTokenUsername, actualClaims.Extra["username"])
-	require.Len(t, actualClaims.Extra, 2)
 	actualDownstreamIDTokenGroups := actualClaims.Extra["groups"]
 	require.NotNil(t, actualDownstreamIDTokenGroups)
 	require.ElementsMatch(t, wantDownstreamIDTokenGroups, actualDownstreamIDTokenGroups)
+	if len(wantDownstreamAdditionalClaims) > 0 {
+		require.Len(t, actualClaims.Extra, 4)
+		require.Equal(t, wantDownstreamAdditionalClaims, actualClaims.Extra["additionalClaims"])
+	} else {
+		require.Len(t, actualClaims.Extra, 3)
+	}
+
+	// The session ID claim identifies the request under which the tokens of the session will be stored.
+	require.Equal(t, storedRequestFromAuthcode.ID, actualClaims.Extra["sid"])
 
 	// Check the rest of the downstream ID token's claims. Fosite wants us to set these (in UTC time).
 	testutil.RequireTimeInDelta(t, time.Now().UTC(), actualClaims.RequestedAt, timeComparisonFudgeFactor)
@@ -415,7 +665,7 @@ func validatePKCEStorage(
 	oauthStore fositestoragei.AllFositeStorage,
 	storeKey string,
 	storedRequestFromAuthcode *fosite.Request,
-	storedSessionFromAuthcode *openid.DefaultSession,
+	storedSessionFromAuthcode *psession.PinnipedSession,
 	wantDownstreamPKCEChallenge, wantDownstreamPKCEChallengeMethod string,
 ) {
 	t.Helper()
@@ -440,7 +690,7 @@ func validateIDSessionStorage(
 	oauthStore fositestoragei.AllFositeStorage,
 	storeKey string,
 	storedRequestFromAuthcode *fosite.Request,
-	storedSessionFromAuthcode *openid.DefaultSession,
+	storedSessionFromAuthcode *psession.PinnipedSession,
 	wantDownstreamNonce string,
 ) {
 	t.Helper()
@@ -459,13 +709,13 @@ func validateIDSessionStorage(
 	require.Equal(t, wantDownstreamNonce, storedRequestFromIDSession.Form.Get("nonce"))
 }
 
-func castStoredAuthorizeRequest(t *testing.T, storedAuthorizeRequest fosite.Requester) (*fosite.Request, *openid.DefaultSession) {
+func castStoredAuthorizeRequest(t *testing.T, storedAuthorizeRequest fosite.Requester) (*fosite.Request, *psession.PinnipedSession) {
 	t.Helper()
 
 	storedRequest, ok := storedAuthorizeRequest.(*fosite.Request)
 	require.Truef(t, ok, "could not cast %T to %T", storedAuthorizeRequest, &fosite.Request{})
-	storedSession, ok := storedAuthorizeRequest.GetSession().(*openid.DefaultSession)
-	require.Truef(t, ok, "could not cast %T to %T", storedAuthorizeRequest.GetSession(), &openid.DefaultSession{})
+	storedSession, ok := storedAuthorizeRequest.GetSession().(*psession.PinnipedSession)
+	require.Truef(t, ok, "could not cast %T to %T", storedAuthorizeRequest.GetSession(), &psession.PinnipedSession{})
 
 	return storedRequest, storedSession
 }
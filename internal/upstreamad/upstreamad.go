@@ -0,0 +1,142 @@
+// Copyright 2021 the Pinniped contributors. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+// Package upstreamad contains functionality for interacting with an upstream Active Directory server using the
+// generic LDAP support in the upstreamldap package.
+package upstreamad
+
+import (
+	"encoding/binary"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/go-ldap/ldap/v3"
+)
+
+const (
+	// ObjectGUIDAttributeName is the name of the attribute which holds the binary GUID of an entry.
+	ObjectGUIDAttributeName = "objectGUID"
+
+	// SAMAccountNameAttributeName is the name of the attribute which holds the pre-Windows 2000 logon name of a
+	// user or group entry.
+	SAMAccountNameAttributeName = "sAMAccountName"
+
+	// UserPrincipalNameAttributeName is the name of the attribute which holds the user@domain logon name of a user.
+	UserPrincipalNameAttributeName = "userPrincipalName"
+
+	// UserAccountControlAttributeName is the name of the attribute which holds the bit flags describing the
+	// state of a user's account.
+	UserAccountControlAttributeName = "userAccountControl"
+
+	// MatchingRuleInChainOID is the OID of the LDAP_MATCHING_RULE_IN_CHAIN extensible match rule, which walks
+	// the chain of ancestry of an attribute's value, e.g. to find transitive group memberships.
+	MatchingRuleInChainOID = "1.2.840.113556.1.4.1941"
+
+	// accountDisabledFlag is the ADS_UF_ACCOUNTDISABLE bit of the userAccountControl attribute.
+	// See https://docs.microsoft.com/en-us/troubleshoot/windows-server/identity/useraccountcontrol-manipulate-account-properties.
+	accountDisabledFlag = 0x0002
+
+	// objectGUIDLength is the length of a binary objectGUID value in bytes.
+	objectGUIDLength = 16
+)
+
+const (
+	// DefaultUserSearchFilter finds users (but not computers) by any of their common logon names.
+	DefaultUserSearchFilter = "(&(objectClass=person)(!(objectClass=computer))(|(sAMAccountName={})(userPrincipalName={})))"
+
+	// DefaultUsernameAttributeName is the attribute which becomes the username of an authenticated user by default.
+	DefaultUsernameAttributeName = UserPrincipalNameAttributeName
+
+	// DefaultUIDAttributeName is the attribute which becomes the UID of an authenticated user by default.
+	DefaultUIDAttributeName = ObjectGUIDAttributeName
+
+	// DefaultGroupSearchFilter finds all groups of which the user is a member, including nested (transitive)
+	// group memberships.
+	DefaultGroupSearchFilter = "(&(objectClass=group)(member:" + MatchingRuleInChainOID + ":={}))"
+
+	// DefaultGroupNameAttributeName is the attribute which becomes a group name by default. When this attribute is
+	// used, group names are reported as sAMAccountName@domain.
+	DefaultGroupNameAttributeName = SAMAccountNameAttributeName
+)
+
+// ObjectGUIDAsString returns the objectGUID attribute of the entry as a canonical GUID string,
+// e.g. "04030201-0605-0807-0910-111213141516". Active Directory stores the first three components of the
+// GUID in little-endian byte order, so they must be reversed to produce the string form which is shown
+// by Microsoft tools.
+func ObjectGUIDAsString(entry *ldap.Entry) (string, error) {
+	values := entry.GetRawAttributeValues(ObjectGUIDAttributeName)
+	if len(values) != 1 {
+		return "", fmt.Errorf(`found %d values for attribute "%s", but expected 1 result`, len(values), ObjectGUIDAttributeName)
+	}
+	guid := values[0]
+	if len(guid) != objectGUIDLength {
+		return "", fmt.Errorf(`found value of length %d for attribute "%s", but expected a binary GUID of length %d`,
+			len(guid), ObjectGUIDAttributeName, objectGUIDLength)
+	}
+	return fmt.Sprintf("%08x-%04x-%04x-%x-%x",
+		binary.LittleEndian.Uint32(guid[0:4]),
+		binary.LittleEndian.Uint16(guid[4:6]),
+		binary.LittleEndian.Uint16(guid[6:8]),
+		guid[8:10],
+		guid[10:16],
+	), nil
+}
+
+// GroupSAMAccountNameWithDomainSuffix returns the sAMAccountName attribute of the group entry suffixed by
+// "@" and the DNS domain name of the group, which is derived from the DC components of the group's DN.
+// For example, the group "CN=Admins,OU=Groups,DC=example,DC=com" with sAMAccountName "Admins" becomes
+// "Admins@example.com". This disambiguates groups of the same name from different domains in a forest.
+func GroupSAMAccountNameWithDomainSuffix(entry *ldap.Entry) (string, error) {
+	values := entry.GetAttributeValues(SAMAccountNameAttributeName)
+	if len(values) != 1 {
+		return "", fmt.Errorf(`found %d values for attribute "%s" of group "%s", but expected 1 result`,
+			len(values), SAMAccountNameAttributeName, entry.DN)
+	}
+	if len(values[0]) == 0 {
+		return "", fmt.Errorf(`found empty value for attribute "%s" of group "%s", but expected value to be non-empty`,
+			SAMAccountNameAttributeName, entry.DN)
+	}
+
+	domain, err := domainFromDN(entry.DN)
+	if err != nil {
+		return "", err
+	}
+
+	return values[0] + "@" + domain, nil
+}
+
+// ValidUserAccountControl returns an error when the userAccountControl attribute of the user entry marks the
+// account as disabled, or when the attribute cannot be read.
+func ValidUserAccountControl(entry *ldap.Entry) error {
+	value := entry.GetAttributeValue(UserAccountControlAttributeName)
+	flags, err := strconv.ParseInt(value, 10, 64)
+	if err != nil {
+		return fmt.Errorf(`could not parse value %q of attribute "%s" as an integer: %w`, value, UserAccountControlAttributeName, err)
+	}
+	if flags&accountDisabledFlag != 0 {
+		return fmt.Errorf("user account is disabled")
+	}
+	return nil
+}
+
+func domainFromDN(distinguishedName string) (string, error) {
+	dn, err := ldap.ParseDN(distinguishedName)
+	if err != nil {
+		return "", fmt.Errorf(`error parsing DN "%s": %w`, distinguishedName, err)
+	}
+
+	domainComponents := []string{}
+	for _, rdn := range dn.RDNs {
+		for _, attribute := range rdn.Attributes {
+			if strings.EqualFold(attribute.Type, "DC") {
+				domainComponents = append(domainComponents, attribute.Value)
+			}
+		}
+	}
+	if len(domainComponents) == 0 {
+		return "", fmt.Errorf(`did not find any domain components in DN "%s"`, distinguishedName)
+	}
+
+	return strings.Join(domainComponents, "."), nil
+}
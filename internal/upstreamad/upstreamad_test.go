@@ -0,0 +1,190 @@
+// Copyright 2021 the Pinniped contributors. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package upstreamad
+
+import (
+	"testing"
+
+	"github.com/go-ldap/ldap/v3"
+	"github.com/stretchr/testify/require"
+)
+
+func TestObjectGUIDAsString(t *testing.T) {
+	tests := []struct {
+		name      string
+		entry     *ldap.Entry
+		want      string
+		wantError string
+	}{
+		{
+			name: "happy path",
+			entry: &ldap.Entry{
+				DN: "some-dn",
+				Attributes: []*ldap.EntryAttribute{
+					ldap.NewEntryAttribute("objectGUID", []string{
+						string([]byte{0x01, 0x02, 0x03, 0x04, 0x05, 0x06, 0x07, 0x08, 0x09, 0x0a, 0x0b, 0x0c, 0x0d, 0x0e, 0x0f, 0x10}),
+					}),
+				},
+			},
+			want: "04030201-0605-0807-090a-0b0c0d0e0f10",
+		},
+		{
+			name:      "missing attribute",
+			entry:     &ldap.Entry{DN: "some-dn"},
+			wantError: `found 0 values for attribute "objectGUID", but expected 1 result`,
+		},
+		{
+			name: "too many values",
+			entry: &ldap.Entry{
+				DN: "some-dn",
+				Attributes: []*ldap.EntryAttribute{
+					ldap.NewEntryAttribute("objectGUID", []string{"abcdefghijklmnop", "qrstuvwxyz012345"}),
+				},
+			},
+			wantError: `found 2 values for attribute "objectGUID", but expected 1 result`,
+		},
+		{
+			name: "wrong length",
+			entry: &ldap.Entry{
+				DN: "some-dn",
+				Attributes: []*ldap.EntryAttribute{
+					ldap.NewEntryAttribute("objectGUID", []string{"too-short"}),
+				},
+			},
+			wantError: `found value of length 9 for attribute "objectGUID", but expected a binary GUID of length 16`,
+		},
+	}
+	for _, test := range tests {
+		tt := test
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ObjectGUIDAsString(tt.entry)
+			if tt.wantError != "" {
+				require.EqualError(t, err, tt.wantError)
+				require.Empty(t, got)
+				return
+			}
+			require.NoError(t, err)
+			require.Equal(t, tt.want, got)
+		})
+	}
+}
+
+func TestGroupSAMAccountNameWithDomainSuffix(t *testing.T) {
+	tests := []struct {
+		name      string
+		entry     *ldap.Entry
+		want      string
+		wantError string
+	}{
+		{
+			name: "happy path",
+			entry: &ldap.Entry{
+				DN: "CN=Some Group,OU=Groups,DC=Example,dc=com",
+				Attributes: []*ldap.EntryAttribute{
+					ldap.NewEntryAttribute("sAMAccountName", []string{"some-group"}),
+				},
+			},
+			want: "some-group@Example.com",
+		},
+		{
+			name: "DN has no domain components",
+			entry: &ldap.Entry{
+				DN: "CN=Some Group,OU=Groups",
+				Attributes: []*ldap.EntryAttribute{
+					ldap.NewEntryAttribute("sAMAccountName", []string{"some-group"}),
+				},
+			},
+			wantError: `did not find any domain components in DN "CN=Some Group,OU=Groups"`,
+		},
+		{
+			name: "DN cannot be parsed",
+			entry: &ldap.Entry{
+				DN: "not-a-dn",
+				Attributes: []*ldap.EntryAttribute{
+					ldap.NewEntryAttribute("sAMAccountName", []string{"some-group"}),
+				},
+			},
+			wantError: `error parsing DN "not-a-dn": DN ended with incomplete type, value pair`,
+		},
+		{
+			name:      "missing attribute",
+			entry:     &ldap.Entry{DN: "CN=Some Group,DC=example,DC=com"},
+			wantError: `found 0 values for attribute "sAMAccountName" of group "CN=Some Group,DC=example,DC=com", but expected 1 result`,
+		},
+		{
+			name: "empty attribute",
+			entry: &ldap.Entry{
+				DN: "CN=Some Group,DC=example,DC=com",
+				Attributes: []*ldap.EntryAttribute{
+					ldap.NewEntryAttribute("sAMAccountName", []string{""}),
+				},
+			},
+			wantError: `found empty value for attribute "sAMAccountName" of group "CN=Some Group,DC=example,DC=com", but expected value to be non-empty`,
+		},
+	}
+	for _, test := range tests {
+		tt := test
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := GroupSAMAccountNameWithDomainSuffix(tt.entry)
+			if tt.wantError != "" {
+				require.EqualError(t, err, tt.wantError)
+				require.Empty(t, got)
+				return
+			}
+			require.NoError(t, err)
+			require.Equal(t, tt.want, got)
+		})
+	}
+}
+
+func TestValidUserAccountControl(t *testing.T) {
+	tests := []struct {
+		name      string
+		values    []string
+		wantError string
+	}{
+		{
+			name:   "normal account",
+			values: []string{"512"},
+		},
+		{
+			name:   "normal account whose password does not expire",
+			values: []string{"66048"},
+		},
+		{
+			name:      "disabled account",
+			values:    []string{"514"},
+			wantError: "user account is disabled",
+		},
+		{
+			name:      "disabled account whose password does not expire",
+			values:    []string{"66050"},
+			wantError: "user account is disabled",
+		},
+		{
+			name:      "missing attribute",
+			wantError: `could not parse value "" of attribute "userAccountControl" as an integer: strconv.ParseInt: parsing "": invalid syntax`,
+		},
+		{
+			name:      "non-integer attribute",
+			values:    []string{"abc"},
+			wantError: `could not parse value "abc" of attribute "userAccountControl" as an integer: strconv.ParseInt: parsing "abc": invalid syntax`,
+		},
+	}
+	for _, test := range tests {
+		tt := test
+		t.Run(tt.name, func(t *testing.T) {
+			entry := &ldap.Entry{DN: "some-dn"}
+			if tt.values != nil {
+				entry.Attributes = []*ldap.EntryAttribute{ldap.NewEntryAttribute("userAccountControl", tt.values)}
+			}
+			err := ValidUserAccountControl(entry)
+			if tt.wantError != "" {
+				require.EqualError(t, err, tt.wantError)
+				return
+			}
+			require.NoError(t, err)
+		})
+	}
+}
@@ -0,0 +1,371 @@
+// Copyright 2021 the Pinniped contributors. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package upstreamldap
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-ldap/ldap/v3"
+
+	"go.pinniped.dev/internal/plog"
+)
+
+const (
+	// DefaultConnectionPoolSize is the number of idle connections which are kept by each Provider in production.
+	DefaultConnectionPoolSize = 10
+
+	// idleConnectionTimeout is how long a pooled connection may be unused before it is closed.
+	idleConnectionTimeout = time.Minute
+
+	// unreachableHostBackoff is how long a host which could not be reached is only tried after all other hosts.
+	unreachableHostBackoff = 30 * time.Second
+
+	// srvRecordsTTL is how long the hosts which were discovered using DNS SRV records are used before they are
+	// looked up again.
+	srvRecordsTTL = time.Minute
+)
+
+// bindError means that a server was reached, but it did not allow the bind as the BindUsername.
+type bindError struct {
+	err error
+}
+
+func (e *bindError) Error() string { return e.err.Error() }
+
+func (e *bindError) Unwrap() error { return e.err }
+
+// isServerUnavailable returns true when an error means that a connection, or the server on the other end of it,
+// cannot be used anymore. Errors which are results of LDAP operations, like invalid credentials, do not mean that.
+func isServerUnavailable(err error) bool {
+	if err == nil {
+		return false
+	}
+	ldapErr := &ldap.Error{}
+	if !errors.As(err, &ldapErr) {
+		return true
+	}
+	switch ldapErr.ResultCode {
+	case ldap.LDAPResultBusy, ldap.LDAPResultUnavailable:
+		return true
+	default:
+		// The go-ldap library uses result codes starting at ErrorNetwork for errors which happen on the client side.
+		return ldapErr.ResultCode >= ldap.ErrorNetwork
+	}
+}
+
+// connections keeps the state which a Provider needs to share connections between operations and to decide
+// which hosts to connect to.
+type connections struct {
+	lock sync.Mutex
+
+	// idle is the stack of pooled connections, with the most recently used connection on top.
+	idle []*idleConnection
+
+	// unreachableHosts remembers when each host which could not be reached was last tried.
+	unreachableHosts map[string]time.Time
+
+	srvHosts        []string
+	srvHostsExpires time.Time
+}
+
+type idleConnection struct {
+	conn  Conn
+	timer *time.Timer
+
+	// needsRebind is true when the connection is no longer bound as the BindUsername, e.g. because an end user
+	// bind was performed on it.
+	needsRebind bool
+}
+
+func newConnections() *connections {
+	return &connections{unreachableHosts: map[string]time.Time{}}
+}
+
+// pooledConn is a connection which is bound as the BindUsername. Closing it returns it to the pool of its Provider,
+// unless it might be broken.
+type pooledConn struct {
+	Conn
+	p *Provider
+
+	// reused is true when the connection was taken from the pool instead of being dialed for this operation.
+	reused bool
+
+	broken      bool
+	needsRebind bool
+}
+
+func (c *pooledConn) Bind(username, password string) error {
+	err := c.Conn.Bind(username, password)
+	c.broken = c.broken || isServerUnavailable(err)
+	// A failed bind leaves the connection bound anonymously.
+	c.needsRebind = err != nil || c.p.usesClientCertificateBind() || username != c.p.c.BindUsername
+	return err
+}
+
+func (c *pooledConn) ExternalBind() error {
+	err := c.Conn.ExternalBind()
+	c.broken = c.broken || isServerUnavailable(err)
+	c.needsRebind = err != nil || !c.p.usesClientCertificateBind()
+	return err
+}
+
+func (c *pooledConn) Search(searchRequest *ldap.SearchRequest) (*ldap.SearchResult, error) {
+	result, err := c.Conn.Search(searchRequest)
+	c.broken = c.broken || isServerUnavailable(err)
+	return result, err
+}
+
+func (c *pooledConn) SearchWithPaging(searchRequest *ldap.SearchRequest, pagingSize uint32) (*ldap.SearchResult, error) {
+	result, err := c.Conn.SearchWithPaging(searchRequest, pagingSize)
+	c.broken = c.broken || isServerUnavailable(err)
+	return result, err
+}
+
+func (c *pooledConn) Close() {
+	if c.broken || !c.p.putIdleConnection(c.Conn, c.needsRebind) {
+		c.Conn.Close()
+	}
+}
+
+// withConnection calls f with a connection which is bound as the BindUsername, and then returns the connection to
+// the pool. When f fails because a connection from the pool turned out to be broken, e.g. because its server was
+// restarted while the connection was idle, then f is called once more with a new connection.
+func (p *Provider) withConnection(ctx context.Context, f func(conn Conn) error) error {
+	conn, err := p.connect(ctx, true)
+	if err != nil {
+		return err
+	}
+	err = f(conn)
+	conn.Close()
+	if err == nil || !conn.reused || !conn.broken {
+		return err
+	}
+
+	plog.DebugErr("retrying with a new connection because a pooled LDAP connection was broken", err, "upstreamName", p.GetName())
+	conn, err = p.connect(ctx, false)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	return f(conn)
+}
+
+// connect returns a connection which is bound as the BindUsername, preferably one from the pool.
+func (p *Provider) connect(ctx context.Context, allowReuse bool) (*pooledConn, error) {
+	if allowReuse {
+		if conn := p.getIdleConnection(); conn != nil {
+			return &pooledConn{Conn: conn, p: p, reused: true}, nil
+		}
+	}
+
+	conn, err := p.dialAndBind(ctx)
+	if err != nil {
+		var bindErr *bindError
+		if errors.As(err, &bindErr) {
+			return nil, fmt.Errorf(`error binding as "%s" before user search: %w`, p.c.BindUsername, bindErr.err)
+		}
+		return nil, err
+	}
+	return &pooledConn{Conn: conn, p: p}, nil
+}
+
+// getIdleConnection takes a connection from the pool and makes sure that it is bound as the BindUsername.
+// It returns nil when there is no usable connection in the pool.
+func (p *Provider) getIdleConnection() Conn {
+	for {
+		p.conns.lock.Lock()
+		if len(p.conns.idle) == 0 {
+			p.conns.lock.Unlock()
+			return nil
+		}
+		idle := p.conns.idle[len(p.conns.idle)-1]
+		p.conns.idle = p.conns.idle[:len(p.conns.idle)-1]
+		p.conns.lock.Unlock()
+
+		if !idle.timer.Stop() {
+			// The connection timed out and is being closed.
+			continue
+		}
+		if idle.needsRebind {
+			if err := p.bindAsServiceAccount(idle.conn); err != nil {
+				plog.DebugErr("could not bind pooled LDAP connection", err, "upstreamName", p.GetName())
+				idle.conn.Close()
+				continue
+			}
+		}
+		return idle.conn
+	}
+}
+
+// putIdleConnection adds a connection to the pool. It returns false when the pool is full.
+func (p *Provider) putIdleConnection(conn Conn, needsRebind bool) bool {
+	p.conns.lock.Lock()
+	defer p.conns.lock.Unlock()
+
+	if len(p.conns.idle) >= p.c.ConnectionPoolSize {
+		return false
+	}
+	idle := &idleConnection{conn: conn, needsRebind: needsRebind}
+	idle.timer = time.AfterFunc(idleConnectionTimeout, func() {
+		p.conns.lock.Lock()
+		for i := range p.conns.idle {
+			if p.conns.idle[i] == idle {
+				p.conns.idle = append(p.conns.idle[:i], p.conns.idle[i+1:]...)
+				break
+			}
+		}
+		p.conns.lock.Unlock()
+		conn.Close()
+	})
+	p.conns.idle = append(p.conns.idle, idle)
+	return true
+}
+
+// dialAndBind tries the hosts in turn until it can dial and bind as the BindUsername. The Host and then the
+// FailoverHosts are tried first, and then the hosts which are discovered using DNS SRV records. Hosts which recently
+// could not be reached are only tried after all other hosts. When no host can be used, it returns the error of
+// the last host which was tried.
+func (p *Provider) dialAndBind(ctx context.Context) (Conn, error) {
+	d := &hostDialer{p: p, tried: map[string]bool{}}
+
+	reachable, unreachable := p.partitionHosts(append([]string{p.c.Host}, p.c.FailoverHosts...))
+	if conn, done := d.tryHosts(ctx, reachable); done {
+		return conn, d.lastErr
+	}
+
+	if p.c.SRVDomain != "" {
+		// Only look up the SRV records when they are needed.
+		srvReachable, srvUnreachable := p.partitionHosts(p.srvHosts(ctx))
+		if conn, done := d.tryHosts(ctx, srvReachable); done {
+			return conn, d.lastErr
+		}
+		unreachable = append(unreachable, srvUnreachable...)
+	}
+
+	d.tryHosts(ctx, unreachable)
+	return d.conn, d.lastErr
+}
+
+// hostDialer remembers which hosts were already tried by one call to dialAndBind, and the last error.
+type hostDialer struct {
+	p       *Provider
+	tried   map[string]bool
+	conn    Conn
+	lastErr error
+}
+
+// tryHosts returns done=true when it could connect to a host, or when it should not try any other host.
+func (d *hostDialer) tryHosts(ctx context.Context, hosts []string) (Conn, bool) {
+	for _, host := range hosts {
+		if d.tried[host] {
+			continue
+		}
+		d.tried[host] = true
+		if d.lastErr != nil {
+			plog.WarningErr("could not connect to LDAP server, trying the next one", d.lastErr,
+				"upstreamName", d.p.GetName(), "nextHost", host)
+		}
+
+		conn, err := d.p.dial(ctx, host)
+		if err != nil {
+			d.lastErr = fmt.Errorf(`error dialing host "%s": %w`, host, err)
+			d.p.markHostUnreachable(host)
+			continue
+		}
+
+		err = d.p.bindAsServiceAccount(conn)
+		if err != nil {
+			conn.Close()
+			d.lastErr = &bindError{err: err}
+			if !isServerUnavailable(err) {
+				// Every server should have the same directory, so it is no use to try the other hosts.
+				return nil, true
+			}
+			d.p.markHostUnreachable(host)
+			continue
+		}
+
+		d.p.markHostReachable(host)
+		d.conn, d.lastErr = conn, nil
+		return conn, true
+	}
+	return nil, false
+}
+
+// partitionHosts splits the hosts into those which can be tried now and those which recently could not be
+// reached, keeping their order.
+func (p *Provider) partitionHosts(hosts []string) (reachable []string, unreachable []string) {
+	p.conns.lock.Lock()
+	defer p.conns.lock.Unlock()
+
+	for _, host := range hosts {
+		if lastFailure, ok := p.conns.unreachableHosts[host]; ok && time.Since(lastFailure) < unreachableHostBackoff {
+			unreachable = append(unreachable, host)
+			continue
+		}
+		reachable = append(reachable, host)
+	}
+	return reachable, unreachable
+}
+
+func (p *Provider) markHostUnreachable(host string) {
+	p.conns.lock.Lock()
+	defer p.conns.lock.Unlock()
+	p.conns.unreachableHosts[host] = time.Now()
+}
+
+func (p *Provider) markHostReachable(host string) {
+	p.conns.lock.Lock()
+	defer p.conns.lock.Unlock()
+	delete(p.conns.unreachableHosts, host)
+}
+
+// srvHosts returns the hosts which are listed by the "_ldap._tcp" DNS SRV records of the SRVDomain, in the order of
+// their priority and weight. When connecting with TLS, the LDAPS port of each host is used instead of the port from
+// its record, because the records advertise the LDAP port.
+func (p *Provider) srvHosts(ctx context.Context) []string {
+	p.conns.lock.Lock()
+	if time.Now().Before(p.conns.srvHostsExpires) {
+		defer p.conns.lock.Unlock()
+		return p.conns.srvHosts
+	}
+	p.conns.lock.Unlock()
+
+	lookupSRV := net.DefaultResolver.LookupSRV
+	if p.c.LookupSRV != nil {
+		lookupSRV = p.c.LookupSRV
+	}
+	_, records, err := lookupSRV(ctx, "ldap", "tcp", p.c.SRVDomain)
+	if err != nil {
+		// Do not remember the failure, so the lookup is tried again next time.
+		plog.WarningErr("could not look up DNS SRV records of LDAP servers", err, "upstreamName", p.GetName(), "domain", p.c.SRVDomain)
+		return nil
+	}
+
+	hosts := make([]string, 0, len(records))
+	for _, record := range records {
+		target := strings.TrimSuffix(record.Target, ".")
+		if target == "" {
+			continue // a target of "." means that the service is not available in this domain
+		}
+		port := record.Port
+		if p.c.ConnectionProtocol == TLS {
+			port = defaultLDAPSPort
+		}
+		hosts = append(hosts, net.JoinHostPort(target, strconv.Itoa(int(port))))
+	}
+
+	p.conns.lock.Lock()
+	defer p.conns.lock.Unlock()
+	p.conns.srvHosts = hosts
+	p.conns.srvHostsExpires = time.Now().Add(srvRecordsTTL)
+	return hosts
+}
@@ -0,0 +1,391 @@
+// Copyright 2021 the Pinniped contributors. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package upstreamldap
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/go-ldap/ldap/v3"
+	"github.com/golang/mock/gomock"
+	"github.com/stretchr/testify/require"
+
+	"go.pinniped.dev/internal/endpointaddr"
+	"go.pinniped.dev/internal/mocks/mockldapconn"
+)
+
+func TestFailover(t *testing.T) {
+	const (
+		failoverHost1 = "ldap1.example.com:8443"
+		failoverHost2 = "ldap2.example.com:8443"
+	)
+
+	networkError := ldap.NewError(ldap.ErrorNetwork, errors.New("connection refused"))
+
+	tests := []struct {
+		name       string
+		srvDomain  string
+		srvRecords []*net.SRV
+		srvError   error
+		protocol   LDAPConnectionProtocol
+		wantLookup bool
+		// setupMocks is called for each dialed host which does not have a dial error.
+		setupMocks func(host string, conn *mockldapconn.MockConn)
+		dialErrors map[string]error
+		wantDials  []string
+		wantError  string
+	}{
+		{
+			name: "uses the host when it can be reached",
+			setupMocks: func(host string, conn *mockldapconn.MockConn) {
+				conn.EXPECT().Bind(testBindUsername, testBindPassword).Times(1)
+				conn.EXPECT().Close().Times(1)
+			},
+			wantDials: []string{testHost},
+		},
+		{
+			name:       "tries the failover hosts in order when the host cannot be dialed",
+			dialErrors: map[string]error{testHost: networkError},
+			setupMocks: func(host string, conn *mockldapconn.MockConn) {
+				if host == failoverHost1 {
+					conn.EXPECT().Bind(testBindUsername, testBindPassword).Return(ldap.NewError(ldap.LDAPResultUnavailable, errors.New("shutting down"))).Times(1)
+					conn.EXPECT().Close().Times(1)
+					return
+				}
+				conn.EXPECT().Bind(testBindUsername, testBindPassword).Times(1)
+				conn.EXPECT().Close().Times(1)
+			},
+			wantDials: []string{testHost, failoverHost1, failoverHost2},
+		},
+		{
+			name: "does not try the failover hosts when the bind credentials are rejected",
+			setupMocks: func(host string, conn *mockldapconn.MockConn) {
+				conn.EXPECT().Bind(testBindUsername, testBindPassword).Return(ldap.NewError(ldap.LDAPResultInvalidCredentials, errors.New("bad password"))).Times(1)
+				conn.EXPECT().Close().Times(1)
+			},
+			wantDials: []string{testHost},
+			wantError: fmt.Sprintf(`error binding as "%s": LDAP Result Code 49 "Invalid Credentials": bad password`, testBindUsername),
+		},
+		{
+			name:       "returns the error of the last host when no host can be reached",
+			dialErrors: map[string]error{testHost: networkError, failoverHost1: networkError, failoverHost2: errors.New("some other error")},
+			wantDials:  []string{testHost, failoverHost1, failoverHost2},
+			wantError:  fmt.Sprintf(`error dialing host "%s": some other error`, failoverHost2),
+		},
+		{
+			name:      "tries the hosts from the SRV records after the failover hosts, using the LDAPS port for TLS",
+			srvDomain: "example.com",
+			srvRecords: []*net.SRV{
+				{Target: "dc1.example.com.", Port: 389},
+				{Target: "dc2.example.com.", Port: 389},
+			},
+			protocol:   TLS,
+			dialErrors: map[string]error{testHost: networkError, failoverHost1: networkError, failoverHost2: networkError, "dc1.example.com:636": networkError},
+			wantLookup: true,
+			setupMocks: func(host string, conn *mockldapconn.MockConn) {
+				conn.EXPECT().Bind(testBindUsername, testBindPassword).Times(1)
+				conn.EXPECT().Close().Times(1)
+			},
+			wantDials: []string{testHost, failoverHost1, failoverHost2, "dc1.example.com:636", "dc2.example.com:636"},
+		},
+		{
+			name:      "uses the port from the SRV records for StartTLS",
+			srvDomain: "example.com",
+			srvRecords: []*net.SRV{
+				{Target: "dc1.example.com.", Port: 1389},
+			},
+			protocol:   StartTLS,
+			dialErrors: map[string]error{testHost: networkError, failoverHost1: networkError, failoverHost2: networkError},
+			wantLookup: true,
+			setupMocks: func(host string, conn *mockldapconn.MockConn) {
+				conn.EXPECT().Bind(testBindUsername, testBindPassword).Times(1)
+				conn.EXPECT().Close().Times(1)
+			},
+			wantDials: []string{testHost, failoverHost1, failoverHost2, "dc1.example.com:1389"},
+		},
+		{
+			name:      "does not look up the SRV records when the host can be reached",
+			srvDomain: "example.com",
+			setupMocks: func(host string, conn *mockldapconn.MockConn) {
+				conn.EXPECT().Bind(testBindUsername, testBindPassword).Times(1)
+				conn.EXPECT().Close().Times(1)
+			},
+			wantDials: []string{testHost},
+		},
+		{
+			name:       "ignores SRV lookup errors",
+			srvDomain:  "example.com",
+			srvError:   errors.New("some DNS error"),
+			dialErrors: map[string]error{testHost: networkError, failoverHost1: networkError, failoverHost2: errors.New("some other error")},
+			wantLookup: true,
+			wantDials:  []string{testHost, failoverHost1, failoverHost2},
+			wantError:  fmt.Sprintf(`error dialing host "%s": some other error`, failoverHost2),
+		},
+	}
+	for _, test := range tests {
+		tt := test
+		t.Run(tt.name, func(t *testing.T) {
+			ctrl := gomock.NewController(t)
+			t.Cleanup(ctrl.Finish)
+
+			protocol := tt.protocol
+			if protocol == "" {
+				protocol = TLS
+			}
+
+			var dials []string
+			lookedUp := false
+			p := New(ProviderConfig{
+				Name:               "some-provider-name",
+				Host:               testHost,
+				FailoverHosts:      []string{failoverHost1, failoverHost2},
+				SRVDomain:          tt.srvDomain,
+				ConnectionProtocol: protocol,
+				BindUsername:       testBindUsername,
+				BindPassword:       testBindPassword,
+				Dialer: LDAPDialerFunc(func(ctx context.Context, addr endpointaddr.HostPort) (Conn, error) {
+					dials = append(dials, addr.Endpoint())
+					if err := tt.dialErrors[addr.Endpoint()]; err != nil {
+						return nil, err
+					}
+					conn := mockldapconn.NewMockConn(ctrl)
+					tt.setupMocks(addr.Endpoint(), conn)
+					return conn, nil
+				}),
+				LookupSRV: func(ctx context.Context, service, proto, name string) (string, []*net.SRV, error) {
+					require.Equal(t, "ldap", service)
+					require.Equal(t, "tcp", proto)
+					require.Equal(t, tt.srvDomain, name)
+					lookedUp = true
+					return "", tt.srvRecords, tt.srvError
+				},
+			})
+
+			err := p.TestConnection(context.Background())
+			if tt.wantError != "" {
+				require.EqualError(t, err, tt.wantError)
+			} else {
+				require.NoError(t, err)
+			}
+			require.Equal(t, tt.wantDials, dials)
+			require.Equal(t, tt.wantLookup, lookedUp)
+		})
+	}
+}
+
+func TestFailoverRemembersUnreachableHosts(t *testing.T) {
+	const failoverHost = "ldap1.example.com:8443"
+
+	ctrl := gomock.NewController(t)
+	t.Cleanup(ctrl.Finish)
+
+	hostIsDown := true
+	var dials []string
+	p := New(ProviderConfig{
+		Name:               "some-provider-name",
+		Host:               testHost,
+		FailoverHosts:      []string{failoverHost},
+		ConnectionProtocol: TLS,
+		BindUsername:       testBindUsername,
+		BindPassword:       testBindPassword,
+		Dialer: LDAPDialerFunc(func(ctx context.Context, addr endpointaddr.HostPort) (Conn, error) {
+			dials = append(dials, addr.Endpoint())
+			if addr.Endpoint() == testHost && hostIsDown {
+				return nil, ldap.NewError(ldap.ErrorNetwork, errors.New("connection refused"))
+			}
+			conn := mockldapconn.NewMockConn(ctrl)
+			conn.EXPECT().Bind(testBindUsername, testBindPassword).Times(1)
+			conn.EXPECT().Close().Times(1)
+			return conn, nil
+		}),
+	})
+
+	require.NoError(t, p.TestConnection(context.Background()))
+	require.Equal(t, []string{testHost, failoverHost}, dials)
+
+	// The host which could not be reached is not tried first anymore.
+	dials = nil
+	require.NoError(t, p.TestConnection(context.Background()))
+	require.Equal(t, []string{failoverHost}, dials)
+
+	// After the backoff, the host is tried first again.
+	p.conns.unreachableHosts[testHost] = time.Now().Add(-unreachableHostBackoff)
+	hostIsDown = false
+	dials = nil
+	require.NoError(t, p.TestConnection(context.Background()))
+	require.Equal(t, []string{testHost}, dials)
+}
+
+func TestConnectionPool(t *testing.T) {
+	const userDN = "some-user-dn"
+
+	// Both the user search and the refresh search find this user.
+	userSearch := func(conn *mockldapconn.MockConn) *gomock.Call {
+		return conn.EXPECT().Search(gomock.Any()).Return(&ldap.SearchResult{Entries: []*ldap.Entry{{
+			DN: userDN,
+			Attributes: []*ldap.EntryAttribute{
+				ldap.NewEntryAttribute(testUserSearchUsernameAttribute, []string{testUserSearchResultUsernameAttributeValue}),
+				ldap.NewEntryAttribute(testUserSearchUIDAttribute, []string{testUserSearchResultUIDAttributeValue}),
+			},
+		}}}, nil)
+	}
+
+	newProvider := func(t *testing.T, poolSize int, conns ...*mockldapconn.MockConn) (*Provider, *int) {
+		dials := 0
+		p := New(ProviderConfig{
+			Name:               "some-provider-name",
+			Host:               testHost,
+			ConnectionProtocol: TLS,
+			BindUsername:       testBindUsername,
+			BindPassword:       testBindPassword,
+			UserSearch: UserSearchConfig{
+				Base:              testUserSearchBase,
+				UsernameAttribute: testUserSearchUsernameAttribute,
+				UIDAttribute:      testUserSearchUIDAttribute,
+			},
+			ConnectionPoolSize: poolSize,
+			Dialer: LDAPDialerFunc(func(ctx context.Context, addr endpointaddr.HostPort) (Conn, error) {
+				require.Less(t, dials, len(conns), "too many dials")
+				dials++
+				return conns[dials-1], nil
+			}),
+		})
+		t.Cleanup(func() {
+			// Close the pooled connections now instead of when they time out.
+			for conn := p.getIdleConnection(); conn != nil; conn = p.getIdleConnection() {
+				conn.Close()
+			}
+		})
+		return p, &dials
+	}
+
+	t.Run("reuses the connection and binds it as the bind user again after an end user bind", func(t *testing.T) {
+		ctrl := gomock.NewController(t)
+		t.Cleanup(ctrl.Finish)
+
+		conn := mockldapconn.NewMockConn(ctrl)
+		gomock.InOrder(
+			conn.EXPECT().Bind(testBindUsername, testBindPassword),
+			userSearch(conn),
+			conn.EXPECT().Bind(userDN, testUpstreamPassword),
+			conn.EXPECT().Bind(testBindUsername, testBindPassword),
+			userSearch(conn),
+			conn.EXPECT().Bind(userDN, testUpstreamPassword),
+			conn.EXPECT().Bind(testBindUsername, testBindPassword),
+			userSearch(conn),
+			userSearch(conn), // no bind is needed after a refresh
+			conn.EXPECT().Close(),
+		)
+
+		p, dials := newProvider(t, 1, conn)
+		for i := 0; i < 2; i++ {
+			_, authenticated, err := p.AuthenticateUser(context.Background(), testUpstreamUsername, testUpstreamPassword)
+			require.NoError(t, err)
+			require.True(t, authenticated)
+		}
+		for i := 0; i < 2; i++ {
+			_, ok, err := p.PerformRefresh(context.Background(), userDN)
+			require.NoError(t, err)
+			require.True(t, ok)
+		}
+		require.Equal(t, 1, *dials)
+	})
+
+	t.Run("keeps at most the pool size of idle connections", func(t *testing.T) {
+		ctrl := gomock.NewController(t)
+		t.Cleanup(ctrl.Finish)
+
+		conn1 := mockldapconn.NewMockConn(ctrl)
+		conn1.EXPECT().Bind(testBindUsername, testBindPassword)
+		userSearch(conn1)
+		conn1.EXPECT().Close()
+		conn2 := mockldapconn.NewMockConn(ctrl)
+		conn2.EXPECT().Bind(testBindUsername, testBindPassword)
+		userSearch(conn2)
+		conn2.EXPECT().Close()
+
+		p, _ := newProvider(t, 1, conn1, conn2)
+
+		// Use two connections at the same time, so both are returned to the pool.
+		err := p.withConnection(context.Background(), func(conn Conn) error {
+			_, ok, err := p.PerformRefresh(context.Background(), userDN)
+			require.NoError(t, err)
+			require.True(t, ok)
+			_, err = conn.Search(&ldap.SearchRequest{})
+			return err
+		})
+		require.NoError(t, err)
+		require.Len(t, p.conns.idle, 1)
+	})
+
+	t.Run("retries with a new connection when the pooled connection is broken", func(t *testing.T) {
+		ctrl := gomock.NewController(t)
+		t.Cleanup(ctrl.Finish)
+
+		conn1 := mockldapconn.NewMockConn(ctrl)
+		gomock.InOrder(
+			conn1.EXPECT().Bind(testBindUsername, testBindPassword),
+			userSearch(conn1),
+			conn1.EXPECT().Search(gomock.Any()).Return(nil, ldap.NewError(ldap.ErrorNetwork, errors.New("connection reset"))),
+			conn1.EXPECT().Close(),
+		)
+		conn2 := mockldapconn.NewMockConn(ctrl)
+		gomock.InOrder(
+			conn2.EXPECT().Bind(testBindUsername, testBindPassword),
+			userSearch(conn2),
+			conn2.EXPECT().Close(),
+		)
+
+		p, dials := newProvider(t, 1, conn1, conn2)
+		for i := 0; i < 2; i++ {
+			_, ok, err := p.PerformRefresh(context.Background(), userDN)
+			require.NoError(t, err)
+			require.True(t, ok)
+		}
+		require.Equal(t, 2, *dials)
+	})
+
+	t.Run("does not retry when a new connection is broken", func(t *testing.T) {
+		ctrl := gomock.NewController(t)
+		t.Cleanup(ctrl.Finish)
+
+		conn := mockldapconn.NewMockConn(ctrl)
+		gomock.InOrder(
+			conn.EXPECT().Bind(testBindUsername, testBindPassword),
+			conn.EXPECT().Search(gomock.Any()).Return(nil, ldap.NewError(ldap.ErrorNetwork, errors.New("connection reset"))),
+			conn.EXPECT().Close(),
+		)
+
+		p, dials := newProvider(t, 1, conn)
+		_, ok, err := p.PerformRefresh(context.Background(), userDN)
+		require.EqualError(t, err, `error searching for user with DN "some-user-dn": LDAP Result Code 200 "Network Error": connection reset`)
+		require.False(t, ok)
+		require.Equal(t, 1, *dials)
+		require.Empty(t, p.conns.idle)
+	})
+
+	t.Run("closes idle connections after the idle timeout", func(t *testing.T) {
+		ctrl := gomock.NewController(t)
+		t.Cleanup(ctrl.Finish)
+
+		closed := make(chan struct{})
+		conn := mockldapconn.NewMockConn(ctrl)
+		conn.EXPECT().Close().Do(func() { close(closed) })
+
+		p, _ := newProvider(t, 1)
+		require.True(t, p.putIdleConnection(conn, false))
+		p.conns.idle[0].timer.Reset(time.Millisecond)
+
+		<-closed
+		require.Eventually(t, func() bool {
+			p.conns.lock.Lock()
+			defer p.conns.lock.Unlock()
+			return len(p.conns.idle) == 0
+		}, time.Second, 10*time.Millisecond)
+	})
+}
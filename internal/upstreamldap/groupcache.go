@@ -0,0 +1,97 @@
+// Copyright 2021 the Pinniped contributors. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package upstreamldap
+
+import (
+	"strings"
+	"sync"
+	"time"
+
+	"k8s.io/apimachinery/pkg/util/clock"
+)
+
+// DefaultGroupSearchCacheMaxEntries is the maximum number of users whose groups are cached, when not configured.
+const DefaultGroupSearchCacheMaxEntries = 1000
+
+// GroupSearchCache remembers the groups which were found for each user for a while, so that the groups do not need
+// to be searched for again when the same user logs in or refreshes their session soon after. It is safe for
+// concurrent use. A GroupSearchCache is meant to be shared by the Providers which are created for the same
+// upstream, so that it is kept while the upstream's configuration does not change.
+type GroupSearchCache struct {
+	ttl        time.Duration
+	maxEntries int
+	clock      clock.Clock
+
+	lock    sync.Mutex
+	entries map[string]groupSearchCacheEntry
+}
+
+type groupSearchCacheEntry struct {
+	groups  []string
+	expires time.Time
+}
+
+// NewGroupSearchCache returns an empty GroupSearchCache. When maxEntries is not positive,
+// DefaultGroupSearchCacheMaxEntries is used.
+func NewGroupSearchCache(ttl time.Duration, maxEntries int) *GroupSearchCache {
+	if maxEntries <= 0 {
+		maxEntries = DefaultGroupSearchCacheMaxEntries
+	}
+	return &GroupSearchCache{
+		ttl:        ttl,
+		maxEntries: maxEntries,
+		clock:      clock.RealClock{},
+		entries:    map[string]groupSearchCacheEntry{},
+	}
+}
+
+// get returns the groups which were cached for the user, and whether they were found and not yet expired.
+func (c *GroupSearchCache) get(userDN string) ([]string, bool) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	key := strings.ToLower(userDN)
+	entry, ok := c.entries[key]
+	if !ok {
+		return nil, false
+	}
+	if !c.clock.Now().Before(entry.expires) {
+		delete(c.entries, key)
+		return nil, false
+	}
+	return append([]string{}, entry.groups...), true
+}
+
+// put caches the groups of the user. When the cache is full, it first removes the expired entries, and then the
+// entries which would expire soonest, until there is room for the new entry.
+func (c *GroupSearchCache) put(userDN string, groups []string) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	key := strings.ToLower(userDN)
+	now := c.clock.Now()
+	if _, ok := c.entries[key]; !ok && len(c.entries) >= c.maxEntries {
+		for k, entry := range c.entries {
+			if !now.Before(entry.expires) {
+				delete(c.entries, k)
+			}
+		}
+		for len(c.entries) >= c.maxEntries {
+			c.deleteSoonestExpiringEntry()
+		}
+	}
+	c.entries[key] = groupSearchCacheEntry{groups: append([]string{}, groups...), expires: now.Add(c.ttl)}
+}
+
+func (c *GroupSearchCache) deleteSoonestExpiringEntry() {
+	var soonestKey string
+	var soonest time.Time
+	found := false
+	for k, entry := range c.entries {
+		if !found || entry.expires.Before(soonest) {
+			soonestKey, soonest, found = k, entry.expires, true
+		}
+	}
+	delete(c.entries, soonestKey)
+}
@@ -0,0 +1,108 @@
+// Copyright 2021 the Pinniped contributors. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package upstreamldap
+
+import (
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-ldap/ldap/v3"
+	"github.com/golang/mock/gomock"
+	"github.com/stretchr/testify/require"
+	"k8s.io/apimachinery/pkg/util/clock"
+	"k8s.io/component-base/metrics/legacyregistry"
+	"k8s.io/component-base/metrics/testutil"
+
+	"go.pinniped.dev/internal/mocks/mockldapconn"
+)
+
+func TestGroupSearchCache(t *testing.T) {
+	fakeClock := clock.NewFakeClock(time.Now())
+	cache := NewGroupSearchCache(time.Minute, 2)
+	cache.clock = fakeClock
+
+	_, ok := cache.get("cn=user1")
+	require.False(t, ok)
+
+	groups := []string{"a", "b"}
+	cache.put("cn=user1", groups)
+	groups[0] = "changed by caller"
+	got, ok := cache.get("CN=User1")
+	require.True(t, ok, "user DNs should be case-insensitive")
+	require.Equal(t, []string{"a", "b"}, got)
+
+	fakeClock.Step(30 * time.Second)
+	cache.put("cn=user2", []string{"c"})
+	fakeClock.Step(10 * time.Second)
+	cache.put("cn=user3", []string{"d"})
+	_, ok = cache.get("cn=user1")
+	require.False(t, ok, "the entry which would expire soonest should have been removed to make room")
+	got, ok = cache.get("cn=user2")
+	require.True(t, ok)
+	require.Equal(t, []string{"c"}, got)
+
+	fakeClock.Step(20 * time.Second)
+	cache.put("cn=user2", []string{"e"})
+	got, ok = cache.get("cn=user2")
+	require.True(t, ok, "replacing an entry should not remove another entry")
+	require.Equal(t, []string{"e"}, got)
+	_, ok = cache.get("cn=user3")
+	require.True(t, ok)
+
+	fakeClock.Step(time.Minute)
+	_, ok = cache.get("cn=user2")
+	require.False(t, ok, "entries should expire after the TTL")
+
+	require.Equal(t, DefaultGroupSearchCacheMaxEntries, NewGroupSearchCache(time.Minute, 0).maxEntries)
+}
+
+func TestGroupsOfUserUsesGroupSearchCache(t *testing.T) {
+	const (
+		userDN  = "cn=cached-user,ou=users,dc=pinniped,dc=dev"
+		idpName = "some-cached-ldap-idp"
+	)
+
+	ctrl := gomock.NewController(t)
+	t.Cleanup(ctrl.Finish)
+	conn := mockldapconn.NewMockConn(ctrl)
+
+	cache := NewGroupSearchCache(time.Minute, 0)
+	p := New(ProviderConfig{
+		Name: idpName,
+		GroupSearch: GroupSearchConfig{
+			Base:  testGroupSearchBase,
+			Cache: cache,
+		},
+	})
+	userEntry := &ldap.Entry{DN: userDN}
+	groupSearchResult := &ldap.SearchResult{Entries: []*ldap.Entry{{DN: "cn=group1"}, {DN: "cn=group2"}}}
+
+	gomock.InOrder(
+		conn.EXPECT().SearchWithPaging(gomock.Any(), expectedGroupSearchPageSize).Return(nil, errors.New("some search error")),
+		conn.EXPECT().SearchWithPaging(gomock.Any(), expectedGroupSearchPageSize).Return(groupSearchResult, nil),
+	)
+
+	// Errors are not cached.
+	_, err := p.groupsOfUser(conn, userEntry)
+	require.EqualError(t, err, `error searching for group memberships for user with DN "`+userDN+`": some search error`)
+
+	groups, err := p.groupsOfUser(conn, userEntry)
+	require.NoError(t, err)
+	require.Equal(t, []string{"cn=group1", "cn=group2"}, groups)
+
+	// The second lookup is served from the cache without searching again, even by a new Provider with the same cache.
+	groups, err = New(p.GetConfig()).groupsOfUser(conn, userEntry)
+	require.NoError(t, err)
+	require.Equal(t, []string{"cn=group1", "cn=group2"}, groups)
+
+	err = testutil.GatherAndCompare(legacyregistry.DefaultGatherer, strings.NewReader(`
+		# HELP pinniped_supervisor_ldap_group_search_cache_lookups_total [ALPHA] Number of lookups in the group search caches of upstream LDAP identity providers, by identity provider and result.
+		# TYPE pinniped_supervisor_ldap_group_search_cache_lookups_total counter
+		pinniped_supervisor_ldap_group_search_cache_lookups_total{idp_name="some-cached-ldap-idp",result="hit"} 1
+		pinniped_supervisor_ldap_group_search_cache_lookups_total{idp_name="some-cached-ldap-idp",result="miss"} 2
+	`), "pinniped_supervisor_ldap_group_search_cache_lookups_total")
+	require.NoError(t, err)
+}
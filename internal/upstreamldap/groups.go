@@ -0,0 +1,210 @@
+// Copyright 2021 the Pinniped contributors. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package upstreamldap
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/go-ldap/ldap/v3"
+
+	"go.pinniped.dev/internal/metrics"
+	"go.pinniped.dev/internal/upstreamad"
+)
+
+// GroupSearchMode determines how the groups of a user are found.
+type GroupSearchMode string
+
+const (
+	// GroupSearchModeSearch searches for the groups which have the user as a member.
+	GroupSearchModeSearch = GroupSearchMode("Search")
+
+	// GroupSearchModeUserAttribute reads the DNs of the user's groups from an attribute of the user's entry.
+	GroupSearchModeUserAttribute = GroupSearchMode("UserAttribute")
+
+	// GroupSearchModeInChainSearch searches for the groups which have the user as a direct or nested member using
+	// the LDAP_MATCHING_RULE_IN_CHAIN matching rule, so the server resolves nested groups.
+	GroupSearchModeInChainSearch = GroupSearchMode("InChainSearch")
+
+	defaultInChainGroupSearchFilter = "member:" + upstreamad.MatchingRuleInChainOID + ":={}"
+	defaultUserAttributeForGroups   = "memberOf"
+
+	// nestedGroupSearchBatchSize limits how many groups are looked up by each search for the groups which contain them.
+	nestedGroupSearchBatchSize = 50
+)
+
+// groupsOfUser finds the groups of the user according to the GroupSearch configuration, and maps them to group names.
+// It returns an empty list when group search is not configured. When the GroupSearch has a Cache, then the groups
+// which were recently found for the same user are returned instead of searching again.
+func (p *Provider) groupsOfUser(conn Conn, userEntry *ldap.Entry) ([]string, error) {
+	cache := p.c.GroupSearch.Cache
+	if cache == nil {
+		return p.searchGroupsOfUser(conn, userEntry)
+	}
+
+	if groups, ok := cache.get(userEntry.DN); ok {
+		metrics.RecordLDAPGroupSearchCacheLookup(p.GetName(), true)
+		return groups, nil
+	}
+	metrics.RecordLDAPGroupSearchCacheLookup(p.GetName(), false)
+
+	groups, err := p.searchGroupsOfUser(conn, userEntry)
+	if err != nil {
+		return nil, err
+	}
+	cache.put(userEntry.DN, groups)
+	return groups, nil
+}
+
+func (p *Provider) searchGroupsOfUser(conn Conn, userEntry *ldap.Entry) ([]string, error) {
+	switch p.c.GroupSearch.Mode {
+	case GroupSearchModeUserAttribute:
+		return p.groupsFromUserAttribute(conn, userEntry)
+	case "", GroupSearchModeSearch, GroupSearchModeInChainSearch:
+		if len(p.c.GroupSearch.Base) == 0 {
+			return []string{}, nil
+		}
+		return p.searchGroupsForUserDN(conn, userEntry.DN)
+	default:
+		return nil, fmt.Errorf("unknown group search mode %q", p.c.GroupSearch.Mode)
+	}
+}
+
+func (p *Provider) userAttributeForGroups() string {
+	if len(p.c.GroupSearch.UserAttributeForGroups) == 0 {
+		return defaultUserAttributeForGroups
+	}
+	return p.c.GroupSearch.UserAttributeForGroups
+}
+
+func (p *Provider) groupNameAttribute() string {
+	if len(p.c.GroupSearch.GroupNameAttribute) == 0 {
+		return distinguishedNameAttributeName
+	}
+	return p.c.GroupSearch.GroupNameAttribute
+}
+
+// mapGroupEntry returns the group name of a group entry.
+func (p *Provider) mapGroupEntry(groupEntry *ldap.Entry, userDN string) (string, error) {
+	groupAttributeName := p.groupNameAttribute()
+	if overrideFunc := p.c.GroupAttributeParsingOverrides[groupAttributeName]; overrideFunc != nil {
+		return overrideFunc(groupEntry)
+	}
+	return p.getSearchResultAttributeValue(groupAttributeName, groupEntry, userDN)
+}
+
+// searchNestedGroups searches for the groups which contain the given groups, and then for the groups which contain
+// those, and so on, up to the MaxNestingDepth. The seen map contains the lowercase DNs of all groups which were
+// already found, and it is used to skip groups which were found before, so cycles of groups do not cause loops.
+// It returns the entries of the newly found groups.
+func (p *Provider) searchNestedGroups(conn Conn, groupDNs []string, seen map[string]bool, userDN string) ([]*ldap.Entry, error) {
+	var found []*ldap.Entry
+	for depth := 0; depth < p.c.GroupSearch.MaxNestingDepth && len(groupDNs) > 0; depth++ {
+		var nextGroupDNs []string
+		for start := 0; start < len(groupDNs); start += nestedGroupSearchBatchSize {
+			end := start + nestedGroupSearchBatchSize
+			if end > len(groupDNs) {
+				end = len(groupDNs)
+			}
+			searchResult, err := conn.SearchWithPaging(p.nestedGroupSearchRequest(groupDNs[start:end]), groupSearchPageSize)
+			if err != nil {
+				return nil, fmt.Errorf(`error searching for nested group memberships for user with DN %q: %w`, userDN, err)
+			}
+			for _, groupEntry := range searchResult.Entries {
+				if len(groupEntry.DN) == 0 {
+					return nil, fmt.Errorf(`searching for nested group memberships for user with DN %q resulted in search result without DN`, userDN)
+				}
+				if seen[strings.ToLower(groupEntry.DN)] {
+					continue
+				}
+				seen[strings.ToLower(groupEntry.DN)] = true
+				found = append(found, groupEntry)
+				nextGroupDNs = append(nextGroupDNs, groupEntry.DN)
+			}
+		}
+		groupDNs = nextGroupDNs
+	}
+	return found, nil
+}
+
+// groupsFromUserAttribute reads the DNs of the user's groups from an attribute of their entry. When the groups
+// should be nested, or when the group names are not their DNs, then it also reads the entries of the groups.
+func (p *Provider) groupsFromUserAttribute(conn Conn, userEntry *ldap.Entry) ([]string, error) {
+	groupDNs := userEntry.GetAttributeValues(p.userAttributeForGroups())
+	if p.c.GroupSearch.MaxNestingDepth == 0 && p.groupNameAttribute() == distinguishedNameAttributeName {
+		return append([]string{}, groupDNs...), nil
+	}
+
+	seen := map[string]bool{}
+	groups := []string{}
+	for depth := 0; len(groupDNs) > 0; depth++ {
+		var nextGroupDNs []string
+		for _, groupDN := range groupDNs {
+			if seen[strings.ToLower(groupDN)] {
+				continue
+			}
+			seen[strings.ToLower(groupDN)] = true
+
+			groupEntry, err := p.readGroupEntry(conn, groupDN, userEntry.DN)
+			if err != nil {
+				return nil, err
+			}
+			if groupEntry == nil {
+				continue // the attribute refers to a group which does not exist anymore
+			}
+			groupName, err := p.mapGroupEntry(groupEntry, userEntry.DN)
+			if err != nil {
+				return nil, fmt.Errorf(`error searching for group memberships for user with DN %q: %w`, userEntry.DN, err)
+			}
+			groups = append(groups, groupName)
+			if depth < p.c.GroupSearch.MaxNestingDepth {
+				nextGroupDNs = append(nextGroupDNs, groupEntry.GetAttributeValues(p.userAttributeForGroups())...)
+			}
+		}
+		groupDNs = nextGroupDNs
+	}
+	return groups, nil
+}
+
+// readGroupEntry reads the entry of a group by its DN. It returns nil when the group does not exist.
+func (p *Provider) readGroupEntry(conn Conn, groupDN string, userDN string) (*ldap.Entry, error) {
+	attributes := p.groupSearchRequestedAttributes()
+	if p.c.GroupSearch.MaxNestingDepth > 0 {
+		attributes = append(attributes, p.userAttributeForGroups())
+	}
+	searchResult, err := conn.Search(&ldap.SearchRequest{
+		BaseDN:       groupDN,
+		Scope:        ldap.ScopeBaseObject,
+		DerefAliases: ldap.NeverDerefAliases,
+		SizeLimit:    2,
+		TimeLimit:    90,
+		TypesOnly:    false,
+		Filter:       "(objectClass=*)", // the base object search already selects exactly one entry
+		Attributes:   attributes,
+		Controls:     nil, // nil because only one entry can be returned
+	})
+	if err != nil {
+		ldapErr := &ldap.Error{}
+		if errors.As(err, &ldapErr) && ldapErr.ResultCode == ldap.LDAPResultNoSuchObject {
+			return nil, nil
+		}
+		return nil, fmt.Errorf(`error reading group with DN %q for user with DN %q: %w`, groupDN, userDN, err)
+	}
+	if len(searchResult.Entries) != 1 {
+		return nil, fmt.Errorf(`reading group with DN %q for user with DN %q resulted in %d search results, but expected 1 result`,
+			groupDN, userDN, len(searchResult.Entries))
+	}
+	return searchResult.Entries[0], nil
+}
+
+func (p *Provider) nestedGroupSearchRequest(groupDNs []string) *ldap.SearchRequest {
+	filters := make([]string, 0, len(groupDNs))
+	for _, groupDN := range groupDNs {
+		filters = append(filters, p.groupSearchFilter(ldap.EscapeFilter(groupDN)))
+	}
+	request := p.groupSearchRequest("")
+	request.Filter = "(|" + strings.Join(filters, "") + ")"
+	return request
+}
@@ -0,0 +1,209 @@
+// Copyright 2021 the Pinniped contributors. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package upstreamldap
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/go-ldap/ldap/v3"
+	"github.com/golang/mock/gomock"
+	"github.com/stretchr/testify/require"
+
+	"go.pinniped.dev/internal/mocks/mockldapconn"
+)
+
+func TestGroupsOfUser(t *testing.T) {
+	const (
+		userDN    = "cn=pinny,ou=users,dc=pinniped,dc=dev"
+		group1DN  = "cn=group1,ou=groups,dc=pinniped,dc=dev"
+		group2DN  = "cn=group2,ou=groups,dc=pinniped,dc=dev"
+		group3DN  = "cn=group3 (nested),ou=groups,dc=pinniped,dc=dev"
+		group4DN  = "cn=group4,ou=groups,dc=pinniped,dc=dev"
+		missingDN = "cn=deleted,ou=groups,dc=pinniped,dc=dev"
+	)
+
+	groupEntry := func(dn string, attributes ...*ldap.EntryAttribute) *ldap.Entry {
+		return &ldap.Entry{DN: dn, Attributes: attributes}
+	}
+	cn := func(value string) *ldap.EntryAttribute {
+		return ldap.NewEntryAttribute("cn", []string{value})
+	}
+	memberOf := func(values ...string) *ldap.EntryAttribute {
+		return ldap.NewEntryAttribute("memberOf", values)
+	}
+	groupSearch := func(filter string) *ldap.SearchRequest {
+		return &ldap.SearchRequest{
+			BaseDN:       testGroupSearchBase,
+			Scope:        ldap.ScopeWholeSubtree,
+			DerefAliases: ldap.NeverDerefAliases,
+			SizeLimit:    0,
+			TimeLimit:    90,
+			TypesOnly:    false,
+			Filter:       filter,
+			Attributes:   []string{"cn"},
+			Controls:     nil,
+		}
+	}
+	groupRead := func(dn string) *ldap.SearchRequest {
+		return &ldap.SearchRequest{
+			BaseDN:       dn,
+			Scope:        ldap.ScopeBaseObject,
+			DerefAliases: ldap.NeverDerefAliases,
+			SizeLimit:    2,
+			TimeLimit:    90,
+			TypesOnly:    false,
+			Filter:       "(objectClass=*)",
+			Attributes:   []string{"cn", "memberOf"},
+			Controls:     nil,
+		}
+	}
+	result := func(entries ...*ldap.Entry) *ldap.SearchResult {
+		return &ldap.SearchResult{Entries: entries}
+	}
+
+	tests := []struct {
+		name        string
+		groupSearch GroupSearchConfig
+		userEntry   *ldap.Entry
+		setupMocks  func(conn *mockldapconn.MockConn)
+		wantGroups  []string
+		wantError   string
+	}{
+		{
+			name:        "search mode without a base does not search for groups",
+			groupSearch: GroupSearchConfig{Mode: GroupSearchModeSearch, MaxNestingDepth: 3},
+			wantGroups:  []string{},
+		},
+		{
+			name:        "search mode finds nested groups up to the max nesting depth and skips cycles",
+			groupSearch: GroupSearchConfig{Base: testGroupSearchBase, GroupNameAttribute: "cn", MaxNestingDepth: 2},
+			setupMocks: func(conn *mockldapconn.MockConn) {
+				gomock.InOrder(
+					conn.EXPECT().SearchWithPaging(groupSearch("(member="+userDN+")"), expectedGroupSearchPageSize).
+						Return(result(groupEntry(group1DN, cn("group1")), groupEntry(group2DN, cn("group2"))), nil),
+					conn.EXPECT().SearchWithPaging(groupSearch("(|(member="+group1DN+")(member="+group2DN+"))"), expectedGroupSearchPageSize).
+						Return(result(groupEntry(group3DN, cn("group3")), groupEntry(group1DN, cn("group1"))), nil),
+					conn.EXPECT().SearchWithPaging(groupSearch(`(|(member=cn=group3 \28nested\29,ou=groups,dc=pinniped,dc=dev))`), expectedGroupSearchPageSize).
+						Return(result(groupEntry(group4DN, cn("group4")), groupEntry(group2DN, cn("group2"))), nil),
+				)
+			},
+			wantGroups: []string{"group1", "group2", "group3", "group4"},
+		},
+		{
+			name:        "search mode stops when there are no more nested groups",
+			groupSearch: GroupSearchConfig{Base: testGroupSearchBase, Filter: "&(objectClass=groupOfNames)(member={})", GroupNameAttribute: "cn", MaxNestingDepth: 5},
+			setupMocks: func(conn *mockldapconn.MockConn) {
+				gomock.InOrder(
+					conn.EXPECT().SearchWithPaging(groupSearch("(&(objectClass=groupOfNames)(member="+userDN+"))"), expectedGroupSearchPageSize).
+						Return(result(groupEntry(group1DN, cn("group1"))), nil),
+					conn.EXPECT().SearchWithPaging(groupSearch("(|(&(objectClass=groupOfNames)(member="+group1DN+")))"), expectedGroupSearchPageSize).
+						Return(result(), nil),
+				)
+			},
+			wantGroups: []string{"group1"},
+		},
+		{
+			name:        "search mode returns an error when a nested group search fails",
+			groupSearch: GroupSearchConfig{Base: testGroupSearchBase, GroupNameAttribute: "cn", MaxNestingDepth: 1},
+			setupMocks: func(conn *mockldapconn.MockConn) {
+				gomock.InOrder(
+					conn.EXPECT().SearchWithPaging(groupSearch("(member="+userDN+")"), expectedGroupSearchPageSize).
+						Return(result(groupEntry(group1DN, cn("group1"))), nil),
+					conn.EXPECT().SearchWithPaging(groupSearch("(|(member="+group1DN+"))"), expectedGroupSearchPageSize).
+						Return(nil, errors.New("some search error")),
+				)
+			},
+			wantError: `error searching for nested group memberships for user with DN "` + userDN + `": some search error`,
+		},
+		{
+			name:        "in chain search mode uses the matching rule by default and lets the server resolve nested groups",
+			groupSearch: GroupSearchConfig{Mode: GroupSearchModeInChainSearch, Base: testGroupSearchBase, GroupNameAttribute: "cn", MaxNestingDepth: 3},
+			setupMocks: func(conn *mockldapconn.MockConn) {
+				conn.EXPECT().SearchWithPaging(groupSearch("(member:1.2.840.113556.1.4.1941:="+userDN+")"), expectedGroupSearchPageSize).
+					Return(result(groupEntry(group1DN, cn("group1")), groupEntry(group3DN, cn("group3"))), nil).Times(1)
+			},
+			wantGroups: []string{"group1", "group3"},
+		},
+		{
+			name:        "user attribute mode returns the group DNs without reading the groups",
+			groupSearch: GroupSearchConfig{Mode: GroupSearchModeUserAttribute},
+			userEntry:   groupEntry(userDN, memberOf(group1DN, group2DN)),
+			wantGroups:  []string{group1DN, group2DN},
+		},
+		{
+			name:        "user attribute mode returns no groups when the user has none",
+			groupSearch: GroupSearchConfig{Mode: GroupSearchModeUserAttribute, UserAttributeForGroups: "isMemberOf"},
+			userEntry:   groupEntry(userDN, memberOf(group1DN)),
+			wantGroups:  []string{},
+		},
+		{
+			name:        "user attribute mode reads nested groups, skipping cycles and groups which do not exist",
+			groupSearch: GroupSearchConfig{Mode: GroupSearchModeUserAttribute, GroupNameAttribute: "cn", MaxNestingDepth: 1},
+			userEntry:   groupEntry(userDN, memberOf(group1DN, missingDN)),
+			setupMocks: func(conn *mockldapconn.MockConn) {
+				gomock.InOrder(
+					conn.EXPECT().Search(groupRead(group1DN)).
+						Return(result(groupEntry(group1DN, cn("group1"), memberOf(group2DN, group1DN))), nil),
+					conn.EXPECT().Search(groupRead(missingDN)).
+						Return(nil, ldap.NewError(ldap.LDAPResultNoSuchObject, errors.New("no such object"))),
+					conn.EXPECT().Search(groupRead(group2DN)).
+						Return(result(groupEntry(group2DN, cn("group2"), memberOf(group4DN))), nil),
+				)
+			},
+			wantGroups: []string{"group1", "group2"},
+		},
+		{
+			name:        "user attribute mode returns an error when a group cannot be read",
+			groupSearch: GroupSearchConfig{Mode: GroupSearchModeUserAttribute, GroupNameAttribute: "cn", MaxNestingDepth: 1},
+			userEntry:   groupEntry(userDN, memberOf(group1DN)),
+			setupMocks: func(conn *mockldapconn.MockConn) {
+				conn.EXPECT().Search(groupRead(group1DN)).Return(nil, errors.New("some search error")).Times(1)
+			},
+			wantError: `error reading group with DN "` + group1DN + `" for user with DN "` + userDN + `": some search error`,
+		},
+		{
+			name:        "unknown mode",
+			groupSearch: GroupSearchConfig{Mode: "Unknown", Base: testGroupSearchBase},
+			wantError:   `unknown group search mode "Unknown"`,
+		},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			ctrl := gomock.NewController(t)
+			t.Cleanup(ctrl.Finish)
+			conn := mockldapconn.NewMockConn(ctrl)
+			if tt.setupMocks != nil {
+				tt.setupMocks(conn)
+			}
+			userEntry := tt.userEntry
+			if userEntry == nil {
+				userEntry = groupEntry(userDN)
+			}
+
+			groups, err := New(ProviderConfig{GroupSearch: tt.groupSearch}).groupsOfUser(conn, userEntry)
+			if tt.wantError != "" {
+				require.EqualError(t, err, tt.wantError)
+				require.Nil(t, groups)
+				return
+			}
+			require.NoError(t, err)
+			require.Equal(t, tt.wantGroups, groups)
+		})
+	}
+}
+
+func TestUserSearchRequestedAttributesForGroupSearchModes(t *testing.T) {
+	userSearch := UserSearchConfig{UsernameAttribute: "uid", UIDAttribute: "uidNumber"}
+
+	p := New(ProviderConfig{UserSearch: userSearch, GroupSearch: GroupSearchConfig{Base: testGroupSearchBase}})
+	require.Equal(t, []string{"uid", "uidNumber"}, p.userSearchRequestedAttributes())
+
+	p = New(ProviderConfig{UserSearch: userSearch, GroupSearch: GroupSearchConfig{Mode: GroupSearchModeUserAttribute}})
+	require.Equal(t, []string{"uid", "uidNumber", "memberOf"}, p.userSearchRequestedAttributes())
+
+	p = New(ProviderConfig{UserSearch: userSearch, GroupSearch: GroupSearchConfig{Mode: GroupSearchModeUserAttribute, UserAttributeForGroups: "isMemberOf"}})
+	require.Equal(t, []string{"uid", "uidNumber", "isMemberOf"}, p.userSearchRequestedAttributes())
+}
@@ -0,0 +1,54 @@
+// Copyright 2021 the Pinniped contributors. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package upstreamldap
+
+import (
+	"time"
+
+	"github.com/go-ldap/ldap/v3"
+
+	"go.pinniped.dev/internal/metrics"
+)
+
+// instrumentedConn records the latency of each bind and search operation of the wrapped Conn.
+type instrumentedConn struct {
+	Conn
+	idpName string
+}
+
+func (c *instrumentedConn) Bind(username, password string) error {
+	start := time.Now()
+	err := c.Conn.Bind(username, password)
+	metrics.ObserveLDAPOperation(c.idpName, "bind", bindResult(err), start)
+	return err
+}
+
+func (c *instrumentedConn) ExternalBind() error {
+	start := time.Now()
+	err := c.Conn.ExternalBind()
+	metrics.ObserveLDAPOperation(c.idpName, "bind", bindResult(err), start)
+	return err
+}
+
+func (c *instrumentedConn) Search(searchRequest *ldap.SearchRequest) (*ldap.SearchResult, error) {
+	start := time.Now()
+	result, err := c.Conn.Search(searchRequest)
+	metrics.ObserveLDAPOperation(c.idpName, "search", metrics.ResultFor(err), start)
+	return result, err
+}
+
+func (c *instrumentedConn) SearchWithPaging(searchRequest *ldap.SearchRequest, pagingSize uint32) (*ldap.SearchResult, error) {
+	start := time.Now()
+	result, err := c.Conn.SearchWithPaging(searchRequest, pagingSize)
+	metrics.ObserveLDAPOperation(c.idpName, "search", metrics.ResultFor(err), start)
+	return result, err
+}
+
+// bindResult tells apart binds which were rejected because of wrong credentials from other errors.
+func bindResult(err error) string {
+	if ldap.IsErrorWithCode(err, ldap.LDAPResultInvalidCredentials) {
+		return metrics.ResultFailure
+	}
+	return metrics.ResultFor(err)
+}
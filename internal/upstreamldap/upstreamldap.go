@@ -18,7 +18,6 @@ import (
 	"time"
 
 	"github.com/go-ldap/ldap/v3"
-	"k8s.io/apiserver/pkg/authentication/authenticator"
 	"k8s.io/apiserver/pkg/authentication/user"
 	"k8s.io/utils/trace"
 
@@ -41,6 +40,8 @@ const (
 type Conn interface {
 	Bind(username, password string) error
 
+	ExternalBind() error
+
 	Search(searchRequest *ldap.SearchRequest) (*ldap.SearchResult, error)
 
 	SearchWithPaging(searchRequest *ldap.SearchRequest, pagingSize uint32) (*ldap.SearchResult, error)
@@ -83,6 +84,14 @@ type ProviderConfig struct {
 	// the default LDAP port will be used.
 	Host string
 
+	// FailoverHosts are the hostnames or "hostname:port" of other LDAP servers, in the order in which they should be
+	// tried when the Host cannot be reached.
+	FailoverHosts []string
+
+	// SRVDomain, when not empty, is a DNS domain whose "_ldap._tcp" SRV records list more LDAP servers, which are
+	// tried after the Host and the FailoverHosts.
+	SRVDomain string
+
 	// ConnectionProtocol determines how to establish the connection to the server. Either StartTLS or TLS.
 	ConnectionProtocol LDAPConnectionProtocol
 
@@ -95,14 +104,43 @@ type ProviderConfig struct {
 	// BindPassword is the password to use when performing a bind with the upstream LDAP IDP.
 	BindPassword string
 
+	// BindClientCertificateData and BindClientKeyData are the PEM encoded client certificate and private key which
+	// are presented to the upstream LDAP IDP during the TLS handshake. When they are set, a SASL EXTERNAL bind is
+	// performed to bind as the identity of the certificate instead of binding with the BindPassword, and the
+	// BindUsername is only used in messages.
+	BindClientCertificateData []byte
+	BindClientKeyData         []byte
+
 	// UserSearch contains information about how to search for users in the upstream LDAP IDP.
 	UserSearch UserSearchConfig
 
 	// GroupSearch contains information about how to search for group membership in the upstream LDAP IDP.
 	GroupSearch GroupSearchConfig
 
+	// UIDAttributeParsingOverrides maps attribute names to functions which compute the user's UID from the user's
+	// entry. When the UserSearch UIDAttribute has an override, then it is used instead of the default behavior of
+	// base64 encoding the raw value of the attribute.
+	UIDAttributeParsingOverrides map[string]func(*ldap.Entry) (string, error)
+
+	// GroupAttributeParsingOverrides maps attribute names to functions which compute a group name from a group's
+	// entry. When the GroupSearch GroupNameAttribute has an override, then it is used instead of the default
+	// behavior of reading the string value of the attribute.
+	GroupAttributeParsingOverrides map[string]func(*ldap.Entry) (string, error)
+
+	// UserAttributeChecks maps attribute names to functions which decide whether the user found by the user search
+	// is allowed to log in. The named attributes are requested during the user search. When any check returns an
+	// error, then authentication fails as if the user was not found.
+	UserAttributeChecks map[string]func(*ldap.Entry) error
+
+	// ConnectionPoolSize is the maximum number of idle connections, bound as the BindUsername, which are kept for
+	// reuse by later operations. Zero means that connections are not reused.
+	ConnectionPoolSize int
+
 	// Dialer exists to enable testing. When nil, will use a default appropriate for production use.
 	Dialer LDAPDialer
+
+	// LookupSRV exists to enable testing. When nil, will use the default DNS resolver.
+	LookupSRV func(ctx context.Context, service, proto, name string) (string, []*net.SRV, error)
 }
 
 // UserSearchConfig contains information about how to search for users in the upstream LDAP IDP.
@@ -120,12 +158,17 @@ type UserSearchConfig struct {
 	// UIDAttribute is the attribute in the LDAP entry from which the user's unique ID should be
 	// retrieved.
 	UIDAttribute string
+
+	// AdditionalClaimMappings maps the names of additional downstream claims to the attributes in the LDAP entry
+	// from which their values should be retrieved.
+	AdditionalClaimMappings map[string]string
 }
 
 // GroupSearchConfig contains information about how to search for group membership for users in the upstream LDAP IDP.
 type GroupSearchConfig struct {
 	// Base is the base DN to use for the group search in the upstream LDAP IDP. Empty means to skip group search
 	// entirely, in which case authenticated users will not belong to any groups from the upstream LDAP IDP.
+	// It is not used when the Mode is GroupSearchModeUserAttribute.
 	Base string
 
 	// Filter is the filter to use for the group search in the upstream LDAP IDP. Empty means to use `member={}`.
@@ -134,10 +177,28 @@ type GroupSearchConfig struct {
 	// GroupNameAttribute is the attribute in the LDAP group entry from which the group name should be
 	// retrieved. Empty means to use 'cn'.
 	GroupNameAttribute string
+
+	// Mode determines how the groups of a user are found. Empty means to use GroupSearchModeSearch.
+	Mode GroupSearchMode
+
+	// UserAttributeForGroups is the attribute of the user's entry, and of each group's entry, which holds the DNs
+	// of the groups of which the entry is a member, when the Mode is GroupSearchModeUserAttribute.
+	// Empty means to use 'memberOf'.
+	UserAttributeForGroups string
+
+	// MaxNestingDepth is how many levels of groups which contain the user's groups should also be found.
+	// Zero means that only the groups of which the user is a direct member are found.
+	// It is ignored when the Mode is GroupSearchModeInChainSearch.
+	MaxNestingDepth int
+
+	// Cache remembers the groups which were recently found for each user. Nil means that the groups are always
+	// searched for.
+	Cache *GroupSearchCache
 }
 
 type Provider struct {
-	c ProviderConfig
+	c     ProviderConfig
+	conns *connections
 }
 
 var _ provider.UpstreamLDAPIdentityProviderI = &Provider{}
@@ -146,7 +207,7 @@ var _ authenticators.UserAuthenticator = &Provider{}
 // Create a Provider. The config is not a pointer to ensure that a copy of the config is created,
 // making the resulting Provider use an effectively read-only configuration.
 func New(config ProviderConfig) *Provider {
-	return &Provider{c: config}
+	return &Provider{c: config, conns: newConnections()}
 }
 
 // A reader for the config. Returns a copy of the config to keep the underlying config read-only.
@@ -154,13 +215,13 @@ func (p *Provider) GetConfig() ProviderConfig {
 	return p.c
 }
 
-func (p *Provider) dial(ctx context.Context) (Conn, error) {
-	tlsAddr, err := endpointaddr.Parse(p.c.Host, defaultLDAPSPort)
+func (p *Provider) dial(ctx context.Context, host string) (Conn, error) {
+	tlsAddr, err := endpointaddr.Parse(host, defaultLDAPSPort)
 	if err != nil {
 		return nil, ldap.NewError(ldap.ErrorNetwork, err)
 	}
 
-	startTLSAddr, err := endpointaddr.Parse(p.c.Host, defaultLDAPPort)
+	startTLSAddr, err := endpointaddr.Parse(host, defaultLDAPPort)
 	if err != nil {
 		return nil, ldap.NewError(ldap.ErrorNetwork, err)
 	}
@@ -184,7 +245,11 @@ func (p *Provider) dial(ctx context.Context) (Conn, error) {
 		dialFunc = p.c.Dialer.Dial
 	}
 
-	return dialFunc(ctx, addr)
+	conn, err := dialFunc(ctx, addr)
+	if err != nil {
+		return nil, err
+	}
+	return &instrumentedConn{Conn: conn, idpName: p.c.Name}, nil
 }
 
 // dialTLS is a default implementation of the Dialer, used when Dialer is nil and ConnectionProtocol is TLS.
@@ -246,7 +311,27 @@ func (p *Provider) tlsConfig() (*tls.Config, error) {
 			return nil, fmt.Errorf("could not parse CA bundle")
 		}
 	}
-	return &tls.Config{MinVersion: tls.VersionTLS12, RootCAs: rootCAs}, nil
+	config := &tls.Config{MinVersion: tls.VersionTLS12, RootCAs: rootCAs}
+	if p.usesClientCertificateBind() {
+		clientCert, err := tls.X509KeyPair(p.c.BindClientCertificateData, p.c.BindClientKeyData)
+		if err != nil {
+			return nil, fmt.Errorf("could not parse bind client certificate: %w", err)
+		}
+		config.Certificates = []tls.Certificate{clientCert}
+	}
+	return config, nil
+}
+
+func (p *Provider) usesClientCertificateBind() bool {
+	return len(p.c.BindClientCertificateData) > 0
+}
+
+// bindAsServiceAccount binds as the BindUsername, or as the identity of the bind client certificate when there is one.
+func (p *Provider) bindAsServiceAccount(conn Conn) error {
+	if p.usesClientCertificateBind() {
+		return conn.ExternalBind()
+	}
+	return conn.Bind(p.c.BindUsername, p.c.BindPassword)
 }
 
 // A name for this upstream provider.
@@ -266,23 +351,22 @@ func (p *Provider) GetURL() *url.URL {
 }
 
 // TestConnection provides a method for testing the connection and bind settings. It performs a dial and bind
-// and returns any errors that we encountered.
+// and returns any errors that we encountered. It never uses a connection from the pool.
 func (p *Provider) TestConnection(ctx context.Context) error {
 	err := p.validateConfig()
 	if err != nil {
 		return err
 	}
 
-	conn, err := p.dial(ctx)
+	conn, err := p.dialAndBind(ctx)
 	if err != nil {
-		return fmt.Errorf(`error dialing host "%s": %w`, p.c.Host, err)
-	}
-	defer conn.Close()
-
-	err = conn.Bind(p.c.BindUsername, p.c.BindPassword)
-	if err != nil {
-		return fmt.Errorf(`error binding as "%s": %w`, p.c.BindUsername, err)
+		var bindErr *bindError
+		if errors.As(err, &bindErr) {
+			return fmt.Errorf(`error binding as "%s": %w`, p.c.BindUsername, bindErr.err)
+		}
+		return err
 	}
+	conn.Close()
 
 	return nil
 }
@@ -291,7 +375,7 @@ func (p *Provider) TestConnection(ctx context.Context) error {
 // authentication for a given end user's username. It runs the same logic as AuthenticateUser except it does
 // not bind as that user, so it does not test their password. It returns the same values that a real call to
 // AuthenticateUser with the correct password would return.
-func (p *Provider) DryRunAuthenticateUser(ctx context.Context, username string) (*authenticator.Response, bool, error) {
+func (p *Provider) DryRunAuthenticateUser(ctx context.Context, username string) (*authenticators.Response, bool, error) {
 	endUserBindFunc := func(conn Conn, foundUserDN string) error {
 		// Act as if the end user bind always succeeds.
 		return nil
@@ -300,14 +384,14 @@ func (p *Provider) DryRunAuthenticateUser(ctx context.Context, username string)
 }
 
 // Authenticate an end user and return their mapped username, groups, and UID. Implements authenticators.UserAuthenticator.
-func (p *Provider) AuthenticateUser(ctx context.Context, username, password string) (*authenticator.Response, bool, error) {
+func (p *Provider) AuthenticateUser(ctx context.Context, username, password string) (*authenticators.Response, bool, error) {
 	endUserBindFunc := func(conn Conn, foundUserDN string) error {
 		return conn.Bind(foundUserDN, password)
 	}
 	return p.authenticateUserImpl(ctx, username, endUserBindFunc)
 }
 
-func (p *Provider) authenticateUserImpl(ctx context.Context, username string, bindFunc func(conn Conn, foundUserDN string) error) (*authenticator.Response, bool, error) {
+func (p *Provider) authenticateUserImpl(ctx context.Context, username string, bindFunc func(conn Conn, foundUserDN string) error) (*authenticators.Response, bool, error) {
 	t := trace.FromContext(ctx).Nest("slow ldap authenticate user attempt", trace.Field{Key: "providerName", Value: p.GetName()})
 	defer t.LogIfLong(500 * time.Millisecond) // to help users debug slow LDAP searches
 
@@ -323,58 +407,123 @@ func (p *Provider) authenticateUserImpl(ctx context.Context, username string, bi
 		return nil, false, nil
 	}
 
-	conn, err := p.dial(ctx)
+	var response *authenticators.Response
+	err = p.withConnection(ctx, func(conn Conn) error {
+		var searchErr error
+		response, searchErr = p.searchAndBindUser(conn, username, bindFunc)
+		return searchErr
+	})
 	if err != nil {
 		p.traceAuthFailure(t, err)
-		return nil, false, fmt.Errorf(`error dialing host "%s": %w`, p.c.Host, err)
+		return nil, false, err
+	}
+	if response == nil {
+		// Couldn't find the username or couldn't bind using the password.
+		p.traceAuthFailure(t, fmt.Errorf("bad username or password"))
+		return nil, false, nil
 	}
-	defer conn.Close()
 
-	err = conn.Bind(p.c.BindUsername, p.c.BindPassword)
+	p.traceAuthSuccess(t)
+	return response, true, nil
+}
+
+// PerformRefresh looks up the user's entry again by the DN which was found during their original authentication,
+// and returns their current mapped username, groups, and UID. It returns a nil response and false when the user's
+// entry no longer exists or when the user is no longer allowed to log in. It does not check the user's password.
+func (p *Provider) PerformRefresh(ctx context.Context, userDN string) (*authenticators.Response, bool, error) {
+	t := trace.FromContext(ctx).Nest("slow ldap refresh attempt", trace.Field{Key: "providerName", Value: p.GetName()})
+	defer t.LogIfLong(500 * time.Millisecond) // to help users debug slow LDAP searches
+
+	err := p.validateConfig()
 	if err != nil {
-		p.traceAuthFailure(t, err)
-		return nil, false, fmt.Errorf(`error binding as "%s" before user search: %w`, p.c.BindUsername, err)
+		p.traceRefreshFailure(t, err)
+		return nil, false, err
 	}
 
-	mappedUsername, mappedUID, mappedGroupNames, err := p.searchAndBindUser(conn, username, bindFunc)
+	if len(userDN) == 0 {
+		p.traceRefreshFailure(t, fmt.Errorf("empty user DN"))
+		return nil, false, nil
+	}
+
+	var response *authenticators.Response
+	err = p.withConnection(ctx, func(conn Conn) error {
+		var searchErr error
+		response, searchErr = p.searchUserByDN(t, conn, userDN)
+		return searchErr
+	})
 	if err != nil {
-		p.traceAuthFailure(t, err)
+		p.traceRefreshFailure(t, err)
 		return nil, false, err
 	}
-	if len(mappedUsername) == 0 || len(mappedUID) == 0 {
-		// Couldn't find the username or couldn't bind using the password.
-		p.traceAuthFailure(t, fmt.Errorf("bad username or password"))
+	if response == nil {
 		return nil, false, nil
 	}
 
-	response := &authenticator.Response{
-		User: &user.DefaultInfo{
-			Name:   mappedUsername,
-			UID:    mappedUID,
-			Groups: mappedGroupNames,
-		},
-	}
-	p.traceAuthSuccess(t)
+	p.traceRefreshSuccess(t)
 	return response, true, nil
 }
 
+// searchUserByDN looks up the user's entry by its DN and maps it. It returns a nil response when the entry no longer
+// exists or when the user is no longer allowed to log in.
+func (p *Provider) searchUserByDN(t *trace.Trace, conn Conn, userDN string) (*authenticators.Response, error) {
+	searchResult, err := conn.Search(p.refreshUserSearchRequest(userDN))
+	if err != nil {
+		ldapErr := &ldap.Error{}
+		if errors.As(err, &ldapErr) && ldapErr.ResultCode == ldap.LDAPResultNoSuchObject {
+			p.traceRefreshFailure(t, fmt.Errorf("user not found"))
+			return nil, nil
+		}
+		return nil, fmt.Errorf(`error searching for user with DN "%s": %w`, userDN, err)
+	}
+	if len(searchResult.Entries) == 0 {
+		p.traceRefreshFailure(t, fmt.Errorf("user not found"))
+		return nil, nil
+	}
+	if len(searchResult.Entries) > 1 {
+		return nil, fmt.Errorf(`searching for user with DN "%s" resulted in %d search results, but expected 1 result`, userDN, len(searchResult.Entries))
+	}
+
+	response, err := p.mapUserEntry(conn, searchResult.Entries[0], userDN)
+	if err != nil {
+		return nil, err
+	}
+	if response == nil {
+		// The user is no longer allowed to log in.
+		p.traceRefreshFailure(t, fmt.Errorf("user is not allowed to log in"))
+		return nil, nil
+	}
+	return response, nil
+}
+
 func (p *Provider) searchGroupsForUserDN(conn Conn, userDN string) ([]string, error) {
 	searchResult, err := conn.SearchWithPaging(p.groupSearchRequest(userDN), groupSearchPageSize)
 	if err != nil {
 		return nil, fmt.Errorf(`error searching for group memberships for user with DN %q: %w`, userDN, err)
 	}
 
-	groupAttributeName := p.c.GroupSearch.GroupNameAttribute
-	if len(groupAttributeName) == 0 {
-		groupAttributeName = distinguishedNameAttributeName
-	}
-
-	groups := []string{}
-	for _, groupEntry := range searchResult.Entries {
+	groupEntries := append([]*ldap.Entry{}, searchResult.Entries...)
+	seen := map[string]bool{}
+	directGroupDNs := make([]string, 0, len(groupEntries))
+	for _, groupEntry := range groupEntries {
 		if len(groupEntry.DN) == 0 {
 			return nil, fmt.Errorf(`searching for group memberships for user with DN %q resulted in search result without DN`, userDN)
 		}
-		mappedGroupName, err := p.getSearchResultAttributeValue(groupAttributeName, groupEntry, userDN)
+		seen[strings.ToLower(groupEntry.DN)] = true
+		directGroupDNs = append(directGroupDNs, groupEntry.DN)
+	}
+
+	// The in chain matching rule already finds the nested groups.
+	if p.c.GroupSearch.Mode != GroupSearchModeInChainSearch {
+		nestedGroupEntries, err := p.searchNestedGroups(conn, directGroupDNs, seen, userDN)
+		if err != nil {
+			return nil, err
+		}
+		groupEntries = append(groupEntries, nestedGroupEntries...)
+	}
+
+	groups := []string{}
+	for _, groupEntry := range groupEntries {
+		mappedGroupName, err := p.mapGroupEntry(groupEntry, userDN)
 		if err != nil {
 			return nil, fmt.Errorf(`error searching for group memberships for user with DN %q: %w`, userDN, err)
 		}
@@ -392,7 +541,7 @@ func (p *Provider) validateConfig() error {
 	return nil
 }
 
-func (p *Provider) searchAndBindUser(conn Conn, username string, bindFunc func(conn Conn, foundUserDN string) error) (string, string, []string, error) {
+func (p *Provider) searchAndBindUser(conn Conn, username string, bindFunc func(conn Conn, foundUserDN string) error) (*authenticators.Response, error) {
 	searchResult, err := conn.Search(p.userSearchRequest(username))
 	if err != nil {
 		plog.All(`error searching for user`,
@@ -400,7 +549,7 @@ func (p *Provider) searchAndBindUser(conn Conn, username string, bindFunc func(c
 			"username", username,
 			"err", err,
 		)
-		return "", "", nil, fmt.Errorf(`error searching for user: %w`, err)
+		return nil, fmt.Errorf(`error searching for user: %w`, err)
 	}
 	if len(searchResult.Entries) == 0 {
 		if plog.Enabled(plog.LevelAll) {
@@ -411,55 +560,120 @@ func (p *Provider) searchAndBindUser(conn Conn, username string, bindFunc func(c
 		} else {
 			plog.Debug("error finding user: user not found (cowardly avoiding printing username because log level is not 'all')", "upstreamName", p.GetName())
 		}
-		return "", "", nil, nil
+		return nil, nil
 	}
 
 	// At this point, we have matched at least one entry, so we can be confident that the username is not actually
 	// someone's password mistakenly entered into the username field, so we can log it without concern.
 	if len(searchResult.Entries) > 1 {
-		return "", "", nil, fmt.Errorf(`searching for user "%s" resulted in %d search results, but expected 1 result`,
+		return nil, fmt.Errorf(`searching for user "%s" resulted in %d search results, but expected 1 result`,
 			username, len(searchResult.Entries),
 		)
 	}
 	userEntry := searchResult.Entries[0]
 	if len(userEntry.DN) == 0 {
-		return "", "", nil, fmt.Errorf(`searching for user "%s" resulted in search result without DN`, username)
+		return nil, fmt.Errorf(`searching for user "%s" resulted in search result without DN`, username)
 	}
 
-	mappedUsername, err := p.getSearchResultAttributeValue(p.c.UserSearch.UsernameAttribute, userEntry, username)
+	response, err := p.mapUserEntry(conn, userEntry, username)
+	if err != nil || response == nil {
+		return nil, err
+	}
+
+	// Caution: Note that any other LDAP commands after this bind will be run as this user instead of as the configured BindUsername!
+	err = bindFunc(conn, userEntry.DN)
 	if err != nil {
-		return "", "", nil, err
+		plog.DebugErr("error binding for user (if this is not the expected dn for this username, please check the user search configuration)",
+			err, "upstreamName", p.GetName(), "username", username, "dn", userEntry.DN)
+		ldapErr := &ldap.Error{}
+		if errors.As(err, &ldapErr) && ldapErr.ResultCode == ldap.LDAPResultInvalidCredentials {
+			return nil, nil
+		}
+		return nil, fmt.Errorf(`error binding for user "%s" using provided password against DN "%s": %w`, username, userEntry.DN, err)
 	}
 
-	// We would like to support binary typed attributes for UIDs, so always read them as binary and encode them,
-	// even when the attribute may not be binary.
-	mappedUID, err := p.getSearchResultAttributeRawValueEncoded(p.c.UserSearch.UIDAttribute, userEntry, username)
+	return response, nil
+}
+
+// mapUserEntry reads the user's username and UID from their entry, runs the UserAttributeChecks, and searches for
+// the user's groups. It returns a nil response when the checks decide that the user is not allowed to log in.
+// The username param is only used in error messages.
+func (p *Provider) mapUserEntry(conn Conn, userEntry *ldap.Entry, username string) (*authenticators.Response, error) {
+	mappedUsername, err := p.getSearchResultAttributeValue(p.c.UserSearch.UsernameAttribute, userEntry, username)
 	if err != nil {
-		return "", "", nil, err
+		return nil, err
 	}
 
-	mappedGroupNames := []string{}
-	if len(p.c.GroupSearch.Base) > 0 {
-		mappedGroupNames, err = p.searchGroupsForUserDN(conn, userEntry.DN)
+	var mappedUID string
+	if overrideFunc := p.c.UIDAttributeParsingOverrides[p.c.UserSearch.UIDAttribute]; overrideFunc != nil {
+		mappedUID, err = overrideFunc(userEntry)
+		if err != nil {
+			return nil, fmt.Errorf(`error reading UID for user "%s": %w`, username, err)
+		}
+	} else {
+		// We would like to support binary typed attributes for UIDs, so always read them as binary and encode them,
+		// even when the attribute may not be binary.
+		mappedUID, err = p.getSearchResultAttributeRawValueEncoded(p.c.UserSearch.UIDAttribute, userEntry, username)
 		if err != nil {
-			return "", "", nil, err
+			return nil, err
 		}
 	}
-	sort.Strings(mappedGroupNames)
 
-	// Caution: Note that any other LDAP commands after this bind will be run as this user instead of as the configured BindUsername!
-	err = bindFunc(conn, userEntry.DN)
+	for _, attributeName := range p.userAttributeCheckNames() {
+		if err := p.c.UserAttributeChecks[attributeName](userEntry); err != nil {
+			plog.Debug("error validating user: user is not allowed to log in",
+				"upstreamName", p.GetName(), "username", username, "dn", userEntry.DN, "attribute", attributeName, "reason", err.Error())
+			return nil, nil
+		}
+	}
+
+	mappedGroupNames, err := p.groupsOfUser(conn, userEntry)
 	if err != nil {
-		plog.DebugErr("error binding for user (if this is not the expected dn for this username, please check the user search configuration)",
-			err, "upstreamName", p.GetName(), "username", username, "dn", userEntry.DN)
-		ldapErr := &ldap.Error{}
-		if errors.As(err, &ldapErr) && ldapErr.ResultCode == ldap.LDAPResultInvalidCredentials {
-			return "", "", nil, nil
+		return nil, err
+	}
+	sort.Strings(mappedGroupNames)
+
+	return &authenticators.Response{
+		User: &user.DefaultInfo{
+			Name:   mappedUsername,
+			UID:    mappedUID,
+			Groups: mappedGroupNames,
+		},
+		DN:               userEntry.DN,
+		Attributes:       entryAttributes(userEntry),
+		AdditionalClaims: p.additionalClaims(userEntry),
+	}, nil
+}
+
+// additionalClaims returns the values of the attributes of the user's entry which are mapped to additional claims.
+// An attribute with one value becomes a string claim, and an attribute with several values becomes a list of strings.
+// Attributes which are not present in the entry are skipped. Returns nil when there are no additional claims.
+func (p *Provider) additionalClaims(userEntry *ldap.Entry) map[string]interface{} {
+	var claims map[string]interface{}
+	for claimName, attributeName := range p.c.UserSearch.AdditionalClaimMappings {
+		values := userEntry.GetEqualFoldAttributeValues(attributeName)
+		if len(values) == 0 {
+			continue
+		}
+		if claims == nil {
+			claims = map[string]interface{}{}
+		}
+		if len(values) == 1 {
+			claims[claimName] = values[0]
+		} else {
+			claims[claimName] = values
 		}
-		return "", "", nil, fmt.Errorf(`error binding for user "%s" using provided password against DN "%s": %w`, username, userEntry.DN, err)
 	}
+	return claims
+}
 
-	return mappedUsername, mappedUID, mappedGroupNames, nil
+// entryAttributes returns the string values of the attributes of the entry, keyed by attribute name, and the DN.
+func entryAttributes(entry *ldap.Entry) map[string][]string {
+	attributes := map[string][]string{distinguishedNameAttributeName: {entry.DN}}
+	for _, attribute := range entry.Attributes {
+		attributes[attribute.Name] = attribute.Values
+	}
+	return attributes
 }
 
 func (p *Provider) userSearchRequest(username string) *ldap.SearchRequest {
@@ -477,6 +691,21 @@ func (p *Provider) userSearchRequest(username string) *ldap.SearchRequest {
 	}
 }
 
+func (p *Provider) refreshUserSearchRequest(userDN string) *ldap.SearchRequest {
+	// See https://ldap.com/the-ldap-search-operation for general documentation of LDAP search options.
+	return &ldap.SearchRequest{
+		BaseDN:       userDN,
+		Scope:        ldap.ScopeBaseObject,
+		DerefAliases: ldap.NeverDerefAliases,
+		SizeLimit:    2,
+		TimeLimit:    90,
+		TypesOnly:    false,
+		Filter:       "(objectClass=*)", // the base object search already selects exactly one entry
+		Attributes:   p.userSearchRequestedAttributes(),
+		Controls:     nil, // nil because only one entry can be returned
+	}
+}
+
 func (p *Provider) groupSearchRequest(userDN string) *ldap.SearchRequest {
 	// See https://ldap.com/the-ldap-search-operation for general documentation of LDAP search options.
 	return &ldap.SearchRequest{
@@ -500,9 +729,35 @@ func (p *Provider) userSearchRequestedAttributes() []string {
 	if p.c.UserSearch.UIDAttribute != distinguishedNameAttributeName {
 		attributes = append(attributes, p.c.UserSearch.UIDAttribute)
 	}
+	if p.c.GroupSearch.Mode == GroupSearchModeUserAttribute {
+		attributes = append(attributes, p.userAttributeForGroups())
+	}
+	attributes = append(attributes, p.userAttributeCheckNames()...)
+	attributes = append(attributes, p.additionalClaimAttributeNames()...)
 	return attributes
 }
 
+// additionalClaimAttributeNames returns the names of the attributes which are mapped to additional claims, in a
+// stable order.
+func (p *Provider) additionalClaimAttributeNames() []string {
+	names := make([]string, 0, len(p.c.UserSearch.AdditionalClaimMappings))
+	for _, attributeName := range p.c.UserSearch.AdditionalClaimMappings {
+		names = append(names, attributeName)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// userAttributeCheckNames returns the names of the attributes which have UserAttributeChecks, in a stable order.
+func (p *Provider) userAttributeCheckNames() []string {
+	names := make([]string, 0, len(p.c.UserAttributeChecks))
+	for attributeName := range p.c.UserAttributeChecks {
+		names = append(names, attributeName)
+	}
+	sort.Strings(names)
+	return names
+}
+
 func (p *Provider) groupSearchRequestedAttributes() []string {
 	switch p.c.GroupSearch.GroupNameAttribute {
 	case "":
@@ -524,6 +779,9 @@ func (p *Provider) userSearchFilter(username string) string {
 
 func (p *Provider) groupSearchFilter(userDN string) string {
 	if len(p.c.GroupSearch.Filter) == 0 {
+		if p.c.GroupSearch.Mode == GroupSearchModeInChainSearch {
+			return interpolateSearchFilter(defaultInChainGroupSearchFilter, userDN)
+		}
 		return fmt.Sprintf("(member=%s)", userDN)
 	}
 	return interpolateSearchFilter(p.c.GroupSearch.Filter, userDN)
@@ -601,3 +859,16 @@ func (p *Provider) traceAuthSuccess(t *trace.Trace) {
 		trace.Field{Key: "authenticated", Value: true},
 	)
 }
+
+func (p *Provider) traceRefreshFailure(t *trace.Trace, err error) {
+	t.Step("refresh failed",
+		trace.Field{Key: "refreshed", Value: false},
+		trace.Field{Key: "reason", Value: err.Error()},
+	)
+}
+
+func (p *Provider) traceRefreshSuccess(t *trace.Trace) {
+	t.Step("refresh succeeded",
+		trace.Field{Key: "refreshed", Value: true},
+	)
+}
@@ -18,9 +18,9 @@ import (
 	"github.com/go-ldap/ldap/v3"
 	"github.com/golang/mock/gomock"
 	"github.com/stretchr/testify/require"
-	"k8s.io/apiserver/pkg/authentication/authenticator"
 	"k8s.io/apiserver/pkg/authentication/user"
 
+	"go.pinniped.dev/internal/authenticators"
 	"go.pinniped.dev/internal/certauthority"
 	"go.pinniped.dev/internal/endpointaddr"
 	"go.pinniped.dev/internal/mocks/mockldapconn"
@@ -151,7 +151,7 @@ func TestEndUserAuthentication(t *testing.T) {
 	}
 
 	// The auth response which matches the exampleUserSearchResult and exampleGroupSearchResult.
-	expectedAuthResponse := func(editFunc func(r *user.DefaultInfo)) *authenticator.Response {
+	expectedAuthResponse := func(editFunc func(r *user.DefaultInfo)) *authenticators.Response {
 		u := &user.DefaultInfo{
 			Name:   testUserSearchResultUsernameAttributeValue,
 			UID:    base64.RawURLEncoding.EncodeToString([]byte(testUserSearchResultUIDAttributeValue)),
@@ -160,7 +160,17 @@ func TestEndUserAuthentication(t *testing.T) {
 		if editFunc != nil {
 			editFunc(u)
 		}
-		return &authenticator.Response{User: u}
+		return &authenticators.Response{User: u, DN: testUserSearchResultDNValue, Attributes: map[string][]string{
+			"dn":                            {testUserSearchResultDNValue},
+			testUserSearchUsernameAttribute: {testUserSearchResultUsernameAttributeValue},
+			testUserSearchUIDAttribute:      {testUserSearchResultUIDAttributeValue},
+		}}
+	}
+
+	// For search results which do not include all of the attributes of the exampleUserSearchResult.
+	withoutAttribute := func(r *authenticators.Response, attributeName string) *authenticators.Response {
+		delete(r.Attributes, attributeName)
+		return r
 	}
 
 	tests := []struct {
@@ -173,7 +183,7 @@ func TestEndUserAuthentication(t *testing.T) {
 		dialError                  error
 		wantError                  string
 		wantToSkipDial             bool
-		wantAuthResponse           *authenticator.Response
+		wantAuthResponse           *authenticators.Response
 		wantUnauthenticated        bool
 		skipDryRunAuthenticateUser bool // tests about when the end user bind fails don't make sense for DryRunAuthenticateUser()
 	}{
@@ -194,6 +204,73 @@ func TestEndUserAuthentication(t *testing.T) {
 			},
 			wantAuthResponse: expectedAuthResponse(nil),
 		},
+		{
+			name:     "when additional claim mappings are configured, reads the mapped attributes of the user entry",
+			username: testUpstreamUsername,
+			password: testUpstreamPassword,
+			providerConfig: providerConfig(func(p *ProviderConfig) {
+				p.UserSearch.AdditionalClaimMappings = map[string]string{
+					"email":      "mail",
+					"department": "departmentNumber",
+					"phone":      "telephoneNumber",
+				}
+			}),
+			searchMocks: func(conn *mockldapconn.MockConn) {
+				conn.EXPECT().Bind(testBindUsername, testBindPassword).Times(1)
+				conn.EXPECT().Search(expectedUserSearch(func(r *ldap.SearchRequest) {
+					r.Attributes = []string{testUserSearchUsernameAttribute, testUserSearchUIDAttribute, "departmentNumber", "mail", "telephoneNumber"}
+				})).Return(&ldap.SearchResult{
+					Entries: []*ldap.Entry{
+						{
+							DN: testUserSearchResultDNValue,
+							Attributes: []*ldap.EntryAttribute{
+								ldap.NewEntryAttribute(testUserSearchUsernameAttribute, []string{testUserSearchResultUsernameAttributeValue}),
+								ldap.NewEntryAttribute(testUserSearchUIDAttribute, []string{testUserSearchResultUIDAttributeValue}),
+								ldap.NewEntryAttribute("Mail", []string{"user@example.com"}),
+								ldap.NewEntryAttribute("departmentNumber", []string{"42", "43"}),
+							},
+						},
+					},
+				}, nil).Times(1)
+				conn.EXPECT().SearchWithPaging(expectedGroupSearch(nil), expectedGroupSearchPageSize).
+					Return(exampleGroupSearchResult, nil).Times(1)
+				conn.EXPECT().Close().Times(1)
+			},
+			bindEndUserMocks: func(conn *mockldapconn.MockConn) {
+				conn.EXPECT().Bind(testUserSearchResultDNValue, testUpstreamPassword).Times(1)
+			},
+			wantAuthResponse: func() *authenticators.Response {
+				r := expectedAuthResponse(nil)
+				r.Attributes["Mail"] = []string{"user@example.com"}
+				r.Attributes["departmentNumber"] = []string{"42", "43"}
+				r.AdditionalClaims = map[string]interface{}{
+					"email":      "user@example.com",
+					"department": []string{"42", "43"},
+				}
+				return r
+			}(),
+		},
+		{
+			name:     "when there is a bind client certificate, binds with SASL EXTERNAL before the user search",
+			username: testUpstreamUsername,
+			password: testUpstreamPassword,
+			providerConfig: providerConfig(func(p *ProviderConfig) {
+				p.BindPassword = ""
+				p.BindClientCertificateData = []byte("some-client-certificate-pem")
+				p.BindClientKeyData = []byte("some-client-key-pem")
+			}),
+			searchMocks: func(conn *mockldapconn.MockConn) {
+				conn.EXPECT().ExternalBind().Times(1)
+				conn.EXPECT().Search(expectedUserSearch(nil)).Return(exampleUserSearchResult, nil).Times(1)
+				conn.EXPECT().SearchWithPaging(expectedGroupSearch(nil), expectedGroupSearchPageSize).
+					Return(exampleGroupSearchResult, nil).Times(1)
+				conn.EXPECT().Close().Times(1)
+			},
+			bindEndUserMocks: func(conn *mockldapconn.MockConn) {
+				conn.EXPECT().Bind(testUserSearchResultDNValue, testUpstreamPassword).Times(1)
+			},
+			wantAuthResponse: expectedAuthResponse(nil),
+		},
 		{
 			name:     "when the user search filter is already wrapped by parenthesis then it is not wrapped again",
 			username: testUpstreamUsername,
@@ -279,9 +356,9 @@ func TestEndUserAuthentication(t *testing.T) {
 			bindEndUserMocks: func(conn *mockldapconn.MockConn) {
 				conn.EXPECT().Bind(testUserSearchResultDNValue, testUpstreamPassword).Times(1)
 			},
-			wantAuthResponse: expectedAuthResponse(func(r *user.DefaultInfo) {
+			wantAuthResponse: withoutAttribute(expectedAuthResponse(func(r *user.DefaultInfo) {
 				r.Name = testUserSearchResultDNValue
-			}),
+			}), testUserSearchUsernameAttribute),
 		},
 		{
 			name:     "when the UIDAttribute is dn",
@@ -311,9 +388,9 @@ func TestEndUserAuthentication(t *testing.T) {
 			bindEndUserMocks: func(conn *mockldapconn.MockConn) {
 				conn.EXPECT().Bind(testUserSearchResultDNValue, testUpstreamPassword).Times(1)
 			},
-			wantAuthResponse: expectedAuthResponse(func(r *user.DefaultInfo) {
+			wantAuthResponse: withoutAttribute(expectedAuthResponse(func(r *user.DefaultInfo) {
 				r.UID = base64.RawURLEncoding.EncodeToString([]byte(testUserSearchResultDNValue))
-			}),
+			}), testUserSearchUIDAttribute),
 		},
 		{
 			name:     "when the GroupNameAttribute is empty then it defaults to dn",
@@ -399,6 +476,86 @@ func TestEndUserAuthentication(t *testing.T) {
 			},
 			wantAuthResponse: expectedAuthResponse(nil),
 		},
+		{
+			name:     "when the UIDAttribute has a parsing override",
+			username: testUpstreamUsername,
+			password: testUpstreamPassword,
+			providerConfig: providerConfig(func(p *ProviderConfig) {
+				p.UIDAttributeParsingOverrides = map[string]func(*ldap.Entry) (string, error){
+					testUserSearchUIDAttribute: func(entry *ldap.Entry) (string, error) {
+						return "overridden-" + entry.GetAttributeValue(testUserSearchUIDAttribute), nil
+					},
+					"some-other-attribute": func(entry *ldap.Entry) (string, error) {
+						return "", errors.New("should not be called")
+					},
+				}
+			}),
+			searchMocks: func(conn *mockldapconn.MockConn) {
+				conn.EXPECT().Bind(testBindUsername, testBindPassword).Times(1)
+				conn.EXPECT().Search(expectedUserSearch(nil)).Return(exampleUserSearchResult, nil).Times(1)
+				conn.EXPECT().SearchWithPaging(expectedGroupSearch(nil), expectedGroupSearchPageSize).
+					Return(exampleGroupSearchResult, nil).Times(1)
+				conn.EXPECT().Close().Times(1)
+			},
+			bindEndUserMocks: func(conn *mockldapconn.MockConn) {
+				conn.EXPECT().Bind(testUserSearchResultDNValue, testUpstreamPassword).Times(1)
+			},
+			wantAuthResponse: expectedAuthResponse(func(r *user.DefaultInfo) {
+				r.UID = "overridden-" + testUserSearchResultUIDAttributeValue
+			}),
+		},
+		{
+			name:     "when the GroupNameAttribute has a parsing override",
+			username: testUpstreamUsername,
+			password: testUpstreamPassword,
+			providerConfig: providerConfig(func(p *ProviderConfig) {
+				p.GroupAttributeParsingOverrides = map[string]func(*ldap.Entry) (string, error){
+					testGroupSearchGroupNameAttribute: func(entry *ldap.Entry) (string, error) {
+						return entry.GetAttributeValue(testGroupSearchGroupNameAttribute) + "@" + entry.DN, nil
+					},
+				}
+			}),
+			searchMocks: func(conn *mockldapconn.MockConn) {
+				conn.EXPECT().Bind(testBindUsername, testBindPassword).Times(1)
+				conn.EXPECT().Search(expectedUserSearch(nil)).Return(exampleUserSearchResult, nil).Times(1)
+				conn.EXPECT().SearchWithPaging(expectedGroupSearch(nil), expectedGroupSearchPageSize).
+					Return(exampleGroupSearchResult, nil).Times(1)
+				conn.EXPECT().Close().Times(1)
+			},
+			bindEndUserMocks: func(conn *mockldapconn.MockConn) {
+				conn.EXPECT().Bind(testUserSearchResultDNValue, testUpstreamPassword).Times(1)
+			},
+			wantAuthResponse: expectedAuthResponse(func(r *user.DefaultInfo) {
+				r.Groups = []string{
+					testGroupSearchResultGroupNameAttributeValue1 + "@" + testGroupSearchResultDNValue1,
+					testGroupSearchResultGroupNameAttributeValue2 + "@" + testGroupSearchResultDNValue2,
+				}
+			}),
+		},
+		{
+			name:     "when there are user attribute checks which pass",
+			username: testUpstreamUsername,
+			password: testUpstreamPassword,
+			providerConfig: providerConfig(func(p *ProviderConfig) {
+				p.UserAttributeChecks = map[string]func(*ldap.Entry) error{
+					"some-check-attribute-b": func(entry *ldap.Entry) error { return nil },
+					"some-check-attribute-a": func(entry *ldap.Entry) error { return nil },
+				}
+			}),
+			searchMocks: func(conn *mockldapconn.MockConn) {
+				conn.EXPECT().Bind(testBindUsername, testBindPassword).Times(1)
+				conn.EXPECT().Search(expectedUserSearch(func(r *ldap.SearchRequest) {
+					r.Attributes = []string{testUserSearchUsernameAttribute, testUserSearchUIDAttribute, "some-check-attribute-a", "some-check-attribute-b"}
+				})).Return(exampleUserSearchResult, nil).Times(1)
+				conn.EXPECT().SearchWithPaging(expectedGroupSearch(nil), expectedGroupSearchPageSize).
+					Return(exampleGroupSearchResult, nil).Times(1)
+				conn.EXPECT().Close().Times(1)
+			},
+			bindEndUserMocks: func(conn *mockldapconn.MockConn) {
+				conn.EXPECT().Bind(testUserSearchResultDNValue, testUpstreamPassword).Times(1)
+			},
+			wantAuthResponse: expectedAuthResponse(nil),
+		},
 		{
 			name:     "when user search Filter is blank it derives a search filter from the UsernameAttribute",
 			username: testUpstreamUsername,
@@ -498,12 +655,18 @@ func TestEndUserAuthentication(t *testing.T) {
 			bindEndUserMocks: func(conn *mockldapconn.MockConn) {
 				conn.EXPECT().Bind(testUserSearchResultDNValue, testUpstreamPassword).Times(1)
 			},
-			wantAuthResponse: &authenticator.Response{
+			wantAuthResponse: &authenticators.Response{
 				User: &user.DefaultInfo{
 					Name:   testUserSearchResultUsernameAttributeValue,
 					UID:    base64.RawURLEncoding.EncodeToString([]byte(testUserSearchResultUIDAttributeValue)),
 					Groups: []string{"a", "b", "c"},
 				},
+				DN: testUserSearchResultDNValue,
+				Attributes: map[string][]string{
+					"dn":                            {testUserSearchResultDNValue},
+					testUserSearchUsernameAttribute: {testUserSearchResultUsernameAttributeValue},
+					testUserSearchUIDAttribute:      {testUserSearchResultUIDAttributeValue},
+				},
 			},
 		},
 		{
@@ -886,6 +1049,62 @@ func TestEndUserAuthentication(t *testing.T) {
 			},
 			wantError: fmt.Sprintf(`found empty value for attribute "%s" while searching for user "%s", but expected value to be non-empty`, testUserSearchUIDAttribute, testUpstreamUsername),
 		},
+		{
+			name:     "when the UIDAttribute parsing override returns an error",
+			username: testUpstreamUsername,
+			password: testUpstreamPassword,
+			providerConfig: providerConfig(func(p *ProviderConfig) {
+				p.UIDAttributeParsingOverrides = map[string]func(*ldap.Entry) (string, error){
+					testUserSearchUIDAttribute: func(entry *ldap.Entry) (string, error) {
+						return "", errors.New("some uid parsing error")
+					},
+				}
+			}),
+			searchMocks: func(conn *mockldapconn.MockConn) {
+				conn.EXPECT().Bind(testBindUsername, testBindPassword).Times(1)
+				conn.EXPECT().Search(expectedUserSearch(nil)).Return(exampleUserSearchResult, nil).Times(1)
+				conn.EXPECT().Close().Times(1)
+			},
+			wantError: fmt.Sprintf(`error reading UID for user "%s": some uid parsing error`, testUpstreamUsername),
+		},
+		{
+			name:     "when the GroupNameAttribute parsing override returns an error",
+			username: testUpstreamUsername,
+			password: testUpstreamPassword,
+			providerConfig: providerConfig(func(p *ProviderConfig) {
+				p.GroupAttributeParsingOverrides = map[string]func(*ldap.Entry) (string, error){
+					testGroupSearchGroupNameAttribute: func(entry *ldap.Entry) (string, error) {
+						return "", errors.New("some group parsing error")
+					},
+				}
+			}),
+			searchMocks: func(conn *mockldapconn.MockConn) {
+				conn.EXPECT().Bind(testBindUsername, testBindPassword).Times(1)
+				conn.EXPECT().Search(expectedUserSearch(nil)).Return(exampleUserSearchResult, nil).Times(1)
+				conn.EXPECT().SearchWithPaging(expectedGroupSearch(nil), expectedGroupSearchPageSize).
+					Return(exampleGroupSearchResult, nil).Times(1)
+				conn.EXPECT().Close().Times(1)
+			},
+			wantError: fmt.Sprintf(`error searching for group memberships for user with DN %q: some group parsing error`, testUserSearchResultDNValue),
+		},
+		{
+			name:     "when a user attribute check fails",
+			username: testUpstreamUsername,
+			password: testUpstreamPassword,
+			providerConfig: providerConfig(func(p *ProviderConfig) {
+				p.UserAttributeChecks = map[string]func(*ldap.Entry) error{
+					"some-check-attribute": func(entry *ldap.Entry) error { return errors.New("user account is disabled") },
+				}
+			}),
+			searchMocks: func(conn *mockldapconn.MockConn) {
+				conn.EXPECT().Bind(testBindUsername, testBindPassword).Times(1)
+				conn.EXPECT().Search(expectedUserSearch(func(r *ldap.SearchRequest) {
+					r.Attributes = []string{testUserSearchUsernameAttribute, testUserSearchUIDAttribute, "some-check-attribute"}
+				})).Return(exampleUserSearchResult, nil).Times(1)
+				conn.EXPECT().Close().Times(1)
+			},
+			wantUnauthenticated: true,
+		},
 		{
 			name:           "when binding as the found user returns an error",
 			username:       testUpstreamUsername,
@@ -1014,6 +1233,255 @@ func TestEndUserAuthentication(t *testing.T) {
 	}
 }
 
+func TestUpstreamRefresh(t *testing.T) {
+	providerConfig := func(editFunc func(p *ProviderConfig)) *ProviderConfig {
+		config := &ProviderConfig{
+			Name:               "some-provider-name",
+			Host:               testHost,
+			CABundle:           nil, // this field is only used by the production dialer, which is replaced by a mock for this test
+			ConnectionProtocol: TLS,
+			BindUsername:       testBindUsername,
+			BindPassword:       testBindPassword,
+			UserSearch: UserSearchConfig{
+				Base:              testUserSearchBase,
+				Filter:            testUserSearchFilter,
+				UsernameAttribute: testUserSearchUsernameAttribute,
+				UIDAttribute:      testUserSearchUIDAttribute,
+			},
+			GroupSearch: GroupSearchConfig{
+				Base:               testGroupSearchBase,
+				Filter:             testGroupSearchFilter,
+				GroupNameAttribute: testGroupSearchGroupNameAttribute,
+			},
+		}
+		if editFunc != nil {
+			editFunc(config)
+		}
+		return config
+	}
+
+	expectedRefreshUserSearch := func(editFunc func(r *ldap.SearchRequest)) *ldap.SearchRequest {
+		request := &ldap.SearchRequest{
+			BaseDN:       testUserSearchResultDNValue,
+			Scope:        ldap.ScopeBaseObject,
+			DerefAliases: ldap.NeverDerefAliases,
+			SizeLimit:    2,
+			TimeLimit:    90,
+			TypesOnly:    false,
+			Filter:       "(objectClass=*)",
+			Attributes:   []string{testUserSearchUsernameAttribute, testUserSearchUIDAttribute},
+			Controls:     nil,
+		}
+		if editFunc != nil {
+			editFunc(request)
+		}
+		return request
+	}
+
+	expectedGroupSearch := &ldap.SearchRequest{
+		BaseDN:       testGroupSearchBase,
+		Scope:        ldap.ScopeWholeSubtree,
+		DerefAliases: ldap.NeverDerefAliases,
+		SizeLimit:    0,
+		TimeLimit:    90,
+		TypesOnly:    false,
+		Filter:       testGroupSearchFilterInterpolated,
+		Attributes:   []string{testGroupSearchGroupNameAttribute},
+		Controls:     nil,
+	}
+
+	exampleUserSearchResult := &ldap.SearchResult{
+		Entries: []*ldap.Entry{
+			{
+				DN: testUserSearchResultDNValue,
+				Attributes: []*ldap.EntryAttribute{
+					ldap.NewEntryAttribute(testUserSearchUsernameAttribute, []string{testUserSearchResultUsernameAttributeValue}),
+					ldap.NewEntryAttribute(testUserSearchUIDAttribute, []string{testUserSearchResultUIDAttributeValue}),
+				},
+			},
+		},
+	}
+
+	exampleGroupSearchResult := &ldap.SearchResult{
+		Entries: []*ldap.Entry{
+			{
+				DN: testGroupSearchResultDNValue1,
+				Attributes: []*ldap.EntryAttribute{
+					ldap.NewEntryAttribute(testGroupSearchGroupNameAttribute, []string{testGroupSearchResultGroupNameAttributeValue1}),
+				},
+			},
+		},
+	}
+
+	tests := []struct {
+		name             string
+		userDN           string
+		providerConfig   *ProviderConfig
+		searchMocks      func(conn *mockldapconn.MockConn)
+		dialError        error
+		wantError        string
+		wantToSkipDial   bool
+		wantNotFound     bool
+		wantAuthResponse *authenticators.Response
+	}{
+		{
+			name:           "happy path",
+			userDN:         testUserSearchResultDNValue,
+			providerConfig: providerConfig(nil),
+			searchMocks: func(conn *mockldapconn.MockConn) {
+				conn.EXPECT().Bind(testBindUsername, testBindPassword).Times(1)
+				conn.EXPECT().Search(expectedRefreshUserSearch(nil)).Return(exampleUserSearchResult, nil).Times(1)
+				conn.EXPECT().SearchWithPaging(expectedGroupSearch, expectedGroupSearchPageSize).
+					Return(exampleGroupSearchResult, nil).Times(1)
+				conn.EXPECT().Close().Times(1)
+			},
+			wantAuthResponse: &authenticators.Response{
+				User: &user.DefaultInfo{
+					Name:   testUserSearchResultUsernameAttributeValue,
+					UID:    base64.RawURLEncoding.EncodeToString([]byte(testUserSearchResultUIDAttributeValue)),
+					Groups: []string{testGroupSearchResultGroupNameAttributeValue1},
+				},
+				DN: testUserSearchResultDNValue,
+				Attributes: map[string][]string{
+					"dn":                            {testUserSearchResultDNValue},
+					testUserSearchUsernameAttribute: {testUserSearchResultUsernameAttributeValue},
+					testUserSearchUIDAttribute:      {testUserSearchResultUIDAttributeValue},
+				},
+			},
+		},
+		{
+			name:           "when the user DN is empty",
+			userDN:         "",
+			providerConfig: providerConfig(nil),
+			wantToSkipDial: true,
+			wantNotFound:   true,
+		},
+		{
+			name:           "when dial fails",
+			userDN:         testUserSearchResultDNValue,
+			providerConfig: providerConfig(nil),
+			dialError:      errors.New("some dial error"),
+			wantError:      fmt.Sprintf(`error dialing host "%s": some dial error`, testHost),
+		},
+		{
+			name:           "when binding as the bind user fails",
+			userDN:         testUserSearchResultDNValue,
+			providerConfig: providerConfig(nil),
+			searchMocks: func(conn *mockldapconn.MockConn) {
+				conn.EXPECT().Bind(testBindUsername, testBindPassword).Return(errors.New("some bind error")).Times(1)
+				conn.EXPECT().Close().Times(1)
+			},
+			wantError: fmt.Sprintf(`error binding as "%s" before user search: some bind error`, testBindUsername),
+		},
+		{
+			name:           "when the user entry no longer exists",
+			userDN:         testUserSearchResultDNValue,
+			providerConfig: providerConfig(nil),
+			searchMocks: func(conn *mockldapconn.MockConn) {
+				conn.EXPECT().Bind(testBindUsername, testBindPassword).Times(1)
+				conn.EXPECT().Search(expectedRefreshUserSearch(nil)).
+					Return(nil, ldap.NewError(ldap.LDAPResultNoSuchObject, errors.New("no such object"))).Times(1)
+				conn.EXPECT().Close().Times(1)
+			},
+			wantNotFound: true,
+		},
+		{
+			name:           "when the search returns no entries",
+			userDN:         testUserSearchResultDNValue,
+			providerConfig: providerConfig(nil),
+			searchMocks: func(conn *mockldapconn.MockConn) {
+				conn.EXPECT().Bind(testBindUsername, testBindPassword).Times(1)
+				conn.EXPECT().Search(expectedRefreshUserSearch(nil)).Return(&ldap.SearchResult{}, nil).Times(1)
+				conn.EXPECT().Close().Times(1)
+			},
+			wantNotFound: true,
+		},
+		{
+			name:           "when the search fails",
+			userDN:         testUserSearchResultDNValue,
+			providerConfig: providerConfig(nil),
+			searchMocks: func(conn *mockldapconn.MockConn) {
+				conn.EXPECT().Bind(testBindUsername, testBindPassword).Times(1)
+				conn.EXPECT().Search(expectedRefreshUserSearch(nil)).Return(nil, errors.New("some search error")).Times(1)
+				conn.EXPECT().Close().Times(1)
+			},
+			wantError: fmt.Sprintf(`error searching for user with DN "%s": some search error`, testUserSearchResultDNValue),
+		},
+		{
+			name:           "when the search returns more than one entry",
+			userDN:         testUserSearchResultDNValue,
+			providerConfig: providerConfig(nil),
+			searchMocks: func(conn *mockldapconn.MockConn) {
+				conn.EXPECT().Bind(testBindUsername, testBindPassword).Times(1)
+				conn.EXPECT().Search(expectedRefreshUserSearch(nil)).Return(&ldap.SearchResult{
+					Entries: []*ldap.Entry{{DN: testUserSearchResultDNValue}, {DN: "some-other-dn"}},
+				}, nil).Times(1)
+				conn.EXPECT().Close().Times(1)
+			},
+			wantError: fmt.Sprintf(`searching for user with DN "%s" resulted in 2 search results, but expected 1 result`, testUserSearchResultDNValue),
+		},
+		{
+			name:   "when a user attribute check now fails",
+			userDN: testUserSearchResultDNValue,
+			providerConfig: providerConfig(func(p *ProviderConfig) {
+				p.UserAttributeChecks = map[string]func(*ldap.Entry) error{
+					"some-check-attribute": func(entry *ldap.Entry) error { return errors.New("user account is disabled") },
+				}
+			}),
+			searchMocks: func(conn *mockldapconn.MockConn) {
+				conn.EXPECT().Bind(testBindUsername, testBindPassword).Times(1)
+				conn.EXPECT().Search(expectedRefreshUserSearch(func(r *ldap.SearchRequest) {
+					r.Attributes = []string{testUserSearchUsernameAttribute, testUserSearchUIDAttribute, "some-check-attribute"}
+				})).Return(exampleUserSearchResult, nil).Times(1)
+				conn.EXPECT().Close().Times(1)
+			},
+			wantNotFound: true,
+		},
+	}
+
+	for _, test := range tests {
+		tt := test
+		t.Run(tt.name, func(t *testing.T) {
+			ctrl := gomock.NewController(t)
+			t.Cleanup(ctrl.Finish)
+
+			conn := mockldapconn.NewMockConn(ctrl)
+			if tt.searchMocks != nil {
+				tt.searchMocks(conn)
+			}
+
+			dialWasAttempted := false
+			tt.providerConfig.Dialer = LDAPDialerFunc(func(ctx context.Context, addr endpointaddr.HostPort) (Conn, error) {
+				dialWasAttempted = true
+				require.Equal(t, tt.providerConfig.Host, addr.Endpoint())
+				if tt.dialError != nil {
+					return nil, tt.dialError
+				}
+				return conn, nil
+			})
+
+			provider := New(*tt.providerConfig)
+
+			authResponse, found, err := provider.PerformRefresh(context.Background(), tt.userDN)
+			require.Equal(t, !tt.wantToSkipDial, dialWasAttempted)
+			switch {
+			case tt.wantError != "":
+				require.EqualError(t, err, tt.wantError)
+				require.False(t, found)
+				require.Nil(t, authResponse)
+			case tt.wantNotFound:
+				require.NoError(t, err)
+				require.False(t, found)
+				require.Nil(t, authResponse)
+			default:
+				require.NoError(t, err)
+				require.True(t, found)
+				require.Equal(t, tt.wantAuthResponse, authResponse)
+			}
+		})
+	}
+}
+
 func TestTestConnection(t *testing.T) {
 	providerConfig := func(editFunc func(p *ProviderConfig)) *ProviderConfig {
 		config := &ProviderConfig{
@@ -1062,6 +1530,19 @@ func TestTestConnection(t *testing.T) {
 			},
 			wantError: fmt.Sprintf(`error binding as "%s": some bind error`, testBindUsername),
 		},
+		{
+			name: "when there is a bind client certificate, binds with SASL EXTERNAL",
+			providerConfig: providerConfig(func(p *ProviderConfig) {
+				p.BindPassword = ""
+				p.BindClientCertificateData = []byte("some-client-certificate-pem")
+				p.BindClientKeyData = []byte("some-client-key-pem")
+			}),
+			setupMocks: func(conn *mockldapconn.MockConn) {
+				conn.EXPECT().ExternalBind().Return(errors.New("some bind error")).Times(1)
+				conn.EXPECT().Close().Times(1)
+			},
+			wantError: fmt.Sprintf(`error binding as "%s": some bind error`, testBindUsername),
+		},
 		{
 			name: "when the config is invalid",
 			providerConfig: providerConfig(func(p *ProviderConfig) {
@@ -1176,13 +1657,18 @@ func TestRealTLSDialing(t *testing.T) {
 	alreadyCancelledContext, cancelFunc := context.WithCancel(context.Background())
 	cancelFunc() // cancel it immediately
 
+	clientCertPEM, clientKeyPEM, err := caForTestServerWithBadCertName.IssueClientCertPEM("some-bind-account", nil, time.Hour)
+	require.NoError(t, err)
+
 	tests := []struct {
-		name      string
-		host      string
-		connProto LDAPConnectionProtocol
-		caBundle  []byte
-		context   context.Context
-		wantError string
+		name           string
+		host           string
+		connProto      LDAPConnectionProtocol
+		caBundle       []byte
+		clientCertData []byte
+		clientKeyData  []byte
+		context        context.Context
+		wantError      string
 	}{
 		{
 			name:      "happy path",
@@ -1191,6 +1677,25 @@ func TestRealTLSDialing(t *testing.T) {
 			connProto: TLS,
 			context:   context.Background(),
 		},
+		{
+			name:           "happy path with a bind client certificate",
+			host:           testServerHostAndPort,
+			caBundle:       []byte(testServerCABundle),
+			clientCertData: clientCertPEM,
+			clientKeyData:  clientKeyPEM,
+			connProto:      TLS,
+			context:        context.Background(),
+		},
+		{
+			name:           "invalid bind client certificate",
+			host:           testServerHostAndPort,
+			caBundle:       []byte(testServerCABundle),
+			clientCertData: []byte("not a certificate"),
+			clientKeyData:  clientKeyPEM,
+			connProto:      TLS,
+			context:        context.Background(),
+			wantError:      `LDAP Result Code 200 "Network Error": could not parse bind client certificate: tls: failed to find any PEM data in certificate input`,
+		},
 		{
 			name:      "server cert name does not match the address to which the client connected",
 			host:      testServerWithBadCertNameAddr,
@@ -1269,12 +1774,14 @@ func TestRealTLSDialing(t *testing.T) {
 		tt := test
 		t.Run(tt.name, func(t *testing.T) {
 			provider := New(ProviderConfig{
-				Host:               tt.host,
-				CABundle:           tt.caBundle,
-				ConnectionProtocol: tt.connProto,
-				Dialer:             nil, // this test is for the default (production) TLS dialer
+				Host:                      tt.host,
+				CABundle:                  tt.caBundle,
+				BindClientCertificateData: tt.clientCertData,
+				BindClientKeyData:         tt.clientKeyData,
+				ConnectionProtocol:        tt.connProto,
+				Dialer:                    nil, // this test is for the default (production) TLS dialer
 			})
-			conn, err := provider.dial(tt.context)
+			conn, err := provider.dial(tt.context, tt.host)
 			if conn != nil {
 				defer conn.Close()
 			}
@@ -1285,13 +1792,14 @@ func TestRealTLSDialing(t *testing.T) {
 				require.NoError(t, err)
 				require.NotNil(t, conn)
 
-				// Should be an instance of the real production LDAP client type.
+				// Should be an instance of the real production LDAP client type, wrapped to record metrics.
 				// Can't test its methods here because we are not dialed to a real LDAP server.
-				require.IsType(t, &ldap.Conn{}, conn)
+				require.IsType(t, &instrumentedConn{}, conn)
+				require.IsType(t, &ldap.Conn{}, conn.(*instrumentedConn).Conn)
 
 				// Indirectly checking that the Dialer method constructed the ldap.Conn with isTLS set to true,
 				// since this is always the correct behavior unless/until we want to support StartTLS.
-				err := conn.(*ldap.Conn).StartTLS(&tls.Config{})
+				err := conn.(*instrumentedConn).Conn.(*ldap.Conn).StartTLS(&tls.Config{})
 				require.EqualError(t, err, `LDAP Result Code 200 "Network Error": ldap: already encrypted`)
 			}
 		})
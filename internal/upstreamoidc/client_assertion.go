@@ -0,0 +1,138 @@
+// Copyright 2021 the Pinniped contributors. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package upstreamoidc
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"mime"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"golang.org/x/oauth2"
+	"gopkg.in/square/go-jose.v2"
+	"gopkg.in/square/go-jose.v2/jwt"
+)
+
+const (
+	// clientAssertionType is the client_assertion_type of a private_key_jwt client assertion, from RFC 7523.
+	clientAssertionType = "urn:ietf:params:oauth:client-assertion-type:jwt-bearer"
+
+	// clientAssertionLifetime is how long a client assertion may be used after it was signed.
+	clientAssertionLifetime = 5 * time.Minute
+)
+
+// clientAssertionParams returns the token endpoint parameters which authenticate the client using a newly signed
+// private_key_jwt client assertion, as described in RFC 7523 and in section 9 of OpenID Connect Core.
+func (p *ProviderConfig) clientAssertionParams() (url.Values, error) {
+	signer, err := jose.NewSigner(
+		jose.SigningKey{Algorithm: jose.SignatureAlgorithm(p.ClientAssertionKey.Algorithm), Key: p.ClientAssertionKey},
+		(&jose.SignerOptions{}).WithType("JWT"),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("could not create client assertion signer: %w", err)
+	}
+
+	var jti [16]byte
+	if _, err := io.ReadFull(rand.Reader, jti[:]); err != nil {
+		return nil, fmt.Errorf("could not generate client assertion ID: %w", err)
+	}
+
+	now := time.Now()
+	assertion, err := jwt.Signed(signer).Claims(jwt.Claims{
+		Issuer:   p.Config.ClientID,
+		Subject:  p.Config.ClientID,
+		Audience: jwt.Audience{p.Config.Endpoint.TokenURL},
+		ID:       hex.EncodeToString(jti[:]),
+		IssuedAt: jwt.NewNumericDate(now),
+		Expiry:   jwt.NewNumericDate(now.Add(clientAssertionLifetime)),
+	}).CompactSerialize()
+	if err != nil {
+		return nil, fmt.Errorf("could not sign client assertion: %w", err)
+	}
+
+	return url.Values{
+		"client_assertion_type": {clientAssertionType},
+		"client_assertion":      {assertion},
+	}, nil
+}
+
+// performRefreshWithClientAssertion is like PerformRefresh, but authenticates the client with a private_key_jwt
+// client assertion. The oauth2 package cannot add extra parameters to refresh requests, so this sends the request
+// itself.
+func (p *ProviderConfig) performRefreshWithClientAssertion(ctx context.Context, refreshToken string) (*oauth2.Token, error) {
+	params, err := p.clientAssertionParams()
+	if err != nil {
+		return nil, err
+	}
+	params.Set("grant_type", "refresh_token")
+	params.Set("refresh_token", refreshToken)
+	params.Set("client_id", p.Config.ClientID)
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, p.Config.Endpoint.TokenURL, strings.NewReader(params.Encode()))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+	httpClient := p.Client
+	if httpClient == nil {
+		httpClient = http.DefaultClient
+	}
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("oauth2: cannot fetch token: %w", err)
+	}
+	defer func() { _ = resp.Body.Close() }()
+
+	body, err := ioutil.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	if err != nil {
+		return nil, fmt.Errorf("oauth2: cannot fetch token: %w", err)
+	}
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return nil, &oauth2.RetrieveError{Response: resp, Body: body}
+	}
+	if contentType, _, _ := mime.ParseMediaType(resp.Header.Get("Content-Type")); contentType != "application/json" {
+		return nil, fmt.Errorf("oauth2: cannot parse token response with content type %q", contentType)
+	}
+
+	var tokenResponse struct {
+		AccessToken  string `json:"access_token"`
+		TokenType    string `json:"token_type"`
+		RefreshToken string `json:"refresh_token"`
+		ExpiresIn    int64  `json:"expires_in"`
+	}
+	var extra map[string]interface{}
+	if err := json.Unmarshal(body, &tokenResponse); err != nil {
+		return nil, fmt.Errorf("oauth2: cannot parse token response: %w", err)
+	}
+	if err := json.Unmarshal(body, &extra); err != nil {
+		return nil, fmt.Errorf("oauth2: cannot parse token response: %w", err)
+	}
+	if tokenResponse.AccessToken == "" {
+		return nil, errors.New("oauth2: server response missing access_token")
+	}
+
+	tok := &oauth2.Token{
+		AccessToken:  tokenResponse.AccessToken,
+		TokenType:    tokenResponse.TokenType,
+		RefreshToken: tokenResponse.RefreshToken,
+	}
+	if tokenResponse.ExpiresIn > 0 {
+		tok.Expiry = time.Now().Add(time.Duration(tokenResponse.ExpiresIn) * time.Second)
+	}
+	// Like the oauth2 package, keep using the old refresh token when the provider did not return a new one.
+	if tok.RefreshToken == "" {
+		tok.RefreshToken = refreshToken
+	}
+	return tok.WithExtra(extra), nil
+}
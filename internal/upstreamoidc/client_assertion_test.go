@@ -0,0 +1,125 @@
+// Copyright 2021 the Pinniped contributors. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package upstreamoidc
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"golang.org/x/oauth2"
+	"gopkg.in/square/go-jose.v2"
+	"gopkg.in/square/go-jose.v2/jwt"
+)
+
+func TestClientAssertion(t *testing.T) {
+	privateKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	key := &jose.JSONWebKey{Key: privateKey, KeyID: "test-key-id", Algorithm: string(jose.ES256)}
+
+	idTokenKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+	signer, err := jose.NewSigner(jose.SigningKey{Algorithm: jose.RS256, Key: idTokenKey}, nil)
+	require.NoError(t, err)
+	idToken, err := jwt.Signed(signer).Claims(jwt.Claims{Subject: "test-user", Audience: jwt.Audience{"test-client-id"}}).CompactSerialize()
+	require.NoError(t, err)
+
+	var tokenServerURL string
+	seenAssertionIDs := map[string]bool{}
+	tokenServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		require.Equal(t, http.MethodPost, r.Method)
+		require.NoError(t, r.ParseForm())
+		require.Equal(t, "test-client-id", r.Form.Get("client_id"))
+		require.Empty(t, r.Header.Get("Authorization"))
+		require.Equal(t, "urn:ietf:params:oauth:client-assertion-type:jwt-bearer", r.Form.Get("client_assertion_type"))
+
+		assertion, err := jwt.ParseSigned(r.Form.Get("client_assertion"))
+		require.NoError(t, err)
+		require.Len(t, assertion.Headers, 1)
+		require.Equal(t, "test-key-id", assertion.Headers[0].KeyID)
+		var claims jwt.Claims
+		require.NoError(t, assertion.Claims(&privateKey.PublicKey, &claims))
+		require.NoError(t, claims.Validate(jwt.Expected{
+			Issuer:   "test-client-id",
+			Subject:  "test-client-id",
+			Audience: jwt.Audience{tokenServerURL},
+			Time:     time.Now(),
+		}))
+		require.NotEmpty(t, claims.ID)
+		require.False(t, seenAssertionIDs[claims.ID], "client assertion was reused")
+		seenAssertionIDs[claims.ID] = true
+
+		switch r.Form.Get("grant_type") {
+		case "authorization_code":
+			require.Equal(t, "valid", r.Form.Get("code"))
+			require.Equal(t, "test-pkce", r.Form.Get("code_verifier"))
+		case "refresh_token":
+			if r.Form.Get("refresh_token") != "valid-refresh-token" {
+				http.Error(w, "invalid refresh token", http.StatusForbidden)
+				return
+			}
+		default:
+			t.Errorf("unexpected grant_type %q", r.Form.Get("grant_type"))
+		}
+
+		var response struct {
+			oauth2.Token
+			IDToken string `json:"id_token,omitempty"`
+		}
+		response.AccessToken = "test-access-token"
+		response.IDToken = "test-id-token"
+		if r.Form.Get("grant_type") == "authorization_code" {
+			response.RefreshToken = "test-refresh-token"
+			response.IDToken = idToken
+		}
+		w.Header().Set("content-type", "application/json")
+		require.NoError(t, json.NewEncoder(w).Encode(&response))
+	}))
+	t.Cleanup(tokenServer.Close)
+	tokenServerURL = tokenServer.URL
+
+	p := ProviderConfig{
+		Name:               "test-name",
+		ClientAuthMethod:   ClientAuthMethodPrivateKeyJWT,
+		ClientAssertionKey: key,
+		UserInfoMode:       UserInfoModeDisabled,
+		Config: &oauth2.Config{
+			ClientID: "test-client-id",
+			Endpoint: oauth2.Endpoint{
+				AuthURL:   "https://example.com",
+				TokenURL:  tokenServer.URL,
+				AuthStyle: oauth2.AuthStyleInParams,
+			},
+		},
+		Provider: &mockProvider{},
+	}
+
+	t.Run("ExchangeAuthcodeAndValidateTokens", func(t *testing.T) {
+		tok, err := p.ExchangeAuthcodeAndValidateTokens(context.Background(), "valid", "test-pkce", "", "https://example.com/callback")
+		require.NoError(t, err)
+		require.Equal(t, "test-access-token", tok.AccessToken.Token)
+		require.Equal(t, "test-refresh-token", tok.RefreshToken.Token)
+		require.Equal(t, "test-user", tok.IDToken.Claims["sub"])
+	})
+
+	t.Run("PerformRefresh", func(t *testing.T) {
+		tok, err := p.PerformRefresh(context.Background(), "valid-refresh-token")
+		require.NoError(t, err)
+		require.Equal(t, "test-access-token", tok.AccessToken)
+		require.Equal(t, "valid-refresh-token", tok.RefreshToken)
+		require.Equal(t, "test-id-token", tok.Extra("id_token"))
+
+		tok, err = p.PerformRefresh(context.Background(), "invalid-refresh-token")
+		require.EqualError(t, err, "oauth2: cannot fetch token: 403 Forbidden\nResponse: invalid refresh token\n")
+		require.Nil(t, tok)
+	})
+}
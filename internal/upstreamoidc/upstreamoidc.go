@@ -11,6 +11,7 @@ import (
 
 	coreosoidc "github.com/coreos/go-oidc/v3/oidc"
 	"golang.org/x/oauth2"
+	"gopkg.in/square/go-jose.v2"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 
 	"go.pinniped.dev/internal/httputil/httperr"
@@ -26,13 +27,50 @@ func New(config *oauth2.Config, provider *coreosoidc.Provider, client *http.Clie
 	return &ProviderConfig{Config: config, Provider: provider, Client: client}
 }
 
+// UserInfoMode determines whether the claims from the userinfo endpoint of an upstream OIDC provider are merged
+// into the claims of its ID tokens.
+type UserInfoMode string
+
+const (
+	// UserInfoModeIfAvailable calls the userinfo endpoint when the provider advertises one.
+	UserInfoModeIfAvailable = UserInfoMode("IfAvailable")
+
+	// UserInfoModeRequired calls the userinfo endpoint and fails when the provider does not advertise one.
+	UserInfoModeRequired = UserInfoMode("Required")
+
+	// UserInfoModeDisabled never calls the userinfo endpoint.
+	UserInfoModeDisabled = UserInfoMode("Disabled")
+)
+
+// ClientAuthMethod determines how the client authenticates itself at the token endpoint of an upstream OIDC provider.
+type ClientAuthMethod string
+
+const (
+	// ClientAuthMethodClientSecret authenticates using the client secret from the oauth2.Config.
+	ClientAuthMethodClientSecret = ClientAuthMethod("ClientSecret")
+
+	// ClientAuthMethodPrivateKeyJWT authenticates using a private_key_jwt client assertion (RFC 7523) which is
+	// signed by the ClientAssertionKey.
+	ClientAuthMethodPrivateKeyJWT = ClientAuthMethod("PrivateKeyJWT")
+
+	// ClientAuthMethodTLSClientAuth authenticates using the client certificate which was configured on the
+	// http.Client (RFC 8705).
+	ClientAuthMethodTLSClientAuth = ClientAuthMethod("TLSClientAuth")
+)
+
 // ProviderConfig holds the active configuration of an upstream OIDC provider.
 type ProviderConfig struct {
-	Name          string
-	UsernameClaim string
-	GroupsClaim   string
-	Config        *oauth2.Config
-	Provider      interface {
+	Name                     string
+	UsernameClaim            string
+	GroupsClaim              string
+	AdditionalClaimMappings  map[string]string
+	AdditionalAuthcodeParams map[string]string
+	UserInfoMode             UserInfoMode     // empty means to use UserInfoModeIfAvailable
+	ClientAuthMethod         ClientAuthMethod // empty means to use ClientAuthMethodClientSecret
+	ClientAssertionKey       *jose.JSONWebKey // the private key for ClientAuthMethodPrivateKeyJWT
+	EndSessionURL            string           // empty means not to end the upstream session on logout
+	Config                   *oauth2.Config
+	Provider                 interface {
 		Verifier(*coreosoidc.Config) *coreosoidc.IDTokenVerifier
 		UserInfo(ctx context.Context, tokenSource oauth2.TokenSource) (*coreosoidc.UserInfo, error)
 	}
@@ -52,10 +90,22 @@ func (p *ProviderConfig) GetAuthorizationURL() *url.URL {
 	return result
 }
 
+func (p *ProviderConfig) GetEndSessionURL() *url.URL {
+	if p.EndSessionURL == "" {
+		return nil
+	}
+	result, _ := url.Parse(p.EndSessionURL)
+	return result
+}
+
 func (p *ProviderConfig) GetScopes() []string {
 	return p.Config.Scopes
 }
 
+func (p *ProviderConfig) GetAdditionalAuthcodeParams() map[string]string {
+	return p.AdditionalAuthcodeParams
+}
+
 func (p *ProviderConfig) GetUsernameClaim() string {
 	return p.UsernameClaim
 }
@@ -64,12 +114,29 @@ func (p *ProviderConfig) GetGroupsClaim() string {
 	return p.GroupsClaim
 }
 
+func (p *ProviderConfig) GetAdditionalClaimMappings() map[string]string {
+	return p.AdditionalClaimMappings
+}
+
 func (p *ProviderConfig) ExchangeAuthcodeAndValidateTokens(ctx context.Context, authcode string, pkceCodeVerifier pkce.Code, expectedIDTokenNonce nonce.Nonce, redirectURI string) (*oidctypes.Token, error) {
+	authCodeOptions := []oauth2.AuthCodeOption{
+		pkceCodeVerifier.Verifier(),
+		oauth2.SetAuthURLParam("redirect_uri", redirectURI),
+	}
+	if p.ClientAuthMethod == ClientAuthMethodPrivateKeyJWT {
+		params, err := p.clientAssertionParams()
+		if err != nil {
+			return nil, err
+		}
+		for name := range params {
+			authCodeOptions = append(authCodeOptions, oauth2.SetAuthURLParam(name, params.Get(name)))
+		}
+	}
+
 	tok, err := p.Config.Exchange(
 		coreosoidc.ClientContext(ctx, p.Client),
 		authcode,
-		pkceCodeVerifier.Verifier(),
-		oauth2.SetAuthURLParam("redirect_uri", redirectURI),
+		authCodeOptions...,
 	)
 	if err != nil {
 		return nil, err
@@ -78,6 +145,21 @@ func (p *ProviderConfig) ExchangeAuthcodeAndValidateTokens(ctx context.Context,
 	return p.ValidateToken(ctx, tok, expectedIDTokenNonce)
 }
 
+// PerformRefresh uses the upstream refresh token to request new tokens from the upstream provider. A successful
+// refresh shows that the upstream provider still considers the user's session to be valid. The returned token's
+// ID token, if any, has not been validated yet.
+func (p *ProviderConfig) PerformRefresh(ctx context.Context, refreshToken string) (*oauth2.Token, error) {
+	if p.ClientAuthMethod == ClientAuthMethodPrivateKeyJWT {
+		return p.performRefreshWithClientAssertion(ctx, refreshToken)
+	}
+
+	// Use the provided HTTP client to benefit from its CA, proxy, and other settings.
+	httpClientContext := coreosoidc.ClientContext(ctx, p.Client)
+	// Create a TokenSource without an access token, so it thinks that a refresh is immediately required.
+	// Then ask it for the tokens to cause it to perform the refresh and return the results.
+	return p.Config.TokenSource(httpClientContext, &oauth2.Token{RefreshToken: refreshToken}).Token()
+}
+
 func (p *ProviderConfig) ValidateToken(ctx context.Context, tok *oauth2.Token, expectedIDTokenNonce nonce.Nonce) (*oidctypes.Token, error) {
 	idTok, hasIDTok := tok.Extra("id_token").(string)
 	if !hasIDTok {
@@ -127,6 +209,10 @@ func (p *ProviderConfig) ValidateToken(ctx context.Context, tok *oauth2.Token, e
 }
 
 func (p *ProviderConfig) fetchUserInfo(ctx context.Context, tok *oauth2.Token, claims map[string]interface{}) error {
+	if p.UserInfoMode == UserInfoModeDisabled {
+		return nil
+	}
+
 	idTokenSubject, _ := claims[oidc.IDTokenSubjectClaim].(string)
 	if len(idTokenSubject) == 0 {
 		return nil // defer to existing ID token validation
@@ -136,7 +222,7 @@ func (p *ProviderConfig) fetchUserInfo(ctx context.Context, tok *oauth2.Token, c
 	if err != nil {
 		// the user info endpoint is not required but we do not have a good way to probe if it was provided
 		const userInfoUnsupported = "oidc: user info endpoint is not supported by this provider"
-		if err.Error() == userInfoUnsupported {
+		if err.Error() == userInfoUnsupported && p.UserInfoMode != UserInfoModeRequired {
 			return nil
 		}
 
@@ -33,6 +33,7 @@ func TestProviderConfig(t *testing.T) {
 			Name:          "test-name",
 			UsernameClaim: "test-username-claim",
 			GroupsClaim:   "test-groups-claim",
+			EndSessionURL: "https://example.com/logout",
 			Config: &oauth2.Config{
 				ClientID: "test-client-id",
 				Endpoint: oauth2.Endpoint{AuthURL: "https://example.com"},
@@ -42,9 +43,56 @@ func TestProviderConfig(t *testing.T) {
 		require.Equal(t, "test-name", p.GetName())
 		require.Equal(t, "test-client-id", p.GetClientID())
 		require.Equal(t, "https://example.com", p.GetAuthorizationURL().String())
+		require.Equal(t, "https://example.com/logout", p.GetEndSessionURL().String())
 		require.ElementsMatch(t, []string{"scope1", "scope2"}, p.GetScopes())
 		require.Equal(t, "test-username-claim", p.GetUsernameClaim())
 		require.Equal(t, "test-groups-claim", p.GetGroupsClaim())
+		require.Nil(t, (&ProviderConfig{}).GetEndSessionURL())
+	})
+
+	t.Run("PerformRefresh", func(t *testing.T) {
+		tokenServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			require.Equal(t, http.MethodPost, r.Method)
+			require.NoError(t, r.ParseForm())
+			require.Equal(t, "test-client-id", r.Form.Get("client_id"))
+			require.Equal(t, "refresh_token", r.Form.Get("grant_type"))
+			if r.Form.Get("refresh_token") != "valid-refresh-token" {
+				http.Error(w, "invalid refresh token", http.StatusForbidden)
+				return
+			}
+			var response struct {
+				oauth2.Token
+				IDToken string `json:"id_token,omitempty"`
+			}
+			response.AccessToken = "test-access-token"
+			response.RefreshToken = "test-new-refresh-token"
+			response.IDToken = "test-id-token"
+			w.Header().Set("content-type", "application/json")
+			require.NoError(t, json.NewEncoder(w).Encode(&response))
+		}))
+		t.Cleanup(tokenServer.Close)
+
+		p := ProviderConfig{
+			Name: "test-name",
+			Config: &oauth2.Config{
+				ClientID: "test-client-id",
+				Endpoint: oauth2.Endpoint{
+					AuthURL:   "https://example.com",
+					TokenURL:  tokenServer.URL,
+					AuthStyle: oauth2.AuthStyleInParams,
+				},
+			},
+		}
+
+		tok, err := p.PerformRefresh(context.Background(), "valid-refresh-token")
+		require.NoError(t, err)
+		require.Equal(t, "test-access-token", tok.AccessToken)
+		require.Equal(t, "test-new-refresh-token", tok.RefreshToken)
+		require.Equal(t, "test-id-token", tok.Extra("id_token"))
+
+		tok, err = p.PerformRefresh(context.Background(), "invalid-refresh-token")
+		require.EqualError(t, err, "oauth2: cannot fetch token: 403 Forbidden\nResponse: invalid refresh token\n")
+		require.Nil(t, tok)
 	})
 
 	const (
@@ -74,6 +122,7 @@ func TestProviderConfig(t *testing.T) {
 		wantErr     string
 		wantToken   oidctypes.Token
 
+		userInfoMode       UserInfoMode
 		userInfo           *oidc.UserInfo
 		userInfoErr        error
 		wantUserInfoCalled bool
@@ -172,6 +221,14 @@ func TestProviderConfig(t *testing.T) {
 			wantErr:     "could not fetch user info claims: could not get user info: some network error",
 			userInfoErr: errors.New("some network error"),
 		},
+		{
+			name:         "user info not supported when required",
+			authCode:     "valid",
+			returnIDTok:  validIDToken,
+			wantErr:      "could not fetch user info claims: could not get user info: oidc: user info endpoint is not supported by this provider",
+			userInfoMode: UserInfoModeRequired,
+			userInfoErr:  userInfoNotSupported,
+		},
 		{
 			name:        "user info sub error",
 			authCode:    "valid",
@@ -218,6 +275,69 @@ func TestProviderConfig(t *testing.T) {
 			userInfo:           forceUserInfoWithClaims("test-user", `{"foo":"awesomeness","groups":"fancy-group"}`),
 			wantUserInfoCalled: true,
 		},
+		{
+			name:         "valid with user info when required",
+			authCode:     "valid",
+			returnIDTok:  validIDToken,
+			userInfoMode: UserInfoModeRequired,
+			wantToken: oidctypes.Token{
+				AccessToken: &oidctypes.AccessToken{
+					Token:  "test-access-token",
+					Expiry: metav1.Time{},
+				},
+				RefreshToken: &oidctypes.RefreshToken{
+					Token: "test-refresh-token",
+				},
+				IDToken: &oidctypes.IDToken{
+					Token:  validIDToken,
+					Expiry: metav1.Time{},
+					Claims: map[string]interface{}{
+						"foo":      "bar",
+						"bat":      "baz",
+						"aud":      "test-client-id",
+						"iat":      1.606768593e+09,
+						"jti":      "test-jti",
+						"nbf":      1.606768593e+09,
+						"sub":      "test-user",
+						"username": "test-username", // add a new claim
+						"groups":   []interface{}{"group-1", "group-2"},
+					},
+				},
+			},
+			// claims is private field so we have to use hacks to set it
+			userInfo:           forceUserInfoWithClaims("test-user", `{"username":"test-username","groups":["group-1","group-2"]}`),
+			wantUserInfoCalled: true,
+		},
+		{
+			name:         "user info disabled",
+			authCode:     "valid",
+			returnIDTok:  validIDToken,
+			userInfoMode: UserInfoModeDisabled,
+			wantToken: oidctypes.Token{
+				AccessToken: &oidctypes.AccessToken{
+					Token:  "test-access-token",
+					Expiry: metav1.Time{},
+				},
+				RefreshToken: &oidctypes.RefreshToken{
+					Token: "test-refresh-token",
+				},
+				IDToken: &oidctypes.IDToken{
+					Token:  validIDToken,
+					Expiry: metav1.Time{},
+					Claims: map[string]interface{}{
+						"foo": "bar",
+						"bat": "baz",
+						"aud": "test-client-id",
+						"iat": 1.606768593e+09,
+						"jti": "test-jti",
+						"nbf": 1.606768593e+09,
+						"sub": "test-user",
+					},
+				},
+			},
+			userInfo:           forceUserInfoWithClaims("test-user", `{"foo":"awesomeness"}`),
+			wantUserInfoCalled: false,
+		},
 		{
 			name:        "invalid sub claim",
 			authCode:    "valid",
@@ -278,6 +398,7 @@ func TestProviderConfig(t *testing.T) {
 				Name:          "test-name",
 				UsernameClaim: "test-username-claim",
 				GroupsClaim:   "test-groups-claim",
+				UserInfoMode:  tt.userInfoMode,
 				Config: &oauth2.Config{
 					ClientID: "test-client-id",
 					Endpoint: oauth2.Endpoint{
@@ -156,3 +156,18 @@ func (c *sessionCache) lookup(key oidcclient.SessionCacheKey) *sessionEntry {
 func (c *sessionCache) insert(entries ...sessionEntry) {
 	c.Sessions = append(c.Sessions, entries...)
 }
+
+// remove all of the cache entries of the issuer, returning the removed entries.
+func (c *sessionCache) remove(issuer string) []sessionEntry {
+	var removed []sessionEntry
+	kept := make([]sessionEntry, 0, len(c.Sessions))
+	for _, s := range c.Sessions {
+		if s.Key.Issuer == issuer {
+			removed = append(removed, s)
+			continue
+		}
+		kept = append(kept, s)
+	}
+	c.Sessions = kept
+	return removed
+}
@@ -268,3 +268,24 @@ func TestInsert(t *testing.T) {
 	c.insert(sessionEntry{})
 	require.Len(t, c.Sessions, 1)
 }
+
+func TestRemove(t *testing.T) {
+	t.Parallel()
+	c := emptySessionCache()
+	c.insert(
+		sessionEntry{Key: oidcclient.SessionCacheKey{Issuer: "test-issuer", ClientID: "test-client-id-1"}},
+		sessionEntry{Key: oidcclient.SessionCacheKey{Issuer: "other-issuer", ClientID: "test-client-id-1"}},
+		sessionEntry{Key: oidcclient.SessionCacheKey{Issuer: "test-issuer", ClientID: "test-client-id-2"}},
+	)
+	require.Empty(t, c.remove("unknown-issuer"))
+	require.Len(t, c.Sessions, 3)
+
+	removed := c.remove("test-issuer")
+	require.Equal(t, []sessionEntry{
+		{Key: oidcclient.SessionCacheKey{Issuer: "test-issuer", ClientID: "test-client-id-1"}},
+		{Key: oidcclient.SessionCacheKey{Issuer: "test-issuer", ClientID: "test-client-id-2"}},
+	}, removed)
+	require.Equal(t, []sessionEntry{
+		{Key: oidcclient.SessionCacheKey{Issuer: "other-issuer", ClientID: "test-client-id-1"}},
+	}, c.Sessions)
+}
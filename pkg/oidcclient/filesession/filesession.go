@@ -113,6 +113,28 @@ func (c *Cache) PutToken(key oidcclient.SessionCacheKey, token *oidctypes.Token)
 	})
 }
 
+// DeleteTokens removes all of the cached sessions of the given issuer, and returns their tokens keyed by the client ID
+// of each session, e.g. so that the refresh tokens can be revoked. It does not return an error but may silently fail
+// to update the session cache.
+func (c *Cache) DeleteTokens(issuer string) map[string][]*oidctypes.Token {
+	// If the cache file does not exist, exit immediately with no error log
+	if _, err := os.Stat(c.path); errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+
+	var result map[string][]*oidctypes.Token
+	c.withCache(func(cache *sessionCache) {
+		for _, entry := range cache.remove(issuer) {
+			if result == nil {
+				result = map[string][]*oidctypes.Token{}
+			}
+			tokens := entry.Tokens
+			result[entry.Key.ClientID] = append(result[entry.Key.ClientID], &tokens)
+		}
+	})
+	return result
+}
+
 // withCache is an internal helper which locks, reads the cache, processes/mutates it with the provided function, then
 // saves it back to the file.
 func (c *Cache) withCache(transact func(*sessionCache)) {
@@ -497,6 +497,97 @@ func TestPutToken(t *testing.T) {
 	}
 }
 
+func TestDeleteTokens(t *testing.T) {
+	t.Parallel()
+	now := time.Now().Round(1 * time.Second)
+	newEntry := func(issuer, clientID, refreshToken string) sessionEntry {
+		return sessionEntry{
+			Key: oidcclient.SessionCacheKey{
+				Issuer:      issuer,
+				ClientID:    clientID,
+				Scopes:      []string{"email", "offline_access", "openid", "profile"},
+				RedirectURI: "http://localhost:0/callback",
+			},
+			CreationTimestamp: metav1.NewTime(now.Add(-2 * time.Hour)),
+			LastUsedTimestamp: metav1.NewTime(now.Add(-1 * time.Hour)),
+			Tokens: oidctypes.Token{
+				RefreshToken: &oidctypes.RefreshToken{Token: refreshToken},
+			},
+		}
+	}
+	tests := []struct {
+		name         string
+		makeTestFile func(t *testing.T, tmp string)
+		issuer       string
+		want         map[string][]*oidctypes.Token
+		wantErrors   []string
+		wantTestFile func(t *testing.T, tmp string)
+	}{
+		{
+			name:   "not found",
+			issuer: "test-issuer",
+		},
+		{
+			name: "invalid file",
+			makeTestFile: func(t *testing.T, tmp string) {
+				require.NoError(t, ioutil.WriteFile(tmp, []byte("invalid yaml"), 0600))
+			},
+			issuer: "test-issuer",
+			wantErrors: []string{
+				"failed to read cache, resetting: invalid session file: error unmarshaling JSON: while decoding JSON: json: cannot unmarshal string into Go value of type filesession.sessionCache",
+			},
+		},
+		{
+			name: "valid file with sessions of several issuers",
+			makeTestFile: func(t *testing.T, tmp string) {
+				validCache := emptySessionCache()
+				validCache.insert(
+					newEntry("test-issuer", "test-client-id", "test-refresh-token-1"),
+					newEntry("other-issuer", "test-client-id", "other-refresh-token"),
+					newEntry("test-issuer", "test-client-id", "test-refresh-token-2"),
+					newEntry("test-issuer", "other-client-id", "test-refresh-token-3"),
+				)
+				require.NoError(t, validCache.writeTo(tmp))
+			},
+			issuer: "test-issuer",
+			want: map[string][]*oidctypes.Token{
+				"test-client-id": {
+					{RefreshToken: &oidctypes.RefreshToken{Token: "test-refresh-token-1"}},
+					{RefreshToken: &oidctypes.RefreshToken{Token: "test-refresh-token-2"}},
+				},
+				"other-client-id": {
+					{RefreshToken: &oidctypes.RefreshToken{Token: "test-refresh-token-3"}},
+				},
+			},
+			wantTestFile: func(t *testing.T, tmp string) {
+				cache, err := readSessionCache(tmp)
+				require.NoError(t, err)
+				require.Len(t, cache.Sessions, 1)
+				require.Equal(t, "other-issuer", cache.Sessions[0].Key.Issuer)
+			},
+		},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+			tmp := testutil.TempDir(t) + "/sessions.yaml"
+			if tt.makeTestFile != nil {
+				tt.makeTestFile(t, tmp)
+			}
+			// Initialize a cache with a reporter that collects errors
+			errors := errorCollector{t: t}
+			c := New(tmp, errors.collect())
+			got := c.DeleteTokens(tt.issuer)
+			require.Equal(t, tt.want, got)
+			errors.require(tt.wantErrors, "TEMPFILE", tmp)
+			if tt.wantTestFile != nil {
+				tt.wantTestFile(t, tmp)
+			}
+		})
+	}
+}
+
 type errorCollector struct {
 	t   *testing.T
 	saw []error
@@ -77,6 +77,7 @@ type handlerState struct {
 	upstreamIdentityProviderName string
 	upstreamIdentityProviderType string
 	cliToSendCredentials         bool
+	useDeviceFlow                bool
 
 	requestedAudience string
 
@@ -91,8 +92,15 @@ type handlerState struct {
 	oauth2Config *oauth2.Config
 	useFormPost  bool
 	state        state.State
-	nonce        nonce.Nonce
-	pkce         pkce.Code
+
+	// The device authorization endpoint from OIDC discovery, if the issuer supports the device authorization grant.
+	deviceAuthorizationURL string
+
+	// The token revocation endpoint from OIDC discovery, if the issuer supports token revocation.
+	revocationURL string
+
+	nonce nonce.Nonce
+	pkce  pkce.Code
 
 	// External calls for things.
 	generateState   func() (state.State, error)
@@ -105,6 +113,7 @@ type handlerState struct {
 	validateIDToken func(ctx context.Context, provider *oidc.Provider, audience string, token string) (*oidc.IDToken, error)
 	promptForValue  func(ctx context.Context, promptLabel string) (string, error)
 	promptForSecret func(ctx context.Context, promptLabel string) (string, error)
+	pollDelay       func(time.Duration) <-chan time.Time
 
 	callbacks chan callbackResult
 }
@@ -250,6 +259,18 @@ func WithUpstreamIdentityProvider(upstreamName, upstreamType string) Option {
 	}
 }
 
+// WithDeviceFlow causes the login flow to use the OAuth 2.0 Device Authorization Grant (RFC8628) instead of a
+// localhost listener. The CLI prints a link and a code, which the user can use to log in from a web browser on any
+// other device, while the CLI polls the issuer's token endpoint until the login is finished. This is useful when
+// the CLI runs on a machine which does not have a web browser, such as a remote server.
+// This is currently only supported by Pinniped Supervisors, using OIDCIdentityProviders.
+func WithDeviceFlow() Option {
+	return func(h *handlerState) error {
+		h.useDeviceFlow = true
+		return nil
+	}
+}
+
 // nopCache is a SessionCache that doesn't actually do anything.
 type nopCache struct{}
 
@@ -283,6 +304,7 @@ func Login(issuer string, clientID string, opts ...Option) (*oidctypes.Token, er
 		},
 		promptForValue:  promptForValue,
 		promptForSecret: promptForSecret,
+		pollDelay:       time.After,
 	}
 	for _, opt := range opts {
 		if err := opt(&h); err != nil {
@@ -387,6 +409,9 @@ func (h *handlerState) baseLogin() (*oidctypes.Token, error) {
 	if h.cliToSendCredentials {
 		authFunc = h.cliBasedAuth
 	}
+	if h.useDeviceFlow {
+		authFunc = h.deviceFlowAuth
+	}
 
 	// Perform the authorize request and authcode exchange to get back OIDC tokens.
 	token, err := authFunc(&authorizeOptions)
@@ -592,6 +617,137 @@ func (h *handlerState) promptForWebLogin(ctx context.Context, authorizeURL strin
 	}()
 }
 
+// Start a device authorization request, and ask the user to visit the verification URI from any web browser.
+// Poll the token endpoint until the user has finished logging in. Return the tokens or an error.
+// See https://datatracker.ietf.org/doc/html/rfc8628.
+func (h *handlerState) deviceFlowAuth(_ *[]oauth2.AuthCodeOption) (*oidctypes.Token, error) {
+	if h.deviceAuthorizationURL == "" {
+		return nil, fmt.Errorf("issuer %q does not support the device authorization grant", h.issuer)
+	}
+
+	params := url.Values{
+		"client_id": []string{h.clientID},
+		"scope":     []string{strings.Join(h.scopes, " ")},
+	}
+	if h.upstreamIdentityProviderName != "" {
+		params.Set(supervisorAuthorizeUpstreamNameParam, h.upstreamIdentityProviderName)
+		params.Set(supervisorAuthorizeUpstreamTypeParam, h.upstreamIdentityProviderType)
+	}
+
+	var deviceAuthorization struct {
+		DeviceCode              string `json:"device_code"`
+		UserCode                string `json:"user_code"`
+		VerificationURI         string `json:"verification_uri"`
+		VerificationURIComplete string `json:"verification_uri_complete"`
+		ExpiresIn               int64  `json:"expires_in"`
+		Interval                int64  `json:"interval"`
+	}
+	if _, err := h.postForm(h.deviceAuthorizationURL, params, &deviceAuthorization); err != nil {
+		return nil, fmt.Errorf("device authorization request failed: %w", err)
+	}
+	if deviceAuthorization.DeviceCode == "" || deviceAuthorization.UserCode == "" || deviceAuthorization.VerificationURI == "" {
+		return nil, fmt.Errorf("device authorization response is missing required parameters")
+	}
+
+	verificationURI := deviceAuthorization.VerificationURIComplete
+	if verificationURI == "" {
+		verificationURI = deviceAuthorization.VerificationURI
+	}
+	_, _ = fmt.Fprintf(os.Stderr, "Log in by visiting this link from any device:\n\n    %s\n\nand confirming that it shows this code:\n\n    %s\n\n",
+		verificationURI, deviceAuthorization.UserCode)
+
+	// The default polling interval is 5 seconds. See https://datatracker.ietf.org/doc/html/rfc8628#section-3.2.
+	interval := time.Duration(deviceAuthorization.Interval) * time.Second
+	if interval <= 0 {
+		interval = 5 * time.Second
+	}
+
+	for {
+		select {
+		case <-h.ctx.Done():
+			return nil, fmt.Errorf("timed out waiting for device authorization: %w", h.ctx.Err())
+		case <-h.pollDelay(interval):
+		}
+
+		var tokenResponse struct {
+			AccessToken  string `json:"access_token"`
+			TokenType    string `json:"token_type"`
+			RefreshToken string `json:"refresh_token"`
+			ExpiresIn    int64  `json:"expires_in"`
+			IDToken      string `json:"id_token"`
+		}
+		errorCode, err := h.postForm(h.oauth2Config.Endpoint.TokenURL, url.Values{
+			"client_id":   []string{h.clientID},
+			"grant_type":  []string{"urn:ietf:params:oauth:grant-type:device_code"},
+			"device_code": []string{deviceAuthorization.DeviceCode},
+		}, &tokenResponse)
+
+		// See https://datatracker.ietf.org/doc/html/rfc8628#section-3.5 for the errors which mean "keep polling".
+		switch {
+		case errorCode == "authorization_pending":
+			continue
+		case errorCode == "slow_down":
+			interval += 5 * time.Second
+			continue
+		case err != nil:
+			return nil, fmt.Errorf("device authorization failed: %w", err)
+		}
+
+		tok := (&oauth2.Token{
+			AccessToken:  tokenResponse.AccessToken,
+			TokenType:    tokenResponse.TokenType,
+			RefreshToken: tokenResponse.RefreshToken,
+			Expiry:       time.Now().Add(time.Duration(tokenResponse.ExpiresIn) * time.Second),
+		}).WithExtra(map[string]interface{}{"id_token": tokenResponse.IDToken})
+
+		// There is no nonce in the device authorization grant, so skip the nonce validation (but not other validations).
+		return h.getProvider(h.oauth2Config, h.provider, h.httpClient).ValidateToken(h.ctx, tok, "")
+	}
+}
+
+// postForm makes a form POST request to an OAuth endpoint and decodes its JSON response into result. When the
+// endpoint returns an OAuth error response, the error code is also returned along with the error.
+func (h *handlerState) postForm(endpointURL string, params url.Values, result interface{}) (string, error) {
+	ctx, cancel := context.WithTimeout(h.ctx, httpRequestTimeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, endpointURL, strings.NewReader(params.Encode()))
+	if err != nil {
+		return "", fmt.Errorf("could not build request: %w", err)
+	}
+	req.Header.Set("content-type", "application/x-www-form-urlencoded")
+
+	resp, err := h.httpClient.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer func() { _ = resp.Body.Close() }()
+
+	mediaType, _, err := mime.ParseMediaType(resp.Header.Get("content-type"))
+	if err != nil || mediaType != "application/json" {
+		return "", fmt.Errorf("unexpected HTTP response status %d", resp.StatusCode)
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		// See https://datatracker.ietf.org/doc/html/rfc6749#section-5.2.
+		var errorResponse struct {
+			Error            string `json:"error"`
+			ErrorDescription string `json:"error_description"`
+		}
+		if err := json.NewDecoder(resp.Body).Decode(&errorResponse); err != nil || errorResponse.Error == "" {
+			return "", fmt.Errorf("unexpected HTTP response status %d", resp.StatusCode)
+		}
+		if errorResponse.ErrorDescription == "" {
+			return errorResponse.Error, fmt.Errorf("login failed with code %q", errorResponse.Error)
+		}
+		return errorResponse.Error, fmt.Errorf("login failed with code %q: %s", errorResponse.Error, errorResponse.ErrorDescription)
+	}
+
+	if err := json.NewDecoder(resp.Body).Decode(result); err != nil {
+		return "", fmt.Errorf("failed to decode response: %w", err)
+	}
+	return "", nil
+}
+
 func promptForValue(ctx context.Context, promptLabel string) (string, error) {
 	if !term.IsTerminal(int(os.Stdin.Fd())) {
 		return "", errors.New("stdin is not connected to a terminal")
@@ -671,12 +827,16 @@ func (h *handlerState) initOIDCDiscovery() error {
 
 	// Use response_mode=form_post if the provider supports it.
 	var discoveryClaims struct {
-		ResponseModesSupported []string `json:"response_modes_supported"`
+		ResponseModesSupported      []string `json:"response_modes_supported"`
+		DeviceAuthorizationEndpoint string   `json:"device_authorization_endpoint"`
+		RevocationEndpoint          string   `json:"revocation_endpoint"`
 	}
 	if err := h.provider.Claims(&discoveryClaims); err != nil {
 		return fmt.Errorf("could not decode response_modes_supported in OIDC discovery from %q: %w", h.issuer, err)
 	}
 	h.useFormPost = stringSliceContains(discoveryClaims.ResponseModesSupported, "form_post")
+	h.deviceAuthorizationURL = discoveryClaims.DeviceAuthorizationEndpoint
+	h.revocationURL = discoveryClaims.RevocationEndpoint
 	return nil
 }
 
@@ -238,6 +238,63 @@ func TestLogin(t *testing.T) { // nolint:gocyclo
 	formPostProviderMux.HandleFunc("/.well-known/openid-configuration", discoveryHandler(formPostSuccessServer, []string{"query", "form_post"}))
 	formPostProviderMux.HandleFunc("/token", tokenHandler)
 
+	// Start a test server that supports the device authorization grant. The token endpoint answers with
+	// authorization_pending once before returning tokens, or with expired_token for the "expired" device code.
+	deviceProviderMux := http.NewServeMux()
+	deviceSuccessServer := httptest.NewServer(deviceProviderMux)
+	t.Cleanup(deviceSuccessServer.Close)
+	deviceProviderMux.HandleFunc("/.well-known/openid-configuration", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("content-type", "application/json")
+		_ = json.NewEncoder(w).Encode(&struct {
+			Issuer                      string `json:"issuer"`
+			AuthURL                     string `json:"authorization_endpoint"`
+			TokenURL                    string `json:"token_endpoint"`
+			JWKSURL                     string `json:"jwks_uri"`
+			DeviceAuthorizationEndpoint string `json:"device_authorization_endpoint"`
+		}{
+			Issuer:                      deviceSuccessServer.URL,
+			AuthURL:                     deviceSuccessServer.URL + "/authorize",
+			TokenURL:                    deviceSuccessServer.URL + "/token",
+			JWKSURL:                     deviceSuccessServer.URL + "/keys",
+			DeviceAuthorizationEndpoint: deviceSuccessServer.URL + "/device_authorization",
+		})
+	})
+	deviceProviderMux.HandleFunc("/device_authorization", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "unexpected method", http.StatusMethodNotAllowed)
+			return
+		}
+		require.NoError(t, r.ParseForm())
+		require.Equal(t, "test-scope", r.Form.Get("scope"))
+		deviceCode := "test-device-code"
+		if r.Form.Get("client_id") == "test-client-id-expired" {
+			deviceCode = "expired"
+		}
+		w.Header().Set("content-type", "application/json")
+		_, _ = fmt.Fprintf(w, `{"device_code":%q,"user_code":"BCDF-GHJK","verification_uri":"%s/device","expires_in":600,"interval":5}`,
+			deviceCode, deviceSuccessServer.URL)
+	})
+	devicePolls := 0
+	deviceProviderMux.HandleFunc("/token", func(w http.ResponseWriter, r *http.Request) {
+		require.NoError(t, r.ParseForm())
+		require.Equal(t, "urn:ietf:params:oauth:grant-type:device_code", r.Form.Get("grant_type"))
+		w.Header().Set("content-type", "application/json")
+		if r.Form.Get("device_code") == "expired" {
+			w.WriteHeader(http.StatusBadRequest)
+			_, _ = w.Write([]byte(`{"error":"expired_token","error_description":"The device code has expired."}`))
+			return
+		}
+		require.Equal(t, "test-device-code", r.Form.Get("device_code"))
+		devicePolls++
+		if devicePolls == 1 {
+			w.WriteHeader(http.StatusBadRequest)
+			_, _ = w.Write([]byte(`{"error":"authorization_pending"}`))
+			return
+		}
+		_, _ = fmt.Fprintf(w, `{"access_token":%q,"token_type":"bearer","refresh_token":%q,"id_token":%q,"expires_in":60}`,
+			testToken.AccessToken.Token, testToken.RefreshToken.Token, testToken.IDToken.Token)
+	})
+
 	defaultDiscoveryResponse := func(req *http.Request) (*http.Response, error) { // nolint:unparam
 		// Call the handler function from the test server to calculate the response.
 		handler, _ := providerMux.Handler(req)
@@ -1433,6 +1490,56 @@ func TestLogin(t *testing.T) { // nolint:gocyclo
 			},
 			wantToken: &testExchangedToken,
 		},
+		{
+			name:     "device flow when the issuer does not support it",
+			issuer:   successServer.URL,
+			clientID: "test-client-id",
+			opt: func(t *testing.T) Option {
+				return WithDeviceFlow()
+			},
+			wantLogs: []string{"\"level\"=4 \"msg\"=\"Pinniped: Performing OIDC discovery\"  \"issuer\"=\"" + successServer.URL + "\""},
+			wantErr:  fmt.Sprintf("issuer %q does not support the device authorization grant", successServer.URL),
+		},
+		{
+			name:     "device flow when the device code expires",
+			issuer:   deviceSuccessServer.URL,
+			clientID: "test-client-id-expired",
+			opt: func(t *testing.T) Option {
+				return func(h *handlerState) error {
+					h.pollDelay = func(_ time.Duration) <-chan time.Time { return time.After(0) }
+					return WithDeviceFlow()(h)
+				}
+			},
+			wantLogs: []string{"\"level\"=4 \"msg\"=\"Pinniped: Performing OIDC discovery\"  \"issuer\"=\"" + deviceSuccessServer.URL + "\""},
+			wantErr:  `device authorization failed: login failed with code "expired_token": The device code has expired.`,
+		},
+		{
+			name:     "device flow success after polling",
+			issuer:   deviceSuccessServer.URL,
+			clientID: "test-client-id",
+			opt: func(t *testing.T) Option {
+				return func(h *handlerState) error {
+					var sawDelays []time.Duration
+					h.pollDelay = func(d time.Duration) <-chan time.Time {
+						sawDelays = append(sawDelays, d)
+						return time.After(0)
+					}
+					t.Cleanup(func() {
+						require.Equal(t, []time.Duration{5 * time.Second, 5 * time.Second}, sawDelays)
+					})
+					h.getProvider = func(_ *oauth2.Config, _ *oidc.Provider, _ *http.Client) provider.UpstreamOIDCIdentityProviderI {
+						mock := mockUpstream(t)
+						mock.EXPECT().
+							ValidateToken(gomock.Any(), HasAccessToken(testToken.AccessToken.Token), nonce.Nonce("")).
+							Return(&testToken, nil)
+						return mock
+					}
+					return WithDeviceFlow()(h)
+				}
+			},
+			wantLogs:  []string{"\"level\"=4 \"msg\"=\"Pinniped: Performing OIDC discovery\"  \"issuer\"=\"" + deviceSuccessServer.URL + "\""},
+			wantToken: &testToken,
+		},
 	}
 	for _, tt := range tests {
 		tt := tt
@@ -0,0 +1,86 @@
+// Copyright 2021 the Pinniped contributors. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package oidcclient
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/coreos/go-oidc/v3/oidc"
+	"github.com/go-logr/logr"
+)
+
+// RevokeRefreshToken revokes a refresh token at the token revocation endpoint of the issuer, as described in
+// https://datatracker.ietf.org/doc/html/rfc7009. A Pinniped Supervisor also revokes all of the other tokens of the
+// same session. Only the WithContext, WithLogger and WithClient options are used.
+func RevokeRefreshToken(issuer string, clientID string, refreshToken string, opts ...Option) error {
+	h := handlerState{
+		issuer:     issuer,
+		clientID:   clientID,
+		ctx:        context.Background(),
+		logger:     logr.Discard(), // discard logs unless a logger is specified
+		httpClient: http.DefaultClient,
+	}
+	for _, opt := range opts {
+		if err := opt(&h); err != nil {
+			return err
+		}
+	}
+
+	// Copy the configured HTTP client to set a request timeout (the Go default client has no timeout configured).
+	httpClientWithTimeout := *h.httpClient
+	httpClientWithTimeout.Timeout = httpRequestTimeout
+	h.httpClient = &httpClientWithTimeout
+
+	ctx, cancel := context.WithTimeout(h.ctx, httpRequestTimeout)
+	defer cancel()
+	h.ctx = oidc.ClientContext(ctx, h.httpClient)
+
+	if err := h.initOIDCDiscovery(); err != nil {
+		return err
+	}
+	if h.revocationURL == "" {
+		return fmt.Errorf("issuer %q does not support token revocation", h.issuer)
+	}
+
+	h.logger.V(debugLogLevel).Info("Pinniped: Revoking refresh token", "issuer", h.issuer, "revocationURL", h.revocationURL)
+	params := url.Values{
+		"token":           []string{refreshToken},
+		"token_type_hint": []string{"refresh_token"},
+		"client_id":       []string{h.clientID},
+	}
+	req, err := http.NewRequestWithContext(h.ctx, http.MethodPost, h.revocationURL, strings.NewReader(params.Encode()))
+	if err != nil {
+		return fmt.Errorf("could not build request: %w", err)
+	}
+	req.Header.Set("content-type", "application/x-www-form-urlencoded")
+
+	resp, err := h.httpClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("could not revoke refresh token: %w", err)
+	}
+	defer func() { _ = resp.Body.Close() }()
+
+	// The revocation endpoint responds with 200 OK even when the token was invalid or had already been revoked.
+	if resp.StatusCode == http.StatusOK {
+		return nil
+	}
+
+	// See https://datatracker.ietf.org/doc/html/rfc7009#section-2.2.1.
+	var errorResponse struct {
+		Error            string `json:"error"`
+		ErrorDescription string `json:"error_description"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&errorResponse); err != nil || errorResponse.Error == "" {
+		return fmt.Errorf("could not revoke refresh token: unexpected HTTP response status %d", resp.StatusCode)
+	}
+	if errorResponse.ErrorDescription == "" {
+		return fmt.Errorf("could not revoke refresh token: failed with code %q", errorResponse.Error)
+	}
+	return fmt.Errorf("could not revoke refresh token: failed with code %q: %s", errorResponse.Error, errorResponse.ErrorDescription)
+}
@@ -0,0 +1,146 @@
+// Copyright 2021 the Pinniped contributors. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package oidcclient
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestRevokeRefreshToken(t *testing.T) {
+	// Start a test server that returns 500 errors
+	errorServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "some discovery error", http.StatusInternalServerError)
+	}))
+	t.Cleanup(errorServer.Close)
+
+	newServer := func(t *testing.T, withRevocationEndpoint bool, revocationHandler http.HandlerFunc) *httptest.Server {
+		mux := http.NewServeMux()
+		server := httptest.NewServer(mux)
+		t.Cleanup(server.Close)
+		mux.HandleFunc("/.well-known/openid-configuration", func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("content-type", "application/json")
+			revocationURL := ""
+			if withRevocationEndpoint {
+				revocationURL = server.URL + "/revoke"
+			}
+			_ = json.NewEncoder(w).Encode(&struct {
+				Issuer        string `json:"issuer"`
+				AuthURL       string `json:"authorization_endpoint"`
+				TokenURL      string `json:"token_endpoint"`
+				JWKSURL       string `json:"jwks_uri"`
+				RevocationURL string `json:"revocation_endpoint,omitempty"`
+			}{
+				Issuer:        server.URL,
+				AuthURL:       server.URL + "/authorize",
+				TokenURL:      server.URL + "/token",
+				JWKSURL:       server.URL + "/keys",
+				RevocationURL: revocationURL,
+			})
+		})
+		mux.HandleFunc("/revoke", revocationHandler)
+		return server
+	}
+
+	tests := []struct {
+		name                   string
+		issuer                 func(t *testing.T) string
+		withRevocationEndpoint bool
+		revocationHandler      func(t *testing.T) http.HandlerFunc
+		wantErr                func(issuer string) string
+	}{
+		{
+			name:                   "success",
+			withRevocationEndpoint: true,
+			revocationHandler: func(t *testing.T) http.HandlerFunc {
+				return func(w http.ResponseWriter, r *http.Request) {
+					require.Equal(t, http.MethodPost, r.Method)
+					require.NoError(t, r.ParseForm())
+					require.Equal(t, "test-refresh-token", r.PostForm.Get("token"))
+					require.Equal(t, "refresh_token", r.PostForm.Get("token_type_hint"))
+					require.Equal(t, "test-client-id", r.PostForm.Get("client_id"))
+					w.WriteHeader(http.StatusOK)
+				}
+			},
+		},
+		{
+			name:   "discovery failure",
+			issuer: func(t *testing.T) string { return errorServer.URL },
+			wantErr: func(issuer string) string {
+				return `could not perform OIDC discovery for "` + issuer + `": 500 Internal Server Error: some discovery error` + "\n"
+			},
+		},
+		{
+			name:    "issuer without revocation endpoint",
+			wantErr: func(issuer string) string { return `issuer "` + issuer + `" does not support token revocation` },
+		},
+		{
+			name:                   "OAuth error response",
+			withRevocationEndpoint: true,
+			revocationHandler: func(t *testing.T) http.HandlerFunc {
+				return func(w http.ResponseWriter, r *http.Request) {
+					w.Header().Set("content-type", "application/json")
+					w.WriteHeader(http.StatusUnauthorized)
+					_, _ = w.Write([]byte(`{"error":"invalid_client","error_description":"some error description"}`))
+				}
+			},
+			wantErr: func(string) string {
+				return `could not revoke refresh token: failed with code "invalid_client": some error description`
+			},
+		},
+		{
+			name:                   "OAuth error response without description",
+			withRevocationEndpoint: true,
+			revocationHandler: func(t *testing.T) http.HandlerFunc {
+				return func(w http.ResponseWriter, r *http.Request) {
+					w.Header().Set("content-type", "application/json")
+					w.WriteHeader(http.StatusBadRequest)
+					_, _ = w.Write([]byte(`{"error":"invalid_request"}`))
+				}
+			},
+			wantErr: func(string) string { return `could not revoke refresh token: failed with code "invalid_request"` },
+		},
+		{
+			name:                   "unexpected error response",
+			withRevocationEndpoint: true,
+			revocationHandler: func(t *testing.T) http.HandlerFunc {
+				return func(w http.ResponseWriter, r *http.Request) {
+					http.Error(w, "some error", http.StatusServiceUnavailable)
+				}
+			},
+			wantErr: func(string) string { return "could not revoke refresh token: unexpected HTTP response status 503" },
+		},
+	}
+	for _, test := range tests {
+		test := test
+		t.Run(test.name, func(t *testing.T) {
+			revocationHandler := func(w http.ResponseWriter, r *http.Request) {
+				t.Errorf("unexpected revocation request")
+			}
+			if test.revocationHandler != nil {
+				revocationHandler = test.revocationHandler(t)
+			}
+			server := newServer(t, test.withRevocationEndpoint, revocationHandler)
+			issuer := server.URL
+			if test.issuer != nil {
+				issuer = test.issuer(t)
+			}
+
+			err := RevokeRefreshToken(issuer, "test-client-id", "test-refresh-token",
+				WithContext(context.Background()),
+				WithClient(server.Client()),
+			)
+			if test.wantErr != nil {
+				require.EqualError(t, err, test.wantErr(issuer))
+				return
+			}
+			require.NoError(t, err)
+		})
+	}
+}
@@ -475,7 +475,8 @@ func requireWellKnownEndpointIsWorking(t *testing.T, supervisorScheme, superviso
       "issuer": "%s",
       "authorization_endpoint": "%s/oauth2/authorize",
       "token_endpoint": "%s/oauth2/token",
-      "token_endpoint_auth_methods_supported": ["client_secret_basic"],
+      "grant_types_supported": ["authorization_code", "refresh_token", "urn:ietf:params:oauth:grant-type:token-exchange", "urn:ietf:params:oauth:grant-type:device_code", "password"],
+      "token_endpoint_auth_methods_supported": ["client_secret_basic", "none"],
       "jwks_uri": "%s/jwks.json",
       "scopes_supported": ["openid", "offline"],
       "response_types_supported": ["code"],
@@ -483,9 +484,15 @@ func requireWellKnownEndpointIsWorking(t *testing.T, supervisorScheme, superviso
       "claims_supported": ["groups"],
       "discovery.supervisor.pinniped.dev/v1alpha1": {"pinniped_identity_providers_endpoint": "%s/v1alpha1/pinniped_identity_providers"},
       "subject_types_supported": ["public"],
-      "id_token_signing_alg_values_supported": ["ES256"]
+      "id_token_signing_alg_values_supported": ["ES256"],
+      "device_authorization_endpoint": "%s/oauth2/device_authorization",
+      "revocation_endpoint": "%s/oauth2/revoke",
+      "revocation_endpoint_auth_methods_supported": ["client_secret_basic", "none"],
+      "introspection_endpoint": "%s/oauth2/introspect",
+      "introspection_endpoint_auth_methods_supported": ["client_secret_basic"],
+      "end_session_endpoint": "%s/oauth2/logout"
     }`)
-	expectedJSON := fmt.Sprintf(expectedResultTemplate, issuerName, issuerName, issuerName, issuerName, issuerName)
+	expectedJSON := fmt.Sprintf(expectedResultTemplate, issuerName, issuerName, issuerName, issuerName, issuerName, issuerName, issuerName, issuerName, issuerName)
 
 	require.Equal(t, "application/json", response.Header.Get("content-type"))
 	require.JSONEq(t, expectedJSON, responseBody)
@@ -40,6 +40,12 @@ func TestSupervisorUpstreamOIDCDiscovery(t *testing.T) {
 				Message: `failed to perform OIDC discovery against "https://127.0.0.1:444444/issuer":
 Get "https://127.0.0.1:444444/issuer/.well-known/openid-configuration": dial tcp: address 444444: in [truncated 10 chars]`,
 			},
+			{
+				Type:    "OfflineAccessRequested",
+				Status:  v1alpha1.ConditionUnknown,
+				Reason:  "OfflineAccessNotInScopes",
+				Message: offlineAccessNotInScopesMessage,
+			},
 		})
 	})
 
@@ -72,6 +78,12 @@ Get "https://127.0.0.1:444444/issuer/.well-known/openid-configuration": dial tcp
 				Message: `failed to perform OIDC discovery against "` + env.SupervisorUpstreamOIDC.Issuer + `/":
 oidc: issuer did not match the issuer returned by provider, expected "` + env.SupervisorUpstreamOIDC.Issuer + `/" got "` + env.SupervisorUpstreamOIDC.Issuer + `"`,
 			},
+			{
+				Type:    "OfflineAccessRequested",
+				Status:  v1alpha1.ConditionUnknown,
+				Reason:  "OfflineAccessNotInScopes",
+				Message: offlineAccessNotInScopesMessage,
+			},
 		})
 	})
 
@@ -103,10 +115,19 @@ oidc: issuer did not match the issuer returned by provider, expected "` + env.Su
 				Reason:  "Success",
 				Message: "discovered issuer configuration",
 			},
+			{
+				Type:    "OfflineAccessRequested",
+				Status:  v1alpha1.ConditionUnknown,
+				Reason:  "OfflineAccessNotInScopes",
+				Message: offlineAccessNotInScopesMessage,
+			},
 		})
 	})
 }
 
+const offlineAccessNotInScopesMessage = `additionalScopes does not include "offline_access", so the provider may not ` +
+	`return refresh tokens and the user may have to log in again whenever their downstream session is refreshed`
+
 func expectUpstreamConditions(t *testing.T, upstream *v1alpha1.OIDCIdentityProvider, expected []v1alpha1.Condition) {
 	t.Helper()
 	normalized := make([]v1alpha1.Condition, 0, len(upstream.Status.Conditions))